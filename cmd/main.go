@@ -1,22 +1,50 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"nuclei-distributed/pkg/api"
+	"nuclei-distributed/pkg/artifacts"
+	"nuclei-distributed/pkg/forward"
+	"nuclei-distributed/pkg/jira"
+	"nuclei-distributed/pkg/logging"
+	"nuclei-distributed/pkg/notify"
 	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/provider/aws"
+	"nuclei-distributed/pkg/provider/digitalocean"
+	"nuclei-distributed/pkg/provider/hetzner"
+	"nuclei-distributed/pkg/provider/kubernetes"
+	"nuclei-distributed/pkg/provider/static"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/tracing"
+	"nuclei-distributed/pkg/types"
+	"nuclei-distributed/pkg/version"
 )
 
 func main() {
-	log.Println("Starting Nuclei Distributed Scanner...")
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		createAPIKey()
+		return
+	}
+
+	setupLogging()
+	slog.Info("Starting Nuclei Distributed Scanner", "version", version.Version, "commit", version.Commit)
 
 	// Get configuration from environment
-	doToken := os.Getenv("DO_API_TOKEN")
-	if doToken == "" {
-		log.Fatal("DO_API_TOKEN environment variable is required")
-	}
+	computeProvider := newProvider()
 
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
@@ -27,7 +55,7 @@ func main() {
 	if mainServerIP == "" {
 		// Try to detect external IP or use localhost
 		mainServerIP = "localhost"
-		log.Printf("MAIN_SERVER_IP not set, using %s", mainServerIP)
+		slog.Warn("MAIN_SERVER_IP not set, using localhost")
 	}
 
 	port := os.Getenv("PORT")
@@ -35,34 +63,741 @@ func main() {
 		port = "8080"
 	}
 
+	// TLS from certificate files or Let's Encrypt, so workers and browsers do
+	// not send tokens and findings in cleartext
+	tlsConfig, err := loadTLSSettings()
+	if err != nil {
+		fatal("Invalid TLS settings", "error", err)
+	}
+	scheme, serverHost := "http", mainServerIP
+	if tlsConfig.enabled() {
+		scheme = "https"
+	}
+	if tlsConfig.Domain != "" {
+		serverHost = tlsConfig.Domain
+	}
+	serverURL := scheme + "://" + serverHost
+	if (scheme == "https" && port != "443") || (scheme == "http" && port != "80") {
+		serverURL = scheme + "://" + net.JoinHostPort(serverHost, port)
+	}
+
+	// Base URL workers call back on, and the key they pin it to when the
+	// certificate is self-signed
+	callbackURL := os.Getenv("CALLBACK_URL")
+	if callbackURL == "" {
+		callbackURL = serverURL
+	}
+	var pinnedPublicKey string
+	if envBool("TLS_PIN_CERT", false) {
+		if pinnedPublicKey, err = tlsConfig.pinnedPublicKey(); err != nil {
+			fatal("Failed to pin TLS certificate", "error", err)
+		}
+		slog.Info("Workers will pin the server certificate key", "key", pinnedPublicKey)
+	}
+
+	// API keys for the management endpoints, several at once so they can be
+	// rotated
+	apiKeys := api.ParseAPIKeys(os.Getenv("API_KEYS"))
+	if keysFile := os.Getenv("API_KEYS_FILE"); keysFile != "" {
+		fileKeys, err := api.ReadAPIKeysFile(keysFile)
+		if err != nil {
+			fatal("Failed to read API_KEYS_FILE", "error", err)
+		}
+		apiKeys = append(apiKeys, fileKeys...)
+	}
+	// Admin keys also reach the endpoints that act on the whole DigitalOcean
+	// account; without any those endpoints refuse every request
+	adminKeys := api.ParseAPIKeys(os.Getenv("ADMIN_API_KEYS"))
+	if len(apiKeys) == 0 && len(adminKeys) == 0 {
+		fatal("No API keys configured: set API_KEYS, or set API_KEYS_FILE and create a key with the apikey command")
+	}
+	slog.Info("Loaded API keys", "count", len(apiKeys), "admin", len(adminKeys))
+	enablePprof := envBool("ENABLE_PPROF", false)
+	if enablePprof {
+		if len(adminKeys) == 0 {
+			slog.Warn("ENABLE_PPROF is set but no ADMIN_API_KEYS are, so the debug endpoints refuse every request")
+		} else {
+			slog.Warn("Profiling endpoints are enabled under /debug for admin keys")
+		}
+	}
+
+	// Key for encrypting scan secrets at rest (base64, 32 bytes)
+	var secretsKey []byte
+	if encoded := os.Getenv("SECRETS_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			fatal("SECRETS_KEY must be 32 bytes encoded as base64")
+		}
+		secretsKey = key
+	}
+
+	// Defaults for nuclei host error handling
+	defaults := types.NucleiDefaults{
+		Timeout:       envInt("NUCLEI_TIMEOUT", 10),
+		Retries:       envInt("NUCLEI_RETRIES", 1),
+		MaxHostErrors: envInt("NUCLEI_MAX_HOST_ERRORS", 30),
+	}
+
+	// Fraction of a scan's targets that may be invalid before it is refused
+	maxInvalidTargets := orchestrator.DefaultMaxInvalidTargets
+	if value := os.Getenv("MAX_INVALID_TARGETS"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			fatal("MAX_INVALID_TARGETS must be a fraction between 0 and 1")
+		}
+		maxInvalidTargets = parsed
+	}
+
+	// Progress below which workers hand targets to idle ones, zero for
+	// manual rebalancing only
+	rebalanceThreshold := float64(orchestrator.DefaultRebalanceThreshold)
+	if value := os.Getenv("REBALANCE_THRESHOLD"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 100 {
+			fatal("REBALANCE_THRESHOLD must be a percentage between 0 and 100")
+		}
+		rebalanceThreshold = parsed
+	}
+
+	// Shares of failed workers and unscanned targets, in percent, past which
+	// a scan ends degraded or failed rather than completed; 0 disables one
+	failurePolicy := orchestrator.DefaultFailurePolicy
+	failureLimits := []struct {
+		name  string
+		limit *float64
+	}{
+		{"SCAN_DEGRADED_WORKERS_PERCENT", &failurePolicy.DegradedWorkers},
+		{"SCAN_FAILED_WORKERS_PERCENT", &failurePolicy.FailedWorkers},
+		{"SCAN_DEGRADED_TARGETS_PERCENT", &failurePolicy.DegradedTargets},
+		{"SCAN_FAILED_TARGETS_PERCENT", &failurePolicy.FailedTargets},
+	}
+	for _, setting := range failureLimits {
+		if value := os.Getenv(setting.name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 100 {
+				fatal(setting.name + " must be a percentage between 0 and 100")
+			}
+			*setting.limit = parsed
+		}
+	}
+
+	// Targets per droplet and per hour of droplet sizes, over the defaults
+	dropletCapacities, err := orchestrator.ParseDropletCapacities(os.Getenv("DROPLET_CAPACITY"))
+	if err != nil {
+		fatal("DROPLET_CAPACITY is invalid", "error", err)
+	}
+
+	// Worker droplets each region may run, in the order scans fall back to them
+	regionLimits, err := orchestrator.ParseRegionLimits(os.Getenv("REGION_LIMITS"))
+	if err != nil {
+		fatal("REGION_LIMITS is invalid", "error", err)
+	}
+
+	// Cloud firewall in front of each scan's workers, admitting SSH from
+	// the admin networks only
+	firewall := orchestrator.FirewallConfig{Enabled: envBool("WORKER_FIREWALL", true)}
+	for _, cidr := range strings.Split(os.Getenv("FIREWALL_ADMIN_CIDRS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			fatal("FIREWALL_ADMIN_CIDRS is invalid", "error", err)
+		}
+		firewall.AdminCIDRs = append(firewall.AdminCIDRs, cidr)
+	}
+
+	// Workers kept running between scans for scans to claim; the droplet
+	// config defaults to that of scans
+	warmPool := orchestrator.WarmPoolConfig{
+		Size:    envInt("WARM_POOL_SIZE", 0),
+		IdleTTL: envDuration("WARM_POOL_IDLE_TTL", orchestrator.DefaultPoolIdleTTL),
+		MaxAge:  envDuration("WARM_POOL_MAX_AGE", orchestrator.DefaultPoolMaxAge),
+		Droplet: types.DropletConfig{
+			Region: os.Getenv("WARM_POOL_REGION"),
+			Size:   os.Getenv("WARM_POOL_DROPLET_SIZE"),
+			Image:  os.Getenv("WARM_POOL_IMAGE"),
+		},
+	}
+	if warmPool.Size < 0 {
+		fatal("WARM_POOL_SIZE must not be negative")
+	}
+
+	// Memory and disk limits past which workers are reported degraded
+	degraded := orchestrator.DegradedThresholds{
+		MemoryPercent: envInt("WORKER_DEGRADED_MEMORY_PERCENT", orchestrator.DefaultDegradedMemoryPercent),
+		DiskFreeMB:    envInt("WORKER_DEGRADED_DISK_FREE_MB", orchestrator.DefaultDegradedDiskFreeMB),
+	}
+	if degraded.MemoryPercent <= 0 || degraded.MemoryPercent > 100 {
+		fatal("WORKER_DEGRADED_MEMORY_PERCENT must be a percentage between 1 and 100")
+	}
+	if degraded.DiskFreeMB <= 0 {
+		fatal("WORKER_DEGRADED_DISK_FREE_MB must be positive")
+	}
+
+	// What happens when the startup self-check fails: strict refuses to
+	// start, readonly refuses new scans until a check passes, warn only logs
+	selfCheckMode := os.Getenv("SELFCHECK_MODE")
+	if selfCheckMode == "" {
+		selfCheckMode = orchestrator.SelfCheckReadOnly
+	}
+
+	// When operators are alerted about failures of the server, through the
+	// notifiers below; ALERT_SINKS narrows them to webhook, slack or discord.
+	// Unset settings use the defaults.
+	alerts := types.AlertSettings{
+		Cooldown:            os.Getenv("ALERT_COOLDOWN"),
+		CreateFailures:      envInt("ALERT_CREATE_FAILURES", orchestrator.DefaultAlertCreateFailures),
+		AuthFailures:        envInt("ALERT_AUTH_FAILURES", orchestrator.DefaultAlertAuthFailures),
+		AuthWindow:          os.Getenv("ALERT_AUTH_WINDOW"),
+		ProvisioningTimeout: os.Getenv("ALERT_PROVISIONING_TIMEOUT"),
+	}
+	for _, sink := range strings.Split(os.Getenv("ALERT_SINKS"), ",") {
+		if sink = strings.TrimSpace(sink); sink != "" {
+			alerts.Sinks = append(alerts.Sinks, sink)
+		}
+	}
+
+	// How scans started with preflight check their targets before droplets
+	// are created: a probe of none, tcp or http after DNS. Unset settings
+	// use the defaults.
+	preflight := types.PreflightSettings{
+		Concurrency:    envInt("PREFLIGHT_CONCURRENCY", orchestrator.DefaultPreflightConcurrency),
+		ResolveTimeout: os.Getenv("PREFLIGHT_RESOLVE_TIMEOUT"),
+		Probe:          os.Getenv("PREFLIGHT_PROBE"),
+		ProbeTimeout:   os.Getenv("PREFLIGHT_PROBE_TIMEOUT"),
+		SkipIPOnly:     envBool("PREFLIGHT_SKIP_IP_ONLY", true),
+	}
+
+	// How scans started with expand enumerate the subdomains of their apex
+	// domains. Unset settings use the defaults.
+	enumeration := types.EnumerationSettings{
+		MaxTargets:  envInt("ENUMERATION_MAX_TARGETS", orchestrator.DefaultEnumerationMaxTargets),
+		ApexTimeout: os.Getenv("ENUMERATION_APEX_TIMEOUT"),
+		Concurrency: envInt("ENUMERATION_CONCURRENCY", orchestrator.DefaultEnumerationConcurrency),
+	}
+
+	// Targets that are never scanned, in addition to those added at runtime
+	var blocklist []string
+	for _, pattern := range strings.Split(os.Getenv("BLOCKLIST"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			blocklist = append(blocklist, pattern)
+		}
+	}
+
+	// Default webhook for scans that do not configure their own
+	var webhooks []types.WebhookConfig
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		webhook := types.WebhookConfig{
+			URL:         webhookURL,
+			Secret:      os.Getenv("WEBHOOK_SECRET"),
+			MinSeverity: os.Getenv("WEBHOOK_MIN_SEVERITY"),
+		}
+		if events := os.Getenv("WEBHOOK_EVENTS"); events != "" {
+			for _, event := range strings.Split(events, ",") {
+				webhook.Events = append(webhook.Events, strings.TrimSpace(event))
+			}
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	// Mail server completion reports are sent through, and the recipients
+	// of scans that do not list their own
+	smtpConfig := notify.SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     envInt("SMTP_PORT", 587),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if smtpConfig.Host != "" && smtpConfig.From == "" {
+		fatal("SMTP_FROM is required when SMTP_HOST is set")
+	}
+	var emails []string
+	if recipients := os.Getenv("NOTIFY_EMAILS"); recipients != "" {
+		for _, recipient := range strings.Split(recipients, ",") {
+			emails = append(emails, strings.TrimSpace(recipient))
+		}
+		if err := notify.ValidateEmails(emails); err != nil {
+			fatal("Invalid NOTIFY_EMAILS", "error", err)
+		}
+	}
+
+	// Base URL used in links sent to Slack, Discord and email
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = serverURL
+	}
+
+	// Where scan records and findings are stored: redis, disk for Redis
+	// without persistence, or postgres
+	resultsDir := os.Getenv("RESULTS_DIR")
+	if resultsDir == "" {
+		resultsDir = "./data/results"
+	}
+
+	// Optional S3-compatible bucket completed scans are archived to
+	artifactConfig := artifacts.Config{
+		Endpoint:  os.Getenv("ARTIFACTS_ENDPOINT"),
+		Region:    os.Getenv("ARTIFACTS_REGION"),
+		Bucket:    os.Getenv("ARTIFACTS_BUCKET"),
+		AccessKey: os.Getenv("ARTIFACTS_ACCESS_KEY"),
+		SecretKey: os.Getenv("ARTIFACTS_SECRET_KEY"),
+	}
+	if expiry := os.Getenv("ARTIFACTS_LINK_EXPIRY"); expiry != "" {
+		d, err := time.ParseDuration(expiry)
+		if err != nil {
+			fatal("Invalid ARTIFACTS_LINK_EXPIRY", "error", err)
+		}
+		artifactConfig.LinkExpiry = d
+	}
+
+	// Optional OpenSearch cluster findings are forwarded to
+	openSearchConfig := forward.OpenSearchConfig{
+		URL:                os.Getenv("OPENSEARCH_URL"),
+		IndexPrefix:        os.Getenv("OPENSEARCH_INDEX_PREFIX"),
+		Username:           os.Getenv("OPENSEARCH_USERNAME"),
+		Password:           os.Getenv("OPENSEARCH_PASSWORD"),
+		InsecureSkipVerify: envBool("OPENSEARCH_INSECURE_SKIP_VERIFY", false),
+	}
+
+	// Status long-polls are answered unchanged after this long
+	longPollTimeout := api.DefaultLongPollTimeout
+	if value := os.Getenv("LONG_POLL_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			fatal("Invalid LONG_POLL_TIMEOUT", "value", value)
+		}
+		longPollTimeout = d
+	}
+
+	// Completed scans older than this are purged; unset keeps them forever
+	retention := os.Getenv("RESULT_RETENTION")
+	if retention != "" {
+		if d, err := time.ParseDuration(retention); err != nil || d <= 0 {
+			fatal("Invalid RESULT_RETENTION", "value", retention)
+		}
+	}
+
+	// Audit entries older than this are purged, 90 days unless set; 0 keeps
+	// them forever
+	auditRetention := os.Getenv("AUDIT_RETENTION")
+	switch auditRetention {
+	case "":
+		auditRetention = "2160h"
+	case "0":
+		auditRetention = ""
+	default:
+		if d, err := time.ParseDuration(auditRetention); err != nil || d <= 0 {
+			fatal("Invalid AUDIT_RETENTION", "value", auditRetention)
+		}
+	}
+
+	// Settings that can also be changed at runtime under /api/admin/config,
+	// optionally overridden by a YAML file
+	settings := types.ServerConfig{
+		Nuclei: defaults,
+		Optimizer: types.OptimizerLimits{
+			MinDroplets:          envInt("OPTIMIZER_MIN_DROPLETS", orchestrator.DefaultOptimizerLimits.MinDroplets),
+			MaxDroplets:          envInt("OPTIMIZER_MAX_DROPLETS", orchestrator.DefaultOptimizerLimits.MaxDroplets),
+			MinDomainsPerDroplet: envInt("OPTIMIZER_MIN_DOMAINS_PER_DROPLET", orchestrator.DefaultOptimizerLimits.MinDomainsPerDroplet),
+			MaxDomainsPerDroplet: envInt("OPTIMIZER_MAX_DOMAINS_PER_DROPLET", orchestrator.DefaultOptimizerLimits.MaxDomainsPerDroplet),
+		},
+		RebalanceThreshold: rebalanceThreshold,
+		MaxInvalidTargets:  maxInvalidTargets,
+		Retention:          retention,
+		AuditRetention:     auditRetention,
+		Blocklist:          blocklist,
+		Notifications: types.NotificationSettings{
+			Webhooks: webhooks,
+			Slack: types.SlackConfig{
+				WebhookURL:  os.Getenv("SLACK_WEBHOOK_URL"),
+				MinSeverity: os.Getenv("SLACK_MIN_SEVERITY"),
+			},
+			Discord: types.DiscordConfig{
+				WebhookURL:  os.Getenv("DISCORD_WEBHOOK_URL"),
+				MinSeverity: os.Getenv("DISCORD_MIN_SEVERITY"),
+			},
+			Emails:    emails,
+			PublicURL: publicURL,
+		},
+		Alerts:        alerts,
+		FailurePolicy: failurePolicy,
+		Preflight:     preflight,
+		Enumeration:   enumeration,
+	}
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		settings, err = orchestrator.LoadConfigFile(configFile, settings)
+		if err != nil {
+			fatal("Failed to read CONFIG_FILE", "error", err)
+		}
+		slog.Info("Loaded settings from CONFIG_FILE", "file", configFile)
+	}
+
+	// Optional Jira project findings are filed in
+	jiraConfig := jira.Config{
+		URL:          os.Getenv("JIRA_URL"),
+		Project:      os.Getenv("JIRA_PROJECT"),
+		Email:        os.Getenv("JIRA_EMAIL"),
+		Token:        os.Getenv("JIRA_API_TOKEN"),
+		IssueType:    os.Getenv("JIRA_ISSUE_TYPE"),
+		AutoSeverity: strings.ToLower(os.Getenv("JIRA_AUTO_SEVERITY")),
+	}
+	if jiraConfig.AutoSeverity != "" && !notify.ValidSeverity(jiraConfig.AutoSeverity) {
+		fatal("Invalid JIRA_AUTO_SEVERITY", "value", jiraConfig.AutoSeverity)
+	}
+
+	// Optional OTLP collector scans and API requests are traced to; without
+	// one tracing is a no-op
+	tracingEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    tracingEndpoint,
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+	})
+	if err != nil {
+		fatal("Invalid tracing settings", "error", err)
+	}
+	if tracingEndpoint != "" {
+		slog.Info("Tracing enabled", "endpoint", tracingEndpoint)
+	}
+
+	// Optional replicas sharing Redis behind a load balancer. Each is reached
+	// by the others on its REPLICA_URL, and they need storage they share.
+	replica := orchestrator.ReplicaConfig{
+		ID:  os.Getenv("REPLICA_ID"),
+		URL: strings.TrimSuffix(os.Getenv("REPLICA_URL"), "/"),
+	}
+	if replica.URL != "" {
+		if u, err := url.Parse(replica.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("Invalid REPLICA_URL", "value", replica.URL)
+		}
+		if os.Getenv("STORAGE_BACKEND") == "disk" {
+			fatal("Replicas need the redis or postgres storage backend")
+		}
+	}
+
+	// Workers post results, heartbeats and completion back over HTTP by
+	// default, or push them onto Redis streams the server reads
+	workerRedisURL := ""
+	switch transport := os.Getenv("WORKER_TRANSPORT"); transport {
+	case "", "http":
+	case "redis":
+		workerRedisURL = os.Getenv("WORKER_REDIS_URL")
+		if workerRedisURL == "" {
+			fatal("WORKER_TRANSPORT=redis needs WORKER_REDIS_URL")
+		}
+	default:
+		fatal("WORKER_TRANSPORT must be http or redis", "value", transport)
+	}
+
 	// Initialize orchestrator
-	orch := orchestrator.New(doToken, redisURL, mainServerIP)
-	log.Println("Orchestrator initialized")
+	orch, err := orchestrator.New(orchestrator.Config{
+		Provider:     computeProvider,
+		RedisURL:     redisURL,
+		SecretsKey:   secretsKey,
+		Settings:     settings,
 
-	// Setup Gin router
-	r := gin.Default()
-
-	// Add CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		
-		c.Next()
+		CallbackURL:     callbackURL,
+		PinnedPublicKey: pinnedPublicKey,
+		SMTP:            smtpConfig,
+		Storage: storage.Config{
+			Backend:     os.Getenv("STORAGE_BACKEND"),
+			Dir:         resultsDir,
+			DatabaseURL: os.Getenv("DATABASE_URL"),
+		},
+		Artifacts:  artifactConfig,
+		OpenSearch: openSearchConfig,
+		Jira:       jiraConfig,
+
+		DropletCapacities:       dropletCapacities,
+		RegionLimits:            regionLimits,
+		Firewall:                firewall,
+		WarmPool:                warmPool,
+		Degraded:                degraded,
+		SelfCheckMode:           selfCheckMode,
+		Replica:                 replica,
+		WorkerRedisURL:          workerRedisURL,
+		SubfinderProviderConfig: os.Getenv("SUBFINDER_PROVIDER_CONFIG"),
 	})
+	if err != nil {
+		fatal("Failed to initialize orchestrator", "error", err)
+	}
+	slog.Info("Orchestrator initialized")
+
+	// Records about a scan also go to the scan's own log
+	slog.SetDefault(slog.New(orch.ScanLogHandler(slog.Default().Handler())))
+
+	// Credentials and settings are checked before the first scan needs them
+	selfCheck := orch.SelfCheck(context.Background())
+	if selfCheck.Status == types.CheckFailed && selfCheckMode == orchestrator.SelfCheckStrict {
+		fatal("Self-check failed, refusing to start", "checks", selfCheck.Checks)
+	}
+	for _, check := range selfCheck.Checks {
+		if check.Name == "callback_url" && check.Status != types.CheckPassed {
+			slog.Warn("Workers may not reach the server; set MAIN_SERVER_IP to its external IP or CALLBACK_URL",
+				"callback_url", callbackURL, "problem", check.Message)
+		}
+	}
+
+	// Setup Gin router
+	// Requests are logged by the API's own middleware, with their IDs
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// Only proxies in front of the server may set the client IP that scans
+	// are rate limited by; others could pick a fresh IP for every request
+	trustedProxies := []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+	if value, set := os.LookupEnv("TRUSTED_PROXIES"); set {
+		trustedProxies = nil
+		for _, proxy := range strings.Split(value, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				trustedProxies = append(trustedProxies, proxy)
+			}
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	// Browser origins other than the server's own that may call the API
+	var allowedOrigins []string
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
+		}
+	}
+	if err := api.CheckOrigins(allowedOrigins); err != nil {
+		fatal("Invalid ALLOWED_ORIGINS", "error", err)
+	}
+	for _, origin := range allowedOrigins {
+		if origin == api.AnyOrigin {
+			slog.Warn("ALLOWED_ORIGINS=* lets any website call the API; use it for development only")
+		}
+	}
 
 	// Setup routes
-	api.SetupRoutes(r, orch)
+	api.SetupRoutes(r, orch, api.Config{
+		APIKeys:           apiKeys,
+		AdminAPIKeys:      adminKeys,
+		MaxUploadBytes:    int64(envInt("MAX_UPLOAD_BYTES", api.DefaultMaxUploadBytes)),
+		MaxBodyBytes:      int64(envInt("MAX_BODY_BYTES", api.DefaultMaxBodyBytes)),
+		MaxTargets:        envInt("MAX_TARGETS", api.DefaultMaxTargets),
+		MaxDroplets:       envInt("MAX_DROPLETS", api.DefaultMaxDroplets),
+		ScanRateLimit:     float64(envInt("SCAN_RATE_LIMIT", api.DefaultScanRateLimit)),
+		ScanBurst:         envInt("SCAN_RATE_BURST", api.DefaultScanBurst),
+		AllowedOrigins:    allowedOrigins,
+		EnablePprof:       enablePprof,
+		LongPollTimeout:   longPollTimeout,
+		MaxClientsPerScan: envInt("WS_MAX_CLIENTS_PER_SCAN", api.DefaultMaxClientsPerScan),
+		Tracing:           tracingEndpoint != "",
+	})
 
-	log.Printf("Server starting on port %s", port)
-	log.Printf("Access the UI at: http://localhost:%s", port)
+	slog.Info("Server starting", "port", port, "url", serverURL)
 	
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	if err := serve(r, port, tlsConfig); err != nil {
+		// Spans still buffered are sent before exiting
+		shutdownTracing(context.Background())
+		fatal("Failed to start server", "error", err)
+	}
+}
+
+// setupLogging sends JSON logs at LOG_LEVEL (debug, info, warn or error) to
+// stderr and, with LOG_FILE set, to that file too, rotated once it reaches
+// LOG_MAX_SIZE_MB. Output of the standard logger goes through the same
+// handler.
+func setupLogging() {
+	var level slog.Level
+	value := os.Getenv("LOG_LEVEL")
+	invalid := value != "" && level.UnmarshalText([]byte(value)) != nil
+	if invalid {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if invalid {
+		slog.Warn("Invalid LOG_LEVEL, using info", "value", value)
+	}
+
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		file, err := logging.OpenRotatingFile(logging.RotateConfig{
+			Path:       path,
+			MaxSizeMB:  envInt("LOG_MAX_SIZE_MB", logging.DefaultMaxSizeMB),
+			MaxBackups: envInt("LOG_MAX_BACKUPS", logging.DefaultMaxBackups),
+			MaxAge:     envDuration("LOG_MAX_AGE", 0),
+		})
+		if err != nil {
+			fatal("Invalid LOG_FILE", "error", err)
+		}
+		output := io.MultiWriter(os.Stderr, file)
+		slog.SetDefault(slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level})))
+	}
+}
+
+// fatal logs an error that keeps the server from starting and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// createAPIKey generates an API key and prints it. With API_KEYS_FILE set the
+// key is also added to that file, and the server accepts it once restarted.
+func createAPIKey() {
+	key, err := api.GenerateAPIKey()
+	if err != nil {
+		log.Fatal("Failed to generate API key: ", err)
+	}
+
+	if keysFile := os.Getenv("API_KEYS_FILE"); keysFile != "" {
+		if err := api.AppendAPIKey(keysFile, key); err != nil {
+			log.Fatal("Failed to write API key: ", err)
+		}
+		log.Printf("Added API key to %s, restart the server to use it", keysFile)
+	}
+	// The ID names the key in the audit log
+	log.Printf("Key ID: %s", api.KeyID(key))
+
+	fmt.Println(key)
+}
+
+// envInt reads an integer environment variable, falling back to def when it
+// is unset or invalid
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer setting, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return parsed
+}
+
+// envDuration reads a duration environment variable such as 30m, falling
+// back to def when it is unset or not a positive duration
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		slog.Warn("Invalid duration setting, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return parsed
+}
+
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean setting, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return parsed
+}
+
+// newProvider sets up the compute backend PROVIDER selects, digitalocean by
+// default
+func newProvider() provider.Provider {
+	switch name := os.Getenv("PROVIDER"); name {
+	case "", "digitalocean":
+		doToken := os.Getenv("DO_API_TOKEN")
+		if doToken == "" {
+			fatal("DO_API_TOKEN environment variable is required")
+		}
+		var extraTags []string
+		for _, tag := range strings.Split(os.Getenv("DO_EXTRA_TAGS"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				extraTags = append(extraTags, tag)
+			}
+		}
+		droplets, err := digitalocean.New(digitalocean.Config{
+			Token:     doToken,
+			ProjectID: os.Getenv("DO_PROJECT_ID"),
+			Tags:      extraTags,
+		})
+		if err != nil {
+			fatal("Invalid DigitalOcean settings", "error", err)
+		}
+		return droplets
+	case "aws":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		var securityGroups []string
+		for _, group := range strings.Split(os.Getenv("AWS_SECURITY_GROUP_IDS"), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				securityGroups = append(securityGroups, group)
+			}
+		}
+		ec2, err := aws.New(aws.Config{
+			Region:           region,
+			AMI:              os.Getenv("AWS_AMI_ID"),
+			InstanceType:     os.Getenv("AWS_INSTANCE_TYPE"),
+			SubnetID:         os.Getenv("AWS_SUBNET_ID"),
+			SecurityGroupIDs: securityGroups,
+			Spot:             envBool("AWS_SPOT", false),
+		})
+		if err != nil {
+			fatal("Invalid AWS settings", "error", err)
+		}
+		return ec2
+	case "hetzner":
+		serverTypes, err := hetzner.ParseServerTypes(os.Getenv("HETZNER_SERVER_TYPES"))
+		if err != nil {
+			fatal("HETZNER_SERVER_TYPES is invalid", "error", err)
+		}
+		hcloud, err := hetzner.New(hetzner.Config{
+			Token:       os.Getenv("HCLOUD_TOKEN"),
+			Location:    os.Getenv("HETZNER_LOCATION"),
+			Image:       os.Getenv("HETZNER_IMAGE"),
+			ServerTypes: serverTypes,
+		})
+		if err != nil {
+			fatal("Invalid Hetzner settings", "error", err)
+		}
+		return hcloud
+	case "kubernetes":
+		jobs, err := kubernetes.New(kubernetes.Config{
+			Kubeconfig:    os.Getenv("KUBECONFIG"),
+			Namespace:     os.Getenv("K8S_NAMESPACE"),
+			Image:         os.Getenv("K8S_WORKER_IMAGE"),
+			CPURequest:    os.Getenv("K8S_CPU_REQUEST"),
+			MemoryRequest: os.Getenv("K8S_MEMORY_REQUEST"),
+			CPULimit:      os.Getenv("K8S_CPU_LIMIT"),
+			MemoryLimit:   os.Getenv("K8S_MEMORY_LIMIT"),
+		})
+		if err != nil {
+			fatal("Invalid Kubernetes settings", "error", err)
+		}
+		return jobs
+	case "static":
+		hostsFile := os.Getenv("STATIC_HOSTS_FILE")
+		if hostsFile == "" {
+			fatal("STATIC_HOSTS_FILE environment variable is required")
+		}
+		config, err := static.LoadConfig(hostsFile)
+		if err != nil {
+			fatal("Failed to read STATIC_HOSTS_FILE", "error", err)
+		}
+		pool, err := static.New(config)
+		if err != nil {
+			fatal("Invalid static host pool", "error", err)
+		}
+		return pool
+	default:
+		fatal("PROVIDER must be digitalocean, aws, hetzner, kubernetes or static", "value", name)
+		return nil
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSettings selects how the server terminates TLS: certificate files,
+// certificates from Let's Encrypt for Domain, or neither for plain HTTP
+type tlsSettings struct {
+	CertFile string
+	KeyFile  string
+	Domain   string
+	Email    string
+	CacheDir string
+	// RedirectPort serves redirects to HTTPS and ACME challenges; empty
+	// disables the listener
+	RedirectPort string
+}
+
+func loadTLSSettings() (tlsSettings, error) {
+	settings := tlsSettings{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		Domain:       os.Getenv("TLS_DOMAIN"),
+		Email:        os.Getenv("ACME_EMAIL"),
+		CacheDir:     os.Getenv("ACME_CACHE_DIR"),
+		RedirectPort: os.Getenv("HTTP_REDIRECT_PORT"),
+	}
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return settings, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if settings.CertFile != "" && settings.Domain != "" {
+		return settings, errors.New("set either TLS_CERT_FILE and TLS_KEY_FILE or TLS_DOMAIN, not both")
+	}
+	if settings.CacheDir == "" {
+		settings.CacheDir = "./data/acme"
+	}
+	switch settings.RedirectPort {
+	case "":
+		settings.RedirectPort = "80"
+	case "off":
+		settings.RedirectPort = ""
+	}
+	return settings, nil
+}
+
+func (s tlsSettings) enabled() bool {
+	return s.CertFile != "" || s.Domain != ""
+}
+
+// pinnedPublicKey returns the curl --pinnedpubkey value of the certificate
+// file's public key, so workers can trust a self-signed certificate
+func (s tlsSettings) pinnedPublicKey() (string, error) {
+	if s.CertFile == "" {
+		return "", errors.New("pinning needs TLS_CERT_FILE; Let's Encrypt certificates rotate their keys")
+	}
+	data, err := os.ReadFile(s.CertFile)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s does not start with a PEM certificate", s.CertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// serve runs handler on port, over TLS when configured. With TLS the
+// redirect listener sends plain HTTP requests to the HTTPS port, and answers
+// Let's Encrypt's challenges when certificates come from there.
+func serve(handler http.Handler, port string, settings tlsSettings) error {
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !settings.enabled() {
+		return server.ListenAndServe()
+	}
+
+	redirect := redirectToHTTPS(port)
+	if settings.Domain != "" {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(settings.Domain),
+			Cache:      autocert.DirCache(settings.CacheDir),
+			Email:      settings.Email,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if settings.RedirectPort != "" {
+		go func() {
+			redirectServer := &http.Server{
+				Addr:              ":" + settings.RedirectPort,
+				Handler:           redirect,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			if err := redirectServer.ListenAndServe(); err != nil {
+				slog.Error("HTTP redirect listener stopped", "error", err)
+			}
+		}()
+	}
+	return server.ListenAndServeTLS(settings.CertFile, settings.KeyFile)
+}
+
+// redirectToHTTPS sends requests to the same host and path on the HTTPS port
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
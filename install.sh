@@ -375,6 +375,8 @@ WORKER_TIMEOUT=3600
 
 # Security Settings
 SESSION_SECRET=$(openssl rand -base64 32)
+# API keys for the management API and UI, comma-separated
+API_KEYS=$(openssl rand -hex 32)
 
 # Optional: Custom Nuclei Settings
 NUCLEI_RATE_LIMIT=10
@@ -547,6 +549,7 @@ final_setup() {
     echo "║     nuclei-restart  - Restart services                                      ║"
     echo "║                                                                              ║"
     echo "║  📝 Logs: $LOG_FILE                               ║"
+    echo "║  🔑 API key: API_KEYS in $INSTALL_DIR/.env                    ║"
     echo "║                                                                              ║"
     echo "╚══════════════════════════════════════════════════════════════════════════════╝"
     echo -e "${NC}"
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/provider/fake"
+)
+
+// Keys the test server accepts
+const (
+	testAPIKey   = "test-key"
+	testAdminKey = "test-admin-key"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer returns the API's routes on an orchestrator using the fake
+// provider and a Redis of its own
+func newTestServer(t *testing.T, config Config) (*gin.Engine, *orchestrator.Orchestrator) {
+	t.Helper()
+	r, orch, _ := newFakeServer(t, config)
+	return r, orch
+}
+
+// newFakeServer is newTestServer that also returns the fake provider, to see
+// the workers scans start
+func newFakeServer(t *testing.T, config Config) (*gin.Engine, *orchestrator.Orchestrator, *fake.Provider) {
+	t.Helper()
+	workers := fake.New()
+	orch, err := orchestrator.New(orchestrator.Config{
+		Provider:    workers,
+		RedisURL:    miniredis.RunT(t).Addr(),
+		CallbackURL: "https://scanner.example.com",
+	})
+	if err != nil {
+		t.Fatalf("orchestrator.New() error = %v", err)
+	}
+	config.APIKeys = append(config.APIKeys, testAPIKey)
+	config.AdminAPIKeys = append(config.AdminAPIKeys, testAdminKey)
+
+	r := gin.New()
+	SetupRoutes(r, orch, config)
+	return r, orch, workers
+}
+
+// serve sends a request with the test API key through r
+func serve(r http.Handler, method, path string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// serveJSON sends a JSON body with the test API key through r
+func serveJSON(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	return serve(r, method, path, strings.NewReader(body), nil)
+}
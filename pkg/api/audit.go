@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+// Context keys handlers set to add detail to their request's audit entry
+const (
+	auditScanIDKey  = "auditScanId"  // scan the request created
+	auditSummaryKey = "auditSummary" // what the request asked for
+	auditChangesKey = "auditChanges" // settings the request changed
+)
+
+// unaudited are the state-changing operations left out of the audit log,
+// because they change nothing anyone needs to answer for
+var unaudited = map[string]bool{
+	"createWebSocketTicket": true,
+}
+
+// Audit records every state-changing management and admin request in the
+// audit log once it has been answered, with the API key, client address,
+// operation and status. Worker callbacks never pass through it.
+func (h *Handler) Audit(c *gin.Context) {
+	switch c.Request.Method {
+	case "POST", "PUT", "PATCH", "DELETE":
+	default:
+		c.Next()
+		return
+	}
+	action := handlerName([]gin.HandlerFunc{c.Handler()})
+	if unaudited[action] {
+		c.Next()
+		return
+	}
+
+	c.Next()
+
+	entry := types.AuditEntry{
+		Time:     time.Now().UTC(),
+		KeyID:    c.GetString(keyIDKey),
+		SourceIP: c.ClientIP(),
+		Action:   action,
+		Request:  c.Request.Method + " " + c.Request.URL.Path,
+		Status:   c.Writer.Status(),
+		ScanID:   c.Param("scanId"),
+		Summary:  c.GetString(auditSummaryKey),
+	}
+	if scanID := c.GetString(auditScanIDKey); scanID != "" {
+		entry.ScanID = scanID
+	}
+	if changes, ok := c.Get(auditChangesKey); ok {
+		entry.Changes, _ = changes.([]types.ConfigChange)
+	}
+	h.orchestrator.RecordAudit(entry)
+}
+
+// auditSummary describes a request in its audit entry
+func auditSummary(c *gin.Context, format string, args ...interface{}) {
+	c.Set(auditSummaryKey, fmt.Sprintf(format, args...))
+}
+
+// AuditLog lists the changes made through the API, newest first, filtered
+// by action, API key and time
+func (h *Handler) AuditLog(c *gin.Context) {
+	filter := types.AuditFilter{Action: c.Query("action"), KeyID: c.Query("actor")}
+	bounds := []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}}
+	for _, bound := range bounds {
+		if raw := c.Query(bound.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				respondError(c, 400, CodeInvalidRequest, bound.name+" must be an RFC 3339 time")
+				return
+			}
+			*bound.value = parsed
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			respondError(c, 400, CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := h.orchestrator.AuditLog(filter)
+	if err != nil {
+		orchestratorError(c, err, "Failed to load audit log")
+		return
+	}
+	c.JSON(200, gin.H{"entries": entries})
+}
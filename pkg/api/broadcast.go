@@ -0,0 +1,566 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// replayBuffer is the number of recent broadcasts kept per scan for
+	// clients that reconnect
+	replayBuffer = 128
+	// replayIdle is how long the broadcasts of a scan without clients are
+	// kept after the last one
+	replayIdle = time.Hour
+	// sendBuffer is the number of messages queued for a client before it
+	// counts as behind and messages are dropped. A full replay has to fit.
+	sendBuffer = replayBuffer + 64
+	// slowClientGrace is how long a client may stay behind before it is
+	// disconnected
+	slowClientGrace = 30 * time.Second
+	// DefaultMaxClientsPerScan is the number of WebSockets and event streams
+	// a scan may have open at once unless configured otherwise
+	DefaultMaxClientsPerScan = 50
+)
+
+// broadcastTypes are the message types broadcast to a scan's clients, which a
+// subscribe message can choose from
+var broadcastTypes = map[string]bool{
+	"status_update": true,
+	"results_batch": true,
+	"worker_log":    true,
+	"scan_complete": true,
+
+	types.WorkerProvisioning: true,
+	types.WorkerReady:        true,
+	types.WorkerProgress:     true,
+	types.WorkerStalled:      true,
+	types.WorkerDegraded:     true,
+	types.WorkerFailed:       true,
+	types.WorkerCompleted:    true,
+	types.WorkerDestroyed:    true,
+	types.TargetsRebalanced:  true,
+}
+
+// eventFilter is what a client subscribed to. The zero value lets everything
+// through.
+type eventFilter struct {
+	events      map[string]bool // nil for every event
+	minSeverity string
+}
+
+// newEventFilter validates a subscription
+func newEventFilter(sub types.WebSocketSubscription) (eventFilter, error) {
+	var filter eventFilter
+	if len(sub.Events) > 0 {
+		filter.events = make(map[string]bool, len(sub.Events))
+		for _, event := range sub.Events {
+			if !broadcastTypes[event] {
+				return eventFilter{}, fmt.Errorf("unknown event %q", event)
+			}
+			filter.events[event] = true
+		}
+	}
+	if sub.MinSeverity != "" && !notify.ValidSeverity(sub.MinSeverity) {
+		return eventFilter{}, fmt.Errorf("unknown severity %q", sub.MinSeverity)
+	}
+	filter.minSeverity = sub.MinSeverity
+	return filter, nil
+}
+
+// apply returns the part of a broadcast message that passes the filter: a
+// results batch keeps only the findings at or above the minimum severity.
+// It returns false when nothing is left.
+func (f eventFilter) apply(message types.WebSocketMessage) (types.WebSocketMessage, bool) {
+	if f.events != nil && !f.events[message.Type] {
+		return message, false
+	}
+	batch, ok := message.Data.(*types.ResultsBatch)
+	if !ok || f.minSeverity == "" {
+		return message, true
+	}
+	filtered := *batch
+	filtered.Results = nil
+	for _, result := range batch.Results {
+		if notify.MeetsSeverity(result.Severity, f.minSeverity) {
+			filtered.Results = append(filtered.Results, result)
+		}
+	}
+	message.Data = &filtered
+	return message, len(filtered.Results) > 0
+}
+
+// WebSocketManager fans each scan's broadcasts out to its subscribers,
+// WebSocket clients and event streams alike, and feeds the dashboard
+type WebSocketManager struct {
+	scans      map[string]*scanSubscribers // scanID -> connected clients
+	history    map[string]*scanHistory     // scanID -> recent broadcasts
+	dashboard  map[*subscriber]bool        // clients of every scan's lifecycle events
+	progress   map[string]*wsProgress      // scanID -> throttled dashboard progress
+	coalescing map[string]*scanCoalescer   // scanID -> batched results and held status
+	mutex      sync.RWMutex
+	upgrader   websocket.Upgrader
+
+	// status reads a scan's totals for results batches
+	status func(scanID string) (*types.ScanStatus, error)
+
+	// relay hands broadcasts to the other replicas' clients, an empty
+	// scanID for the dashboard; nil when the server runs alone
+	relay func(scanID, kind string, data interface{})
+
+	// maxClients is the number of clients a scan may have at once
+	maxClients int
+
+	// broadcastsDropped counts messages dropped because a scan's queue was
+	// full, clientDrops those dropped for clients that fell behind,
+	// slowClients the clients disconnected for falling behind and
+	// clientsRefused those turned away from a scan at maxClients
+	broadcastsDropped atomic.Int64
+	clientDrops       atomic.Int64
+	slowClients       atomic.Int64
+	clientsRefused    atomic.Int64
+}
+
+// errTooManyClients refuses a client of a scan that has maxClients already
+var errTooManyClients = errors.New("too many connections for this scan")
+
+func NewWebSocketManager(status func(scanID string) (*types.ScanStatus, error), maxClients int) *WebSocketManager {
+	return &WebSocketManager{
+		scans:      make(map[string]*scanSubscribers),
+		history:    make(map[string]*scanHistory),
+		dashboard:  make(map[*subscriber]bool),
+		progress:   make(map[string]*wsProgress),
+		coalescing: make(map[string]*scanCoalescer),
+		upgrader:   websocket.Upgrader{}, // same origin only unless CheckOrigin is set
+		status:     status,
+		maxClients: maxClients,
+	}
+}
+
+// scanHistory numbers a scan's broadcasts and keeps the last replayBuffer of
+// them, whether or not clients are connected, so a client that reconnects
+// can catch up
+type scanHistory struct {
+	seq      uint64                   // last sequence number handed out
+	messages []types.WebSocketMessage // ring indexed by seq % replayBuffer
+	updated  time.Time
+}
+
+// add numbers a message and keeps it
+func (h *scanHistory) add(message types.WebSocketMessage) types.WebSocketMessage {
+	h.seq++
+	message.Seq = h.seq
+	h.messages[h.seq%replayBuffer] = message
+	h.updated = time.Now()
+	return message
+}
+
+// since returns the broadcasts after seq, or false when some of them are no
+// longer kept or seq was never handed out, e.g. before a restart
+func (h *scanHistory) since(seq uint64) ([]types.WebSocketMessage, bool) {
+	if seq > h.seq || h.seq-seq > replayBuffer {
+		return nil, false
+	}
+	missed := make([]types.WebSocketMessage, 0, h.seq-seq)
+	for s := seq + 1; s <= h.seq; s++ {
+		missed = append(missed, h.messages[s%replayBuffer])
+	}
+	return missed, true
+}
+
+// scanSubscribers holds the clients of one scan and the messages waiting
+// for them. Its broadcaster goroutine is the only reader of messages. The channel is
+// never closed, so a send racing the last client's departure cannot panic;
+// done is closed instead, once the scan has left the manager, and stops the
+// broadcaster.
+type scanSubscribers struct {
+	clients  map[*subscriber]bool
+	messages chan types.WebSocketMessage
+	done     chan struct{}
+}
+
+// subscriber receives a scan's broadcasts for one WebSocket or event stream.
+// Messages wait in pending for the consumer's own writer goroutine, so
+// queuing never waits for the network.
+type subscriber struct {
+	mutex   sync.Mutex // guards pending and saturated
+	pending []types.WebSocketMessage
+	// saturated is when the subscriber's queue first overflowed without it
+	// catching up since; zero while it keeps up
+	saturated time.Time
+	ready     chan struct{} // signalled when pending gains messages
+	closed    chan struct{}
+	once      sync.Once
+	onClose   func(code int, reason string) // ends the connection, may be nil
+
+	filterMutex sync.Mutex // the reader sets the filter, the broadcaster reads it
+	filter      eventFilter
+
+	// after is the last broadcast the subscriber got on connect, through
+	// replay or the status snapshot. Set once under the manager's lock.
+	after uint64
+}
+
+func newSubscriber(onClose func(code int, reason string)) *subscriber {
+	return &subscriber{
+		ready:   make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		onClose: onClose,
+	}
+}
+
+// subscribe replaces the subscriber's filter
+func (s *subscriber) subscribe(filter eventFilter) {
+	s.filterMutex.Lock()
+	s.filter = filter
+	s.filterMutex.Unlock()
+}
+
+// filtered returns what the subscriber subscribed to of a broadcast message
+func (s *subscriber) filtered(message types.WebSocketMessage) (types.WebSocketMessage, bool) {
+	s.filterMutex.Lock()
+	defer s.filterMutex.Unlock()
+	return s.filter.apply(message)
+}
+
+// queueOutcome is what became of a message handed to a subscriber
+type queueOutcome int
+
+const (
+	queued queueOutcome = iota
+	// dropped: the subscriber is behind and the message, or an older one
+	// making room for it, was dropped
+	dropped
+	// disconnected: the subscriber stayed behind too long and was closed
+	disconnected
+)
+
+// intermediate reports whether a message may be dropped for a subscriber
+// that fell behind. Findings batches and logs are covered by the counts of
+// the next status update; progress by the next progress event.
+func intermediate(message types.WebSocketMessage) bool {
+	switch message.Type {
+	case "results_batch", "worker_log", "scan_progress", types.WorkerProgress:
+		return true
+	}
+	return false
+}
+
+// queue hands a message to the writer without blocking. Once sendBuffer
+// messages wait, intermediate ones are dropped to make room, and a status
+// update replaces those still waiting, so the latest status always gets
+// through. A subscriber still behind after slowClientGrace, or with nothing
+// left to drop, is disconnected rather than holding up the others.
+func (s *subscriber) queue(message types.WebSocketMessage) queueOutcome {
+	select {
+	case <-s.closed:
+		return queued
+	default:
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	outcome := queued
+	if len(s.pending) >= sendBuffer {
+		now := time.Now()
+		if s.saturated.IsZero() {
+			s.saturated = now
+		} else if now.Sub(s.saturated) > slowClientGrace {
+			return s.disconnect(message)
+		}
+
+		outcome = dropped
+		if intermediate(message) {
+			return outcome
+		}
+		if message.Type == "status_update" {
+			s.dropPending(func(m types.WebSocketMessage) bool { return m.Type == "status_update" })
+		}
+		if len(s.pending) >= sendBuffer {
+			s.dropOldest(intermediate)
+		}
+		if len(s.pending) >= sendBuffer {
+			return s.disconnect(message)
+		}
+	}
+
+	s.pending = append(s.pending, message)
+	select {
+	case s.ready <- struct{}{}:
+	default: // the writer has yet to take the last signal
+	}
+	return outcome
+}
+
+// dropPending drops the waiting messages drop matches. The caller holds the
+// subscriber's lock.
+func (s *subscriber) dropPending(drop func(types.WebSocketMessage) bool) {
+	kept := s.pending[:0]
+	for _, message := range s.pending {
+		if !drop(message) {
+			kept = append(kept, message)
+		}
+	}
+	clear(s.pending[len(kept):])
+	s.pending = kept
+}
+
+// dropOldest drops the oldest waiting message drop matches. The caller holds
+// the subscriber's lock.
+func (s *subscriber) dropOldest(drop func(types.WebSocketMessage) bool) {
+	for i, message := range s.pending {
+		if drop(message) {
+			copy(s.pending[i:], s.pending[i+1:])
+			s.pending[len(s.pending)-1] = types.WebSocketMessage{}
+			s.pending = s.pending[:len(s.pending)-1]
+			return
+		}
+	}
+}
+
+// disconnect closes a subscriber that fell behind. The caller holds the
+// subscriber's lock.
+func (s *subscriber) disconnect(message types.WebSocketMessage) queueOutcome {
+	slog.Warn("Live update client too slow, disconnecting", "type", message.Type, "behind_for", time.Since(s.saturated).Round(time.Second).String())
+	s.pending = nil
+	s.close(websocket.CloseTryAgainLater, "too slow to keep up")
+	return disconnected
+}
+
+// take hands the writer every waiting message. The subscriber counts as
+// caught up once it takes a queue no more than half full.
+func (s *subscriber) take() []types.WebSocketMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.pending
+	if len(messages) <= sendBuffer/2 {
+		s.saturated = time.Time{}
+	}
+	s.pending = nil
+	return messages
+}
+
+// close stops the writer and ends the connection, telling the client why
+// with code when the transport can
+func (s *subscriber) close(code int, reason string) {
+	s.once.Do(func() {
+		close(s.closed)
+		if s.onClose != nil {
+			s.onClose(code, reason)
+		}
+	})
+}
+
+// count records what became of a message queued for a client
+func (wsm *WebSocketManager) count(outcome queueOutcome) {
+	switch outcome {
+	case dropped:
+		wsm.clientDrops.Add(1)
+	case disconnected:
+		wsm.slowClients.Add(1)
+	}
+}
+
+// RegisterClient adds a client to a scan. A client resuming after seq since
+// is sent the broadcasts it missed; otherwise, or when they are no longer
+// kept, it is sent snapshot's status numbered with the last broadcast. Both
+// happen under the lock, so no broadcast is missed or sent twice. It returns
+// the number of messages replayed, or errTooManyClients when the scan has
+// maxClients already.
+func (wsm *WebSocketManager) RegisterClient(scanID string, client *subscriber, since *uint64, snapshot func() interface{}) (int, error) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	scan := wsm.scans[scanID]
+	if scan != nil && len(scan.clients) >= wsm.maxClients {
+		wsm.clientsRefused.Add(1)
+		return 0, errTooManyClients
+	}
+
+	var last uint64
+	var missed []types.WebSocketMessage
+	resumed := false
+	if history := wsm.history[scanID]; history != nil {
+		last = history.seq
+		if since != nil {
+			missed, resumed = history.since(*since)
+		}
+	} else if since != nil && *since == 0 {
+		resumed = true // nothing was broadcast yet
+	}
+	client.after = last
+	if resumed {
+		for _, message := range missed {
+			if filtered, wanted := client.filtered(message); wanted {
+				client.queue(filtered)
+			}
+		}
+	} else if status := snapshot(); status != nil {
+		client.queue(types.WebSocketMessage{Type: "status_update", Data: status, Seq: last})
+	}
+
+	if scan == nil {
+		scan = &scanSubscribers{
+			clients:  make(map[*subscriber]bool),
+			messages: make(chan types.WebSocketMessage, 100),
+			done:     make(chan struct{}),
+		}
+		wsm.scans[scanID] = scan
+
+		// Start broadcast goroutine for this scan
+		go wsm.handleBroadcast(scan)
+	}
+
+	scan.clients[client] = true
+	return len(missed), nil
+}
+
+// UnregisterClient removes a client. It is only called by the client's own
+// handler once its reads fail, never from the broadcaster.
+func (wsm *WebSocketManager) UnregisterClient(scanID string, client *subscriber) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	if scan, exists := wsm.scans[scanID]; exists {
+		if _, exists := scan.clients[client]; exists {
+			delete(scan.clients, client)
+
+			// Stop the broadcaster with the last client. A client that
+			// connects later gets a new one.
+			if len(scan.clients) == 0 {
+				delete(wsm.scans, scanID)
+				close(scan.done)
+			}
+		}
+	}
+}
+
+// debugCounts returns the connected clients, queued messages and batched
+// findings per scan
+func (wsm *WebSocketManager) debugCounts() (clients, queued, batched map[string]int) {
+	wsm.mutex.RLock()
+	defer wsm.mutex.RUnlock()
+
+	clients = make(map[string]int, len(wsm.scans))
+	queued = make(map[string]int, len(wsm.scans))
+	for scanID, scan := range wsm.scans {
+		clients[scanID] = len(scan.clients)
+		queued[scanID] = len(scan.messages)
+	}
+	batched = make(map[string]int, len(wsm.coalescing))
+	for scanID, coalescer := range wsm.coalescing {
+		batched[scanID] = len(coalescer.results)
+	}
+	return clients, queued, batched
+}
+
+// BroadcastToScan sends a message to the scan's clients. Status updates are
+// rate-limited, and a completion first sends whatever is held back.
+func (wsm *WebSocketManager) BroadcastToScan(scanID string, message types.WebSocketMessage) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	switch message.Type {
+	case "status_update":
+		if wsm.holdStatus(scanID, message) {
+			return
+		}
+	case "scan_complete":
+		wsm.flushResults(scanID)
+		wsm.stopCoalescing(scanID) // the completion carries the final status
+	}
+	wsm.publish(scanID, message)
+}
+
+// publish sends a message to the scan's clients here and on the other
+// replicas. The caller holds the lock.
+func (wsm *WebSocketManager) publish(scanID string, message types.WebSocketMessage) {
+	wsm.deliver(scanID, message)
+	if wsm.relay != nil {
+		wsm.relay(scanID, message.Type, message.Data)
+	}
+}
+
+// deliver numbers a message, keeps it for replay and queues it for the
+// scan's clients without blocking. The caller holds the lock.
+func (wsm *WebSocketManager) deliver(scanID string, message types.WebSocketMessage) {
+	history := wsm.history[scanID]
+	if history == nil {
+		wsm.pruneHistory()
+		history = &scanHistory{messages: make([]types.WebSocketMessage, replayBuffer)}
+		wsm.history[scanID] = history
+	}
+	message = history.add(message)
+	wsm.summarize(scanID, message)
+
+	if scan, exists := wsm.scans[scanID]; exists {
+		select {
+		case scan.messages <- message:
+		default:
+			wsm.broadcastsDropped.Add(1)
+			slog.Warn("WebSocket broadcast channel full, dropping message", "scan_id", scanID, "type", message.Type)
+		}
+	}
+}
+
+// ForgetScan drops a finished scan's broadcasts unless clients are still
+// connected; reconnecting clients get the status instead of a replay
+func (wsm *WebSocketManager) ForgetScan(scanID string) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	if _, connected := wsm.scans[scanID]; !connected {
+		delete(wsm.history, scanID)
+	}
+	wsm.stopProgress(scanID)
+	wsm.stopCoalescing(scanID)
+}
+
+// pruneHistory drops the broadcasts of scans without clients that went
+// replayIdle without one. Numbering a connected scan's broadcasts from 1
+// again would hide them from its clients, so those are kept. The caller
+// holds the lock.
+func (wsm *WebSocketManager) pruneHistory() {
+	cutoff := time.Now().Add(-replayIdle)
+	for scanID, history := range wsm.history {
+		if _, connected := wsm.scans[scanID]; !connected && history.updated.Before(cutoff) {
+			delete(wsm.history, scanID)
+		}
+	}
+}
+
+// handleBroadcast hands each message to every client of the scan until the
+// last one leaves. Queuing never blocks, so the lock is held while the
+// clients are iterated; clients that stay behind are closed and leave
+// through their own handlers.
+func (wsm *WebSocketManager) handleBroadcast(scan *scanSubscribers) {
+	for {
+		select {
+		case message := <-scan.messages:
+			wsm.mutex.RLock()
+			for client := range scan.clients {
+				// Broadcasts queued before the client connected were
+				// replayed or are covered by its snapshot
+				if message.Seq <= client.after {
+					continue
+				}
+				if filtered, wanted := client.filtered(message); wanted {
+					wsm.count(client.queue(filtered))
+				}
+			}
+			wsm.mutex.RUnlock()
+		case <-scan.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// drain takes a subscriber's messages until it is closed or stop is closed,
+// failing on a broadcast numbered out of order
+func drain(t *testing.T, client *subscriber, stop <-chan struct{}) int {
+	received := 0
+	last := uint64(0)
+	check := func() {
+		for _, message := range client.take() {
+			if message.Type == "status_update" && message.Seq == client.after {
+				continue // the snapshot on connect
+			}
+			if message.Seq <= last {
+				t.Errorf("got %s numbered %d after %d", message.Type, message.Seq, last)
+			}
+			last = message.Seq
+			received++
+		}
+	}
+	for {
+		select {
+		case <-client.ready:
+			check()
+		case <-client.closed:
+			return received
+		case <-stop:
+			check()
+			return received
+		}
+	}
+}
+
+// TestBroadcastConcurrentClients registers, unregisters and broadcasts to the
+// clients of several scans from many goroutines at once. Run it with -race.
+func TestBroadcastConcurrentClients(t *testing.T) {
+	const (
+		scans       = 4
+		churners    = 16
+		broadcaster = 8
+		rounds      = 200
+	)
+	wsm := NewWebSocketManager(func(scanID string) (*types.ScanStatus, error) {
+		return &types.ScanStatus{ID: scanID}, nil
+	}, churners*rounds)
+	scanID := func(i int) string { return fmt.Sprintf("scan-%d", i%scans) }
+	snapshot := func() interface{} { return &types.ScanStatus{Status: "running"} }
+
+	// One client per scan stays connected throughout and checks the order
+	stop := make(chan struct{})
+	var watchers sync.WaitGroup
+	received := make([]int, scans)
+	steady := make([]*subscriber, scans)
+	for i := range steady {
+		steady[i] = newSubscriber(nil)
+		if _, err := wsm.RegisterClient(scanID(i), steady[i], nil, snapshot); err != nil {
+			t.Fatalf("RegisterClient() error = %v", err)
+		}
+		watchers.Add(1)
+		go func(i int) {
+			defer watchers.Done()
+			received[i] = drain(t, steady[i], stop)
+		}(i)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < churners; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				client := newSubscriber(nil)
+				id := scanID(g + round)
+				var since *uint64
+				if round%3 == 0 {
+					seq := uint64(round)
+					since = &seq
+				}
+				if _, err := wsm.RegisterClient(id, client, since, snapshot); err != nil {
+					t.Errorf("RegisterClient() error = %v", err)
+					return
+				}
+				if round%2 == 0 {
+					client.subscribe(eventFilter{events: map[string]bool{"worker_log": true}})
+				}
+				client.take()
+				wsm.UnregisterClient(id, client)
+				// Unregistering twice is harmless
+				if round%5 == 0 {
+					wsm.UnregisterClient(id, client)
+				}
+			}
+		}(g)
+	}
+	for g := 0; g < broadcaster; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				id := scanID(g + round)
+				switch round % 4 {
+				case 0:
+					wsm.BroadcastToScan(id, types.WebSocketMessage{Type: "status_update", Data: &types.ScanStatus{ID: id}})
+				case 1:
+					wsm.BroadcastResult(id, &types.ScanResult{Host: "example.com", Template: "tech-detect", Severity: "info"})
+				default:
+					wsm.BroadcastToScan(id, types.WebSocketMessage{Type: "worker_log", Data: round})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Let the broadcasters hand over what is queued
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, queued, _ := wsm.debugCounts()
+		empty := true
+		for _, count := range queued {
+			empty = empty && count == 0
+		}
+		if empty || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	watchers.Wait()
+
+	clients, _, _ := wsm.debugCounts()
+	for i := range steady {
+		if clients[scanID(i)] != 1 {
+			t.Errorf("%s has %d clients, want only the steady one", scanID(i), clients[scanID(i)])
+		}
+		if received[i] == 0 {
+			t.Errorf("steady client of %s received no broadcasts", scanID(i))
+		}
+		wsm.UnregisterClient(scanID(i), steady[i])
+	}
+	if clients, _, _ := wsm.debugCounts(); len(clients) != 0 {
+		t.Errorf("clients left after every one unregistered: %v", clients)
+	}
+	if dropped := wsm.broadcastsDropped.Load(); dropped > 0 {
+		t.Logf("%d broadcasts dropped for full scan queues", dropped)
+	}
+	for i := 0; i < scans; i++ {
+		wsm.ForgetScan(scanID(i))
+	}
+}
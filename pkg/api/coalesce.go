@@ -0,0 +1,138 @@
+package api
+
+import (
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// resultBatchInterval is the longest a finding waits for its results
+	// batch
+	resultBatchInterval = 250 * time.Millisecond
+	// resultBatchMax sends a batch early once it holds this many findings
+	resultBatchMax = 500
+	// statusInterval is the least time between two status updates of a scan
+	statusInterval = time.Second
+)
+
+// scanCoalescer holds what a scan's broadcasts wait on: findings for the
+// next results batch and the latest status update inside the rate limit.
+// Timers fire under the manager's lock and find the coalescer gone once the
+// scan completed.
+type scanCoalescer struct {
+	results      []types.ScanResult
+	resultsTimer *time.Timer
+
+	status      *types.WebSocketMessage
+	statusSent  time.Time
+	statusTimer *time.Timer
+}
+
+// coalescer returns a scan's coalescer, creating it. The caller holds the
+// lock.
+func (wsm *WebSocketManager) coalescer(scanID string) *scanCoalescer {
+	coalescer := wsm.coalescing[scanID]
+	if coalescer == nil {
+		coalescer = &scanCoalescer{}
+		wsm.coalescing[scanID] = coalescer
+	}
+	return coalescer
+}
+
+// BroadcastResult adds a finding to the scan's next results batch, which
+// goes out within resultBatchInterval
+func (wsm *WebSocketManager) BroadcastResult(scanID string, result *types.ScanResult) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	coalescer := wsm.coalescer(scanID)
+	coalescer.results = append(coalescer.results, *result)
+	if len(coalescer.results) >= resultBatchMax {
+		wsm.flushResults(scanID)
+		return
+	}
+	if coalescer.resultsTimer == nil {
+		coalescer.resultsTimer = time.AfterFunc(resultBatchInterval, func() {
+			wsm.mutex.Lock()
+			defer wsm.mutex.Unlock()
+			wsm.flushResults(scanID)
+		})
+	}
+}
+
+// flushResults sends the findings waiting for a scan's batch with the scan's
+// totals. The caller holds the lock.
+func (wsm *WebSocketManager) flushResults(scanID string) {
+	coalescer := wsm.coalescing[scanID]
+	if coalescer == nil {
+		return
+	}
+	if coalescer.resultsTimer != nil {
+		coalescer.resultsTimer.Stop()
+		coalescer.resultsTimer = nil
+	}
+	if len(coalescer.results) == 0 {
+		return
+	}
+
+	batch := &types.ResultsBatch{Results: coalescer.results}
+	coalescer.results = nil
+	if status, err := wsm.status(scanID); err == nil {
+		batch.ResultCount = status.ResultCount
+		batch.SeverityCounts = status.SeverityCounts
+	}
+	wsm.publish(scanID, types.WebSocketMessage{Type: "results_batch", Data: batch})
+}
+
+// holdStatus rate-limits a scan's status updates. An update inside
+// statusInterval replaces any held back and waits for the timer, so the
+// latest one always goes out; holdStatus reports whether it was held. The
+// caller holds the lock.
+func (wsm *WebSocketManager) holdStatus(scanID string, message types.WebSocketMessage) bool {
+	coalescer := wsm.coalescer(scanID)
+	if coalescer.statusTimer == nil && time.Since(coalescer.statusSent) >= statusInterval {
+		coalescer.statusSent = time.Now()
+		return false
+	}
+
+	coalescer.status = &message
+	if coalescer.statusTimer == nil {
+		wait := statusInterval - time.Since(coalescer.statusSent)
+		coalescer.statusTimer = time.AfterFunc(wait, func() {
+			wsm.mutex.Lock()
+			defer wsm.mutex.Unlock()
+			wsm.flushStatus(scanID, coalescer)
+		})
+	}
+	return true
+}
+
+// flushStatus sends the status update held back, unless the scan completed
+// meanwhile
+func (wsm *WebSocketManager) flushStatus(scanID string, coalescer *scanCoalescer) {
+	if wsm.coalescing[scanID] != coalescer {
+		return
+	}
+	coalescer.statusTimer = nil
+	if coalescer.status != nil {
+		message := *coalescer.status
+		coalescer.status = nil
+		coalescer.statusSent = time.Now()
+		wsm.publish(scanID, message)
+	}
+}
+
+// stopCoalescing drops what a scan holds back and its timers. The caller
+// holds the lock and has flushed the results it wants sent.
+func (wsm *WebSocketManager) stopCoalescing(scanID string) {
+	if coalescer := wsm.coalescing[scanID]; coalescer != nil {
+		if coalescer.resultsTimer != nil {
+			coalescer.resultsTimer.Stop()
+		}
+		if coalescer.statusTimer != nil {
+			coalescer.statusTimer.Stop()
+		}
+		delete(wsm.coalescing, scanID)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// gzipWriters are reused across responses, a gzip.Writer holds about 800KB
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// compressResponses gzips responses for clients that accept it. WebSocket
+// upgrades, responses without a body and formats that are compressed already
+// are sent as they are.
+func compressResponses(c *gin.Context) {
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		c.Next()
+		return
+	}
+
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Next()
+		return
+	}
+
+	writer := &gzipWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	defer writer.close()
+	c.Next()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		return quality > 0
+	}
+	return false
+}
+
+// compressible reports whether a content type is worth compressing
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case contentType == "":
+		return false
+	case strings.HasPrefix(contentType, "image/svg"):
+		return true
+	case strings.HasPrefix(contentType, "image/"), strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "audio/"), strings.HasPrefix(contentType, "font/woff"),
+		strings.HasPrefix(contentType, "application/zip"), strings.HasPrefix(contentType, "application/gzip"),
+		strings.HasPrefix(contentType, "text/event-stream"):
+		return false
+	}
+	return true
+}
+
+// gzipWriter compresses a response once its first bytes show it has a body
+// worth compressing. Until then headers can still change.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decide()
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// decide compresses the response unless a handler encoded it already or its
+// content type is compressed
+func (w *gzipWriter) decide() {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || !compressible(header.Get("Content-Type")) {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+// Flush sends what was written so far. A flush before the first write, as
+// by a reverse proxy, decides on compressing first, since it sends the
+// headers.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close finishes the gzip stream, if the response was compressed
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
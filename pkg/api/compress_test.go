@@ -0,0 +1,149 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0, deflate", false},
+		{"gzip;q=bogus", false},
+		{"*", true},
+		{"br, *;q=0", false},
+		{"identity", false},
+		{"br, deflate", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+// newCompressingRouter serves a few kinds of responses through
+// compressResponses
+func newCompressingRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(compressResponses)
+	r.GET("/json", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": strings.Repeat("finding ", 100)})
+	})
+	r.GET("/zip", func(c *gin.Context) {
+		c.Data(200, "application/zip", []byte("PK\x03\x04"))
+	})
+	r.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "br")
+		c.Data(200, "text/plain", []byte("already encoded"))
+	})
+	r.GET("/empty", func(c *gin.Context) {
+		c.Status(204)
+	})
+	return r
+}
+
+func getWithEncoding(r http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func gunzip(t *testing.T, body io.Reader) string {
+	t.Helper()
+	reader, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read the gzip body: %v", err)
+	}
+	return string(data)
+}
+
+func TestCompressResponses(t *testing.T) {
+	r := newCompressingRouter()
+
+	recorder := getWithEncoding(r, "/json", "gzip, deflate")
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", recorder.Header().Get("Content-Encoding"))
+	}
+	if recorder.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", recorder.Header().Get("Vary"))
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("Content-Length = %q on a compressed response", recorder.Header().Get("Content-Length"))
+	}
+	var body struct{ Message string }
+	if err := json.Unmarshal([]byte(gunzip(t, recorder.Body)), &body); err != nil || body.Message != strings.Repeat("finding ", 100) {
+		t.Errorf("decompressed body = %+v, %v; want the JSON", body, err)
+	}
+
+	tests := []struct {
+		path, acceptEncoding string
+		wantEncoding         string
+	}{
+		{"/json", "", ""},
+		{"/json", "gzip;q=0", ""},
+		{"/zip", "gzip", ""},
+		{"/encoded", "gzip", "br"},
+		{"/empty", "gzip", ""},
+	}
+	for _, tt := range tests {
+		recorder := getWithEncoding(r, tt.path, tt.acceptEncoding)
+		if got := recorder.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+			t.Errorf("GET %s with Accept-Encoding %q: Content-Encoding = %q, want %q", tt.path, tt.acceptEncoding,
+				got, tt.wantEncoding)
+		}
+		if recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s: Vary = %q, want Accept-Encoding", tt.path, recorder.Header().Get("Vary"))
+		}
+	}
+	if recorder := getWithEncoding(r, "/zip", "gzip"); recorder.Body.String() != "PK\x03\x04" {
+		t.Errorf("zip body = %q, want it sent as it is", recorder.Body)
+	}
+	if recorder := getWithEncoding(r, "/empty", "gzip"); recorder.Body.Len() != 0 {
+		t.Errorf("204 body = %q, want none", recorder.Body)
+	}
+}
+
+func TestAPICompressesResponses(t *testing.T) {
+	r, _ := newTestServer(t, Config{})
+
+	recorder := serve(r, "GET", "/api/v1/scans", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if recorder.Code != 200 || recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d with Content-Encoding %q, want 200 gzip", recorder.Code, recorder.Header().Get("Content-Encoding"))
+	}
+	var scans map[string]interface{}
+	if err := json.Unmarshal([]byte(gunzip(t, recorder.Body)), &scans); err != nil {
+		t.Errorf("decompressed body is not JSON: %v", err)
+	}
+
+	// Errors are compressed like any other JSON
+	recorder = serve(r, "GET", "/api/v1/scan/unknown/status", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if recorder.Code != 404 || recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d with Content-Encoding %q, want 404 gzip", recorder.Code, recorder.Header().Get("Content-Encoding"))
+	}
+	if body := gunzip(t, recorder.Body); !strings.Contains(body, CodeScanNotFound) {
+		t.Errorf("decompressed error = %s, want %s", body, CodeScanNotFound)
+	}
+}
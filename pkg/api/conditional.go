@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scanETag identifies one representation of a scan at a revision. variant
+// tells apart representations of the same revision, such as result pages and
+// formats. Revisions start over when the server restarts, so the ETag also
+// names the server's start.
+func (h *Handler) scanETag(scanID string, revision int64, variant string) string {
+	sum := sha256.Sum256([]byte(h.startedAt + "\x00" + scanID + "\x00" + variant))
+	return fmt.Sprintf(`W/"%d-%s"`, revision, hex.EncodeToString(sum[:8]))
+}
+
+// notModified sets a response's ETag and answers 304 when the client already
+// holds that representation. It reports whether the request was answered.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	// Scans change while they run, so clients must revalidate every time
+	c.Header("Cache-Control", "private, no-cache")
+	if !etagMatches(c.GetHeader("If-None-Match"), etag) {
+		return false
+	}
+	c.AbortWithStatus(304)
+	return true
+}
+
+// etagMatches applies If-None-Match's weak comparison
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
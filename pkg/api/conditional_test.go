@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `W/"3-abcdef"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"3-abcdef"`, true},
+		{`"3-abcdef"`, true},
+		{`W/"2-abcdef"`, false},
+		{`W/"1-x", W/"3-abcdef"`, true},
+		{"*", true},
+		{`W/"3-abcde"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestScanStatusNotModified(t *testing.T) {
+	r, orch := newTestServer(t, Config{})
+
+	recorder := serveJSON(r, "POST", "/api/v1/scan", `{"domains": ["example.com"], "droplets": 1}`)
+	if recorder.Code != 200 {
+		t.Fatalf("POST /scan = %d: %s", recorder.Code, recorder.Body)
+	}
+	var started struct {
+		ScanID string `json:"scan_id"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &started); err != nil || started.ScanID == "" {
+		t.Fatalf("POST /scan body = %s, want a scan ID", recorder.Body)
+	}
+	path := "/api/v1/scan/" + started.ScanID + "/status"
+
+	// The scan changes once its worker is up
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := orch.GetScanStatus(started.ScanID)
+		if err == nil && len(status.ActiveDroplets) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker did not come up: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	first := serve(r, "GET", path, nil, nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || etag == "" {
+		t.Fatalf("GET status = %d with ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+	if first.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", first.Header().Get("Cache-Control"))
+	}
+
+	// The ETag is the same until the scan changes, compressed or not
+	for _, header := range []http.Header{
+		{"If-None-Match": {etag}},
+		{"If-None-Match": {etag}, "Accept-Encoding": {"gzip"}},
+		{"If-None-Match": {`W/"0-other", ` + etag}},
+	} {
+		recorder := serve(r, "GET", path, nil, header)
+		if recorder.Code != 304 {
+			t.Fatalf("GET status with %v = %d, want 304", header, recorder.Code)
+		}
+		if recorder.Body.Len() != 0 || recorder.Header().Get("Content-Encoding") != "" {
+			t.Errorf("304 has a %d byte body with Content-Encoding %q, want none", recorder.Body.Len(),
+				recorder.Header().Get("Content-Encoding"))
+		}
+		if recorder.Header().Get("ETag") != etag {
+			t.Errorf("304 ETag = %q, want %q", recorder.Header().Get("ETag"), etag)
+		}
+	}
+
+	if _, err := orch.AddResult(started.ScanID, types.ScanResult{Host: "example.com", Template: "tech-detect",
+		MatchedAt: "https://example.com/"}); err != nil {
+		t.Fatalf("AddResult() error = %v", err)
+	}
+	changed := serve(r, "GET", path, nil, http.Header{"If-None-Match": {etag}})
+	if changed.Code != 200 {
+		t.Fatalf("GET status after a finding = %d, want 200", changed.Code)
+	}
+	if changed.Header().Get("ETag") == etag {
+		t.Errorf("ETag %q did not change with the scan", etag)
+	}
+
+	// Result pages are told apart by their query
+	results := serve(r, "GET", "/api/v1/scan/"+started.ScanID+"/results?page=1", nil, nil)
+	resultsETag := results.Header().Get("ETag")
+	if results.Code != 200 || resultsETag == "" {
+		t.Fatalf("GET results = %d with ETag %q, want 200 with an ETag", results.Code, resultsETag)
+	}
+	if recorder := serve(r, "GET", "/api/v1/scan/"+started.ScanID+"/results?page=1", nil,
+		http.Header{"If-None-Match": {resultsETag}}); recorder.Code != 304 {
+		t.Errorf("GET the same results page = %d, want 304", recorder.Code)
+	}
+	if recorder := serve(r, "GET", "/api/v1/scan/"+started.ScanID+"/results?page=2", nil,
+		http.Header{"If-None-Match": {resultsETag}}); recorder.Code != 200 {
+		t.Errorf("GET another results page with the first's ETag = %d, want 200", recorder.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnyOrigin in AllowedOrigins lets every origin call the API. It is meant
+// for development only.
+const AnyOrigin = "*"
+
+const (
+	// corsMaxAge is how long browsers may cache a preflight response
+	corsMaxAge = 10 * time.Minute
+
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, Accept, X-Request-ID, Idempotency-Key, If-None-Match"
+	corsExposeHeaders = "Content-Disposition, Retry-After, Deprecation, Link, X-Request-ID, Idempotent-Replayed, ETag"
+)
+
+// CheckOrigins reports the first entry of origins that is neither AnyOrigin
+// nor a scheme and host, e.g. https://scanner.example.com
+func CheckOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == AnyOrigin {
+			continue
+		}
+		if _, ok := normalizeOrigin(origin); !ok {
+			return fmt.Errorf("%q is not an origin like https://example.com", origin)
+		}
+	}
+	return nil
+}
+
+// normalizeOrigin lowercases an origin's scheme and host. Origins have no
+// path, query or credentials.
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// originPolicy decides which cross-origin callers the browser may let through
+type originPolicy struct {
+	any     bool
+	origins map[string]bool
+}
+
+func newOriginPolicy(allowed []string) *originPolicy {
+	policy := &originPolicy{origins: make(map[string]bool)}
+	for _, origin := range allowed {
+		if origin == AnyOrigin {
+			policy.any = true
+		} else if normalized, ok := normalizeOrigin(origin); ok {
+			policy.origins[normalized] = true
+		}
+	}
+	return policy
+}
+
+// allowed reports whether a cross-origin request from origin is allowed
+func (p *originPolicy) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	normalized, ok := normalizeOrigin(origin)
+	return ok && p.origins[normalized]
+}
+
+// checkWebSocket is the upgrader's CheckOrigin. Clients that send no Origin
+// are not browsers, and the web UI is served from the same origin.
+func (p *originPolicy) checkWebSocket(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.allowed(origin)
+}
+
+// CORS answers cross-origin requests from the allowed origins, including
+// preflights. Other origins get no CORS headers, so browsers refuse to hand
+// them the responses.
+func (h *Handler) CORS(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+
+	c.Writer.Header().Add("Vary", "Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if !h.origins.allowed(origin) {
+		if preflight {
+			c.AbortWithStatus(403)
+			return
+		}
+		c.Next()
+		return
+	}
+
+	// API keys travel in the Authorization header rather than cookies, so
+	// credentials are never allowed and the wildcard stays valid
+	if h.origins.any {
+		c.Header("Access-Control-Allow-Origin", AnyOrigin)
+	} else {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+	if preflight {
+		c.Header("Access-Control-Allow-Methods", corsAllowMethods)
+		c.Header("Access-Control-Allow-Headers", corsAllowHeaders)
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		c.AbortWithStatus(204)
+		return
+	}
+	c.Header("Access-Control-Expose-Headers", corsExposeHeaders)
+	c.Next()
+}
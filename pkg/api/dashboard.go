@@ -0,0 +1,170 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+// wsProgressInterval is the least time between two scan_progress messages
+// of a scan on the dashboard feed
+const wsProgressInterval = 3 * time.Second
+
+// wsProgress throttles a scan's progress on the dashboard feed. An update
+// inside the interval waits for the timer, replacing any update already
+// waiting, so the last one always arrives.
+type wsProgress struct {
+	sent    time.Time
+	pending *types.WebSocketMessage
+	timer   *time.Timer
+}
+
+// scanEvent summarizes a scan for the dashboard feed
+func scanEvent(status *types.ScanStatus) types.ScanEvent {
+	return types.ScanEvent{
+		ScanID:         status.ID,
+		Status:         status.Status,
+		CreatedAt:      status.CreatedAt,
+		CompletedAt:    status.CompletedAt,
+		Progress:       status.Progress,
+		TotalDomains:   status.TotalDomains,
+		ScannedDomains: status.ScannedDomains,
+		Workers:        len(status.ActiveDroplets),
+		ResultCount:    status.ResultCount,
+		SeverityCounts: status.SeverityCounts,
+		Degraded:       status.Degraded,
+	}
+}
+
+// HandleDashboardWebSocket streams the lifecycle events of every scan:
+// scan_started, scan_progress, worker_failed and scan_complete. Findings are
+// left to the per-scan WebSocket.
+func (h *Handler) HandleDashboardWebSocket(c *gin.Context) {
+	conn, err := h.wsManager.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		requestLog(c).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	client := newWSClient(conn)
+	client.keepAlive()
+	go client.writeMessages("")
+	defer client.close(0, "")
+
+	h.wsManager.RegisterDashboardClient(client.subscriber, func() []types.ScanEvent {
+		running := h.orchestrator.RunningScans()
+		events := make([]types.ScanEvent, len(running))
+		for i, status := range running {
+			events[i] = scanEvent(status)
+		}
+		return events
+	})
+	defer h.wsManager.UnregisterDashboardClient(client.subscriber)
+
+	requestLog(c).Info("Dashboard WebSocket client connected")
+
+	client.readMessages(requestLog(c), func(wsIncoming) {})
+}
+
+// RegisterDashboardClient adds a client to the dashboard feed and sends it
+// the running scans. The snapshot is taken under the lock, so no event is
+// missed between it and the live feed.
+func (wsm *WebSocketManager) RegisterDashboardClient(client *subscriber, snapshot func() []types.ScanEvent) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	client.queue(types.WebSocketMessage{Type: "scans", Data: snapshot()})
+	wsm.dashboard[client] = true
+}
+
+// UnregisterDashboardClient removes a client from the dashboard feed
+func (wsm *WebSocketManager) UnregisterDashboardClient(client *subscriber) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	delete(wsm.dashboard, client)
+}
+
+// BroadcastDashboard queues an event for every dashboard client without
+// blocking. Events a scan's broadcasts cover are fed in by BroadcastToScan.
+func (wsm *WebSocketManager) BroadcastDashboard(message types.WebSocketMessage) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	wsm.queueDashboard(message)
+	if wsm.relay != nil {
+		wsm.relay("", message.Type, message.Data)
+	}
+}
+
+// queueDashboard hands an event to every dashboard client. The caller holds
+// the lock.
+func (wsm *WebSocketManager) queueDashboard(message types.WebSocketMessage) {
+	for client := range wsm.dashboard {
+		wsm.count(client.queue(message))
+	}
+}
+
+// summarize feeds a scan's broadcast into the dashboard: status updates as
+// throttled scan_progress events, completions as scan_complete. The caller
+// holds the lock.
+func (wsm *WebSocketManager) summarize(scanID string, message types.WebSocketMessage) {
+	status, ok := message.Data.(*types.ScanStatus)
+	if !ok {
+		return
+	}
+
+	switch message.Type {
+	case "status_update":
+		if len(wsm.dashboard) == 0 {
+			return
+		}
+		event := types.WebSocketMessage{Type: "scan_progress", Data: scanEvent(status)}
+		progress := wsm.progress[scanID]
+		if progress == nil {
+			progress = &wsProgress{}
+			wsm.progress[scanID] = progress
+		}
+		if progress.timer == nil && time.Since(progress.sent) >= wsProgressInterval {
+			progress.sent = time.Now()
+			wsm.queueDashboard(event)
+			return
+		}
+		progress.pending = &event
+		if progress.timer == nil {
+			wait := wsProgressInterval - time.Since(progress.sent)
+			progress.timer = time.AfterFunc(wait, func() { wsm.flushProgress(scanID, progress) })
+		}
+	case "scan_complete":
+		// A progress update still waiting would arrive after the completion
+		wsm.stopProgress(scanID)
+		wsm.queueDashboard(types.WebSocketMessage{Type: "scan_complete", Data: scanEvent(status)})
+	}
+}
+
+// flushProgress sends the progress update that waited out the interval,
+// unless the scan completed meanwhile
+func (wsm *WebSocketManager) flushProgress(scanID string, progress *wsProgress) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	if wsm.progress[scanID] != progress {
+		return
+	}
+	progress.timer = nil
+	if progress.pending != nil {
+		progress.sent = time.Now()
+		wsm.queueDashboard(*progress.pending)
+		progress.pending = nil
+	}
+}
+
+// stopProgress forgets a scan's throttling. The caller holds the lock.
+func (wsm *WebSocketManager) stopProgress(scanID string) {
+	if progress := wsm.progress[scanID]; progress != nil {
+		if progress.timer != nil {
+			progress.timer.Stop()
+		}
+		delete(wsm.progress, scanID)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerDebug mounts the pprof profiles under /debug/pprof and a summary
+// of what the server holds in memory at /debug/state, both for admin keys
+// only. Nothing is mounted unless Config.EnablePprof is set.
+func registerDebug(r *gin.Engine, h *Handler) {
+	if !h.config.EnablePprof {
+		return
+	}
+	debug := r.Group("/debug", h.RequireAdminKey)
+	debug.GET("/state", h.GetDebugState)
+	debug.Any("/pprof/*profile", servePprof)
+}
+
+// servePprof serves the index and named profiles the way net/http/pprof
+// does on the default mux, which the server does not use
+func servePprof(c *gin.Context) {
+	switch c.Param("profile") {
+	case "/cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "/profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "/symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "/trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// Index serves the profile named after /debug/pprof/, or the list
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// GetDebugState returns counts of the scans, goroutines, WebSocket clients
+// and buffers the server holds in memory
+func (h *Handler) GetDebugState(c *gin.Context) {
+	state := h.orchestrator.DebugState()
+	clients, queued, batched := h.wsManager.debugCounts()
+	for i := range state.Scans {
+		scan := &state.Scans[i]
+		scan.WebSocketClients = clients[scan.ID]
+		scan.BroadcastQueue = queued[scan.ID]
+		scan.BatchedResults = batched[scan.ID]
+		if clients[scan.ID] > 0 {
+			scan.Goroutines++
+		}
+	}
+	for _, count := range clients {
+		state.WebSocketClients += count
+	}
+	state.BroadcastsDropped = h.wsManager.broadcastsDropped.Load()
+	state.ClientMessagesDropped = h.wsManager.clientDrops.Load()
+	state.SlowClients = h.wsManager.slowClients.Load()
+	state.ClientsRefused = h.wsManager.clientsRefused.Load()
+
+	c.JSON(200, state)
+}
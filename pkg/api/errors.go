@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/types"
+)
+
+// Error codes returned in ErrorResponse.Code
+const (
+	CodeInvalidRequest        = "INVALID_REQUEST" // malformed body or query parameters
+	CodeInvalidDomain         = "INVALID_DOMAIN"
+	CodeInvalidScan           = "INVALID_SCAN" // scan settings the orchestrator refuses to run
+	CodeInvalidResult         = "INVALID_RESULT"
+	CodeInvalidTriage         = "INVALID_TRIAGE"
+	CodeInvalidRule           = "INVALID_RULE"
+	CodeInvalidBlocklist      = "INVALID_BLOCKLIST_ENTRY"
+	CodeInvalidConfig         = "INVALID_CONFIG"              // runtime settings the server refuses
+	CodeTargetsBlocked        = "TARGETS_BLOCKED"             // every target of a scan is blocklisted
+	CodeIdempotencyMismatch   = "IDEMPOTENCY_KEY_REUSED"      // the key was used for a different request
+	CodeIdempotencyInProgress = "IDEMPOTENCY_KEY_IN_PROGRESS" // the key's first request is still handled
+	CodePayloadTooLarge       = "PAYLOAD_TOO_LARGE"
+	CodeQuotaExceeded         = "QUOTA_EXCEEDED" // a configured limit was reached
+	CodeUnauthorized          = "UNAUTHORIZED"
+	CodeForbidden             = "FORBIDDEN" // the API key may not use the endpoint
+	CodeWorkerUnauthorized    = "WORKER_UNAUTHORIZED"
+	CodeScanNotFound          = "SCAN_NOT_FOUND"
+	CodeResultNotFound        = "RESULT_NOT_FOUND"
+	CodeRuleNotFound          = "RULE_NOT_FOUND"
+	CodeBlocklistNotFound     = "BLOCKLIST_ENTRY_NOT_FOUND"
+	CodeDropletNotFound       = "DROPLET_NOT_FOUND"
+	CodeScanNotRunning        = "SCAN_NOT_RUNNING"    // the scan completed before the change
+	CodeScanNotHeld           = "SCAN_NOT_HELD"       // the scan's workers are not waiting for a release
+	CodeScanConfigMissing     = "SCAN_CONFIG_MISSING" // the scan predates stored configurations
+	CodeCoverageMissing       = "COVERAGE_MISSING"    // the scan predates target coverage tracking
+	CodeFeatureDisabled       = "FEATURE_DISABLED"    // the server is not configured for the feature
+	CodeSecretsConsumed       = "SECRETS_CONSUMED"
+	CodeLeaseLost             = "LEASE_LOST"      // the batch's lease expired or was never the worker's
+	CodeReleaseRefused        = "RELEASE_REFUSED" // the worker was not asked for the targets, or no worker is idle
+	CodeUpstreamFailed        = "UPSTREAM_FAILED" // an external service such as Jira failed
+	CodeUnavailable           = "SERVICE_UNAVAILABLE"
+	CodeInternal              = "INTERNAL_ERROR"
+)
+
+func init() {
+	// Report fields by their JSON names in validation errors
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// respondError aborts the request with an error envelope. It works from
+// middleware and handlers alike.
+func respondError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, types.ErrorResponse{Code: code, Message: message})
+}
+
+// orchestratorError answers with the status and code matching one of the
+// orchestrator's errors. Unexpected errors are logged and reported as
+// fallback, without the underlying error.
+func orchestratorError(c *gin.Context, err error, fallback string) {
+	var targetsError *orchestrator.InvalidTargetsError
+	var blockedError *orchestrator.BlockedTargetsError
+	switch {
+	case errors.As(err, &targetsError):
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidDomain,
+			Message: err.Error(),
+			Details: targetsError.Rejected,
+		})
+	case errors.As(err, &blockedError):
+		c.AbortWithStatusJSON(403, types.ErrorResponse{
+			Code:    CodeTargetsBlocked,
+			Message: err.Error(),
+			Details: blockedError.Blocked,
+		})
+	case errors.Is(err, orchestrator.ErrScanNotFound):
+		respondError(c, 404, CodeScanNotFound, "Scan not found")
+	case errors.Is(err, orchestrator.ErrResultNotFound):
+		respondError(c, 404, CodeResultNotFound, "Result not found")
+	case errors.Is(err, orchestrator.ErrRuleNotFound):
+		respondError(c, 404, CodeRuleNotFound, "Rule not found")
+	case errors.Is(err, orchestrator.ErrBlocklistEntryNotFound):
+		respondError(c, 404, CodeBlocklistNotFound, "Blocklist entry not found")
+	case errors.Is(err, orchestrator.ErrDropletNotFound):
+		respondError(c, 404, CodeDropletNotFound, "Worker droplet not found")
+	case errors.Is(err, orchestrator.ErrScanNotRunning):
+		respondError(c, 409, CodeScanNotRunning, "Scan is not running")
+	case errors.Is(err, orchestrator.ErrScanNotHeld):
+		respondError(c, 409, CodeScanNotHeld, "Scan was not started with holdUntilRelease or was released already")
+	case errors.Is(err, orchestrator.ErrScanConfigMissing):
+		respondError(c, 409, CodeScanConfigMissing, "Scan was recorded before configurations were stored and cannot be re-run")
+	case errors.Is(err, orchestrator.ErrCoverageMissing):
+		respondError(c, 409, CodeCoverageMissing, "Scan was recorded before target coverage was tracked")
+	case errors.Is(err, orchestrator.ErrInvalidScan):
+		respondError(c, 400, CodeInvalidScan, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidResult):
+		respondError(c, 400, CodeInvalidResult, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidTriage):
+		respondError(c, 400, CodeInvalidTriage, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidRule):
+		respondError(c, 400, CodeInvalidRule, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidBlocklistEntry):
+		respondError(c, 400, CodeInvalidBlocklist, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidConfig):
+		respondError(c, 400, CodeInvalidConfig, err.Error())
+	case errors.Is(err, orchestrator.ErrLeaseLost):
+		respondError(c, 409, CodeLeaseLost, "Batch is not leased to this worker, its lease may have expired")
+	case errors.Is(err, orchestrator.ErrReleaseRefused):
+		respondError(c, 409, CodeReleaseRefused, "Targets were not asked for or no worker is idle to take them, keep scanning them")
+	case errors.Is(err, orchestrator.ErrReadOnly):
+		respondError(c, 503, CodeUnavailable, "Server is read-only because its self-check failed, see /health")
+	case errors.Is(err, orchestrator.ErrNoRegionCapacity):
+		respondError(c, 503, CodeUnavailable, "Every allowed region is at its droplet limit or out of capacity")
+	case errors.Is(err, orchestrator.ErrSecretsConsumed):
+		respondError(c, 410, CodeSecretsConsumed, err.Error())
+	case errors.Is(err, orchestrator.ErrArtifactsDisabled), errors.Is(err, orchestrator.ErrForwardingDisabled),
+		errors.Is(err, orchestrator.ErrTicketsDisabled):
+		respondError(c, 404, CodeFeatureDisabled, err.Error())
+	case errors.Is(err, orchestrator.ErrTicketFailed), errors.Is(err, orchestrator.ErrProviderFailed):
+		requestLog(c).Error(fallback, "error", err)
+		respondError(c, 502, CodeUpstreamFailed, err.Error())
+	default:
+		requestLog(c).Error(fallback, "error", err)
+		respondError(c, 500, CodeInternal, fallback)
+	}
+}
+
+// bindingError answers a request body that could not be decoded, listing the
+// offending fields rather than the decoder's own message
+func bindingError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, 413, CodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	c.AbortWithStatusJSON(400, types.ErrorResponse{
+		Code:    CodeInvalidRequest,
+		Message: "Invalid request body",
+		Details: fieldErrors(err),
+	})
+}
+
+// fieldErrors describes what was wrong with a request body
+func fieldErrors(err error) []types.FieldError {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrors):
+		fields := make([]types.FieldError, 0, len(validationErrors))
+		for _, fieldError := range validationErrors {
+			reason := fieldError.Tag()
+			if fieldError.Tag() == "required" {
+				reason = "is required"
+			}
+			fields = append(fields, types.FieldError{Field: jsonFieldName(fieldError), Reason: reason})
+		}
+		return fields
+	case errors.As(err, &typeError):
+		field := typeError.Field
+		if field == "" {
+			field = "body"
+		}
+		return []types.FieldError{{Field: field, Reason: fmt.Sprintf("must be %s, got %s", jsonTypeName(typeError.Type.Kind().String()), typeError.Value)}}
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		return []types.FieldError{{Field: "body", Reason: "is not valid JSON"}}
+	case errors.Is(err, io.EOF):
+		return []types.FieldError{{Field: "body", Reason: "is required"}}
+	}
+	return []types.FieldError{{Field: "body", Reason: "could not be decoded"}}
+}
+
+// jsonFieldName returns the path of a failed validation without the name of
+// the request struct, e.g. droplet_config.size
+func jsonFieldName(fieldError validator.FieldError) string {
+	namespace := fieldError.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		namespace = namespace[i+1:]
+	}
+	return namespace
+}
+
+// jsonTypeName names a Go kind the way JSON clients know it
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "string":
+		return "a string"
+	case kind == "slice", kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
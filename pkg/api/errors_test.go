@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/types"
+)
+
+// envelope is the error body as clients decode it
+type envelope struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details"`
+}
+
+// decodeError checks that a response is a JSON error envelope with status
+// and code, and returns it
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder, status int, code string) envelope {
+	t.Helper()
+	if recorder.Code != status {
+		t.Errorf("status = %d, want %d: %s", recorder.Code, status, recorder.Body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", contentType)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("body %q is not a JSON object: %v", recorder.Body, err)
+	}
+	for name := range fields {
+		if name != "code" && name != "message" && name != "details" {
+			t.Errorf("envelope has unexpected field %q", name)
+		}
+	}
+
+	var body envelope
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not an error envelope: %v", recorder.Body, err)
+	}
+	if body.Code != code {
+		t.Errorf("code = %q, want %q", body.Code, code)
+	}
+	if body.Message == "" {
+		t.Error("message is empty")
+	}
+	return body
+}
+
+// fieldDetails decodes the details of a body that could not be bound
+func fieldDetails(t *testing.T, body envelope) map[string]string {
+	t.Helper()
+	reasons := make(map[string]string)
+	for _, detail := range body.Details {
+		var field struct{ Field, Reason string }
+		if err := json.Unmarshal(detail, &field); err != nil {
+			t.Fatalf("detail %s is not a field error: %v", detail, err)
+		}
+		reasons[field.Field] = field.Reason
+	}
+	return reasons
+}
+
+func TestRespondErrorEnvelope(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	respondError(c, 409, CodeScanNotRunning, "Scan is not running")
+
+	if !c.IsAborted() {
+		t.Error("respondError did not abort the request")
+	}
+	body := decodeError(t, recorder, 409, CodeScanNotRunning)
+	if body.Message != "Scan is not running" || body.Details != nil {
+		t.Errorf("body = %+v, want the message without details", body)
+	}
+	if strings.Contains(recorder.Body.String(), "details") {
+		t.Errorf("body %s has details, want them left out", recorder.Body)
+	}
+}
+
+func TestErrorEnvelopes(t *testing.T) {
+	r, _ := newTestServer(t, Config{MaxBodyBytes: 1024})
+
+	t.Run("400 malformed JSON", func(t *testing.T) {
+		body := decodeError(t, serveJSON(r, "POST", "/api/v1/scan", `{"domains": [`), 400, CodeInvalidRequest)
+		if reasons := fieldDetails(t, body); reasons["body"] != "is not valid JSON" {
+			t.Errorf("details = %v, want the body reported as invalid JSON", reasons)
+		}
+	})
+
+	t.Run("400 wrong type", func(t *testing.T) {
+		body := decodeError(t, serveJSON(r, "POST", "/api/v1/scan", `{"domains": "example.com"}`), 400, CodeInvalidRequest)
+		if reasons := fieldDetails(t, body); reasons["domains"] != "must be an array, got string" {
+			t.Errorf("details = %v, want domains reported as not an array", reasons)
+		}
+	})
+
+	t.Run("400 from the orchestrator", func(t *testing.T) {
+		body := decodeError(t, serveJSON(r, "POST", "/api/v1/scan", `{"domains": ["example.com"], "timeout": 601}`),
+			400, CodeInvalidScan)
+		if !strings.Contains(body.Message, "timeout") {
+			t.Errorf("message = %q, want it to name the timeout", body.Message)
+		}
+	})
+
+	t.Run("401 without a key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/scans", nil)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		decodeError(t, recorder, 401, CodeUnauthorized)
+		if recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("WWW-Authenticate = %q, want Bearer", recorder.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("401 with a wrong key", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer wrong"}}
+		decodeError(t, serve(r, "GET", "/api/v1/scans", nil, header), 401, CodeUnauthorized)
+	})
+
+	t.Run("404 unknown scan", func(t *testing.T) {
+		decodeError(t, serve(r, "GET", "/api/v1/scan/00000000-0000-0000-0000-000000000000/status", nil, nil),
+			404, CodeScanNotFound)
+	})
+
+	t.Run("413 by Content-Length", func(t *testing.T) {
+		payload := `{"domains": ["` + strings.Repeat("a", 2048) + `.com"]}`
+		body := decodeError(t, serveJSON(r, "POST", "/api/v1/scan", payload), 413, CodePayloadTooLarge)
+		if body.Message != "Request body exceeds 1024 bytes" {
+			t.Errorf("message = %q, want the limit named", body.Message)
+		}
+	})
+
+	t.Run("413 while reading", func(t *testing.T) {
+		// Without a Content-Length the limit is only hit while binding
+		payload := `{"domains": ["` + strings.Repeat("a", 2048) + `.com"]}`
+		recorder := serve(r, "POST", "/api/v1/scan", io.MultiReader(strings.NewReader(payload)), nil)
+		body := decodeError(t, recorder, 413, CodePayloadTooLarge)
+		if body.Message != "Request body exceeds 1024 bytes" {
+			t.Errorf("message = %q, want the limit named", body.Message)
+		}
+	})
+}
+
+func TestOrchestratorErrorDetails(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/api/v1/scan", nil)
+	orchestratorError(c, &orchestrator.BlockedTargetsError{BlockedCount: 1,
+		Blocked: []types.BlockedTarget{{Target: "blocked.example.com", Pattern: "*.example.com"}}}, "Failed to start scan")
+
+	body := decodeError(t, recorder, 403, CodeTargetsBlocked)
+	if len(body.Details) != 1 || !strings.Contains(string(body.Details[0]), "blocked.example.com") {
+		t.Errorf("details = %s, want the blocked target", body.Details)
+	}
+
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/api/v1/scans", nil)
+	orchestratorError(c, errors.New("connection refused"), "Failed to list scans")
+	body = decodeError(t, recorder, 500, CodeInternal)
+	if body.Message != "Failed to list scans" {
+		t.Errorf("message = %q, want the fallback without the underlying error", body.Message)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment, so proxies
+// do not time it out
+const sseHeartbeat = 15 * time.Second
+
+// StreamScanEvents sends a scan's broadcasts as Server-Sent Events, for
+// clients that cannot open WebSockets. Event IDs are the broadcasts' seq, so
+// an EventSource reconnecting with Last-Event-ID is sent what it missed.
+func (h *Handler) StreamScanEvents(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	if _, err := h.orchestrator.GetScanRecord(scanID); err != nil {
+		orchestratorError(c, err, "Failed to read scan")
+		return
+	}
+
+	// Browsers send Last-Event-ID when they reconnect on their own; since
+	// lets a new EventSource resume too
+	var since *uint64
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("since")
+	}
+	if raw != "" {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			respondError(c, 400, CodeInvalidRequest, "Last-Event-ID and since must be a non-negative integer")
+			return
+		}
+		since = &seq
+	}
+
+	var sub types.WebSocketSubscription
+	if events := c.Query("events"); events != "" {
+		sub.Events = strings.Split(events, ",")
+	}
+	sub.MinSeverity = c.Query("min_severity")
+	filter, err := newEventFilter(sub)
+	if err != nil {
+		respondError(c, 400, CodeInvalidRequest, "Invalid events or min_severity: "+err.Error())
+		return
+	}
+
+	client := newSubscriber(nil)
+	client.subscribe(filter)
+	defer client.close(0, "")
+
+	replayed, err := h.wsManager.RegisterClient(scanID, client, since, func() interface{} {
+		if status, err := h.orchestrator.GetScanStatus(scanID); err == nil {
+			return status
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, 429, CodeQuotaExceeded, "Too many connections for this scan")
+		return
+	}
+	defer h.wsManager.UnregisterClient(scanID, client)
+
+	requestLog(c).Info("Event stream client connected", "scan_id", scanID, "replayed", replayed)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no") // nginx would hold events back
+	c.Status(200)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-client.ready:
+			for _, message := range client.take() {
+				if err := writeEvent(c, message); err != nil {
+					requestLog(c).Warn("Failed to write event", "scan_id", scanID, "error", err)
+					return
+				}
+			}
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-client.closed:
+			// Behind for too long; the client reconnects and catches up
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes a broadcast as an event named after its type, with its
+// data as JSON
+func writeEvent(c *gin.Context, message types.WebSocketMessage) error {
+	data, err := json.Marshal(message.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", message.Seq, message.Type, data)
+	return err
+}
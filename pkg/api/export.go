@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/export"
+	"nuclei-distributed/pkg/types"
+)
+
+// writeResultsCSV streams results to the client as a CSV download
+func writeResultsCSV(c *gin.Context, filename string, results []types.ScanResult) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	if err := export.WriteCSV(c.Writer, results); err != nil {
+		requestLog(c).Warn("Failed to write CSV export", "error", err)
+	}
+}
+
+// writeResultsSARIF sends results to the client as a SARIF 2.1.0 log
+func writeResultsSARIF(c *gin.Context, filename string, results []types.ScanResult) {
+	c.Header("Content-Type", "application/sarif+json")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	if err := export.WriteSARIF(c.Writer, results); err != nil {
+		requestLog(c).Warn("Failed to write SARIF export", "error", err)
+	}
+}
+
+// writeResultsJSONL streams results to the client as newline-delimited JSON
+func writeResultsJSONL(c *gin.Context, filename string, results []types.ScanResult) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	if err := export.WriteJSONL(c.Writer, results); err != nil {
+		requestLog(c).Warn("Failed to write JSONL export", "error", err)
+	}
+}
+
+// writeScanLog sends a scan's log as a download of newline-delimited JSON,
+// one entry per line
+func writeScanLog(c *gin.Context, filename string, log *types.ScanLog) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	encoder := json.NewEncoder(c.Writer)
+	for _, entry := range log.Entries {
+		if err := encoder.Encode(entry); err != nil {
+			requestLog(c).Warn("Failed to write scan log", "error", err)
+			return
+		}
+	}
+}
+
+// writeUncoveredText sends the targets of a scan that were not scanned as a
+// download, one per line, ready to be scanned again
+func writeUncoveredText(c *gin.Context, filename string, targets []types.UncoveredTarget) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	for _, target := range targets {
+		if _, err := c.Writer.WriteString(target.Target + "\n"); err != nil {
+			requestLog(c).Warn("Failed to write uncovered targets", "error", err)
+			return
+		}
+	}
+}
+
+// writeUncoveredCSV sends the targets of a scan that were not scanned as a
+// CSV download with their state and worker
+func writeUncoveredCSV(c *gin.Context, filename string, targets []types.UncoveredTarget) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(200)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"target", "state", "worker_id"})
+	for _, target := range targets {
+		writer.Write([]string{target.Target, target.State, target.WorkerID})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		requestLog(c).Warn("Failed to write uncovered targets", "error", err)
+	}
+}
+
+// GetReport renders a self-contained HTML report of a scan's findings
+func (h *Handler) GetReport(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	filter, err := parseResultFilter(c, false)
+	if err != nil {
+		respondError(c, 400, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	page, err := h.orchestrator.QueryResults(scanID, filter)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	record, err := h.orchestrator.GetScanRecord(scanID)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	meta := export.ReportMeta{
+		ScanID:         scanID,
+		Status:         record.Status,
+		TotalDomains:   record.TotalDomains,
+		ScannedDomains: record.ScannedDomains,
+		Workers:        record.Workers,
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(200)
+
+	if err := export.WriteHTMLReport(c.Writer, meta, page.Results); err != nil {
+		requestLog(c).Warn("Failed to write HTML report", "scan_id", scanID, "error", err)
+	}
+}
+
+// GetArchive streams a zip of everything about a scan: results, report,
+// worker logs, the redacted scan config and a manifest
+func (h *Handler) GetArchive(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	archive, err := h.orchestrator.GetScanArchive(scanID)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=scan_"+scanID+".zip")
+	c.Status(200)
+
+	if err := h.orchestrator.WriteArchive(c.Writer, archive); err != nil {
+		requestLog(c).Warn("Failed to write archive", "scan_id", scanID, "error", err)
+	}
+}
+
+// GetArtifacts returns presigned download links for a completed scan's
+// archived results and report
+func (h *Handler) GetArtifacts(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	links, err := h.orchestrator.ArtifactLinks(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read artifacts")
+		return
+	}
+
+	c.JSON(200, gin.H{"artifacts": links})
+}
+
+// DiffScans compares the findings of two scans, as JSON or CSV
+func (h *Handler) DiffScans(c *gin.Context) {
+	baseID := c.Query("base")
+	headID := c.Query("head")
+	if baseID == "" || headID == "" {
+		respondError(c, 400, CodeInvalidRequest, "base and head scan IDs are required")
+		return
+	}
+
+	format := resultFormat(c)
+	if format != "json" && format != "csv" {
+		respondError(c, 400, CodeInvalidRequest, "Unsupported format "+format)
+		return
+	}
+
+	diff, err := h.orchestrator.DiffScans(baseID, headID)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename=scan_diff.csv")
+		c.Status(200)
+		if err := export.WriteDiffCSV(c.Writer, diff); err != nil {
+			requestLog(c).Warn("Failed to write diff CSV", "error", err)
+		}
+		return
+	}
+
+	c.JSON(200, diff)
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,32 +13,131 @@ import (
 	"github.com/google/uuid"
 	"nuclei-distributed/pkg/orchestrator"
 	"nuclei-distributed/pkg/types"
+	"nuclei-distributed/pkg/version"
 )
 
+// Config holds the API server's settings
+type Config struct {
+	// APIKeys are accepted on the management endpoints; several may be
+	// active at once so keys can be rotated
+	APIKeys []string
+	// AdminAPIKeys are also accepted on the admin endpoints, which act on
+	// the DigitalOcean account rather than single scans
+	AdminAPIKeys []string
+	// MaxUploadBytes is the largest targets file accepted by the upload
+	// endpoint
+	MaxUploadBytes int64
+	// MaxBodyBytes is the largest JSON request body accepted
+	MaxBodyBytes int64
+	// MaxTargets is the number of targets a scan may be submitted with
+	MaxTargets int
+	// MaxDroplets is the largest droplet count a scan may ask for
+	MaxDroplets int
+	// ScanRateLimit is the number of scans a client IP may start per minute,
+	// after a burst of ScanBurst; zero disables the limit
+	ScanRateLimit float64
+	ScanBurst     int
+	// AllowedOrigins may call the API from browsers on other origins;
+	// AnyOrigin allows all of them
+	AllowedOrigins []string
+	// EnablePprof mounts the pprof profiles and /debug/state for admin keys
+	EnablePprof bool
+	// LongPollTimeout is how long a status wait is held open before it is
+	// answered unchanged
+	LongPollTimeout time.Duration
+	// MaxClientsPerScan is the number of WebSockets and event streams a scan
+	// may have open at once
+	MaxClientsPerScan int
+	// Tracing traces every request, once tracing has been set up
+	Tracing bool
+}
+
 type Handler struct {
 	orchestrator *orchestrator.Orchestrator
 	wsManager    *WebSocketManager
+	apiKeys      [][]byte
+	adminKeys    [][]byte
+	config       Config
+	scanLimiter  *rateLimiter // nil when scans are not rate limited
+	origins      *originPolicy
+	startedAt    string // tells ETags of earlier server runs apart
 }
 
-func NewHandler(orch *orchestrator.Orchestrator) *Handler {
-	return &Handler{
+func NewHandler(orch *orchestrator.Orchestrator, config Config) *Handler {
+	// Admin keys work on the management endpoints as well
+	var keys, adminKeys [][]byte
+	for _, key := range config.APIKeys {
+		keys = append(keys, []byte(key))
+	}
+	for _, key := range config.AdminAPIKeys {
+		keys = append(keys, []byte(key))
+		adminKeys = append(adminKeys, []byte(key))
+	}
+	if config.MaxUploadBytes <= 0 {
+		config.MaxUploadBytes = DefaultMaxUploadBytes
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if config.MaxTargets <= 0 {
+		config.MaxTargets = DefaultMaxTargets
+	}
+	if config.MaxDroplets <= 0 {
+		config.MaxDroplets = DefaultMaxDroplets
+	}
+	if config.LongPollTimeout <= 0 {
+		config.LongPollTimeout = DefaultLongPollTimeout
+	}
+	if config.MaxClientsPerScan <= 0 {
+		config.MaxClientsPerScan = DefaultMaxClientsPerScan
+	}
+	var scanLimiter *rateLimiter
+	if config.ScanRateLimit > 0 {
+		scanLimiter = newRateLimiter(config.ScanRateLimit, config.ScanBurst)
+	}
+	origins := newOriginPolicy(config.AllowedOrigins)
+	wsManager := NewWebSocketManager(orch.GetScanStatus, config.MaxClientsPerScan)
+	wsManager.upgrader.CheckOrigin = origins.checkWebSocket
+	orch.OnScanEvent(func(scanID, event string, data interface{}) {
+		wsManager.BroadcastToScan(scanID, types.WebSocketMessage{Type: event, Data: data})
+	})
+	// Broadcasts reach the clients of every replica
+	wsManager.relay = orch.PublishBroadcast
+	orch.OnBroadcast(wsManager.Relayed)
+	h := &Handler{
 		orchestrator: orch,
-		wsManager:    NewWebSocketManager(),
+		wsManager:    wsManager,
+		apiKeys:      keys,
+		adminKeys:    adminKeys,
+		config:       config,
+		scanLimiter:  scanLimiter,
+		origins:      origins,
+		startedAt:    strconv.FormatInt(time.Now().UnixNano(), 36),
 	}
+	orch.OnWorkerMessage(h.takeWorkerMessage)
+	return h
 }
 
 // StartScan handles the scan start request
 func (h *Handler) StartScan(c *gin.Context) {
 	var req types.ScanRequest
-	if err := c.BindJSON(&req); err != nil {
-		log.Printf("Error binding request: %v", err)
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c).Warn("Invalid scan request", "error", err)
+		bindingError(c, err)
 		return
 	}
+	// Only the rerun endpoint links scans
+	req.RerunOf = ""
 
+	h.startScan(c, &req, nil)
+}
+
+// startScan cleans a scan request's domains and starts it. extra is merged
+// into the response.
+func (h *Handler) startScan(c *gin.Context, req *types.ScanRequest, extra gin.H) {
 	// Validate request
 	if len(req.Domains) == 0 {
-		c.JSON(400, gin.H{"error": "No domains provided"})
+		respondError(c, 400, CodeInvalidDomain, "No domains provided")
 		return
 	}
 
@@ -48,28 +151,89 @@ func (h *Handler) StartScan(c *gin.Context) {
 	}
 
 	if len(cleanDomains) == 0 {
-		c.JSON(400, gin.H{"error": "No valid domains provided"})
+		respondError(c, 400, CodeInvalidDomain, "No valid domains provided")
+		return
+	}
+	req.Domains = cleanDomains
+	if !h.checkScanLimits(c, req) {
 		return
 	}
 
 	// Generate scan ID
 	req.ID = uuid.New().String()
-	req.Domains = cleanDomains
+	c.Set(auditScanIDKey, req.ID)
+	auditSummary(c, "%d domains, %d droplets", len(req.Domains), req.Droplets)
 
-	log.Printf("Starting scan %s with %d domains and %d droplets", req.ID, len(req.Domains), req.Droplets)
+	requestLog(c).Info("Starting scan", "scan_id", req.ID, "domains", len(req.Domains), "droplets", req.Droplets)
 
 	// Start the scan
-	if err := h.orchestrator.StartScan(c.Request.Context(), &req); err != nil {
-		log.Printf("Error starting scan: %v", err)
-		c.JSON(500, gin.H{"error": err.Error()})
+	plan, err := h.orchestrator.StartScan(c.Request.Context(), req)
+	if err != nil {
+		orchestratorError(c, err, "Failed to start scan")
 		return
 	}
+	if status, err := h.orchestrator.GetScanStatus(req.ID); err == nil {
+		h.wsManager.BroadcastDashboard(types.WebSocketMessage{Type: "scan_started", Data: scanEvent(status)})
+	}
 
-	c.JSON(200, gin.H{
+	response := gin.H{
 		"scan_id": req.ID,
 		"message": "Scan started successfully",
 		"domains_count": len(req.Domains),
-	})
+		"plan": plan,
+	}
+	if req.Expand {
+		response["message"] = "Scan started, droplets are planned once its subdomains are enumerated"
+	} else if req.Preflight {
+		response["message"] = "Scan started, droplets are planned once preflight has checked its targets"
+	}
+	if plan.Targets != nil {
+		response["accepted_count"] = plan.Targets.Accepted
+		response["duplicate_count"] = plan.Targets.Duplicates
+		response["expanded_count"] = plan.Targets.Expanded
+		response["rejected_count"] = plan.Targets.RejectedCount
+		response["rejected"] = plan.Targets.Rejected
+		response["blocked_count"] = plan.Targets.BlockedCount
+		response["blocked"] = plan.Targets.Blocked
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(200, response)
+}
+
+// GetScanConfig returns the settings and targets a scan ran with, secrets
+// redacted
+func (h *Handler) GetScanConfig(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	config, err := h.orchestrator.ScanConfig(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan config")
+		return
+	}
+
+	c.JSON(200, config)
+}
+
+// RerunScan starts a new scan with the targets and settings of an earlier
+// one. The body is optional and overrides the droplet count or templates.
+func (h *Handler) RerunScan(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	var overrides types.RerunRequest
+	if err := c.ShouldBindJSON(&overrides); err != nil && !errors.Is(err, io.EOF) {
+		bindingError(c, err)
+		return
+	}
+
+	req, err := h.orchestrator.RerunRequest(scanID, overrides)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan config")
+		return
+	}
+
+	h.startScan(c, req, gin.H{"rerun_of": scanID})
 }
 
 // GetScanStatus returns the current status of a scan
@@ -78,42 +242,232 @@ func (h *Handler) GetScanStatus(c *gin.Context) {
 	
 	status, err := h.orchestrator.GetScanStatus(scanID)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Scan not found"})
+		orchestratorError(c, err, "Failed to read scan")
+		return
+	}
+	if notModified(c, h.scanETag(scanID, status.Revision, "status")) {
 		return
 	}
 
 	c.JSON(200, status)
 }
 
+// ListScans returns the stored record of every scan, newest first
+func (h *Handler) ListScans(c *gin.Context) {
+	scans, err := h.orchestrator.ListScans()
+	if err != nil {
+		orchestratorError(c, err, "Failed to list scans")
+		return
+	}
+
+	c.JSON(200, gin.H{"scans": scans})
+}
+
+// GetDeadLetters returns the findings OpenSearch forwarding gave up on
+func (h *Handler) GetDeadLetters(c *gin.Context) {
+	deadLetters, err := h.orchestrator.ForwarderDeadLetters()
+	if err != nil {
+		orchestratorError(c, err, "Failed to read dead letters")
+		return
+	}
+
+	c.JSON(200, gin.H{"deadLetters": deadLetters})
+}
+
+// GetThroughputStats returns the throughput of past scans that plans use
+func (h *Handler) GetThroughputStats(c *gin.Context) {
+	profiles, err := h.orchestrator.ThroughputProfiles(c.Request.Context())
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan throughput")
+		return
+	}
+
+	c.JSON(200, gin.H{"profiles": profiles})
+}
+
+// GetCostStats returns what scans' droplets cost over the last days, by day
+// and by scan
+func (h *Handler) GetCostStats(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 || days > orchestrator.MaxCostDays {
+		respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("days must be between 1 and %d", orchestrator.MaxCostDays))
+		return
+	}
+
+	stats, err := h.orchestrator.CostStats(days)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan costs")
+		return
+	}
+
+	c.JSON(200, stats)
+}
+
 // ReceiveResults handles results from worker droplets
 func (h *Handler) ReceiveResults(c *gin.Context) {
 	scanID := c.Param("scanId")
 	workerID := c.Param("workerId")
 
 	var result types.ScanResult
-	if err := c.BindJSON(&result); err != nil {
-		log.Printf("Error binding result: %v", err)
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&result); err != nil {
+		requestLog(c).Warn("Invalid result", "scan_id", scanID, "worker_id", workerID, "error", err)
+		bindingError(c, err)
+		return
+	}
+
+	status, err := h.storeResult(requestLog(c), scanID, workerID, result)
+	if err != nil {
+		if errors.Is(err, orchestrator.ErrScanNotFound) || errors.Is(err, orchestrator.ErrInvalidResult) {
+			requestLog(c).Warn("Rejected result", "scan_id", scanID, "worker_id", workerID, "error", err)
+			orchestratorError(c, err, "Failed to store result")
+			return
+		}
+		// Workers retry failed posts, so a store outage does not lose results
+		requestLog(c).Error("Failed to store result", "scan_id", scanID, "worker_id", workerID, "error", err)
+		respondError(c, 503, CodeUnavailable, "Failed to store result")
 		return
 	}
 
+	c.JSON(200, gin.H{"status": status})
+}
+
+// storeResult stores a worker's result and queues it for live clients. It
+// returns how the result was taken: received, duplicate, or blocked, which
+// is acknowledged all the same so the worker does not retry it.
+func (h *Handler) storeResult(log *slog.Logger, scanID, workerID string, result types.ScanResult) (string, error) {
 	// Set metadata
 	result.Timestamp = time.Now()
 	result.WorkerID = workerID
 
-	// Add result to orchestrator
-	h.orchestrator.AddResult(scanID, result)
+	// Add result to orchestrator, duplicates are counted but not broadcast
+	stored, err := h.orchestrator.AddResult(scanID, result)
+	if errors.Is(err, orchestrator.ErrResultBlocked) {
+		return "blocked", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if stored == nil {
+		return "duplicate", nil
+	}
+
+	// Broadcast to WebSocket clients in the next results batch
+	h.wsManager.BroadcastResult(scanID, stored)
+
+	// Findings stay out of info logs, only their IDs are logged there
+	log.Debug("Received result", "scan_id", scanID, "worker_id", workerID, "result_id", stored.ID,
+		"host", stored.Host, "template", stored.Template)
+	return "received", nil
+}
+
+// ReceiveLogs handles batched log entries from worker droplets
+func (h *Handler) ReceiveLogs(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	var logs []types.Log
+	if err := c.ShouldBindJSON(&logs); err != nil {
+		requestLog(c).Warn("Invalid worker logs", "scan_id", scanID, "worker_id", workerID, "error", err)
+		bindingError(c, err)
+		return
+	}
+
+	// Set metadata
+	now := time.Now()
+	for i := range logs {
+		logs[i].WorkerID = workerID
+		if logs[i].Timestamp.IsZero() {
+			logs[i].Timestamp = now
+		}
+		if logs[i].Type == "" {
+			logs[i].Type = "info"
+		}
+	}
+
+	h.orchestrator.AddWorkerLogs(scanID, workerID, logs)
+
+	// Only errors and restarts are pushed to WebSocket clients, the rest is fetched on demand
+	for _, entry := range logs {
+		if entry.Type == "error" || entry.Type == "restart" {
+			message := types.WebSocketMessage{
+				Type: "worker_log",
+				Data: entry,
+			}
+			h.wsManager.BroadcastToScan(scanID, message)
+		}
+	}
+
+	c.JSON(200, gin.H{"status": "received", "count": len(logs)})
+}
+
+// GetWorkerLogs returns a page of logs for a single worker
+func (h *Handler) GetWorkerLogs(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, 400, CodeInvalidRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		respondError(c, 400, CodeInvalidRequest, "limit must be between 1 and 1000")
+		return
+	}
+
+	page, err := h.orchestrator.GetWorkerLogs(scanID, workerID, offset, limit)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read worker logs")
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// GetOrchestratorLog downloads what the server logged about a scan and the
+// errors its workers logged. X-Log-Dropped counts the oldest entries the
+// log no longer holds.
+func (h *Handler) GetOrchestratorLog(c *gin.Context) {
+	scanID := c.Param("scanId")
 
-	// Broadcast to WebSocket clients
-	message := types.WebSocketMessage{
-		Type: "new_result",
-		Data: result,
+	log, err := h.orchestrator.ScanLog(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan log")
+		return
 	}
-	h.wsManager.BroadcastToScan(scanID, message)
 
-	log.Printf("Received result from %s: %s - %s", workerID, result.Host, result.Template)
+	c.Header("X-Log-Dropped", strconv.Itoa(log.Dropped))
+	writeScanLog(c, "scan_"+scanID+"_orchestrator.log", log)
+}
+
+// heartbeatRequest is a worker's progress report
+type heartbeatRequest struct {
+	Progress       float64 `json:"progress"`
+	HostsCompleted int     `json:"hosts_completed"`
+	HostsTotal     int     `json:"hosts_total"`
+	HostsAlive     int     `json:"hosts_alive"`
+	HostsDead      int     `json:"hosts_dead"`
+	CurrentDomain  string  `json:"current_domain"`
+	Message        string  `json:"message"`
+	// Telemetry is the worker's resource sample, sent with every heartbeat
+	// and on its own before nuclei reports progress
+	Telemetry *heartbeatTelemetry `json:"telemetry"`
+}
 
-	c.JSON(200, gin.H{"status": "received"})
+// heartbeatTelemetry is a worker's resource sample and nuclei counters
+type heartbeatTelemetry struct {
+	Load1       float64 `json:"load1"`
+	CPUs        int     `json:"cpus"`
+	MemUsedMB   int     `json:"mem_used_mb"`
+	MemTotalMB  int     `json:"mem_total_mb"`
+	DiskFreeMB  int     `json:"disk_free_mb"`
+	DiskTotalMB int     `json:"disk_total_mb"`
+	NucleiRSSMB int     `json:"nuclei_rss_mb"`
+	Errors      int64   `json:"errors"`
+	RPS         float64 `json:"rps"`
+	Findings    int     `json:"findings"`
 }
 
 // WorkerHeartbeat handles heartbeat from workers
@@ -121,19 +475,50 @@ func (h *Handler) WorkerHeartbeat(c *gin.Context) {
 	scanID := c.Param("scanId")
 	workerID := c.Param("workerId")
 
-	var heartbeat struct {
-		Progress      float64 `json:"progress"`
-		CurrentDomain string  `json:"current_domain"`
-		Message       string  `json:"message"`
+	var heartbeat heartbeatRequest
+	if err := c.ShouldBindJSON(&heartbeat); err != nil {
+		bindingError(c, err)
+		return
 	}
 
-	if err := c.BindJSON(&heartbeat); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+	h.recordHeartbeat(scanID, workerID, heartbeat)
+	c.JSON(200, gin.H{"status": "updated"})
+}
+
+// recordHeartbeat updates a worker's progress and telemetry and broadcasts
+// the scan's status
+func (h *Handler) recordHeartbeat(scanID, workerID string, heartbeat heartbeatRequest) {
+	// Workers without nuclei stats only send a percentage
+	if heartbeat.HostsTotal == 0 && heartbeat.Progress > 0 {
+		heartbeat.HostsTotal = 100
+		heartbeat.HostsCompleted = int(heartbeat.Progress)
+	}
+
+	if sample := heartbeat.Telemetry; sample != nil {
+		h.orchestrator.RecordWorkerTelemetry(scanID, workerID, types.WorkerTelemetry{
+			Timestamp:         time.Now().UTC(),
+			Load1:             sample.Load1,
+			CPUs:              sample.CPUs,
+			MemoryUsedMB:      sample.MemUsedMB,
+			MemoryTotalMB:     sample.MemTotalMB,
+			DiskFreeMB:        sample.DiskFreeMB,
+			DiskTotalMB:       sample.DiskTotalMB,
+			NucleiRSSMB:       sample.NucleiRSSMB,
+			Errors:            sample.Errors,
+			RequestsPerSecond: sample.RPS,
+			Findings:          sample.Findings,
+		})
+	}
+
+	// The first heartbeat of a probing worker carries the httpx results
+	if heartbeat.HostsAlive+heartbeat.HostsDead > 0 {
+		h.orchestrator.SetWorkerAlive(scanID, workerID, heartbeat.HostsAlive)
 	}
 
-	// Update worker progress
-	h.orchestrator.UpdateWorkerProgress(scanID, workerID, heartbeat.Progress, heartbeat.CurrentDomain)
+	// Update worker progress, unless the heartbeat only carried telemetry
+	if heartbeat.HostsTotal > 0 || heartbeat.HostsAlive+heartbeat.HostsDead > 0 || heartbeat.Telemetry == nil {
+		h.orchestrator.UpdateWorkerProgress(scanID, workerID, heartbeat.HostsCompleted, heartbeat.HostsTotal, heartbeat.CurrentDomain)
+	}
 
 	// Broadcast status update
 	status, _ := h.orchestrator.GetScanStatus(scanID)
@@ -144,84 +529,848 @@ func (h *Handler) WorkerHeartbeat(c *gin.Context) {
 		}
 		h.wsManager.BroadcastToScan(scanID, message)
 	}
+}
 
-	c.JSON(200, gin.H{"status": "updated"})
+// completeRequest is how a worker's scan ended; the body is optional
+type completeRequest struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
 }
 
-// CompleteWorker handles worker completion notification
+// CompleteWorker handles worker completion notification. Workers whose nuclei
+// run gave up report {"status": "failed", "message": "..."}.
 func (h *Handler) CompleteWorker(c *gin.Context) {
 	scanID := c.Param("scanId")
 	workerID := c.Param("workerId")
 
-	log.Printf("Worker %s completed for scan %s", workerID, scanID)
+	var report completeRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&report); err != nil {
+			bindingError(c, err)
+			return
+		}
+	}
+
+	h.completeWorker(requestLog(c), scanID, workerID, report)
+	c.JSON(200, gin.H{"status": "completed"})
+}
 
-	// Update worker status to completed
-	h.orchestrator.UpdateWorkerProgress(scanID, workerID, 100.0, "completed")
+// completeWorker records how a worker's scan ended and, once every worker's
+// has, completes the scan
+func (h *Handler) completeWorker(log *slog.Logger, scanID, workerID string, report completeRequest) {
+	failure := ""
+	if report.Status == "failed" {
+		failure = report.Message
+		if failure == "" {
+			failure = "worker reported failure"
+		}
+		log.Warn("Worker failed", "scan_id", scanID, "worker_id", workerID, "reason", failure)
+	} else {
+		log.Info("Worker completed", "scan_id", scanID, "worker_id", workerID)
+	}
 
-	// Check if all workers are complete
-	status, err := h.orchestrator.GetScanStatus(scanID)
-	if err == nil {
-		allComplete := true
-		for _, worker := range status.ActiveDroplets {
-			if worker.Progress < 100.0 {
-				allComplete = false
-				break
-			}
+	// Update worker status and check if all workers are complete
+	done := h.orchestrator.CompleteWorker(scanID, workerID, failure)
+	if failure != "" {
+		if status, err := h.orchestrator.GetScanStatus(scanID); err == nil {
+			event := scanEvent(status)
+			event.Failure = &types.WorkerFailure{WorkerID: workerID, Reason: failure}
+			h.wsManager.BroadcastDashboard(types.WebSocketMessage{Type: "worker_failed", Data: event})
 		}
+	}
+	if done {
+		log.Info("All workers completed", "scan_id", scanID)
 
-		if allComplete {
-			log.Printf("All workers completed for scan %s", scanID)
-			status.Status = "completed"
-			
+		if status, err := h.orchestrator.GetScanStatus(scanID); err == nil {
 			// Broadcast completion
 			message := types.WebSocketMessage{
 				Type: "scan_complete",
 				Data: status,
 			}
 			h.wsManager.BroadcastToScan(scanID, message)
-
-			// Schedule cleanup
-			go func() {
-				time.Sleep(30 * time.Second) // Wait 30 seconds before cleanup
-				h.orchestrator.CleanupScan(scanID)
-			}()
 		}
+
+		// Schedule cleanup
+		go func() {
+			time.Sleep(30 * time.Second) // Wait 30 seconds before cleanup
+			h.orchestrator.CleanupScan(scanID)
+			h.wsManager.ForgetScan(scanID)
+		}()
 	}
+}
 
-	c.JSON(200, gin.H{"status": "completed"})
+// GetWebhooks returns delivery status for a scan's webhooks
+func (h *Handler) GetWebhooks(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	webhooks, err := h.orchestrator.GetWebhookStatus(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read webhooks")
+		return
+	}
+
+	c.JSON(200, gin.H{"webhooks": webhooks})
 }
 
-// GetResults returns all results for a scan
-func (h *Handler) GetResults(c *gin.Context) {
+// secretsRequest holds template variables for a scan's workers
+type secretsRequest struct {
+	Secrets              map[string]string `json:"secrets" binding:"required"`
+	InvalidateAfterFetch bool              `json:"invalidate_after_fetch"`
+}
+
+// SetSecrets stores template variables for a scan's workers
+func (h *Handler) SetSecrets(c *gin.Context) {
 	scanID := c.Param("scanId")
-	
-	status, err := h.orchestrator.GetScanStatus(scanID)
+
+	var req secretsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	if err := h.orchestrator.SetScanSecrets(scanID, req.Secrets, req.InvalidateAfterFetch); err != nil {
+		orchestratorError(c, err, "Failed to store secrets")
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "stored", "count": len(req.Secrets)})
+}
+
+// FetchSecrets hands a worker its scan's template variables
+func (h *Handler) FetchSecrets(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	secrets, err := h.orchestrator.FetchWorkerSecrets(scanID, workerID)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Scan not found"})
+		orchestratorError(c, err, "Failed to fetch secrets")
 		return
 	}
 
-	// Return results as JSON or CSV based on Accept header
-	accept := c.GetHeader("Accept")
-	if strings.Contains(accept, "text/csv") {
-		c.Header("Content-Type", "text/csv")
-		c.Header("Content-Disposition", "attachment; filename=scan_results.csv")
-		
-		// Write CSV headers
-		c.String(200, "Host,Template,Severity,Match,Timestamp,WorkerID\n")
-		
-		// Write results
-		for _, result := range status.Results {
-			c.String(200, "%s,%s,%s,%s,%s,%s\n",
-				result.Host,
-				result.Template,
-				result.Severity,
-				result.Match,
-				result.Timestamp.Format(time.RFC3339),
-				result.WorkerID,
-			)
-		}
-	} else {
-		c.JSON(200, status.Results)
+	c.JSON(200, gin.H{"secrets": secrets})
+}
+
+// FetchConfig serves a scan's nuclei config file to its workers
+func (h *Handler) FetchConfig(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	config, err := h.orchestrator.GetScanConfigYAML(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read config")
+		return
+	}
+
+	c.Data(200, "application/yaml", []byte(config))
+}
+
+// PullTargets hands a worker the targets added or rebalanced to it since it
+// started
+func (h *Handler) PullTargets(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	targets, running := h.orchestrator.PullTargets(scanID, workerID)
+	c.JSON(200, gin.H{"targets": targets, "scan_running": running})
+}
+
+// LeaseTargets hands a worker of a pull scan its next batch of targets,
+// count of them or the scan's batch size
+func (h *Handler) LeaseTargets(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	count, err := strconv.Atoi(c.DefaultQuery("count", "0"))
+	if err != nil || count < 0 || count > orchestrator.MaxBatchSize {
+		respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("count must be between 1 and %d", orchestrator.MaxBatchSize))
+		return
+	}
+
+	batch, err := h.orchestrator.LeaseTargets(scanID, workerID, count)
+	if err != nil {
+		orchestratorError(c, err, "Failed to lease targets")
+		return
+	}
+
+	c.JSON(200, batch)
+}
+
+// ConfirmBatch records that a worker scanned a batch it leased
+func (h *Handler) ConfirmBatch(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	if err := h.orchestrator.ConfirmBatch(scanID, workerID, c.Param("batchId")); err != nil {
+		orchestratorError(c, err, "Failed to confirm batch")
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "confirmed"})
+}
+
+// segmentRequest is a segment of targets a worker of a static scan finished,
+// with the number it still has queued
+type segmentRequest struct {
+	Targets []string `json:"targets"`
+	Queued  int      `json:"queued"`
+}
+
+// ReportTargetsDone records a segment a worker finished and tells it how
+// many queued targets to hand back for idle workers
+func (h *Handler) ReportTargetsDone(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	var req segmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
 	}
+
+	release, err := h.orchestrator.ReportTargetsDone(scanID, workerID, req.Targets, req.Queued)
+	if err != nil {
+		orchestratorError(c, err, "Failed to record targets")
+		return
+	}
+
+	c.JSON(200, gin.H{"release": release})
+}
+
+// releaseTargetsRequest holds the targets a worker hands back
+type releaseTargetsRequest struct {
+	Targets []string `json:"targets" binding:"required"`
+}
+
+// ReleaseTargets moves the targets a slow worker handed back to idle workers
+func (h *Handler) ReleaseTargets(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	var req releaseTargetsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	rebalance, err := h.orchestrator.ReleaseTargets(scanID, workerID, req.Targets)
+	if err != nil {
+		orchestratorError(c, err, "Failed to hand back targets")
+		return
+	}
+
+	c.JSON(200, rebalance)
+}
+
+// GetProblemHosts returns the hosts nuclei skipped after repeated errors
+func (h *Handler) GetProblemHosts(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	hosts, err := h.orchestrator.GetProblemHosts(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read problem hosts")
+		return
+	}
+
+	c.JSON(200, gin.H{"hosts": hosts, "count": len(hosts)})
+}
+
+// GetCoverage reports which of a scan's targets were scanned, with the list
+// of those that were not, or that list alone as a text or CSV download
+func (h *Handler) GetCoverage(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	format := resultFormat(c)
+	switch format {
+	case "json", "txt", "csv":
+	default:
+		respondError(c, 400, CodeInvalidRequest, "Unsupported format "+format)
+		return
+	}
+	states := make(map[string]bool)
+	for _, state := range strings.Split(c.Query("state"), ",") {
+		switch state = strings.TrimSpace(state); state {
+		case "":
+		case orchestrator.TargetPending, orchestrator.TargetAssigned, orchestrator.TargetErrored, orchestrator.TargetSkipped,
+			orchestrator.TargetDead:
+			states[state] = true
+		default:
+			respondError(c, 400, CodeInvalidRequest, "state must be pending, assigned, errored, skipped or dead")
+			return
+		}
+	}
+
+	report, err := h.orchestrator.GetCoverage(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read coverage")
+		return
+	}
+	if len(states) > 0 {
+		uncovered := report.Uncovered[:0]
+		for _, target := range report.Uncovered {
+			if states[target.State] {
+				uncovered = append(uncovered, target)
+			}
+		}
+		report.Uncovered = uncovered
+	}
+
+	switch format {
+	case "txt":
+		writeUncoveredText(c, "scan_"+scanID+"_uncovered.txt", report.Uncovered)
+	case "csv":
+		writeUncoveredCSV(c, "scan_"+scanID+"_uncovered.csv", report.Uncovered)
+	default:
+		c.JSON(200, report)
+	}
+}
+
+// parseResultFilter reads the result filter query parameters. Pagination is
+// only applied when paginate is true.
+func parseResultFilter(c *gin.Context, paginate bool) (types.ResultFilter, error) {
+	filter := types.ResultFilter{
+		Template:          c.Query("template"),
+		Host:              c.Query("host"),
+		IncludeDuplicates: c.Query("include_duplicates") == "true",
+
+		ExcludeFalsePositives: c.Query("exclude_false_positives") == "true",
+	}
+
+	if severity := c.Query("severity"); severity != "" {
+		for _, s := range strings.Split(severity, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				filter.Severities = append(filter.Severities, s)
+			}
+		}
+	}
+
+	// since takes a timestamp, or the nextCursor of an earlier response to
+	// fetch only the results stored after it
+	if since := c.Query("since"); since != "" {
+		if parsed, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = parsed
+		} else if cursor, err := strconv.ParseInt(since, 10, 64); err == nil && cursor >= 0 {
+			filter.AfterSeq = cursor
+		} else {
+			return filter, fmt.Errorf("since must be an RFC3339 timestamp or a nextCursor")
+		}
+		if filter.AfterSeq > 0 && filter.IncludeDuplicates {
+			return filter, fmt.Errorf("since cursors cannot be combined with include_duplicates")
+		}
+	}
+
+	if paginate {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			return filter, fmt.Errorf("page must be a positive integer")
+		}
+		pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "100"))
+		if err != nil || pageSize < 1 || pageSize > 1000 {
+			return filter, fmt.Errorf("page_size must be between 1 and 1000")
+		}
+		filter.Page = page
+		filter.PageSize = pageSize
+	}
+
+	return filter, nil
+}
+
+// resultsError answers a failed result query, telling unknown scans apart
+// from result store failures
+func resultsError(c *gin.Context, err error) {
+	orchestratorError(c, err, "Failed to read results")
+}
+
+// resultFormat picks the export format from ?format=, falling back to the
+// Accept header
+func resultFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return "csv"
+	case strings.Contains(accept, "application/x-ndjson"):
+		return "jsonl"
+	}
+	return "json"
+}
+
+// GetResults returns a page of results for a scan, or every matching result
+// as a JSONL, CSV or SARIF download. Running scans return the results stored
+// so far.
+func (h *Handler) GetResults(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	format := resultFormat(c)
+	switch format {
+	case "json", "jsonl", "csv", "sarif":
+	default:
+		respondError(c, 400, CodeInvalidRequest, "Unsupported format "+format)
+		return
+	}
+
+	// Downloads contain every matching result, only JSON is paginated
+	filter, err := parseResultFilter(c, format == "json")
+	if err != nil {
+		respondError(c, 400, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	// Scans the server tracks answer polls with 304 until they change. The
+	// revision is read first, so a change made meanwhile is never missed.
+	if revision, tracked := h.orchestrator.ScanRevision(scanID); tracked {
+		if notModified(c, h.scanETag(scanID, revision, format+"?"+c.Request.URL.RawQuery)) {
+			return
+		}
+	}
+
+	page, err := h.orchestrator.QueryResults(scanID, filter)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	switch format {
+	case "csv":
+		writeResultsCSV(c, "scan_results.csv", page.Results)
+		return
+	case "sarif":
+		writeResultsSARIF(c, "scan_results.sarif", page.Results)
+		return
+	case "jsonl":
+		writeResultsJSONL(c, "scan_results.jsonl", page.Results)
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// scanUpdateRequest changes a scan's settings
+type scanUpdateRequest struct {
+	Pinned *bool `json:"pinned"`
+}
+
+// UpdateScan changes a scan's settings; only pinning is supported
+func (h *Handler) UpdateScan(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	var req scanUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+	if req.Pinned == nil {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "Invalid request body",
+			Details: []types.FieldError{{Field: "pinned", Reason: "is required"}},
+		})
+		return
+	}
+	auditSummary(c, "pinned: %t", *req.Pinned)
+
+	record, err := h.orchestrator.SetPinned(scanID, *req.Pinned)
+	if err != nil {
+		orchestratorError(c, err, "Failed to update scan")
+		return
+	}
+	// The configuration is only served redacted, by GetScanConfig
+	record.Config = nil
+
+	c.JSON(200, record)
+}
+
+// AddTargets adds targets to a running scan
+func (h *Handler) AddTargets(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	var req types.AddTargetsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+	domains := make([]string, 0, len(req.Domains))
+	for _, domain := range req.Domains {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		respondError(c, 400, CodeInvalidDomain, "No valid domains provided")
+		return
+	}
+	auditSummary(c, "%d domains", len(domains))
+
+	status, err := h.orchestrator.GetScanStatus(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan")
+		return
+	}
+	if status.TotalDomains+len(domains) > h.config.MaxTargets {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: fmt.Sprintf("Too many targets, a scan may have at most %d", h.config.MaxTargets),
+			Details: []types.FieldError{{Field: "domains", Reason: fmt.Sprintf("the scan already has %d targets", status.TotalDomains)}},
+		})
+		return
+	}
+
+	added, err := h.orchestrator.AddTargets(scanID, domains)
+	if err != nil {
+		orchestratorError(c, err, "Failed to add targets")
+		return
+	}
+
+	c.JSON(200, added)
+}
+
+// RebalanceScan asks the slow workers of a running static scan to hand
+// untouched targets to workers that finished theirs
+func (h *Handler) RebalanceScan(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	pass, err := h.orchestrator.Rebalance(scanID)
+	if err != nil {
+		orchestratorError(c, err, "Failed to rebalance scan")
+		return
+	}
+
+	c.JSON(200, pass)
+}
+
+// GetHosts returns the affected assets of a scan, one entry per host
+func (h *Handler) GetHosts(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	order := c.DefaultQuery("sort", orchestrator.HostsBySeverity)
+	switch order {
+	case orchestrator.HostsBySeverity, orchestrator.HostsByCount, orchestrator.HostsByHost:
+	default:
+		respondError(c, 400, CodeInvalidRequest, "sort must be severity, count or host")
+		return
+	}
+
+	hosts, err := h.orchestrator.GetHosts(scanID, order)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"hosts": hosts})
+}
+
+// GetHostResults returns the findings on one host of a scan, with the same
+// filters and formats as GetResults
+func (h *Handler) GetHostResults(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	format := resultFormat(c)
+	switch format {
+	case "json", "jsonl", "csv", "sarif":
+	default:
+		respondError(c, 400, CodeInvalidRequest, "Unsupported format "+format)
+		return
+	}
+
+	filter, err := parseResultFilter(c, format == "json")
+	if err != nil {
+		respondError(c, 400, CodeInvalidRequest, err.Error())
+		return
+	}
+	filter.AssetHost = c.Param("host")
+
+	page, err := h.orchestrator.QueryResults(scanID, filter)
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	switch format {
+	case "csv":
+		writeResultsCSV(c, "host_results.csv", page.Results)
+		return
+	case "sarif":
+		writeResultsSARIF(c, "host_results.sarif", page.Results)
+		return
+	case "jsonl":
+		writeResultsJSONL(c, "host_results.jsonl", page.Results)
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// triageRequest is an analyst's verdict on a finding
+type triageRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// UpdateTriage records an analyst's verdict on one finding
+func (h *Handler) UpdateTriage(c *gin.Context) {
+	scanID := c.Param("scanId")
+	resultID := c.Param("resultId")
+
+	var req triageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	result, err := h.orchestrator.SetTriage(scanID, resultID, req.Status, req.Note)
+	if err != nil {
+		orchestratorError(c, err, fmt.Sprintf("Failed to save triage of result %s", resultID))
+		return
+	}
+
+	c.JSON(200, result)
+}
+
+// CreateTicket files a Jira issue for a finding and returns the finding with
+// the issue linked. A finding that already has an issue is returned as is.
+func (h *Handler) CreateTicket(c *gin.Context) {
+	scanID := c.Param("scanId")
+	resultID := c.Param("resultId")
+
+	result, created, err := h.orchestrator.CreateTicket(scanID, resultID)
+	if err != nil {
+		orchestratorError(c, err, fmt.Sprintf("Failed to file ticket for result %s", resultID))
+		return
+	}
+
+	if created {
+		c.JSON(201, result)
+		return
+	}
+	c.JSON(200, result)
+}
+
+// GetStats returns a scan's finding counts by severity and triage status
+func (h *Handler) GetStats(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	stats, err := h.orchestrator.ScanStats(scanID, c.Query("exclude_false_positives") == "true")
+	if err != nil {
+		resultsError(c, err)
+		return
+	}
+
+	c.JSON(200, stats)
+}
+
+// ListRules returns every false-positive rule with its hit count
+func (h *Handler) ListRules(c *gin.Context) {
+	rules, err := h.orchestrator.ListRules()
+	if err != nil {
+		orchestratorError(c, err, "Failed to list rules")
+		return
+	}
+
+	c.JSON(200, gin.H{"rules": rules})
+}
+
+// GetRule returns one false-positive rule
+func (h *Handler) GetRule(c *gin.Context) {
+	rule, err := h.orchestrator.GetRule(c.Param("ruleId"))
+	if err != nil {
+		ruleError(c, err)
+		return
+	}
+
+	c.JSON(200, rule)
+}
+
+// CreateRule adds a false-positive rule applied to findings received from now on
+func (h *Handler) CreateRule(c *gin.Context) {
+	var req types.FPRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	rule, err := h.orchestrator.CreateRule(req)
+	if err != nil {
+		ruleError(c, err)
+		return
+	}
+
+	c.JSON(201, rule)
+}
+
+// UpdateRule replaces a false-positive rule's template, pattern and note
+func (h *Handler) UpdateRule(c *gin.Context) {
+	var req types.FPRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	rule, err := h.orchestrator.UpdateRule(c.Param("ruleId"), req)
+	if err != nil {
+		ruleError(c, err)
+		return
+	}
+
+	c.JSON(200, rule)
+}
+
+// DeleteRule removes a false-positive rule
+func (h *Handler) DeleteRule(c *gin.Context) {
+	if err := h.orchestrator.DeleteRule(c.Param("ruleId")); err != nil {
+		ruleError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "deleted"})
+}
+
+// GetVersion returns the server's build and the API version it serves
+func (h *Handler) GetVersion(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"version":     version.Version,
+		"api_version": version.APIVersion,
+		"commit":      version.Commit,
+	})
+}
+
+// Health reports the state of the server's dependencies, with 503 while a
+// critical one is failing
+func (h *Handler) Health(c *gin.Context) {
+	report := h.orchestrator.Health(c.Request.Context())
+	status := 200
+	if report.Status != "healthy" {
+		status = 503
+	}
+	c.JSON(status, report)
+}
+
+// Ready tells load balancers whether to send traffic: 503 until state has
+// been restored from storage and while a critical dependency is failing
+func (h *Handler) Ready(c *gin.Context) {
+	ready, report := h.orchestrator.Ready(c.Request.Context())
+	if !ready {
+		c.JSON(503, gin.H{"status": "not_ready", "health": report})
+		return
+	}
+	c.JSON(200, gin.H{"status": "ready"})
+}
+
+// GetBlocklist returns every blocklist entry
+func (h *Handler) GetBlocklist(c *gin.Context) {
+	entries, err := h.orchestrator.Blocklist()
+	if err != nil {
+		orchestratorError(c, err, "Failed to list blocklist")
+		return
+	}
+
+	c.JSON(200, gin.H{"entries": entries})
+}
+
+// AddBlocklist adds entries to the blocklist, applied to scans submitted and
+// findings received from now on
+func (h *Handler) AddBlocklist(c *gin.Context) {
+	var req types.BlocklistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	added, err := h.orchestrator.AddBlocklistEntries(req.Entries)
+	if err != nil {
+		orchestratorError(c, err, "Failed to save blocklist")
+		return
+	}
+
+	c.JSON(200, gin.H{"added": added})
+}
+
+// RemoveBlocklist removes the blocklist entry given by the pattern query
+// parameter. CIDR blocks contain a slash, so the pattern is not part of the
+// path.
+func (h *Handler) RemoveBlocklist(c *gin.Context) {
+	pattern := c.Query("pattern")
+	if pattern == "" {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "pattern is required",
+			Details: []types.FieldError{{Field: "pattern", Reason: "is required"}},
+		})
+		return
+	}
+
+	if err := h.orchestrator.RemoveBlocklistEntry(pattern); err != nil {
+		orchestratorError(c, err, "Failed to save blocklist")
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "deleted"})
+}
+
+// ListDroplets lists every worker droplet in the DigitalOcean account, with
+// the hourly cost of those still running
+func (h *Handler) ListDroplets(c *gin.Context) {
+	droplets, err := h.orchestrator.WorkerDroplets(c.Request.Context())
+	if err != nil {
+		orchestratorError(c, err, "Failed to list droplets")
+		return
+	}
+
+	hourlyCost := 0.0
+	for _, droplet := range droplets {
+		hourlyCost += droplet.PriceHourly
+	}
+	c.JSON(200, gin.H{"droplets": droplets, "count": len(droplets), "hourly_cost": hourlyCost})
+}
+
+// DeleteDroplet destroys a worker droplet
+func (h *Handler) DeleteDroplet(c *gin.Context) {
+	dropletID := c.Param("dropletId")
+
+	if err := h.orchestrator.DestroyWorkerDroplet(c.Request.Context(), dropletID); err != nil {
+		orchestratorError(c, err, "Failed to destroy droplet")
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "deleted"})
+}
+
+// SelfCheck checks the server's credentials and settings again, lifting
+// read-only mode when the checks pass
+func (h *Handler) SelfCheck(c *gin.Context) {
+	c.JSON(200, h.orchestrator.SelfCheck(c.Request.Context()))
+}
+
+// GetConfig returns the settings changeable at runtime, secrets redacted
+func (h *Handler) GetConfig(c *gin.Context) {
+	config, err := h.orchestrator.ServerConfig()
+	if err != nil {
+		orchestratorError(c, err, "Failed to load configuration")
+		return
+	}
+	c.JSON(200, config)
+}
+
+// UpdateConfig changes runtime settings; scans started afterwards use them
+func (h *Handler) UpdateConfig(c *gin.Context) {
+	patch, err := c.GetRawData()
+	if err == nil && len(patch) == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		bindingError(c, err)
+		return
+	}
+
+	config, changes, err := h.orchestrator.UpdateConfig(patch)
+	if err != nil {
+		orchestratorError(c, err, "Failed to update configuration")
+		return
+	}
+	c.Set(auditChangesKey, changes)
+	c.JSON(200, config)
+}
+
+// ruleError maps false-positive rule errors onto status codes
+func ruleError(c *gin.Context, err error) {
+	orchestratorError(c, err, "Failed to save rule")
 }
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/orchestrator"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength caps keys, which are usually UUIDs
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotent makes requests carrying an Idempotency-Key safe to retry. The
+// first request with a key is handled and its successful response cached;
+// repeats with the same body get that response back with an
+// Idempotent-Replayed header, and repeats with another body a 409. Failed
+// requests release the key so they can be retried. Keys are scoped to the
+// API key the request was authenticated with.
+func (h *Handler) Idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if !printableASCII(key, maxIdempotencyKeyLength) {
+		respondError(c, 400, CodeInvalidRequest, "Idempotency-Key must be printable ASCII of at most 255 characters")
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		bindingError(c, err)
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	keyID := c.GetString(keyIDKey)
+	cached, err := h.orchestrator.ClaimIdempotencyKey(c.Request.Context(), keyID, key, fingerprint)
+	switch {
+	case errors.Is(err, orchestrator.ErrIdempotencyMismatch):
+		respondError(c, 409, CodeIdempotencyMismatch, err.Error())
+		return
+	case errors.Is(err, orchestrator.ErrIdempotencyInProgress):
+		c.Header("Retry-After", "1")
+		respondError(c, 409, CodeIdempotencyInProgress, err.Error())
+		return
+	case errors.Is(err, context.Canceled):
+		c.Abort()
+		return
+	case err != nil:
+		requestLog(c).Error("Failed to check idempotency key", "error", err)
+		respondError(c, 503, CodeUnavailable, "Failed to check idempotency key")
+		return
+	case cached != nil:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(cached.Status, cached.ContentType, cached.Body)
+		c.Abort()
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	status := recorder.Status()
+	if status < 200 || status > 299 {
+		h.orchestrator.FinishIdempotencyKey(keyID, key, fingerprint, nil)
+		return
+	}
+	h.orchestrator.FinishIdempotencyKey(keyID, key, fingerprint, &orchestrator.IdempotentResponse{
+		Status:      status,
+		ContentType: recorder.Header().Get("Content-Type"),
+		Body:        recorder.body.Bytes(),
+	})
+}
+
+// responseRecorder keeps a copy of the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotentScanStartsOnce(t *testing.T) {
+	const otherKey = "other-key"
+	r, orch, workers := newFakeServer(t, Config{APIKeys: []string{otherKey}})
+	body := `{"domains": ["example.com"], "droplets": 1}`
+	sameKey := http.Header{"Idempotency-Key": {"scan-2024-05-01"}}
+
+	// Two identical requests race for the key
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = serve(r, "POST", "/api/v1/scan", strings.NewReader(body), sameKey)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, recorder := range responses {
+		if recorder.Code != 200 {
+			t.Fatalf("request %d: POST /scan = %d: %s", i, recorder.Code, recorder.Body)
+		}
+	}
+	if responses[0].Body.String() != responses[1].Body.String() {
+		t.Errorf("the requests got different bodies:\n%s\n%s", responses[0].Body, responses[1].Body)
+	}
+	replayed := 0
+	for _, recorder := range responses {
+		if recorder.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != 1 {
+		t.Errorf("%d responses were replayed, want 1", replayed)
+	}
+
+	scans, err := orch.ListScans()
+	if err != nil || len(scans) != 1 {
+		t.Fatalf("ListScans() = %d scans, %v, want 1", len(scans), err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(workers.Workers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no worker was started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, worker := range workers.Workers() {
+		if !worker.HasTag(scans[0].ID) {
+			t.Errorf("worker %s belongs to another scan: %v", worker.Name, worker.Tags)
+		}
+	}
+	if started := len(workers.Workers()); started != 1 {
+		t.Errorf("%d workers were started, want 1", started)
+	}
+
+	// The key cannot be reused for another request
+	other := `{"domains": ["example.org"], "droplets": 1}`
+	decodeError(t, serve(r, "POST", "/api/v1/scan", strings.NewReader(other), sameKey), 409, CodeIdempotencyMismatch)
+
+	// Other API keys have keys of their own
+	otherClient := http.Header{"Authorization": {"Bearer " + otherKey}, "Idempotency-Key": sameKey["Idempotency-Key"]}
+	recorder := serve(r, "POST", "/api/v1/scan", strings.NewReader(other), otherClient)
+	if recorder.Code != 200 || recorder.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("POST /scan with another API key = %d, replayed %q: %s",
+			recorder.Code, recorder.Header().Get("Idempotent-Replayed"), recorder.Body)
+	}
+}
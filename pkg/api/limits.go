@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// DefaultMaxBodyBytes is the largest JSON request body accepted unless
+	// configured otherwise
+	DefaultMaxBodyBytes = 5 << 20
+	// DefaultMaxTargets is the number of targets a scan may be submitted
+	// with unless configured otherwise, counted before ranges are expanded
+	DefaultMaxTargets = 100000
+	// DefaultMaxDroplets is the largest droplet count a scan may ask for
+	// unless configured otherwise
+	DefaultMaxDroplets = 10
+	// DefaultScanRateLimit is the number of scans a client IP may start per
+	// minute unless configured otherwise
+	DefaultScanRateLimit = 10
+	// DefaultScanBurst is the number of scans a client IP may start at once
+	DefaultScanBurst = 5
+	// rateLimitSweep is how often idle client buckets are dropped
+	rateLimitSweep = time.Minute
+)
+
+// LimitBody refuses request bodies larger than the configured limit. Bodies
+// that do not declare their length are cut off while they are decoded, which
+// bindingError reports the same way. Multipart uploads have their own limit.
+func (h *Handler) LimitBody(c *gin.Context) {
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		c.Next()
+		return
+	}
+
+	limit := h.config.MaxBodyBytes
+	if c.Request.ContentLength > limit {
+		respondError(c, 413, CodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	c.Next()
+}
+
+// LimitScanRate refuses scans from client IPs that started too many
+// recently, telling them when to retry
+func (h *Handler) LimitScanRate(c *gin.Context) {
+	if h.scanLimiter == nil {
+		c.Next()
+		return
+	}
+
+	if wait := h.scanLimiter.take(c.ClientIP(), time.Now()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondError(c, 429, CodeQuotaExceeded, "Too many scans started, try again later")
+		return
+	}
+	c.Next()
+}
+
+// checkScanLimits refuses scans with more targets or droplets than
+// configured. It answers the request and returns false when a limit is
+// exceeded.
+func (h *Handler) checkScanLimits(c *gin.Context, req *types.ScanRequest) bool {
+	if len(req.Domains) > h.config.MaxTargets {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: fmt.Sprintf("Too many targets, a scan may have at most %d", h.config.MaxTargets),
+			Details: []types.FieldError{{Field: "domains", Reason: fmt.Sprintf("has %d targets", len(req.Domains))}},
+		})
+		return false
+	}
+	if req.Droplets < 0 || req.Droplets > h.config.MaxDroplets {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "Invalid droplet count",
+			Details: []types.FieldError{{Field: "droplets", Reason: fmt.Sprintf("must be between 0 and %d", h.config.MaxDroplets)}},
+		})
+		return false
+	}
+	return true
+}
+
+// rateLimiter keeps a token bucket per client. Buckets refill continuously
+// and are dropped once full, so idle clients cost nothing.
+type rateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// take spends a token of client's bucket. It returns zero when one was
+// available, otherwise how long until one will be.
+func (l *rateLimiter) take(client string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.swept) >= rateLimitSweep {
+		for key, bucket := range l.buckets {
+			if l.refill(bucket, now) >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.swept = now
+	}
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	if l.refill(bucket, now) < 1 {
+		return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return 0
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	if elapsed := now.Sub(bucket.updated).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		bucket.updated = now
+	}
+	return bucket.tokens
+}
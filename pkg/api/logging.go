@@ -0,0 +1,77 @@
+package api
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestId"
+	// maxRequestIDLength caps request IDs taken from clients
+	maxRequestIDLength = 128
+)
+
+// requestID tags every request with an ID, the caller's X-Request-ID when it
+// sent a sensible one, and echoes it in the response
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !printableASCII(id, maxRequestIDLength) {
+		id = uuid.New().String()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// printableASCII accepts non-empty values of at most max printable ASCII
+// characters, so clients cannot forge log lines or keys with them
+func printableASCII(value string, max int) bool {
+	if value == "" || len(value) > max {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x21 || value[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests logs every request once it has been answered. Only the path is
+// logged: query strings may carry an API key, and bodies are never logged.
+func logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	requestLog(c).Log(c.Request.Context(), level, "Request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", status,
+		"latency_ms", time.Since(start).Milliseconds(),
+		"client_ip", c.ClientIP(),
+		"bytes", c.Writer.Size(),
+	)
+}
+
+// requestLog returns the logger for a request, which tags records with its ID
+// and, when it is traced, its trace's
+func requestLog(c *gin.Context) *slog.Logger {
+	logger := slog.With("request_id", c.GetString(requestIDKey))
+	if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
+}
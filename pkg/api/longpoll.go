@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLongPollTimeout is how long a status wait is held open unless
+// configured otherwise
+const DefaultLongPollTimeout = 30 * time.Second
+
+// WaitScanStatus answers once a scan's revision is above the revision query
+// parameter, or when the long-poll timeout runs out with the status as it
+// is, for clients whose proxies cut WebSockets off
+func (h *Handler) WaitScanStatus(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	revision, err := strconv.ParseInt(c.Query("revision"), 10, 64)
+	if err != nil || revision < 0 {
+		respondError(c, 400, CodeInvalidRequest, "revision must be the revision of an earlier status")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.config.LongPollTimeout)
+	defer cancel()
+	status, err := h.orchestrator.WaitForScan(ctx, scanID, revision)
+	if err != nil {
+		orchestratorError(c, err, "Failed to read scan")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(200, status)
+}
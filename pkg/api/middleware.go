@@ -0,0 +1,128 @@
+package api
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"nuclei-distributed/pkg/version"
+)
+
+// keyIDKey holds the ID of the API key a request was authenticated with
+const keyIDKey = "keyId"
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// RequireAPIKey rejects management requests that do not carry one of the
+// configured API keys. Browsers cannot set headers on a WebSocket upgrade or
+// an EventSource, so those may pass a single-use ticket from POST /ws-ticket
+// as the ticket query parameter, or the key itself as access_token.
+func (h *Handler) RequireAPIKey(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" && (websocket.IsWebSocketUpgrade(c.Request) || strings.Contains(c.GetHeader("Accept"), "text/event-stream")) {
+		if ticket := c.Query("ticket"); ticket != "" {
+			h.requireTicket(c, ticket)
+			return
+		}
+		token = c.Query("access_token")
+	}
+
+	if !validKey(h.apiKeys, token) {
+		c.Header("WWW-Authenticate", "Bearer")
+		respondError(c, 401, CodeUnauthorized, "Missing or invalid API key")
+		return
+	}
+
+	c.Set(keyIDKey, KeyID(token))
+	c.Next()
+}
+
+// requireTicket lets a live update stream through when its ticket is valid,
+// using the ticket up
+func (h *Handler) requireTicket(c *gin.Context, ticket string) {
+	valid, err := h.orchestrator.RedeemWebSocketTicket(c.Request.Context(), ticket)
+	if err != nil {
+		requestLog(c).Error("Failed to redeem WebSocket ticket", "error", err)
+		respondError(c, 503, CodeUnavailable, "Failed to check ticket")
+		return
+	}
+	if !valid {
+		respondError(c, 401, CodeUnauthorized, "Invalid, expired or already used ticket")
+		return
+	}
+	c.Next()
+}
+
+// RequireAdminKey rejects admin requests that do not carry one of the
+// configured admin API keys. Other API keys are refused with 403.
+func (h *Handler) RequireAdminKey(c *gin.Context) {
+	token := bearerToken(c)
+	if validKey(h.adminKeys, token) {
+		c.Set(keyIDKey, KeyID(token))
+		c.Next()
+		return
+	}
+
+	if validKey(h.apiKeys, token) {
+		respondError(c, 403, CodeForbidden, "An admin API key is required")
+		return
+	}
+	c.Header("WWW-Authenticate", "Bearer")
+	respondError(c, 401, CodeUnauthorized, "Missing or invalid API key")
+}
+
+// validKey compares token against every key so the time taken does not
+// reveal which key, if any, matched
+func validKey(keys [][]byte, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	valid := 0
+	for _, key := range keys {
+		valid |= subtle.ConstantTimeCompare(key, []byte(token))
+	}
+	return valid == 1
+}
+
+// RequireWorkerToken rejects worker callbacks that do not carry the token
+// issued to that worker when its droplet was created
+func (h *Handler) RequireWorkerToken(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	if !h.orchestrator.ValidateWorkerToken(scanID, workerID, bearerToken(c)) {
+		respondError(c, 401, CodeWorkerUnauthorized, "Invalid worker token")
+		return
+	}
+
+	c.Next()
+}
+
+// RequirePoolToken rejects warm pool workers asking for work without the
+// token issued to them when their droplet was created
+func (h *Handler) RequirePoolToken(c *gin.Context) {
+	if !h.orchestrator.ValidatePoolToken(c.Param("workerId"), bearerToken(c)) {
+		respondError(c, 401, CodeWorkerUnauthorized, "Invalid pool worker token")
+		return
+	}
+
+	c.Next()
+}
+
+// deprecatedAPI marks responses on the unversioned /api aliases as
+// deprecated and links the same endpoint under the current version
+func deprecatedAPI(c *gin.Context) {
+	successor := "/api/" + version.APIVersion + strings.TrimPrefix(c.Request.URL.EscapedPath(), "/api")
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+	c.Next()
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBlocklistChangesRequireAdminKey(t *testing.T) {
+	r, _ := newTestServer(t, Config{})
+	adminKey := http.Header{"Authorization": {"Bearer " + testAdminKey}}
+	add := `{"entries": [{"pattern": "10.0.0.0/8", "note": "internal"}]}`
+	remove := "/api/v1/blocklist?pattern=" + url.QueryEscape("10.0.0.0/8")
+
+	// Scan users can read the blocklist but not change it
+	decodeError(t, serveJSON(r, "POST", "/api/v1/blocklist", add), 403, CodeForbidden)
+	if recorder := serve(r, "GET", "/api/v1/blocklist", nil, nil); recorder.Code != 200 {
+		t.Fatalf("GET /blocklist = %d, want 200: %s", recorder.Code, recorder.Body)
+	} else if strings.Contains(recorder.Body.String(), "10.0.0.0/8") {
+		t.Fatalf("entry was added with a regular key: %s", recorder.Body)
+	}
+
+	if recorder := serve(r, "POST", "/api/v1/blocklist", strings.NewReader(add), adminKey); recorder.Code != 200 {
+		t.Fatalf("POST /blocklist with an admin key = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+	decodeError(t, serve(r, "DELETE", remove, nil, nil), 403, CodeForbidden)
+	if recorder := serve(r, "DELETE", remove, nil, adminKey); recorder.Code != 200 {
+		t.Fatalf("DELETE /blocklist with an admin key = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+
+	// Without any key, the admin routes ask for one
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("DELETE", remove, nil))
+	decodeError(t, recorder, 401, CodeUnauthorized)
+}
@@ -0,0 +1,985 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/types"
+	"nuclei-distributed/pkg/version"
+)
+
+//go:embed docs/index.html
+var docsFS embed.FS
+
+// operation documents an endpoint in the OpenAPI specification. Request and
+// Response are values of the types the handler binds and writes; their
+// schemas are derived from the types by reflection.
+type operation struct {
+	Summary         string
+	Description     string
+	Tag             string
+	Query           []queryParam
+	Headers         []queryParam
+	Request         interface{} // JSON request body, nil when there is none
+	Consumes        string      // media type of the request body, when not JSON
+	OptionalRequest bool        // the request body may be left out
+	Upload          bool        // multipart targets file instead of a JSON body
+	Response        interface{} // JSON success body, nil when only Produces applies
+	Status          int         // success status, 200 when zero
+	Produces        []string    // other media types the success response comes in
+}
+
+// queryParam is a query or header parameter
+type queryParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+	Required    bool
+}
+
+// Responses written as gin.H, described for the specification
+type (
+	startScanResponse struct {
+		ScanID         string                 `json:"scan_id"`
+		Message        string                 `json:"message"`
+		DomainsCount   int                    `json:"domains_count"`
+		Plan           *types.ScanPlan        `json:"plan"`
+		AcceptedCount  int                    `json:"accepted_count"`
+		DuplicateCount int                    `json:"duplicate_count"`
+		ExpandedCount  int                    `json:"expanded_count"`
+		RejectedCount  int                    `json:"rejected_count"`
+		Rejected       []types.RejectedTarget `json:"rejected"`
+		BlockedCount   int                    `json:"blocked_count"`
+		Blocked        []types.BlockedTarget  `json:"blocked"`
+		InvalidLines   int                    `json:"invalid_lines,omitempty"`   // uploads only
+		DuplicateLines int                    `json:"duplicate_lines,omitempty"` // uploads only
+	}
+	rerunResponse struct {
+		startScanResponse
+		RerunOf string `json:"rerun_of"`
+	}
+	statusResponse struct {
+		Status string `json:"status"`
+		Count  int    `json:"count,omitempty"`
+	}
+	resultBatchResponse struct {
+		Status     string `json:"status"`
+		Accepted   int    `json:"accepted"`
+		Duplicates int    `json:"duplicates"`
+		Blocked    int    `json:"blocked"`
+		Skipped    int    `json:"skipped"`
+	}
+	wsTicketResponse struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"` // seconds
+	}
+	versionResponse struct {
+		Version    string `json:"version"`
+		APIVersion string `json:"api_version"`
+		Commit     string `json:"commit"`
+	}
+	scansResponse struct {
+		Scans []types.ScanRecord `json:"scans"`
+	}
+	deadLettersResponse struct {
+		DeadLetters []types.DeadLetter `json:"deadLetters"`
+	}
+	throughputResponse struct {
+		Profiles []types.ThroughputProfile `json:"profiles"`
+	}
+	webhooksResponse struct {
+		Webhooks []types.WebhookStatus `json:"webhooks"`
+	}
+	secretsResponse struct {
+		Secrets map[string]string `json:"secrets"`
+	}
+	targetsResponse struct {
+		Targets     []string `json:"targets"`
+		ScanRunning bool     `json:"scan_running"`
+	}
+	poolAssignmentResponse struct {
+		Assigned bool   `json:"assigned"`
+		ScanID   string `json:"scan_id,omitempty"`
+		WorkerID string `json:"worker_id,omitempty"`
+		Script   string `json:"script,omitempty"`
+	}
+	segmentResponse struct {
+		Release int `json:"release"`
+	}
+	gateResponse struct {
+		Released bool `json:"released"`
+	}
+	problemHostsResponse struct {
+		Hosts []types.ProblemHost `json:"hosts"`
+		Count int                 `json:"count"`
+	}
+	hostsResponse struct {
+		Hosts []types.HostSummary `json:"hosts"`
+	}
+	rulesResponse struct {
+		Rules []types.FPRule `json:"rules"`
+	}
+	artifactsResponse struct {
+		Artifacts []types.ArtifactLink `json:"artifacts"`
+	}
+	blocklistResponse struct {
+		Entries []types.BlocklistEntry `json:"entries"`
+	}
+	blocklistAddedResponse struct {
+		Added []types.BlocklistEntry `json:"added"`
+	}
+	auditResponse struct {
+		Entries []types.AuditEntry `json:"entries"`
+	}
+	dropletsResponse struct {
+		Droplets   []types.WorkerDroplet `json:"droplets"`
+		Count      int                   `json:"count"`
+		HourlyCost float64               `json:"hourly_cost"`
+	}
+	readyResponse struct {
+		Status string              `json:"status"` // ready or not_ready
+		Health *types.HealthReport `json:"health,omitempty"`
+	}
+)
+
+// ifNoneMatchHeader makes a request conditional on an ETag from an earlier
+// response
+var ifNoneMatchHeader = queryParam{Name: "If-None-Match", Type: "string", Description: "ETag of the copy the client holds; unchanged data gets 304 without a body"}
+
+// resultFilterQuery are the filters shared by the result endpoints
+var resultFilterQuery = []queryParam{
+	{Name: "format", Type: "string", Description: "json (default), jsonl, csv or sarif; the Accept header is used when unset"},
+	{Name: "page", Type: "integer", Description: "Page of JSON results, from 1"},
+	{Name: "page_size", Type: "integer", Description: "JSON results per page, 1 to 1000, default 100"},
+	{Name: "severity", Type: "string", Description: "Comma-separated severities"},
+	{Name: "template", Type: "string", Description: "Template ID"},
+	{Name: "host", Type: "string", Description: "Substring of the host"},
+	{Name: "since", Type: "string", Description: "RFC3339 timestamp, or the nextCursor of an earlier page"},
+	{Name: "include_duplicates", Type: "boolean", Description: "Append the findings dropped as duplicates"},
+	{Name: "exclude_false_positives", Type: "boolean", Description: "Leave out findings triaged as false positives"},
+}
+
+var resultDownloads = []string{"application/x-ndjson", "text/csv", "application/sarif+json"}
+
+// operations documents the API routes by method and path, as registered
+// under each API prefix
+var operations = map[string]operation{
+	"POST /scan": {
+		Summary: "Start a scan", Tag: "Scans",
+		Description: "With an Idempotency-Key, retries with the same body get the first response back for 24 hours, " +
+			"marked with Idempotent-Replayed: true, instead of starting another scan. Reusing a key with a different body " +
+			"is refused with 409 IDEMPOTENCY_KEY_REUSED. A retry sent while the first request is handled waits for its " +
+			"response, or gets 409 IDEMPOTENCY_KEY_IN_PROGRESS after 10 seconds. A scan started with expand is " +
+			"enumerating until the subdomains of its apex domains are found; its droplets are then planned and its plan " +
+			"shows in the scan's status. A scan started with preflight is in preflight, after any enumeration, until its " +
+			"targets are checked; the dead ones are then left out of its plan.",
+		Headers: []queryParam{{Name: "Idempotency-Key", Type: "string", Description: "Client-chosen key, such as a UUID, identifying the request across retries"}},
+		Request: types.ScanRequest{}, Response: startScanResponse{},
+	},
+	"POST /scan/upload": {
+		Summary: "Start a scan from a targets file", Tag: "Scans",
+		Description: "Multipart form: a targets file with one target per line and # comments, the droplets count, and options holding any other scan settings as the JSON of POST /scan.",
+		Upload:      true, Response: startScanResponse{},
+	},
+	"GET /scans": {
+		Summary: "List stored scans, newest first", Tag: "Scans",
+		Response: scansResponse{},
+	},
+	"PATCH /scan/:scanId": {
+		Summary: "Pin or unpin a scan so retention keeps it", Tag: "Scans",
+		Request: scanUpdateRequest{}, Response: types.ScanRecord{},
+	},
+	"GET /scan/:scanId/config": {
+		Summary: "Get the settings and targets a scan ran with", Tag: "Scans",
+		Description: "Normalized as the scan was run, with defaults filled in. Webhook secrets, Slack and Discord webhook " +
+			"URLs and credentials in the nuclei config are redacted. Scans recorded before configurations were stored " +
+			"get 409 SCAN_CONFIG_MISSING.",
+		Response: types.ScanRequest{},
+	},
+	"POST /scan/:scanId/rerun": {
+		Summary: "Run a scan again with the same targets and settings", Tag: "Scans",
+		Description: "The body is optional. droplets replaces the droplet count; tags and templateIds replace the scan's " +
+			"-tags and -template-id flags, and an empty list drops them. The new scan's rerunOf names the original. " +
+			"Template variables are not copied and must be stored for the new scan again. Scans recorded before " +
+			"configurations were stored get 409 SCAN_CONFIG_MISSING.",
+		Request: types.RerunRequest{}, OptionalRequest: true, Response: rerunResponse{},
+	},
+	"POST /scan/:scanId/rebalance": {
+		Summary: "Move untouched targets from slow workers to idle ones", Tag: "Scans",
+		Description: "Workers of a running static scan scan their targets in segments of 50. Workers that finished " +
+			"theirs but still run count as idle; each running worker is asked to hand back enough untouched targets " +
+			"that it and the idle workers end up with about the same share, keeping the segment it scans and the " +
+			"next. It hands them back when its segment is done, and each move is listed in the scan's rebalances. " +
+			"REBALANCE_THRESHOLD does this every minute for workers below that progress. Pull scans are " +
+			"refused with 400 INVALID_SCAN, completed scans with 409 SCAN_NOT_RUNNING.",
+		Response: types.RebalancePass{},
+	},
+	"PATCH /scan/:scanId/targets": {
+		Summary: "Add targets to a running scan", Tag: "Scans",
+		Description: "Targets are validated like a new scan's and those the scan already has are skipped. Fewer than 100 " +
+			"new targets are queued for the running workers, which pick them up once their own targets are done; more, " +
+			"or any when no worker is running, get a new worker. Scans with pull distribution add them to their pool. " +
+			"Completed scans are refused with 409 SCAN_NOT_RUNNING.",
+		Request: types.AddTargetsRequest{}, Response: types.TargetsAdded{},
+	},
+	"GET /scan/:scanId/status": {
+		Summary: "Get a scan's live status", Tag: "Scans",
+		Description: "The ETag follows the scan's revision; polls with If-None-Match get 304 until the scan changes.",
+		Headers:     []queryParam{ifNoneMatchHeader},
+		Response:    types.ScanStatus{},
+	},
+	"GET /scan/:scanId/status/wait": {
+		Summary: "Wait for a scan's status to change", Tag: "Scans",
+		Description: "Long-poll alternative to the WebSocket. Answers as soon as the scan's revision is above the given " +
+			"one, or with the unchanged status once LONG_POLL_TIMEOUT (30 seconds by default) runs out; pass the " +
+			"returned revision to the next wait.",
+		Query:    []queryParam{{Name: "revision", Type: "integer", Description: "Revision of the status the client holds", Required: true}},
+		Response: types.ScanStatus{},
+	},
+	"GET /scan/:scanId/events": {
+		Summary: "Stream a scan's updates as Server-Sent Events", Tag: "Scans",
+		Description: "Alternative to the WebSocket for networks that block upgrades. Sends the WebSocket's messages as " +
+			"events named after their type, with their data as JSON and their seq as ID, and a heartbeat comment " +
+			"every 15 seconds. Starts with a status_update, or with the events after Last-Event-ID while they are kept. " +
+			"Answers 429 when the scan has WS_MAX_CLIENTS_PER_SCAN WebSockets and event streams open.",
+		Headers: []queryParam{{Name: "Last-Event-ID", Type: "string", Description: "ID of the last event received, sent by EventSource on reconnect"}},
+		Query: []queryParam{
+			{Name: "since", Type: "integer", Description: "ID of the last event received, for a new EventSource"},
+			{Name: "events", Type: "string", Description: "Comma-separated event types, default all"},
+			{Name: "min_severity", Type: "string", Description: "Lowest severity of findings in results_batch events"},
+			{Name: "access_token", Type: "string", Description: "API key, for EventSource, which cannot set headers"},
+			{Name: "ticket", Type: "string", Description: "Single-use ticket from POST /ws-ticket, in place of access_token"},
+		},
+		Produces: []string{"text/event-stream"},
+	},
+	"GET /scan/:scanId/results": {
+		Summary: "Query or download a scan's findings", Tag: "Results",
+		Description: "For scans the server tracks, the ETag follows the scan's revision; polls with If-None-Match get 304 " +
+			"until a finding or its triage changes.",
+		Query: resultFilterQuery, Headers: []queryParam{ifNoneMatchHeader},
+		Response: types.ResultPage{}, Produces: resultDownloads,
+	},
+	"PATCH /scan/:scanId/results/:resultId": {
+		Summary: "Triage a finding", Tag: "Results",
+		Request: triageRequest{}, Response: types.ScanResult{},
+	},
+	"POST /scan/:scanId/results/:resultId/ticket": {
+		Summary: "File a Jira issue for a finding", Tag: "Results",
+		Description: "Returns 201 when an issue was created and 200 when the finding already had one.",
+		Response:    types.ScanResult{}, Status: 201,
+	},
+	"GET /scan/:scanId/stats": {
+		Summary: "Count a scan's findings by severity and triage status", Tag: "Results",
+		Query:    []queryParam{{Name: "exclude_false_positives", Type: "boolean", Description: "Leave out false positives"}},
+		Response: types.ScanStats{},
+	},
+	"GET /scan/:scanId/hosts": {
+		Summary: "List the hosts with findings", Tag: "Results",
+		Query:    []queryParam{{Name: "sort", Type: "string", Description: "severity (default), count or host"}},
+		Response: hostsResponse{},
+	},
+	"GET /scan/:scanId/hosts/:host/results": {
+		Summary: "Query or download the findings on one host", Tag: "Results",
+		Description: "The host is URL-encoded, e.g. https%3A%2F%2Fexample.com.",
+		Query:       resultFilterQuery, Response: types.ResultPage{}, Produces: resultDownloads,
+	},
+	"GET /scan/:scanId/report.html": {
+		Summary: "Download the HTML report", Tag: "Exports",
+		Query:    []queryParam{{Name: "exclude_false_positives", Type: "boolean", Description: "Leave out false positives"}},
+		Produces: []string{"text/html"},
+	},
+	"GET /scan/:scanId/artifacts": {
+		Summary: "Get presigned links to the archived results and report", Tag: "Exports",
+		Response: artifactsResponse{},
+	},
+	"GET /scan/:scanId/archive.zip": {
+		Summary: "Download a zip of results, report, worker logs, config and manifest", Tag: "Exports",
+		Produces: []string{"application/zip"},
+	},
+	"GET /scan/:scanId/worker/:workerId/logs": {
+		Summary: "Read a worker's logs", Tag: "Workers",
+		Query: []queryParam{
+			{Name: "offset", Type: "integer", Description: "Lines to skip, default 0"},
+			{Name: "limit", Type: "integer", Description: "Lines to return, 1 to 1000, default 100"},
+		},
+		Response: types.WorkerLogPage{},
+	},
+	"GET /scan/:scanId/orchestrator-log": {
+		Summary: "Download the server's log of a scan", Tag: "Scans",
+		Description: "What the server logged about the scan at info level and above, and the errors its workers " +
+			"logged, oldest first, one JSON object per line with time, level, source (orchestrator or worker), " +
+			"workerId, msg and attrs. The log keeps the last 2000 " +
+			"entries; X-Log-Dropped counts those dropped before them. It is stored with the scan's record and " +
+			"empty for scans recorded before scan logs were kept.",
+		Produces: []string{"application/x-ndjson"},
+	},
+	"POST /scan/:scanId/secrets": {
+		Summary: "Store template variables for a scan's workers", Tag: "Scans",
+		Request: secretsRequest{}, Response: statusResponse{},
+	},
+	"GET /scan/:scanId/problem-hosts": {
+		Summary: "List hosts skipped after hitting the host error limit", Tag: "Scans",
+		Response: problemHostsResponse{},
+	},
+	"GET /scan/:scanId/coverage": {
+		Summary: "Report which of a scan's targets were scanned", Tag: "Scans",
+		Description: "Counts the targets by state: pending until their worker starts or leases them, assigned while a " +
+			"worker holds them, completed once scanned, errored when their worker failed, and skipped when nuclei " +
+			"gave up on the host. uncovered lists every target not completed; format=txt or csv downloads it alone. " +
+			"A scan ends completed when every target was completed or skipped, and partial otherwise. Scans recorded " +
+			"before coverage was tracked get 409 COVERAGE_MISSING.",
+		Query: []queryParam{
+			{Name: "format", Type: "string", Description: "json, txt or csv"},
+			{Name: "state", Type: "string", Description: "Comma-separated states to list: pending, assigned, errored, skipped or dead"},
+		},
+		Response: types.CoverageReport{}, Produces: []string{"text/plain", "text/csv"},
+	},
+	"GET /scan/:scanId/webhooks": {
+		Summary: "Get webhook delivery counts and recent failures", Tag: "Scans",
+		Response: webhooksResponse{},
+	},
+	"GET /scan/:scanId/source-ips": {
+		Summary: "List the addresses a scan's workers scan from", Tag: "Scans",
+		Description: "Every worker's public IPv4 and IPv6 address, for the targets' owners to allowlist. pending " +
+			"counts planned workers still provisioning; the list is complete once it is zero. Workers of a scan " +
+			"started with holdUntilRelease send no traffic until POST /scan/:scanId/release, so the complete list " +
+			"can be handed over first.",
+		Response: types.SourceIPs{},
+	},
+	"POST /scan/:scanId/release": {
+		Summary: "Let the workers of a held scan start scanning", Tag: "Scans",
+		Description: "Workers of a scan started with holdUntilRelease come up and report their addresses without " +
+			"sending any traffic until the scan is released; they start within ten seconds. Workers created " +
+			"later start right away. Scans not held get 409 SCAN_NOT_HELD, ended ones 409 SCAN_NOT_RUNNING.",
+		Response: types.ScanStatus{},
+	},
+	"GET /scans/diff": {
+		Summary: "Compare the findings of two scans", Tag: "Exports",
+		Query: []queryParam{
+			{Name: "base", Type: "string", Description: "Earlier scan ID", Required: true},
+			{Name: "head", Type: "string", Description: "Later scan ID", Required: true},
+			{Name: "format", Type: "string", Description: "json (default) or csv"},
+		},
+		Response: types.ScanDiff{}, Produces: []string{"text/csv"},
+	},
+	"GET /opensearch/dead-letters": {
+		Summary: "List findings OpenSearch forwarding gave up on", Tag: "Exports",
+		Response: deadLettersResponse{},
+	},
+	"GET /stats/throughput": {
+		Summary: "List the throughput of past scans by droplet size and template set", Tag: "Scans",
+		Description: "Completed scans record the targets their workers scanned per hour of running nuclei, keeping the " +
+			"last 20 for each droplet size and template set. Once a profile has 3, scans planned with it use their " +
+			"median in place of the capacity table's targets per hour; usedForPlanning says which do.",
+		Response: throughputResponse{},
+	},
+	"GET /stats/costs": {
+		Summary: "Report what scans' droplets cost by day and by scan", Tag: "Scans",
+		Description: "Droplets are billed to a scan from their creation, or claim from the warm pool, until their " +
+			"destruction or return, at their size's hourly price. Each UTC day of the window gets the runtime it saw; " +
+			"a scan's cost is its share of the window and total its whole cost. Droplets still running accrue until now.",
+		Query:    []queryParam{{Name: "days", Type: "integer", Description: "Days to report on, today included, 1 to 366 (default 30)"}},
+		Response: types.CostStats{},
+	},
+	"POST /ws-ticket": {
+		Summary: "Get a single-use ticket for a WebSocket or event stream", Tag: "Scans",
+		Description: "Pass the ticket as the ticket query parameter of /ws, /ws/{scanId} or the events stream instead of " +
+			"access_token, so the API key stays out of URLs and proxy logs. A ticket opens one connection and expires " +
+			"after 30 seconds.",
+		Response: wsTicketResponse{},
+	},
+	"GET /fp-rules": {
+		Summary: "List false-positive rules", Tag: "Rules",
+		Response: rulesResponse{},
+	},
+	"POST /fp-rules": {
+		Summary: "Add a false-positive rule", Tag: "Rules",
+		Request: types.FPRule{}, Response: types.FPRule{}, Status: 201,
+	},
+	"GET /fp-rules/:ruleId": {
+		Summary: "Get a false-positive rule", Tag: "Rules",
+		Response: types.FPRule{},
+	},
+	"PUT /fp-rules/:ruleId": {
+		Summary: "Replace a rule's template, pattern and note", Tag: "Rules",
+		Request: types.FPRule{}, Response: types.FPRule{},
+	},
+	"DELETE /fp-rules/:ruleId": {
+		Summary: "Remove a false-positive rule", Tag: "Rules",
+		Response: statusResponse{},
+	},
+	"GET /blocklist": {
+		Summary: "List the targets that are never scanned", Tag: "Blocklist",
+		Response: blocklistResponse{},
+	},
+	"POST /blocklist": {
+		Summary: "Add blocklist entries", Tag: "Blocklist",
+		Request: types.BlocklistRequest{}, Response: blocklistAddedResponse{},
+	},
+	"DELETE /blocklist": {
+		Summary: "Remove a blocklist entry added through the API", Tag: "Blocklist",
+		Query:    []queryParam{{Name: "pattern", Type: "string", Description: "The entry's pattern", Required: true}},
+		Response: statusResponse{},
+	},
+	"GET /admin/droplets": {
+		Summary: "List worker droplets across scans", Tag: "Admin",
+		Description: "Lists every droplet tagged nuclei-worker in the DigitalOcean account, including droplets of " +
+			"scans this server has no record of. scanKnown is false for those. hourly_cost sums their prices.",
+		Response: dropletsResponse{},
+	},
+	"DELETE /admin/droplets/:dropletId": {
+		Summary: "Destroy a worker droplet", Tag: "Admin",
+		Description: "Only droplets tagged nuclei-worker can be destroyed. A worker that had not finished is " +
+			"marked failed when the server tracks its scan.",
+		Response: statusResponse{},
+	},
+	"POST /admin/selfcheck": {
+		Summary: "Check credentials and settings again", Tag: "Admin",
+		Description: "Runs the startup self-check: Redis, the compute provider's credentials, the droplet region, size " +
+			"and image slugs, and whether workers can reach the callback URL. A server made read-only by a failed " +
+			"check accepts scans again once it passes.",
+		Response: types.SelfCheck{},
+	},
+	"GET /admin/config": {
+		Summary: "Get the settings changeable at runtime", Tag: "Admin",
+		Description: "Webhook secrets and the Slack and Discord webhook URLs are shown as [redacted]. Durations are Go " +
+			"durations such as 15m; an empty retention keeps scans forever.",
+		Response: types.ServerConfig{},
+	},
+	"PATCH /admin/config": {
+		Summary: "Change settings at runtime", Tag: "Admin",
+		Description: "Takes any part of the configuration: objects are merged field by field, lists and other values " +
+			"replaced. [redacted] keeps a secret as it is. Changes are stored, apply to scans started afterwards and " +
+			"are recorded in the audit log with the ID of the API key that made them.",
+		Request: types.ServerConfig{}, Response: types.ServerConfig{},
+	},
+	"GET /admin/audit": {
+		Summary: "List changes made through the API", Tag: "Admin",
+		Description: "Every POST, PUT, PATCH and DELETE of the management and admin endpoints, except WebSocket tickets, " +
+			"newest first. action is the operation ID, keyId the start of the SHA-256 of the API key used, as printed " +
+			"by the apikey command. Configuration changes list the settings changed.",
+		Query: []queryParam{
+			{Name: "action", Type: "string", Description: "Operation ID, such as startScan"},
+			{Name: "actor", Type: "string", Description: "keyId of the API key"},
+			{Name: "since", Type: "string", Description: "RFC 3339 time of the oldest entry"},
+			{Name: "until", Type: "string", Description: "RFC 3339 time the entries are before"},
+			{Name: "limit", Type: "integer", Description: "Most entries to return"},
+		},
+		Response: auditResponse{},
+	},
+	"POST /results/:scanId/:workerId": {
+		Summary: "Report a finding", Tag: "Worker callbacks",
+		Description: "The status is received, duplicate or blocked.",
+		Request:     types.ScanResult{}, Response: statusResponse{},
+	},
+	"POST /results/:scanId/:workerId/batch": {
+		Summary: "Report a batch of findings", Tag: "Worker callbacks",
+		Description: fmt.Sprintf("One finding per line, as for a single finding, up to %d. Lines that are malformed or "+
+			"invalid are skipped and counted; the others are stored at once, or none are.", maxResultBatch),
+		Headers:  []queryParam{{Name: "Content-Encoding", Type: "string", Description: "gzip for a compressed body"}},
+		Consumes: "application/x-ndjson",
+		Request:  types.ScanResult{},
+		Response: resultBatchResponse{},
+	},
+	"POST /heartbeat/:scanId/:workerId": {
+		Summary: "Report progress", Tag: "Worker callbacks",
+		Description: "Sent every 30 seconds with a telemetry sample, which comes alone until nuclei reports " +
+			"progress. Memory or disk past their thresholds marks the worker degraded.",
+		Request: heartbeatRequest{}, Response: statusResponse{},
+	},
+	"POST /logs/:scanId/:workerId": {
+		Summary: "Send a batch of log lines", Tag: "Worker callbacks",
+		Request: []types.Log{}, Response: statusResponse{},
+	},
+	"POST /complete/:scanId/:workerId": {
+		Summary: "Report that the worker finished", Tag: "Worker callbacks",
+		Request: completeRequest{}, Response: statusResponse{},
+	},
+	"GET /secrets/:scanId/:workerId": {
+		Summary: "Fetch the scan's template variables", Tag: "Worker callbacks",
+		Response: secretsResponse{},
+	},
+	"GET /config/:scanId/:workerId": {
+		Summary: "Fetch the scan's nuclei config file", Tag: "Worker callbacks",
+		Produces: []string{"application/yaml"},
+	},
+	"GET /targets/:scanId/:workerId": {
+		Summary: "Pull the targets added or rebalanced to the worker since it started", Tag: "Worker callbacks",
+		Description: "Each target is handed out once; an empty list means the worker has nothing left to scan. " +
+			"Workers of static scans that finished keep asking every 30 seconds, so slower workers can hand them " +
+			"targets, until scan_running is false.",
+		Response: targetsResponse{},
+	},
+	"GET /targets/:scanId/:workerId/next": {
+		Summary: "Lease the next batch of a pull scan's targets", Tag: "Worker callbacks",
+		Description: "The batch is the worker's until it confirms it, or until the worker has sent neither " +
+			"heartbeats nor logs for 15 minutes, when it goes back to the pool. Without a batchId there is nothing " +
+			"to lease: drained means the pool is empty and the worker is done, otherwise other workers hold the " +
+			"last batches and the worker should ask again later. Scans with static distribution get 400 INVALID_SCAN.",
+		Query: []queryParam{
+			{Name: "count", Type: "integer", Description: fmt.Sprintf("Targets to lease, 1 to %d; defaults to the scan's batch size", orchestrator.MaxBatchSize)},
+		},
+		Response: types.TargetBatch{},
+	},
+	"POST /targets/:scanId/:workerId/batches/:batchId": {
+		Summary: "Confirm a leased batch as scanned", Tag: "Worker callbacks",
+		Description: "A batch whose lease expired was returned to the pool, and is refused with 409 LEASE_LOST.",
+		Response:    statusResponse{},
+	},
+	"POST /targets/:scanId/:workerId/done": {
+		Summary: "Report a segment of a static scan's targets as scanned", Tag: "Worker callbacks",
+		Description: "queued is the number of targets the worker has left after the segment. release is how many of " +
+			"them, taken from the end of the queue, a rebalance wants handed back to POST .../release; the worker " +
+			"always keeps the next segment.",
+		Request: segmentRequest{}, Response: segmentResponse{},
+	},
+	"POST /targets/:scanId/:workerId/release": {
+		Summary: "Hand queued targets back for idle workers", Tag: "Worker callbacks",
+		Description: "At most the release count of the last report is accepted. The targets go to workers that " +
+			"finished theirs, and the move is broadcast as a targets_rebalanced message. When the worker was not " +
+			"asked for them or no worker is idle any more, 409 RELEASE_REFUSED tells it to keep scanning them.",
+		Request: releaseTargetsRequest{}, Response: types.Rebalance{},
+	},
+	"GET /gate/:scanId/:workerId": {
+		Summary: "Ask whether a held scan was released", Tag: "Worker callbacks",
+		Description: "Polled by the workers of a scan started with holdUntilRelease before they send any traffic. " +
+			"Counts as a sign of life, so waiting workers do not stall.",
+		Response: gateResponse{},
+	},
+	"GET /pool/:workerId/assignment": {
+		Summary: "Ask for a scan to work on", Tag: "Worker callbacks",
+		Description: "Called by warm pool workers with the token they were created with. Once a scan claimed the " +
+			"worker, script is the worker's job for it, which fetches its targets through the targets endpoint " +
+			"with the scan worker's own token. Asking again after the job exited returns the worker to the pool.",
+		Response: poolAssignmentResponse{},
+	},
+}
+
+// unversionedOperations documents the routes outside the versioned API
+var unversionedOperations = map[string]operation{
+	"GET /api/version": {
+		Summary: "Get the server and API version", Tag: "Server",
+		Response: versionResponse{},
+	},
+	"GET /api/openapi.json": {
+		Summary: "Get this specification", Tag: "Server",
+		Response: map[string]interface{}{},
+	},
+	"GET /health": {
+		Summary: "Check the server's dependencies", Tag: "Server",
+		Description: "Pings Redis and reports the last DigitalOcean token check, made every few minutes. " +
+			"Answers 503 with the same body while a critical dependency is failing. selfCheck is the last " +
+			"check of credentials and settings, made at startup and by POST /admin/selfcheck.",
+		Response: types.HealthReport{},
+	},
+	"GET /ready": {
+		Summary: "Check whether the server should get traffic", Tag: "Server",
+		Description: "Answers 503 with status not_ready and the health report until state has been restored " +
+			"from storage at startup, and while a critical dependency is failing.",
+		Response: readyResponse{},
+	},
+	"GET /ws/:scanId": {
+		Summary: "Stream a scan's updates over a WebSocket", Tag: "Scans",
+		Description: "Upgrade with the API key as a bearer token or, from browsers, the access_token query parameter. " +
+			"The server sends a status_update with the current status on connect, then results_batch (every 250ms at most), " +
+			"status_update (once a second at most), worker_log, worker lifecycle (worker_provisioning, worker_ready, " +
+			"worker_progress, worker_stalled, worker_degraded, worker_failed, worker_completed and worker_destroyed, carrying a " +
+			"WorkerStatus) " +
+			"and scan_complete messages as WebSocketMessage JSON, " +
+			"numbered by seq. With since, the messages after it are replayed " +
+			"instead of the status when they are still kept. Clients may send {\"type\": \"ping\"} and get a pong, and " +
+			"{\"type\": \"subscribe\"} with WebSocketSubscription data to choose the messages they get. A client that falls " +
+			"behind loses results_batch, worker_log and worker_progress messages but still gets the latest status_update; one behind for 30 " +
+			"seconds is closed with code 1013. A scan with WS_MAX_CLIENTS_PER_SCAN clients closes new ones with code 4429.",
+		Query: []queryParam{
+			{Name: "access_token", Type: "string", Description: "API key, for clients that cannot set headers"},
+			{Name: "ticket", Type: "string", Description: "Single-use ticket from POST /ws-ticket, in place of access_token"},
+			{Name: "since", Type: "integer", Description: "seq of the last message received, when reconnecting"},
+		},
+		Response: types.WebSocketMessage{}, Status: 101,
+	},
+	"GET /ws": {
+		Summary: "Stream every scan's lifecycle events over a WebSocket", Tag: "Scans",
+		Description: "Authenticated like the per-scan WebSocket. The server sends a scans message listing the running scans " +
+			"as ScanEvent on connect, then scan_started, scan_progress (at most one per scan every 3 seconds), worker_failed " +
+			"and scan_complete messages carrying a ScanEvent. Findings are not sent.",
+		Query: []queryParam{
+			{Name: "access_token", Type: "string", Description: "API key, for clients that cannot set headers"},
+			{Name: "ticket", Type: "string", Description: "Single-use ticket from POST /ws-ticket, in place of access_token"},
+		},
+		Response: types.WebSocketMessage{}, Status: 101,
+	},
+}
+
+// openAPISpec builds the OpenAPI document for the routes of a handler
+func (h *Handler) openAPISpec() (map[string]interface{}, error) {
+	schemas := &schemaRegistry{schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+
+	add := func(method, path string, op operation, security string, handlers []gin.HandlerFunc) {
+		item := paths[openAPIPath(path)]
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[openAPIPath(path)] = item
+		}
+		item[strings.ToLower(method)] = op.build(path, security, handlerName(handlers), schemas)
+	}
+
+	prefix := "/api/" + version.APIVersion
+	for _, group := range []struct {
+		routes   []route
+		security string
+	}{{h.managementRoutes(), "apiKey"}, {h.adminRoutes(), "adminKey"}, {h.workerRoutes(), "workerToken"},
+		{h.poolRoutes(), "workerToken"}} {
+		for _, route := range group.routes {
+			op, documented := operations[route.method+" "+route.path]
+			if !documented {
+				return nil, fmt.Errorf("route %s %s is not documented", route.method, route.path)
+			}
+			add(route.method, prefix+route.path, op, group.security, route.handlers)
+		}
+	}
+	for key, op := range unversionedOperations {
+		method, path, _ := strings.Cut(key, " ")
+		security := ""
+		if path == "/ws" || strings.HasPrefix(path, "/ws/") {
+			security = "apiKey"
+		}
+		add(method, path, op, security, nil)
+	}
+
+	errorSchema := schemas.schema(reflect.TypeOf(types.ErrorResponse{}))
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Nuclei Distributed Scanner API",
+			"version": version.APIVersion,
+			"description": "Versioned endpoints live under " + prefix + ". The unversioned /api paths are " +
+				"deprecated aliases that answer the same way with a Deprecation header. Server build " +
+				version.Version + " (" + version.Commit + ").",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error envelope with a machine-readable code",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"apiKey":      map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A key from API_KEYS"},
+				"adminKey":    map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A key from ADMIN_API_KEYS"},
+				"workerToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "The token a worker was started with"},
+			},
+		},
+	}, nil
+}
+
+// build returns the OpenAPI operation object
+func (op operation) build(path, security, operationID string, schemas *schemaRegistry) map[string]interface{} {
+	built := map[string]interface{}{
+		"summary": op.Summary,
+		"tags":    []string{op.Tag},
+	}
+	if op.Description != "" {
+		built["description"] = op.Description
+	}
+	if operationID != "" {
+		built["operationId"] = operationID
+	}
+	if security != "" {
+		built["security"] = []map[string][]string{{security: {}}}
+	}
+
+	parameters := make([]map[string]interface{}, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			parameters = append(parameters, map[string]interface{}{
+				"name": segment[1:], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, param := range op.Query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": param.Name, "in": "query", "required": param.Required, "description": param.Description,
+			"schema": map[string]interface{}{"type": param.Type},
+		})
+	}
+	for _, param := range op.Headers {
+		parameters = append(parameters, map[string]interface{}{
+			"name": param.Name, "in": "header", "required": param.Required, "description": param.Description,
+			"schema": map[string]interface{}{"type": param.Type},
+		})
+	}
+	if len(parameters) > 0 {
+		built["parameters"] = parameters
+	}
+
+	switch {
+	case op.Upload:
+		built["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{"multipart/form-data": map[string]interface{}{"schema": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"targets"},
+				"properties": map[string]interface{}{
+					"targets":  map[string]interface{}{"type": "string", "format": "binary"},
+					"droplets": map[string]interface{}{"type": "integer"},
+					"options":  map[string]interface{}{"type": "string", "description": "ScanRequest JSON without domains"},
+				},
+			}}},
+		}
+	case op.Request != nil:
+		mediaType := "application/json"
+		if op.Consumes != "" {
+			mediaType = op.Consumes
+		}
+		built["requestBody"] = map[string]interface{}{
+			"required": !op.OptionalRequest,
+			"content": map[string]interface{}{mediaType: map[string]interface{}{
+				"schema": schemas.schema(reflect.TypeOf(op.Request)),
+			}},
+		}
+	}
+
+	content := make(map[string]interface{})
+	if op.Response != nil {
+		content["application/json"] = map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(op.Response))}
+	}
+	for _, mediaType := range op.Produces {
+		schema := map[string]interface{}{"type": "string"}
+		if mediaType == "application/zip" {
+			schema["format"] = "binary"
+		}
+		content[mediaType] = map[string]interface{}{"schema": schema}
+	}
+	status := op.Status
+	if status == 0 {
+		status = 200
+	}
+	success := map[string]interface{}{"description": successDescription(status)}
+	if len(content) > 0 {
+		success["content"] = content
+	}
+	responses := map[string]interface{}{
+		fmt.Sprint(status): success,
+		"default":          map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	for _, header := range op.Headers {
+		if header.Name == ifNoneMatchHeader.Name {
+			responses["304"] = map[string]interface{}{"description": "Not Modified, the client's copy is current"}
+		}
+	}
+	built["responses"] = responses
+	return built
+}
+
+func successDescription(status int) string {
+	switch status {
+	case 101:
+		return "Switching Protocols"
+	case 201:
+		return "Created"
+	}
+	return "OK"
+}
+
+// openAPIPath turns gin parameters into OpenAPI ones, e.g. :scanId into
+// {scanId}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// handlerName names an endpoint after the handler method that serves it
+func handlerName(handlers []gin.HandlerFunc) string {
+	if len(handlers) == 0 {
+		return ""
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(handlers[len(handlers)-1]).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+	return lowerFirst(name)
+}
+
+// schemaRegistry derives JSON schemas from Go types. Named structs become
+// components referenced by name.
+type schemaRegistry struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (s *schemaRegistry) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t == rawJSONType {
+		// Embedded JSON of any kind
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := upperFirst(t.Name())
+		if _, exists := s.schemas[name]; !exists {
+			// Registered before the fields so recursive types terminate
+			s.schemas[name] = map[string]interface{}{}
+			s.schemas[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{} and anything else may hold any value
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct's JSON fields. Embedded structs without a
+// JSON name contribute their fields, as encoding/json does.
+func (s *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			name := strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					collect(embedded)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.schema(field.Type)
+			for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+				if rule == "required" {
+					required = append(required, name)
+				}
+			}
+		}
+	}
+	collect(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func upperFirst(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+// checkSpecCoverage fails when a route under /api or /ws, or a health check,
+// is missing from the specification, so the spec cannot silently fall behind
+// the routes. Deprecated /api aliases are checked against their /api/v1 paths.
+func checkSpecCoverage(routes gin.RoutesInfo, spec map[string]interface{}) error {
+	paths := spec["paths"].(map[string]map[string]interface{})
+	prefix := "/api/" + version.APIVersion
+	for _, route := range routes {
+		path := route.Path
+		switch {
+		case path == "/health", path == "/ready", path == "/ws", strings.HasPrefix(path, "/ws/"), strings.HasPrefix(path, prefix+"/"):
+		case strings.HasPrefix(path, "/api/"):
+			if _, unversioned := unversionedOperations[route.Method+" "+path]; !unversioned {
+				path = prefix + strings.TrimPrefix(path, "/api")
+			}
+		default:
+			continue
+		}
+		if _, documented := paths[openAPIPath(path)][strings.ToLower(route.Method)]; !documented {
+			return fmt.Errorf("route %s %s is missing from the OpenAPI specification", route.Method, route.Path)
+		}
+	}
+	return nil
+}
+
+// serveDocs serves the specification and the Swagger UI that renders it. The
+// UI's assets come from the web build, which copies them from
+// swagger-ui-dist.
+func serveDocs(r *gin.Engine, spec map[string]interface{}) error {
+	if err := checkSpecCoverage(r.Routes(), spec); err != nil {
+		return err
+	}
+	document, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	page, err := docsFS.ReadFile("docs/index.html")
+	if err != nil {
+		return err
+	}
+
+	r.GET("/api/openapi.json", func(c *gin.Context) {
+		c.Data(200, "application/json", document)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		c.Data(200, "text/html; charset=utf-8", page)
+	})
+	r.Static("/docs/swagger-ui", "./web/dist/swagger-ui")
+	return nil
+}
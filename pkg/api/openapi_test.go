@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"nuclei-distributed/pkg/version"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	_, orch := newTestServer(t, Config{})
+	h := NewHandler(orch, Config{})
+	spec, err := h.openAPISpec()
+	if err != nil {
+		t.Fatalf("openAPISpec() error = %v", err)
+	}
+	paths := spec["paths"].(map[string]map[string]interface{})
+	prefix := "/api/" + version.APIVersion
+
+	groups := []struct {
+		name     string
+		routes   []route
+		security string
+	}{
+		{"management", h.managementRoutes(), "apiKey"},
+		{"admin", h.adminRoutes(), "adminKey"},
+		{"worker", h.workerRoutes(), "workerToken"},
+		{"pool", h.poolRoutes(), "workerToken"},
+	}
+
+	registered := make(map[string]string)
+	for _, group := range groups {
+		for _, route := range group.routes {
+			key := route.method + " " + route.path
+			if other, exists := registered[key]; exists {
+				t.Errorf("%s is registered by the %s and %s routes", key, other, group.name)
+			}
+			registered[key] = group.name
+
+			op, documented := paths[openAPIPath(prefix+route.path)][strings.ToLower(route.method)].(map[string]interface{})
+			if !documented {
+				t.Errorf("%s route %s is missing from the specification", group.name, key)
+				continue
+			}
+
+			security, _ := json.Marshal(op["security"])
+			if want := `[{"` + group.security + `":[]}]`; string(security) != want {
+				t.Errorf("%s has security %s, want %s", key, security, want)
+			}
+			if name := handlerName(route.handlers); op["operationId"] != name {
+				t.Errorf("%s has operationId %v, want %s", key, op["operationId"], name)
+			}
+
+			// Every path parameter is documented, and nothing else in the path
+			var want, got []string
+			for _, segment := range strings.Split(route.path, "/") {
+				if strings.HasPrefix(segment, ":") {
+					want = append(want, segment[1:])
+				}
+			}
+			parameters, _ := op["parameters"].([]map[string]interface{})
+			for _, parameter := range parameters {
+				if parameter["in"] == "path" {
+					got = append(got, parameter["name"].(string))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s documents path parameters %v, want %v", key, got, want)
+			}
+		}
+	}
+
+	// Nothing is documented that no route serves
+	for key := range operations {
+		if _, exists := registered[key]; !exists {
+			t.Errorf("operation %s is documented but not registered", key)
+		}
+	}
+	var stale []string
+	for path, item := range paths {
+		for method := range item {
+			key := strings.ToUpper(method) + " " + path
+			if _, unversioned := unversionedOperations[strings.ToUpper(method)+" "+ginPath(path)]; unversioned {
+				continue
+			}
+			if !strings.HasPrefix(path, prefix+"/") {
+				stale = append(stale, key)
+				continue
+			}
+			if _, exists := registered[strings.ToUpper(method)+" "+ginPath(strings.TrimPrefix(path, prefix))]; !exists {
+				stale = append(stale, key)
+			}
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		t.Errorf("specification has %s, which no route serves", key)
+	}
+}
+
+func TestServedRoutesAreDocumented(t *testing.T) {
+	r, orch := newTestServer(t, Config{})
+	spec, err := NewHandler(orch, Config{}).openAPISpec()
+	if err != nil {
+		t.Fatalf("openAPISpec() error = %v", err)
+	}
+	if err := checkSpecCoverage(r.Routes(), spec); err != nil {
+		t.Error(err)
+	}
+
+	// Each versioned route is also served under its deprecated alias
+	served := make(map[string]bool)
+	for _, route := range r.Routes() {
+		served[route.Method+" "+route.Path] = true
+	}
+	for key := range operations {
+		method, path, _ := strings.Cut(key, " ")
+		for _, prefix := range []string{"/api/" + version.APIVersion, "/api"} {
+			if !served[method+" "+prefix+path] {
+				t.Errorf("%s %s is documented but not served", method, prefix+path)
+			}
+		}
+	}
+}
+
+// ginPath turns OpenAPI parameters back into gin ones, e.g. {scanId} into
+// :scanId
+func ginPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + strings.Trim(segment, "{}")
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// GetSourceIPs lists the addresses a scan's workers scan from
+func (h *Handler) GetSourceIPs(c *gin.Context) {
+	ips, err := h.orchestrator.SourceIPs(c.Param("scanId"))
+	if err != nil {
+		orchestratorError(c, err, "Failed to list source IPs")
+		return
+	}
+
+	c.JSON(200, ips)
+}
+
+// ReleaseScan lets the workers of a held scan start scanning
+func (h *Handler) ReleaseScan(c *gin.Context) {
+	status, err := h.orchestrator.ReleaseScan(c.Param("scanId"))
+	if err != nil {
+		orchestratorError(c, err, "Failed to release scan")
+		return
+	}
+
+	c.JSON(200, status)
+}
+
+// ScanGate tells a worker of a held scan whether it may start scanning
+func (h *Handler) ScanGate(c *gin.Context) {
+	released, err := h.orchestrator.ScanReleased(c.Param("scanId"), c.Param("workerId"))
+	if err != nil {
+		orchestratorError(c, err, "Failed to check scan release")
+		return
+	}
+
+	c.JSON(200, gin.H{"released": released})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+// forwardedHeader marks requests one replica passed on to another, which
+// serves them whatever it holds rather than passing them on again
+const forwardedHeader = "X-Nuclei-Replica"
+
+// ForwardScan passes requests about a scan another replica runs on to that
+// replica, worker callbacks included, so any replica takes any request
+func (h *Handler) ForwardScan(c *gin.Context) {
+	scanID := c.Param("scanId")
+	if scanID == "" || c.GetHeader(forwardedHeader) != "" {
+		c.Next()
+		return
+	}
+	if target := h.orchestrator.ScanReplica(scanID); target != "" {
+		h.forward(c, target)
+		return
+	}
+	c.Next()
+}
+
+// ForwardPool passes warm pool workers' requests on to the leader, the only
+// replica keeping a pool
+func (h *Handler) ForwardPool(c *gin.Context) {
+	if c.GetHeader(forwardedHeader) != "" {
+		c.Next()
+		return
+	}
+	if target := h.orchestrator.LeaderReplica(); target != "" {
+		h.forward(c, target)
+		return
+	}
+	c.Next()
+}
+
+// forward proxies a request to the replica at target and ends it there.
+// Event streams and long polls are flushed as they come.
+func (h *Handler) forward(c *gin.Context, target string) {
+	base, err := url.Parse(target)
+	if err != nil {
+		requestLog(c).Error("Invalid replica URL", "url", target, "error", err)
+		respondError(c, 502, CodeUnavailable, "Failed to reach the replica running the scan")
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(base)
+	proxy.FlushInterval = -1
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set(forwardedHeader, c.GetString(requestIDKey))
+		// Responses are compressed on the way out of this replica
+		req.Header.Del("Accept-Encoding")
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		requestLog(c).Error("Failed to forward request to replica", "url", target, "error", err)
+		respondError(c, 502, CodeUnavailable, "Failed to reach the replica running the scan")
+	}
+
+	requestLog(c).Debug("Forwarding request to replica", "url", target)
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// Relayed delivers a broadcast another replica published to this replica's
+// clients of the scan or, without a scan, its dashboard
+func (wsm *WebSocketManager) Relayed(scanID, kind string, data json.RawMessage) {
+	wsm.mutex.Lock()
+	defer wsm.mutex.Unlock()
+
+	if scanID == "" {
+		wsm.queueDashboard(types.WebSocketMessage{Type: kind, Data: data})
+		return
+	}
+	wsm.deliver(scanID, types.WebSocketMessage{Type: kind, Data: relayedData(kind, data)})
+}
+
+// relayedData decodes the data of the broadcasts that are filtered or fed
+// into the dashboard; others go out as they came
+func relayedData(kind string, data json.RawMessage) interface{} {
+	var value interface{}
+	switch kind {
+	case "status_update", "scan_complete":
+		value = &types.ScanStatus{}
+	case "results_batch":
+		value = &types.ResultsBatch{}
+	default:
+		return data
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return data
+	}
+	return value
+}
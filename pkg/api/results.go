@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// maxResultBatch is the most findings a batch may hold
+	maxResultBatch = 1000
+	// maxResultBatchBytes is the largest batch accepted once decompressed
+	maxResultBatchBytes = 64 << 20
+	// maxResultLineBytes is the longest finding kept from a batch
+	maxResultLineBytes = 1 << 20
+)
+
+var (
+	errResultBatchTooLarge = fmt.Errorf("batch exceeds %d bytes decompressed", maxResultBatchBytes)
+	errResultBatchTooLong  = fmt.Errorf("batch holds more than %d findings", maxResultBatch)
+)
+
+// ReceiveResultBatch handles a batch of results from worker droplets: NDJSON,
+// one finding per line, gzip-compressed when sent with Content-Encoding:
+// gzip. Malformed and invalid lines are skipped and counted; the other
+// findings are stored at once, so a batch that failed can be sent again.
+func (h *Handler) ReceiveResultBatch(c *gin.Context) {
+	scanID := c.Param("scanId")
+	workerID := c.Param("workerId")
+
+	body := io.Reader(c.Request.Body)
+	switch encoding := strings.ToLower(c.GetHeader("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		decompressed, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			resultBatchError(c, err)
+			return
+		}
+		defer decompressed.Close()
+		body = decompressed
+	default:
+		respondError(c, 415, CodeInvalidRequest, "Content-Encoding must be gzip or identity")
+		return
+	}
+
+	results, skipped, err := readResultBatch(body)
+	if err != nil {
+		requestLog(c).Warn("Invalid result batch", "scan_id", scanID, "worker_id", workerID, "error", err)
+		resultBatchError(c, err)
+		return
+	}
+
+	batch, err := h.storeResults(scanID, workerID, results)
+	if err != nil {
+		if errors.Is(err, orchestrator.ErrScanNotFound) {
+			requestLog(c).Warn("Rejected result batch", "scan_id", scanID, "worker_id", workerID, "error", err)
+			orchestratorError(c, err, "Failed to store results")
+			return
+		}
+		// Workers retry failed batches, so a store outage does not lose results
+		requestLog(c).Error("Failed to store results", "scan_id", scanID, "worker_id", workerID, "error", err)
+		respondError(c, 503, CodeUnavailable, "Failed to store results")
+		return
+	}
+	skipped += batch.Invalid
+
+	requestLog(c).Debug("Received result batch", "scan_id", scanID, "worker_id", workerID, "accepted", len(batch.Stored),
+		"duplicates", batch.Duplicates, "blocked", batch.Blocked, "skipped", skipped)
+	c.JSON(200, gin.H{
+		"status":     "received",
+		"accepted":   len(batch.Stored),
+		"duplicates": batch.Duplicates,
+		"blocked":    batch.Blocked,
+		"skipped":    skipped,
+	})
+}
+
+// storeResults stores a batch of a worker's results and queues those stored
+// for live clients
+func (h *Handler) storeResults(scanID, workerID string, results []types.ScanResult) (*orchestrator.ResultBatch, error) {
+	// Set metadata
+	now := time.Now()
+	for i := range results {
+		results[i].Timestamp = now
+		results[i].WorkerID = workerID
+	}
+
+	batch, err := h.orchestrator.AddResults(scanID, results)
+	if err != nil {
+		return nil, err
+	}
+	for i := range batch.Stored {
+		h.wsManager.BroadcastResult(scanID, &batch.Stored[i])
+	}
+	return batch, nil
+}
+
+// readResultBatch decodes the findings of a batch, one per line. Blank lines
+// are ignored; lines that are too long or not a valid finding are skipped
+// and counted.
+func readResultBatch(body io.Reader) ([]types.ScanResult, int, error) {
+	reader := bufio.NewReader(io.LimitReader(body, maxResultBatchBytes+1))
+	var results []types.ScanResult
+	skipped := 0
+	read := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		read += len(line)
+		if read > maxResultBatchBytes {
+			return nil, 0, errResultBatchTooLarge
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if len(results)+skipped == maxResultBatch {
+				return nil, 0, errResultBatchTooLong
+			}
+			var result types.ScanResult
+			if len(line) > maxResultLineBytes || binding.JSON.BindBody(line, &result) != nil {
+				skipped++
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return results, skipped, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// resultBatchError answers a batch that could not be read
+func resultBatchError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondError(c, 413, CodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+	case errors.Is(err, errResultBatchTooLarge), errors.Is(err, errResultBatchTooLong):
+		respondError(c, 413, CodePayloadTooLarge, "Result "+err.Error())
+	default:
+		respondError(c, 400, CodeInvalidRequest, "Invalid result batch: "+err.Error())
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+// findingLines returns n distinct findings, one per line
+func findingLines(n int) string {
+	var lines strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&lines, `{"host":"host%d.example.com","template":"tech-detect"}`+"\n", i)
+	}
+	return lines.String()
+}
+
+func TestReadResultBatch(t *testing.T) {
+	// Lines of spaces are blank but still count towards the size limit
+	blank := strings.Repeat(" ", 1023) + "\n"
+	full := strings.Repeat(blank, maxResultBatchBytes/len(blank))
+
+	tests := []struct {
+		name        string
+		body        string
+		wantResults int
+		wantSkipped int
+		wantErr     error
+	}{
+		{name: "empty", body: ""},
+		{name: "findings", body: findingLines(3), wantResults: 3},
+		{name: "no trailing newline", body: strings.TrimSuffix(findingLines(2), "\n"), wantResults: 2},
+		{name: "blank lines", body: "\n\n" + findingLines(1) + "   \n\r\n", wantResults: 1},
+		{
+			name:        "malformed lines",
+			body:        "not json\n" + findingLines(2) + "[1, 2]\n" + `{"host": 5}` + "\n{\"host\":\n",
+			wantResults: 2,
+			wantSkipped: 4,
+		},
+		{
+			name:        "line too long",
+			body:        `{"host":"a.example.com","template":"` + strings.Repeat("x", maxResultLineBytes) + `"}` + "\n" + findingLines(1),
+			wantResults: 1,
+			wantSkipped: 1,
+		},
+		{name: "as many findings as allowed", body: findingLines(maxResultBatch), wantResults: maxResultBatch},
+		{name: "one finding too many", body: findingLines(maxResultBatch + 1), wantErr: errResultBatchTooLong},
+		{
+			name:    "skipped lines count towards the findings",
+			body:    findingLines(maxResultBatch-1) + "not json\nnot json either\n",
+			wantErr: errResultBatchTooLong,
+		},
+		{name: "as many bytes as allowed", body: full},
+		{name: "one byte too many", body: full + "x", wantErr: errResultBatchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, skipped, err := readResultBatch(strings.NewReader(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readResultBatch() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(results) != tt.wantResults || skipped != tt.wantSkipped {
+				t.Errorf("readResultBatch() = %d results, %d skipped, want %d and %d", len(results), skipped,
+					tt.wantResults, tt.wantSkipped)
+			}
+			for i, result := range results {
+				if result.Host == "" {
+					t.Errorf("result %d has no host", i)
+				}
+			}
+		})
+	}
+}
+
+// postResultBatch hands a gzip-compressed batch straight to the handler,
+// past worker authentication
+func postResultBatch(t *testing.T, h *Handler, scanID, workerID, lines string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	compressed := gzip.NewWriter(&body)
+	if _, err := compressed.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/api/v1/results/"+scanID+"/"+workerID+"/batch", &body)
+	c.Request.Header.Set("Content-Type", "application/x-ndjson")
+	c.Request.Header.Set("Content-Encoding", "gzip")
+	c.Params = gin.Params{{Key: "scanId", Value: scanID}, {Key: "workerId", Value: workerID}}
+	h.ReceiveResultBatch(c)
+	return recorder
+}
+
+func TestReceiveResultBatch(t *testing.T) {
+	_, orch := newTestServer(t, Config{})
+	h := NewHandler(orch, Config{})
+	req := &types.ScanRequest{Domains: []string{"example.com"}, Droplets: 1}
+	if _, err := orch.StartScan(context.Background(), req); err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	workerID := req.ID[:8] + "-worker-0"
+
+	t.Run("skipped lines are counted", func(t *testing.T) {
+		recorder := postResultBatch(t, h, req.ID, workerID, "not json\n"+findingLines(2)+"[]\n")
+		if recorder.Code != 200 {
+			t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+		}
+		var body struct {
+			Accepted int `json:"accepted"`
+			Skipped  int `json:"skipped"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("body %q does not decode: %v", recorder.Body, err)
+		}
+		if body.Accepted != 2 || body.Skipped != 2 {
+			t.Errorf("accepted %d and skipped %d, want 2 and 2", body.Accepted, body.Skipped)
+		}
+	})
+
+	t.Run("too many findings", func(t *testing.T) {
+		recorder := postResultBatch(t, h, req.ID, workerID, findingLines(maxResultBatch+1))
+		body := decodeError(t, recorder, 413, CodePayloadTooLarge)
+		if !strings.Contains(body.Message, fmt.Sprint(maxResultBatch)) {
+			t.Errorf("message %q does not name the limit", body.Message)
+		}
+	})
+
+	t.Run("too large decompressed", func(t *testing.T) {
+		// Compresses to well under the request body limit
+		recorder := postResultBatch(t, h, req.ID, workerID, strings.Repeat(" ", maxResultBatchBytes)+"\n")
+		body := decodeError(t, recorder, 413, CodePayloadTooLarge)
+		if !strings.Contains(body.Message, fmt.Sprint(maxResultBatchBytes)) {
+			t.Errorf("message %q does not name the limit", body.Message)
+		}
+	})
+
+	status, err := orch.GetScanStatus(req.ID)
+	if err != nil {
+		t.Fatalf("GetScanStatus() error = %v", err)
+	}
+	if status.ResultCount != 2 {
+		t.Errorf("ResultCount = %d, want only the 2 findings of the accepted batch", status.ResultCount)
+	}
+}
@@ -1,13 +1,33 @@
 package api
 
 import (
+	"log/slog"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"nuclei-distributed/pkg/orchestrator"
+	"nuclei-distributed/pkg/version"
 )
 
-// SetupRoutes configures all API routes
-func SetupRoutes(r *gin.Engine, orch *orchestrator.Orchestrator) {
-	handler := NewHandler(orch)
+// SetupRoutes configures all API routes. Management endpoints and the
+// WebSocket require one of the configured API keys, admin endpoints an admin
+// key; workers use their own tokens.
+func SetupRoutes(r *gin.Engine, orch *orchestrator.Orchestrator, config Config) {
+	handler := NewHandler(orch, config)
+
+	// Hosts are URLs, so routes see escaped paths and a host like
+	// https%3A%2F%2Fexample.com stays one parameter
+	r.UseRawPath = true
+	r.UnescapePathValues = true
+
+	// Request IDs, traces and access logs, then cross-origin callers, before
+	// any route so preflights are answered for every path. Responses are
+	// compressed for clients that accept gzip.
+	r.Use(requestID)
+	if config.Tracing {
+		r.Use(traceRequests)
+	}
+	r.Use(logRequests, handler.CORS, compressResponses)
 
 	// Serve static files
 	r.Static("/static", "./web/dist/static")
@@ -17,25 +37,170 @@ func SetupRoutes(r *gin.Engine, orch *orchestrator.Orchestrator) {
 	})
 	r.StaticFile("/favicon.ico", "./web/dist/favicon.ico")
 
-	// API routes
-	api := r.Group("/api")
-	{
-		// Scan management
-		api.POST("/scan", handler.StartScan)
-		api.GET("/scan/:scanId/status", handler.GetScanStatus)
-		api.GET("/scan/:scanId/results", handler.GetResults)
+	// The API lives under /api/v1. The unversioned /api paths it started
+	// out with remain as deprecated aliases. Requests about a scan another
+	// replica runs go there first.
+	registerAPI := func(group *gin.RouterGroup) {
+		// Management endpoints, authenticated with API keys. Changes made
+		// there and through the admin endpoints are audited.
+		manage := group.Group("", handler.ForwardScan, handler.RequireAPIKey, handler.Audit)
+		for _, route := range handler.managementRoutes() {
+			manage.Handle(route.method, route.path, route.handlers...)
+		}
 
-		// Worker communication
-		api.POST("/results/:scanId/:workerId", handler.ReceiveResults)
-		api.POST("/heartbeat/:scanId/:workerId", handler.WorkerHeartbeat)
-		api.POST("/complete/:scanId/:workerId", handler.CompleteWorker)
+		// Account-wide operations, authenticated with admin API keys
+		admin := group.Group("", handler.RequireAdminKey, handler.Audit)
+		for _, route := range handler.adminRoutes() {
+			admin.Handle(route.method, route.path, route.handlers...)
+		}
+
+		// Worker communication, authenticated with per-worker tokens
+		worker := group.Group("", handler.ForwardScan, handler.RequireWorkerToken)
+		for _, route := range handler.workerRoutes() {
+			worker.Handle(route.method, route.path, route.handlers...)
+		}
+
+		// Warm pool workers asking for work, authenticated with their own
+		// tokens until a scan claims them, on the leader
+		pool := group.Group("", handler.ForwardPool, handler.RequirePoolToken)
+		for _, route := range handler.poolRoutes() {
+			pool.Handle(route.method, route.path, route.handlers...)
+		}
 	}
+	registerAPI(r.Group("/api/"+version.APIVersion, handler.LimitBody))
+	registerAPI(r.Group("/api", deprecatedAPI, handler.LimitBody))
 
-	// WebSocket endpoint
-	r.GET("/ws/:scanId", handler.HandleWebSocket)
+	// Build and API version, open so tooling can check compatibility first
+	r.GET("/api/version", handler.GetVersion)
 
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy"})
-	})
+	// WebSocket endpoints for one scan and for the dashboard of every scan,
+	// authenticated like the management endpoints
+	r.GET("/ws/:scanId", handler.RequireAPIKey, handler.HandleWebSocket)
+	r.GET("/ws", handler.RequireAPIKey, handler.HandleDashboardWebSocket)
+
+	// Health of the server's dependencies, and readiness for load balancers
+	r.GET("/health", handler.Health)
+	r.GET("/ready", handler.Ready)
+
+	// Profiles and memory counts, only when enabled
+	registerDebug(r, handler)
+
+	// OpenAPI specification and Swagger UI, open like the version. Startup
+	// fails when a route above is missing from the specification.
+	spec, err := handler.openAPISpec()
+	if err == nil {
+		err = serveDocs(r, spec)
+	}
+	if err != nil {
+		slog.Error("Invalid OpenAPI specification", "error", err)
+		os.Exit(1)
+	}
+}
+
+// route is an API endpoint, registered under every API prefix
+type route struct {
+	method   string
+	path     string
+	handlers []gin.HandlerFunc
+}
+
+func newRoute(method, path string, handlers ...gin.HandlerFunc) route {
+	return route{method: method, path: path, handlers: handlers}
+}
+
+// managementRoutes are the endpoints that require an API key
+func (h *Handler) managementRoutes() []route {
+	return []route{
+		// Scan management, starting scans is rate limited per client IP.
+		// Retries with an Idempotency-Key are replayed before the limit.
+		newRoute("POST", "/scan", h.Idempotent, h.LimitScanRate, h.StartScan),
+		newRoute("POST", "/scan/upload", h.LimitScanRate, h.UploadScan),
+		newRoute("GET", "/scans", h.ListScans),
+		newRoute("PATCH", "/scan/:scanId", h.UpdateScan),
+		newRoute("PATCH", "/scan/:scanId/targets", h.AddTargets),
+		newRoute("GET", "/scan/:scanId/config", h.GetScanConfig),
+		newRoute("POST", "/scan/:scanId/rerun", h.LimitScanRate, h.RerunScan),
+		newRoute("POST", "/scan/:scanId/rebalance", h.RebalanceScan),
+		newRoute("GET", "/scan/:scanId/status", h.GetScanStatus),
+		newRoute("GET", "/scan/:scanId/status/wait", h.WaitScanStatus),
+		newRoute("GET", "/scan/:scanId/events", h.StreamScanEvents),
+		newRoute("GET", "/scan/:scanId/results", h.GetResults),
+		newRoute("PATCH", "/scan/:scanId/results/:resultId", h.UpdateTriage),
+		newRoute("POST", "/scan/:scanId/results/:resultId/ticket", h.CreateTicket),
+		newRoute("GET", "/scan/:scanId/stats", h.GetStats),
+		newRoute("GET", "/scan/:scanId/hosts", h.GetHosts),
+		newRoute("GET", "/scan/:scanId/hosts/:host/results", h.GetHostResults),
+		newRoute("GET", "/scan/:scanId/report.html", h.GetReport),
+		newRoute("GET", "/scan/:scanId/artifacts", h.GetArtifacts),
+		newRoute("GET", "/scan/:scanId/archive.zip", h.GetArchive),
+		newRoute("GET", "/scan/:scanId/worker/:workerId/logs", h.GetWorkerLogs),
+		newRoute("GET", "/scan/:scanId/orchestrator-log", h.GetOrchestratorLog),
+		newRoute("POST", "/scan/:scanId/secrets", h.SetSecrets),
+		newRoute("GET", "/scan/:scanId/problem-hosts", h.GetProblemHosts),
+		newRoute("GET", "/scan/:scanId/coverage", h.GetCoverage),
+		newRoute("GET", "/scan/:scanId/webhooks", h.GetWebhooks),
+		newRoute("GET", "/scan/:scanId/source-ips", h.GetSourceIPs),
+		newRoute("POST", "/scan/:scanId/release", h.ReleaseScan),
+		newRoute("GET", "/scans/diff", h.DiffScans),
+		newRoute("GET", "/opensearch/dead-letters", h.GetDeadLetters),
+		newRoute("GET", "/stats/throughput", h.GetThroughputStats),
+		newRoute("GET", "/stats/costs", h.GetCostStats),
+
+		// Single-use tickets that open WebSockets and event streams
+		newRoute("POST", "/ws-ticket", h.CreateWebSocketTicket),
+
+		// False-positive rules applied to incoming findings
+		newRoute("GET", "/fp-rules", h.ListRules),
+		newRoute("POST", "/fp-rules", h.CreateRule),
+		newRoute("GET", "/fp-rules/:ruleId", h.GetRule),
+		newRoute("PUT", "/fp-rules/:ruleId", h.UpdateRule),
+		newRoute("DELETE", "/fp-rules/:ruleId", h.DeleteRule),
+
+		// Targets that are never scanned; admin keys change them
+		newRoute("GET", "/blocklist", h.GetBlocklist),
+	}
+}
+
+// adminRoutes are the endpoints that require an admin API key
+func (h *Handler) adminRoutes() []route {
+	return []route{
+		// Worker droplets across scans, including leaked ones
+		newRoute("GET", "/admin/droplets", h.ListDroplets),
+		newRoute("DELETE", "/admin/droplets/:dropletId", h.DeleteDroplet),
+		// Credentials and settings, checked as at startup
+		newRoute("POST", "/admin/selfcheck", h.SelfCheck),
+		// Settings changeable at runtime, and who changed them
+		newRoute("GET", "/admin/config", h.GetConfig),
+		newRoute("PATCH", "/admin/config", h.UpdateConfig),
+		newRoute("GET", "/admin/audit", h.AuditLog),
+		// Targets that are never scanned, so scan users cannot lift them
+		newRoute("POST", "/blocklist", h.AddBlocklist),
+		newRoute("DELETE", "/blocklist", h.RemoveBlocklist),
+	}
+}
+
+// workerRoutes are the endpoints workers call with their tokens
+func (h *Handler) workerRoutes() []route {
+	return []route{
+		newRoute("POST", "/results/:scanId/:workerId", h.ReceiveResults),
+		newRoute("POST", "/results/:scanId/:workerId/batch", h.ReceiveResultBatch),
+		newRoute("POST", "/heartbeat/:scanId/:workerId", h.WorkerHeartbeat),
+		newRoute("POST", "/logs/:scanId/:workerId", h.ReceiveLogs),
+		newRoute("POST", "/complete/:scanId/:workerId", h.CompleteWorker),
+		newRoute("GET", "/secrets/:scanId/:workerId", h.FetchSecrets),
+		newRoute("GET", "/config/:scanId/:workerId", h.FetchConfig),
+		newRoute("GET", "/targets/:scanId/:workerId", h.PullTargets),
+		newRoute("GET", "/targets/:scanId/:workerId/next", h.LeaseTargets),
+		newRoute("POST", "/targets/:scanId/:workerId/batches/:batchId", h.ConfirmBatch),
+		newRoute("POST", "/targets/:scanId/:workerId/done", h.ReportTargetsDone),
+		newRoute("POST", "/targets/:scanId/:workerId/release", h.ReleaseTargets),
+		newRoute("GET", "/gate/:scanId/:workerId", h.ScanGate),
+	}
+}
+
+// poolRoutes are the endpoints idle warm pool workers call with their tokens
+func (h *Handler) poolRoutes() []route {
+	return []route{
+		newRoute("GET", "/pool/:workerId/assignment", h.PoolAssignment),
+	}
 }
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin/binding"
+	"nuclei-distributed/pkg/orchestrator"
+)
+
+// takeWorkerMessage handles a result batch, heartbeat or completion a worker
+// pushed onto its scan's stream like the callback it stands for. Only a
+// batch that could not be stored fails, to be read again; malformed and
+// rejected messages are dropped, as their callbacks would be rejected.
+func (h *Handler) takeWorkerMessage(scanID, workerID, kind string, body []byte) error {
+	log := slog.With("transport", "redis")
+	switch kind {
+	case orchestrator.StreamResults:
+		results, skipped, err := readResultBatch(bytes.NewReader(body))
+		if err != nil {
+			log.Warn("Invalid result batch", "scan_id", scanID, "worker_id", workerID, "error", err)
+			return nil
+		}
+		batch, err := h.storeResults(scanID, workerID, results)
+		if errors.Is(err, orchestrator.ErrScanNotFound) {
+			log.Warn("Rejected result batch", "scan_id", scanID, "worker_id", workerID, "error", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug("Received result batch", "scan_id", scanID, "worker_id", workerID, "accepted", len(batch.Stored),
+			"duplicates", batch.Duplicates, "blocked", batch.Blocked, "skipped", skipped+batch.Invalid)
+	case orchestrator.StreamHeartbeat:
+		var heartbeat heartbeatRequest
+		if err := binding.JSON.BindBody(body, &heartbeat); err != nil {
+			log.Warn("Invalid heartbeat", "scan_id", scanID, "worker_id", workerID, "error", err)
+			return nil
+		}
+		h.recordHeartbeat(scanID, workerID, heartbeat)
+	case orchestrator.StreamComplete:
+		var report completeRequest
+		if len(body) > 0 {
+			if err := binding.JSON.BindBody(body, &report); err != nil {
+				log.Warn("Invalid completion", "scan_id", scanID, "worker_id", workerID, "error", err)
+				return nil
+			}
+		}
+		h.completeWorker(log, scanID, workerID, report)
+	default:
+		log.Warn("Dropping worker message of unknown kind", "scan_id", scanID, "worker_id", workerID, "kind", kind)
+	}
+	return nil
+}
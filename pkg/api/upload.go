@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// DefaultMaxUploadBytes is the largest targets file accepted unless
+	// configured otherwise
+	DefaultMaxUploadBytes = 10 << 20
+	// maxUploadFormBytes leaves room for the form fields next to the file
+	maxUploadFormBytes = 1 << 20
+	// maxTargetLineBytes is the longest line read from a targets file
+	maxTargetLineBytes = 64 << 10
+)
+
+// UploadScan starts a scan from a multipart form. The targets file holds one
+// target per line; blank lines and lines starting with # are skipped. The
+// droplets field sets the number of droplets and the options field takes any
+// other scan settings as the JSON accepted by StartScan.
+func (h *Handler) UploadScan(c *gin.Context) {
+	limit := h.config.MaxUploadBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+maxUploadFormBytes)
+
+	file, header, err := c.Request.FormFile("targets")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, 413, CodePayloadTooLarge, fmt.Sprintf("Targets file exceeds %d bytes", limit))
+			return
+		}
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "Invalid upload",
+			Details: []types.FieldError{{Field: "targets", Reason: "a file is required"}},
+		})
+		return
+	}
+	defer file.Close()
+	if header.Size > limit {
+		respondError(c, 413, CodePayloadTooLarge, fmt.Sprintf("Targets file exceeds %d bytes", limit))
+		return
+	}
+
+	var req types.ScanRequest
+	if options := c.Request.FormValue("options"); options != "" {
+		if err := json.Unmarshal([]byte(options), &req); err != nil {
+			c.AbortWithStatusJSON(400, types.ErrorResponse{
+				Code:    CodeInvalidRequest,
+				Message: "Invalid upload",
+				Details: prefixFields("options", fieldErrors(err)),
+			})
+			return
+		}
+		if len(req.Domains) > 0 {
+			respondError(c, 400, CodeInvalidRequest, "Targets must be uploaded as the targets file, not in options")
+			return
+		}
+		req.RerunOf = ""
+	}
+	if droplets := c.Request.FormValue("droplets"); droplets != "" {
+		req.Droplets, err = strconv.Atoi(droplets)
+		if err != nil {
+			c.AbortWithStatusJSON(400, types.ErrorResponse{
+				Code:    CodeInvalidRequest,
+				Message: "Invalid upload",
+				Details: []types.FieldError{{Field: "droplets", Reason: "must be a number"}},
+			})
+			return
+		}
+	}
+
+	targets, err := readTargets(file)
+	if err != nil {
+		c.AbortWithStatusJSON(400, types.ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "Invalid upload",
+			Details: []types.FieldError{{Field: "targets", Reason: err.Error()}},
+		})
+		return
+	}
+	req.Domains = targets.valid
+
+	h.startScan(c, &req, gin.H{
+		"invalid_lines":   targets.invalid,
+		"duplicate_lines": targets.duplicates,
+	})
+}
+
+// uploadedTargets is the outcome of reading a targets file
+type uploadedTargets struct {
+	valid      []string
+	invalid    int // lines dropped because they cannot be a target
+	duplicates int
+}
+
+// readTargets reads a targets file, trimming and deduplicating lines. Lines
+// with whitespace or control characters inside are dropped as invalid.
+func readTargets(r io.Reader) (uploadedTargets, error) {
+	var targets uploadedTargets
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxTargetLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.IndexFunc(line, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			targets.invalid++
+			continue
+		}
+		if seen[line] {
+			targets.duplicates++
+			continue
+		}
+		seen[line] = true
+		targets.valid = append(targets.valid, line)
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return targets, fmt.Errorf("has a line longer than %d bytes", maxTargetLineBytes)
+	}
+	return targets, scanner.Err()
+}
+
+// prefixFields places field errors under a parent field, e.g. options.timeout
+func prefixFields(parent string, fields []types.FieldError) []types.FieldError {
+	for i := range fields {
+		if fields[i].Field == "body" {
+			fields[i].Field = parent
+		} else {
+			fields[i].Field = parent + "." + fields[i].Field
+		}
+	}
+	return fields
+}
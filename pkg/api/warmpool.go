@@ -0,0 +1,22 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// PoolAssignment hands a warm pool worker the job of the scan that claimed
+// it, if any
+func (h *Handler) PoolAssignment(c *gin.Context) {
+	assignment := h.orchestrator.PoolAssignment(c.Param("workerId"))
+	if assignment == nil {
+		c.JSON(200, gin.H{"assigned": false})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"assigned":  true,
+		"scan_id":   assignment.ScanID,
+		"worker_id": assignment.WorkerID,
+		"script":    assignment.Script,
+	})
+}
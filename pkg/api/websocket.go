@@ -1,144 +1,218 @@
 package api
 
 import (
-	"log"
-	"net/http"
-	"sync"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"nuclei-distributed/pkg/orchestrator"
 	"nuclei-distributed/pkg/types"
 )
 
-type WebSocketManager struct {
-	clients   map[string]map[*websocket.Conn]bool // scanID -> connections
-	broadcast map[string]chan types.WebSocketMessage // scanID -> broadcast channel
-	mutex     sync.RWMutex
-	upgrader  websocket.Upgrader
-}
+const (
+	// wsCloseTimeout bounds the close frame sent to a disconnected client
+	wsCloseTimeout = time.Second
+	// wsWriteWait bounds every write, so a dead peer cannot stall its writer
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent, pongs included,
+	// before it is evicted
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod is how often clients are pinged; shorter than wsPongWait
+	// so a live client's pong arrives in time
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsCloseTooManyClients closes a connection to a scan that has as many
+	// clients as it may; 4429 mirrors HTTP 429
+	wsCloseTooManyClients = 4429
+)
 
-func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
-		clients:   make(map[string]map[*websocket.Conn]bool),
-		broadcast: make(map[string]chan types.WebSocketMessage),
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in development
-			},
-		},
-	}
+// wsIncoming is a message from a client. Data is decoded once its type is
+// known.
+type wsIncoming struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
 }
 
-func (h *Handler) HandleWebSocket(c *gin.Context) {
-	scanID := c.Param("scanId")
-	
-	conn, err := h.wsManager.upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+// parseSubscription decodes the data of a subscribe message. No data
+// subscribes to everything.
+func parseSubscription(data json.RawMessage) (types.WebSocketSubscription, eventFilter, error) {
+	var sub types.WebSocketSubscription
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, &sub); err != nil {
+			return sub, eventFilter{}, err
+		}
 	}
-	defer conn.Close()
-
-	// Register client
-	h.wsManager.RegisterClient(scanID, conn)
-	defer h.wsManager.UnregisterClient(scanID, conn)
+	filter, err := newEventFilter(sub)
+	return sub, filter, err
+}
 
-	log.Printf("Client connected to scan %s", scanID)
+// wsClient is a WebSocket connection. gorilla/websocket allows one writer at
+// a time, so its writer goroutine is the only one writing.
+type wsClient struct {
+	*subscriber
+	conn *websocket.Conn
+}
 
-	// Send current status immediately
-	if status, err := h.orchestrator.GetScanStatus(scanID); err == nil {
-		message := types.WebSocketMessage{
-			Type: "status_update",
-			Data: status,
-		}
-		conn.WriteJSON(message)
+// newWSClient wraps a connection. Closing sends a close frame telling the
+// client why when code is set, and the reader's next read fails.
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		subscriber: newSubscriber(func(code int, reason string) {
+			go func() {
+				if code != 0 {
+					message := websocket.FormatCloseMessage(code, reason)
+					conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsCloseTimeout))
+				}
+				conn.Close()
+			}()
+		}),
+		conn: conn,
 	}
+}
 
-	// Listen for client messages (ping/pong, etc.)
+// writeMessages writes queued messages and pings the client every
+// wsPingPeriod, until the client is closed or a write fails
+func (c *wsClient) writeMessages(scanID string) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	defer c.close(0, "")
 	for {
-		var msg types.WebSocketMessage
-		err := conn.ReadJSON(&msg)
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+		select {
+		case <-c.ready:
+			for _, message := range c.take() {
+				c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if err := c.conn.WriteJSON(message); err != nil {
+					slog.Warn("Failed to write to WebSocket", "scan_id", scanID, "error", err)
+					return
+				}
 			}
-			break
-		}
-
-		// Handle client messages if needed
-		switch msg.Type {
-		case "ping":
-			response := types.WebSocketMessage{
-				Type: "pong",
-				Data: "pong",
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				slog.Warn("Failed to ping WebSocket", "scan_id", scanID, "error", err)
+				return
 			}
-			conn.WriteJSON(response)
+		case <-c.closed:
+			return
 		}
 	}
 }
 
-func (wsm *WebSocketManager) RegisterClient(scanID string, conn *websocket.Conn) {
-	wsm.mutex.Lock()
-	defer wsm.mutex.Unlock()
+// keepAlive makes reads fail once the client has been silent for
+// wsPongWait. Pongs to the server's pings and any message count as signs of
+// life; the reader extends the deadline for messages.
+func (c *wsClient) keepAlive() {
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+}
 
-	if wsm.clients[scanID] == nil {
-		wsm.clients[scanID] = make(map[*websocket.Conn]bool)
-		wsm.broadcast[scanID] = make(chan types.WebSocketMessage, 100)
-		
-		// Start broadcast goroutine for this scan
-		go wsm.handleBroadcast(scanID)
+// CreateWebSocketTicket issues a single-use ticket that opens a WebSocket or
+// event stream, so browsers need not put the API key in the URL
+func (h *Handler) CreateWebSocketTicket(c *gin.Context) {
+	ticket, err := h.orchestrator.IssueWebSocketTicket(c.Request.Context())
+	if err != nil {
+		requestLog(c).Error("Failed to issue WebSocket ticket", "error", err)
+		respondError(c, 503, CodeUnavailable, "Failed to issue ticket")
+		return
 	}
 
-	wsm.clients[scanID][conn] = true
+	c.Header("Cache-Control", "no-store")
+	c.JSON(200, gin.H{"ticket": ticket, "expires_in": int(orchestrator.WebSocketTicketTTL.Seconds())})
 }
 
-func (wsm *WebSocketManager) UnregisterClient(scanID string, conn *websocket.Conn) {
-	wsm.mutex.Lock()
-	defer wsm.mutex.Unlock()
-
-	if clients, exists := wsm.clients[scanID]; exists {
-		if _, exists := clients[conn]; exists {
-			delete(clients, conn)
-			
-			// Clean up if no more clients
-			if len(clients) == 0 {
-				close(wsm.broadcast[scanID])
-				delete(wsm.clients, scanID)
-				delete(wsm.broadcast, scanID)
-			}
+func (h *Handler) HandleWebSocket(c *gin.Context) {
+	scanID := c.Param("scanId")
+
+	// since is the seq of the last message a reconnecting client got
+	var since *uint64
+	if raw := c.Query("since"); raw != "" {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			respondError(c, 400, CodeInvalidRequest, "since must be a non-negative integer")
+			return
 		}
+		since = &seq
 	}
-}
-
-func (wsm *WebSocketManager) BroadcastToScan(scanID string, message types.WebSocketMessage) {
-	wsm.mutex.RLock()
-	broadcastChan, exists := wsm.broadcast[scanID]
-	wsm.mutex.RUnlock()
 
-	if exists {
-		select {
-		case broadcastChan <- message:
-		default:
-			log.Printf("Broadcast channel full for scan %s", scanID)
+	conn, err := h.wsManager.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		requestLog(c).Warn("WebSocket upgrade failed", "scan_id", scanID, "error", err)
+		return
+	}
+	client := newWSClient(conn)
+	client.keepAlive()
+	go client.writeMessages(scanID)
+	defer client.close(0, "")
+
+	// Registering replays what a reconnecting client missed, or sends the
+	// current status
+	replayed, err := h.wsManager.RegisterClient(scanID, client.subscriber, since, func() interface{} {
+		if status, err := h.orchestrator.GetScanStatus(scanID); err == nil {
+			return status
 		}
+		return nil
+	})
+	if err != nil {
+		requestLog(c).Warn("Refusing WebSocket client", "scan_id", scanID, "error", err)
+		client.close(wsCloseTooManyClients, err.Error())
+		return
 	}
-}
+	defer h.wsManager.UnregisterClient(scanID, client.subscriber)
 
-func (wsm *WebSocketManager) handleBroadcast(scanID string) {
-	broadcastChan := wsm.broadcast[scanID]
-	
-	for message := range broadcastChan {
-		wsm.mutex.RLock()
-		clients := wsm.clients[scanID]
-		wsm.mutex.RUnlock()
+	requestLog(c).Info("WebSocket client connected", "scan_id", scanID, "replayed", replayed)
 
-		for conn := range clients {
-			err := conn.WriteJSON(message)
+	client.readMessages(requestLog(c).With("scan_id", scanID), func(msg wsIncoming) {
+		switch msg.Type {
+		case "subscribe":
+			// Every subscribe replaces the previous one
+			sub, filter, err := parseSubscription(msg.Data)
 			if err != nil {
-				log.Printf("Error writing to WebSocket: %v", err)
-				conn.Close()
-				wsm.UnregisterClient(scanID, conn)
+				client.queue(types.WebSocketMessage{
+					Type: "error",
+					Data: types.ErrorResponse{Code: CodeInvalidRequest, Message: "Invalid subscription", Details: err.Error()},
+				})
+				return
 			}
+			client.subscribe(filter)
+			client.queue(types.WebSocketMessage{
+				Type: "subscribed",
+				Data: sub,
+			})
+		}
+	})
+}
+
+// readMessages reads the client's messages until the connection fails or
+// the client goes silent, handing all but pings to handle. Browsers cannot
+// answer protocol pings from script, so JSON pings are answered as well.
+func (c *wsClient) readMessages(log *slog.Logger, handle func(wsIncoming)) {
+	for {
+		var msg wsIncoming
+		err := c.conn.ReadJSON(&msg)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Info("WebSocket client stopped answering pings, evicting")
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Warn("WebSocket closed unexpectedly", "error", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		if msg.Type == "ping" {
+			c.queue(types.WebSocketMessage{
+				Type: "pong",
+				Data: "pong",
+			})
+			continue
 		}
+		handle(msg)
 	}
 }
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSocketTicketOpensOneStream(t *testing.T) {
+	r, _ := newTestServer(t, Config{})
+
+	recorder := serve(r, "POST", "/api/v1/ws-ticket", nil, nil)
+	var issued struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &issued); err != nil || issued.Ticket == "" {
+		t.Fatalf("POST /ws-ticket = %d %s, want a ticket", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", recorder.Header().Get("Cache-Control"))
+	}
+
+	stream := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/scan/unknown/events?ticket="+issued.Ticket, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// The first use gets past authentication to the unknown scan
+	decodeError(t, stream(), 404, CodeScanNotFound)
+	decodeError(t, stream(), 401, CodeUnauthorized)
+}
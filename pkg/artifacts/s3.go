@@ -0,0 +1,265 @@
+// Package artifacts uploads files to S3-compatible object storage such as
+// DigitalOcean Spaces, MinIO or AWS S3, and hands out presigned links to them.
+// Requests are signed with AWS Signature Version 4 and use path-style URLs,
+// which every S3-compatible store accepts.
+package artifacts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+	// MaxLinkExpiry is the longest lifetime SigV4 allows a presigned link
+	MaxLinkExpiry = 7 * 24 * time.Hour
+)
+
+// Config points the uploader at a bucket
+type Config struct {
+	// Endpoint is the store's base URL, e.g. https://nyc3.digitaloceanspaces.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// LinkExpiry is how long presigned download links stay valid
+	LinkExpiry time.Duration
+}
+
+// Client uploads objects to one bucket
+type Client struct {
+	config   Config
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// NewClient checks cfg and returns a client for its bucket
+func NewClient(cfg Config) (*Client, error) {
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return nil, fmt.Errorf("artifact endpoint must be an http(s) URL")
+	}
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("artifact storage needs a bucket, access key and secret key")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.LinkExpiry <= 0 {
+		cfg.LinkExpiry = time.Hour
+	}
+	if cfg.LinkExpiry > MaxLinkExpiry {
+		cfg.LinkExpiry = MaxLinkExpiry
+	}
+
+	return &Client{
+		config:   cfg,
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// objectPath is the escaped path of an object under the bucket
+func (c *Client) objectPath(key string) string {
+	return "/" + uriEncode(c.config.Bucket, false) + "/" + uriEncode(key, true)
+}
+
+// ObjectURL returns the unsigned URL of an object
+func (c *Client) ObjectURL(key string) string {
+	return c.endpoint.Scheme + "://" + c.endpoint.Host + c.objectPath(key)
+}
+
+// Put uploads a file as key. The payload is sent unsigned so large files are
+// streamed rather than hashed up front.
+func (c *Client) Put(ctx context.Context, key, contentType string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.ObjectURL(key), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req, c.objectPath(key), time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload of %s returned %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Delete removes the object at key. Deleting a missing object succeeds.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.ObjectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	c.sign(req, c.objectPath(key), time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("delete of %s returned %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Presign returns a GET link for key that is valid for the configured expiry
+func (c *Client) Presign(key string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := c.scope(now)
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     signingAlgorithm,
+		"X-Amz-Credential":    c.config.AccessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(c.config.LinkExpiry / time.Second)),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		c.objectPath(key),
+		canonicalQuery,
+		"host:" + c.endpoint.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signature := c.signature(now, amzDate, scope, canonicalRequest)
+	link := c.ObjectURL(key) + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+	return link, now.Add(c.config.LinkExpiry)
+}
+
+// sign adds SigV4 authorization headers to a request for the object at path
+func (c *Client) sign(req *http.Request, path string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	scope := c.scope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	// Only headers the request carries can be signed
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	signature := c.signature(now, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func (c *Client) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + c.config.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with the key derived for its day
+func (c *Client) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQueryString encodes query parameters sorted by name
+func canonicalQueryString(query map[string]string) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = uriEncode(name, false) + "=" + uriEncode(query[name], false)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as SigV4
+// requires, optionally leaving slashes alone for object paths
+func uriEncode(s string, keepSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && keepSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
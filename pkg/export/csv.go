@@ -0,0 +1,106 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+var csvHeader = []string{
+	"Host", "Template", "TemplateName", "Severity", "Match", "MatchedAt", "MatcherName",
+	"ExtractedResults", "Type", "IP", "Reference", "CurlCommand", "Timestamp", "WorkerID",
+	"ID", "Triage", "TriageNote",
+}
+
+// csvRow flattens a result in csvHeader order
+func csvRow(result types.ScanResult) []string {
+	return []string{
+		result.Host,
+		result.Template,
+		result.TemplateName,
+		result.Severity,
+		result.Match,
+		result.MatchedAt,
+		result.MatcherName,
+		strings.Join(result.ExtractedResults, ";"),
+		result.Type,
+		result.IP,
+		strings.Join(result.Reference, ";"),
+		result.CurlCommand,
+		result.Timestamp.Format(time.RFC3339),
+		result.WorkerID,
+		result.ID,
+		result.TriageStatus(),
+		triageNote(result),
+	}
+}
+
+func triageNote(result types.ScanResult) string {
+	if result.Triage == nil {
+		return ""
+	}
+	return result.Triage.Note
+}
+
+// WriteCSV streams results as CSV with a header row
+func WriteCSV(w io.Writer, results []types.ScanResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		if err := writer.Write(csvRow(result)); err != nil {
+			return err
+		}
+
+		if (i+1)%flushRows == 0 {
+			writer.Flush()
+			flush(w)
+		}
+	}
+
+	writer.Flush()
+	flush(w)
+	return writer.Error()
+}
+
+// WriteDiffCSV writes the findings of a scan diff as CSV, with a leading
+// Change column of new, persisting, fixed or not_rescanned
+func WriteDiffCSV(w io.Writer, diff *types.ScanDiff) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"Change"}, csvHeader...)); err != nil {
+		return err
+	}
+
+	sections := []struct {
+		change  string
+		results []types.ScanResult
+	}{
+		{"new", diff.New},
+		{"persisting", diff.Persisting},
+		{"fixed", diff.Fixed},
+		{"not_rescanned", diff.NotRescanned},
+	}
+
+	rows := 0
+	for _, section := range sections {
+		for _, result := range section.results {
+			if err := writer.Write(append([]string{section.change}, csvRow(result)...)); err != nil {
+				return err
+			}
+			rows++
+			if rows%flushRows == 0 {
+				writer.Flush()
+				flush(w)
+			}
+		}
+	}
+
+	writer.Flush()
+	flush(w)
+	return writer.Error()
+}
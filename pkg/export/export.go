@@ -0,0 +1,17 @@
+// Package export renders scan results into the formats offered for download.
+package export
+
+import (
+	"io"
+	"net/http"
+)
+
+// flushRows is how many rows are written between flushes of a streaming writer
+const flushRows = 500
+
+// flush pushes buffered output to the client when w supports it
+func flush(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
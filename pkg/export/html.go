@@ -0,0 +1,173 @@
+package export
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+//go:embed templates/report.html.tmpl
+var templateFS embed.FS
+
+var reportTemplate = template.Must(template.ParseFS(templateFS, "templates/report.html.tmpl"))
+
+// severityOrder lists severities from most to least severe
+var severityOrder = []string{"critical", "high", "medium", "low", "info", "unknown"}
+
+// ReportMeta describes the scan a report is generated for
+type ReportMeta struct {
+	ScanID         string
+	Status         string
+	TotalDomains   int
+	ScannedDomains int
+	Workers        int
+	GeneratedAt    time.Time
+}
+
+type reportHeader struct {
+	Meta       ReportMeta
+	Total      int
+	Hosts      int
+	Severities []severityCount
+}
+
+type severityCount struct {
+	Name    string
+	Count   int
+	Percent int
+}
+
+type hostSection struct {
+	Host      string
+	Count     int
+	Templates []templateSection
+}
+
+type templateSection struct {
+	ID          string
+	Name        string
+	Severity    string
+	Description string
+	Reference   []string
+	Results     []types.ScanResult
+}
+
+// normalizeSeverity folds severities nuclei may report in any case, or not at
+// all, onto severityOrder
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	for _, known := range severityOrder {
+		if severity == known {
+			return severity
+		}
+	}
+	return "unknown"
+}
+
+func severityRank(severity string) int {
+	severity = normalizeSeverity(severity)
+	for i, known := range severityOrder {
+		if severity == known {
+			return i
+		}
+	}
+	return len(severityOrder)
+}
+
+// WriteHTMLReport writes a self-contained HTML report with a severity summary
+// and findings grouped by host and template. Sections are rendered one host at
+// a time so the page is never built in memory as a whole.
+func WriteHTMLReport(w io.Writer, meta ReportMeta, results []types.ScanResult) error {
+	// Sort a copy by host, severity and template so groups are contiguous
+	sorted := append([]types.ScanResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if ra, rb := severityRank(a.Severity), severityRank(b.Severity); ra != rb {
+			return ra < rb
+		}
+		return a.Template < b.Template
+	})
+
+	header := reportHeader{Meta: meta, Total: len(sorted)}
+	counts := make(map[string]int)
+	for i, result := range sorted {
+		counts[normalizeSeverity(result.Severity)]++
+		if i == 0 || result.Host != sorted[i-1].Host {
+			header.Hosts++
+		}
+	}
+	for _, severity := range severityOrder {
+		entry := severityCount{Name: severity, Count: counts[severity]}
+		if header.Total > 0 {
+			entry.Percent = entry.Count * 100 / header.Total
+		}
+		header.Severities = append(header.Severities, entry)
+	}
+
+	if err := reportTemplate.ExecuteTemplate(w, "header", header); err != nil {
+		return err
+	}
+
+	rendered := 0
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Host == sorted[start].Host {
+			end++
+		}
+
+		if err := reportTemplate.ExecuteTemplate(w, "host", newHostSection(sorted[start:end])); err != nil {
+			return err
+		}
+
+		rendered += end - start
+		if rendered >= flushRows {
+			flush(w)
+			rendered = 0
+		}
+		start = end
+	}
+
+	if err := reportTemplate.ExecuteTemplate(w, "footer", nil); err != nil {
+		return err
+	}
+	flush(w)
+	return nil
+}
+
+// newHostSection groups one host's results, already sorted by severity and
+// template, into template sections
+func newHostSection(results []types.ScanResult) hostSection {
+	section := hostSection{Host: results[0].Host, Count: len(results)}
+
+	for start := 0; start < len(results); {
+		end := start
+		for end < len(results) && results[end].Template == results[start].Template {
+			end++
+		}
+
+		first := results[start]
+		name := first.TemplateName
+		if name == "" {
+			name = first.Template
+		}
+		section.Templates = append(section.Templates, templateSection{
+			ID:          first.Template,
+			Name:        name,
+			Severity:    normalizeSeverity(first.Severity),
+			Description: first.Description,
+			Reference:   first.Reference,
+			Results:     results[start:end],
+		})
+		start = end
+	}
+
+	return section
+}
@@ -0,0 +1,34 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// WriteJSONL streams results as newline-delimited JSON, one result per line
+func WriteJSONL(w io.Writer, results []types.ScanResult) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	for i, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+
+		if (i+1)%flushRows == 0 {
+			if err := buffered.Flush(); err != nil {
+				return err
+			}
+			flush(w)
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	flush(w)
+	return nil
+}
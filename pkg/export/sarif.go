@@ -0,0 +1,221 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string              `json:"id"`
+	Name             string              `json:"name,omitempty"`
+	ShortDescription sarifMessage        `json:"shortDescription"`
+	FullDescription  *sarifMessage       `json:"fullDescription,omitempty"`
+	HelpURI          string              `json:"helpUri,omitempty"`
+	DefaultConfig    sarifRuleConfig     `json:"defaultConfiguration"`
+	Properties       sarifRuleProperties `json:"properties"`
+}
+
+type sarifRuleConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifRuleProperties struct {
+	Severity         string   `json:"severity"`
+	SecuritySeverity string   `json:"security-severity"`
+	References       []string `json:"references,omitempty"`
+	Tags             []string `json:"tags"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID       string             `json:"ruleId"`
+	RuleIndex    int                `json:"ruleIndex"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifLevel maps nuclei severities onto SARIF result levels
+func sarifLevel(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "high":
+		return "error"
+	case "medium":
+		return "warning"
+	case "low", "info":
+		return "note"
+	default:
+		return "none"
+	}
+}
+
+// securitySeverity maps nuclei severities onto the CVSS-like score GitHub
+// code scanning uses to rank security alerts
+func securitySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return "9.5"
+	case "high":
+		return "8.0"
+	case "medium":
+		return "5.5"
+	case "low":
+		return "3.0"
+	default:
+		return "0.0"
+	}
+}
+
+// WriteSARIF writes results as a SARIF 2.1.0 log with one rule per template.
+// A scan without results produces a run with empty rules and results.
+func WriteSARIF(w io.Writer, results []types.ScanResult) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "nuclei",
+			InformationURI: "https://github.com/projectdiscovery/nuclei",
+			Rules:          make([]sarifRule, 0),
+		}},
+		Results: make([]sarifResult, 0, len(results)),
+	}
+
+	ruleIndex := make(map[string]int)
+	for _, result := range results {
+		ruleID := result.Template
+		if ruleID == "" {
+			ruleID = "unknown-template"
+		}
+
+		index, exists := ruleIndex[ruleID]
+		if !exists {
+			index = len(run.Tool.Driver.Rules)
+			ruleIndex[ruleID] = index
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, newSARIFRule(ruleID, result))
+		}
+
+		location := result.MatchedAt
+		if location == "" {
+			location = result.Host
+		}
+
+		name := result.TemplateName
+		if name == "" {
+			name = ruleID
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    ruleID,
+			RuleIndex: index,
+			Level:     sarifLevel(result.Severity),
+			Message:   sarifMessage{Text: fmt.Sprintf("%s matched at %s", name, location)},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: location},
+				},
+			}},
+			Suppressions: sarifSuppressions(result),
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{run},
+	})
+}
+
+// sarifSuppressions marks findings triaged as false positive or accepted risk
+// as suppressed, so code scanning tools do not raise them as open alerts
+func sarifSuppressions(result types.ScanResult) []sarifSuppression {
+	status := result.TriageStatus()
+	if status != types.TriageFalsePositive && status != types.TriageAccepted {
+		return nil
+	}
+	justification := status
+	if result.Triage.Note != "" {
+		justification += ": " + result.Triage.Note
+	}
+	return []sarifSuppression{{Kind: "external", Status: "accepted", Justification: justification}}
+}
+
+func newSARIFRule(ruleID string, result types.ScanResult) sarifRule {
+	name := result.TemplateName
+	if name == "" {
+		name = ruleID
+	}
+
+	rule := sarifRule{
+		ID:               ruleID,
+		Name:             name,
+		ShortDescription: sarifMessage{Text: name},
+		DefaultConfig:    sarifRuleConfig{Level: sarifLevel(result.Severity)},
+		Properties: sarifRuleProperties{
+			Severity:         strings.ToLower(result.Severity),
+			SecuritySeverity: securitySeverity(result.Severity),
+			References:       result.Reference,
+			Tags:             []string{"security", "nuclei"},
+		},
+	}
+
+	if result.Description != "" {
+		rule.FullDescription = &sarifMessage{Text: result.Description}
+	}
+	if len(result.Reference) > 0 {
+		rule.HelpURI = result.Reference[0]
+	}
+
+	return rule
+}
@@ -0,0 +1,333 @@
+// Package forward streams findings to external search stores as they arrive.
+package forward
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// bulkSize is the most documents sent in one bulk request
+	bulkSize = 500
+	// flushInterval is the longest a document waits for its batch to fill
+	flushInterval = 5 * time.Second
+	// forwardQueueSize is the number of documents buffered for indexing
+	forwardQueueSize = 10000
+	// documentAttempts is how often a document is sent before it is
+	// dead-lettered
+	documentAttempts = 5
+	// deadLetterSize is the number of dead-lettered documents kept
+	deadLetterSize = 1000
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// OpenSearchConfig points the exporter at a cluster. Elasticsearch works too,
+// since only the bulk API is used.
+type OpenSearchConfig struct {
+	URL string
+	// IndexPrefix names the daily indices, e.g. nuclei-results-2024.01.31
+	IndexPrefix        string
+	Username           string
+	Password           string
+	InsecureSkipVerify bool
+}
+
+// document is a finding as indexed, with the scan it belongs to
+type document struct {
+	types.ScanResult
+	ScanID        string    `json:"scanId"`
+	ScanCreatedAt time.Time `json:"scanCreatedAt"`
+	IndexedAt     time.Time `json:"@timestamp"`
+}
+
+// pendingDocument is a serialized document waiting to be indexed
+type pendingDocument struct {
+	index    string
+	id       string
+	body     []byte
+	attempts int
+}
+
+// OpenSearch bulk-indexes findings in the background. Batches are retried
+// with exponential backoff while the cluster throttles or fails; documents
+// that keep failing, or that the cluster rejects outright, are moved to a
+// bounded dead-letter buffer.
+type OpenSearch struct {
+	config OpenSearchConfig
+	client *http.Client
+	queue  chan pendingDocument
+
+	mu          sync.Mutex
+	health      types.ForwarderHealth
+	deadLetters []types.DeadLetter
+}
+
+// NewOpenSearch checks config and starts the exporter
+func NewOpenSearch(config OpenSearchConfig) (*OpenSearch, error) {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return nil, fmt.Errorf("OpenSearch URL must be an http(s) URL")
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+	if config.IndexPrefix == "" {
+		config.IndexPrefix = "nuclei-results"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	o := &OpenSearch{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		queue:  make(chan pendingDocument, forwardQueueSize),
+		health: types.ForwarderHealth{Status: "ok"},
+	}
+	go o.run()
+	return o, nil
+}
+
+// Forward queues a finding without blocking. Findings that do not fit in a
+// full queue are counted as dropped.
+func (o *OpenSearch) Forward(scanID string, scanCreatedAt time.Time, result types.ScanResult) {
+	indexedAt := result.Timestamp
+	if indexedAt.IsZero() {
+		indexedAt = time.Now().UTC()
+	}
+
+	body, err := json.Marshal(document{
+		ScanResult:    result,
+		ScanID:        scanID,
+		ScanCreatedAt: scanCreatedAt,
+		IndexedAt:     indexedAt,
+	})
+	if err != nil {
+		slog.Error("Failed to encode finding for OpenSearch", "scan_id", scanID, "error", err)
+		return
+	}
+
+	// A stable ID keeps retried batches from indexing a finding twice
+	sum := sha256.Sum256([]byte(scanID + "|" + result.DedupKey()))
+	doc := pendingDocument{
+		index: o.config.IndexPrefix + "-" + indexedAt.UTC().Format("2006.01.02"),
+		id:    hex.EncodeToString(sum[:16]),
+		body:  body,
+	}
+
+	select {
+	case o.queue <- doc:
+	default:
+		o.mu.Lock()
+		o.health.Dropped++
+		o.mu.Unlock()
+		slog.Warn("OpenSearch queue full, dropping finding", "scan_id", scanID)
+	}
+}
+
+// Health returns the exporter's counters and last error
+func (o *OpenSearch) Health() types.ForwarderHealth {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	health := o.health
+	health.Queued = len(o.queue)
+	health.DeadLetters = len(o.deadLetters)
+	return health
+}
+
+// DeadLetters returns the documents the exporter gave up on, oldest first
+func (o *OpenSearch) DeadLetters() []types.DeadLetter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]types.DeadLetter(nil), o.deadLetters...)
+}
+
+func (o *OpenSearch) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingDocument, 0, bulkSize)
+	for {
+		select {
+		case doc := <-o.queue:
+			batch = append(batch, doc)
+			if len(batch) < bulkSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		o.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush indexes a batch, retrying the documents that failed with a retryable
+// error until they succeed or run out of attempts
+func (o *OpenSearch) flush(batch []pendingDocument) {
+	pending := append([]pendingDocument(nil), batch...)
+	backoff := initialBackoff
+
+	for len(pending) > 0 {
+		retry, indexed, err := o.bulk(pending)
+		if indexed > 0 {
+			o.recordSuccess(indexed)
+		}
+		if err != nil {
+			o.recordError(err)
+		}
+
+		var next []pendingDocument
+		for _, doc := range retry {
+			doc.attempts++
+			if doc.attempts >= documentAttempts {
+				o.deadLetter(doc, err.Error())
+				continue
+			}
+			next = append(next, doc)
+		}
+		pending = next
+		if len(pending) == 0 {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// bulkResponse is the part of a bulk API response used to find failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends one bulk request and returns the documents worth retrying along
+// with the number indexed. Items the cluster rejects for other reasons, such
+// as mapping errors, are dead-lettered straight away since resending them
+// cannot succeed.
+func (o *OpenSearch) bulk(docs []pendingDocument) ([]pendingDocument, int, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": doc.index, "_id": doc.id}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.config.URL+"/_bulk", &body)
+	if err != nil {
+		return docs, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.config.Username != "" {
+		req.SetBasicAuth(o.config.Username, o.config.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return docs, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return docs, 0, fmt.Errorf("bulk request returned %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+
+	var parsed bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return docs, 0, fmt.Errorf("failed to parse bulk response: %v", err)
+	}
+	if !parsed.Errors {
+		return nil, len(docs), nil
+	}
+
+	var retry []pendingDocument
+	var lastErr error
+	indexed := 0
+	for i, item := range parsed.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, outcome := range item {
+			if outcome.Status >= 200 && outcome.Status <= 299 {
+				indexed++
+				continue
+			}
+			lastErr = fmt.Errorf("document rejected with %d: %s", outcome.Status, outcome.Error)
+			if outcome.Status == http.StatusTooManyRequests || outcome.Status >= 500 {
+				retry = append(retry, docs[i])
+			} else {
+				docs[i].attempts++
+				o.deadLetter(docs[i], lastErr.Error())
+			}
+		}
+	}
+	return retry, indexed, lastErr
+}
+
+func (o *OpenSearch) recordSuccess(indexed int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	o.health.Indexed += int64(indexed)
+	o.health.LastSuccessAt = &now
+	o.health.Status = "ok"
+}
+
+func (o *OpenSearch) recordError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	o.health.LastError = err.Error()
+	o.health.LastErrorAt = &now
+	o.health.Status = "degraded"
+	slog.Error("OpenSearch bulk indexing failed", "error", err)
+}
+
+// deadLetter keeps a document the exporter gave up on, evicting the oldest
+// once the buffer is full
+func (o *OpenSearch) deadLetter(doc pendingDocument, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.deadLetters = append(o.deadLetters, types.DeadLetter{
+		Index:    doc.index,
+		ID:       doc.id,
+		Document: json.RawMessage(doc.body),
+		Error:    reason,
+		Attempts: doc.attempts,
+		FailedAt: time.Now(),
+	})
+	if overflow := len(o.deadLetters) - deadLetterSize; overflow > 0 {
+		o.deadLetters = append([]types.DeadLetter(nil), o.deadLetters[overflow:]...)
+	}
+}
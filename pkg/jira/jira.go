@@ -0,0 +1,218 @@
+// Package jira files findings as issues in a Jira project.
+package jira
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// Config selects the Jira site and project issues are created in
+type Config struct {
+	URL     string // e.g. https://example.atlassian.net
+	Project string // project key, e.g. SEC
+	// Email is the account the token belongs to on Jira Cloud; leave it empty
+	// to send the token as a personal access token (Server, Data Center)
+	Email     string
+	Token     string
+	IssueType string // defaults to Bug
+	// AutoSeverity files an issue for every new finding at or above it;
+	// empty leaves issue creation to the API
+	AutoSeverity string
+}
+
+// priorities maps nuclei severities to Jira's default priority scheme
+var priorities = map[string]string{
+	"critical": "Highest",
+	"high":     "High",
+	"medium":   "Medium",
+	"low":      "Low",
+	"info":     "Lowest",
+}
+
+// Client creates issues through the Jira REST API
+type Client struct {
+	config Config
+	client *http.Client
+}
+
+// NewClient checks config and returns a client for it
+func NewClient(config Config) (*Client, error) {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return nil, fmt.Errorf("Jira URL must be an http(s) URL")
+	}
+	if config.Project == "" || config.Token == "" {
+		return nil, fmt.Errorf("Jira project and token are required")
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+	if config.IssueType == "" {
+		config.IssueType = "Bug"
+	}
+
+	return &Client{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// AutoSeverity returns the severity from which findings are filed on ingest
+func (c *Client) AutoSeverity() string {
+	return c.config.AutoSeverity
+}
+
+// Label is the label identifying a finding's issue. It is derived from the
+// finding's dedup key, so the same finding in a later scan maps to the same
+// issue.
+func Label(result types.ScanResult) string {
+	sum := sha256.Sum256([]byte(result.DedupKey()))
+	return "nuclei-" + hex.EncodeToString(sum[:8])
+}
+
+// Find returns the issue already filed for a finding, or nil when there is
+// none
+func (c *Client) Find(ctx context.Context, result types.ScanResult) (*types.Ticket, error) {
+	query := url.Values{}
+	query.Set("jql", fmt.Sprintf(`project = "%s" AND labels = "%s" ORDER BY created ASC`, c.config.Project, Label(result)))
+	query.Set("fields", "created")
+	query.Set("maxResults", "1")
+
+	var found struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/rest/api/2/search?"+query.Encode(), nil, &found); err != nil {
+		return nil, err
+	}
+	if len(found.Issues) == 0 {
+		return nil, nil
+	}
+	return &types.Ticket{
+		Key:       found.Issues[0].Key,
+		URL:       c.browseURL(found.Issues[0].Key),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Create files an issue for a finding. scanURL is linked from the
+// description.
+func (c *Client) Create(ctx context.Context, result types.ScanResult, scanURL string) (*types.Ticket, error) {
+	name := result.TemplateName
+	if name == "" {
+		name = result.Template
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": c.config.Project},
+		"issuetype":   map[string]string{"name": c.config.IssueType},
+		"summary":     truncate(fmt.Sprintf("[%s] %s on %s", strings.ToUpper(result.Severity), name, result.Host), 250),
+		"description": description(result, scanURL),
+		"labels":      []string{"nuclei", Label(result)},
+	}
+	if priority, known := priorities[strings.ToLower(result.Severity)]; known {
+		fields["priority"] = map[string]string{"name": priority}
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+		return nil, err
+	}
+	return &types.Ticket{
+		Key:       created.Key,
+		URL:       c.browseURL(created.Key),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+func (c *Client) browseURL(key string) string {
+	return c.config.URL + "/browse/" + key
+}
+
+// description renders a finding in Jira wiki markup
+func description(result types.ScanResult, scanURL string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Host:* %s\n", result.Host)
+	fmt.Fprintf(&b, "*Template:* %s", result.Template)
+	if result.TemplateName != "" {
+		fmt.Fprintf(&b, " (%s)", result.TemplateName)
+	}
+	fmt.Fprintf(&b, "\n*Severity:* %s\n", result.Severity)
+	if result.MatchedAt != "" {
+		fmt.Fprintf(&b, "*Matched at:* %s\n", result.MatchedAt)
+	}
+	if result.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", result.Description)
+	}
+	if result.CurlCommand != "" {
+		fmt.Fprintf(&b, "\n*Reproduce:*\n{noformat}\n%s\n{noformat}\n", result.CurlCommand)
+	}
+	if len(result.Reference) > 0 {
+		b.WriteString("\n*References:*\n")
+		for _, reference := range result.Reference {
+			fmt.Fprintf(&b, "* %s\n", reference)
+		}
+	}
+	fmt.Fprintf(&b, "\nFound %s, see [scan|%s].", result.Timestamp.Format(time.RFC3339), scanURL)
+	return b.String()
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+// do sends a request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.config.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.config.Email != "" {
+		req.SetBasicAuth(c.config.Email, c.config.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Jira returned status %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(data)), 500))
+	}
+	return json.Unmarshal(data, out)
+}
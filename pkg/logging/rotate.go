@@ -0,0 +1,154 @@
+// Package logging writes the server's logs to files that are rotated by
+// size, so they survive restarts without filling the disk.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for unset rotation settings
+const (
+	DefaultMaxSizeMB  = 100
+	DefaultMaxBackups = 10
+)
+
+// backupTimeFormat names rotated files after the time they were rotated
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateConfig is where a log file is written and how long it grows
+type RotateConfig struct {
+	// Path is the file logs are appended to
+	Path string
+	// MaxSizeMB is the size a file reaches before it is rotated
+	MaxSizeMB int
+	// MaxBackups is the number of rotated files kept, MaxAge how long they
+	// are kept; zero MaxAge keeps them however old
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// RotatingFile appends to a log file and, once it reaches its size, renames
+// it to name-<time>.ext next to it and starts a new one. Rotated files past
+// the number or age kept are removed.
+type RotatingFile struct {
+	config RotateConfig
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+}
+
+// OpenRotatingFile opens the log file of cfg for appending, creating it and
+// its directory when missing
+func OpenRotatingFile(cfg RotateConfig) (*RotatingFile, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("a log file path is required")
+	}
+	if cfg.MaxSizeMB <= 0 {
+		cfg.MaxSizeMB = DefaultMaxSizeMB
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = DefaultMaxBackups
+	}
+	rotating := &RotatingFile{config: cfg}
+	if err := rotating.open(); err != nil {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+// Write appends p to the file, rotating it first when p would take it past
+// its size. Each write lands whole in one file.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > int64(f.config.MaxSizeMB)<<20 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.config.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the current file aside, opens a new one and removes the
+// rotated files no longer kept. A file that cannot be renamed is appended
+// to until it can. Callers must hold f.mutex.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	prefix, ext := f.backupName()
+	renameErr := os.Rename(f.config.Path, prefix+time.Now().UTC().Format(backupTimeFormat)+ext)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr == nil {
+		f.removeBackups()
+	}
+	return nil
+}
+
+// backupName splits the path rotated files are named after around their time
+func (f *RotatingFile) backupName() (prefix, ext string) {
+	ext = filepath.Ext(f.config.Path)
+	return strings.TrimSuffix(f.config.Path, ext) + "-", ext
+}
+
+// removeBackups removes the rotated files beyond the number kept and those
+// older than the age kept. Failures are left for the next rotation.
+func (f *RotatingFile) removeBackups() {
+	prefix, ext := f.backupName()
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+	type backup struct {
+		path      string
+		rotatedAt time.Time
+	}
+	var backups []backup
+	for _, path := range matches {
+		rotatedAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(path, prefix), ext))
+		if err == nil {
+			backups = append(backups, backup{path: path, rotatedAt: rotatedAt})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotatedAt.After(backups[j].rotatedAt) })
+
+	now := time.Now()
+	for i, backup := range backups {
+		if i >= f.config.MaxBackups || (f.config.MaxAge > 0 && now.Sub(backup.rotatedAt) > f.config.MaxAge) {
+			os.Remove(backup.path)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// discordInterval spaces messages to stay within Discord's limit of 30
+	// messages per minute per webhook
+	discordInterval = 2 * time.Second
+	// discordMaxEmbeds is the number of embeds Discord accepts per message
+	discordMaxEmbeds = 10
+	// discordQueueSize buffers findings while the rate limit holds them back
+	discordQueueSize = 5000
+	discordAttempts  = 3
+)
+
+// severityColors are the embed colors per severity
+var severityColors = map[string]int{
+	"critical": 0x8b0000,
+	"high":     0xd1242f,
+	"medium":   0xd4a72c,
+	"low":      0x0969da,
+	"info":     0x57606a,
+	"unknown":  0x8c959f,
+}
+
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Discord posts findings at or above a threshold as embeds colored by
+// severity, plus scan start and completion summaries. Messages are queued and
+// sent no faster than Discord's per-webhook rate limit allows.
+type Discord struct {
+	webhookURL  string
+	minSeverity string
+	reportURL   string
+	queue       *queue
+}
+
+// NewDiscord starts a Discord notifier. reportURL is linked from every embed.
+func NewDiscord(config types.DiscordConfig, reportURL string) *Discord {
+	if config.MinSeverity == "" {
+		config.MinSeverity = DefaultSeverity
+	}
+
+	d := &Discord{
+		webhookURL:  config.WebhookURL,
+		minSeverity: config.MinSeverity,
+		reportURL:   reportURL,
+		queue:       newQueue(discordQueueSize),
+	}
+	go d.run()
+	return d
+}
+
+// Notify queues findings above the threshold, scan start and completion and
+// alerts
+func (d *Discord) Notify(event Event) {
+	switch event.Type {
+	case EventNewResult:
+		if result, ok := event.Data.(types.ScanResult); !ok || !MeetsSeverity(result.Severity, d.minSeverity) {
+			return
+		}
+	case EventScanStarted, EventScanComplete, EventAlert:
+	default:
+		return
+	}
+
+	if !d.queue.push(event) {
+		logDropped("Discord", event)
+	}
+}
+
+// Close sends the queued messages and stops the notifier
+func (d *Discord) Close() {
+	d.queue.close()
+}
+
+func (d *Discord) run() {
+	var held *Event
+
+	for {
+		var event Event
+		if held != nil {
+			event, held = *held, nil
+		} else {
+			next, ok := <-d.queue.events
+			if !ok {
+				return
+			}
+			event = next
+		}
+
+		message := d.message(event)
+
+		// Pack findings already waiting into the same message
+		if event.Type == EventNewResult {
+		pack:
+			for len(message.Embeds) < discordMaxEmbeds {
+				select {
+				case next, ok := <-d.queue.events:
+					if !ok {
+						break pack
+					}
+					if next.Type != EventNewResult {
+						held = &next
+						break pack
+					}
+					message.Embeds = append(message.Embeds, d.findingEmbed(next.Data.(types.ScanResult)))
+				default:
+					break pack
+				}
+			}
+		}
+
+		d.send(event.ScanID, message)
+		time.Sleep(discordInterval)
+	}
+}
+
+func (d *Discord) message(event Event) discordMessage {
+	switch event.Type {
+	case EventNewResult:
+		return discordMessage{Embeds: []discordEmbed{d.findingEmbed(event.Data.(types.ScanResult))}}
+	case EventAlert:
+		return discordMessage{Embeds: []discordEmbed{alertEmbed(event)}}
+	case EventScanStarted:
+		summary, _ := event.Data.(types.ScanSummary)
+		return discordMessage{Embeds: []discordEmbed{{
+			Title:       "Scan started",
+			Description: fmt.Sprintf("Scanning %d domains in scan `%s`", summary.TotalDomains, event.ScanID),
+			URL:         d.reportURL,
+			Color:       severityColors["low"],
+			Timestamp:   event.Timestamp.Format(time.RFC3339),
+		}}}
+	default:
+		summary, _ := event.Data.(types.ScanSummary)
+		fields := make([]discordField, 0, 5)
+		for _, severity := range []string{"critical", "high", "medium", "low", "info"} {
+			fields = append(fields, discordField{
+				Name:   strings.ToUpper(severity[:1]) + severity[1:],
+				Value:  fmt.Sprintf("%d", summary.Severities[severity]),
+				Inline: true,
+			})
+		}
+		description := fmt.Sprintf("%d of %d domains scanned on %d workers, %d findings",
+			summary.ScannedDomains, summary.TotalDomains, summary.Workers, summary.Results)
+		if summary.FailedWorkers > 0 {
+			description += fmt.Sprintf(", %d workers failed", summary.FailedWorkers)
+		}
+		title, color := "Scan finished", severityColors["info"]
+		if summary.Degraded {
+			title, color = "Scan finished "+summary.Status, severityColors["high"]
+		}
+		if summary.FailureSummary != nil {
+			for _, reason := range summary.FailureSummary.Reasons {
+				description += "\n" + reason
+			}
+		}
+		return discordMessage{Embeds: []discordEmbed{{
+			Title:       title,
+			Description: description,
+			URL:         d.reportURL,
+			Color:       color,
+			Fields:      fields,
+			Timestamp:   event.Timestamp.Format(time.RFC3339),
+		}}}
+	}
+}
+
+func (d *Discord) findingEmbed(result types.ScanResult) discordEmbed {
+	severity := strings.ToLower(result.Severity)
+	if _, known := severityColors[severity]; !known {
+		severity = "unknown"
+	}
+
+	name := result.TemplateName
+	if name == "" {
+		name = result.Template
+	}
+	location := result.MatchedAt
+	if location == "" {
+		location = result.Host
+	}
+
+	fields := []discordField{
+		{Name: "Host", Value: truncate(result.Host, 1024), Inline: true},
+		{Name: "Template", Value: truncate(result.Template, 1024), Inline: true},
+	}
+	if result.MatcherName != "" {
+		fields = append(fields, discordField{Name: "Matcher", Value: truncate(result.MatcherName, 1024), Inline: true})
+	}
+
+	return discordEmbed{
+		Title:       truncate(fmt.Sprintf("[%s] %s", strings.ToUpper(severity), name), 256),
+		Description: truncate("Matched at "+location, 4096),
+		URL:         d.reportURL,
+		Color:       severityColors[severity],
+		Fields:      fields,
+		Timestamp:   result.Timestamp.Format(time.RFC3339),
+	}
+}
+
+// alertEmbed describes an alert with its details as fields
+func alertEmbed(event Event) discordEmbed {
+	alert, _ := event.Data.(types.Alert)
+	fields := make([]discordField, 0, len(alert.Details)+2)
+	if event.ScanID != "" {
+		fields = append(fields, discordField{Name: "Scan", Value: event.ScanID, Inline: true})
+	}
+	keys := make([]string, 0, len(alert.Details))
+	for key := range alert.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, discordField{Name: truncate(key, 256), Value: truncate(alert.Details[key], 1024), Inline: true})
+	}
+	if alert.Suppressed > 0 {
+		fields = append(fields, discordField{Name: "Held back by the cooldown", Value: fmt.Sprintf("%d", alert.Suppressed)})
+	}
+	return discordEmbed{
+		Title:       truncate("Alert: "+alert.Type, 256),
+		Description: truncate(alert.Message, 4096),
+		Color:       severityColors["high"],
+		Fields:      fields,
+		Timestamp:   event.Timestamp.Format(time.RFC3339),
+	}
+}
+
+// send posts a message, waiting out 429 responses for as long as Discord asks
+func (d *Discord) send(scanID string, message discordMessage) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	for attempt := 1; attempt <= discordAttempts; attempt++ {
+		resp, err := httpClient.Post(d.webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Warn("Discord notification failed", "scan_id", scanID, "error", redactURL(err))
+			time.Sleep(webhookBackoff)
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			resp.Body.Close()
+			return
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.NewDecoder(resp.Body).Decode(&limit)
+			resp.Body.Close()
+
+			wait := time.Duration(limit.RetryAfter * float64(time.Second))
+			if wait <= 0 {
+				wait = discordInterval
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		resp.Body.Close()
+		slog.Warn("Discord notification failed", "scan_id", scanID, "status", resp.StatusCode)
+		if resp.StatusCode < 500 {
+			return
+		}
+		time.Sleep(webhookBackoff)
+	}
+}
+
+// truncate shortens s to Discord's field limits, counted in characters
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,241 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/types"
+)
+
+// SMTPConfig is the mail server scan reports are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     int // 465 uses implicit TLS, any other port STARTTLS when offered
+	Username string
+	Password string
+	From     string
+	// Recipients receive reports of scans that do not list their own
+	Recipients []string
+}
+
+// Enabled reports whether a mail server is configured
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != "" && c.From != ""
+}
+
+// ValidateEmails checks a list of recipient addresses
+func ValidateEmails(addresses []string) error {
+	for _, address := range addresses {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil || parsed.Address != address {
+			return fmt.Errorf("invalid email address %q", address)
+		}
+	}
+	return nil
+}
+
+// Attachment is a file sent along with an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Email is an HTML message with optional attachments
+type Email struct {
+	To          []string
+	Subject     string
+	HTML        string
+	Attachments []Attachment
+}
+
+// SendEmail delivers an email through the configured server in one attempt;
+// callers decide whether to retry
+func SendEmail(config SMTPConfig, email Email) error {
+	message, err := buildMessage(config.From, email)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	var conn net.Conn
+	if config.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.Port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range email.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage renders an email as a MIME message with CRLF line endings
+func buildMessage(from string, email Email) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(email.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"Message-ID: <" + uuid.New().String() + "@nuclei-distributed>",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary(),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	body := Attachment{ContentType: "text/html; charset=utf-8", Data: []byte(email.HTML)}
+	if err := writePart(writer, body, ""); err != nil {
+		return nil, err
+	}
+	for _, attachment := range email.Attachments {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})
+		if err := writePart(writer, attachment, disposition); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePart adds a base64-encoded part, wrapped at 76 characters
+func writePart(writer *multipart.Writer, part Attachment, disposition string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", part.ContentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	if disposition != "" {
+		header.Set("Content-Disposition", disposition)
+	}
+	w, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(part.Data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = w.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+// ScanReport is the content of the email sent when a scan finishes
+type ScanReport struct {
+	Summary     types.ScanSummary
+	Duration    time.Duration
+	Cost        float64            // zero when the droplet price is unknown
+	Currency    string             // of Cost, e.g. USD
+	TopFindings []types.ScanResult // most severe first
+	ReportURL   string
+	CSVURL      string // linked when the CSV was too large to attach
+	MinSeverity string // lowest severity among the top findings, empty for all
+}
+
+var reportSeverities = []string{"critical", "high", "medium", "low", "info", "unknown"}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"upper": strings.ToUpper,
+	"count": func(counts map[string]int, severity string) int { return counts[severity] },
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>Scan {{.Summary.ID}} {{.Summary.Status}}</h2>
+<p>{{.Summary.ScannedDomains}} of {{.Summary.TotalDomains}} domains scanned on {{.Summary.Workers}} workers{{if .Summary.FailedWorkers}} ({{.Summary.FailedWorkers}} failed){{end}}
+in {{.Duration}}{{if .Cost}}, estimated cost {{printf "%.2f" .Cost}} {{.Currency}}{{end}}.</p>
+{{with .Summary.FailureSummary}}<ul>{{range .Reasons}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<h3>{{.Summary.Results}} findings</h3>
+<table cellpadding="4" style="border-collapse: collapse">
+<tr>{{range $.Severities}}<th align="left">{{upper .}}</th>{{end}}</tr>
+<tr>{{range $.Severities}}<td>{{count $.Summary.Severities .}}</td>{{end}}</tr>
+</table>
+{{if .TopFindings}}<h3>Top findings{{if .MinSeverity}} ({{.MinSeverity}} and above){{end}}</h3>
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>Severity</th><th>Template</th><th>Host</th><th>Matched at</th></tr>
+{{range .TopFindings}}<tr><td>{{upper .Severity}}</td><td>{{.Template}}{{if .TemplateName}} ({{.TemplateName}}){{end}}</td><td>{{.Host}}</td><td>{{.MatchedAt}}</td></tr>
+{{end}}</table>{{end}}
+<p><a href="{{.ReportURL}}">View scan report</a>{{if .CSVURL}} | <a href="{{.CSVURL}}">Download CSV</a> (too large to attach){{end}}</p>
+</body></html>
+`))
+
+// ReportEmail renders the subject and HTML body of a scan report
+func ReportEmail(report ScanReport) (string, string, error) {
+	report.Duration = report.Duration.Round(time.Second)
+
+	var body bytes.Buffer
+	data := struct {
+		ScanReport
+		Severities []string
+	}{report, reportSeverities}
+	if err := reportTemplate.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	subject := fmt.Sprintf("Nuclei scan %s %s: %d findings", report.Summary.ID, report.Summary.Status, report.Summary.Results)
+	if critical, high := report.Summary.Severities["critical"], report.Summary.Severities["high"]; critical+high > 0 {
+		subject += fmt.Sprintf(" (%d critical, %d high)", critical, high)
+	}
+	return subject, body.String(), nil
+}
@@ -0,0 +1,114 @@
+// Package notify delivers scan events to external services such as
+// webhooks, Slack and Discord.
+package notify
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scan events handed to notifiers
+const (
+	EventScanStarted  = "scan_started"
+	EventWorkerFailed = "worker_failed"
+	EventNewResult    = "new_result"
+	EventScanComplete = "scan_complete"
+	// EventAlert is sent to the server's own notifiers, never to a scan's.
+	// Its scan ID is empty unless the alert is about one scan.
+	EventAlert = "alert"
+)
+
+// Events lists every event a notifier can receive
+var Events = map[string]bool{
+	EventScanStarted: true, EventWorkerFailed: true, EventNewResult: true, EventScanComplete: true,
+	EventAlert: true,
+}
+
+// Event is a scan event or alert. Data holds a types.ScanSummary for
+// scan_started and scan_complete, a types.WorkerFailure for worker_failed, a
+// types.ScanResult for new_result and a types.Alert for alert.
+type Event struct {
+	Type      string      `json:"event"`
+	ScanID    string      `json:"scanId"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Notifier delivers a scan's events to one destination
+type Notifier interface {
+	// Notify queues an event without blocking
+	Notify(event Event)
+	// Close delivers events already queued and stops the notifier
+	Close()
+}
+
+// queueSize is the number of events buffered per notifier
+const queueSize = 1000
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// severityRanks orders severities for threshold checks
+var severityRanks = map[string]int{
+	"unknown": 0, "info": 1, "low": 2, "medium": 3, "high": 4, "critical": 5,
+}
+
+// ValidSeverity reports whether severity is a nuclei severity
+func ValidSeverity(severity string) bool {
+	_, valid := severityRanks[strings.ToLower(severity)]
+	return valid
+}
+
+// MeetsSeverity reports whether severity is at or above minimum. An empty
+// minimum matches everything.
+func MeetsSeverity(severity, minimum string) bool {
+	if minimum == "" {
+		return true
+	}
+	return severityRanks[strings.ToLower(severity)] >= severityRanks[strings.ToLower(minimum)]
+}
+
+// queue is a closable event channel. Pushing never blocks and never panics
+// after close.
+type queue struct {
+	mu     sync.Mutex
+	closed bool
+	events chan Event
+}
+
+func newQueue(size int) *queue {
+	return &queue{events: make(chan Event, size)}
+}
+
+// push queues an event and reports whether it fit
+func (q *queue) push(event Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	select {
+	case q.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+}
+
+// logDropped reports an event a notifier had no room for
+func logDropped(notifier string, event Event) {
+	slog.Warn("Notification queue full, dropping event", "notifier", notifier, "scan_id", event.ScanID, "event", event.Type)
+}
@@ -0,0 +1,276 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// slackBatchWindow is how long findings are collected before they are
+	// summarized into a single message
+	slackBatchWindow = 10 * time.Second
+	// slackHostsPerTemplate is the number of hosts listed per template before
+	// the rest are only counted
+	slackHostsPerTemplate = 10
+	// DefaultSeverity is the notification threshold used when none is configured
+	DefaultSeverity = "high"
+)
+
+var severityEmoji = map[string]string{
+	"critical": ":red_circle:",
+	"high":     ":large_orange_circle:",
+	"medium":   ":large_yellow_circle:",
+	"low":      ":large_blue_circle:",
+	"info":     ":white_circle:",
+}
+
+// slackEscaper escapes the characters Slack treats as control sequences
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// ValidateChatWebhook checks the webhook URL and threshold of a chat notifier
+func ValidateChatWebhook(name, webhookURL, minSeverity string) error {
+	if webhookURL != "" {
+		parsed, err := url.Parse(webhookURL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("%s webhook URL must be an absolute https URL", name)
+		}
+	}
+	if minSeverity != "" && !ValidSeverity(minSeverity) {
+		return fmt.Errorf("unknown %s severity %q", name, minSeverity)
+	}
+	return nil
+}
+
+// slackTemplateBatch collects the hosts one template matched within a batch
+type slackTemplateBatch struct {
+	template string
+	name     string
+	severity string
+	hosts    []string
+	count    int
+}
+
+// Slack posts findings at or above a threshold to a Slack incoming webhook,
+// summarized per template every few seconds, and a summary when the scan
+// finishes
+type Slack struct {
+	webhookURL  string
+	minSeverity string
+	reportURL   string
+	queue       *queue
+}
+
+// NewSlack starts a Slack notifier. reportURL is linked from every message.
+func NewSlack(config types.SlackConfig, reportURL string) *Slack {
+	if config.MinSeverity == "" {
+		config.MinSeverity = DefaultSeverity
+	}
+
+	s := &Slack{
+		webhookURL:  config.WebhookURL,
+		minSeverity: config.MinSeverity,
+		reportURL:   reportURL,
+		queue:       newQueue(queueSize),
+	}
+	go s.run()
+	return s
+}
+
+// Notify queues findings above the threshold, scan completion and alerts
+func (s *Slack) Notify(event Event) {
+	switch event.Type {
+	case EventNewResult:
+		if result, ok := event.Data.(types.ScanResult); !ok || !MeetsSeverity(result.Severity, s.minSeverity) {
+			return
+		}
+	case EventScanComplete, EventAlert:
+	default:
+		return
+	}
+
+	if !s.queue.push(event) {
+		logDropped("Slack", event)
+	}
+}
+
+// Close posts pending findings and stops the notifier
+func (s *Slack) Close() {
+	s.queue.close()
+}
+
+func (s *Slack) run() {
+	batch := make(map[string]*slackTemplateBatch)
+	var flushTimer <-chan time.Time
+	scanID := ""
+
+	flush := func() {
+		if len(batch) > 0 {
+			s.post(scanID, s.findingsMessage(scanID, batch))
+			batch = make(map[string]*slackTemplateBatch)
+		}
+		flushTimer = nil
+	}
+
+	for {
+		select {
+		case event, ok := <-s.queue.events:
+			if !ok {
+				flush()
+				return
+			}
+			scanID = event.ScanID
+
+			switch event.Type {
+			case EventNewResult:
+				result := event.Data.(types.ScanResult)
+				entry, exists := batch[result.Template]
+				if !exists {
+					entry = &slackTemplateBatch{
+						template: slackEscaper.Replace(result.Template),
+						name:     slackEscaper.Replace(result.TemplateName),
+						severity: strings.ToLower(result.Severity),
+					}
+					batch[result.Template] = entry
+				}
+				entry.count++
+				if len(entry.hosts) < slackHostsPerTemplate {
+					entry.hosts = append(entry.hosts, slackEscaper.Replace(result.Host))
+				}
+				if flushTimer == nil {
+					flushTimer = time.After(slackBatchWindow)
+				}
+			case EventScanComplete:
+				flush()
+				if summary, ok := event.Data.(types.ScanSummary); ok {
+					s.post(scanID, s.summaryMessage(summary))
+				}
+			case EventAlert:
+				if alert, ok := event.Data.(types.Alert); ok {
+					s.post(scanID, alertMessage(scanID, alert))
+				}
+			}
+		case <-flushTimer:
+			flush()
+		}
+	}
+}
+
+// findingsMessage summarizes a batch, most severe templates first
+func (s *Slack) findingsMessage(scanID string, batch map[string]*slackTemplateBatch) string {
+	entries := make([]*slackTemplateBatch, 0, len(batch))
+	total := 0
+	for _, entry := range batch {
+		entries = append(entries, entry)
+		total += entry.count
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		ri, rj := severityRanks[entries[i].severity], severityRanks[entries[j].severity]
+		if ri != rj {
+			return ri > rj
+		}
+		return entries[i].template < entries[j].template
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d new findings* in scan `%s`\n", total, scanID)
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s *%s* `%s`", severityEmoji[entry.severity], strings.ToUpper(entry.severity), entry.template)
+		if entry.name != "" {
+			fmt.Fprintf(&b, " %s", entry.name)
+		}
+		if entry.count == 1 {
+			fmt.Fprintf(&b, " on %s\n", entry.hosts[0])
+			continue
+		}
+		fmt.Fprintf(&b, " on %d hosts: %s", entry.count, strings.Join(entry.hosts, ", "))
+		if more := entry.count - len(entry.hosts); more > 0 {
+			fmt.Fprintf(&b, " and %d more", more)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "<%s|View scan report>", s.reportURL)
+	return b.String()
+}
+
+func (s *Slack) summaryMessage(summary types.ScanSummary) string {
+	var b strings.Builder
+	icon, outcome := ":white_check_mark:", "finished"
+	if summary.Degraded {
+		icon, outcome = ":warning:", "finished "+summary.Status
+	}
+	fmt.Fprintf(&b, "%s *Scan `%s` %s*: %d of %d domains scanned on %d workers",
+		icon, summary.ID, outcome, summary.ScannedDomains, summary.TotalDomains, summary.Workers)
+	if summary.FailedWorkers > 0 {
+		fmt.Fprintf(&b, " (%d failed)", summary.FailedWorkers)
+	}
+	if summary.FailureSummary != nil {
+		for _, reason := range summary.FailureSummary.Reasons {
+			fmt.Fprintf(&b, "\n• %s", reason)
+		}
+	}
+	fmt.Fprintf(&b, "\n*%d findings*:", summary.Results)
+	for _, severity := range []string{"critical", "high", "medium", "low", "info"} {
+		fmt.Fprintf(&b, " %s %d", severity, summary.Severities[severity])
+	}
+	fmt.Fprintf(&b, "\n<%s|View scan report>", s.reportURL)
+	return b.String()
+}
+
+// alertMessage describes an alert, leaving out the report link since the
+// server's notifiers have no scan to link to
+func alertMessage(scanID string, alert types.Alert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":rotating_light: *%s*: %s", alert.Type, slackEscaper.Replace(alert.Message))
+	if scanID != "" {
+		fmt.Fprintf(&b, " (scan `%s`)", scanID)
+	}
+	keys := make([]string, 0, len(alert.Details))
+	for key := range alert.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n%s: `%s`", key, slackEscaper.Replace(alert.Details[key]))
+	}
+	if alert.Suppressed > 0 {
+		fmt.Fprintf(&b, "\n_%d more held back by the cooldown_", alert.Suppressed)
+	}
+	return b.String()
+}
+
+// post sends a message, retrying once when Slack rate limits or errors
+func (s *Slack) post(scanID, text string) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		resp, err := httpClient.Post(s.webhookURL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+			err = fmt.Errorf("slack returned status %d", resp.StatusCode)
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				slog.Error("Slack notification failed", "scan_id", scanID, "error", redactURL(err))
+				return
+			}
+		}
+		if attempt == 2 {
+			slog.Error("Slack notification failed", "scan_id", scanID, "error", redactURL(err))
+			return
+		}
+		time.Sleep(webhookBackoff)
+	}
+}
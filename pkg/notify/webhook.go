@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// webhookAttempts is how often a delivery is tried before it is given up
+	webhookAttempts = 5
+	// webhookBackoff is the delay before the first retry, doubled after each
+	webhookBackoff = 2 * time.Second
+	// maxWebhookFailures is the number of failures kept per webhook
+	maxWebhookFailures = 50
+)
+
+// webhookHost is the part of a webhook URL that is logged, since paths and
+// queries often carry tokens
+func webhookHost(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		return parsed.Host
+	}
+	return ""
+}
+
+// redactURL keeps only the host of the URL in HTTP client errors, which
+// would otherwise log a webhook's token
+func redactURL(err error) error {
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return fmt.Errorf("%s %s: %w", urlError.Op, webhookHost(urlError.URL), urlError.Err)
+	}
+	return err
+}
+
+// ValidateWebhook checks a webhook configuration
+func ValidateWebhook(config types.WebhookConfig) error {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook URL %q must be an absolute http(s) URL", config.URL)
+	}
+	for _, event := range config.Events {
+		if !Events[event] {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	if config.MinSeverity != "" && !ValidSeverity(config.MinSeverity) {
+		return fmt.Errorf("unknown webhook severity %q", config.MinSeverity)
+	}
+	return nil
+}
+
+// Webhook posts signed JSON events to an HTTP endpoint in order, retrying
+// with exponential backoff, and keeps delivery statistics
+type Webhook struct {
+	config types.WebhookConfig
+	queue  *queue
+
+	mu     sync.Mutex
+	status types.WebhookStatus
+}
+
+// NewWebhook starts delivering events to config.URL
+func NewWebhook(config types.WebhookConfig) *Webhook {
+	w := &Webhook{
+		config: config,
+		queue:  newQueue(queueSize),
+		status: types.WebhookStatus{
+			URL:         config.URL,
+			Events:      config.Events,
+			MinSeverity: config.MinSeverity,
+		},
+	}
+	go w.run()
+	return w
+}
+
+// Notify queues an event the webhook subscribed to. Events that do not fit in
+// a full queue are recorded as failures.
+func (w *Webhook) Notify(event Event) {
+	if !w.wants(event) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.queue.push(event) {
+		w.status.Pending++
+	} else {
+		w.recordFailure(event.Type, "delivery queue full", 0)
+	}
+}
+
+// Close delivers queued events and stops the webhook
+func (w *Webhook) Close() {
+	w.queue.close()
+}
+
+// Status returns the webhook's delivery statistics
+func (w *Webhook) Status() types.WebhookStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := w.status
+	status.Failures = append([]types.WebhookFailure(nil), w.status.Failures...)
+	return status
+}
+
+func (w *Webhook) wants(event Event) bool {
+	if len(w.config.Events) > 0 {
+		wanted := false
+		for _, e := range w.config.Events {
+			if e == event.Type {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			return false
+		}
+	}
+
+	if result, ok := event.Data.(types.ScanResult); ok && event.Type == EventNewResult {
+		return MeetsSeverity(result.Severity, w.config.MinSeverity)
+	}
+	return true
+}
+
+func (w *Webhook) run() {
+	for event := range w.queue.events {
+		attempts, err := w.send(event)
+
+		w.mu.Lock()
+		w.status.Pending--
+		w.status.LastAttempt = time.Now()
+		if err != nil {
+			w.recordFailure(event.Type, err.Error(), attempts)
+		} else {
+			w.status.Delivered++
+		}
+		w.mu.Unlock()
+
+		if err != nil {
+			slog.Error("Webhook failed", "host", webhookHost(w.config.URL), "scan_id", event.ScanID, "attempts", attempts, "error", redactURL(err))
+		}
+	}
+}
+
+// recordFailure keeps the most recent failures. Callers must hold w.mu.
+func (w *Webhook) recordFailure(event, reason string, attempts int) {
+	w.status.Failed++
+	w.status.Failures = append(w.status.Failures, types.WebhookFailure{
+		Event:     event,
+		Error:     reason,
+		Attempts:  attempts,
+		Timestamp: time.Now(),
+	})
+	if overflow := len(w.status.Failures) - maxWebhookFailures; overflow > 0 {
+		w.status.Failures = append([]types.WebhookFailure(nil), w.status.Failures[overflow:]...)
+	}
+}
+
+// send posts an event, retrying with exponential backoff on network errors,
+// 429 and 5xx responses. It returns the number of attempts made.
+func (w *Webhook) send(event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	deliveryID := uuid.New().String()
+	backoff := webhookBackoff
+	var lastErr error
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		retry, err := w.post(event.Type, deliveryID, body)
+		if err == nil {
+			return attempt, nil
+		}
+		lastErr = err
+		if !retry || attempt == webhookAttempts {
+			return attempt, lastErr
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return webhookAttempts, lastErr
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth
+// retrying
+func (w *Webhook) post(event, deliveryID string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nuclei-distributed-webhook")
+	req.Header.Set("X-Nuclei-Event", event)
+	req.Header.Set("X-Nuclei-Delivery", deliveryID)
+	req.Header.Set("X-Nuclei-Timestamp", timestamp)
+	if w.config.Secret != "" {
+		req.Header.Set("X-Nuclei-Signature", "sha256="+Sign(w.config.Secret, timestamp, body))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it with their secret and compare it to X-Nuclei-Signature.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,226 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrScanNotRunning is returned for changes only a running scan accepts
+var ErrScanNotRunning = errors.New("scan is not running")
+
+// newWorkerTargets is the number of added targets that get a worker of their
+// own rather than queuing behind the running workers' targets
+const newWorkerTargets = 100
+
+// plannedWorker is a worker to be created for targets added to a scan
+type plannedWorker struct {
+	index   int
+	id      string
+	request types.ScanRequest
+	domains []string
+}
+
+// AddTargets adds targets to a running scan. They are validated like a new
+// scan's, and those the scan already has are skipped. Small additions are
+// queued for the running workers, which pull them once their targets are
+// done; larger ones, or any when no worker is running, get a new worker.
+// Scans with pull distribution queue them in their pool instead.
+func (o *Orchestrator) AddTargets(scanID string, domains []string) (*types.TargetsAdded, error) {
+	o.mutex.RLock()
+	state := o.scanStates[scanID]
+	allowLarge := state != nil && state.request != nil && state.request.AllowLargeExpansion
+	o.mutex.RUnlock()
+	if state == nil {
+		return nil, ErrScanNotFound
+	}
+
+	req := &types.ScanRequest{ID: scanID, Domains: domains, AllowLargeExpansion: allowLarge}
+	summary, err := o.checkTargets(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Held so no worker is told a pull scan's pool is drained while
+	// targets are queued in it
+	o.poolMutex.Lock()
+	defer o.poolMutex.Unlock()
+
+	o.mutex.Lock()
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		o.mutex.Unlock()
+		return nil, ErrScanNotFound
+	}
+	if scanEnded(scan.Status) || state.request == nil || scan.Plan == nil {
+		o.mutex.Unlock()
+		return nil, ErrScanNotRunning
+	}
+
+	known := make(map[string]bool, len(state.targets))
+	for _, target := range state.targets {
+		known[target] = true
+	}
+	added := make([]string, 0, len(req.Domains))
+	for _, target := range req.Domains {
+		if !known[target] {
+			known[target] = true
+			added = append(added, target)
+		}
+	}
+	result := &types.TargetsAdded{
+		Added:           len(added),
+		Targets:         summary,
+		AlreadyTargeted: len(req.Domains) - len(added),
+	}
+	if len(added) == 0 {
+		o.mutex.Unlock()
+		return result, nil
+	}
+
+	var assigned map[string]int
+	var worker *plannedWorker
+	if scan.Pool != nil {
+		worker, err = o.queuePoolTargets(scan, state, added)
+	} else {
+		assigned, worker, err = o.assignTargets(scan, state, added, len(added) >= newWorkerTargets)
+	}
+	if err != nil {
+		o.mutex.Unlock()
+		return nil, err
+	}
+	result.Assigned = assigned
+	if worker != nil {
+		result.NewWorker = worker.id
+	}
+	state.targets = append(state.targets, added...)
+	state.scope.add(added)
+	scan.TotalDomains += len(added)
+	updateScanProgress(scan)
+	o.scanChanged(scan)
+	record := scanRecord(scan, state)
+	o.mutex.Unlock()
+
+	slog.Info("Added targets", "scan_id", scanID, "added", len(added), "already_targeted", result.AlreadyTargeted,
+		"new_worker", result.NewWorker)
+	o.saveScanRecord(record)
+	if worker != nil {
+		go o.startAddedWorker(scanID, worker)
+	}
+	return result, nil
+}
+
+// assignTargets queues targets for the scan's running workers, spread evenly,
+// or plans a new worker for them when ownWorker is set or no worker is
+// running. Workers added after the scan's secrets were deleted could not
+// fetch them, so then only running workers take targets. Callers must hold
+// o.mutex and start the planned worker once it is released.
+func (o *Orchestrator) assignTargets(scan *types.ScanStatus, state *scanState, targets []string, ownWorker bool) (map[string]int, *plannedWorker, error) {
+	var running []*types.WorkerStatus
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status != "completed" && worker.Status != "failed" {
+			running = append(running, worker)
+		}
+	}
+	deleted := secretsDeleted(scan, state)
+
+	if len(running) > 0 && (!ownWorker || deleted) {
+		assigned := make(map[string]int)
+		for i, target := range targets {
+			worker := running[i%len(running)]
+			state.pendingTargets[worker.ID] = append(state.pendingTargets[worker.ID], target)
+			state.coverage.assign([]string{target}, worker.ID, TargetAssigned)
+			state.addedTargets[worker.ID]++
+			worker.TotalDomains++
+			worker.DomainsAlive++
+			assigned[worker.ID]++
+		}
+		for _, worker := range running {
+			o.setWorkerScanned(worker, worker.DomainsScanned)
+		}
+		return assigned, nil, nil
+	}
+	if deleted {
+		return nil, nil, fmt.Errorf("%w: no worker is running and the scan's secrets were deleted once fetched, so none can be added",
+			ErrInvalidScan)
+	}
+
+	index := scan.Plan.Droplets
+	scan.Plan.Droplets++
+	state.coverage.assign(targets, workerName(scan.ID, index), TargetPending)
+	return nil, &plannedWorker{
+		index:   index,
+		id:      workerName(scan.ID, index),
+		request: *state.request,
+		domains: targets,
+	}, nil
+}
+
+// secretsDeleted reports whether the scan's secrets were deleted once every
+// worker fetched them, so added workers could not. Callers must hold o.mutex.
+func secretsDeleted(scan *types.ScanStatus, state *scanState) bool {
+	return state.secrets != nil && state.secrets.invalidateAfterFetch &&
+		len(state.secrets.fetched) >= scan.Plan.Droplets
+}
+
+// startAddedWorker creates the droplet of a worker planned for added targets
+func (o *Orchestrator) startAddedWorker(scanID string, worker *plannedWorker) {
+	config, err := resolveDropletConfig(&worker.request)
+	if err != nil {
+		slog.Error("Failed to create worker", "scan_id", scanID, "worker_id", worker.id, "error", err)
+		o.workerCreateFailed(scanID, worker.id, err)
+		return
+	}
+	ctx := o.scanTraceContext(scanID)
+	if region := o.fallbackRegion(ctx, config.Region, nil); region != "" {
+		config.Region = region
+	}
+	o.launchWorker(ctx, &worker.request, config, worker.index, worker.domains)
+}
+
+// requeueTargets hands the targets a finished worker never pulled to the
+// scan's other workers. Callers must hold o.mutex and start the returned
+// worker, if any, once it is released.
+func (o *Orchestrator) requeueTargets(scan *types.ScanStatus, state *scanState, worker *types.WorkerStatus) *plannedWorker {
+	pending := state.pendingTargets[worker.ID]
+	if len(pending) == 0 || scan.Plan == nil || state.request == nil {
+		return nil
+	}
+	delete(state.pendingTargets, worker.ID)
+	state.addedTargets[worker.ID] -= len(pending)
+	worker.TotalDomains -= len(pending)
+	worker.DomainsAlive -= len(pending)
+
+	_, planned, err := o.assignTargets(scan, state, pending, false)
+	if err != nil {
+		slog.Error("Added targets were not scanned", "scan_id", scan.ID, "worker_id", worker.ID, "targets", len(pending), "error", err)
+		scan.TotalDomains -= len(pending)
+		state.coverage.assign(pending, worker.ID, TargetErrored)
+		return nil
+	}
+	slog.Info("Requeued added targets", "scan_id", scan.ID, "worker_id", worker.ID, "targets", len(pending))
+	return planned
+}
+
+// PullTargets hands a worker the targets queued for it since it started,
+// added to the scan or rebalanced from slower workers, and reports whether
+// the scan still runs. Workers that finished keep asking until it ends.
+func (o *Orchestrator) PullTargets(scanID, workerID string) ([]string, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	targets := []string{}
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		return targets, false
+	}
+	targets = append(targets, state.pendingTargets[workerID]...)
+	delete(state.pendingTargets, workerID)
+	if worker := o.findWorker(scanID, workerID); worker != nil && o.workerSeen(scanID, worker) {
+		o.workerEvent(scanID, types.WorkerProgress, worker)
+	}
+	return targets, !scanEnded(scan.Status)
+}
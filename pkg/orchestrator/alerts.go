@@ -0,0 +1,294 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/types"
+)
+
+// Alert types, each with its own cooldown
+const (
+	AlertCreateFailures     = "droplet_create_failures"
+	AlertOrphanDestroyed    = "orphan_destroyed"
+	AlertStorageUnavailable = "storage_unavailable"
+	AlertWorkerAuthFailures = "worker_auth_failures"
+	AlertScanStuck          = "scan_stuck_provisioning"
+)
+
+// Defaults for unset alert settings
+const (
+	DefaultAlertCooldown       = 15 * time.Minute
+	DefaultAlertCreateFailures = 3
+	DefaultAlertAuthFailures   = 20
+	DefaultAlertAuthWindow     = 5 * time.Minute
+	DefaultProvisioningTimeout = 15 * time.Minute
+)
+
+// alertCheckInterval is how often scans are checked for being stuck
+const alertCheckInterval = time.Minute
+
+// alertSinks are the values AlertSettings.Sinks accepts
+var alertSinks = map[string]bool{"webhook": true, "slack": true, "discord": true}
+
+// alertConfig decides when operators are alerted about failures of the
+// server rather than of a scan, and where alerts are sent. It is parsed from
+// types.AlertSettings.
+type alertConfig struct {
+	// sinks are the server notifiers alerts go to; empty sends them to
+	// every one configured
+	sinks map[string]bool
+	// cooldown is how long an alert type stays quiet once sent; alerts
+	// raised meanwhile are only counted
+	cooldown time.Duration
+	// createFailures is the number of droplet creations in a row that must
+	// fail before an alert
+	createFailures int
+	// authFailures is the number of callbacks with a bad worker token within
+	// authWindow that raise an alert
+	authFailures int
+	authWindow   time.Duration
+	// provisioningTimeout is how long a scan may run without any worker
+	// ready before it is reported stuck
+	provisioningTimeout time.Duration
+}
+
+// parseAlertSettings checks the alert settings and fills in the defaults of
+// those left unset. The error names the setting at fault.
+func parseAlertSettings(settings *types.AlertSettings) (alertConfig, error) {
+	cfg := alertConfig{
+		sinks:          make(map[string]bool, len(settings.Sinks)),
+		createFailures: settings.CreateFailures,
+		authFailures:   settings.AuthFailures,
+	}
+	for _, sink := range settings.Sinks {
+		if !alertSinks[sink] {
+			return cfg, fmt.Errorf("sinks: unknown alert sink %q", sink)
+		}
+		cfg.sinks[sink] = true
+	}
+	if cfg.createFailures < 0 || cfg.authFailures < 0 {
+		return cfg, errors.New("createFailures and authFailures must not be negative")
+	}
+	if cfg.createFailures == 0 {
+		cfg.createFailures = DefaultAlertCreateFailures
+	}
+	if cfg.authFailures == 0 {
+		cfg.authFailures = DefaultAlertAuthFailures
+	}
+	settings.CreateFailures, settings.AuthFailures = cfg.createFailures, cfg.authFailures
+
+	durations := []struct {
+		name     string
+		setting  *string
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{"cooldown", &settings.Cooldown, &cfg.cooldown, DefaultAlertCooldown},
+		{"authWindow", &settings.AuthWindow, &cfg.authWindow, DefaultAlertAuthWindow},
+		{"provisioningTimeout", &settings.ProvisioningTimeout, &cfg.provisioningTimeout, DefaultProvisioningTimeout},
+	}
+	for _, d := range durations {
+		*d.value = d.fallback
+		if *d.setting != "" {
+			parsed, err := time.ParseDuration(*d.setting)
+			if err != nil || parsed <= 0 {
+				return cfg, fmt.Errorf("%s must be a positive duration such as 15m", d.name)
+			}
+			*d.value = parsed
+		}
+		*d.setting = d.value.String()
+	}
+	return cfg, nil
+}
+
+// alertBus sends alerts to the server's notifiers, holding each type back
+// for its cooldown once sent
+type alertBus struct {
+	mutex          sync.Mutex
+	config         alertConfig
+	notifiers      []notify.Notifier
+	sent           map[string]time.Time // when each type was last sent
+	suppressed     map[string]int       // raised during each type's cooldown
+	createFailures int                  // droplet creations failed in a row
+	authFailures   []time.Time          // bad worker tokens within the window
+}
+
+func newAlertBus() *alertBus {
+	return &alertBus{
+		sent:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// configure applies alert settings, creating the notifiers of the selected
+// sinks from the server's notifier settings. The notifiers replaced are
+// closed once they delivered what they queued.
+func (b *alertBus) configure(cfg alertConfig, notifyConfig NotifyConfig) {
+	selected := func(sink string) bool { return len(cfg.sinks) == 0 || cfg.sinks[sink] }
+
+	var notifiers []notify.Notifier
+	if selected("webhook") {
+		for _, config := range notifyConfig.Webhooks {
+			notifiers = append(notifiers, notify.NewWebhook(config))
+		}
+	}
+	if selected("slack") && notifyConfig.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, notify.NewSlack(notifyConfig.Slack, ""))
+	}
+	if selected("discord") && notifyConfig.Discord.WebhookURL != "" {
+		notifiers = append(notifiers, notify.NewDiscord(notifyConfig.Discord, ""))
+	}
+
+	b.mutex.Lock()
+	replaced := b.notifiers
+	b.config = cfg
+	b.notifiers = notifiers
+	b.mutex.Unlock()
+
+	for _, notifier := range replaced {
+		notifier.Close()
+	}
+}
+
+// settings returns the alert settings in use
+func (b *alertBus) settings() alertConfig {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.config
+}
+
+// raise sends an alert unless its type is cooling down. Alerts are logged
+// either way.
+func (b *alertBus) raise(alertType, scanID, message string, details map[string]string) {
+	now := time.Now()
+	b.mutex.Lock()
+	if sent, ok := b.sent[alertType]; ok && now.Sub(sent) < b.config.cooldown {
+		b.suppressed[alertType]++
+		b.mutex.Unlock()
+		slog.Debug("Alert held back by its cooldown", "alert", alertType, "scan_id", scanID, "message", message)
+		return
+	}
+	b.sent[alertType] = now
+	alert := types.Alert{Type: alertType, Message: message, Details: details, Suppressed: b.suppressed[alertType]}
+	delete(b.suppressed, alertType)
+	notifiers := b.notifiers
+	b.mutex.Unlock()
+
+	slog.Warn("Alert raised", "alert", alertType, "scan_id", scanID, "message", message, "suppressed", alert.Suppressed)
+	event := notify.Event{Type: notify.EventAlert, ScanID: scanID, Timestamp: now.UTC(), Data: alert}
+	for _, notifier := range notifiers {
+		notifier.Notify(event)
+	}
+}
+
+// dropletCreated records the outcome of creating a droplet, alerting once
+// enough in a row failed
+func (b *alertBus) dropletCreated(region, size string, err error) {
+	b.mutex.Lock()
+	if err == nil {
+		b.createFailures = 0
+		b.mutex.Unlock()
+		return
+	}
+	b.createFailures++
+	failures, threshold := b.createFailures, b.config.createFailures
+	b.mutex.Unlock()
+
+	if failures >= threshold {
+		b.raise(AlertCreateFailures, "", fmt.Sprintf("%d droplet creations failed in a row", failures), map[string]string{
+			"region": region, "size": size, "error": err.Error(),
+		})
+	}
+}
+
+// workerAuthFailed records a callback with a bad worker token, alerting once
+// too many arrived within the window
+func (b *alertBus) workerAuthFailed(scanID, workerID string) {
+	now := time.Now()
+	b.mutex.Lock()
+	window, threshold := b.config.authWindow, b.config.authFailures
+	recent := b.authFailures[:0]
+	for _, at := range b.authFailures {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	b.authFailures = append(recent, now)
+	failures := len(b.authFailures)
+	b.mutex.Unlock()
+
+	if failures >= threshold {
+		b.raise(AlertWorkerAuthFailures, "", fmt.Sprintf("%d worker callbacks with a bad token in the last %s", failures, window),
+			map[string]string{"scan_id": scanID, "worker_id": workerID})
+	}
+}
+
+// storageFailed alerts that the storage backend refused an operation
+func (b *alertBus) storageFailed(operation string, err error) {
+	b.raise(AlertStorageUnavailable, "", "Storage backend failed to "+operation, map[string]string{"error": err.Error()})
+}
+
+// orphanDestroyed alerts that the reaper destroyed a droplet the server did
+// not know
+func (b *alertBus) orphanDestroyed(workerID, dropletID string) {
+	b.raise(AlertOrphanDestroyed, "", "Destroyed a worker droplet the server did not know", map[string]string{
+		"worker_id": workerID, "droplet_id": dropletID,
+	})
+}
+
+// runAlertChecks looks for stuck scans every alertCheckInterval. Of several
+// replicas the leader runs the check.
+func (o *Orchestrator) runAlertChecks() {
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		o.leaderCheck(checkStuck, time.Now())
+	}
+}
+
+// checkStuckScans alerts about running scans none of whose workers became
+// ready within the provisioning timeout, once per scan
+func (o *Orchestrator) checkStuckScans(now time.Time) {
+	type stuckScan struct {
+		id      string
+		age     time.Duration
+		workers int
+	}
+	timeout := o.alerts.settings().provisioningTimeout
+	var stuck []stuckScan
+	o.mutex.Lock()
+	for scanID, scan := range o.activeScans {
+		state := o.scanStates[scanID]
+		if state == nil || state.stuckAlerted || scanEnded(scan.Status) || scan.Status == ScanEnumerating ||
+			scan.Status == ScanPreflight || len(scan.ActiveDroplets) > 0 {
+			continue
+		}
+		// Workers of scans started with expand or preflight are created once
+		// they are planned
+		started := scan.CreatedAt
+		if !state.plannedAt.IsZero() {
+			started = state.plannedAt
+		}
+		if age := now.Sub(started); age > timeout {
+			state.stuckAlerted = true
+			o.replicateScan(scanID)
+			workers := 0
+			if scan.Plan != nil {
+				workers = scan.Plan.Droplets
+			}
+			stuck = append(stuck, stuckScan{id: scanID, age: age, workers: workers})
+		}
+	}
+	o.mutex.Unlock()
+
+	for _, scan := range stuck {
+		o.alerts.raise(AlertScanStuck, scan.id, fmt.Sprintf("No worker became ready in %s", scan.age.Round(time.Second)),
+			map[string]string{"planned_workers": strconv.Itoa(scan.workers)})
+	}
+}
@@ -0,0 +1,328 @@
+package orchestrator
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nuclei-distributed/pkg/types"
+)
+
+// archiveLogBatch is the number of spilled log lines read from Redis at once
+const archiveLogBatch = 1000
+
+// redactedValue replaces secret values in a scan archive
+const redactedValue = "[redacted]"
+
+// secretConfigKeys are nuclei config keys whose values may hold credentials
+var secretConfigKeys = map[string]bool{"header": true, "H": true, "var": true, "V": true}
+
+// secretKeyWords mark any other config key as holding a credential
+var secretKeyWords = []string{"secret", "token", "password", "passwd", "auth", "cookie", "key"}
+
+// ScanArchive is what a scan's downloadable archive is built from. Results
+// are loaded up front so a missing scan fails before anything is written;
+// worker logs are read while the archive is written.
+type ScanArchive struct {
+	Record  *types.ScanRecord
+	Status  *types.ScanStatus // nil once the scan is no longer held in memory
+	Results []types.ScanResult
+	Workers []string // every worker with logs, sorted
+}
+
+// archiveConfig is the scan configuration included in an archive
+type archiveConfig struct {
+	Targets    []string          `json:"targets"`
+	Plan       *types.ScanPlan   `json:"plan,omitempty"`
+	ExtraFlags []string          `json:"extraFlags,omitempty"`
+	ConfigYAML string            `json:"configYaml,omitempty"`
+	Secrets    map[string]string `json:"secrets,omitempty"` // values redacted
+}
+
+// archiveManifest describes the scan and the files in its archive
+type archiveManifest struct {
+	ScanID          string            `json:"scanId"`
+	Status          string            `json:"status"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	CompletedAt     *time.Time        `json:"completedAt,omitempty"`
+	DurationSeconds float64           `json:"durationSeconds,omitempty"`
+	GeneratedAt     time.Time         `json:"generatedAt"`
+	TotalDomains    int               `json:"totalDomains"`
+	ScannedDomains  int               `json:"scannedDomains"`
+	ResultCount     int               `json:"resultCount"`
+	SeverityCounts  map[string]int    `json:"severityCounts"`
+	Workers         []archiveWorker   `json:"workers"`
+	Files           []string          `json:"files"`
+	Errors          map[string]string `json:"errors,omitempty"` // files that could not be written completely
+}
+
+// archiveWorker is a worker's metadata in an archive manifest. Details other
+// than the log line count are only known while the scan is in memory.
+type archiveWorker struct {
+	ID             string     `json:"id"`
+	IP             string     `json:"ip,omitempty"`
+	IPv6           string     `json:"ipv6,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty"`
+	DomainsScanned int        `json:"domainsScanned"`
+	TotalDomains   int        `json:"totalDomains"`
+	RestartCount   int        `json:"restartCount"`
+	LogLines       int        `json:"logLines"`
+	LogFile        string     `json:"logFile"`
+}
+
+// GetScanArchive loads what is needed to write a scan's archive
+func (o *Orchestrator) GetScanArchive(scanID string) (*ScanArchive, error) {
+	record, err := o.GetScanRecord(scanID)
+	if err != nil {
+		return nil, err
+	}
+	results, err := o.allResults(scanID)
+	if err != nil {
+		return nil, err
+	}
+	archive := &ScanArchive{Record: record, Results: results}
+
+	workers := make(map[string]bool)
+	o.mutex.RLock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		archive.Status = statusSnapshot(scan)
+		for _, worker := range scan.ActiveDroplets {
+			workers[worker.ID] = true
+		}
+	}
+	o.mutex.RUnlock()
+
+	// Logs spilled to Redis also cover workers that never registered and
+	// scans no longer in memory
+	ctx := context.Background()
+	prefix, suffix := "scan:"+scanID+":worker:", ":logs"
+	iter := o.redis.Scan(ctx, 0, workerLogsKey(globEscaper.Replace(scanID), "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		workers[strings.TrimSuffix(strings.TrimPrefix(iter.Val(), prefix), suffix)] = true
+	}
+	if err := iter.Err(); err != nil {
+		slog.Error("Failed to list worker logs", "scan_id", scanID, "error", err)
+	}
+
+	for id := range workers {
+		archive.Workers = append(archive.Workers, id)
+	}
+	sort.Strings(archive.Workers)
+	return archive, nil
+}
+
+// WriteArchive streams a scan archive to w as a zip file. Each entry is
+// flushed as soon as it is complete, so large scans are never held in memory
+// as a whole. The manifest comes last and records anything that could not
+// be written.
+func (o *Orchestrator) WriteArchive(w io.Writer, archive *ScanArchive) error {
+	zw := zip.NewWriter(w)
+	record := archive.Record
+	manifest := archiveManifest{
+		ScanID:         record.ID,
+		Status:         record.Status,
+		CreatedAt:      record.CreatedAt,
+		CompletedAt:    record.CompletedAt,
+		GeneratedAt:    time.Now().UTC(),
+		TotalDomains:   record.TotalDomains,
+		ScannedDomains: record.ScannedDomains,
+		ResultCount:    len(archive.Results),
+		SeverityCounts: record.SeverityCounts,
+		Workers:        make([]archiveWorker, 0, len(archive.Workers)),
+		Files:          make([]string, 0),
+	}
+	if record.CompletedAt != nil {
+		manifest.DurationSeconds = record.CompletedAt.Sub(record.CreatedAt).Seconds()
+	}
+
+	// entry writes one file. Failing to render a file is recorded in the
+	// manifest; only failing to write to w, e.g. once the client went away,
+	// ends the archive.
+	entry := func(name string, write func(io.Writer) error) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, name)
+		if err := write(fw); err != nil {
+			slog.Error("Failed to write archive file", "scan_id", record.ID, "file", name, "error", err)
+			if manifest.Errors == nil {
+				manifest.Errors = make(map[string]string)
+			}
+			manifest.Errors[name] = err.Error()
+		}
+		if err := zw.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(interface{ Flush() }); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	for _, file := range artifactFiles {
+		file := file
+		if err := entry(file.name, func(fw io.Writer) error { return file.write(fw, record, archive.Results) }); err != nil {
+			return err
+		}
+	}
+
+	for _, workerID := range archive.Workers {
+		worker := archiveWorker{ID: workerID, LogFile: "logs/" + archiveFileName(workerID) + ".jsonl"}
+		var memory []types.Log
+		if archive.Status != nil {
+			for _, status := range archive.Status.ActiveDroplets {
+				if status.ID == workerID {
+					createdAt := status.CreatedAt
+					worker.IP = status.IP
+					worker.IPv6 = status.IPv6
+					worker.Status = status.Status
+					worker.CreatedAt = &createdAt
+					worker.DomainsScanned = status.DomainsScanned
+					worker.TotalDomains = status.TotalDomains
+					worker.RestartCount = status.RestartCount
+					memory = status.Logs
+				}
+			}
+		}
+		err := entry(worker.LogFile, func(fw io.Writer) error {
+			lines, err := o.writeWorkerLogs(fw, record.ID, workerID, memory)
+			worker.LogLines = lines
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		manifest.Workers = append(manifest.Workers, worker)
+	}
+
+	if err := entry("config.json", func(fw io.Writer) error { return writeArchiveJSON(fw, archiveScanConfig(archive)) }); err != nil {
+		return err
+	}
+
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, "manifest.json")
+	if err := writeArchiveJSON(fw, manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeWorkerLogs writes a worker's logs as JSON lines, oldest first: the
+// lines spilled to Redis in batches, then the lines held in memory. The
+// lines in memory are still written when Redis cannot be read.
+func (o *Orchestrator) writeWorkerLogs(w io.Writer, scanID, workerID string, memory []types.Log) (int, error) {
+	ctx := context.Background()
+	key := workerLogsKey(scanID, workerID)
+	lines := 0
+
+	var spillErr error
+	for start := int64(0); ; start += archiveLogBatch {
+		entries, err := o.redis.LRange(ctx, key, start, start+archiveLogBatch-1).Result()
+		if err != nil {
+			spillErr = fmt.Errorf("failed to read spilled logs: %v", err)
+			break
+		}
+		for _, entry := range entries {
+			if _, err := io.WriteString(w, entry+"\n"); err != nil {
+				return lines, err
+			}
+			lines++
+		}
+		if len(entries) < archiveLogBatch {
+			break
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, entry := range memory {
+		if err := encoder.Encode(entry); err != nil {
+			return lines, err
+		}
+		lines++
+	}
+	return lines, spillErr
+}
+
+// archiveScanConfig returns a scan's configuration with every secret value
+// redacted
+func archiveScanConfig(archive *ScanArchive) archiveConfig {
+	config := archiveConfig{Targets: archive.Record.Targets}
+	if config.Targets == nil {
+		config.Targets = make([]string, 0)
+	}
+	if status := archive.Status; status != nil {
+		config.Plan = status.Plan
+		config.ExtraFlags = status.ExtraFlags
+		config.ConfigYAML = redactConfigYAML(status.ConfigYAML)
+		if len(status.SecretKeys) > 0 {
+			config.Secrets = make(map[string]string, len(status.SecretKeys))
+			for _, name := range status.SecretKeys {
+				config.Secrets[name] = redactedValue
+			}
+		}
+	}
+	return config
+}
+
+// redactConfigYAML replaces the values of credential-bearing keys in a
+// nuclei config file, such as headers and template variables
+func redactConfigYAML(configYAML string) string {
+	if configYAML == "" {
+		return ""
+	}
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(configYAML), &config); err != nil {
+		return redactedValue
+	}
+	for key := range config {
+		if isSecretConfigKey(key) {
+			config[key] = redactedValue
+		}
+	}
+	redacted, err := yaml.Marshal(config)
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+func isSecretConfigKey(key string) bool {
+	if secretConfigKeys[key] {
+		return true
+	}
+	lower := strings.ToLower(key)
+	for _, word := range secretKeyWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveFileName makes a worker ID safe to use as a file name
+func archiveFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func writeArchiveJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,206 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"nuclei-distributed/pkg/export"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrArtifactsDisabled is returned when no artifact storage is configured
+var ErrArtifactsDisabled = errors.New("artifact storage is not configured")
+
+const (
+	// artifactAttempts is how often each upload is tried before it is
+	// reported as failed
+	artifactAttempts = 5
+	// artifactBackoff is the wait before the first retry, doubled each time
+	artifactBackoff = 5 * time.Second
+)
+
+// artifactFile is one file archived when a scan completes
+type artifactFile struct {
+	name        string
+	contentType string
+	write       func(w io.Writer, record *types.ScanRecord, results []types.ScanResult) error
+}
+
+var artifactFiles = []artifactFile{
+	{"results.jsonl", "application/x-ndjson", func(w io.Writer, _ *types.ScanRecord, results []types.ScanResult) error {
+		return export.WriteJSONL(w, results)
+	}},
+	{"results.csv", "text/csv", func(w io.Writer, _ *types.ScanRecord, results []types.ScanResult) error {
+		return export.WriteCSV(w, results)
+	}},
+	{"report.html", "text/html; charset=utf-8", func(w io.Writer, record *types.ScanRecord, results []types.ScanResult) error {
+		meta := export.ReportMeta{
+			ScanID:         record.ID,
+			Status:         record.Status,
+			TotalDomains:   record.TotalDomains,
+			ScannedDomains: record.ScannedDomains,
+			Workers:        record.Workers,
+			GeneratedAt:    time.Now().UTC(),
+		}
+		return export.WriteHTMLReport(w, meta, results)
+	}},
+}
+
+// pendingArtifacts lists the artifacts a completed scan will upload
+func pendingArtifacts(scanID string) []types.Artifact {
+	pending := make([]types.Artifact, len(artifactFiles))
+	for i, file := range artifactFiles {
+		pending[i] = types.Artifact{
+			Name:   file.name,
+			Key:    scanID + "/" + file.name,
+			Status: "pending",
+		}
+	}
+	return pending
+}
+
+// uploadArtifacts archives a completed scan's results and report. It runs
+// independently of cleanup; progress and failures are recorded on the scan.
+func (o *Orchestrator) uploadArtifacts(scanID string) {
+	record, err := o.GetScanRecord(scanID)
+	var results []types.ScanResult
+	if err == nil {
+		results, err = o.allResults(scanID)
+	}
+	if err != nil {
+		slog.Error("Failed to read scan for artifact upload", "scan_id", scanID, "error", err)
+		for _, file := range artifactFiles {
+			o.updateArtifact(scanID, file.name, func(artifact *types.Artifact) {
+				artifact.Status = "failed"
+				artifact.Error = err.Error()
+			})
+		}
+		o.saveLiveRecord(scanID)
+		return
+	}
+
+	for _, file := range artifactFiles {
+		o.uploadArtifact(scanID, file, record, results)
+	}
+	o.saveLiveRecord(scanID)
+}
+
+// uploadArtifact renders one artifact to a temporary file and uploads it,
+// retrying with exponential backoff
+func (o *Orchestrator) uploadArtifact(scanID string, file artifactFile, record *types.ScanRecord, results []types.ScanResult) {
+	key := scanID + "/" + file.name
+	fail := func(err error) {
+		slog.Error("Failed to upload artifact", "scan_id", scanID, "key", key, "error", err)
+		o.updateArtifact(scanID, file.name, func(artifact *types.Artifact) {
+			artifact.Status = "failed"
+			artifact.Error = err.Error()
+		})
+	}
+
+	tmp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := file.write(tmp, record, results); err != nil {
+		fail(fmt.Errorf("failed to render: %v", err))
+		return
+	}
+
+	backoff := artifactBackoff
+	for attempt := 1; ; attempt++ {
+		err = o.artifacts.Put(context.Background(), key, file.contentType, tmp)
+		o.updateArtifact(scanID, file.name, func(artifact *types.Artifact) {
+			artifact.Attempts = attempt
+			if err != nil {
+				artifact.Error = err.Error()
+				return
+			}
+			uploadedAt := time.Now().UTC()
+			artifact.Status = "uploaded"
+			artifact.URL = o.artifacts.ObjectURL(key)
+			artifact.Error = ""
+			artifact.UploadedAt = &uploadedAt
+		})
+		if err == nil {
+			slog.Info("Uploaded artifact", "scan_id", scanID, "key", key)
+			return
+		}
+		if attempt == artifactAttempts {
+			fail(err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// updateArtifact applies update to one of a scan's artifacts
+func (o *Orchestrator) updateArtifact(scanID, name string, update func(*types.Artifact)) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return
+	}
+	for i := range scan.Artifacts {
+		if scan.Artifacts[i].Name == name {
+			update(&scan.Artifacts[i])
+		}
+	}
+	o.scanChanged(scan)
+}
+
+// saveLiveRecord writes the record of a scan still in memory to storage, e.g.
+// after its artifacts or email report changed
+func (o *Orchestrator) saveLiveRecord(scanID string) {
+	o.mutex.RLock()
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		o.mutex.RUnlock()
+		return
+	}
+	record := scanRecord(scan, o.scanStates[scanID])
+	o.mutex.RUnlock()
+
+	o.saveScanRecord(record)
+}
+
+// ArtifactLinks returns presigned download links for a scan's uploaded
+// artifacts, along with the state of those still pending or failed
+func (o *Orchestrator) ArtifactLinks(scanID string) ([]types.ArtifactLink, error) {
+	if o.artifacts == nil {
+		return nil, ErrArtifactsDisabled
+	}
+
+	record, err := o.GetScanRecord(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	links := make([]types.ArtifactLink, 0, len(record.Artifacts))
+	for _, artifact := range record.Artifacts {
+		link := types.ArtifactLink{
+			Name:   artifact.Name,
+			Status: artifact.Status,
+			Error:  artifact.Error,
+		}
+		if artifact.Status == "uploaded" {
+			url, expiresAt := o.artifacts.Presign(artifact.Key, now)
+			link.URL = url
+			link.ExpiresAt = &expiresAt
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
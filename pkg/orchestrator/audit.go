@@ -0,0 +1,71 @@
+package orchestrator
+
+import (
+	"log/slog"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// RecordAudit adds an entry to the audit log. A failure is logged rather
+// than returned, since the change it records has already been made.
+func (o *Orchestrator) RecordAudit(entry types.AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if err := o.store.AppendAudit(entry); err != nil {
+		slog.Error("Failed to record audit entry", "action", entry.Action, "key_id", entry.KeyID, "error", err)
+	}
+}
+
+// AuditLog returns the changes made through the API that match the filter,
+// newest first
+func (o *Orchestrator) AuditLog(filter types.AuditFilter) ([]types.AuditEntry, error) {
+	entries, err := o.store.AuditLog()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]types.AuditEntry, 0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+		if auditMatches(&entries[i], filter) {
+			matched = append(matched, entries[i])
+		}
+	}
+	return matched, nil
+}
+
+func auditMatches(entry *types.AuditEntry, filter types.AuditFilter) bool {
+	switch {
+	case filter.Action != "" && entry.Action != filter.Action:
+		return false
+	case filter.KeyID != "" && entry.KeyID != filter.KeyID:
+		return false
+	case !filter.Since.IsZero() && entry.Time.Before(filter.Since):
+		return false
+	case !filter.Until.IsZero() && !entry.Time.Before(filter.Until):
+		return false
+	}
+	return true
+}
+
+// purgeAudit removes the audit entries older than the audit retention,
+// while one is set
+func (o *Orchestrator) purgeAudit(now time.Time) {
+	retention := o.settings().auditRetention
+	if retention <= 0 {
+		return
+	}
+
+	purged, err := o.store.PurgeAudit(now.Add(-retention))
+	if err != nil {
+		slog.Error("Retention janitor failed to purge audit log", "error", err)
+		return
+	}
+	if purged > 0 {
+		slog.Info("Retention janitor purged audit entries", "entries", purged)
+	}
+}
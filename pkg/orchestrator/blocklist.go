@@ -0,0 +1,343 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidBlocklistEntry is returned for blocklist entries that cannot
+// match anything, and for changes to entries set in the configuration
+var ErrInvalidBlocklistEntry = errors.New("invalid blocklist entry")
+
+// ErrBlocklistEntryNotFound is returned when no blocklist entry has a given
+// pattern
+var ErrBlocklistEntryNotFound = errors.New("blocklist entry not found")
+
+// ErrTargetsBlocked is returned when every target of a scan is blocklisted
+var ErrTargetsBlocked = errors.New("every target is blocklisted")
+
+// ErrResultBlocked is returned for findings on blocklisted hosts. They are
+// dropped rather than stored.
+var ErrResultBlocked = errors.New("result host is blocklisted")
+
+// BlockedTargetsError refuses a scan whose targets are all blocklisted
+type BlockedTargetsError struct {
+	BlockedCount int
+	Blocked      []types.BlockedTarget // the first maxReportedRejections
+}
+
+func (e *BlockedTargetsError) Error() string {
+	return fmt.Sprintf("all %d targets are blocklisted", e.BlockedCount)
+}
+
+func (e *BlockedTargetsError) Unwrap() error {
+	return ErrTargetsBlocked
+}
+
+// blockRule is a blocklist entry with its pattern parsed
+type blockRule struct {
+	types.BlocklistEntry
+	network *net.IPNet // set for CIDR blocks and IPs
+	domain  string     // set for domains and suffix patterns
+	suffix  bool       // also match every name under domain
+}
+
+// compileBlockEntry validates an entry and normalizes its pattern, so the
+// same range or domain is always stored under the same pattern
+func compileBlockEntry(entry types.BlocklistEntry) (*blockRule, error) {
+	pattern := strings.ToLower(strings.TrimSpace(entry.Pattern))
+	if pattern == "" {
+		return nil, fmt.Errorf("%w: pattern is required", ErrInvalidBlocklistEntry)
+	}
+	if len(entry.Note) > maxTriageNote {
+		return nil, fmt.Errorf("%w: note longer than %d bytes", ErrInvalidBlocklistEntry, maxTriageNote)
+	}
+
+	rule := &blockRule{BlocklistEntry: entry}
+	switch {
+	case strings.Contains(pattern, "/"):
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a valid CIDR block", ErrInvalidBlocklistEntry, entry.Pattern)
+		}
+		rule.network = network
+		rule.Pattern = network.String()
+	case net.ParseIP(pattern) != nil:
+		ip := net.ParseIP(pattern)
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		rule.Pattern = ip.String()
+	default:
+		domain := strings.TrimPrefix(pattern, "*.")
+		rule.suffix = domain != pattern
+		if strings.Contains(domain, "*") {
+			return nil, fmt.Errorf("%w: wildcards are only allowed as a leading *.", ErrInvalidBlocklistEntry)
+		}
+		host, err := checkHost(domain)
+		if err != nil || net.ParseIP(host) != nil {
+			return nil, fmt.Errorf("%w: %q is not a domain, IP or CIDR block", ErrInvalidBlocklistEntry, entry.Pattern)
+		}
+		rule.domain = host
+		rule.Pattern = host
+		if rule.suffix {
+			rule.Pattern = "*." + host
+		}
+	}
+	return rule, nil
+}
+
+// matches reports whether host, a hostname, IP, URL or host:port, is blocked
+// by the rule. Hostnames are matched by name only, never resolved.
+func (r *blockRule) matches(host string) bool {
+	host = hostname(host)
+	if host == "" {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return r.network != nil && r.network.Contains(ip)
+	}
+	if r.domain == "" {
+		return false
+	}
+	return host == r.domain || (r.suffix && strings.HasSuffix(host, "."+r.domain))
+}
+
+// compileBlocklist parses the blocklist entries set in the configuration
+func compileBlocklist(patterns []string) ([]*blockRule, error) {
+	rules := make([]*blockRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		rule, err := compileBlockEntry(types.BlocklistEntry{Pattern: pattern, Source: types.BlocklistSourceConfig})
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// loadBlocklist reads the entries added at runtime from storage the first
+// time they are needed. Callers must hold o.blocklistMutex.
+func (o *Orchestrator) loadBlocklist() error {
+	if o.blockRules != nil {
+		return nil
+	}
+
+	stored, err := o.store.Blocklist()
+	if err != nil {
+		return err
+	}
+	rules := make([]*blockRule, 0, len(stored))
+	for _, entry := range stored {
+		rule, err := compileBlockEntry(entry)
+		if err != nil {
+			slog.Warn("Skipping stored blocklist entry", "pattern", entry.Pattern, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	o.blockRules = rules
+	return nil
+}
+
+// blocklist returns every blocklist rule, configured ones first. Callers
+// must hold o.blocklistMutex.
+func (o *Orchestrator) blocklist() []*blockRule {
+	return append(append([]*blockRule(nil), o.settings().blockRules...), o.blockRules...)
+}
+
+// findBlockRule returns the rule with a normalized pattern, or nil
+func findBlockRule(rules []*blockRule, pattern string) *blockRule {
+	for _, rule := range rules {
+		if rule.Pattern == pattern {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Blocklist returns every blocklist entry, configured ones first and the
+// others oldest first
+func (o *Orchestrator) Blocklist() ([]types.BlocklistEntry, error) {
+	o.blocklistMutex.Lock()
+	defer o.blocklistMutex.Unlock()
+
+	if err := o.loadBlocklist(); err != nil {
+		return nil, err
+	}
+	rules := o.blocklist()
+	entries := make([]types.BlocklistEntry, 0, len(rules))
+	for _, rule := range rules {
+		entries = append(entries, rule.BlocklistEntry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Source != entries[j].Source {
+			return entries[i].Source == types.BlocklistSourceConfig
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// AddBlocklistEntries adds entries to the blocklist and persists them. They
+// apply to scans submitted and findings received from then on. Entries whose
+// pattern is already listed are skipped; the entries added are returned.
+func (o *Orchestrator) AddBlocklistEntries(entries []types.BlocklistEntry) ([]types.BlocklistEntry, error) {
+	now := time.Now().UTC()
+	compiled := make([]*blockRule, 0, len(entries))
+	for _, entry := range entries {
+		entry.Source = types.BlocklistSourceAPI
+		entry.CreatedAt = now
+		rule, err := compileBlockEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+
+	o.blocklistMutex.Lock()
+	defer o.blocklistMutex.Unlock()
+
+	if err := o.loadBlocklist(); err != nil {
+		return nil, err
+	}
+	configRules := o.settings().blockRules
+	rules := append([]*blockRule(nil), o.blockRules...)
+	added := make([]types.BlocklistEntry, 0, len(compiled))
+	for _, rule := range compiled {
+		if findBlockRule(configRules, rule.Pattern) != nil || findBlockRule(rules, rule.Pattern) != nil {
+			continue
+		}
+		rules = append(rules, rule)
+		added = append(added, rule.BlocklistEntry)
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	if err := o.saveBlocklist(rules); err != nil {
+		return nil, err
+	}
+	for _, entry := range added {
+		slog.Info("Blocklist: added entry", "pattern", entry.Pattern)
+	}
+	return added, nil
+}
+
+// RemoveBlocklistEntry removes an entry added at runtime. Entries set in the
+// configuration cannot be removed.
+func (o *Orchestrator) RemoveBlocklistEntry(pattern string) error {
+	rule, err := compileBlockEntry(types.BlocklistEntry{Pattern: pattern})
+	if err != nil {
+		return err
+	}
+
+	o.blocklistMutex.Lock()
+	defer o.blocklistMutex.Unlock()
+
+	if err := o.loadBlocklist(); err != nil {
+		return err
+	}
+	if findBlockRule(o.settings().blockRules, rule.Pattern) != nil {
+		return fmt.Errorf("%w: %s is set in the configuration and cannot be removed", ErrInvalidBlocklistEntry, rule.Pattern)
+	}
+	rules := make([]*blockRule, 0, len(o.blockRules))
+	for _, existing := range o.blockRules {
+		if existing.Pattern != rule.Pattern {
+			rules = append(rules, existing)
+		}
+	}
+	if len(rules) == len(o.blockRules) {
+		return ErrBlocklistEntryNotFound
+	}
+
+	if err := o.saveBlocklist(rules); err != nil {
+		return err
+	}
+	slog.Info("Blocklist: removed entry", "pattern", rule.Pattern)
+	return nil
+}
+
+// saveBlocklist persists the runtime entries and then uses them. Callers
+// must hold o.blocklistMutex.
+func (o *Orchestrator) saveBlocklist(rules []*blockRule) error {
+	entries := make([]types.BlocklistEntry, 0, len(rules))
+	for _, rule := range rules {
+		entries = append(entries, rule.BlocklistEntry)
+	}
+	if err := o.store.SaveBlocklist(entries); err != nil {
+		return err
+	}
+	o.blockRules = rules
+	return nil
+}
+
+// blockedTargets removes blocklisted targets from a scan's targets and
+// records them in summary. Every blocked target is logged. Scans are refused
+// rather than checked against a partial blocklist when the stored entries
+// cannot be loaded.
+func (o *Orchestrator) blockedTargets(scanID string, targets []string, summary *types.TargetSummary) ([]string, error) {
+	o.blocklistMutex.Lock()
+	if err := o.loadBlocklist(); err != nil {
+		o.blocklistMutex.Unlock()
+		return nil, fmt.Errorf("failed to load blocklist: %v", err)
+	}
+	rules := o.blocklist()
+	o.blocklistMutex.Unlock()
+
+	if len(rules) == 0 {
+		return targets, nil
+	}
+	allowed := make([]string, 0, len(targets))
+	for _, target := range targets {
+		rule := matchBlockRule(rules, target)
+		if rule == nil {
+			allowed = append(allowed, target)
+			continue
+		}
+		slog.Warn("Blocklist: removed target", "scan_id", scanID, "target", target, "pattern", rule.Pattern)
+		summary.BlockedCount++
+		if len(summary.Blocked) < maxReportedRejections {
+			summary.Blocked = append(summary.Blocked, types.BlockedTarget{Target: target, Pattern: rule.Pattern})
+		}
+	}
+	return allowed, nil
+}
+
+// resultBlocked returns the blocklist entry a finding's host or IP matches,
+// or nil. Findings are checked against the entries loaded so far when the
+// stored ones cannot be read.
+func (o *Orchestrator) resultBlocked(result *types.ScanResult) *blockRule {
+	o.blocklistMutex.Lock()
+	if err := o.loadBlocklist(); err != nil {
+		slog.Error("Failed to load blocklist", "error", err)
+	}
+	rules := o.blocklist()
+	o.blocklistMutex.Unlock()
+
+	if rule := matchBlockRule(rules, result.Host); rule != nil {
+		return rule
+	}
+	if result.IP != "" {
+		return matchBlockRule(rules, result.IP)
+	}
+	return nil
+}
+
+func matchBlockRule(rules []*blockRule, host string) *blockRule {
+	for _, rule := range rules {
+		if rule.matches(host) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// DefaultDropletCapacities is how many targets each droplet size takes and
+// scans per hour, unless the server configuration says otherwise. The
+// smallest size matches the global defaults.
+var DefaultDropletCapacities = map[string]types.DropletCapacity{
+	"s-1vcpu-1gb":  {MaxDomainsPerDroplet: 500, DomainsPerHour: 600},
+	"s-1vcpu-2gb":  {MaxDomainsPerDroplet: 600, DomainsPerHour: 700},
+	"s-2vcpu-2gb":  {MaxDomainsPerDroplet: 1000, DomainsPerHour: 1200},
+	"s-2vcpu-4gb":  {MaxDomainsPerDroplet: 1200, DomainsPerHour: 1400},
+	"s-4vcpu-8gb":  {MaxDomainsPerDroplet: 2500, DomainsPerHour: 2800},
+	"s-8vcpu-16gb": {MaxDomainsPerDroplet: 5000, DomainsPerHour: 5500},
+}
+
+// ParseDropletCapacities reads capacity table entries written as
+// size=maxDomainsPerDroplet:domainsPerHour, comma-separated, e.g.
+// "s-4vcpu-8gb=3000:3200"
+func ParseDropletCapacities(value string) (map[string]types.DropletCapacity, error) {
+	capacities := make(map[string]types.DropletCapacity)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		size, figures, found := strings.Cut(entry, "=")
+		maxDomains, perHour, valid := strings.Cut(figures, ":")
+		if !found || !valid || strings.TrimSpace(size) == "" {
+			return nil, fmt.Errorf("%q is not size=maxDomainsPerDroplet:domainsPerHour", entry)
+		}
+		var capacity types.DropletCapacity
+		var err error
+		if capacity.MaxDomainsPerDroplet, err = strconv.Atoi(strings.TrimSpace(maxDomains)); err != nil {
+			return nil, fmt.Errorf("%q: invalid targets per droplet", entry)
+		}
+		if capacity.DomainsPerHour, err = strconv.ParseFloat(strings.TrimSpace(perHour), 64); err != nil {
+			return nil, fmt.Errorf("%q: invalid targets per hour", entry)
+		}
+		capacities[strings.TrimSpace(size)] = capacity
+	}
+	return capacities, nil
+}
+
+// serverDropletCapacities merges the configured capacity table over the
+// defaults and checks every entry
+func serverDropletCapacities(configured map[string]types.DropletCapacity) (map[string]types.DropletCapacity, error) {
+	capacities := make(map[string]types.DropletCapacity, len(DefaultDropletCapacities)+len(configured))
+	for size, capacity := range DefaultDropletCapacities {
+		capacities[size] = capacity
+	}
+	for size, capacity := range configured {
+		if capacity.MaxDomainsPerDroplet < 1 || capacity.DomainsPerHour <= 0 {
+			return nil, fmt.Errorf("invalid capacity for droplet size %s: targets per droplet and per hour must be positive", size)
+		}
+		capacities[size] = capacity
+	}
+	return capacities, nil
+}
+
+// capacityLimits applies a droplet size's capacity to the server's optimizer
+// limits: it replaces the global targets per droplet, so larger sizes take
+// more of them, and is the ceiling scans may narrow
+func capacityLimits(server types.OptimizerLimits, capacity types.DropletCapacity) types.OptimizerLimits {
+	limits := server
+	limits.MaxDomainsPerDroplet = capacity.MaxDomainsPerDroplet
+	if limits.MinDomainsPerDroplet > limits.MaxDomainsPerDroplet {
+		limits.MinDomainsPerDroplet = limits.MaxDomainsPerDroplet
+	}
+	return limits
+}
@@ -0,0 +1,369 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidConfig is wrapped by errors caused by server settings that do
+// not validate, whether from the environment, CONFIG_FILE or the API
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// redacted replaces secrets in the configuration the API returns. Sent back
+// unchanged in an update, it keeps the secret in use.
+const redacted = "[redacted]"
+
+// runtimeConfig is the server configuration in use, with its settings
+// parsed. It is never changed once in use, only replaced.
+type runtimeConfig struct {
+	types.ServerConfig
+	retention      time.Duration // zero when scans are kept forever
+	auditRetention time.Duration // zero when the audit log is kept forever
+	blockRules     []*blockRule  // from Blocklist
+	alerts         alertConfig
+	preflight      preflightConfig
+	enumeration    enumerationConfig
+}
+
+// compileConfig checks the settings of cfg and fills in the defaults of
+// those left unset, so the configuration shows the values in use
+func compileConfig(cfg types.ServerConfig) (*runtimeConfig, error) {
+	invalid := func(field, format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalidConfig, field, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Nuclei.Timeout < 0 || cfg.Nuclei.Retries < 0 || cfg.Nuclei.MaxHostErrors < 0 {
+		return nil, invalid("nuclei", "settings must not be negative")
+	}
+	optimizer, err := serverOptimizerLimits(cfg.Optimizer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	cfg.Optimizer = optimizer
+	if cfg.RebalanceThreshold < 0 || cfg.RebalanceThreshold > 100 {
+		return nil, invalid("rebalanceThreshold", "must be a percentage between 0 and 100")
+	}
+	if cfg.MaxInvalidTargets < 0 || cfg.MaxInvalidTargets > 1 {
+		return nil, invalid("maxInvalidTargets", "must be a fraction between 0 and 1")
+	}
+	if err := checkFailurePolicy(cfg.FailurePolicy); err != nil {
+		return nil, fmt.Errorf("%w: failurePolicy.%v", ErrInvalidConfig, err)
+	}
+
+	compiled := &runtimeConfig{}
+	if cfg.Retention != "" {
+		if compiled.retention, err = time.ParseDuration(cfg.Retention); err != nil || compiled.retention <= 0 {
+			return nil, invalid("retention", "must be a positive duration such as 720h, or empty to keep scans forever")
+		}
+		cfg.Retention = compiled.retention.String()
+	}
+	if cfg.AuditRetention != "" {
+		if compiled.auditRetention, err = time.ParseDuration(cfg.AuditRetention); err != nil || compiled.auditRetention <= 0 {
+			return nil, invalid("auditRetention", "must be a positive duration such as 2160h, or empty to keep the audit log forever")
+		}
+		cfg.AuditRetention = compiled.auditRetention.String()
+	}
+
+	if compiled.blockRules, err = compileBlocklist(cfg.Blocklist); err != nil {
+		return nil, fmt.Errorf("%w: blocklist: %v", ErrInvalidConfig, err)
+	}
+
+	notifications := &cfg.Notifications
+	for _, hook := range notifications.Webhooks {
+		if err := notify.ValidateWebhook(hook); err != nil {
+			return nil, fmt.Errorf("%w: notifications.webhooks: %v", ErrInvalidConfig, err)
+		}
+	}
+	if err := notify.ValidateChatWebhook("Slack", notifications.Slack.WebhookURL, notifications.Slack.MinSeverity); err != nil {
+		return nil, fmt.Errorf("%w: notifications.slack: %v", ErrInvalidConfig, err)
+	}
+	if err := notify.ValidateChatWebhook("Discord", notifications.Discord.WebhookURL, notifications.Discord.MinSeverity); err != nil {
+		return nil, fmt.Errorf("%w: notifications.discord: %v", ErrInvalidConfig, err)
+	}
+	if err := notify.ValidateEmails(notifications.Emails); err != nil {
+		return nil, fmt.Errorf("%w: notifications.emails: %v", ErrInvalidConfig, err)
+	}
+
+	if compiled.alerts, err = parseAlertSettings(&cfg.Alerts); err != nil {
+		return nil, fmt.Errorf("%w: alerts.%v", ErrInvalidConfig, err)
+	}
+	if compiled.preflight, err = parsePreflightSettings(&cfg.Preflight); err != nil {
+		return nil, fmt.Errorf("%w: preflight.%v", ErrInvalidConfig, err)
+	}
+	if compiled.enumeration, err = parseEnumerationSettings(&cfg.Enumeration); err != nil {
+		return nil, fmt.Errorf("%w: enumeration.%v", ErrInvalidConfig, err)
+	}
+
+	// Lists are shown empty rather than null
+	if cfg.Blocklist == nil {
+		cfg.Blocklist = []string{}
+	}
+	if notifications.Webhooks == nil {
+		notifications.Webhooks = []types.WebhookConfig{}
+	}
+	if notifications.Emails == nil {
+		notifications.Emails = []string{}
+	}
+	if cfg.Alerts.Sinks == nil {
+		cfg.Alerts.Sinks = []string{}
+	}
+	compiled.ServerConfig = cfg
+	return compiled, nil
+}
+
+// configFields returns the top-level fields of a configuration by JSON name
+func configFields(cfg types.ServerConfig) map[string]json.RawMessage {
+	data, _ := json.Marshal(cfg)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// mergeConfig applies the JSON object patch to cfg: nested objects are
+// merged field by field and every other value, lists included, is replaced.
+// Unknown fields are refused, and secrets sent back redacted keep the
+// values in cfg. It returns the merged configuration and the names of the
+// top-level fields that changed.
+func mergeConfig(cfg types.ServerConfig, patch []byte) (types.ServerConfig, []string, error) {
+	var current, changes map[string]interface{}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, nil, err
+	}
+	json.Unmarshal(data, &current)
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return cfg, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if data, err = json.Marshal(mergeObjects(current, changes)); err != nil {
+		return cfg, nil, err
+	}
+
+	// Decoded afresh, since decoding into a list reuses its elements
+	var merged types.ServerConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&merged); err != nil {
+		return cfg, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	notifications := &merged.Notifications
+	for i, hook := range notifications.Webhooks {
+		if hook.Secret != redacted {
+			continue
+		}
+		notifications.Webhooks[i].Secret = ""
+		for _, existing := range cfg.Notifications.Webhooks {
+			if existing.URL == hook.URL {
+				notifications.Webhooks[i].Secret = existing.Secret
+			}
+		}
+	}
+	if notifications.Slack.WebhookURL == redacted {
+		notifications.Slack.WebhookURL = cfg.Notifications.Slack.WebhookURL
+	}
+	if notifications.Discord.WebhookURL == redacted {
+		notifications.Discord.WebhookURL = cfg.Notifications.Discord.WebhookURL
+	}
+
+	before, after := configFields(cfg), configFields(merged)
+	var changed []string
+	for name, value := range after {
+		if !bytes.Equal(before[name], value) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return merged, changed, nil
+}
+
+// LoadConfigFile applies a YAML file of settings over cfg, with the field
+// names of the runtime configuration:
+//
+//	retention: 720h
+//	optimizer:
+//	  maxDroplets: 8
+//	notifications:
+//	  emails: [security@example.com]
+func LoadConfigFile(file string, cfg types.ServerConfig) (types.ServerConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	if settings == nil {
+		return cfg, nil
+	}
+	patch, err := json.Marshal(settings)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	merged, _, err := mergeConfig(cfg, patch)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return merged, nil
+}
+
+// mergeObjects sets the fields of changes on base, merging nested objects
+func mergeObjects(base, changes map[string]interface{}) map[string]interface{} {
+	for name, value := range changes {
+		nested, isObject := value.(map[string]interface{})
+		existing, wasObject := base[name].(map[string]interface{})
+		if isObject && wasObject {
+			value = mergeObjects(existing, nested)
+		}
+		base[name] = value
+	}
+	return base
+}
+
+// redactConfig hides the webhook secrets and the chat webhook URLs, which
+// grant posting to their channels
+func redactConfig(cfg types.ServerConfig) types.ServerConfig {
+	webhooks := make([]types.WebhookConfig, len(cfg.Notifications.Webhooks))
+	for i, hook := range cfg.Notifications.Webhooks {
+		if hook.Secret != "" {
+			hook.Secret = redacted
+		}
+		webhooks[i] = hook
+	}
+	cfg.Notifications.Webhooks = webhooks
+	if cfg.Notifications.Slack.WebhookURL != "" {
+		cfg.Notifications.Slack.WebhookURL = redacted
+	}
+	if cfg.Notifications.Discord.WebhookURL != "" {
+		cfg.Notifications.Discord.WebhookURL = redacted
+	}
+	return cfg
+}
+
+// settings returns the runtime configuration in use
+func (o *Orchestrator) settings() *runtimeConfig {
+	return o.config.Load()
+}
+
+// useConfig puts a compiled configuration in use. Alerts are sent through
+// notifiers made from its settings from then on.
+func (o *Orchestrator) useConfig(compiled *runtimeConfig) {
+	o.config.Store(compiled)
+	o.alerts.configure(compiled.alerts, o.notifyConfig())
+}
+
+// ServerConfig returns the runtime configuration in use, secrets redacted
+func (o *Orchestrator) ServerConfig() (types.ServerConfig, error) {
+	o.configMutex.Lock()
+	defer o.configMutex.Unlock()
+
+	if err := o.loadConfigOverrides(); err != nil {
+		return types.ServerConfig{}, err
+	}
+	return redactConfig(o.settings().ServerConfig), nil
+}
+
+// UpdateConfig applies a JSON object of changes to the runtime
+// configuration and stores the fields that changed. Scans started from then
+// on use the new settings. The configuration in use is returned with the
+// changes for the audit log, secrets redacted in both.
+func (o *Orchestrator) UpdateConfig(patch []byte) (types.ServerConfig, []types.ConfigChange, error) {
+	o.configMutex.Lock()
+	defer o.configMutex.Unlock()
+
+	if err := o.loadConfigOverrides(); err != nil {
+		return types.ServerConfig{}, nil, err
+	}
+	current := o.settings().ServerConfig
+	merged, changed, err := mergeConfig(current, patch)
+	if err != nil {
+		return types.ServerConfig{}, nil, err
+	}
+	compiled, err := compileConfig(merged)
+	if err != nil {
+		return types.ServerConfig{}, nil, err
+	}
+	if len(changed) == 0 {
+		return redactConfig(current), nil, nil
+	}
+
+	overrides := make(map[string]json.RawMessage, len(o.configOverrides)+len(changed))
+	for name, value := range o.configOverrides {
+		overrides[name] = value
+	}
+	before, after := configFields(redactConfig(current)), configFields(redactConfig(compiled.ServerConfig))
+	stored := configFields(compiled.ServerConfig)
+	changes := make([]types.ConfigChange, 0, len(changed))
+	for _, name := range changed {
+		overrides[name] = stored[name]
+		changes = append(changes, types.ConfigChange{Field: name, Old: before[name], New: after[name]})
+	}
+
+	if err := o.store.SaveConfigOverrides(overrides); err != nil {
+		return types.ServerConfig{}, nil, err
+	}
+	o.configOverrides = overrides
+	o.useConfig(compiled)
+	slog.Info("Configuration changed", "fields", changed)
+	return redactConfig(compiled.ServerConfig), changes, nil
+}
+
+// loadConfigOverrides applies the settings changed at runtime, read from
+// storage the first time they are needed, over the configuration the
+// server started with. Stored fields that no longer apply are skipped.
+// Callers must hold o.configMutex.
+func (o *Orchestrator) loadConfigOverrides() error {
+	if o.configOverrides != nil {
+		return nil
+	}
+
+	stored, err := o.store.ConfigOverrides()
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]json.RawMessage, len(stored))
+	compiled := o.settings()
+	for name, value := range stored {
+		patch, _ := json.Marshal(map[string]json.RawMessage{name: value})
+		merged, _, err := mergeConfig(compiled.ServerConfig, patch)
+		if err == nil {
+			var next *runtimeConfig
+			if next, err = compileConfig(merged); err == nil {
+				compiled = next
+				overrides[name] = value
+				continue
+			}
+		}
+		slog.Warn("Skipping stored configuration change", "field", name, "error", err)
+	}
+	o.configOverrides = overrides
+	if len(overrides) > 0 {
+		o.useConfig(compiled)
+		slog.Info("Applied configuration changed at runtime", "fields", len(overrides))
+	}
+	return nil
+}
+
+// notifyConfig returns the server's notifier settings in use
+func (o *Orchestrator) notifyConfig() NotifyConfig {
+	notifications := o.settings().Notifications
+	smtp := o.smtp
+	smtp.Recipients = notifications.Emails
+	return NotifyConfig{
+		Webhooks:  notifications.Webhooks,
+		Slack:     notifications.Slack,
+		Discord:   notifications.Discord,
+		SMTP:      smtp,
+		PublicURL: notifications.PublicURL,
+	}
+}
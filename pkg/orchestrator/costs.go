@@ -0,0 +1,286 @@
+package orchestrator
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// MaxCostDays is the longest window GET /stats/costs reports on
+const MaxCostDays = 366
+
+// billDroplet starts billing a droplet to a scan and saves the scan's record,
+// so a droplet leaked by a crash is still charged to it. An unset price is
+// the plan's for the size or the price table's, an unset start now.
+func (o *Orchestrator) billDroplet(scanID string, droplet types.DropletCost) {
+	o.mutex.Lock()
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		o.mutex.Unlock()
+		return
+	}
+	if droplet.PriceHourly <= 0 {
+		droplet.PriceHourly = planPrice(scan, droplet.Size)
+	}
+	if droplet.StartedAt.IsZero() {
+		droplet.StartedAt = time.Now()
+	}
+	droplet.StartedAt = droplet.StartedAt.UTC()
+	if scan.ActualCost == nil {
+		scan.ActualCost = &types.ActualCost{Currency: o.currency()}
+	}
+	scan.ActualCost.Droplets = append(scan.ActualCost.Droplets, droplet)
+	record := scanRecord(scan, o.scanStates[scanID])
+	o.mutex.Unlock()
+
+	o.saveScanRecord(record)
+}
+
+// planPrice is the hourly price of a droplet size as the scan's plan priced
+// it, or as the price table does for other sizes
+func planPrice(scan *types.ScanStatus, size string) float64 {
+	if scan.Plan != nil && scan.Plan.DropletSize == size && scan.Plan.PriceHourly > 0 {
+		return scan.Plan.PriceHourly
+	}
+	return dropletSizes[size].PriceHourly
+}
+
+// endBilling stops billing the droplet with dropletID, or the pooled droplet
+// of workerID when dropletID is empty, and reports whether it was billed
+func endBilling(cost *types.ActualCost, dropletID, workerID string, at time.Time) bool {
+	if cost == nil {
+		return false
+	}
+	for i := range cost.Droplets {
+		droplet := &cost.Droplets[i]
+		if droplet.EndedAt != nil {
+			continue
+		}
+		if (dropletID != "" && droplet.DropletID == dropletID) ||
+			(dropletID == "" && droplet.Pooled && droplet.WorkerID == workerID) {
+			ended := at.UTC()
+			droplet.EndedAt = &ended
+			return true
+		}
+	}
+	return false
+}
+
+// cleanedUp records a scan's cleanup. Droplets still billed were not
+// destroyed and leak from now on.
+func cleanedUp(cost *types.ActualCost, at time.Time) {
+	if cost == nil {
+		return
+	}
+	at = at.UTC()
+	cost.CleanedUpAt = &at
+	for i := range cost.Droplets {
+		if cost.Droplets[i].EndedAt == nil {
+			cost.Droplets[i].LeakedFrom = &at
+		}
+	}
+}
+
+// reapDroplet stops billing a destroyed droplet of a scan, adding it first
+// when the scan never billed it, e.g. when it was created before costs were
+// tracked. A droplet outliving the scan's cleanup, or endedAt for a scan
+// that ended without one, leaked from then.
+func reapDroplet(cost *types.ActualCost, droplet *provider.Worker, price float64, endedAt *time.Time, now time.Time) {
+	index := -1
+	for i := range cost.Droplets {
+		if cost.Droplets[i].DropletID == droplet.ID && cost.Droplets[i].EndedAt == nil {
+			index = i
+		}
+	}
+	if index < 0 {
+		start := droplet.CreatedAt
+		if start.IsZero() {
+			start = now
+		}
+		cost.Droplets = append(cost.Droplets, types.DropletCost{
+			WorkerID:    droplet.Name,
+			DropletID:   droplet.ID,
+			Size:        droplet.Size,
+			PriceHourly: price,
+			StartedAt:   start.UTC(),
+		})
+		index = len(cost.Droplets) - 1
+	}
+
+	reaped := &cost.Droplets[index]
+	ended := now.UTC()
+	reaped.EndedAt = &ended
+	if reaped.LeakedFrom == nil {
+		if cost.CleanedUpAt != nil {
+			reaped.LeakedFrom = cost.CleanedUpAt
+		} else if endedAt != nil {
+			reaped.LeakedFrom = endedAt
+		}
+	}
+}
+
+// billReapedDroplet charges the runtime of a droplet destroyed through the
+// admin API to its scan, in memory or in storage when the server no longer
+// tracks the scan
+func (o *Orchestrator) billReapedDroplet(scanID string, droplet *provider.Worker) {
+	now := time.Now()
+	price := droplet.PriceHourly
+	if price <= 0 {
+		price = dropletSizes[droplet.Size].PriceHourly
+	}
+
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		if scan.ActualCost == nil {
+			scan.ActualCost = &types.ActualCost{Currency: o.currency()}
+		}
+		// The scan's cleanup marks the droplets it left behind as leaked
+		reapDroplet(scan.ActualCost, droplet, price, nil, now)
+		o.scanChanged(scan)
+		record := scanRecord(scan, o.scanStates[scanID])
+		o.mutex.Unlock()
+		o.saveScanRecord(record)
+		return
+	}
+	o.mutex.Unlock()
+
+	record, err := o.store.GetScan(scanID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to bill reaped droplet to its scan", "scan_id", scanID, "droplet_id", droplet.ID, "error", err)
+		return
+	}
+	if record.ActualCost == nil {
+		record.ActualCost = &types.ActualCost{Currency: o.currency()}
+	}
+	// The server stopped tracking the scan, so a droplet of a scan that
+	// ended leaked from its end
+	reapDroplet(record.ActualCost, droplet, price, record.CompletedAt, now)
+	record.ActualCost = accrue(record.ActualCost, scanEnded(record.Status), now)
+	o.saveScanRecord(*record)
+	slog.Info("Billed reaped droplet to its scan", "scan_id", scanID, "droplet_id", droplet.ID,
+		"total", record.ActualCost.Total)
+}
+
+// accrue prices a scan's droplets up to now and returns a copy with the
+// costs filled in; ended says whether the scan ended
+func accrue(cost *types.ActualCost, ended bool, now time.Time) *types.ActualCost {
+	if cost == nil {
+		return nil
+	}
+	result := *cost
+	result.Total, result.LeakedCost, result.Running = 0, 0, 0
+	result.Droplets = make([]types.DropletCost, len(cost.Droplets))
+	for i, droplet := range cost.Droplets {
+		end := now
+		if droplet.EndedAt != nil {
+			end = *droplet.EndedAt
+		} else {
+			result.Running++
+		}
+		droplet.Cost = billed(droplet.PriceHourly, droplet.StartedAt, end)
+		result.Total += droplet.Cost
+		if droplet.LeakedFrom != nil {
+			result.LeakedCost += billed(droplet.PriceHourly, later(droplet.StartedAt, *droplet.LeakedFrom), end)
+		}
+		result.Droplets[i] = droplet
+	}
+	result.Final = ended && result.Running == 0
+	return &result
+}
+
+// billed is what a droplet at priceHourly costs from start to end
+func billed(priceHourly float64, start, end time.Time) float64 {
+	if !end.After(start) {
+		return 0
+	}
+	return priceHourly * end.Sub(start).Hours()
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// CostStats reports what scans' droplets cost over the last days UTC days,
+// today included, by day and by scan
+func (o *Orchestrator) CostStats(days int) (*types.CostStats, error) {
+	records, err := o.ListScans()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := today.AddDate(0, 0, 1-days)
+	stats := &types.CostStats{
+		Currency: o.currency(),
+		From:     from,
+		To:       now,
+		Days:     make([]types.DayCost, days),
+		Scans:    []types.ScanCost{},
+	}
+	for i := range stats.Days {
+		stats.Days[i].Date = from.AddDate(0, 0, i).Format("2006-01-02")
+	}
+
+	for _, record := range records {
+		cost := accrue(record.ActualCost, scanEnded(record.Status), now)
+		if cost == nil {
+			continue
+		}
+		scan := types.ScanCost{
+			ScanID:    record.ID,
+			Status:    record.Status,
+			CreatedAt: record.CreatedAt,
+			Total:     cost.Total,
+			Running:   cost.Running,
+			Final:     cost.Final,
+		}
+		billedDays := make(map[int]bool)
+		for _, droplet := range cost.Droplets {
+			end := now
+			if droplet.EndedAt != nil {
+				end = *droplet.EndedAt
+			}
+			// Each day gets the part of the droplet's runtime it saw
+			for day := range stats.Days {
+				dayStart := from.AddDate(0, 0, day)
+				start, stop := later(droplet.StartedAt, dayStart), earlier(end, dayStart.AddDate(0, 0, 1))
+				if !stop.After(start) {
+					continue
+				}
+				share := billed(droplet.PriceHourly, start, stop)
+				stats.Days[day].Cost += share
+				scan.Cost += share
+				billedDays[day] = true
+			}
+		}
+		if len(billedDays) == 0 {
+			continue
+		}
+		for day := range billedDays {
+			stats.Days[day].Scans++
+		}
+		stats.Total += scan.Cost
+		stats.Scans = append(stats.Scans, scan)
+	}
+	sort.SliceStable(stats.Scans, func(i, j int) bool { return stats.Scans[i].Cost > stats.Scans[j].Cost })
+	return stats, nil
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
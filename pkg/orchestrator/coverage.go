@@ -0,0 +1,194 @@
+package orchestrator
+
+import (
+	"errors"
+	"sort"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// Statuses a scan ends in: completed when every target was scanned or
+// skipped, partial when some were not, and degraded or failed when more
+// workers failed or targets went unscanned than the failure policy allows
+const (
+	ScanCompleted = "completed"
+	ScanPartial   = "partial"
+	ScanDegraded  = "degraded"
+	ScanFailed    = "failed"
+)
+
+// Coverage states of a scan's targets. Completed, errored, skipped and dead
+// are final.
+const (
+	TargetPending   = "pending"   // waiting for a worker to start or lease it
+	TargetAssigned  = "assigned"  // held by a running worker
+	TargetCompleted = "completed" // scanned, or found dead by the probe
+	TargetErrored   = "errored"   // its worker failed or never came up
+	TargetSkipped   = "skipped"   // nuclei gave up on the host after repeated errors
+	TargetDead      = "dead"      // found dead by preflight, never handed to a worker
+)
+
+// targetStates lists the coverage states in the order reports show them
+var targetStates = []string{TargetPending, TargetAssigned, TargetCompleted, TargetErrored, TargetSkipped, TargetDead}
+
+// ErrCoverageMissing is returned for scans recorded before their targets'
+// coverage was tracked
+var ErrCoverageMissing = errors.New("scan was recorded before target coverage was tracked")
+
+// scanEnded reports whether a scan status is one a scan ends in
+func scanEnded(status string) bool {
+	return status == ScanCompleted || status == ScanPartial || status == ScanDegraded || status == ScanFailed
+}
+
+// targetEntry is the coverage state of a target and the worker holding it,
+// empty while it waits in a pull scan's pool
+type targetEntry struct {
+	state  string
+	worker string
+}
+
+// targetCoverage tracks the state of each of a scan's targets, from worker
+// starts, reported segments, confirmed batches, problem hosts and worker
+// completions. Guarded by o.mutex.
+type targetCoverage struct {
+	targets map[string]*targetEntry
+	started map[string]bool // workers whose targets moved to assigned
+}
+
+func newTargetCoverage() *targetCoverage {
+	return &targetCoverage{
+		targets: make(map[string]*targetEntry),
+		started: make(map[string]bool),
+	}
+}
+
+// assign hands targets to a worker, or back to the pool when workerID is
+// empty, in the given state. Targets already scanned keep their state.
+func (c *targetCoverage) assign(targets []string, workerID, state string) {
+	if state == TargetPending && c.started[workerID] {
+		state = TargetAssigned
+	}
+	for _, target := range targets {
+		entry := c.targets[target]
+		if entry == nil {
+			entry = &targetEntry{}
+			c.targets[target] = entry
+		}
+		if entry.state == TargetCompleted || entry.state == TargetSkipped || entry.state == TargetDead {
+			continue
+		}
+		entry.state = state
+		entry.worker = workerID
+	}
+}
+
+// complete marks targets scanned, unless nuclei skipped their host
+func (c *targetCoverage) complete(targets []string) {
+	for _, target := range targets {
+		if entry := c.targets[target]; entry != nil && entry.state != TargetSkipped {
+			entry.state = TargetCompleted
+		}
+	}
+}
+
+// workerStarted moves the targets waiting for a worker to assigned
+func (c *targetCoverage) workerStarted(workerID string) {
+	if c.started[workerID] {
+		return
+	}
+	c.started[workerID] = true
+	for _, entry := range c.targets {
+		if entry.worker == workerID && entry.state == TargetPending {
+			entry.state = TargetAssigned
+		}
+	}
+}
+
+// workerFinished moves the targets a worker still holds to state, completed
+// when it finished them and errored when it failed
+func (c *targetCoverage) workerFinished(workerID, state string) {
+	for _, entry := range c.targets {
+		if entry.worker == workerID && (entry.state == TargetPending || entry.state == TargetAssigned) {
+			entry.state = state
+		}
+	}
+}
+
+// skipHost marks the targets on a host nuclei gave up on skipped
+func (c *targetCoverage) skipHost(host string) {
+	host = hostname(host)
+	for target, entry := range c.targets {
+		if entry.state != TargetErrored && entry.state != TargetDead && hostname(target) == host {
+			entry.state = TargetSkipped
+		}
+	}
+}
+
+// summary counts the targets in each state. The gap is the targets neither
+// scanned, skipped nor dead.
+func (c *targetCoverage) summary() *types.CoverageSummary {
+	summary := &types.CoverageSummary{Counts: make(map[string]int, len(targetStates))}
+	for _, state := range targetStates {
+		summary.Counts[state] = 0
+	}
+	for _, entry := range c.targets {
+		summary.Counts[entry.state]++
+		if entry.state != TargetCompleted && entry.state != TargetSkipped && entry.state != TargetDead {
+			summary.Gap++
+		}
+	}
+	return summary
+}
+
+// uncovered lists the targets that were not scanned, ordered by target
+func (c *targetCoverage) uncovered() []types.UncoveredTarget {
+	var targets []types.UncoveredTarget
+	for target, entry := range c.targets {
+		if entry.state != TargetCompleted {
+			targets = append(targets, types.UncoveredTarget{Target: target, State: entry.state, WorkerID: entry.worker})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Target < targets[j].Target })
+	return targets
+}
+
+// GetCoverage reports which of a scan's targets were scanned, live for scans
+// in memory and as recorded when they ended otherwise, with the targets
+// that were not
+func (o *Orchestrator) GetCoverage(scanID string) (*types.CoverageReport, error) {
+	o.mutex.RLock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		defer o.mutex.RUnlock()
+		state := o.scanStates[scanID]
+		if state == nil || state.coverage == nil {
+			return nil, ErrCoverageMissing
+		}
+		return &types.CoverageReport{
+			ScanID:          scanID,
+			Status:          scan.Status,
+			Total:           len(state.coverage.targets),
+			CoverageSummary: *state.coverage.summary(),
+			Uncovered:       state.coverage.uncovered(),
+		}, nil
+	}
+	o.mutex.RUnlock()
+
+	record, err := o.GetScanRecord(scanID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Coverage == nil {
+		return nil, ErrCoverageMissing
+	}
+	total := 0
+	for _, count := range record.Coverage.Counts {
+		total += count
+	}
+	return &types.CoverageReport{
+		ScanID:          scanID,
+		Status:          record.Status,
+		Total:           total,
+		CoverageSummary: *record.Coverage,
+		Uncovered:       append([]types.UncoveredTarget{}, record.Uncovered...),
+	}, nil
+}
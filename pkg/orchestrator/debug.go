@@ -0,0 +1,65 @@
+package orchestrator
+
+import (
+	"runtime"
+	"sort"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// DebugState counts the scans, queues and buffers the orchestrator holds in
+// memory. WebSocket counts are left to the API, which owns the connections.
+func (o *Orchestrator) DebugState() types.DebugState {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	state := types.DebugState{
+		Goroutines:        runtime.NumGoroutine(),
+		HeapAllocBytes:    memory.HeapAlloc,
+		HeapObjects:       memory.HeapObjects,
+		TicketQueue:       len(o.ticketQueue),
+		ProviderRateLimit: o.providerRateLimit(),
+	}
+	if o.forwarder != nil {
+		state.ForwardQueue = o.forwarder.Health().Queued
+	}
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	state.ActiveScans = len(o.activeScans)
+	state.WorkerTokens = len(o.workerTokens)
+	state.Scans = make([]types.ScanDebugState, 0, len(o.activeScans))
+	for scanID, scan := range o.activeScans {
+		if !scanEnded(scan.Status) {
+			state.RunningScans++
+		}
+		scanState := types.ScanDebugState{
+			ID:            scanID,
+			Status:        scan.Status,
+			Workers:       len(scan.ActiveDroplets),
+			RecentResults: len(scan.Results),
+			ProblemHosts:  len(scan.ProblemHosts),
+		}
+		for _, worker := range scan.ActiveDroplets {
+			scanState.WorkerLogs += len(worker.Logs)
+			if worker.Degraded != "" {
+				scanState.DegradedWorkers++
+			}
+		}
+		if internal := o.scanStates[scanID]; internal != nil {
+			scanState.Goroutines = len(internal.notifiers)
+			scanState.ResultKeys = len(internal.resultKeys)
+			for _, targets := range internal.pendingTargets {
+				scanState.PendingTargets += len(targets)
+			}
+			if scan.Plan != nil && !scanEnded(scan.Status) {
+				if waiting := scan.Plan.Droplets - len(scan.ActiveDroplets) - internal.failedWorkers; waiting > 0 {
+					scanState.Goroutines += waiting
+				}
+			}
+		}
+		state.Scans = append(state.Scans, scanState)
+	}
+	sort.Slice(state.Scans, func(i, j int) bool { return state.Scans[i].ResultKeys > state.Scans[j].ResultKeys })
+	return state
+}
@@ -0,0 +1,124 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// normalizeHost reduces a target or result host such as
+// "https://Example.com:8443/path" to its lowercase hostname
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if strings.Contains(host, "://") {
+		if parsed, err := url.Parse(host); err == nil {
+			return parsed.Hostname()
+		}
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[:i], ":") {
+		host = host[:i]
+	}
+	return host
+}
+
+// hostSet returns the normalized hosts of a target list
+func hostSet(targets []string) map[string]bool {
+	set := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if host := normalizeHost(target); host != "" {
+			set[host] = true
+		}
+	}
+	return set
+}
+
+// DiffScans compares the findings of head against base. Findings match on
+// their dedup key, i.e. host, template and matched-at.
+func (o *Orchestrator) DiffScans(baseID, headID string) (*types.ScanDiff, error) {
+	base, err := o.GetScanRecord(baseID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: base scan %s", err, baseID)
+	}
+	head, err := o.GetScanRecord(headID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: head scan %s", err, headID)
+	}
+
+	baseResults, err := o.allResults(baseID)
+	if err != nil {
+		return nil, err
+	}
+	headResults, err := o.allResults(headID)
+	if err != nil {
+		return nil, err
+	}
+	baseHosts := hostSet(base.Targets)
+	headHosts := hostSet(head.Targets)
+
+	diff := &types.ScanDiff{
+		Base:            baseID,
+		Head:            headID,
+		New:             make([]types.ScanResult, 0),
+		Persisting:      make([]types.ScanResult, 0),
+		Fixed:           make([]types.ScanResult, 0),
+		NotRescanned:    make([]types.ScanResult, 0),
+		HostsOnlyInBase: make([]string, 0),
+		HostsOnlyInHead: make([]string, 0),
+	}
+
+	baseKeys := make(map[string]*types.ScanResult, len(baseResults))
+	for i := range baseResults {
+		baseKeys[baseResults[i].DedupKey()] = &baseResults[i]
+	}
+
+	headKeys := make(map[string]bool, len(headResults))
+	for _, result := range headResults {
+		key := result.DedupKey()
+		headKeys[key] = true
+		if base, exists := baseKeys[key]; exists {
+			// Carry the verdict forward until the finding is triaged again
+			if result.Triage == nil && base.Triage != nil {
+				inherited := *base.Triage
+				if inherited.InheritedFrom == "" {
+					inherited.InheritedFrom = baseID
+				}
+				result.Triage = &inherited
+			}
+			diff.Persisting = append(diff.Persisting, result)
+		} else {
+			diff.New = append(diff.New, result)
+		}
+	}
+
+	for _, result := range baseResults {
+		if headKeys[result.DedupKey()] {
+			continue
+		}
+		if len(headHosts) > 0 && !headHosts[normalizeHost(result.Host)] {
+			diff.NotRescanned = append(diff.NotRescanned, result)
+		} else {
+			diff.Fixed = append(diff.Fixed, result)
+		}
+	}
+
+	for host := range baseHosts {
+		if !headHosts[host] {
+			diff.HostsOnlyInBase = append(diff.HostsOnlyInBase, host)
+		}
+	}
+	for host := range headHosts {
+		if !baseHosts[host] {
+			diff.HostsOnlyInHead = append(diff.HostsOnlyInHead, host)
+		}
+	}
+	sort.Strings(diff.HostsOnlyInBase)
+	sort.Strings(diff.HostsOnlyInHead)
+
+	return diff, nil
+}
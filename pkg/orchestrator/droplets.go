@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidScan is wrapped by errors caused by a scan request the
+// orchestrator refuses to run, as opposed to infrastructure failures
+var ErrInvalidScan = errors.New("invalid scan request")
+
+// ErrScanNotFound is returned for scan IDs the orchestrator does not track
+var ErrScanNotFound = errors.New("scan not found")
+
+// dropletSize describes the resources and price of a DigitalOcean size slug
+type dropletSize struct {
+	MemoryMB    int
+	VCPUs       int
+	PriceHourly float64
+}
+
+// dropletSizes lists the size slugs the orchestrator knows how to plan for
+var dropletSizes = map[string]dropletSize{
+	"s-1vcpu-1gb":  {MemoryMB: 1024, VCPUs: 1, PriceHourly: 0.00893},
+	"s-1vcpu-2gb":  {MemoryMB: 2048, VCPUs: 1, PriceHourly: 0.01786},
+	"s-2vcpu-2gb":  {MemoryMB: 2048, VCPUs: 2, PriceHourly: 0.02679},
+	"s-2vcpu-4gb":  {MemoryMB: 4096, VCPUs: 2, PriceHourly: 0.03571},
+	"s-4vcpu-8gb":  {MemoryMB: 8192, VCPUs: 4, PriceHourly: 0.07143},
+	"s-8vcpu-16gb": {MemoryMB: 16384, VCPUs: 8, PriceHourly: 0.14286},
+}
+
+const (
+	// headlessMinMemoryMB is the smallest droplet that runs chromium alongside nuclei
+	headlessMinMemoryMB = 2048
+	// headlessDropletSize is used when a headless scan did not ask for a size
+	headlessDropletSize = "s-2vcpu-2gb"
+)
+
+// DefaultDropletConfig returns the droplet settings used when a scan does not
+// specify its own
+func DefaultDropletConfig() types.DropletConfig {
+	return types.DropletConfig{
+		Region: "nyc3",
+		Size:   "s-1vcpu-1gb",
+		Image:  "ubuntu-20-04-x64",
+	}
+}
+
+// resolveDropletConfig merges the scan's droplet settings over the defaults and
+// makes sure the size can handle the requested scan type
+func resolveDropletConfig(req *types.ScanRequest) (types.DropletConfig, error) {
+	config := DefaultDropletConfig()
+	sizeRequested := false
+
+	if req.DropletConfig != nil {
+		if req.DropletConfig.Region != "" {
+			config.Region = req.DropletConfig.Region
+		}
+		if req.DropletConfig.Size != "" {
+			config.Size = req.DropletConfig.Size
+			sizeRequested = true
+		}
+		if req.DropletConfig.Image != "" {
+			config.Image = req.DropletConfig.Image
+		}
+	}
+
+	if req.Headless {
+		if size, known := dropletSizes[config.Size]; known && size.MemoryMB < headlessMinMemoryMB {
+			if sizeRequested {
+				return config, fmt.Errorf("%w: headless scans need at least %dMB of memory, %s is too small",
+					ErrInvalidScan, headlessMinMemoryMB, config.Size)
+			}
+			config.Size = headlessDropletSize
+		}
+	}
+
+	return config, nil
+}
+
+// workerTag marks every droplet created for a worker. Each also carries its
+// scan's ID as a second tag.
+const workerTag = "nuclei-worker"
+
+// ErrDropletNotFound is returned for droplet IDs that are not workers
+var ErrDropletNotFound = errors.New("worker droplet not found")
+
+// ErrProviderFailed is returned when the compute provider fails a request
+var ErrProviderFailed = errors.New("compute provider request failed")
+
+// sizePrice is the hourly price of a droplet size on the compute provider,
+// zero when unknown
+func (o *Orchestrator) sizePrice(ctx context.Context, size string) float64 {
+	pricer, ok := o.provider.(provider.Pricer)
+	if !ok {
+		return dropletSizes[size].PriceHourly
+	}
+	price, err := pricer.SizePrice(ctx, size)
+	if err != nil {
+		slog.Warn("Failed to price droplet size, estimating no cost", "size", size, "error", err)
+		return 0
+	}
+	return price
+}
+
+// currency is the ISO 4217 code of the compute provider's prices
+func (o *Orchestrator) currency() string {
+	if pricer, ok := o.provider.(provider.Pricer); ok {
+		return pricer.Currency()
+	}
+	return "USD"
+}
+
+// WorkerDroplets lists every droplet tagged as a worker, including those of
+// scans this server has no record of, such as droplets leaked by a crash.
+// When the provider files workers under a project, droplets outside it are
+// marked unassigned.
+func (o *Orchestrator) WorkerDroplets(ctx context.Context) ([]types.WorkerDroplet, error) {
+	droplets, err := o.provider.ListWorkersByTag(ctx, workerTag)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderFailed, err)
+	}
+
+	var project string
+	var members map[string]bool
+	if assigner, ok := o.provider.(provider.ProjectAssigner); ok && assigner.Project() != "" {
+		project = assigner.Project()
+		if members, err = assigner.ProjectWorkers(ctx); err != nil {
+			slog.Warn("Failed to list project members, not reporting membership", "project", project, "error", err)
+			project = ""
+		}
+	}
+
+	poolScans := o.poolWorkerScans()
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	now := time.Now()
+	workers := make([]types.WorkerDroplet, 0, len(droplets))
+	for _, droplet := range droplets {
+		worker := types.WorkerDroplet{
+			ID:          droplet.ID,
+			Name:        droplet.Name,
+			ScanID:      dropletScanID(&droplet),
+			Region:      droplet.Region,
+			Size:        droplet.Size,
+			Status:      droplet.Status,
+			IP:          droplet.IP,
+			PriceHourly: droplet.PriceHourly,
+			Pooled:      droplet.HasTag(poolTag),
+		}
+		if worker.Pooled {
+			worker.ScanID = poolScans[droplet.Name]
+		}
+		if project != "" {
+			if members[droplet.ID] {
+				worker.Project = project
+			} else {
+				worker.Unassigned = true
+			}
+		}
+		_, worker.ScanKnown = o.activeScans[worker.ScanID]
+		if size, known := dropletSizes[droplet.Size]; known && worker.PriceHourly <= 0 {
+			worker.PriceHourly = size.PriceHourly
+		}
+		if !droplet.CreatedAt.IsZero() {
+			worker.CreatedAt = droplet.CreatedAt
+			worker.AgeSeconds = int64(now.Sub(droplet.CreatedAt).Seconds())
+		}
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// DestroyWorkerDroplet destroys a worker droplet. Droplets without the worker
+// tag are never destroyed. When the server tracks the droplet's scan and the
+// worker had not finished, the worker is marked failed.
+func (o *Orchestrator) DestroyWorkerDroplet(ctx context.Context, dropletID string) error {
+	droplet, err := o.provider.GetWorker(ctx, dropletID)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			return ErrDropletNotFound
+		}
+		return fmt.Errorf("%w: %v", ErrProviderFailed, err)
+	}
+	if !droplet.HasTag(workerTag) {
+		return ErrDropletNotFound
+	}
+
+	if err := o.provider.DestroyWorker(ctx, dropletID); err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			return ErrDropletNotFound
+		}
+		return fmt.Errorf("%w: %v", ErrProviderFailed, err)
+	}
+	scanID := dropletScanID(droplet)
+	slog.Info("Destroyed droplet on request", "scan_id", scanID, "worker_id", droplet.Name, "droplet_id", dropletID)
+	// Pool workers are billed to scans only while claimed
+	if scanID != "" && !droplet.HasTag(poolTag) {
+		o.billReapedDroplet(scanID, droplet)
+	}
+
+	o.mutex.RLock()
+	worker := o.findWorker(scanID, droplet.Name)
+	running := worker != nil && worker.Status != "completed" && worker.Status != "failed"
+	o.mutex.RUnlock()
+	if running {
+		o.CompleteWorker(scanID, droplet.Name, "droplet destroyed by an administrator")
+	}
+
+	o.mutex.RLock()
+	if worker := o.findWorker(scanID, droplet.Name); worker != nil {
+		o.workerEvent(scanID, types.WorkerDestroyed, worker)
+	}
+	o.mutex.RUnlock()
+	return nil
+}
+
+// dropletScanID returns the scan a worker droplet was created for, from the
+// tag next to the worker tag. Pool workers were created for none.
+func dropletScanID(droplet *provider.Worker) string {
+	for _, tag := range droplet.Tags {
+		if tag != workerTag && tag != poolTag {
+			return tag
+		}
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"bytes"
+	"log/slog"
+	"sort"
+	"time"
+
+	"nuclei-distributed/pkg/export"
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// emailAttempts is how often a report is sent before it is reported as
+	// failed
+	emailAttempts = 4
+	// emailBackoff is the wait before the first retry, doubled each time
+	emailBackoff = 30 * time.Second
+	// maxEmailAttachment is the largest CSV attached to a report; larger
+	// exports are linked instead
+	maxEmailAttachment = 5 << 20
+	// emailTopFindings is the number of findings listed in a report
+	emailTopFindings = 10
+)
+
+// sendEmailReport emails a finished scan's summary, top findings and CSV
+// export. Like artifact uploads it runs on its own; delivery progress and
+// failures are recorded on the scan.
+func (o *Orchestrator) sendEmailReport(scanID string, summary types.ScanSummary) {
+	o.mutex.RLock()
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil || scan.EmailReport == nil {
+		o.mutex.RUnlock()
+		return
+	}
+	recipients := scan.EmailReport.Recipients
+	report := notify.ScanReport{
+		Summary:     summary,
+		ReportURL:   o.scanURL(scanID, "report.html"),
+		MinSeverity: state.emailMinSeverity,
+	}
+	if scan.CompletedAt != nil {
+		report.Duration = scan.CompletedAt.Sub(scan.CreatedAt)
+	}
+	if scan.Plan != nil {
+		price := scan.Plan.PriceHourly
+		if price == 0 {
+			// Plans made before prices came from the provider
+			price = dropletSizes[scan.Plan.DropletSize].PriceHourly
+		}
+		report.Cost = float64(summary.Workers) * price * report.Duration.Hours()
+		report.Currency = scan.Plan.Currency
+		if report.Currency == "" {
+			report.Currency = "USD"
+		}
+	}
+	o.mutex.RUnlock()
+
+	fail := func(err error) {
+		slog.Error("Failed to email report", "scan_id", scanID, "error", err)
+		o.updateEmailReport(scanID, func(status *types.EmailReport) {
+			status.Status = "failed"
+			status.Error = err.Error()
+		})
+		o.saveLiveRecord(scanID)
+	}
+
+	results, err := o.allResults(scanID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	report.TopFindings = topFindings(results, report.MinSeverity, emailTopFindings)
+
+	var csv bytes.Buffer
+	if err := export.WriteCSV(&csv, results); err != nil {
+		fail(err)
+		return
+	}
+	var attachments []notify.Attachment
+	if csv.Len() <= maxEmailAttachment {
+		attachments = append(attachments, notify.Attachment{
+			Name:        "scan-" + scanID + ".csv",
+			ContentType: "text/csv",
+			Data:        csv.Bytes(),
+		})
+	} else {
+		report.CSVURL = o.scanURL(scanID, "results?format=csv")
+	}
+
+	subject, body, err := notify.ReportEmail(report)
+	if err != nil {
+		fail(err)
+		return
+	}
+	email := notify.Email{To: recipients, Subject: subject, HTML: body, Attachments: attachments}
+
+	backoff := emailBackoff
+	for attempt := 1; ; attempt++ {
+		err := notify.SendEmail(o.notifyConfig().SMTP, email)
+		o.updateEmailReport(scanID, func(status *types.EmailReport) {
+			status.Attempts = attempt
+			status.Attached = len(attachments) > 0
+			if err != nil {
+				status.Error = err.Error()
+				return
+			}
+			sentAt := time.Now().UTC()
+			status.Status = "sent"
+			status.SentAt = &sentAt
+			status.Error = ""
+		})
+		if err == nil {
+			slog.Info("Emailed report", "scan_id", scanID, "recipients", len(recipients))
+			o.saveLiveRecord(scanID)
+			return
+		}
+		if attempt == emailAttempts {
+			fail(err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// updateEmailReport applies update to a scan's email delivery state
+func (o *Orchestrator) updateEmailReport(scanID string, update func(*types.EmailReport)) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if scan, exists := o.activeScans[scanID]; exists && scan.EmailReport != nil {
+		update(scan.EmailReport)
+		o.scanChanged(scan)
+	}
+}
+
+// topFindings returns up to limit findings at or above minSeverity, most
+// severe first. False positives are left out.
+func topFindings(results []types.ScanResult, minSeverity string, limit int) []types.ScanResult {
+	top := make([]types.ScanResult, 0, limit)
+	for _, result := range results {
+		if result.TriageStatus() != types.TriageFalsePositive && notify.MeetsSeverity(result.Severity, minSeverity) {
+			top = append(top, result)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return storage.SeverityRank(top[i].Severity) > storage.SeverityRank(top[j].Severity)
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
@@ -0,0 +1,371 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/gologger/levels"
+	"github.com/projectdiscovery/subfinder/v2/pkg/runner"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/publicsuffix"
+	"nuclei-distributed/pkg/types"
+)
+
+// ScanEnumerating is the status of a scan started with expand while the
+// subdomains of its apex domains are enumerated, before it is planned
+const ScanEnumerating = "enumerating"
+
+const (
+	// DefaultEnumerationMaxTargets is the most subdomains added to a scan
+	DefaultEnumerationMaxTargets = 10000
+	// DefaultEnumerationApexTimeout bounds the enumeration of each apex;
+	// what was found until then is kept
+	DefaultEnumerationApexTimeout = 2 * time.Minute
+	// DefaultEnumerationConcurrency is the number of apexes enumerated at
+	// once
+	DefaultEnumerationConcurrency = 4
+	// maxEnumerationConcurrency keeps the subfinder sources' requests in
+	// check
+	maxEnumerationConcurrency = 64
+	// subfinderSourceTimeout bounds each request to a subfinder source, in
+	// seconds
+	subfinderSourceTimeout = 30
+	// subfinderMaxMinutes is subfinder's own bound on an enumeration, past
+	// any apex timeout; the apex timeout ends it through its context
+	subfinderMaxMinutes = 24 * 60
+)
+
+// newSubfinder sets up the subfinder runner that enumerates the subdomains
+// of scans started with expand, with the API keys of its sources read from
+// providerConfig, or subfinder's default location when it is empty.
+// subfinder's own logging is silenced; what it finds is logged here.
+func newSubfinder(providerConfig string) (*runner.Runner, error) {
+	gologger.DefaultLogger.SetMaxLevel(levels.LevelSilent)
+	return runner.NewRunner(&runner.Options{
+		Threads:            10,
+		Timeout:            subfinderSourceTimeout,
+		MaxEnumerationTime: subfinderMaxMinutes,
+		ProviderConfig:     providerConfig,
+		Silent:             true,
+		DisableUpdateCheck: true,
+	})
+}
+
+// enumerationConfig is the parsed form of types.EnumerationSettings
+type enumerationConfig struct {
+	maxTargets  int
+	apexTimeout time.Duration
+	concurrency int
+}
+
+// parseEnumerationSettings validates enumeration settings and fills in the
+// defaults of those left unset
+func parseEnumerationSettings(settings *types.EnumerationSettings) (enumerationConfig, error) {
+	cfg := enumerationConfig{
+		maxTargets:  settings.MaxTargets,
+		concurrency: settings.Concurrency,
+	}
+	if cfg.maxTargets < 0 || cfg.maxTargets > maxLargeTargetExpansion {
+		return cfg, fmt.Errorf("maxTargets must be between 1 and %d, or 0 for the default", maxLargeTargetExpansion)
+	}
+	if cfg.maxTargets == 0 {
+		cfg.maxTargets = DefaultEnumerationMaxTargets
+	}
+	settings.MaxTargets = cfg.maxTargets
+
+	if cfg.concurrency < 0 || cfg.concurrency > maxEnumerationConcurrency {
+		return cfg, fmt.Errorf("concurrency must be between 1 and %d, or 0 for the default", maxEnumerationConcurrency)
+	}
+	if cfg.concurrency == 0 {
+		cfg.concurrency = DefaultEnumerationConcurrency
+	}
+	settings.Concurrency = cfg.concurrency
+
+	cfg.apexTimeout = DefaultEnumerationApexTimeout
+	if settings.ApexTimeout != "" {
+		parsed, err := time.ParseDuration(settings.ApexTimeout)
+		if err != nil || parsed <= 0 {
+			return cfg, fmt.Errorf("apexTimeout must be a positive duration such as 2m")
+		}
+		cfg.apexTimeout = parsed
+	}
+	settings.ApexTimeout = cfg.apexTimeout.String()
+	return cfg, nil
+}
+
+// scanEnumeration carries what the enumeration of a scan's apexes found into
+// its planning
+type scanEnumeration struct {
+	startedAt  time.Time
+	finishedAt time.Time
+	link       trace.Link // to the request that started the scan
+	summary    *types.EnumerationSummary
+	origins    map[string]string // each subdomain found, to its apex
+}
+
+// discovered returns the targets enumeration added, to the apex each was
+// found under
+func (e *scanEnumeration) discovered(targets []string) map[string]string {
+	discovered := make(map[string]string)
+	for _, target := range targets {
+		if apex, found := e.origins[target]; found {
+			discovered[target] = apex
+		}
+	}
+	return discovered
+}
+
+// apexDomains returns the distinct apex domains among a scan's targets,
+// sorted, bare or as the host of a URL without a path, such as example.com
+// but not www.example.com
+func apexDomains(targets []string) []string {
+	seen := make(map[string]bool)
+	var apexes []string
+	for _, target := range targets {
+		host := hostname(target)
+		if seen[host] || net.ParseIP(host) != nil {
+			continue
+		}
+		if target != host && strings.TrimPrefix(strings.TrimPrefix(target, "https://"), "http://") != host {
+			continue
+		}
+		if apex, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil && apex == host {
+			seen[host] = true
+			apexes = append(apexes, host)
+		}
+	}
+	sort.Strings(apexes)
+	return apexes
+}
+
+// startEnumeration answers a scan started with expand right away, as
+// enumerating, and plans it in the background once the subdomains of its
+// apexes are enumerated. The request is the one submitted, req the one
+// checked; the scan is planned from the submitted one with the subdomains
+// added, so everything is checked again with them.
+func (o *Orchestrator) startEnumeration(ctx context.Context, request types.ScanRequest, req *types.ScanRequest,
+	targets *types.TargetSummary) (*types.ScanPlan, error) {
+	if o.subfinder == nil {
+		return nil, fmt.Errorf("%w: subdomain enumeration is not configured", ErrInvalidScan)
+	}
+	apexes := apexDomains(req.Domains)
+	if len(apexes) == 0 {
+		return nil, fmt.Errorf("%w: expand needs at least one apex domain among the targets, such as example.com", ErrInvalidScan)
+	}
+
+	enumeration := &scanEnumeration{
+		startedAt: time.Now().UTC(),
+		link:      trace.LinkFromContext(ctx),
+		summary:   &types.EnumerationSummary{Apexes: apexes},
+		origins:   make(map[string]string),
+	}
+	plan := &types.ScanPlan{
+		Mode:         req.Mode,
+		Distribution: req.Distribution,
+		Targets:      targets,
+		Warnings: []string{fmt.Sprintf("droplets are planned once the subdomains of %d apex domains are enumerated",
+			len(apexes))},
+	}
+	log := o.scanLogs.start(req.ID)
+
+	// Enumerating scans have no plan or workers yet, so targets cannot be
+	// added to them
+	o.mutex.Lock()
+	o.activeScans[req.ID] = &types.ScanStatus{
+		ID:             req.ID,
+		CreatedAt:      enumeration.startedAt,
+		ActiveDroplets: make([]*types.WorkerStatus, 0),
+		Results:        make([]types.ScanResult, 0),
+		SeverityCounts: make(map[string]int),
+		TotalDomains:   len(req.Domains),
+		DomainsAlive:   len(req.Domains),
+		Status:         ScanEnumerating,
+		RerunOf:        req.RerunOf,
+		Held:           req.HoldUntilRelease,
+		Enumeration:    enumeration.summary,
+	}
+	o.replicateScan(req.ID)
+	state := newScanState()
+	state.targets = req.Domains
+	config := *req
+	config.Domains = nil
+	state.request = &config
+	state.log = log
+	o.scanStates[req.ID] = state
+	record := scanRecord(o.activeScans[req.ID], state)
+	o.mutex.Unlock()
+
+	o.saveScanRecord(record)
+	slog.Info("Enumerating subdomains", "scan_id", req.ID, "apexes", len(apexes))
+
+	known := make(map[string]bool, len(req.Domains))
+	for _, target := range req.Domains {
+		known[hostname(target)] = true
+	}
+	go o.runEnumeration(request, known, enumeration)
+	return plan, nil
+}
+
+// runEnumeration enumerates the subdomains of a scan's apexes, then plans
+// and starts the scan with those found added to its targets
+func (o *Orchestrator) runEnumeration(request types.ScanRequest, known map[string]bool, enumeration *scanEnumeration) {
+	cfg := o.settings().enumeration
+	summary := enumeration.summary
+	found := make([][]string, len(summary.Apexes))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(cfg.concurrency, len(summary.Apexes)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				found[i] = o.enumerateApex(request.ID, summary.Apexes[i], cfg, known, enumeration)
+			}
+		}()
+	}
+	for i := range summary.Apexes {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// Apexes take turns, so each has its subdomains scanned when there are
+	// more than the cap allows
+	var added []string
+	for round := 0; len(added) < cfg.maxTargets; round++ {
+		more := false
+		for i, hosts := range found {
+			if round < len(hosts) && len(added) < cfg.maxTargets {
+				added = append(added, hosts[round])
+				enumeration.origins[hosts[round]] = summary.Apexes[i]
+				more = true
+			}
+		}
+		if !more {
+			break
+		}
+	}
+
+	o.mutex.Lock()
+	summary.Capped = len(added) < summary.Found
+	summary.DurationMs = time.Since(enumeration.startedAt).Milliseconds()
+	enumeration.finishedAt = time.Now()
+	o.mutex.Unlock()
+	slog.Info("Enumerated subdomains", "scan_id", request.ID, "apexes", len(summary.Apexes), "found", summary.Found,
+		"added", len(added), "timed_out", len(summary.TimedOut), "failed", len(summary.Failed),
+		"duration_ms", summary.DurationMs)
+
+	req := request
+	req.Domains = append(append([]string(nil), request.Domains...), added...)
+	if _, err := o.startScan(context.Background(), &req, enumeration, nil); err != nil {
+		o.enumerationFailed(request.ID, err)
+	}
+}
+
+// enumerateApex runs subfinder on an apex and returns the subdomains it
+// found that the scan does not have yet, sorted. The scan's status counts
+// the apex once it is done.
+func (o *Orchestrator) enumerateApex(scanID, apex string, cfg enumerationConfig, known map[string]bool,
+	enumeration *scanEnumeration) []string {
+	hosts, err := o.runSubfinder(apex, cfg.apexTimeout)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	switch {
+	case timedOut:
+		slog.Warn("Subdomain enumeration timed out", "scan_id", scanID, "apex", apex, "timeout", cfg.apexTimeout,
+			"found", len(hosts))
+	case err != nil:
+		slog.Warn("Subdomain enumeration failed", "scan_id", scanID, "apex", apex, "error", err)
+	}
+
+	var fresh []string
+	for _, host := range hosts {
+		if !known[host] {
+			fresh = append(fresh, host)
+		}
+	}
+	sort.Strings(fresh)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	summary := enumeration.summary
+	summary.Completed++
+	summary.Found += len(fresh)
+	if timedOut {
+		summary.TimedOut = append(summary.TimedOut, apex)
+	} else if err != nil {
+		summary.Failed = append(summary.Failed, apex)
+	}
+	if scan, exists := o.activeScans[scanID]; exists && scan.Status == ScanEnumerating {
+		o.scanChanged(scan)
+	}
+	return fresh
+}
+
+// runSubfinder returns the distinct subdomains subfinder finds for an apex.
+// Those found before it failed or timed out are returned with the error.
+func (o *Orchestrator) runSubfinder(apex string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// subfinder writes what its sources found once they are done, or once
+	// the timeout stops them
+	var output bytes.Buffer
+	err := o.subfinder.EnumerateSingleDomainWithCtx(ctx, apex, []io.Writer{&output})
+
+	seen := make(map[string]bool)
+	var hosts []string
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		host, err := checkHost(strings.TrimSpace(scanner.Text()))
+		if err != nil || seen[host] || !strings.HasSuffix(host, "."+apex) {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	if ctx.Err() != nil {
+		return hosts, ctx.Err()
+	}
+	return hosts, err
+}
+
+// enumerationFailed ends a scan that could not be started once its
+// subdomains were enumerated
+func (o *Orchestrator) enumerationFailed(scanID string, err error) {
+	slog.Error("Failed to start scan after subdomain enumeration", "scan_id", scanID, "error", err)
+	o.phaseFailed(scanID, ScanEnumerating, fmt.Sprintf("the scan could not be started after subdomain enumeration: %v", err))
+}
+
+// phaseFailed ends a scan still in phase, enumerating or preflight, with
+// the reason it could not be started as a warning. Scans that moved on, or
+// were cancelled meanwhile, are left alone.
+func (o *Orchestrator) phaseFailed(scanID, phase, reason string) {
+	o.mutex.Lock()
+	scan, exists := o.activeScans[scanID]
+	if !exists || scan.Status != phase {
+		o.mutex.Unlock()
+		return
+	}
+	completedAt := time.Now().UTC()
+	scan.Status = ScanFailed
+	scan.CompletedAt = &completedAt
+	scan.Held = false
+	scan.Warnings = append(scan.Warnings, reason)
+	o.scanChanged(scan)
+	record := scanRecord(scan, o.scanStates[scanID])
+	o.mutex.Unlock()
+
+	o.saveScanRecord(record)
+}
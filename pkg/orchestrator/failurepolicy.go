@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// DefaultFailurePolicy degrades scans that lost over a fifth of their
+// workers or a twentieth of their targets, and fails those that lost half
+var DefaultFailurePolicy = types.FailurePolicy{
+	DegradedWorkers: 20,
+	FailedWorkers:   50,
+	DegradedTargets: 5,
+	FailedTargets:   50,
+}
+
+// checkFailurePolicy validates the limits of a failure policy
+func checkFailurePolicy(policy types.FailurePolicy) error {
+	limits := []struct {
+		name  string
+		value float64
+	}{
+		{"degradedWorkers", policy.DegradedWorkers},
+		{"failedWorkers", policy.FailedWorkers},
+		{"degradedTargets", policy.DegradedTargets},
+		{"failedTargets", policy.FailedTargets},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 || limit.value > 100 {
+			return fmt.Errorf("%s must be a percentage between 0 and 100", limit.name)
+		}
+	}
+	return nil
+}
+
+// exceeds reports whether count is more than limit percent of total. A zero
+// limit is never exceeded.
+func exceeds(count, total int, limit float64) bool {
+	return limit > 0 && total > 0 && float64(count)*100 > limit*float64(total)
+}
+
+// scanOutcome decides the status a finished scan ends in, from its workers
+// and the coverage of its targets. Scans the policy degrades or fails come
+// with the reasons. Callers must hold o.mutex and have set scan.Coverage.
+func scanOutcome(policy types.FailurePolicy, scan *types.ScanStatus, state *scanState) (string, *types.FailureSummary) {
+	summary := &types.FailureSummary{
+		Workers:       len(scan.ActiveDroplets) + state.failedWorkers,
+		FailedWorkers: state.failedWorkers,
+	}
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status == "failed" {
+			summary.FailedWorkers++
+		}
+	}
+	if scan.Coverage != nil {
+		for _, count := range scan.Coverage.Counts {
+			summary.Targets += count
+		}
+		summary.UncoveredTargets = scan.Coverage.Gap
+	}
+
+	workerShare := fmt.Sprintf("%d of %d workers failed", summary.FailedWorkers, summary.Workers)
+	targetShare := fmt.Sprintf("%d of %d targets were not scanned", summary.UncoveredTargets, summary.Targets)
+	status := ScanCompleted
+	if summary.UncoveredTargets > 0 {
+		status = ScanPartial
+	}
+	switch {
+	case exceeds(summary.FailedWorkers, summary.Workers, policy.FailedWorkers):
+		status = ScanFailed
+		summary.Reasons = append(summary.Reasons, fmt.Sprintf("%s, more than the %g%% limit for failing a scan", workerShare, policy.FailedWorkers))
+	case exceeds(summary.FailedWorkers, summary.Workers, policy.DegradedWorkers):
+		status = ScanDegraded
+		summary.Reasons = append(summary.Reasons, fmt.Sprintf("%s, more than the %g%% limit for degrading a scan", workerShare, policy.DegradedWorkers))
+	}
+	switch {
+	case exceeds(summary.UncoveredTargets, summary.Targets, policy.FailedTargets):
+		status = ScanFailed
+		summary.Reasons = append(summary.Reasons, fmt.Sprintf("%s, more than the %g%% limit for failing a scan", targetShare, policy.FailedTargets))
+	case exceeds(summary.UncoveredTargets, summary.Targets, policy.DegradedTargets):
+		if status != ScanFailed {
+			status = ScanDegraded
+		}
+		summary.Reasons = append(summary.Reasons, fmt.Sprintf("%s, more than the %g%% limit for degrading a scan", targetShare, policy.DegradedTargets))
+	}
+
+	if len(summary.Reasons) == 0 {
+		return status, nil
+	}
+	return status, summary
+}
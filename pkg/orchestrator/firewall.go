@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	// firewallCleanupInterval is how long cleanup waits for a scan's
+	// droplets to be gone before deleting its firewall again
+	firewallCleanupInterval = 30 * time.Second
+	// firewallCleanupAttempts bounds how often cleanup looks before leaving
+	// the firewall behind
+	firewallCleanupAttempts = 20
+)
+
+// FirewallConfig selects the cloud firewall put in front of each scan's
+// workers, on providers that support one
+type FirewallConfig struct {
+	Enabled bool
+	// AdminCIDRs may reach workers over SSH. Nothing else reaches them.
+	AdminCIDRs []string
+}
+
+// firewallName names a scan's firewall
+func firewallName(scanID string) string {
+	return "nuclei-" + scanID
+}
+
+// protectWorkers puts a scan's workers behind a firewall applied by the scan
+// tag. When that fails the workers run without one, and the scan says so.
+func (o *Orchestrator) protectWorkers(ctx context.Context, scanID string) {
+	firewaller, ok := o.provider.(provider.Firewaller)
+	if !o.firewall.Enabled || !ok {
+		return
+	}
+	if err := firewaller.EnsureFirewall(ctx, firewallName(scanID), scanID, o.firewall.AdminCIDRs); err != nil {
+		slog.Warn("Failed to firewall workers", "scan_id", scanID, "error", err)
+		o.addScanWarning(scanID, "workers run without a firewall: "+err.Error())
+		return
+	}
+	slog.Info("Workers firewalled", "scan_id", scanID, "firewall", firewallName(scanID))
+}
+
+// addScanWarning records a problem the scan runs on despite
+func (o *Orchestrator) addScanWarning(scanID, warning string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		scan.Warnings = append(scan.Warnings, warning)
+		o.scanChanged(scan)
+	}
+}
+
+// removeFirewall deletes a scan's firewall once no droplet carries the scan
+// tag any more, which takes a little while after they are destroyed
+func (o *Orchestrator) removeFirewall(scanID string) {
+	firewaller, ok := o.provider.(provider.Firewaller)
+	if !o.firewall.Enabled || !ok {
+		return
+	}
+	ctx := context.Background()
+	for attempt := 0; attempt < firewallCleanupAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(firewallCleanupInterval)
+		}
+		droplets, err := o.provider.ListWorkersByTag(ctx, scanID)
+		if err != nil || len(droplets) > 0 {
+			continue
+		}
+		if err := firewaller.DeleteFirewall(ctx, firewallName(scanID)); err != nil {
+			slog.Warn("Failed to delete firewall", "scan_id", scanID, "error", err)
+			continue
+		}
+		slog.Info("Deleted firewall", "scan_id", scanID, "firewall", firewallName(scanID))
+		return
+	}
+	slog.Warn("Left firewall behind, droplets of the scan remain", "scan_id", scanID, "firewall", firewallName(scanID))
+}
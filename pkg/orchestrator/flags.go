@@ -0,0 +1,85 @@
+package orchestrator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	intFlagValue  = regexp.MustCompile(`^[0-9]{1,6}$`)
+	listFlagValue = regexp.MustCompile(`^[A-Za-z0-9_.:][A-Za-z0-9_.:,-]{0,255}$`)
+)
+
+// allowedNucleiFlags maps the nuclei flags a scan may pass through to the
+// pattern their value must match. A nil pattern marks a boolean flag that
+// takes no value. Patterns never admit whitespace, quotes, or shell
+// metacharacters since the flags end up in the worker's bash script.
+var allowedNucleiFlags = map[string]*regexp.Regexp{
+	"dast":                  nil,
+	"fuzz":                  nil,
+	"follow-redirects":      nil,
+	"follow-host-redirects": nil,
+	"disable-redirects":     nil,
+	"disable-clustering":    nil,
+	"stop-at-first-match":   nil,
+	"no-httpx":              nil,
+	"timeout":               intFlagValue,
+	"retries":               intFlagValue,
+	"max-host-error":        intFlagValue,
+	"max-redirects":         intFlagValue,
+	"concurrency":           intFlagValue,
+	"bulk-size":             intFlagValue,
+	"tags":                  listFlagValue,
+	"include-tags":          listFlagValue,
+	"exclude-tags":          listFlagValue,
+	"template-id":           listFlagValue,
+	"exclude-id":            listFlagValue,
+	"author":                listFlagValue,
+	"scan-strategy":         regexp.MustCompile(`^(auto|host-spray|template-spray)$`),
+	"fuzzing-type":          regexp.MustCompile(`^(replace|prefix|postfix|infix)$`),
+	"fuzzing-mode":          regexp.MustCompile(`^(multiple|single)$`),
+}
+
+// validateExtraFlags checks each flag against allowedNucleiFlags and returns
+// them normalized to -name or -name=value. Entries may be written as
+// "-name", "--name", "-name=value" or "-name value".
+func validateExtraFlags(flags []string) ([]string, error) {
+	normalized := make([]string, 0, len(flags))
+
+	for _, flag := range flags {
+		flag = strings.TrimSpace(flag)
+		if !strings.HasPrefix(flag, "-") {
+			return nil, fmt.Errorf("%w: extra flag %q must start with -", ErrInvalidScan, flag)
+		}
+
+		name := strings.TrimLeft(flag, "-")
+		value := ""
+		hasValue := false
+		if i := strings.IndexAny(name, "= \t"); i >= 0 {
+			value = strings.TrimSpace(name[i+1:])
+			name = name[:i]
+			hasValue = true
+		}
+
+		pattern, allowed := allowedNucleiFlags[name]
+		if !allowed {
+			return nil, fmt.Errorf("%w: nuclei flag -%s is not allowed", ErrInvalidScan, name)
+		}
+
+		if pattern == nil {
+			if hasValue {
+				return nil, fmt.Errorf("%w: nuclei flag -%s does not take a value", ErrInvalidScan, name)
+			}
+			normalized = append(normalized, "-"+name)
+			continue
+		}
+
+		if !pattern.MatchString(value) {
+			return nil, fmt.Errorf("%w: invalid value %q for nuclei flag -%s", ErrInvalidScan, value, name)
+		}
+		normalized = append(normalized, fmt.Sprintf("-%s=%s", name, value))
+	}
+
+	return normalized, nil
+}
@@ -0,0 +1,283 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidRule is returned for false-positive rules that cannot match
+var ErrInvalidRule = errors.New("invalid rule")
+
+// ErrRuleNotFound is returned when no false-positive rule has a given ID
+var ErrRuleNotFound = errors.New("rule not found")
+
+// fpRule is a stored rule with its host pattern compiled
+type fpRule struct {
+	types.FPRule
+	host *regexp.Regexp // nil matches every host
+}
+
+// compileRule validates a rule and compiles its host pattern. Globs match
+// the whole host case-insensitively, with * matching any run of characters;
+// regular expressions are used as written.
+func compileRule(rule types.FPRule) (*fpRule, error) {
+	if rule.Template == "" {
+		return nil, fmt.Errorf("%w: template is required", ErrInvalidRule)
+	}
+	if len(rule.Note) > maxTriageNote {
+		return nil, fmt.Errorf("%w: note longer than %d bytes", ErrInvalidRule, maxTriageNote)
+	}
+
+	compiled := &fpRule{FPRule: rule}
+	if rule.HostPattern == "" {
+		return compiled, nil
+	}
+
+	var err error
+	switch rule.PatternType {
+	case "", types.PatternGlob:
+		compiled.host, err = regexp.Compile(globPattern(rule.HostPattern))
+	case types.PatternRegex:
+		compiled.host, err = regexp.Compile(rule.HostPattern)
+	default:
+		return nil, fmt.Errorf("%w: unknown pattern type %q", ErrInvalidRule, rule.PatternType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	return compiled, nil
+}
+
+// globPattern turns a glob into an anchored, case-insensitive expression
+func globPattern(glob string) string {
+	var pattern strings.Builder
+	pattern.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern.WriteString("$")
+	return pattern.String()
+}
+
+// matches reports whether the rule applies to a finding. The host pattern is
+// tried against the finding's host and, for URLs, its hostname, so
+// "*.example.com" matches "https://api.example.com" as well.
+func (r *fpRule) matches(result *types.ScanResult) bool {
+	if r.Template != result.Template {
+		return false
+	}
+	if r.host == nil || r.host.MatchString(result.Host) {
+		return true
+	}
+	if parsed, err := url.Parse(result.Host); err == nil && parsed.Hostname() != "" {
+		return r.host.MatchString(parsed.Hostname())
+	}
+	return false
+}
+
+// loadRules reads the rules from storage the first time they are needed.
+// Callers must hold o.rulesMutex.
+func (o *Orchestrator) loadRules() error {
+	if o.fpRules != nil {
+		return nil
+	}
+
+	stored, err := o.store.Rules()
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]*fpRule, len(stored))
+	for _, rule := range stored {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			slog.Warn("Skipping stored false-positive rule", "rule_id", rule.ID, "error", err)
+			continue
+		}
+		rules[rule.ID] = compiled
+	}
+	o.fpRules = rules
+	return nil
+}
+
+// ListRules returns every false-positive rule, oldest first
+func (o *Orchestrator) ListRules() ([]types.FPRule, error) {
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	if err := o.loadRules(); err != nil {
+		return nil, err
+	}
+	rules := make([]types.FPRule, 0, len(o.fpRules))
+	for _, rule := range o.fpRules {
+		rules = append(rules, rule.FPRule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+	return rules, nil
+}
+
+// GetRule returns one false-positive rule
+func (o *Orchestrator) GetRule(ruleID string) (*types.FPRule, error) {
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	if err := o.loadRules(); err != nil {
+		return nil, err
+	}
+	rule, exists := o.fpRules[ruleID]
+	if !exists {
+		return nil, ErrRuleNotFound
+	}
+	copied := rule.FPRule
+	return &copied, nil
+}
+
+// CreateRule stores a new false-positive rule. It applies to findings
+// received from then on; stored findings keep their triage.
+func (o *Orchestrator) CreateRule(rule types.FPRule) (*types.FPRule, error) {
+	now := time.Now().UTC()
+	rule.ID = uuid.New().String()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	rule.Hits = 0
+	rule.LastHitAt = nil
+
+	return o.putRule(rule, false)
+}
+
+// UpdateRule replaces a rule's template, pattern and note, keeping its hit
+// count
+func (o *Orchestrator) UpdateRule(ruleID string, update types.FPRule) (*types.FPRule, error) {
+	o.rulesMutex.Lock()
+	if err := o.loadRules(); err != nil {
+		o.rulesMutex.Unlock()
+		return nil, err
+	}
+	existing, exists := o.fpRules[ruleID]
+	if !exists {
+		o.rulesMutex.Unlock()
+		return nil, ErrRuleNotFound
+	}
+	rule := existing.FPRule
+	o.rulesMutex.Unlock()
+
+	rule.Template = update.Template
+	rule.HostPattern = update.HostPattern
+	rule.PatternType = update.PatternType
+	rule.Note = update.Note
+	rule.UpdatedAt = time.Now().UTC()
+
+	return o.putRule(rule, true)
+}
+
+// putRule validates and saves a rule. With mustExist set the rule is only
+// saved if it was not deleted meanwhile, and its hit count is kept current.
+func (o *Orchestrator) putRule(rule types.FPRule, mustExist bool) (*types.FPRule, error) {
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	if err := o.loadRules(); err != nil {
+		return nil, err
+	}
+	if existing, exists := o.fpRules[rule.ID]; exists {
+		compiled.Hits = existing.Hits
+		compiled.LastHitAt = existing.LastHitAt
+	} else if mustExist {
+		return nil, ErrRuleNotFound
+	}
+
+	if err := o.store.SaveRule(compiled.FPRule); err != nil {
+		return nil, err
+	}
+	o.fpRules[rule.ID] = compiled
+
+	saved := compiled.FPRule
+	return &saved, nil
+}
+
+// DeleteRule removes a false-positive rule. Findings it already marked keep
+// their triage.
+func (o *Orchestrator) DeleteRule(ruleID string) error {
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	if err := o.loadRules(); err != nil {
+		return err
+	}
+	if _, exists := o.fpRules[ruleID]; !exists {
+		return ErrRuleNotFound
+	}
+	if err := o.store.DeleteRule(ruleID); err != nil && !errors.Is(err, storage.ErrRuleNotFound) {
+		return err
+	}
+	delete(o.fpRules, ruleID)
+	return nil
+}
+
+// matchRule returns the triage a false-positive rule gives a finding, or nil
+// when no rule matches. The oldest matching rule wins.
+func (o *Orchestrator) matchRule(result *types.ScanResult) *types.Triage {
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	if err := o.loadRules(); err != nil {
+		// Findings are stored untriaged rather than refused
+		slog.Error("Failed to load false-positive rules", "error", err)
+		return nil
+	}
+
+	var matched *fpRule
+	for _, rule := range o.fpRules {
+		if rule.matches(result) && (matched == nil || rule.CreatedAt.Before(matched.CreatedAt)) {
+			matched = rule
+		}
+	}
+	if matched == nil {
+		return nil
+	}
+
+	return &types.Triage{
+		Status:    types.TriageFalsePositive,
+		Note:      matched.Note,
+		UpdatedAt: time.Now().UTC(),
+		Rule:      matched.ID,
+	}
+}
+
+// recordRuleHit counts a stored finding against the rule that triaged it, so
+// rules that stopped matching can be found and removed
+func (o *Orchestrator) recordRuleHit(ruleID string, at time.Time) {
+	o.rulesMutex.Lock()
+	defer o.rulesMutex.Unlock()
+
+	rule, exists := o.fpRules[ruleID]
+	if !exists {
+		return
+	}
+	rule.Hits++
+	rule.LastHitAt = &at
+	if err := o.store.SaveRule(rule.FPRule); err != nil {
+		slog.Error("Failed to record hit on false-positive rule", "rule_id", ruleID, "error", err)
+	}
+}
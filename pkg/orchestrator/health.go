@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// redisCheckTimeout bounds the Redis ping made for every health check
+	redisCheckTimeout = 2 * time.Second
+	// providerCheckInterval is how often the compute provider is checked;
+	// health requests report the last outcome rather than calling the API
+	providerCheckInterval = 5 * time.Minute
+	providerCheckTimeout  = 10 * time.Second
+	// restoreRetry is how long to wait before loading state from storage
+	// again after it failed
+	restoreRetry = 5 * time.Second
+)
+
+// healthState holds the results of checks made in the background
+type healthState struct {
+	mutex     sync.RWMutex
+	provider  types.DependencyHealth
+	restored  bool             // rules and blocklist were loaded from storage
+	selfCheck *types.SelfCheck // nil until the first self-check
+}
+
+// Health checks the services the server depends on. Redis is pinged on
+// every call; the provider check is the last one made in the background.
+func (o *Orchestrator) Health(ctx context.Context) types.HealthReport {
+	o.health.mutex.RLock()
+	provider := o.health.provider
+	ready := o.health.restored
+	selfCheck := o.health.selfCheck
+	o.health.mutex.RUnlock()
+	provider.RateLimit = o.providerRateLimit()
+
+	report := types.HealthReport{
+		Status: "healthy",
+		Ready:  ready,
+		Dependencies: map[string]types.DependencyHealth{
+			"redis":        o.checkRedis(ctx),
+			"digitalocean": provider,
+		},
+		OpenSearch: o.ForwarderHealth(),
+		Retention:  o.RetentionHealth(),
+		SelfCheck:  selfCheck,
+		Replica:    o.ReplicaHealth(),
+	}
+	for _, dependency := range report.Dependencies {
+		if dependency.Critical && dependency.Status == types.DependencyFailing {
+			report.Status = "unhealthy"
+		}
+	}
+	return report
+}
+
+// checkRedis pings Redis with a short timeout
+func (o *Orchestrator) checkRedis(ctx context.Context) types.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, redisCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := o.redis.Ping(ctx).Err()
+	return dependencyHealth(start, err)
+}
+
+// checkProvider lists the compute provider's regions, an authenticated call
+// that fails once its credentials are revoked
+func (o *Orchestrator) checkProvider() {
+	ctx, cancel := context.WithTimeout(context.Background(), providerCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := o.provider.Regions(ctx)
+	health := dependencyHealth(start, err)
+	if err != nil {
+		slog.Warn("Compute provider health check failed", "error", err)
+	}
+
+	o.health.mutex.Lock()
+	o.health.provider = health
+	o.health.mutex.Unlock()
+}
+
+// providerRateLimit is the compute provider's API request budget, nil when
+// it does not pace its requests
+func (o *Orchestrator) providerRateLimit() *types.RateLimitState {
+	limited, ok := o.provider.(provider.RateLimited)
+	if !ok {
+		return nil
+	}
+	limit := limited.RateLimit()
+	state := &types.RateLimitState{
+		Limit:     limit.Limit,
+		Remaining: limit.Remaining,
+		Throttled: limit.Throttled,
+		Retried:   limit.Retried,
+	}
+	if !limit.Reset.IsZero() {
+		reset := limit.Reset.UTC()
+		state.Reset = &reset
+	}
+	if !limit.UpdatedAt.IsZero() {
+		updatedAt := limit.UpdatedAt.UTC()
+		state.UpdatedAt = &updatedAt
+	}
+	return state
+}
+
+func dependencyHealth(start time.Time, err error) types.DependencyHealth {
+	checkedAt := time.Now().UTC()
+	health := types.DependencyHealth{
+		Status:    types.DependencyOK,
+		Critical:  true,
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: &checkedAt,
+	}
+	if err != nil {
+		health.Status = types.DependencyFailing
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// runHealthChecks checks the compute provider now and then every
+// providerCheckInterval, repeating the self-check while it keeps the server
+// read-only
+func (o *Orchestrator) runHealthChecks() {
+	ticker := time.NewTicker(providerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		o.checkProvider()
+		if o.readOnly() {
+			o.SelfCheck(context.Background())
+		}
+		<-ticker.C
+	}
+}
+
+// restoreState loads the false-positive rules and blocklist kept in storage,
+// retrying until storage answers. The server is ready once they are loaded,
+// so no scan or finding is checked against a partial set.
+func (o *Orchestrator) restoreState() {
+	for {
+		o.configMutex.Lock()
+		err := o.loadConfigOverrides()
+		o.configMutex.Unlock()
+		if err == nil {
+			o.rulesMutex.Lock()
+			err = o.loadRules()
+			o.rulesMutex.Unlock()
+		}
+		if err == nil {
+			o.blocklistMutex.Lock()
+			err = o.loadBlocklist()
+			o.blocklistMutex.Unlock()
+		}
+		if err == nil {
+			break
+		}
+		slog.Error("Failed to restore state from storage, retrying", "error", err)
+		o.alerts.storageFailed("restore state", err)
+		time.Sleep(restoreRetry)
+	}
+
+	o.health.mutex.Lock()
+	o.health.restored = true
+	o.health.mutex.Unlock()
+	slog.Info("State restored from storage")
+}
+
+// Ready reports whether the server can take traffic: state was restored
+// from storage and no critical dependency is failing
+func (o *Orchestrator) Ready(ctx context.Context) (bool, types.HealthReport) {
+	report := o.Health(ctx)
+	return report.Ready && report.Status == "healthy", report
+}
@@ -0,0 +1,132 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrIdempotencyMismatch is returned when an idempotency key is reused for a
+// different request
+var ErrIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+
+// ErrIdempotencyInProgress is returned when the request that claimed an
+// idempotency key is still being handled
+var ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+
+const (
+	// idempotencyTTL is how long an answered request is replayed for
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyClaimTTL bounds how long a claim outlives a server that died
+	// while handling the request
+	idempotencyClaimTTL = 2 * time.Minute
+	// idempotencyWait is how long a concurrent duplicate waits for the first
+	// request's response before giving up
+	idempotencyWait = 10 * time.Second
+	idempotencyPoll = 100 * time.Millisecond
+)
+
+// IdempotentResponse is a response replayed for requests that repeat an
+// idempotency key
+type IdempotentResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"body"`
+}
+
+// idempotencyRecord is stored under a key while its request is handled, and
+// holds the response once it was answered
+type idempotencyRecord struct {
+	Fingerprint string              `json:"fingerprint"`
+	Response    *IdempotentResponse `json:"response,omitempty"`
+}
+
+// idempotencyRedisKey scopes the client's key to the API key that sent it,
+// so clients cannot replay or block each other's requests, and hashes it,
+// since it may hold any characters
+func idempotencyRedisKey(keyID, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "idempotency:" + keyID + ":" + hex.EncodeToString(sum[:])
+}
+
+// ClaimIdempotencyKey claims key, as sent with the API key identified by
+// keyID, for a request identified by fingerprint. It
+// returns nil when the caller should handle the request and then call
+// FinishIdempotencyKey, or the response to replay when the same request was
+// already answered. A duplicate that arrives while the first is handled
+// waits for its response.
+func (o *Orchestrator) ClaimIdempotencyKey(ctx context.Context, keyID, key, fingerprint string) (*IdempotentResponse, error) {
+	redisKey := idempotencyRedisKey(keyID, key)
+	claim, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(idempotencyWait)
+	for {
+		claimed, err := o.redis.SetNX(ctx, redisKey, claim, idempotencyClaimTTL).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim idempotency key: %v", err)
+		}
+		if claimed {
+			return nil, nil
+		}
+
+		data, err := o.redis.Get(ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// Released or expired since, claim it again
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read idempotency key: %v", err)
+		}
+		var record idempotencyRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to decode idempotency key: %v", err)
+		}
+		if record.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyMismatch
+		}
+		if record.Response != nil {
+			return record.Response, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrIdempotencyInProgress
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idempotencyPoll):
+		}
+	}
+}
+
+// FinishIdempotencyKey stores the response to replay for a claimed key. A nil
+// response releases the key instead, so the request can be retried.
+func (o *Orchestrator) FinishIdempotencyKey(keyID, key, fingerprint string, response *IdempotentResponse) {
+	ctx := context.Background()
+	redisKey := idempotencyRedisKey(keyID, key)
+
+	if response == nil {
+		if err := o.redis.Del(ctx, redisKey).Err(); err != nil {
+			slog.Warn("Failed to release idempotency key", "error", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint, Response: response})
+	if err == nil {
+		err = o.redis.Set(ctx, redisKey, data, idempotencyTTL).Err()
+	}
+	if err != nil {
+		slog.Warn("Failed to store idempotent response", "error", err)
+	}
+}
@@ -0,0 +1,89 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	// listingTTL is how long a listing of a tag's workers answers lookups.
+	// Workers booting are polled every ten seconds, so each poll round of a
+	// scan costs one list call however many workers it has.
+	listingTTL = 5 * time.Second
+	// listingIdle is how long an unused listing is kept
+	listingIdle = 10 * time.Minute
+)
+
+// workerLister shares one provider listing of the workers carrying a tag,
+// such as a scan's ID, between the loops watching them, instead of each
+// asking the provider for its own worker
+type workerLister struct {
+	mutex    sync.Mutex
+	listings map[string]*workerListing
+}
+
+// workerListing is the last listing of a tag's workers
+type workerListing struct {
+	mutex    sync.Mutex // held while listing, so concurrent lookups wait for one call
+	listedAt time.Time
+	usedAt   time.Time
+	workers  map[string]provider.Worker
+	err      error
+}
+
+// lookupWorker returns a worker from the listing of the workers carrying
+// tag, listing them again once the listing is older than listingTTL. A
+// worker missing from the listing, which may lag behind its creation or be
+// gone, is asked for by ID.
+func (o *Orchestrator) lookupWorker(ctx context.Context, tag, id string) (*provider.Worker, error) {
+	listing := o.lister.listing(tag)
+
+	listing.mutex.Lock()
+	if time.Since(listing.listedAt) >= listingTTL {
+		workers, err := o.provider.ListWorkersByTag(ctx, tag)
+		listing.listedAt = time.Now()
+		listing.err = err
+		listing.workers = make(map[string]provider.Worker, len(workers))
+		for _, worker := range workers {
+			listing.workers[worker.ID] = worker
+		}
+	}
+	worker, found := listing.workers[id]
+	err := listing.err
+	listing.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &worker, nil
+	}
+	return o.provider.GetWorker(ctx, id)
+}
+
+// listing returns the listing of tag, creating it, and drops listings
+// nobody used for listingIdle
+func (l *workerLister) listing(tag string) *workerListing {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if l.listings == nil {
+		l.listings = make(map[string]*workerListing)
+	}
+	for key, listing := range l.listings {
+		if now.Sub(listing.usedAt) > listingIdle {
+			delete(l.listings, key)
+		}
+	}
+	listing, exists := l.listings[tag]
+	if !exists {
+		listing = &workerListing{}
+		l.listings[tag] = listing
+	}
+	listing.usedAt = now
+	return listing
+}
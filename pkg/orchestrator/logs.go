@@ -0,0 +1,199 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// maxWorkerLogs is the number of log lines kept in memory per worker before
+// older lines are spilled to Redis
+const maxWorkerLogs = 500
+
+// skippedHostPattern extracts the host from nuclei's max-host-error message
+var skippedHostPattern = regexp.MustCompile(`Skipped (\S+) from target list`)
+
+func workerLogsKey(scanID, workerID string) string {
+	return fmt.Sprintf("scan:%s:worker:%s:logs", scanID, workerID)
+}
+
+// findWorker returns the worker with the given ID. Callers must hold o.mutex.
+func (o *Orchestrator) findWorker(scanID, workerID string) *types.WorkerStatus {
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return nil
+	}
+
+	for _, worker := range scan.ActiveDroplets {
+		if worker.ID == workerID {
+			return worker
+		}
+	}
+
+	return nil
+}
+
+// AddWorkerLogs appends log entries to a worker's in-memory buffer. Once the
+// buffer exceeds maxWorkerLogs the oldest lines are moved to Redis. Logs for
+// workers that have not registered yet go straight to Redis. Restart entries
+// also bump the worker's RestartCount, skipped entries add the host to the
+// scan's problem hosts and error entries go to the scan's log.
+func (o *Orchestrator) AddWorkerLogs(scanID, workerID string, logs []types.Log) {
+	var spill []types.Log
+
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		o.scanChanged(scan)
+		for _, entry := range logs {
+			if entry.Type == "skipped" {
+				if host := addProblemHost(scan, workerID, entry); host != "" {
+					if state := o.scanStates[scanID]; state != nil {
+						state.coverage.skipHost(host)
+					}
+				}
+			}
+		}
+	}
+	o.addWorkerErrors(scanID, workerID, logs)
+	if worker := o.findWorker(scanID, workerID); worker != nil {
+		for _, entry := range logs {
+			if entry.Type == "restart" {
+				worker.RestartCount++
+			}
+		}
+		if o.workerSeen(scanID, worker) {
+			o.workerEvent(scanID, types.WorkerProgress, worker)
+		}
+		worker.Logs = append(worker.Logs, logs...)
+		if overflow := len(worker.Logs) - maxWorkerLogs; overflow > 0 {
+			spill = append(spill, worker.Logs[:overflow]...)
+			worker.Logs = append([]types.Log(nil), worker.Logs[overflow:]...)
+		}
+	} else {
+		spill = logs
+	}
+	o.mutex.Unlock()
+
+	if len(spill) > 0 {
+		o.spillWorkerLogs(scanID, workerID, spill)
+	}
+}
+
+// addProblemHost records a host nuclei skipped, once per host, and returns
+// it when it is new. Callers must hold o.mutex.
+func addProblemHost(scan *types.ScanStatus, workerID string, entry types.Log) string {
+	host := entry.Message
+	if match := skippedHostPattern.FindStringSubmatch(entry.Message); match != nil {
+		host = match[1]
+	}
+
+	for _, existing := range scan.ProblemHosts {
+		if existing.Host == host {
+			return ""
+		}
+	}
+
+	scan.ProblemHosts = append(scan.ProblemHosts, types.ProblemHost{
+		Host:      host,
+		WorkerID:  workerID,
+		Reason:    entry.Message,
+		Timestamp: entry.Timestamp,
+	})
+	return host
+}
+
+// GetProblemHosts returns the hosts nuclei gave up on during a scan
+func (o *Orchestrator) GetProblemHosts(scanID string) ([]types.ProblemHost, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return nil, ErrScanNotFound
+	}
+
+	return append([]types.ProblemHost{}, scan.ProblemHosts...), nil
+}
+
+func (o *Orchestrator) spillWorkerLogs(scanID, workerID string, logs []types.Log) {
+	values := make([]interface{}, 0, len(logs))
+	for _, entry := range logs {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		values = append(values, data)
+	}
+
+	if err := o.redis.RPush(context.Background(), workerLogsKey(scanID, workerID), values...).Err(); err != nil {
+		slog.Error("Failed to spill worker logs", "scan_id", scanID, "worker_id", workerID, "count", len(values), "error", err)
+	}
+}
+
+// GetWorkerLogs returns a page of a worker's logs, oldest first. Lines spilled
+// to Redis come before the lines still held in memory.
+func (o *Orchestrator) GetWorkerLogs(scanID, workerID string, offset, limit int) (*types.WorkerLogPage, error) {
+	o.mutex.RLock()
+	if _, exists := o.activeScans[scanID]; !exists {
+		o.mutex.RUnlock()
+		return nil, ErrScanNotFound
+	}
+	var memory []types.Log
+	if worker := o.findWorker(scanID, workerID); worker != nil {
+		memory = append(memory, worker.Logs...)
+	}
+	o.mutex.RUnlock()
+
+	ctx := context.Background()
+	key := workerLogsKey(scanID, workerID)
+
+	spilled, err := o.redis.LLen(ctx, key).Result()
+	if err != nil {
+		slog.Error("Failed to read spilled worker logs", "scan_id", scanID, "worker_id", workerID, "error", err)
+		spilled = 0
+	}
+
+	page := &types.WorkerLogPage{
+		WorkerID: workerID,
+		Logs:     make([]types.Log, 0),
+		Total:    int(spilled) + len(memory),
+		Offset:   offset,
+		Limit:    limit,
+	}
+
+	end := offset + limit
+	if end > page.Total {
+		end = page.Total
+	}
+
+	// Read the part of the page that lives in Redis
+	if offset < int(spilled) {
+		redisEnd := end
+		if redisEnd > int(spilled) {
+			redisEnd = int(spilled)
+		}
+		entries, err := o.redis.LRange(ctx, key, int64(offset), int64(redisEnd-1)).Result()
+		if err != nil {
+			slog.Error("Failed to read spilled worker logs", "scan_id", scanID, "worker_id", workerID, "error", err)
+		}
+		for _, entry := range entries {
+			var logEntry types.Log
+			if err := json.Unmarshal([]byte(entry), &logEntry); err == nil {
+				page.Logs = append(page.Logs, logEntry)
+			}
+		}
+	}
+
+	// Read the remainder from memory
+	for i := offset; i < end; i++ {
+		if i >= int(spilled) {
+			page.Logs = append(page.Logs, memory[i-int(spilled)])
+		}
+	}
+
+	return page, nil
+}
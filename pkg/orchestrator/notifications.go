@@ -0,0 +1,261 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrForwardingDisabled is returned when no OpenSearch cluster is configured
+var ErrForwardingDisabled = errors.New("OpenSearch forwarding is not configured")
+
+// NotifyConfig holds the server-wide notifier settings scans fall back to
+type NotifyConfig struct {
+	// Webhooks are notified about scans that do not configure their own
+	Webhooks []types.WebhookConfig
+	Slack    types.SlackConfig
+	Discord  types.DiscordConfig
+	// SMTP is the mail server completion reports are sent through
+	SMTP notify.SMTPConfig
+	// PublicURL is the base URL notifications link back to
+	PublicURL string
+}
+
+// notifierNames are the values ScanRequest.Notifiers accepts
+var notifierNames = map[string]bool{"webhook": true, "slack": true, "discord": true, "email": true, "none": true}
+
+// validateNotifications checks the notifier settings on a scan request
+func validateNotifications(req *types.ScanRequest) error {
+	for _, name := range req.Notifiers {
+		if !notifierNames[name] {
+			return fmt.Errorf("%w: unknown notifier %q", ErrInvalidScan, name)
+		}
+	}
+	if len(req.Notifications) > 0 && len(req.Notifiers) > 0 {
+		return fmt.Errorf("%w: notifiers and notifications cannot both be set", ErrInvalidScan)
+	}
+	routed := make(map[string]bool, len(req.Notifications))
+	for _, route := range req.Notifications {
+		if routed[route.Channel] {
+			return fmt.Errorf("%w: channel %q is routed twice", ErrInvalidScan, route.Channel)
+		}
+		routed[route.Channel] = true
+		if route.MinSeverity != "" && !notify.ValidSeverity(route.MinSeverity) {
+			return fmt.Errorf("%w: unknown severity %q for channel %q", ErrInvalidScan, route.MinSeverity, route.Channel)
+		}
+	}
+	for _, hook := range req.Webhooks {
+		if err := notify.ValidateWebhook(hook); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidScan, err)
+		}
+	}
+	if err := notify.ValidateEmails(req.NotifyEmails); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidScan, err)
+	}
+	if req.Slack != nil {
+		if err := notify.ValidateChatWebhook("Slack", req.Slack.WebhookURL, req.Slack.MinSeverity); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidScan, err)
+		}
+	}
+	if req.Discord != nil {
+		if err := notify.ValidateChatWebhook("Discord", req.Discord.WebhookURL, req.Discord.MinSeverity); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidScan, err)
+		}
+	}
+	return nil
+}
+
+// channelConfigured reports whether a notification channel has credentials,
+// from the scan itself or the server defaults
+func (o *Orchestrator) channelConfigured(req *types.ScanRequest, channel string) bool {
+	server := o.notifyConfig()
+	switch channel {
+	case "webhook":
+		return len(req.Webhooks) > 0 || len(server.Webhooks) > 0
+	case "slack":
+		return server.Slack.WebhookURL != "" || (req.Slack != nil && req.Slack.WebhookURL != "")
+	case "discord":
+		return server.Discord.WebhookURL != "" || (req.Discord != nil && req.Discord.WebhookURL != "")
+	case "email":
+		return server.SMTP.Enabled() && (len(req.NotifyEmails) > 0 || len(server.SMTP.Recipients) > 0)
+	}
+	return false
+}
+
+// checkRoutes rejects notification routes to channels that do not exist or
+// have no credentials, listing all of them at once
+func (o *Orchestrator) checkRoutes(req *types.ScanRequest) error {
+	var unknown []string
+	for _, route := range req.Notifications {
+		if !o.channelConfigured(req, route.Channel) {
+			unknown = append(unknown, route.Channel)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: unknown or unconfigured notification channels: %s", ErrInvalidScan, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// notifierRoute reports whether a scan selected a notifier and the lowest
+// severity it asked the notifier for. An empty severity keeps the
+// notifier's own threshold.
+func notifierRoute(req *types.ScanRequest, name string) (selected bool, minSeverity string) {
+	if len(req.Notifications) > 0 {
+		for _, route := range req.Notifications {
+			if route.Channel == name {
+				return true, strings.ToLower(route.MinSeverity)
+			}
+		}
+		return false, ""
+	}
+	if len(req.Notifiers) == 0 {
+		return true, ""
+	}
+	for _, n := range req.Notifiers {
+		if n == name {
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+// scanURL links to a path under a scan's API, e.g. its report
+func (o *Orchestrator) scanURL(scanID, path string) string {
+	return fmt.Sprintf("%s/api/v1/scan/%s/%s", strings.TrimRight(o.settings().Notifications.PublicURL, "/"), scanID, path)
+}
+
+// emailRecipients returns who is sent a scan's completion report: the scan's
+// own list, else the server default. No one is when email is not selected or
+// no mail server is configured.
+func (o *Orchestrator) emailRecipients(req *types.ScanRequest) []string {
+	server := o.notifyConfig()
+	if selected, _ := notifierRoute(req, "email"); !selected || !server.SMTP.Enabled() {
+		return nil
+	}
+	if len(req.NotifyEmails) > 0 {
+		return req.NotifyEmails
+	}
+	return server.SMTP.Recipients
+}
+
+// startNotifiers creates the notifiers a scan selected, merging its settings
+// over the server defaults. Webhooks are also returned on their own so their
+// delivery status can be reported.
+func (o *Orchestrator) startNotifiers(req *types.ScanRequest) ([]notify.Notifier, []*notify.Webhook) {
+	server := o.notifyConfig()
+	reportURL := o.scanURL(req.ID, "report.html")
+	var notifiers []notify.Notifier
+	var webhooks []*notify.Webhook
+
+	if selected, minSeverity := notifierRoute(req, "webhook"); selected {
+		configs := req.Webhooks
+		if len(configs) == 0 {
+			configs = server.Webhooks
+		}
+		for _, config := range configs {
+			if minSeverity != "" {
+				config.MinSeverity = minSeverity
+			}
+			webhook := notify.NewWebhook(config)
+			webhooks = append(webhooks, webhook)
+			notifiers = append(notifiers, webhook)
+		}
+	}
+
+	if selected, minSeverity := notifierRoute(req, "slack"); selected {
+		config := server.Slack
+		if req.Slack != nil {
+			if req.Slack.WebhookURL != "" {
+				config.WebhookURL = req.Slack.WebhookURL
+			}
+			if req.Slack.MinSeverity != "" {
+				config.MinSeverity = req.Slack.MinSeverity
+			}
+		}
+		if minSeverity != "" {
+			config.MinSeverity = minSeverity
+		}
+		if config.WebhookURL != "" {
+			notifiers = append(notifiers, notify.NewSlack(config, reportURL))
+		}
+	}
+
+	if selected, minSeverity := notifierRoute(req, "discord"); selected {
+		config := server.Discord
+		if req.Discord != nil {
+			if req.Discord.WebhookURL != "" {
+				config.WebhookURL = req.Discord.WebhookURL
+			}
+			if req.Discord.MinSeverity != "" {
+				config.MinSeverity = req.Discord.MinSeverity
+			}
+		}
+		if minSeverity != "" {
+			config.MinSeverity = minSeverity
+		}
+		if config.WebhookURL != "" {
+			notifiers = append(notifiers, notify.NewDiscord(config, reportURL))
+		}
+	}
+
+	return notifiers, webhooks
+}
+
+// emitEvent hands an event to the scan's notifiers without blocking. Callers
+// must hold o.mutex.
+func (o *Orchestrator) emitEvent(scanID, eventType string, data interface{}) {
+	state := o.scanStates[scanID]
+	if state == nil {
+		return
+	}
+
+	event := notify.Event{
+		Type:      eventType,
+		ScanID:    scanID,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+	for _, notifier := range state.notifiers {
+		notifier.Notify(event)
+	}
+}
+
+// GetWebhookStatus returns delivery state for each of a scan's webhooks
+func (o *Orchestrator) GetWebhookStatus(scanID string) ([]types.WebhookStatus, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	state, exists := o.scanStates[scanID]
+	if !exists {
+		return nil, ErrScanNotFound
+	}
+
+	statuses := make([]types.WebhookStatus, 0, len(state.webhooks))
+	for _, webhook := range state.webhooks {
+		statuses = append(statuses, webhook.Status())
+	}
+	return statuses, nil
+}
+
+// ForwarderHealth returns the OpenSearch exporter's state, or nil when
+// forwarding is not configured
+func (o *Orchestrator) ForwarderHealth() *types.ForwarderHealth {
+	if o.forwarder == nil {
+		return nil
+	}
+	health := o.forwarder.Health()
+	return &health
+}
+
+// ForwarderDeadLetters returns the findings OpenSearch would not accept
+func (o *Orchestrator) ForwarderDeadLetters() ([]types.DeadLetter, error) {
+	if o.forwarder == nil {
+		return nil, ErrForwardingDisabled
+	}
+	return o.forwarder.DeadLetters(), nil
+}
@@ -0,0 +1,209 @@
+package orchestrator
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"nuclei-distributed/pkg/types"
+)
+
+// maxConfigYAMLBytes caps the size of a per-scan nuclei config file
+const maxConfigYAMLBytes = 64 * 1024
+
+var (
+	headerConfigValue = regexp.MustCompile(`^[A-Za-z0-9-]{1,128}:[^\r\n]{0,1000}$`)
+	varConfigValue    = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}=[^\r\n]{0,1000}$`)
+)
+
+// allowedConfigKeys maps the keys a scan's nuclei config file may set to
+// the pattern their values must match: the pass-through flags of
+// allowedNucleiFlags, the options the request has fields for, and headers
+// and template variables. A nil pattern marks a boolean. Anything else, such
+// as options that write files on the worker or the output the worker script
+// controls itself, is refused.
+var allowedConfigKeys = configKeyPatterns()
+
+func configKeyPatterns() map[string]*regexp.Regexp {
+	keys := maps.Clone(allowedNucleiFlags)
+	maps.Copy(keys, map[string]*regexp.Regexp{
+		"severity":   listFlagValue,
+		"s":          listFlagValue,
+		"rate-limit": intFlagValue,
+		"rl":         intFlagValue,
+		"mhe":        intFlagValue,
+		"headless":   nil,
+		"header":     headerConfigValue,
+		"H":          headerConfigValue,
+		"var":        varConfigValue,
+		"V":          varConfigValue,
+	})
+	return keys
+}
+
+// validConfigValue reports whether a config file value matches the pattern
+// of its key. Lists are accepted for the keys nuclei reads as lists.
+func validConfigValue(pattern *regexp.Regexp, value interface{}) bool {
+	if pattern == nil {
+		_, isBool := value.(bool)
+		return isBool
+	}
+	switch value := value.(type) {
+	case string:
+		return pattern.MatchString(value)
+	case int:
+		return pattern.MatchString(strconv.Itoa(value))
+	case []interface{}:
+		if pattern != listFlagValue && pattern != headerConfigValue && pattern != varConfigValue {
+			return false
+		}
+		for _, item := range value {
+			if !validConfigValue(pattern, item) {
+				return false
+			}
+		}
+		return len(value) > 0
+	}
+	return false
+}
+
+var validSeverities = map[string]bool{
+	"info": true, "low": true, "medium": true, "high": true, "critical": true, "unknown": true,
+}
+
+// validateScanOptions checks the explicit nuclei options on a scan request
+func validateScanOptions(req *types.ScanRequest) error {
+	if req.Severity != "" {
+		for _, severity := range strings.Split(req.Severity, ",") {
+			if !validSeverities[strings.TrimSpace(severity)] {
+				return fmt.Errorf("%w: unknown severity %q", ErrInvalidScan, severity)
+			}
+		}
+	}
+
+	if req.RateLimit < 0 || req.RateLimit > 10000 {
+		return fmt.Errorf("%w: rate limit must be between 0 and 10000", ErrInvalidScan)
+	}
+
+	if req.Timeout < 0 || req.Timeout > 600 {
+		return fmt.Errorf("%w: timeout must be between 1 and 600 seconds, or 0 for the server default", ErrInvalidScan)
+	}
+	if req.Retries != nil && (*req.Retries < 0 || *req.Retries > 10) {
+		return fmt.Errorf("%w: retries must be between 0 and 10", ErrInvalidScan)
+	}
+	if req.MaxHostErrors < 0 || req.MaxHostErrors > 10000 {
+		return fmt.Errorf("%w: max host errors must be between 1 and 10000, or 0 for the server default", ErrInvalidScan)
+	}
+
+	// The dedicated fields replace the equivalent pass-through flags
+	for _, flag := range req.ExtraFlags {
+		switch strings.SplitN(strings.TrimLeft(flag, "-"), "=", 2)[0] {
+		case "timeout", "retries", "max-host-error":
+			return fmt.Errorf("%w: set %s with the timeout, retries and maxHostErrors fields instead", ErrInvalidScan, flag)
+		}
+	}
+
+	return nil
+}
+
+// applyScanDefaults fills unset host error options from the server defaults
+func (o *Orchestrator) applyScanDefaults(req *types.ScanRequest) {
+	defaults := o.settings().Nuclei
+	if req.Timeout == 0 {
+		req.Timeout = defaults.Timeout
+	}
+	if req.Retries == nil {
+		retries := defaults.Retries
+		req.Retries = &retries
+	}
+	if req.MaxHostErrors == 0 {
+		req.MaxHostErrors = defaults.MaxHostErrors
+	}
+}
+
+// explicitConfigKeys returns the nuclei option names set by the request itself,
+// which win over the same keys in a config file
+func explicitConfigKeys(req *types.ScanRequest) map[string]string {
+	keys := make(map[string]string)
+	if req.Severity != "" {
+		keys["severity"] = "severity"
+		keys["s"] = "severity"
+	}
+	if req.RateLimit > 0 {
+		keys["rate-limit"] = "rateLimit"
+		keys["rl"] = "rateLimit"
+	}
+	if req.Headless {
+		keys["headless"] = "headless"
+	}
+	if req.Timeout > 0 {
+		keys["timeout"] = "timeout"
+	}
+	if req.Retries != nil {
+		keys["retries"] = "retries"
+	}
+	if req.MaxHostErrors > 0 {
+		keys["max-host-error"] = "maxHostErrors"
+		keys["mhe"] = "maxHostErrors"
+	}
+	for _, flag := range req.ExtraFlags {
+		name := strings.SplitN(strings.TrimLeft(flag, "-"), "=", 2)[0]
+		keys[name] = "extraFlags"
+	}
+	return keys
+}
+
+// sanitizeConfigYAML parses a nuclei config file, refuses keys outside
+// allowedConfigKeys and values that do not match their pattern, and removes
+// keys set explicitly on the request. It returns the cleaned YAML and a
+// description of every removed key.
+func sanitizeConfigYAML(req *types.ScanRequest) (string, []string, error) {
+	if req.ConfigYAML == "" {
+		return "", nil, nil
+	}
+
+	if len(req.ConfigYAML) > maxConfigYAMLBytes {
+		return "", nil, fmt.Errorf("%w: config YAML exceeds %d bytes", ErrInvalidScan, maxConfigYAMLBytes)
+	}
+
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(req.ConfigYAML), &config); err != nil {
+		return "", nil, fmt.Errorf("%w: config YAML is not a valid mapping: %v", ErrInvalidScan, err)
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pattern, allowed := allowedConfigKeys[key]
+		if !allowed {
+			return "", nil, fmt.Errorf("%w: config key %q is not allowed", ErrInvalidScan, key)
+		}
+		if !validConfigValue(pattern, config[key]) {
+			return "", nil, fmt.Errorf("%w: invalid value for config key %q", ErrInvalidScan, key)
+		}
+	}
+
+	explicit := explicitConfigKeys(req)
+	conflicts := make([]string, 0)
+	for key := range config {
+		if field, exists := explicit[key]; exists {
+			conflicts = append(conflicts, fmt.Sprintf("config key %q was overridden by %s", key, field))
+			delete(config, key)
+		}
+	}
+	sort.Strings(conflicts)
+
+	cleaned, err := yaml.Marshal(config)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode config YAML: %v", err)
+	}
+
+	return string(cleaned), conflicts, nil
+}
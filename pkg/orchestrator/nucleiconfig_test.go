@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nuclei-distributed/pkg/types"
+)
+
+func TestSanitizeConfigYAMLRefusesKeys(t *testing.T) {
+	// Options that write files on the worker, and those the worker script
+	// sets itself
+	refused := []string{
+		"store-resp-dir: /etc/cron.d", "srd: /etc/cron.d",
+		"error-log: /root/.bashrc", "trace-log: /root/.bashrc",
+		"markdown-export: /tmp/x", "me: /tmp/x",
+		"json-export: /tmp/x.json", "sarif-export: /tmp/x.sarif",
+		"project-path: /tmp/project",
+		"output: /tmp/results.jsonl", "list: /etc/passwd", "stats: true",
+		"templates: [/etc]", "secret-file: /etc/shadow",
+	}
+	for _, config := range refused {
+		_, _, err := sanitizeConfigYAML(&types.ScanRequest{ConfigYAML: config})
+		if !errors.Is(err, ErrInvalidScan) || !strings.Contains(err.Error(), "is not allowed") {
+			t.Errorf("sanitizeConfigYAML(%q) error = %v, want the key refused", config, err)
+		}
+	}
+}
+
+func TestSanitizeConfigYAMLValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{name: "list", config: "tags: [cve, rce]", valid: true},
+		{name: "comma-separated list", config: "exclude-tags: dos,fuzz", valid: true},
+		{name: "number", config: "bulk-size: 25", valid: true},
+		{name: "boolean", config: "follow-redirects: true", valid: true},
+		{name: "choice", config: "scan-strategy: host-spray", valid: true},
+		{name: "headers", config: "header: ['Authorization: Bearer abc', 'X-Team: red']", valid: true},
+		{name: "variables", config: "var: [username=admin]", valid: true},
+		{name: "list with a shell expansion", config: "tags: ['$(id)']"},
+		{name: "path in a list", config: "template-id: /etc/passwd"},
+		{name: "number as text", config: "bulk-size: lots"},
+		{name: "list for a number", config: "concurrency: [1, 2]"},
+		{name: "boolean as text", config: "headless: 'yes'"},
+		{name: "unknown choice", config: "scan-strategy: everything"},
+		{name: "header without a name", config: "header: ': abc'"},
+		{name: "empty list", config: "tags: []"},
+		{name: "nested mapping", config: "tags: {a: b}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := sanitizeConfigYAML(&types.ScanRequest{ConfigYAML: tt.config})
+			if tt.valid && err != nil {
+				t.Errorf("sanitizeConfigYAML(%q) error = %v, want it accepted", tt.config, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidScan) {
+				t.Errorf("sanitizeConfigYAML(%q) error = %v, want it refused", tt.config, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeConfigYAMLOverrides(t *testing.T) {
+	retries := 2
+	req := &types.ScanRequest{
+		ConfigYAML: "severity: low\nrate-limit: 50\ntags: [cve]\nretries: 5\n",
+		Severity:   "high,critical",
+		Retries:    &retries,
+	}
+	cleaned, conflicts, err := sanitizeConfigYAML(req)
+	if err != nil {
+		t.Fatalf("sanitizeConfigYAML() error = %v", err)
+	}
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(cleaned), &config); err != nil {
+		t.Fatalf("cleaned config %q does not parse: %v", cleaned, err)
+	}
+	if _, kept := config["severity"]; kept || config["rate-limit"] != 50 || config["tags"] == nil {
+		t.Errorf("cleaned config = %v, want severity dropped and the rest kept", config)
+	}
+	if _, kept := config["retries"]; kept {
+		t.Errorf("cleaned config = %v, want retries dropped", config)
+	}
+	if len(conflicts) != 2 {
+		t.Errorf("conflicts = %q, want severity and retries", conflicts)
+	}
+}
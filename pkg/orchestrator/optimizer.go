@@ -1,24 +1,139 @@
 package orchestrator
 
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// provisioningMinutes covers droplet boot plus the bootstrap script
+	provisioningMinutes = 5.0
+	// headlessProvisioningMinutes is the extra time spent installing chromium
+	headlessProvisioningMinutes = 3.0
+	// secondsPerDomain is the rough time nuclei spends on a single target
+	secondsPerDomain = 6.0
+	// headlessSecondsPerDomain is the extra per-target time for browser templates
+	headlessSecondsPerDomain = 4.0
+)
+
+// DefaultOptimizerLimits apply where the server configuration leaves a
+// limit unset
+var DefaultOptimizerLimits = types.OptimizerLimits{
+	MinDroplets:          1,
+	MaxDroplets:          5,
+	MinDomainsPerDroplet: 50,
+	MaxDomainsPerDroplet: 500,
+}
+
 // ScanOptimizer optimizes the distribution of domains across droplets
 type ScanOptimizer struct {
 	MaxDomainsPerDroplet int
 	MinDomainsPerDroplet int
 	MaxDroplets          int
 	MinDroplets          int
+	// DomainsPerHour is the droplet size's throughput for estimates, zero
+	// for the global default. ThroughputMeasured is set when it comes from
+	// past scans with the same templates, which headless ones include.
+	DomainsPerHour     float64
+	ThroughputMeasured bool
+	// PriceHourly is the droplet size's price on the compute provider, zero
+	// when unknown
+	PriceHourly float64
 }
 
 // NewScanOptimizer creates a new optimizer with default settings
 func NewScanOptimizer() *ScanOptimizer {
+	return newScanOptimizer(DefaultOptimizerLimits)
+}
+
+// newScanOptimizer creates an optimizer with resolved limits
+func newScanOptimizer(limits types.OptimizerLimits) *ScanOptimizer {
 	return &ScanOptimizer{
-		MaxDomainsPerDroplet: 500,  // Max domains per droplet
-		MinDomainsPerDroplet: 50,   // Min domains per droplet
-		MaxDroplets:          5,   // Max droplets allowed
-		MinDroplets:          1,    // Min droplets required
+		MaxDomainsPerDroplet: limits.MaxDomainsPerDroplet,
+		MinDomainsPerDroplet: limits.MinDomainsPerDroplet,
+		MaxDroplets:          limits.MaxDroplets,
+		MinDroplets:          limits.MinDroplets,
+	}
+}
+
+// serverOptimizerLimits fills the limits the server configuration leaves
+// unset with the defaults and checks they are consistent
+func serverOptimizerLimits(limits types.OptimizerLimits) (types.OptimizerLimits, error) {
+	if limits.MinDroplets == 0 {
+		limits.MinDroplets = DefaultOptimizerLimits.MinDroplets
+	}
+	if limits.MaxDroplets == 0 {
+		limits.MaxDroplets = DefaultOptimizerLimits.MaxDroplets
+	}
+	if limits.MinDomainsPerDroplet == 0 {
+		limits.MinDomainsPerDroplet = DefaultOptimizerLimits.MinDomainsPerDroplet
+	}
+	if limits.MaxDomainsPerDroplet == 0 {
+		limits.MaxDomainsPerDroplet = DefaultOptimizerLimits.MaxDomainsPerDroplet
+	}
+	if err := checkOptimizerLimits(limits); err != nil {
+		return limits, fmt.Errorf("invalid optimizer limits: %v", err)
+	}
+	return limits, nil
+}
+
+// resolveOptimizerLimits applies a scan's optimizer overrides to the
+// server's limits. Maximums may only go down, so the server's stay a
+// ceiling.
+func resolveOptimizerLimits(server types.OptimizerLimits, overrides *types.OptimizerLimits) (types.OptimizerLimits, error) {
+	if overrides == nil {
+		return server, nil
+	}
+
+	limits := server
+	fields := []struct {
+		name     string
+		value    int
+		resolved *int
+		ceiling  int
+	}{
+		{"minDroplets", overrides.MinDroplets, &limits.MinDroplets, server.MaxDroplets},
+		{"maxDroplets", overrides.MaxDroplets, &limits.MaxDroplets, server.MaxDroplets},
+		{"minDomainsPerDroplet", overrides.MinDomainsPerDroplet, &limits.MinDomainsPerDroplet, server.MaxDomainsPerDroplet},
+		{"maxDomainsPerDroplet", overrides.MaxDomainsPerDroplet, &limits.MaxDomainsPerDroplet, server.MaxDomainsPerDroplet},
+	}
+	for _, field := range fields {
+		if field.value < 0 {
+			return server, fmt.Errorf("%w: optimizer %s must not be negative", ErrInvalidScan, field.name)
+		}
+		if field.value > field.ceiling {
+			return server, fmt.Errorf("%w: optimizer %s must be at most %d", ErrInvalidScan, field.name, field.ceiling)
+		}
+		if field.value > 0 {
+			*field.resolved = field.value
+		}
+	}
+	if err := checkOptimizerLimits(limits); err != nil {
+		return server, fmt.Errorf("%w: optimizer %v", ErrInvalidScan, err)
+	}
+	return limits, nil
+}
+
+// checkOptimizerLimits refuses limits that contradict each other
+func checkOptimizerLimits(limits types.OptimizerLimits) error {
+	switch {
+	case limits.MinDroplets < 1 || limits.MinDomainsPerDroplet < 1:
+		return fmt.Errorf("minimums must be at least 1")
+	case limits.MinDroplets > limits.MaxDroplets:
+		return fmt.Errorf("minDroplets %d is above maxDroplets %d", limits.MinDroplets, limits.MaxDroplets)
+	case limits.MinDomainsPerDroplet > limits.MaxDomainsPerDroplet:
+		return fmt.Errorf("minDomainsPerDroplet %d is above maxDomainsPerDroplet %d",
+			limits.MinDomainsPerDroplet, limits.MaxDomainsPerDroplet)
 	}
+	return nil
 }
 
-// OptimizeDistribution calculates optimal distribution of domains across droplets
+// OptimizeDistribution calculates optimal distribution of domains across
+// droplets. A non-empty domain list always gets at least one droplet and
+// never more droplets than domains, so no chunk is empty.
 func (so *ScanOptimizer) OptimizeDistribution(domains []string, requestedDroplets int) (int, [][]string) {
 	totalDomains := len(domains)
 	
@@ -35,12 +150,15 @@ func (so *ScanOptimizer) OptimizeDistribution(domains []string, requestedDroplet
 	return optimalDroplets, chunks
 }
 
+// calculateOptimalDroplets picks the droplet count for a non-empty domain
+// list. Limits left at zero are not applied.
 func (so *ScanOptimizer) calculateOptimalDroplets(totalDomains, requestedDroplets int) int {
-	// Start with requested droplets
+	// Start with requested droplets; zero, the field left out, asks for the
+	// minimum
 	optimalDroplets := requestedDroplets
 	
 	// Ensure we don't exceed max droplets
-	if optimalDroplets > so.MaxDroplets {
+	if so.MaxDroplets > 0 && optimalDroplets > so.MaxDroplets {
 		optimalDroplets = so.MaxDroplets
 	}
 	
@@ -48,21 +166,22 @@ func (so *ScanOptimizer) calculateOptimalDroplets(totalDomains, requestedDroplet
 	if optimalDroplets < so.MinDroplets {
 		optimalDroplets = so.MinDroplets
 	}
+	if optimalDroplets < 1 {
+		optimalDroplets = 1
+	}
 	
 	// Check if domains per droplet would be too high
-	domainsPerDroplet := totalDomains / optimalDroplets
-	if domainsPerDroplet > so.MaxDomainsPerDroplet {
+	if so.MaxDomainsPerDroplet > 0 && totalDomains/optimalDroplets > so.MaxDomainsPerDroplet {
 		// Need more droplets
 		optimalDroplets = (totalDomains + so.MaxDomainsPerDroplet - 1) / so.MaxDomainsPerDroplet
-		if optimalDroplets > so.MaxDroplets {
+		if so.MaxDroplets > 0 && optimalDroplets > so.MaxDroplets {
 			optimalDroplets = so.MaxDroplets
 		}
 	}
 	
 	// Check if domains per droplet would be too low (unless total domains is small)
-	if totalDomains > so.MinDomainsPerDroplet {
-		domainsPerDroplet = totalDomains / optimalDroplets
-		if domainsPerDroplet < so.MinDomainsPerDroplet {
+	if so.MinDomainsPerDroplet > 0 && totalDomains > so.MinDomainsPerDroplet {
+		if totalDomains/optimalDroplets < so.MinDomainsPerDroplet {
 			// Use fewer droplets
 			optimalDroplets = totalDomains / so.MinDomainsPerDroplet
 			if optimalDroplets < so.MinDroplets {
@@ -71,11 +190,31 @@ func (so *ScanOptimizer) calculateOptimalDroplets(totalDomains, requestedDroplet
 		}
 	}
 	
+	// Whatever the limits say, every droplet needs a domain and there is at
+	// least one droplet
+	if optimalDroplets > totalDomains {
+		optimalDroplets = totalDomains
+	}
+	if optimalDroplets < 1 {
+		optimalDroplets = 1
+	}
+	
 	return optimalDroplets
 }
 
+// createChunks splits domains into numDroplets contiguous chunks whose sizes
+// differ by at most one, with numDroplets kept between 1 and len(domains)
 func (so *ScanOptimizer) createChunks(domains []string, numDroplets int) [][]string {
 	totalDomains := len(domains)
+	if numDroplets < 1 {
+		numDroplets = 1
+	}
+	if numDroplets > totalDomains {
+		numDroplets = totalDomains
+	}
+	if numDroplets == 0 {
+		return [][]string{}
+	}
 	baseChunkSize := totalDomains / numDroplets
 	extraDomains := totalDomains % numDroplets
 	
@@ -99,3 +238,118 @@ func (so *ScanOptimizer) createChunks(domains []string, numDroplets int) [][]str
 	
 	return chunks
 }
+
+// GroupByApex spreads domains across droplets like OptimizeDistribution, but
+// keeps the names under each registered domain together on one droplet, so
+// nuclei's per-host rate limits see all of an origin's traffic. Groups are
+// split only when larger than MaxDomainsPerDroplet, and packed largest first
+// onto the droplet with the fewest domains, which keeps droplets as even as
+// the groups allow. Fewer groups than droplets means fewer droplets.
+func (so *ScanOptimizer) GroupByApex(domains []string, requestedDroplets int) (int, [][]string) {
+	if len(domains) == 0 {
+		return 0, [][]string{}
+	}
+	numDroplets := so.calculateOptimalDroplets(len(domains), requestedDroplets)
+
+	var order []string
+	groups := make(map[string][]string)
+	for _, domain := range domains {
+		key := apexKey(domain)
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], domain)
+	}
+
+	var pieces [][]string
+	for _, key := range order {
+		group := groups[key]
+		for so.MaxDomainsPerDroplet > 0 && len(group) > so.MaxDomainsPerDroplet {
+			pieces = append(pieces, group[:so.MaxDomainsPerDroplet])
+			group = group[so.MaxDomainsPerDroplet:]
+		}
+		pieces = append(pieces, group)
+	}
+	// Largest first; equal pieces keep the order their domains came in
+	sort.SliceStable(pieces, func(i, j int) bool { return len(pieces[i]) > len(pieces[j]) })
+
+	if numDroplets > len(pieces) {
+		numDroplets = len(pieces)
+	}
+	chunks := make([][]string, numDroplets)
+	for _, piece := range pieces {
+		smallest := 0
+		for i := range chunks {
+			if len(chunks[i]) < len(chunks[smallest]) {
+				smallest = i
+			}
+		}
+		chunks[smallest] = append(chunks[smallest], piece...)
+	}
+
+	return numDroplets, chunks
+}
+
+// apexKey groups a target with the others under the same registered domain.
+// IPs are grouped only with themselves.
+func apexKey(target string) string {
+	host := hostname(target)
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return registeredDomain(host)
+}
+
+// EstimatePlan projects the duration and cost of running chunks on droplets of
+// the given size. The slowest (largest) chunk determines the duration.
+func (so *ScanOptimizer) EstimatePlan(chunks [][]string, config types.DropletConfig, headless bool) *types.ScanPlan {
+	largestChunk := 0
+	for _, chunk := range chunks {
+		if len(chunk) > largestChunk {
+			largestChunk = len(chunk)
+		}
+	}
+
+	minutes, perDomain := so.estimateMinutes(largestChunk, headless)
+
+	chunkSizes := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		chunkSizes[i] = len(chunk)
+	}
+
+	plan := &types.ScanPlan{
+		ChunkSizes:       chunkSizes,
+		Droplets:         len(chunks),
+		DropletSize:      config.Size,
+		Region:           config.Region,
+		EstimatedMinutes: minutes,
+		DomainsPerHour:   3600 / perDomain,
+	}
+
+	plan.PriceHourly = so.PriceHourly
+	plan.EstimatedCost = estimateCost(len(chunks), so.PriceHourly, minutes)
+
+	return plan
+}
+
+// estimateMinutes projects how long droplets take to scan largestChunk
+// targets, provisioning included, and the seconds each target takes
+func (so *ScanOptimizer) estimateMinutes(largestChunk int, headless bool) (float64, float64) {
+	minutes := provisioningMinutes
+	perDomain := secondsPerDomain
+	if so.DomainsPerHour > 0 {
+		perDomain = 3600 / so.DomainsPerHour
+	}
+	if headless {
+		minutes += headlessProvisioningMinutes
+		if !so.ThroughputMeasured {
+			perDomain += headlessSecondsPerDomain
+		}
+	}
+	return minutes + float64(largestChunk)*perDomain/60, perDomain
+}
+
+// estimateCost prices running droplets for minutes
+func estimateCost(droplets int, priceHourly, minutes float64) float64 {
+	return float64(droplets) * priceHourly * minutes / 60
+}
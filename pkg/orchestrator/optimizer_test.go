@@ -0,0 +1,186 @@
+package orchestrator
+
+import (
+	"fmt"
+	"testing"
+)
+
+// numberedDomains returns n distinct domains under their own apexes
+func numberedDomains(n int) []string {
+	domains := make([]string, n)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("host%d.example%d.com", i, i)
+	}
+	return domains
+}
+
+// checkChunks fails unless chunks hold every domain exactly once, none of
+// them empty
+func checkChunks(t *testing.T, domains []string, droplets int, chunks [][]string) {
+	t.Helper()
+	if len(chunks) != droplets {
+		t.Errorf("got %d chunks for %d droplets", len(chunks), droplets)
+	}
+	seen := make(map[string]int)
+	for i, chunk := range chunks {
+		if len(chunk) == 0 {
+			t.Errorf("chunk %d is empty", i)
+		}
+		for _, domain := range chunk {
+			seen[domain]++
+		}
+	}
+	for _, domain := range domains {
+		if seen[domain] != 1 {
+			t.Errorf("%s is in %d chunks, want 1", domain, seen[domain])
+		}
+		delete(seen, domain)
+	}
+	for domain := range seen {
+		t.Errorf("chunks hold %s, which was not submitted", domain)
+	}
+}
+
+func TestOptimizeDistribution(t *testing.T) {
+	// Every domain count on every requested droplet count, with the droplets
+	// planned for each request
+	requests := []int{0, 1, 5, 100}
+	for _, tt := range []struct {
+		domains int
+		want    []int
+	}{
+		{0, []int{0, 0, 0, 0}},
+		{1, []int{1, 1, 1, 1}},
+		{49, []int{1, 1, 5, 5}},
+		{50, []int{1, 1, 5, 5}},
+		{501, []int{2, 2, 5, 5}},
+		{2501, []int{5, 5, 5, 5}},
+		{10000, []int{5, 5, 5, 5}},
+	} {
+		for i, requested := range requests {
+			testDistribution(t, tt.domains, requested, tt.want[i])
+		}
+	}
+}
+
+// testDistribution checks the droplets and chunks planned for count domains
+func testDistribution(t *testing.T, count, requested, want int) {
+	t.Run(fmt.Sprintf("%d domains on %d droplets", count, requested), func(t *testing.T) {
+		domains := numberedDomains(count)
+		droplets, chunks := NewScanOptimizer().OptimizeDistribution(domains, requested)
+		if droplets != want {
+			t.Errorf("droplets = %d, want %d", droplets, want)
+		}
+		if count == 0 {
+			if droplets != 0 || chunks == nil || len(chunks) != 0 {
+				t.Errorf("OptimizeDistribution() = %d, %v for no domains, want 0 and no chunks", droplets, chunks)
+			}
+			return
+		}
+		if droplets < 1 || droplets > count {
+			t.Errorf("droplets = %d, want between 1 and %d", droplets, count)
+		}
+		if droplets > DefaultOptimizerLimits.MaxDroplets {
+			t.Errorf("droplets = %d, above the maximum of %d", droplets, DefaultOptimizerLimits.MaxDroplets)
+		}
+		if len(chunks) > len(domains) {
+			t.Errorf("got %d chunks for %d domains", len(chunks), len(domains))
+		}
+		checkChunks(t, domains, droplets, chunks)
+
+		// Contiguous and even, in the order submitted
+		var joined []string
+		smallest, largest := len(chunks[0]), len(chunks[0])
+		for _, chunk := range chunks {
+			joined = append(joined, chunk...)
+			smallest, largest = min(smallest, len(chunk)), max(largest, len(chunk))
+		}
+		if largest-smallest > 1 {
+			t.Errorf("chunk sizes range from %d to %d, want them even", smallest, largest)
+		}
+		for i := range joined {
+			if joined[i] != domains[i] {
+				t.Fatalf("chunk order differs from the domains at %d: %s, want %s", i, joined[i], domains[i])
+			}
+		}
+	})
+}
+
+func TestOptimizeDistributionWithoutLimits(t *testing.T) {
+	optimizer := &ScanOptimizer{}
+	for _, tt := range []struct{ domains, requested, want int }{
+		{3, 0, 1},
+		{3, 10, 3},
+		{100, 7, 7},
+	} {
+		domains := numberedDomains(tt.domains)
+		droplets, chunks := optimizer.OptimizeDistribution(domains, tt.requested)
+		if droplets != tt.want {
+			t.Errorf("%d domains on %d droplets: droplets = %d, want %d", tt.domains, tt.requested, droplets, tt.want)
+		}
+		checkChunks(t, domains, droplets, chunks)
+	}
+}
+
+func TestGroupByApex(t *testing.T) {
+	// One apex larger than two droplets hold, and many small ones
+	var domains []string
+	for i := 0; i < 1200; i++ {
+		domains = append(domains, fmt.Sprintf("h%d.big.com", i))
+	}
+	for apex := 0; apex < 40; apex++ {
+		for i := 0; i <= apex%3; i++ {
+			domains = append(domains, fmt.Sprintf("www%d.small%d.org", i, apex))
+		}
+	}
+	domains = append(domains, "10.0.0.1", "https://10.0.0.1:8443/login", "10.0.0.2")
+
+	optimizer := NewScanOptimizer()
+	droplets, chunks := optimizer.GroupByApex(domains, 5)
+	if droplets != 5 {
+		t.Fatalf("droplets = %d, want 5", droplets)
+	}
+	checkChunks(t, domains, droplets, chunks)
+
+	chunkOf := make(map[string]map[int]bool)
+	for i, chunk := range chunks {
+		if len(chunk) > optimizer.MaxDomainsPerDroplet {
+			t.Errorf("chunk %d holds %d domains, above the %d per droplet", i, len(chunk), optimizer.MaxDomainsPerDroplet)
+		}
+		for _, domain := range chunk {
+			key := apexKey(domain)
+			if chunkOf[key] == nil {
+				chunkOf[key] = make(map[int]bool)
+			}
+			chunkOf[key][i] = true
+		}
+	}
+
+	// The large apex is split into droplet-sized pieces, the others and
+	// each IP stay whole
+	if got := len(chunkOf["big.com"]); got != 3 {
+		t.Errorf("big.com is spread over %d chunks, want 3", got)
+	}
+	for key, spread := range chunkOf {
+		if key != "big.com" && len(spread) != 1 {
+			t.Errorf("%s is spread over %d chunks, want 1", key, len(spread))
+		}
+	}
+	if len(chunkOf) != 1+40+2 {
+		t.Errorf("got %d groups, want 43", len(chunkOf))
+	}
+}
+
+func TestGroupByApexFewerGroupsThanDroplets(t *testing.T) {
+	domains := []string{"a.one.com", "b.one.com", "two.net", "www.two.net", "three.io"}
+	droplets, chunks := NewScanOptimizer().GroupByApex(domains, 5)
+	if droplets != 3 {
+		t.Errorf("droplets = %d for three apexes, want 3", droplets)
+	}
+	checkChunks(t, domains, droplets, chunks)
+
+	droplets, chunks = NewScanOptimizer().GroupByApex(nil, 5)
+	if droplets != 0 || len(chunks) != 0 {
+		t.Errorf("GroupByApex() = %d, %v for no domains, want 0 and no chunks", droplets, chunks)
+	}
+}
@@ -2,137 +2,751 @@ package orchestrator
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/digitalocean/godo"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/projectdiscovery/subfinder/v2/pkg/runner"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"nuclei-distributed/pkg/artifacts"
+	"nuclei-distributed/pkg/forward"
+	"nuclei-distributed/pkg/jira"
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/storage"
 	"nuclei-distributed/pkg/types"
 )
 
 type Orchestrator struct {
-	doClient    *godo.Client
+	provider    provider.Provider
 	redis       *redis.Client
 	activeScans map[string]*types.ScanStatus
 	mutex       sync.RWMutex
-	mainServerIP string
+	callbackURL  string // base URL workers call back on
+	pinnedPubKey string // curl --pinnedpubkey value, empty to trust CAs
+	config       atomic.Pointer[runtimeConfig] // settings changeable at runtime
+	configMutex  sync.Mutex                    // held while changing the settings
+	configOverrides map[string]json.RawMessage // changed at runtime, nil until loaded from storage
+
+	workerTokens   map[string]string // scanID/workerID -> callback token
+	secretsCipher  cipher.AEAD
+	scanStates     map[string]*scanState
+	smtp           notify.SMTPConfig // recipients come from the settings
+	store          storage.Store
+	artifacts      *artifacts.Client // nil when artifacts are not archived
+	forwarder      *forward.OpenSearch // nil when findings are not forwarded
+	triageMutex    sync.Mutex
+	rulesMutex     sync.Mutex
+	fpRules        map[string]*fpRule // nil until loaded from storage
+	retentionMutex sync.Mutex         // held while pinning or purging a scan
+	janitor        types.RetentionHealth // guarded by mutex
+	jira           *jira.Client          // nil when findings are not filed in Jira
+	ticketMutex    sync.Mutex            // held while filing an issue
+	ticketQueue    chan ticketJob        // findings filed automatically
+	blocklistMutex   sync.Mutex
+	blockRules       []*blockRule // added at runtime, nil until loaded from storage
+	dropletCapacities map[string]types.DropletCapacity // by size slug
+	regions          regionLimits
+	firewall         FirewallConfig
+	warmPool         warmPool
+	degraded         DegradedThresholds
+	selfCheckMode    string
+	poolMutex        sync.Mutex // held across changes to a pull scan's pool, before mutex
+
+	health   healthState
+	lister   workerLister // shared provider listings for the loops watching workers
+	scanLogs scanLogs
+	alerts   *alertBus
+
+	scanEvents   scanEvents
+	replicas     *replicaState
+	workerStream *workerStream   // nil while workers call back
+	subfinder    *runner.Runner // nil when scans cannot be expanded
+}
+
+// Config holds the settings an orchestrator is created with
+type Config struct {
+	// Provider creates and destroys the machines workers run on
+	Provider     provider.Provider
+	RedisURL     string
+	// CallbackURL is the base URL workers reach the server on, e.g.
+	// https://scanner.example.com:8443
+	CallbackURL string
+	// PinnedPublicKey, when set, is the curl --pinnedpubkey value workers
+	// check the server's certificate against instead of trusted CAs, for
+	// self-signed certificates
+	PinnedPublicKey string
+	// SecretsKey is the 32-byte AES key used to encrypt scan secrets; when
+	// empty an ephemeral key is generated
+	SecretsKey []byte
+	// Settings are the ones operators may change at runtime: nuclei
+	// defaults, optimizer limits, retention, the configured blocklist,
+	// notifiers and alerts. Changes made through UpdateConfig are stored
+	// and win over these.
+	Settings types.ServerConfig
+	// SMTP is the mail server completion reports are sent through; the
+	// recipients are in Settings
+	SMTP notify.SMTPConfig
+	// Storage selects where scan records and findings are kept. The redis
+	// backend shares the orchestrator's client.
+	Storage storage.Config
+	// Artifacts is the bucket completed scans are archived to; leave the
+	// endpoint empty to disable archiving
+	Artifacts artifacts.Config
+	// OpenSearch is the cluster findings are forwarded to; leave the URL
+	// empty to disable forwarding
+	OpenSearch forward.OpenSearchConfig
+	// Jira is the project findings are filed in; leave the URL empty to
+	// disable issue creation
+	Jira jira.Config
+	// DropletCapacities adds to or replaces entries of
+	// DefaultDropletCapacities. Listed sizes take their own targets per
+	// droplet instead of the optimizer's, and are estimated at their own
+	// throughput.
+	DropletCapacities map[string]types.DropletCapacity
+	// RegionLimits caps the worker droplets each region runs, counting
+	// those of every scan. Scans whose region is full fall back to the
+	// listed regions in order; other regions are not capped.
+	RegionLimits []types.RegionLimit
+	// Firewall restricts inbound traffic to workers on providers that
+	// support cloud firewalls
+	Firewall FirewallConfig
+	// WarmPool keeps workers running between scans for scans to claim
+	WarmPool WarmPoolConfig
+	// Degraded are the memory and disk limits past which workers are
+	// reported degraded; unset ones use the defaults
+	Degraded DegradedThresholds
+	// Replica lets several servers share the Redis at RedisURL; leave its
+	// URL empty to run alone
+	Replica ReplicaConfig
+	// WorkerRedisURL is the redis:// or rediss:// URL, credentials
+	// included, workers reach the Redis at RedisURL on to push results,
+	// heartbeats and completion onto their scan's stream. Leave it empty for
+	// workers to call back over HTTP.
+	WorkerRedisURL string
+	// SubfinderProviderConfig is the file with the API keys of the sources
+	// subfinder enumerates the subdomains of scans started with expand
+	// from. Leave it empty for subfinder's default location.
+	SubfinderProviderConfig string
+	// SelfCheckMode is what the server does while a self-check fails:
+	// SelfCheckReadOnly, the default, SelfCheckStrict or SelfCheckWarn.
+	// Refusing to start in strict mode is up to the caller.
+	SelfCheckMode string
 }
 
-func New(doToken string, redisURL string, mainServerIP string) *Orchestrator {
-	return &Orchestrator{
-		doClient:     godo.NewFromToken(doToken),
-		redis:        redis.NewClient(&redis.Options{Addr: redisURL}),
-		activeScans:  make(map[string]*types.ScanStatus),
-		mainServerIP: mainServerIP,
+// maxRecentResults is the number of findings kept in ScanStatus.Results for
+// live views
+const maxRecentResults = 100
+
+func New(cfg Config) (*Orchestrator, error) {
+	if cfg.Provider == nil {
+		return nil, errors.New("a compute provider is required")
+	}
+	redisClient := redis.NewClient(&redis.Options{Addr: cfg.RedisURL})
+
+	storageConfig := cfg.Storage
+	storageConfig.Redis = redisClient
+	store, err := storage.New(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifactClient *artifacts.Client
+	if cfg.Artifacts.Endpoint != "" {
+		artifactClient, err = artifacts.NewClient(cfg.Artifacts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var forwarder *forward.OpenSearch
+	if cfg.OpenSearch.URL != "" {
+		forwarder, err = forward.NewOpenSearch(cfg.OpenSearch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var jiraClient *jira.Client
+	if cfg.Jira.URL != "" {
+		jiraClient, err = jira.NewClient(cfg.Jira)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	settings, err := compileConfig(cfg.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	subfinder, err := newSubfinder(cfg.SubfinderProviderConfig)
+	if err != nil {
+		slog.Warn("Failed to set up subfinder, scans cannot be expanded into subdomains", "error", err)
+	}
+
+	secretsCipher, err := newSecretsCipher(cfg.SecretsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream *workerStream
+	if cfg.WorkerRedisURL != "" {
+		stream, err = newWorkerStream(cfg.WorkerRedisURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	selfCheckMode := cfg.SelfCheckMode
+	if selfCheckMode == "" {
+		selfCheckMode = SelfCheckReadOnly
 	}
+	if !validSelfCheckMode(selfCheckMode) {
+		return nil, fmt.Errorf("self-check mode must be %s, %s or %s", SelfCheckStrict, SelfCheckReadOnly, SelfCheckWarn)
+	}
+
+	dropletCapacities, err := serverDropletCapacities(cfg.DropletCapacities)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Orchestrator{
+		provider:       cfg.Provider,
+		redis:          redisClient,
+		activeScans:    make(map[string]*types.ScanStatus),
+		callbackURL:    strings.TrimSuffix(cfg.CallbackURL, "/"),
+		pinnedPubKey:   cfg.PinnedPublicKey,
+		workerTokens:   make(map[string]string),
+		secretsCipher:  secretsCipher,
+		scanStates:     make(map[string]*scanState),
+		smtp:           cfg.SMTP,
+		store:          store,
+		artifacts:      artifactClient,
+		forwarder:      forwarder,
+		jira:           jiraClient,
+		dropletCapacities: dropletCapacities,
+		degraded:         cfg.Degraded.withDefaults(),
+		selfCheckMode:    selfCheckMode,
+		regions:          regionLimits{limits: cfg.RegionLimits, full: make(map[string]time.Time)},
+		firewall:         cfg.Firewall,
+		warmPool:         newWarmPool(cfg.WarmPool),
+		alerts:           newAlertBus(),
+		replicas:         newReplicaState(cfg.Replica),
+		workerStream:     stream,
+		subfinder:        subfinder,
+	}
+	o.useConfig(settings)
+	o.health.provider = types.DependencyHealth{Status: types.DependencyUnknown, Critical: true}
+	go o.runHealthChecks()
+	go o.restoreState()
+	go o.runLeaseChecks()
+	go o.runWarmPool()
+	go o.runAlertChecks()
+	go o.runJanitor()
+	o.startReplica()
+	if jiraClient != nil && jiraClient.AutoSeverity() != "" {
+		o.ticketQueue = make(chan ticketJob, ticketQueueSize)
+		go o.runTickets()
+	}
+	return o, nil
 }
 
-func (o *Orchestrator) StartScan(ctx context.Context, req *types.ScanRequest) error {
-	log.Printf("Starting scan for %d domains with %d droplets", len(req.Domains), req.Droplets)
-	
+func (o *Orchestrator) StartScan(ctx context.Context, req *types.ScanRequest) (*types.ScanPlan, error) {
+	slog.Info("Starting scan", "domains", len(req.Domains), "droplets", req.Droplets)
+	return o.startScan(ctx, req, nil, nil)
+}
+
+// startScan checks, plans and starts a scan. Scans started with expand are
+// planned once their subdomains are enumerated, and scans started with
+// preflight once their targets are checked, by a later call given what
+// enumeration or preflight found.
+func (o *Orchestrator) startScan(ctx context.Context, req *types.ScanRequest, enumeration *scanEnumeration,
+	check *scanPreflight) (*types.ScanPlan, error) {
+	if o.readOnly() {
+		return nil, ErrReadOnly
+	}
+
 	// Generate scan ID if not provided
 	if req.ID == "" {
 		req.ID = uuid.New().String()
 	}
+	planStart := time.Now()
+	// Enumerated scans are planned again from what was submitted
+	submitted := *req
+
+	targets, err := o.checkTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	var discovered map[string]string
+	if enumeration != nil {
+		discovered = enumeration.discovered(req.Domains)
+		// The summary is still in the enumerating scan's status
+		o.mutex.Lock()
+		enumeration.summary.Added = len(discovered)
+		o.mutex.Unlock()
+		targets.Enumeration = enumeration.summary
+	}
+	if !req.KeepOrder && !req.GroupByApex {
+		shuffleTargets(req.Domains, req.ID)
+		targets.Shuffled = true
+	}
+
+	dropletConfig, err := resolveDropletConfig(req)
+	if err != nil {
+		return nil, err
+	}
+
+	extraFlags, err := validateExtraFlags(req.ExtraFlags)
+	if err != nil {
+		return nil, err
+	}
+	req.ExtraFlags = extraFlags
+
+	o.applyScanDefaults(req)
+	if err := validateScanOptions(req); err != nil {
+		return nil, err
+	}
+	if err := resolveDistribution(req); err != nil {
+		return nil, err
+	}
+	if err := resolvePlanMode(req); err != nil {
+		return nil, err
+	}
+
+	configYAML, conflicts, err := sanitizeConfigYAML(req)
+	if err != nil {
+		return nil, err
+	}
+	req.ConfigYAML = configYAML
+
+	if err := validateNotifications(req); err != nil {
+		return nil, err
+	}
+	if len(req.NotifyEmails) > 0 && !o.smtp.Enabled() {
+		return nil, fmt.Errorf("%w: email is not configured", ErrInvalidScan)
+	}
+	if err := o.checkRoutes(req); err != nil {
+		return nil, err
+	}
+	if req.OpenSearch != nil && *req.OpenSearch && o.forwarder == nil {
+		return nil, fmt.Errorf("%w: OpenSearch forwarding is not configured", ErrInvalidScan)
+	}
+	if req.Expand && enumeration == nil {
+		return o.startEnumeration(ctx, submitted, req, targets)
+	}
+	if req.Preflight && check == nil {
+		return o.startPreflight(ctx, submitted, req, targets, enumeration)
+	}
+
+	// Dead targets are left out before droplets are planned for them
+	var dead []string
+	if check != nil {
+		isDead := make(map[string]bool, len(check.dead))
+		for _, target := range check.dead {
+			isDead[target] = true
+		}
+		alive := make([]string, 0, len(req.Domains))
+		for _, target := range req.Domains {
+			if isDead[target] {
+				dead = append(dead, target)
+			} else {
+				alive = append(alive, target)
+			}
+		}
+		if len(alive) == 0 {
+			return nil, fmt.Errorf("%w: all %d targets are dead", ErrInvalidScan, len(dead))
+		}
+		req.Domains = alive
+		targets.Preflight = check.summary
+	}
+
+	// Sizes in the capacity table take their own number of targets
+	serverLimits := o.settings().Optimizer
+	capacity, sized := o.dropletCapacities[dropletConfig.Size]
+	if sized {
+		serverLimits = capacityLimits(serverLimits, capacity)
+	}
+	limits, err := resolveOptimizerLimits(serverLimits, req.Optimizer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Regions at their droplet limit leave room for fewer droplets, which
+	// spill over into the configured fallbacks
+	var slots []regionSlot
+	var regionWarnings []string
+	if len(o.regions.limits) > 0 {
+		var warning string
+		slots, warning = o.regionSlots(ctx, dropletConfig.Region, nil)
+		if warning != "" {
+			regionWarnings = append(regionWarnings, warning)
+		}
+		room := regionRoom(slots)
+		if room == 0 {
+			return nil, ErrNoRegionCapacity
+		}
+		if room > 0 && room < limits.MaxDroplets {
+			limits.MaxDroplets = room
+			limits.MinDroplets = min(limits.MinDroplets, room)
+			regionWarnings = append(regionWarnings, fmt.Sprintf("the allowed regions have room for %d more droplets", room))
+		}
+	}
+
+	// Past scans with the same size and templates know the throughput
+	// best, then the capacity table
+	optimizer := newScanOptimizer(limits)
+	optimizer.DomainsPerHour = capacity.DomainsPerHour
+	optimizer.PriceHourly = o.sizePrice(ctx, dropletConfig.Size)
+	throughputSource := ThroughputDefault
+	if sized {
+		throughputSource = ThroughputCapacity
+	}
+	templates := templateSet(req)
+	measured, samples := o.plannedThroughput(ctx, dropletConfig.Size, templates)
+	if measured > 0 {
+		optimizer.DomainsPerHour = measured
+		optimizer.ThroughputMeasured = true
+		throughputSource = ThroughputHistory
+	}
+	droplets, deadlineMissed := optimizer.modeDroplets(req.Mode, len(req.Domains), req.Droplets, req.DeadlineMinutes,
+		req.Headless)
+	var numDroplets int
+	var chunks [][]string
+	if req.GroupByApex {
+		numDroplets, chunks = optimizer.GroupByApex(req.Domains, droplets)
+	} else {
+		numDroplets, chunks = optimizer.OptimizeDistribution(req.Domains, droplets)
+	}
+	plan := optimizer.EstimatePlan(chunks, dropletConfig, req.Headless)
+	plan.Mode = req.Mode
+	plan.Currency = o.currency()
+	plan.Alternatives = optimizer.planAlternatives(req.Mode, len(req.Domains), req.Droplets, req.Headless)
+	if req.Mode != PlanBalanced && req.Droplets > 0 {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("droplets is ignored in %s mode", req.Mode))
+	}
+	if deadlineMissed != "" {
+		plan.Warnings = append(plan.Warnings, deadlineMissed)
+	}
+	plan.Limits = &limits
+	plan.ThroughputSource = throughputSource
+	plan.TemplateSet = templates
+	plan.HistorySamples = samples
+	if sized {
+		plan.Capacity = &capacity
+	} else {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("droplet size %s has no capacity entry, planned with the "+
+			"default %d targets per droplet", dropletConfig.Size, serverLimits.MaxDomainsPerDroplet))
+	}
+	workerRegions := make([]string, len(chunks))
+	for i := range workerRegions {
+		workerRegions[i] = dropletConfig.Region
+	}
+	if slots != nil {
+		workerRegions = assignRegions(slots, len(chunks))
+		plan.Regions = make(map[string]int)
+		for _, region := range workerRegions {
+			plan.Regions[region]++
+		}
+	}
+	plan.Warnings = append(plan.Warnings, regionWarnings...)
+	plan.Warnings = append(plan.Warnings, conflicts...)
+	plan.Targets = targets
+	plan.Distribution = req.Distribution
 
-	// Optimize droplet distribution
-	optimizer := NewScanOptimizer()
-	numDroplets, chunks := optimizer.OptimizeDistribution(req.Domains, req.Droplets)
+	// Pull workers start without targets and lease them from the pool
+	var pool *types.TargetPool
+	if req.Distribution == DistributionPull {
+		if err := o.fillPool(ctx, req.ID, req.Domains); err != nil {
+			return nil, err
+		}
+		pool = &types.TargetPool{BatchSize: req.BatchSize, Queued: len(req.Domains)}
+		plan.ChunkSizes = nil
+		for i := range chunks {
+			chunks[i] = nil
+		}
+	}
 	
-	log.Printf("Optimized to %d droplets", numDroplets)
+	// The scan's log starts with the plan, or the enumeration or preflight
+	// before it; nothing below refuses the scan
+	log := o.scanLogs.get(req.ID)
+	phased := enumeration != nil || check != nil
+	if !phased || log == nil {
+		log = o.scanLogs.start(req.ID)
+	}
+	slog.Info("Optimized droplets", "scan_id", req.ID, "droplets", numDroplets, "size", dropletConfig.Size,
+		"distribution", req.Distribution)
+
+	// The scan's trace outlives the request that started it, which it links
+	// to instead. Scans refused while planning only show up in the request's.
+	scanStart, link := planStart, trace.LinkFromContext(ctx)
+	if check != nil {
+		scanStart, link = check.startedAt, check.link
+	}
+	if enumeration != nil {
+		scanStart, link = enumeration.startedAt, enumeration.link
+	}
+	scanCtx, scanSpan := tracer.Start(context.Background(), "scan", trace.WithTimestamp(scanStart),
+		trace.WithLinks(link), trace.WithAttributes(attribute.String("scan.id", req.ID)))
+	if enumeration != nil {
+		_, enumerateSpan := tracer.Start(scanCtx, "enumerate", trace.WithTimestamp(enumeration.startedAt),
+			trace.WithAttributes(
+				attribute.Int("enumeration.apexes", len(enumeration.summary.Apexes)),
+				attribute.Int("enumeration.added", enumeration.summary.Added),
+			))
+		enumerateSpan.End(trace.WithTimestamp(enumeration.finishedAt))
+	}
+	if check != nil {
+		_, preflightSpan := tracer.Start(scanCtx, "preflight", trace.WithTimestamp(check.startedAt),
+			trace.WithAttributes(
+				attribute.Int("preflight.checked", check.summary.Checked),
+				attribute.Int("preflight.dead", check.summary.DeadCount),
+			))
+		preflightSpan.End(trace.WithTimestamp(check.finishedAt))
+	}
+	_, planSpan := tracer.Start(scanCtx, "plan", trace.WithTimestamp(planStart), trace.WithAttributes(
+		attribute.Int("scan.targets", len(req.Domains)),
+		attribute.Int("scan.droplets", numDroplets),
+		attribute.String("scan.distribution", req.Distribution),
+		attribute.String("droplet.size", dropletConfig.Size),
+	))
+	planSpan.End()
 
 	// Initialize scan status
 	o.mutex.Lock()
-	o.activeScans[req.ID] = &types.ScanStatus{
+	status := &types.ScanStatus{
 		ID:             req.ID,
+		CreatedAt:      time.Now().UTC(),
 		Progress:       0,
 		ActiveDroplets: make([]*types.WorkerStatus, 0),
 		Results:        make([]types.ScanResult, 0),
+		SeverityCounts: make(map[string]int),
 		TotalDomains:   len(req.Domains),
+		DomainsAlive:   len(req.Domains),
 		Status:         "starting",
+		Plan:           plan,
+		ExtraFlags:     extraFlags,
+		ConfigYAML:     configYAML,
+		RerunOf:        req.RerunOf,
+		Pool:           pool,
+		Held:           req.HoldUntilRelease,
 	}
+	// An enumerated or checked scan carries over what changed while it was
+	// enumerating or in preflight
+	var waiting *scanState
+	if phased {
+		phase := ScanEnumerating
+		if check != nil {
+			phase = ScanPreflight
+		}
+		placeholder, exists := o.activeScans[req.ID]
+		if !exists || placeholder.Status != phase {
+			o.mutex.Unlock()
+			scanSpan.End()
+			return nil, ErrScanNotFound
+		}
+		status.CreatedAt = placeholder.CreatedAt
+		status.Revision = placeholder.Revision
+		status.Pinned = placeholder.Pinned
+		status.Warnings = placeholder.Warnings
+		if placeholder.ReleasedAt != nil {
+			status.Held = false
+			status.ReleasedAt = placeholder.ReleasedAt
+		}
+		status.Enumeration = placeholder.Enumeration
+		status.Preflight = placeholder.Preflight
+		waiting = o.scanStates[req.ID]
+	}
+	o.activeScans[req.ID] = status
+	o.replicateScan(req.ID)
+	state := newScanState()
+	state.targets = req.Domains
+	if pool != nil {
+		state.coverage.assign(req.Domains, "", TargetPending)
+	}
+	for i, chunk := range chunks {
+		state.coverage.assign(chunk, workerName(req.ID, i), TargetPending)
+	}
+	state.coverage.assign(dead, "", TargetDead)
+	state.scope = newTargetScope(req.Domains)
+	request := *req
+	request.Domains = nil
+	state.request = &request
+	state.notifiers, state.webhooks = o.startNotifiers(req)
+	state.emailTo = o.emailRecipients(req)
+	_, state.emailMinSeverity = notifierRoute(req, "email")
+	state.forward = o.forwarder != nil && (req.OpenSearch == nil || *req.OpenSearch)
+	state.span = scanSpan
+	state.log = log
+	if enumeration != nil {
+		state.discovered = discovered
+	}
+	if phased {
+		state.plannedAt = time.Now()
+	}
+	o.scanStates[req.ID] = state
+	if waiting != nil {
+		// Wakes the clients waiting on the scan before it was planned
+		state.changed = waiting.changed
+		o.scanChanged(status)
+	}
+	o.emitEvent(req.ID, notify.EventScanStarted, scanSummary(o.activeScans[req.ID], state))
+	record := scanRecord(o.activeScans[req.ID], state)
 	o.mutex.Unlock()
 
+	o.saveScanRecord(record)
+
+	o.protectWorkers(ctx, req.ID)
+
 	// Create droplets for each chunk
 	for i, chunk := range chunks {
-		go func(index int, domains []string) {
-			if err := o.createAndStartWorker(ctx, req.ID, index, domains); err != nil {
-				log.Printf("Failed to create worker %d: %v", index, err)
-			}
-		}(i, chunk)
+		config := dropletConfig
+		config.Region = workerRegions[i]
+		// Workers outlive the request that started the scan
+		go o.launchWorker(scanCtx, req, config, i, chunk)
 	}
 
-	return nil
+	return plan, nil
 }
 
-func (o *Orchestrator) createAndStartWorker(ctx context.Context, scanID string, index int, domains []string) error {
-	workerID := fmt.Sprintf("%s-worker-%d", scanID[:8], index)
+func (o *Orchestrator) createAndStartWorker(ctx context.Context, req *types.ScanRequest, config types.DropletConfig, index int, domains []string) error {
+	scanID := req.ID
+	workerID := workerName(scanID, index)
 	
-	log.Printf("Creating worker %s with %d domains", workerID, len(domains))
+	slog.Info("Creating worker", "scan_id", scanID, "worker_id", workerID, "domains", len(domains))
 
-	// Create user data script
-	userData := o.generateUserData(scanID, workerID, domains)
-
-	createRequest := &godo.DropletCreateRequest{
-		Name:   workerID,
-		Region: "nyc3",
-		Size:   "s-1vcpu-1gb", 
-		Image: godo.DropletCreateImage{
-			Slug: "ubuntu-20-04-x64",
-		},
-		UserData: userData,
-		Tags:     []string{"nuclei-worker", scanID},
+	token, err := o.issueWorkerToken(scanID, workerID)
+	if err != nil {
+		return fmt.Errorf("failed to issue worker token: %v", err)
 	}
 
-	droplet, _, err := o.doClient.Droplets.Create(ctx, createRequest)
+	// Create user data script
+	userData := o.generateUserData(ctx, req, workerID, token, domains)
+
+	createCtx, span := tracer.Start(ctx, "create droplet", workerAttributes(scanID, workerID), trace.WithAttributes(
+		attribute.String("droplet.region", config.Region), attribute.String("droplet.size", config.Size)))
+	droplet, err := o.provider.CreateWorker(createCtx, provider.CreateRequest{
+		Name:     workerID,
+		Region:   config.Region,
+		Size:     config.Size,
+		Image:    config.Image,
+		UserData: userData,
+		Tags:     []string{workerTag, scanID},
+		Env:      map[string]string{"SCAN_ID": scanID, "WORKER_ID": workerID, "SERVER_URL": o.callbackURL},
+	})
+	o.alerts.dropletCreated(config.Region, config.Size, err)
 	if err != nil {
-		return fmt.Errorf("failed to create droplet: %v", err)
+		endSpan(span, err)
+		return fmt.Errorf("failed to create droplet: %w", err)
 	}
+	span.SetAttributes(attribute.String("droplet.id", droplet.ID))
+	span.End()
 
-	log.Printf("Created droplet %d for worker %s", droplet.ID, workerID)
+	slog.Info("Created droplet", "scan_id", scanID, "worker_id", workerID, "droplet_id", droplet.ID)
+	o.billDroplet(scanID, types.DropletCost{
+		WorkerID:    workerID,
+		DropletID:   droplet.ID,
+		Size:        config.Size,
+		PriceHourly: droplet.PriceHourly,
+		StartedAt:   droplet.CreatedAt,
+	})
+	o.workerEvent(scanID, types.WorkerProvisioning, &types.WorkerStatus{
+		ID:           workerID,
+		TotalDomains: len(domains),
+		DomainsAlive: len(domains),
+		CreatedAt:    time.Now(),
+		Status:       "provisioning",
+	})
 
 	// Wait for droplet to get IP and be ready
-	go o.waitForWorker(ctx, scanID, workerID, droplet.ID, len(domains))
+	go o.waitForWorker(ctx, scanID, workerID, droplet.ID, scanID, len(domains))
 
 	return nil
 }
 
-func (o *Orchestrator) waitForWorker(ctx context.Context, scanID, workerID string, dropletID int, totalDomains int) {
+// workerName derives a worker's ID, which doubles as its droplet name
+func workerName(scanID string, index int) string {
+	return fmt.Sprintf("%s-worker-%d", scanID[:8], index)
+}
+
+// workerCreateFailed records a worker whose droplet never came up
+func (o *Orchestrator) workerCreateFailed(scanID, workerID string, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	state := o.scanStates[scanID]
+	if state == nil {
+		return
+	}
+	state.failedWorkers++
+	state.coverage.workerFinished(workerID, TargetErrored)
+	if scan, exists := o.activeScans[scanID]; exists {
+		o.scanChanged(scan)
+	}
+	o.emitEvent(scanID, notify.EventWorkerFailed, types.WorkerFailure{WorkerID: workerID, Reason: err.Error()})
+	o.workerEvent(scanID, types.WorkerFailed, &types.WorkerStatus{ID: workerID, CreatedAt: time.Now(), Status: "failed"})
+}
+
+// waitForWorker waits for a worker to be ready and get an IP, then watches
+// it. Its droplet is looked up in the shared listing of the droplets
+// carrying tag, its scan's ID unless it came from the warm pool.
+func (o *Orchestrator) waitForWorker(ctx context.Context, scanID, workerID, dropletID, tag string, totalDomains int) {
+	_, span := tracer.Start(ctx, "wait for worker", workerAttributes(scanID, workerID),
+		trace.WithAttributes(attribute.String("droplet.id", dropletID)))
 	// Wait for droplet to be ready and get IP
 	for {
-		droplet, _, err := o.doClient.Droplets.Get(ctx, dropletID)
+		droplet, err := o.lookupWorker(ctx, tag, dropletID)
+		if errors.Is(err, provider.ErrNotFound) || (err == nil && droplet.Status == provider.StatusFailed) {
+			slog.Error("Worker failed before it was ready", "scan_id", scanID, "worker_id", workerID, "droplet_id", dropletID)
+			err := errors.New("worker failed on the provider before it was ready")
+			endSpan(span, err)
+			o.workerCreateFailed(scanID, workerID, err)
+			return
+		}
 		if err != nil {
-			log.Printf("Error getting droplet status: %v", err)
+			slog.Warn("Failed to get droplet status", "scan_id", scanID, "worker_id", workerID, "droplet_id", dropletID, "error", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
-		if droplet.Status == "active" {
-			ip, err := droplet.PublicIPv4()
-			if err == nil && ip != "" {
+		if droplet.Status == provider.StatusActive {
+			ip := droplet.IP
+			if ip != "" {
 				// Add worker to active scan
 				o.mutex.Lock()
 				if scan, exists := o.activeScans[scanID]; exists {
 					worker := &types.WorkerStatus{
 						ID:           workerID,
 						IP:           ip,
+						IPv6:         droplet.IPv6,
 						Progress:     0,
 						TotalDomains: totalDomains,
+						DomainsAlive: totalDomains,
 						CreatedAt:    time.Now(),
 						Status:       "starting",
 						Logs:         make([]types.Log, 0),
 					}
 					scan.ActiveDroplets = append(scan.ActiveDroplets, worker)
+					o.scanChanged(scan)
+					o.workerSeen(scanID, worker)
+					o.workerEvent(scanID, types.WorkerReady, worker)
 				}
 				o.mutex.Unlock()
 				
-				log.Printf("Worker %s is ready at IP %s", workerID, ip)
-				break
+				slog.Info("Worker ready", "scan_id", scanID, "worker_id", workerID, "ip", ip, "ipv6", droplet.IPv6)
+				span.End()
+				o.watchWorker(ctx, scanID, workerID, dropletID, tag)
+				return
 			}
 		}
 
@@ -140,126 +754,553 @@ func (o *Orchestrator) waitForWorker(ctx context.Context, scanID, workerID strin
 	}
 }
 
-func (o *Orchestrator) generateUserData(scanID, workerID string, domains []string) string {
-	domainsStr := strings.Join(domains, "\n")
-	domainsB64 := base64.StdEncoding.EncodeToString([]byte(domainsStr))
-
-	script := fmt.Sprintf(`#!/bin/bash
-export DEBIAN_FRONTEND=noninteractive
-
-# Update system
-apt-get update
-apt-get install -y curl wget unzip
-
-# Install Go
-wget https://go.dev/dl/go1.21.0.linux-amd64.tar.gz
-tar -C /usr/local -xzf go1.21.0.linux-amd64.tar.gz
-export PATH=$PATH:/usr/local/go/bin
-
-# Install nuclei
-wget https://github.com/projectdiscovery/nuclei/releases/download/v3.0.4/nuclei_3.0.4_linux_amd64.zip
-unzip nuclei_3.0.4_linux_amd64.zip
-mv nuclei /usr/local/bin/
-
-# Set up environment
-export SCAN_ID=%s
-export WORKER_ID=%s
-export MAIN_SERVER=%s
-export DOMAINS_B64=%s
-
-# Decode domains
-echo $DOMAINS_B64 | base64 -d > /root/domains.txt
-
-# Download and run worker script
-curl -L https://raw.githubusercontent.com/projectdiscovery/nuclei/main/nuclei-templates.tar.gz | tar -xzf - -C /root/
-
-# Start scan
-/usr/local/bin/nuclei -l /root/domains.txt -json -o /root/results.json &
-
-# Monitor and send results
-while true; do
-    if [ -f /root/results.json ]; then
-        tail -f /root/results.json | while read line; do
-            curl -X POST \
-                -H "Content-Type: application/json" \
-                -d "$line" \
-                "http://$MAIN_SERVER:8080/api/results/$SCAN_ID/$WORKER_ID" || true
-        done
-    fi
-    sleep 5
-done
-`, scanID, workerID, o.mainServerIP, domainsB64)
-
-	return script
+func (o *Orchestrator) GetScanStatus(scanID string) (*types.ScanStatus, error) {
+	o.mutex.RLock()
+	if status, exists := o.activeScans[scanID]; exists {
+		snapshot := statusSnapshot(status)
+		o.mutex.RUnlock()
+		return snapshot, nil
+	}
+	o.mutex.RUnlock()
+
+	// Scans other replicas run are served from their copy in Redis
+	if o.replicated() {
+		return o.replicaStatus(scanID)
+	}
+	return nil, ErrScanNotFound
 }
 
-func (o *Orchestrator) GetScanStatus(scanID string) (*types.ScanStatus, error) {
+// touchScan bumps the revision of a tracked scan after a change made outside
+// o.mutex, such as to its stored findings
+func (o *Orchestrator) touchScan(scanID string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if scan, exists := o.activeScans[scanID]; exists {
+		o.scanChanged(scan)
+	}
+}
+
+// ScanRevision returns the revision of a scan the server tracks. Scans only
+// found in storage have none.
+func (o *Orchestrator) ScanRevision(scanID string) (int64, bool) {
 	o.mutex.RLock()
 	defer o.mutex.RUnlock()
-	
+
+	if scan, exists := o.activeScans[scanID]; exists {
+		return scan.Revision, true
+	}
+	return 0, false
+}
+
+// scanChanged bumps a scan's revision and wakes the clients waiting for it
+// to change. Callers must hold o.mutex.
+func (o *Orchestrator) scanChanged(scan *types.ScanStatus) {
+	scan.Revision++
+	o.replicateScan(scan.ID)
+	if state := o.scanStates[scan.ID]; state != nil && state.changed != nil {
+		close(state.changed)
+		state.changed = nil
+	}
+}
+
+// WaitForScan returns a scan's status once its revision is above revision,
+// right away if it already is, or when ctx is done
+func (o *Orchestrator) WaitForScan(ctx context.Context, scanID string, revision int64) (*types.ScanStatus, error) {
+	for {
+		o.mutex.Lock()
+		scan, exists := o.activeScans[scanID]
+		state := o.scanStates[scanID]
+		if !exists || state == nil {
+			o.mutex.Unlock()
+			return nil, ErrScanNotFound
+		}
+		if scan.Revision > revision {
+			status := statusSnapshot(scan)
+			o.mutex.Unlock()
+			return status, nil
+		}
+		if state.changed == nil {
+			state.changed = make(chan struct{})
+		}
+		changed := state.changed
+		o.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return o.GetScanStatus(scanID)
+		}
+	}
+}
+
+// statusSnapshot copies a scan's status so it can be serialized after the lock
+// is released. Results only hold the most recent findings, so the copy stays
+// small however many the scan has stored. Callers must hold o.mutex.
+func statusSnapshot(scan *types.ScanStatus) *types.ScanStatus {
+	snapshot := *scan
+	snapshot.ActiveDroplets = make([]*types.WorkerStatus, len(scan.ActiveDroplets))
+	for i, worker := range scan.ActiveDroplets {
+		copied := *worker
+		snapshot.ActiveDroplets[i] = &copied
+	}
+	snapshot.Results = append([]types.ScanResult(nil), scan.Results...)
+	snapshot.SeverityCounts = make(map[string]int, len(scan.SeverityCounts))
+	for severity, count := range scan.SeverityCounts {
+		snapshot.SeverityCounts[severity] = count
+	}
+	snapshot.ProblemHosts = append([]types.ProblemHost(nil), scan.ProblemHosts...)
+	snapshot.Artifacts = append([]types.Artifact(nil), scan.Artifacts...)
+	snapshot.Rebalances = append([]types.Rebalance(nil), scan.Rebalances...)
+	snapshot.Warnings = append([]string(nil), scan.Warnings...)
+	if scan.EmailReport != nil {
+		report := *scan.EmailReport
+		snapshot.EmailReport = &report
+	}
+	if scan.Pool != nil {
+		pool := *scan.Pool
+		snapshot.Pool = &pool
+	}
+	if scan.Enumeration != nil {
+		enumeration := *scan.Enumeration
+		enumeration.TimedOut = append([]string(nil), scan.Enumeration.TimedOut...)
+		enumeration.Failed = append([]string(nil), scan.Enumeration.Failed...)
+		snapshot.Enumeration = &enumeration
+	}
+	snapshot.TriageCounts = copyTriageCounts(scan.TriageCounts)
+	snapshot.ActualCost = accrue(scan.ActualCost, scanEnded(scan.Status), time.Now())
+	return &snapshot
+}
+
+// GetScanConfigYAML returns the sanitized nuclei config file for a scan
+func (o *Orchestrator) GetScanConfigYAML(scanID string) (string, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
 	if status, exists := o.activeScans[scanID]; exists {
-		return status, nil
+		return status.ConfigYAML, nil
 	}
-	
-	return nil, fmt.Errorf("scan not found")
+
+	return "", ErrScanNotFound
 }
 
-func (o *Orchestrator) UpdateWorkerProgress(scanID, workerID string, progress float64, currentDomain string) {
+// UpdateWorkerProgress records a worker's host counts from its nuclei stats.
+// hostsTotal may differ from the worker's live host count when nuclei dedupes
+// inputs, so the count is scaled before it feeds scan-level progress.
+func (o *Orchestrator) UpdateWorkerProgress(scanID, workerID string, hostsCompleted, hostsTotal int, currentDomain string) {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 	
 	if scan, exists := o.activeScans[scanID]; exists {
-		for _, worker := range scan.ActiveDroplets {
-			if worker.ID == workerID {
-				worker.Progress = progress
-				worker.CurrentDomain = currentDomain
-				worker.DomainsScanned = int(float64(worker.TotalDomains) * progress / 100)
-				break
+		if worker := o.findWorker(scanID, workerID); worker != nil {
+			// Targets queued for the worker are not in its stats until it
+			// pulls them
+			workload := worker.DomainsAlive
+			if state := o.scanStates[scanID]; state != nil {
+				workload -= len(state.pendingTargets[workerID])
 			}
+			scanned := hostsCompleted
+			if hostsTotal > 0 && hostsTotal != workload {
+				scanned = hostsCompleted * workload / hostsTotal
+			}
+			o.setWorkerScanned(worker, scanned)
+			worker.CurrentDomain = currentDomain
+			o.workerSeen(scanID, worker)
+			if worker.Status == "starting" {
+				worker.Status = "running" // nuclei only reports once it runs
+			}
+			if state := o.scanStates[scanID]; state != nil && worker.Status == "running" {
+				workerScanning(state, workerID, time.Now())
+				state.coverage.workerStarted(workerID)
+			}
+			o.workerEvent(scanID, types.WorkerProgress, worker)
 		}
-		
-		// Update overall progress
-		totalProgress := 0.0
-		for _, worker := range scan.ActiveDroplets {
-			totalProgress += worker.Progress
+
+		updateScanProgress(scan)
+		o.scanChanged(scan)
+	}
+}
+
+// SetWorkerAlive records how many of a worker's domains answered the httpx
+// probe. Only those hosts are handed to nuclei, so they become the worker's
+// workload for progress purposes. Targets added to the worker since it
+// started were not probed and count as alive.
+func (o *Orchestrator) SetWorkerAlive(scanID, workerID string, alive int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if scan, exists := o.activeScans[scanID]; exists {
+		if worker := o.findWorker(scanID, workerID); worker != nil {
+			if state := o.scanStates[scanID]; state != nil {
+				alive += state.addedTargets[workerID]
+			}
+			if alive > worker.TotalDomains {
+				alive = worker.TotalDomains
+			}
+			worker.DomainsAlive = alive
+			o.setWorkerScanned(worker, worker.DomainsScanned)
+			if o.workerSeen(scanID, worker) {
+				o.workerEvent(scanID, types.WorkerProgress, worker)
+			}
 		}
-		if len(scan.ActiveDroplets) > 0 {
-			scan.Progress = totalProgress / float64(len(scan.ActiveDroplets))
+
+		updateScanProgress(scan)
+		o.scanChanged(scan)
+	}
+}
+
+// CompleteWorker marks every host of a worker's chunk as scanned, or marks the
+// worker failed when failure is set. It reports whether the scan finished
+// with this worker, in which case the scan is marked completed.
+func (o *Orchestrator) CompleteWorker(scanID, workerID, failure string) bool {
+	// Batches a pull worker gave up on go to the others
+	o.releaseWorkerLeases(scanID, workerID)
+
+	o.mutex.Lock()
+
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		o.mutex.Unlock()
+		return false
+	}
+
+	var added *plannedWorker
+	if worker := o.findWorker(scanID, workerID); worker != nil {
+		worker.CurrentDomain = ""
+		delete(state.stalledWorkers, workerID)
+		if failure != "" {
+			worker.Status = "failed"
+			o.emitEvent(scanID, notify.EventWorkerFailed, types.WorkerFailure{WorkerID: workerID, Reason: failure})
+		} else {
+			worker.Status = "completed"
+			// Counts as idle while it asks for targets rebalanced its way
+			state.workerSeen[workerID] = time.Now()
+		}
+		delete(state.releases, workerID)
+		workerStopped(state, workerID, time.Now())
+		// A pool worker goes back to the pool and off the scan's bill
+		endBilling(scan.ActualCost, "", workerID, time.Now())
+		added = o.requeueTargets(scan, state, worker)
+		if failure == "" {
+			state.coverage.workerFinished(workerID, TargetCompleted)
+		} else {
+			state.coverage.workerFinished(workerID, TargetErrored)
 		}
+		if failure == "" {
+			o.setWorkerScanned(worker, worker.DomainsAlive)
+			o.workerEvent(scanID, types.WorkerCompleted, worker)
+		} else {
+			o.workerEvent(scanID, types.WorkerFailed, worker)
+		}
+	}
+	if added != nil {
+		go o.startAddedWorker(scanID, added)
+	}
+	updateScanProgress(scan)
+	o.scanChanged(scan)
+
+	if scanEnded(scan.Status) || !scanFinished(scan, state) {
+		o.mutex.Unlock()
+		return false
+	}
+
+	if scan.Pool != nil && scan.Pool.Queued+scan.Pool.Leased > 0 {
+		// Every worker failed before the pool was drained
+		slog.Warn("Scan finished with targets left in the pool", "scan_id", scanID,
+			"queued", scan.Pool.Queued, "leased", scan.Pool.Leased)
+	}
+	completedAt := time.Now().UTC()
+	scan.Coverage = state.coverage.summary()
+	scan.Status, scan.FailureSummary = scanOutcome(o.settings().FailurePolicy, scan, state)
+	scan.Degraded = scan.FailureSummary != nil
+	if scan.Coverage.Gap > 0 {
+		slog.Warn("Scan finished without scanning every target", "scan_id", scanID, "gap", scan.Coverage.Gap,
+			"coverage", scan.Coverage.Counts)
+	}
+	if scan.Degraded {
+		slog.Warn("Scan exceeded its failure policy", "scan_id", scanID, "status", scan.Status,
+			"reasons", scan.FailureSummary.Reasons)
+	}
+	scan.CompletedAt = &completedAt
+	if o.artifacts != nil {
+		scan.Artifacts = pendingArtifacts(scanID)
+	}
+	if len(state.emailTo) > 0 {
+		scan.EmailReport = &types.EmailReport{Recipients: state.emailTo, Status: "pending"}
+	}
+	summary := scanSummary(scan, state)
+	o.emitEvent(scanID, notify.EventScanComplete, summary)
+	state.span.AddEvent("scan finished", trace.WithAttributes(attribute.String("scan.status", scan.Status)))
+	record := scanRecord(scan, state)
+	sample := throughputSample(scan, state)
+	o.mutex.Unlock()
+
+	o.saveScanRecord(record)
+	if sample != nil {
+		go o.recordThroughput(sample)
+	}
+	if o.artifacts != nil {
+		go o.uploadArtifacts(scanID)
+	}
+	if record.EmailReport != nil {
+		go o.sendEmailReport(scanID, summary)
+	}
+	return true
+}
+
+// scanFinished reports whether every planned worker has either completed,
+// failed, or never came up. Callers must hold o.mutex.
+func scanFinished(scan *types.ScanStatus, state *scanState) bool {
+	planned := len(scan.ActiveDroplets)
+	if scan.Plan != nil {
+		planned = scan.Plan.Droplets
+	}
+	if len(scan.ActiveDroplets)+state.failedWorkers < planned {
+		return false
+	}
+
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status != "completed" && worker.Status != "failed" {
+			return false
+		}
+	}
+	return true
+}
+
+// scanSummary condenses a scan for notifications. Callers must hold o.mutex.
+func scanSummary(scan *types.ScanStatus, state *scanState) types.ScanSummary {
+	summary := types.ScanSummary{
+		ID:                scan.ID,
+		Status:            scan.Status,
+		TotalDomains:      scan.TotalDomains,
+		ScannedDomains:    scan.ScannedDomains,
+		Workers:           len(scan.ActiveDroplets),
+		FailedWorkers:     state.failedWorkers,
+		Results:           scan.ResultCount,
+		Severities:        make(map[string]int),
+		DuplicatesDropped: scan.DuplicatesDropped,
+		Degraded:          scan.Degraded,
+		FailureSummary:    scan.FailureSummary,
+	}
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status == "failed" {
+			summary.FailedWorkers++
+		}
+	}
+	for severity, count := range scan.SeverityCounts {
+		summary.Severities[severity] = count
+	}
+	return summary
+}
+
+func (o *Orchestrator) setWorkerScanned(worker *types.WorkerStatus, scanned int) {
+	if scanned > worker.DomainsAlive {
+		scanned = worker.DomainsAlive
+	}
+	if scanned < 0 {
+		scanned = 0
+	}
+
+	worker.DomainsScanned = scanned
+	if worker.DomainsAlive > 0 {
+		worker.Progress = float64(scanned) / float64(worker.DomainsAlive) * 100
+	} else {
+		// Nothing answered the probe, so there is nothing left to scan
+		worker.Progress = 100
+	}
+}
+
+// updateScanProgress derives scan progress from completed hosts over the live
+// workload, so large chunks weigh more than small ones and workers that have
+// not reported yet count as zero. Workers that have not registered yet count
+// with their full chunk until their probe says otherwise. Callers must hold
+// o.mutex.
+func updateScanProgress(scan *types.ScanStatus) {
+	scanned := 0
+	alive := 0
+	assigned := 0
+	for _, worker := range scan.ActiveDroplets {
+		scanned += worker.DomainsScanned
+		alive += worker.DomainsAlive
+		assigned += worker.TotalDomains
+	}
+	if unregistered := scan.TotalDomains - assigned; unregistered > 0 {
+		alive += unregistered
+	}
+
+	scan.ScannedDomains = scanned
+	scan.DomainsAlive = alive
+	if alive > 0 {
+		scan.Progress = float64(scanned) / float64(alive) * 100
 	}
 }
 
-func (o *Orchestrator) AddResult(scanID string, result types.ScanResult) {
+// AddResult validates a finding and stores it unless the same host, template
+// and match was already stored for the scan. It returns the finding as
+// stored, or nil for a duplicate. Duplicates are kept separately so they can
+// still be exported, and findings outside the scan's targets are stored but
+// flagged.
+func (o *Orchestrator) AddResult(scanID string, result types.ScanResult) (*types.ScanResult, error) {
+	if err := normalizeResult(&result); err != nil {
+		return nil, err
+	}
+	if rule := o.resultBlocked(&result); rule != nil {
+		slog.Warn("Blocklist: dropped result", "scan_id", scanID, "worker_id", result.WorkerID, "host", result.Host, "pattern", rule.Pattern)
+		o.mutex.Lock()
+		if scan, exists := o.activeScans[scanID]; exists {
+			scan.BlockedResults++
+			o.scanChanged(scan)
+		}
+		o.mutex.Unlock()
+		return nil, ErrResultBlocked
+	}
+
+	o.mutex.Lock()
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		o.mutex.Unlock()
+		return nil, ErrScanNotFound
+	}
+
+	// Workers cannot triage, number or file their own findings
+	result.ID = result.ResultID()
+	result.Triage = nil
+	result.Seq = 0
+	result.Ticket = nil
+	result.OutOfScope = state.scope != nil && !state.scope.contains(result.Host)
+
+	key := dedupHash(result.ID)
+	_, duplicate := state.resultKeys[key]
+	if duplicate {
+		scan.DuplicatesDropped++
+		o.scanChanged(scan)
+	} else {
+		state.resultKeys[key] = struct{}{}
+	}
+	o.mutex.Unlock()
+
+	if duplicate {
+		if err := o.store.AppendDuplicate(scanID, result); err != nil {
+			slog.Error("Failed to store duplicate result", "scan_id", scanID, "error", err)
+		}
+		// Again once stored, so clients holding the earlier revision do not
+		// keep a result list without it
+		o.touchScan(scanID)
+		return nil, nil
+	}
+
+	// Findings matching a false-positive rule are stored, already triaged
+	result.Triage = o.matchRule(&result)
+
+	if _, err := o.store.AppendResult(scanID, result); err != nil {
+		o.alerts.storageFailed("store a finding", err)
+		// Forget the key so the worker's retry is not taken for a duplicate
+		o.mutex.Lock()
+		delete(state.resultKeys, key)
+		o.mutex.Unlock()
+		return nil, err
+	}
+	if result.Triage != nil {
+		o.recordRuleHit(result.Triage.Rule, result.Triage.UpdatedAt)
+	}
+
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
-	
-	if scan, exists := o.activeScans[scanID]; exists {
-		scan.Results = append(scan.Results, result)
-		scan.ScannedDomains++
+
+	o.recordResult(scanID, scan, state, result)
+	return &result, nil
+}
+
+// recordResult counts a stored finding in its scan and passes it on to
+// notifiers, the forwarder and the issue tracker. The caller holds mutex.
+func (o *Orchestrator) recordResult(scanID string, scan *types.ScanStatus, state *scanState, result types.ScanResult) {
+	scan.ResultCount++
+	o.scanChanged(scan)
+	scan.SeverityCounts[storage.IndexedSeverity(result.Severity)]++
+	if result.OutOfScope {
+		scan.OutOfScopeResults++
+		slog.Warn("Result outside the targets", "scan_id", scanID, "worker_id", result.WorkerID, "host", result.Host)
+	}
+	if result.Triage != nil {
+		moveTriage(&scan.TriageCounts, result.Severity, types.TriageOpen, result.Triage.Status)
+	}
+	scan.Results = append(scan.Results, result)
+	if overflow := len(scan.Results) - maxRecentResults; overflow > 0 {
+		scan.Results = append([]types.ScanResult(nil), scan.Results[overflow:]...)
+	}
+	o.emitEvent(scanID, notify.EventNewResult, result)
+	if state.forward {
+		o.forwarder.Forward(scanID, scan.CreatedAt, result)
 	}
+	o.queueTicket(scanID, &result)
 }
 
+// CleanupScan destroys a scan's droplets and releases its worker tokens,
+// secrets and notifiers. The scan record and its results are kept so they can
+// still be exported and compared against later scans.
 func (o *Orchestrator) CleanupScan(scanID string) error {
+	// Saved once unlocked, with what the droplets cost
+	defer o.saveLiveRecord(scanID)
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 	
 	if scan, exists := o.activeScans[scanID]; exists {
+		scanSpan := trace.SpanFromContext(context.Background())
+		if state := o.scanStates[scanID]; state != nil {
+			scanSpan = state.span
+		}
+		_, span := tracer.Start(trace.ContextWithSpan(context.Background(), scanSpan), "cleanup",
+			trace.WithAttributes(attribute.String("scan.id", scanID)))
+		destroyed := 0
+
 		// Destroy all droplets
 		for _, worker := range scan.ActiveDroplets {
 			// Find and destroy droplet by name
-			droplets, _, err := o.doClient.Droplets.ListByTag(context.Background(), scanID, nil)
+			droplets, err := o.provider.ListWorkersByTag(context.Background(), scanID)
 			if err == nil {
 				for _, droplet := range droplets {
 					if strings.Contains(droplet.Name, worker.ID) {
-						o.doClient.Droplets.Delete(context.Background(), droplet.ID)
-						log.Printf("Destroyed droplet %d for worker %s", droplet.ID, worker.ID)
+						if err := o.provider.DestroyWorker(context.Background(), droplet.ID); err == nil {
+							slog.Info("Destroyed droplet", "scan_id", scanID, "worker_id", worker.ID, "droplet_id", droplet.ID)
+							endBilling(scan.ActualCost, droplet.ID, "", time.Now())
+							destroyed++
+							o.workerEvent(scanID, types.WorkerDestroyed, worker)
+						} else {
+							slog.Warn("Failed to destroy droplet", "scan_id", scanID, "worker_id", worker.ID, "droplet_id", droplet.ID, "error", err)
+						}
 					}
 				}
 			}
 		}
+		// Droplets that were not destroyed leak from here
+		cleanedUp(scan.ActualCost, time.Now())
+		go o.removeFirewall(scanID)
+		span.SetAttributes(attribute.Int("droplets.destroyed", destroyed))
+		span.End()
+		scanSpan.SetAttributes(attribute.String("scan.status", scan.Status), attribute.Int("scan.findings", scan.ResultCount))
+		scanSpan.End()
 		
-		// Remove from active scans
-		delete(o.activeScans, scanID)
+		// Release per-scan resources, queued notifications are still sent
+		if state := o.scanStates[scanID]; state != nil {
+			for _, notifier := range state.notifiers {
+				notifier.Close()
+			}
+			state.notifiers = nil
+		}
+		o.forgetWorkerTokens(scanID)
+		if o.replicated() {
+			if err := o.redis.Del(context.Background(), scanTokensKey(scanID)).Err(); err != nil {
+				slog.Warn("Failed to delete worker tokens", "scan_id", scanID, "error", err)
+			}
+		}
+		o.deleteScanSecrets(scanID)
+		if scan.Pool != nil {
+			if err := o.redis.Del(context.Background(), poolTargetsKey(scanID), poolLeasesKey(scanID)).Err(); err != nil {
+				slog.Warn("Failed to delete target pool", "scan_id", scanID, "error", err)
+			}
+		}
 	}
 	
 	return nil
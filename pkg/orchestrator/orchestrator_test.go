@@ -0,0 +1,302 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/provider/fake"
+	"nuclei-distributed/pkg/types"
+)
+
+// newTestOrchestrator returns an orchestrator on the fake provider, keeping
+// scans and findings in a Redis of its own
+func newTestOrchestrator(t *testing.T) (*Orchestrator, *fake.Provider, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	o, err := New(Config{
+		Provider:    workers,
+		RedisURL:    server.Addr(),
+		CallbackURL: "https://scanner.example.com",
+		Settings:    types.ServerConfig{Nuclei: types.NucleiDefaults{Timeout: 10, Retries: 1, MaxHostErrors: 30}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return o, workers, server
+}
+
+// startTestScan starts a scan of domains on one worker
+func startTestScan(t *testing.T, o *Orchestrator, domains ...string) string {
+	t.Helper()
+	req := &types.ScanRequest{Domains: domains, Droplets: 1}
+	if _, err := o.StartScan(context.Background(), req); err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	return req.ID
+}
+
+// waitForWorkers waits until count workers of a scan are up
+func waitForWorkers(t *testing.T, o *Orchestrator, scanID string, count int) *types.ScanStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := o.GetScanStatus(scanID)
+		if err != nil {
+			t.Fatalf("GetScanStatus() error = %v", err)
+		}
+		if len(status.ActiveDroplets) >= count {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d workers came up", len(status.ActiveDroplets), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failedWorkers returns the number of workers of a scan that never came up
+func failedWorkers(o *Orchestrator, scanID string) int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	if state := o.scanStates[scanID]; state != nil {
+		return state.failedWorkers
+	}
+	return 0
+}
+
+func TestStartScanCreatesWorkers(t *testing.T) {
+	o, workers, _ := newTestOrchestrator(t)
+	domains := make([]string, 120)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("host%d.example.com", i)
+	}
+	req := &types.ScanRequest{Domains: domains, Droplets: 2}
+	plan, err := o.StartScan(context.Background(), req)
+	if err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	if plan.Droplets != 2 {
+		t.Fatalf("plan has %d droplets, want 2", plan.Droplets)
+	}
+
+	status := waitForWorkers(t, o, req.ID, 2)
+	total := 0
+	for _, worker := range status.ActiveDroplets {
+		if worker.IP == "" || worker.Status != "starting" {
+			t.Errorf("worker %s is %q at %q, want starting with an IP", worker.ID, worker.Status, worker.IP)
+		}
+		total += worker.TotalDomains
+	}
+	if total != len(domains) {
+		t.Errorf("workers hold %d domains, want %d", total, len(domains))
+	}
+
+	created := workers.Workers()
+	if len(created) != 2 {
+		t.Fatalf("provider has %d workers, want 2", len(created))
+	}
+	// Workers are created concurrently, in no particular order
+	names := map[string]bool{workerName(req.ID, 0): true, workerName(req.ID, 1): true}
+	for _, worker := range created {
+		if !names[worker.Name] {
+			t.Errorf("worker is named %q, want one of %v", worker.Name, names)
+		}
+		delete(names, worker.Name)
+		if !worker.HasTag(workerTag) || !worker.HasTag(req.ID) {
+			t.Errorf("worker %s has tags %v, want %s and the scan ID", worker.Name, worker.Tags, workerTag)
+		}
+
+		o.mutex.RLock()
+		token := o.workerTokens[workerKey(req.ID, worker.Name)]
+		o.mutex.RUnlock()
+		if !o.ValidateWorkerToken(req.ID, worker.Name, token) {
+			t.Errorf("ValidateWorkerToken() = false for the token issued to %s", worker.Name)
+		}
+		if o.ValidateWorkerToken(req.ID, worker.Name, token+"0") {
+			t.Errorf("ValidateWorkerToken() = true for a wrong token for %s", worker.Name)
+		}
+	}
+	if failed := failedWorkers(o, req.ID); failed != 0 {
+		t.Errorf("%d workers failed, want none", failed)
+	}
+}
+
+func TestStartScanWorkerCreateFails(t *testing.T) {
+	o, workers, _ := newTestOrchestrator(t)
+	workers.CreateErr = func(req provider.CreateRequest) error {
+		return errors.New("quota exceeded")
+	}
+	scanID := startTestScan(t, o, "example.com")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for failedWorkers(o, scanID) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the worker that could not be created was not counted as failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if created := workers.Workers(); len(created) != 0 {
+		t.Errorf("provider has %d workers, want none", len(created))
+	}
+}
+
+func TestWaitForWorkerGoneBeforeReady(t *testing.T) {
+	o, _, _ := newTestOrchestrator(t)
+	scanID := startTestScan(t, o, "example.com")
+	waitForWorkers(t, o, scanID, 1)
+
+	// A droplet the provider no longer knows fails the worker at once
+	o.waitForWorker(context.Background(), scanID, workerName(scanID, 1), "999", scanID, 1)
+	if failed := failedWorkers(o, scanID); failed != 1 {
+		t.Errorf("failed workers = %d, want 1", failed)
+	}
+	status, err := o.GetScanStatus(scanID)
+	if err != nil {
+		t.Fatalf("GetScanStatus() error = %v", err)
+	}
+	if len(status.ActiveDroplets) != 1 {
+		t.Errorf("scan has %d workers, want the 1 that came up", len(status.ActiveDroplets))
+	}
+}
+
+func TestCleanupScan(t *testing.T) {
+	o, workers, _ := newTestOrchestrator(t)
+	domains := make([]string, 120)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("host%d.example.com", i)
+	}
+	req := &types.ScanRequest{Domains: domains, Droplets: 2}
+	if _, err := o.StartScan(context.Background(), req); err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	waitForWorkers(t, o, req.ID, 2)
+
+	o.mutex.RLock()
+	tokens := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		workerID := workerName(req.ID, i)
+		tokens[workerID] = o.workerTokens[workerKey(req.ID, workerID)]
+	}
+	o.mutex.RUnlock()
+
+	if err := o.CleanupScan(req.ID); err != nil {
+		t.Fatalf("CleanupScan() error = %v", err)
+	}
+	if destroyed := workers.Destroyed(); len(destroyed) != 2 {
+		t.Errorf("destroyed %v, want both workers", destroyed)
+	}
+	if remaining := workers.Workers(); len(remaining) != 0 {
+		t.Errorf("provider still has %d workers", len(remaining))
+	}
+	for workerID, token := range tokens {
+		if o.ValidateWorkerToken(req.ID, workerID, token) {
+			t.Errorf("ValidateWorkerToken() = true for %s after cleanup", workerID)
+		}
+	}
+
+	// The scan is kept, and cleaning it up again destroys nothing more
+	if _, err := o.GetScanStatus(req.ID); err != nil {
+		t.Errorf("GetScanStatus() error = %v after cleanup", err)
+	}
+	if err := o.CleanupScan(req.ID); err != nil {
+		t.Fatalf("CleanupScan() error = %v", err)
+	}
+	if destroyed := workers.Destroyed(); len(destroyed) != 2 {
+		t.Errorf("destroyed %v after a second cleanup, want the same two", destroyed)
+	}
+	if err := o.CleanupScan("unknown"); err != nil {
+		t.Errorf("CleanupScan() error = %v for an unknown scan", err)
+	}
+}
+
+// preflightTargets returns a target answering on a local port and one whose
+// port is closed
+func preflightTargets(t *testing.T) (string, string) {
+	t.Helper()
+	alive, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { alive.Close() })
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	return alive.Addr().String(), closed.Addr().String()
+}
+
+// waitForStatus waits until a scan's status is one of statuses
+func waitForStatus(t *testing.T, o *Orchestrator, scanID string, statuses ...string) *types.ScanStatus {
+	t.Helper()
+	var status *types.ScanStatus
+	eventually(t, fmt.Sprintf("the scan is %v", statuses), func() bool {
+		var err error
+		if status, err = o.GetScanStatus(scanID); err != nil {
+			t.Fatalf("GetScanStatus() error = %v", err)
+		}
+		return slices.Contains(statuses, status.Status)
+	})
+	return status
+}
+
+func TestStartScanPreflight(t *testing.T) {
+	o, workers, _ := newTestOrchestrator(t)
+	alive, dead := preflightTargets(t)
+	req := &types.ScanRequest{Domains: []string{alive, dead}, Droplets: 1, Preflight: true}
+	plan, err := o.StartScan(context.Background(), req)
+	if err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	// The plan is returned before the targets are checked
+	if plan.Targets.Preflight != nil || len(plan.ChunkSizes) != 0 {
+		t.Errorf("StartScan() planned droplets before preflight: %+v", plan)
+	}
+
+	status := waitForWorkers(t, o, req.ID, 1)
+	if status.Preflight == nil || status.Preflight.Checked != 2 || status.Preflight.DeadCount != 1 ||
+		len(status.Preflight.Dead) != 1 || status.Preflight.Dead[0].Target != dead {
+		t.Fatalf("status preflight = %+v, want %s dead", status.Preflight, dead)
+	}
+	if status.TotalDomains != 1 || status.Plan == nil || status.Plan.Targets.Preflight == nil {
+		t.Errorf("scan planned %d targets with plan %+v, want the 1 alive", status.TotalDomains, status.Plan)
+	}
+	if created := workers.Workers(); len(created) != 1 {
+		t.Errorf("provider has %d workers, want 1", len(created))
+	}
+	o.mutex.RLock()
+	entry := o.scanStates[req.ID].coverage.targets[dead]
+	o.mutex.RUnlock()
+	if entry == nil || entry.state != TargetDead {
+		t.Errorf("coverage of the dead target = %+v, want dead", entry)
+	}
+}
+
+func TestStartScanPreflightAllDead(t *testing.T) {
+	o, workers, _ := newTestOrchestrator(t)
+	_, dead := preflightTargets(t)
+	req := &types.ScanRequest{Domains: []string{dead}, Droplets: 1, Preflight: true}
+	if _, err := o.StartScan(context.Background(), req); err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+
+	status := waitForStatus(t, o, req.ID, ScanFailed)
+	if status.Preflight == nil || status.Preflight.DeadCount != 1 {
+		t.Errorf("status preflight = %+v, want the dead target", status.Preflight)
+	}
+	if len(status.Warnings) != 1 || !strings.Contains(status.Warnings[0], "all 1 targets dead") {
+		t.Errorf("warnings = %q, want why the scan failed", status.Warnings)
+	}
+	if created := workers.Workers(); len(created) != 0 {
+		t.Errorf("provider has %d workers, want none", len(created))
+	}
+}
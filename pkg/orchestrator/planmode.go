@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// Planning modes, see types.ScanRequest.Mode
+const (
+	PlanBalanced = "balanced"
+	PlanFast     = "fast"
+	PlanCheap    = "cheap"
+)
+
+// planModes lists the planning modes in the order plans show them
+var planModes = []string{PlanBalanced, PlanFast, PlanCheap}
+
+// resolvePlanMode defaults a scan's planning mode to balanced and checks
+// the deadline only comes with cheap
+func resolvePlanMode(req *types.ScanRequest) error {
+	switch req.Mode {
+	case "":
+		req.Mode = PlanBalanced
+	case PlanBalanced, PlanFast, PlanCheap:
+	default:
+		return fmt.Errorf("%w: mode must be balanced, fast or cheap", ErrInvalidScan)
+	}
+	if req.DeadlineMinutes < 0 {
+		return fmt.Errorf("%w: deadlineMinutes must not be negative", ErrInvalidScan)
+	}
+	if req.DeadlineMinutes > 0 && req.Mode != PlanCheap {
+		return fmt.Errorf("%w: deadlineMinutes only applies to cheap mode", ErrInvalidScan)
+	}
+	return nil
+}
+
+// modeDroplets picks the droplet count for a planning mode within the
+// optimizer's limits. Cheap scans that no count finishes by the deadline
+// get the most droplets, with a warning saying so.
+func (so *ScanOptimizer) modeDroplets(mode string, totalDomains, requested, deadline int, headless bool) (int, string) {
+	if totalDomains == 0 {
+		return 0, ""
+	}
+	most := totalDomains
+	if so.MaxDroplets > 0 {
+		most = so.MaxDroplets
+	}
+	fastest := so.calculateOptimalDroplets(totalDomains, most)
+
+	switch mode {
+	case PlanFast:
+		return fastest, ""
+	case PlanCheap:
+		fewest := so.calculateOptimalDroplets(totalDomains, 0)
+		if deadline == 0 {
+			return fewest, ""
+		}
+		for droplets := fewest; droplets <= fastest; droplets++ {
+			// Counts the limits would change are not on offer
+			if so.calculateOptimalDroplets(totalDomains, droplets) != droplets {
+				continue
+			}
+			if minutes, _ := so.estimateMinutes(evenChunk(totalDomains, droplets), headless); minutes <= float64(deadline) {
+				return droplets, ""
+			}
+		}
+		minutes, _ := so.estimateMinutes(evenChunk(totalDomains, fastest), headless)
+		return fastest, fmt.Sprintf("no droplet count within the limits finishes within %d minutes, planned %d "+
+			"droplets taking about %.0f", deadline, fastest, minutes)
+	default:
+		return so.calculateOptimalDroplets(totalDomains, requested), ""
+	}
+}
+
+// planAlternatives estimates what the planning modes other than mode would
+// run a scan on, as if its targets were spread evenly. Cheap is shown
+// without a deadline.
+func (so *ScanOptimizer) planAlternatives(mode string, totalDomains, requested int, headless bool) []types.PlanAlternative {
+	var alternatives []types.PlanAlternative
+	for _, other := range planModes {
+		if other == mode {
+			continue
+		}
+		droplets, _ := so.modeDroplets(other, totalDomains, requested, 0, headless)
+		minutes, _ := so.estimateMinutes(evenChunk(totalDomains, droplets), headless)
+		alternatives = append(alternatives, types.PlanAlternative{
+			Mode:             other,
+			Droplets:         droplets,
+			EstimatedMinutes: minutes,
+			EstimatedCost:    estimateCost(droplets, so.PriceHourly, minutes),
+		})
+	}
+	return alternatives
+}
+
+// evenChunk is the largest chunk of targets spread evenly over droplets
+func evenChunk(targets, droplets int) int {
+	if droplets < 1 {
+		return targets
+	}
+	return (targets + droplets - 1) / droplets
+}
@@ -0,0 +1,432 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/types"
+)
+
+// Target distribution modes, see types.ScanRequest.Distribution
+const (
+	DistributionStatic = "static"
+	DistributionPull   = "pull"
+)
+
+const (
+	// DefaultBatchSize is the number of targets a pull worker leases at a
+	// time unless the scan sets its own
+	DefaultBatchSize = 25
+	// MaxBatchSize is the most targets a worker may lease at once
+	MaxBatchSize = 1000
+	// targetLeaseTimeout is how long a worker may send neither heartbeats
+	// nor logs before its batches go back to the pool. It is longer than
+	// workerStallTimeout, so a worker stalls before it loses its targets.
+	targetLeaseTimeout = 15 * time.Minute
+	// poolPushSize is the number of targets pushed to Redis per command
+	poolPushSize = 1000
+)
+
+// ErrLeaseLost is returned when a worker confirms a batch it does not hold,
+// usually because the lease expired and the batch went back to the pool
+var ErrLeaseLost = errors.New("batch is not leased to the worker")
+
+// poolTargetsKey is the Redis list of a pull scan's targets waiting to be
+// leased
+func poolTargetsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:targets", scanID)
+}
+
+// poolLeasesKey is the Redis hash of a pull scan's leased batches by ID
+func poolLeasesKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:leases", scanID)
+}
+
+// targetLease is a batch leased to a worker, as kept in Redis
+type targetLease struct {
+	WorkerID string    `json:"workerId"`
+	Targets  []string  `json:"targets"`
+	LeasedAt time.Time `json:"leasedAt"`
+}
+
+// resolveDistribution checks a scan's distribution settings and fills in
+// their defaults
+func resolveDistribution(req *types.ScanRequest) error {
+	switch req.Distribution {
+	case "":
+		req.Distribution = DistributionStatic
+	case DistributionStatic, DistributionPull:
+	default:
+		return fmt.Errorf("%w: distribution must be static or pull", ErrInvalidScan)
+	}
+	if req.BatchSize < 0 || req.BatchSize > MaxBatchSize {
+		return fmt.Errorf("%w: batch size must be between 1 and %d", ErrInvalidScan, MaxBatchSize)
+	}
+
+	if req.Distribution == DistributionStatic {
+		if req.BatchSize != 0 {
+			return fmt.Errorf("%w: batch size only applies to pull distribution", ErrInvalidScan)
+		}
+		return nil
+	}
+	// Workers take whatever batch comes next, so names cannot be kept together
+	if req.GroupByApex {
+		return fmt.Errorf("%w: groupByApex needs static distribution", ErrInvalidScan)
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = DefaultBatchSize
+	}
+	return nil
+}
+
+// fillPool replaces a scan's pool with its targets, in order
+func (o *Orchestrator) fillPool(ctx context.Context, scanID string, targets []string) error {
+	pipe := o.redis.TxPipeline()
+	pipe.Del(ctx, poolTargetsKey(scanID), poolLeasesKey(scanID))
+	pushPool(ctx, pipe, scanID, targets)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to queue targets: %v", err)
+	}
+	return nil
+}
+
+// pushPool queues targets at the end of a scan's pool
+func pushPool(ctx context.Context, pipe redis.Pipeliner, scanID string, targets []string) {
+	for start := 0; start < len(targets); start += poolPushSize {
+		end := min(start+poolPushSize, len(targets))
+		values := make([]interface{}, 0, end-start)
+		for _, target := range targets[start:end] {
+			values = append(values, target)
+		}
+		pipe.RPush(ctx, poolTargetsKey(scanID), values...)
+	}
+}
+
+// LeaseTargets leases the next count targets of a pull scan's pool to a
+// worker, or the scan's batch size when count is zero. The batch is the
+// worker's until it confirms it, gives up, or goes quiet for longer than
+// targetLeaseTimeout. An empty batch without Drained means other workers
+// still hold batches that may come back, so the worker should ask again.
+func (o *Orchestrator) LeaseTargets(scanID, workerID string, count int) (*types.TargetBatch, error) {
+	o.poolMutex.Lock()
+	defer o.poolMutex.Unlock()
+
+	o.mutex.RLock()
+	scan, exists := o.activeScans[scanID]
+	var pool *types.TargetPool
+	running := false
+	if exists {
+		pool = scan.Pool
+		running = !scanEnded(scan.Status)
+	}
+	o.mutex.RUnlock()
+	switch {
+	case !exists:
+		return nil, ErrScanNotFound
+	case pool == nil:
+		return nil, fmt.Errorf("%w: the scan does not use pull distribution", ErrInvalidScan)
+	case !running:
+		return nil, ErrScanNotRunning
+	}
+	if count <= 0 {
+		count = pool.BatchSize
+	}
+	count = min(count, MaxBatchSize)
+
+	ctx := context.Background()
+	pipe := o.redis.TxPipeline()
+	taken := pipe.LRange(ctx, poolTargetsKey(scanID), 0, int64(count-1))
+	pipe.LTrim(ctx, poolTargetsKey(scanID), int64(count), -1)
+	leased := pipe.HLen(ctx, poolLeasesKey(scanID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to lease targets: %v", err)
+	}
+
+	targets := taken.Val()
+	if len(targets) == 0 {
+		batch := &types.TargetBatch{Targets: []string{}, Drained: leased.Val() == 0}
+		if batch.Drained {
+			o.mutex.Lock()
+			if state := o.scanStates[scanID]; state != nil {
+				state.poolDrained = true
+			}
+			o.mutex.Unlock()
+		}
+		return batch, nil
+	}
+
+	batchID := uuid.New().String()
+	data, err := json.Marshal(targetLease{WorkerID: workerID, Targets: targets, LeasedAt: time.Now()})
+	if err == nil {
+		err = o.redis.HSet(ctx, poolLeasesKey(scanID), batchID, data).Err()
+	}
+	if err != nil {
+		o.unpopTargets(ctx, scanID, targets)
+		return nil, fmt.Errorf("failed to record lease: %v", err)
+	}
+
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists && scan.Pool != nil {
+		scan.Pool.Queued -= len(targets)
+		scan.Pool.Leased += len(targets)
+		if state := o.scanStates[scanID]; state != nil {
+			state.coverage.assign(targets, workerID, TargetAssigned)
+		}
+		if worker := o.findWorker(scanID, workerID); worker != nil {
+			worker.TotalDomains += len(targets)
+			worker.DomainsAlive += len(targets)
+			o.setWorkerScanned(worker, worker.DomainsScanned)
+		}
+		updateScanProgress(scan)
+		o.scanChanged(scan)
+	}
+	o.mutex.Unlock()
+
+	slog.Debug("Leased targets", "scan_id", scanID, "worker_id", workerID, "batch_id", batchID, "targets", len(targets))
+	return &types.TargetBatch{BatchID: batchID, Targets: targets}, nil
+}
+
+// unpopTargets puts targets taken from a scan's pool back at its head, in
+// their order. Callers must hold o.poolMutex.
+func (o *Orchestrator) unpopTargets(ctx context.Context, scanID string, targets []string) {
+	values := make([]interface{}, 0, len(targets))
+	for i := len(targets) - 1; i >= 0; i-- {
+		values = append(values, targets[i])
+	}
+	if err := o.redis.LPush(ctx, poolTargetsKey(scanID), values...).Err(); err != nil {
+		slog.Error("Targets lost from the pool", "scan_id", scanID, "targets", len(targets), "error", err)
+	}
+}
+
+// ConfirmBatch records that a worker scanned a batch it leased
+func (o *Orchestrator) ConfirmBatch(scanID, workerID, batchID string) error {
+	o.poolMutex.Lock()
+	defer o.poolMutex.Unlock()
+
+	o.mutex.RLock()
+	_, exists := o.activeScans[scanID]
+	o.mutex.RUnlock()
+	if !exists {
+		return ErrScanNotFound
+	}
+
+	ctx := context.Background()
+	data, err := o.redis.HGet(ctx, poolLeasesKey(scanID), batchID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read lease: %v", err)
+	}
+	var lease targetLease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return fmt.Errorf("failed to read lease: %v", err)
+	}
+	if lease.WorkerID != workerID {
+		return ErrLeaseLost
+	}
+	if err := o.redis.HDel(ctx, poolLeasesKey(scanID), batchID).Err(); err != nil {
+		return fmt.Errorf("failed to confirm batch: %v", err)
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if scan, exists := o.activeScans[scanID]; exists && scan.Pool != nil {
+		scan.Pool.Leased -= len(lease.Targets)
+		scan.Pool.Confirmed += len(lease.Targets)
+		if state := o.scanStates[scanID]; state != nil {
+			state.confirmedTargets[workerID] += len(lease.Targets)
+			state.coverage.complete(lease.Targets)
+			if worker := o.findWorker(scanID, workerID); worker != nil {
+				o.setWorkerScanned(worker, max(worker.DomainsScanned, state.confirmedTargets[workerID]))
+			}
+		}
+		updateScanProgress(scan)
+		o.scanChanged(scan)
+	}
+	return nil
+}
+
+// reclaimLeases puts the batches of the leases reclaim picks back at the
+// head of a scan's pool, so they are leased next, and returns the targets
+// each worker lost. Callers must hold o.poolMutex.
+func (o *Orchestrator) reclaimLeases(ctx context.Context, scanID string, reclaim func(targetLease) bool) (map[string][]string, error) {
+	leases, err := o.redis.HGetAll(ctx, poolLeasesKey(scanID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leases: %v", err)
+	}
+
+	returned := make(map[string][]string)
+	for batchID, data := range leases {
+		var lease targetLease
+		if err := json.Unmarshal([]byte(data), &lease); err != nil || !reclaim(lease) {
+			continue
+		}
+		deleted, err := o.redis.HDel(ctx, poolLeasesKey(scanID), batchID).Result()
+		if err != nil {
+			return returned, fmt.Errorf("failed to reclaim lease: %v", err)
+		}
+		if deleted == 0 {
+			continue
+		}
+		o.unpopTargets(ctx, scanID, lease.Targets)
+		returned[lease.WorkerID] = append(returned[lease.WorkerID], lease.Targets...)
+	}
+	return returned, nil
+}
+
+// leasesReturned moves returned targets from their workers back to the
+// pool's count. Callers must hold o.mutex.
+func (o *Orchestrator) leasesReturned(scanID string, returned map[string][]string, reason string) {
+	scan, exists := o.activeScans[scanID]
+	if !exists || scan.Pool == nil || len(returned) == 0 {
+		return
+	}
+	for workerID, targets := range returned {
+		count := len(targets)
+		if state := o.scanStates[scanID]; state != nil {
+			state.coverage.assign(targets, "", TargetPending)
+		}
+		scan.Pool.Leased -= count
+		scan.Pool.Queued += count
+		scan.Pool.Returned += count
+		if worker := o.findWorker(scanID, workerID); worker != nil {
+			worker.TotalDomains -= count
+			worker.DomainsAlive -= count
+			o.setWorkerScanned(worker, worker.DomainsScanned)
+		}
+		slog.Warn("Returned leased targets to the pool", "scan_id", scanID, "worker_id", workerID, "targets", count, "reason", reason)
+	}
+	updateScanProgress(scan)
+	o.scanChanged(scan)
+}
+
+// releaseWorkerLeases returns the batches a finished worker still holds to
+// the pool, for the scan's other workers
+func (o *Orchestrator) releaseWorkerLeases(scanID, workerID string) {
+	o.poolMutex.Lock()
+	defer o.poolMutex.Unlock()
+
+	o.mutex.RLock()
+	scan, exists := o.activeScans[scanID]
+	pull := exists && scan.Pool != nil
+	o.mutex.RUnlock()
+	if !pull {
+		return
+	}
+
+	returned, err := o.reclaimLeases(context.Background(), scanID, func(lease targetLease) bool {
+		return lease.WorkerID == workerID
+	})
+	if err != nil {
+		slog.Error("Failed to return a finished worker's batches", "scan_id", scanID, "worker_id", workerID, "error", err)
+	}
+
+	o.mutex.Lock()
+	o.leasesReturned(scanID, returned, "worker finished")
+	o.mutex.Unlock()
+}
+
+// runLeaseChecks returns the batches of workers that went quiet to the pool.
+// Of several replicas the leader runs the check.
+func (o *Orchestrator) runLeaseChecks() {
+	ticker := time.NewTicker(workerStallCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		o.leaderCheck(checkLeases, time.Now())
+	}
+}
+
+// checkLeases returns every batch whose worker has not been heard from for
+// targetLeaseTimeout since leasing it, or has finished
+func (o *Orchestrator) checkLeases(now time.Time) {
+	o.poolMutex.Lock()
+	defer o.poolMutex.Unlock()
+
+	// Workers' last signs of life, per pull scan still running
+	seen := make(map[string]map[string]time.Time)
+	finished := make(map[string]bool)
+	o.mutex.RLock()
+	for scanID, scan := range o.activeScans {
+		state := o.scanStates[scanID]
+		if scan.Pool == nil || scanEnded(scan.Status) || state == nil {
+			continue
+		}
+		workers := make(map[string]time.Time, len(state.workerSeen))
+		for workerID, at := range state.workerSeen {
+			workers[workerID] = at
+		}
+		seen[scanID] = workers
+		for _, worker := range scan.ActiveDroplets {
+			if worker.Status == "completed" || worker.Status == "failed" {
+				finished[workerKey(scanID, worker.ID)] = true
+			}
+		}
+	}
+	o.mutex.RUnlock()
+
+	ctx := context.Background()
+	for scanID, workers := range seen {
+		returned, err := o.reclaimLeases(ctx, scanID, func(lease targetLease) bool {
+			last := workers[lease.WorkerID]
+			if last.Before(lease.LeasedAt) {
+				last = lease.LeasedAt
+			}
+			return finished[workerKey(scanID, lease.WorkerID)] || now.Sub(last) >= targetLeaseTimeout
+		})
+		if err != nil {
+			slog.Error("Failed to check leases", "scan_id", scanID, "error", err)
+		}
+		if len(returned) > 0 {
+			o.mutex.Lock()
+			o.leasesReturned(scanID, returned, "lease expired")
+			o.mutex.Unlock()
+		}
+	}
+}
+
+// queuePoolTargets adds targets to a pull scan's pool. The running workers
+// lease them like the rest; when none is left to, a new worker is planned.
+// Callers must hold o.poolMutex and o.mutex and start the planned worker
+// once they are released.
+func (o *Orchestrator) queuePoolTargets(scan *types.ScanStatus, state *scanState, targets []string) (*plannedWorker, error) {
+	running := false
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status != "completed" && worker.Status != "failed" {
+			running = true
+		}
+	}
+	// Workers told the pool was drained are on their way out
+	ownWorker := !running || state.poolDrained
+	if ownWorker && secretsDeleted(scan, state) {
+		return nil, fmt.Errorf("%w: no worker is left to lease them and the scan's secrets were deleted once fetched, so none can be added",
+			ErrInvalidScan)
+	}
+
+	ctx := context.Background()
+	pipe := o.redis.TxPipeline()
+	pushPool(ctx, pipe, scan.ID, targets)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to queue targets: %v", err)
+	}
+	scan.Pool.Queued += len(targets)
+	state.coverage.assign(targets, "", TargetPending)
+	if !ownWorker {
+		return nil, nil
+	}
+
+	state.poolDrained = false
+	index := scan.Plan.Droplets
+	scan.Plan.Droplets++
+	return &plannedWorker{
+		index:   index,
+		id:      workerName(scan.ID, index),
+		request: *state.request,
+	}, nil
+}
@@ -0,0 +1,390 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"nuclei-distributed/pkg/types"
+)
+
+// Probes preflight may run on the targets that resolve: none stops at DNS,
+// tcp connects to their ports and http expects any HTTP response
+const (
+	PreflightProbeNone = "none"
+	PreflightProbeTCP  = "tcp"
+	PreflightProbeHTTP = "http"
+)
+
+const (
+	// DefaultPreflightConcurrency is the number of targets checked at once
+	DefaultPreflightConcurrency = 50
+	// DefaultPreflightResolveTimeout bounds each DNS lookup
+	DefaultPreflightResolveTimeout = 3 * time.Second
+	// DefaultPreflightProbeTimeout bounds each connection or request
+	DefaultPreflightProbeTimeout = 3 * time.Second
+	// maxPreflightConcurrency keeps the server's own sockets in check
+	maxPreflightConcurrency = 1000
+)
+
+// ScanPreflight is the status of a scan started with preflight while its
+// targets are checked, before it is planned
+const ScanPreflight = "preflight"
+
+// preflightConfig is the parsed form of types.PreflightSettings
+type preflightConfig struct {
+	concurrency    int
+	resolveTimeout time.Duration
+	probe          string
+	probeTimeout   time.Duration
+	skipIPOnly     bool
+}
+
+// parsePreflightSettings validates preflight settings and fills in the
+// defaults of those left unset
+func parsePreflightSettings(settings *types.PreflightSettings) (preflightConfig, error) {
+	cfg := preflightConfig{
+		concurrency: settings.Concurrency,
+		probe:       settings.Probe,
+		skipIPOnly:  settings.SkipIPOnly,
+	}
+	if cfg.concurrency < 0 || cfg.concurrency > maxPreflightConcurrency {
+		return cfg, fmt.Errorf("concurrency must be between 1 and %d, or 0 for the default", maxPreflightConcurrency)
+	}
+	if cfg.concurrency == 0 {
+		cfg.concurrency = DefaultPreflightConcurrency
+	}
+	settings.Concurrency = cfg.concurrency
+
+	switch cfg.probe {
+	case "":
+		cfg.probe = PreflightProbeTCP
+	case PreflightProbeNone, PreflightProbeTCP, PreflightProbeHTTP:
+	default:
+		return cfg, fmt.Errorf("probe must be none, tcp or http")
+	}
+	settings.Probe = cfg.probe
+
+	durations := []struct {
+		name     string
+		setting  *string
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{"resolveTimeout", &settings.ResolveTimeout, &cfg.resolveTimeout, DefaultPreflightResolveTimeout},
+		{"probeTimeout", &settings.ProbeTimeout, &cfg.probeTimeout, DefaultPreflightProbeTimeout},
+	}
+	for _, d := range durations {
+		*d.value = d.fallback
+		if *d.setting != "" {
+			parsed, err := time.ParseDuration(*d.setting)
+			if err != nil || parsed <= 0 {
+				return cfg, fmt.Errorf("%s must be a positive duration such as 3s", d.name)
+			}
+			*d.value = parsed
+		}
+		*d.setting = d.value.String()
+	}
+	return cfg, nil
+}
+
+// scanPreflight carries what the preflight check of a scan's targets found
+// into its planning
+type scanPreflight struct {
+	startedAt  time.Time
+	finishedAt time.Time
+	link       trace.Link // to the request that started the scan
+	summary    *types.PreflightSummary
+	dead       []string
+}
+
+// startPreflight answers a scan started with preflight right away, as
+// preflight, and plans it in the background once its targets are checked.
+// The request is the one submitted, req the one checked, as for
+// startEnumeration. An enumerated scan moves on from enumerating.
+func (o *Orchestrator) startPreflight(ctx context.Context, request types.ScanRequest, req *types.ScanRequest,
+	targets *types.TargetSummary, enumeration *scanEnumeration) (*types.ScanPlan, error) {
+	check := &scanPreflight{
+		startedAt: time.Now().UTC(),
+		link:      trace.LinkFromContext(ctx),
+	}
+	plan := &types.ScanPlan{
+		Mode:         req.Mode,
+		Distribution: req.Distribution,
+		Targets:      targets,
+		Warnings: []string{fmt.Sprintf("droplets are planned once preflight has checked the %d targets",
+			len(req.Domains))},
+	}
+
+	if enumeration != nil {
+		o.mutex.Lock()
+		scan, exists := o.activeScans[req.ID]
+		if !exists || scan.Status != ScanEnumerating {
+			o.mutex.Unlock()
+			return nil, ErrScanNotFound
+		}
+		scan.Status = ScanPreflight
+		scan.TotalDomains = len(req.Domains)
+		scan.DomainsAlive = len(req.Domains)
+		o.scanChanged(scan)
+		o.mutex.Unlock()
+	} else {
+		log := o.scanLogs.start(req.ID)
+
+		// Like enumerating scans, scans in preflight have no plan or workers
+		// yet
+		o.mutex.Lock()
+		o.activeScans[req.ID] = &types.ScanStatus{
+			ID:             req.ID,
+			CreatedAt:      check.startedAt,
+			ActiveDroplets: make([]*types.WorkerStatus, 0),
+			Results:        make([]types.ScanResult, 0),
+			SeverityCounts: make(map[string]int),
+			TotalDomains:   len(req.Domains),
+			DomainsAlive:   len(req.Domains),
+			Status:         ScanPreflight,
+			RerunOf:        req.RerunOf,
+			Held:           req.HoldUntilRelease,
+		}
+		o.replicateScan(req.ID)
+		state := newScanState()
+		state.targets = req.Domains
+		config := *req
+		config.Domains = nil
+		state.request = &config
+		state.log = log
+		o.scanStates[req.ID] = state
+		record := scanRecord(o.activeScans[req.ID], state)
+		o.mutex.Unlock()
+
+		o.saveScanRecord(record)
+	}
+	slog.Info("Checking targets", "scan_id", req.ID, "targets", len(req.Domains))
+
+	go o.runPreflight(request, append([]string(nil), req.Domains...), enumeration, check)
+	return plan, nil
+}
+
+// runPreflight checks a scan's targets, then plans and starts the scan
+// without those found dead. A scan whose targets are all dead ends failed.
+func (o *Orchestrator) runPreflight(request types.ScanRequest, targets []string, enumeration *scanEnumeration,
+	check *scanPreflight) {
+	ctx := context.Background()
+	_, dead, summary, err := o.preflight(ctx, request.ID, targets)
+	if err != nil {
+		o.preflightFailed(request.ID, err.Error())
+		return
+	}
+
+	o.mutex.Lock()
+	check.finishedAt = time.Now()
+	check.summary = summary
+	for _, target := range dead {
+		check.dead = append(check.dead, target.Target)
+	}
+	if scan, exists := o.activeScans[request.ID]; exists && scan.Status == ScanPreflight {
+		scan.Preflight = summary
+		o.scanChanged(scan)
+	}
+	o.mutex.Unlock()
+	if len(dead) == len(targets) {
+		o.preflightFailed(request.ID, fmt.Sprintf("preflight found all %d targets dead", len(dead)))
+		return
+	}
+
+	req := request
+	if _, err := o.startScan(ctx, &req, enumeration, check); err != nil {
+		o.preflightFailed(request.ID, fmt.Sprintf("the scan could not be started after preflight: %v", err))
+	}
+}
+
+// preflightFailed ends a scan in preflight that could not be started, with
+// the reason as a warning
+func (o *Orchestrator) preflightFailed(scanID, reason string) {
+	slog.Error("Failed to start scan after preflight", "scan_id", scanID, "reason", reason)
+	o.phaseFailed(scanID, ScanPreflight, reason)
+}
+
+// preflight checks a scan's targets before any droplet is created for them,
+// a bounded number at a time, and returns those alive in their order with
+// the dead ones. A target is dead when its host does not resolve or, with a
+// probe, does not answer on any port it would be scanned on. Scans of
+// addresses alone are let through unchecked when the settings say so.
+func (o *Orchestrator) preflight(ctx context.Context, scanID string, targets []string) ([]string, []types.DeadTarget, *types.PreflightSummary, error) {
+	cfg := o.settings().preflight
+	summary := &types.PreflightSummary{Probe: cfg.probe}
+	if cfg.skipIPOnly && addressesOnly(targets) {
+		summary.Skipped = "every target is an IP address"
+		return targets, nil, summary, nil
+	}
+
+	start := time.Now()
+	var client *http.Client
+	if cfg.probe == PreflightProbeHTTP {
+		client = preflightClient(cfg.probeTimeout)
+		defer client.CloseIdleConnections()
+	}
+	reasons := make([]string, len(targets))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(cfg.concurrency, len(targets)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				reasons[i] = checkPreflightTarget(ctx, cfg, client, targets[i])
+			}
+		}()
+	}
+feed:
+	for i := range targets {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("preflight of the targets was interrupted: %w", err)
+	}
+
+	alive := make([]string, 0, len(targets))
+	var dead []types.DeadTarget
+	for i, target := range targets {
+		if reasons[i] == "" {
+			alive = append(alive, target)
+			continue
+		}
+		dead = append(dead, types.DeadTarget{Target: target, Reason: reasons[i]})
+	}
+	summary.Checked = len(targets)
+	summary.DurationMs = time.Since(start).Milliseconds()
+	summary.DeadCount = len(dead)
+	summary.Dead = dead[:min(len(dead), maxReportedRejections)]
+	slog.Info("Preflight checked targets", "scan_id", scanID, "targets", len(targets), "dead", len(dead),
+		"probe", cfg.probe, "duration_ms", summary.DurationMs)
+	return alive, dead, summary, nil
+}
+
+// addressesOnly reports whether every target is an IP address, with or
+// without a port or scheme
+func addressesOnly(targets []string) bool {
+	for _, target := range targets {
+		if net.ParseIP(hostname(target)) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPreflightTarget returns why a target is dead, or nothing when it is
+// alive. The client makes the HTTP probe's requests.
+func checkPreflightTarget(ctx context.Context, cfg preflightConfig, client *http.Client, target string) string {
+	host, ports, schemes := preflightEndpoints(target)
+	if host == "" {
+		return "no host to check"
+	}
+
+	if net.ParseIP(host) == nil {
+		resolveCtx, cancel := context.WithTimeout(ctx, cfg.resolveTimeout)
+		addresses, err := net.DefaultResolver.LookupIPAddr(resolveCtx, host)
+		cancel()
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return "does not resolve"
+			}
+			if errors.As(err, &dnsErr) && dnsErr.IsTimeout {
+				return fmt.Sprintf("DNS lookup timed out after %s", cfg.resolveTimeout)
+			}
+			return fmt.Sprintf("DNS lookup failed: %v", err)
+		}
+		if len(addresses) == 0 {
+			return "does not resolve"
+		}
+	}
+
+	switch cfg.probe {
+	case PreflightProbeTCP:
+		dialer := net.Dialer{Timeout: cfg.probeTimeout}
+		for _, port := range ports {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+			if err == nil {
+				conn.Close()
+				return ""
+			}
+		}
+		return fmt.Sprintf("no answer on port %s", strings.Join(ports, " or "))
+	case PreflightProbeHTTP:
+		for _, endpoint := range schemes {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+			if err != nil {
+				continue
+			}
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				return ""
+			}
+		}
+		return "no HTTP response"
+	}
+	return ""
+}
+
+// preflightEndpoints splits a normalized target into its host, the ports a
+// TCP probe tries and the URLs an HTTP probe requests. A bare host is tried
+// on HTTPS first, then HTTP, as nuclei would.
+func preflightEndpoints(target string) (string, []string, []string) {
+	if strings.Contains(target, "://") {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return "", nil, nil
+		}
+		port := parsed.Port()
+		if port == "" {
+			port = "80"
+			if parsed.Scheme == "https" {
+				port = "443"
+			}
+		}
+		return parsed.Hostname(), []string{port}, []string{target}
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host = strings.Trim(target, "[]")
+		address := host
+		if strings.Contains(host, ":") {
+			address = "[" + host + "]"
+		}
+		return host, []string{"443", "80"}, []string{"https://" + address, "http://" + address}
+	}
+	return host, []string{port}, []string{"https://" + target, "http://" + target}
+}
+
+// preflightClient makes the HTTP probe's requests. It takes any certificate
+// and the first response, as any answer means the target is alive.
+func preflightClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
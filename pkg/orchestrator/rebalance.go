@@ -0,0 +1,266 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// DefaultRebalanceThreshold is the progress, in percent, below which a
+	// worker hands targets to idle ones unless configured otherwise
+	DefaultRebalanceThreshold = 50
+	// workerSegmentSize is the number of targets a worker of a static scan
+	// hands nuclei at a time, reporting them scanned after each segment
+	workerSegmentSize = 50
+	// rebalanceBuffer is the number of targets a slow worker keeps queued
+	// after the segment it is scanning, so it never gives away targets it
+	// is about to start on
+	rebalanceBuffer = workerSegmentSize
+	// idleWorkerWindow is how recently a finished worker must have asked
+	// for targets to be handed more; they ask every 30 seconds
+	idleWorkerWindow = 2 * time.Minute
+	// rebalanceCheck is how often slow workers are looked for
+	rebalanceCheck = time.Minute
+)
+
+// ErrReleaseRefused is returned when a worker hands back targets it was not
+// asked for, or that no idle worker is left to take
+var ErrReleaseRefused = errors.New("targets cannot be handed to another worker")
+
+// releaseRequest is what a rebalance pass asked of a slow worker
+type releaseRequest struct {
+	targets int
+	trigger string
+}
+
+// Rebalance asks the slow workers of a static scan to hand untouched targets
+// to workers that finished theirs, however far along they are. Pull scans
+// balance themselves.
+func (o *Orchestrator) Rebalance(scanID string) (*types.RebalancePass, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		return nil, ErrScanNotFound
+	}
+	if scanEnded(scan.Status) {
+		return nil, ErrScanNotRunning
+	}
+	if scan.Pool != nil {
+		return nil, fmt.Errorf("%w: workers of a pull scan already share its targets", ErrInvalidScan)
+	}
+	return o.rebalancePass(scan, state, "manual", 0, time.Now()), nil
+}
+
+// runRebalanceChecks looks for slow workers every rebalanceCheck
+func (o *Orchestrator) runRebalanceChecks() {
+	ticker := time.NewTicker(rebalanceCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		o.checkRebalance(time.Now())
+	}
+}
+
+// checkRebalance runs a rebalance pass over every running static scan,
+// for workers below the threshold, while rebalancing is automatic
+func (o *Orchestrator) checkRebalance(now time.Time) {
+	threshold := o.settings().RebalanceThreshold
+	if threshold <= 0 {
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for scanID, scan := range o.activeScans {
+		state := o.scanStates[scanID]
+		if state == nil || scanEnded(scan.Status) || scan.Pool != nil {
+			continue
+		}
+		o.rebalancePass(scan, state, "automatic", threshold, now)
+	}
+}
+
+// rebalancePass asks running workers below threshold percent, or all of
+// them when it is zero, to hand back part of their untouched targets at
+// their next checkpoint: enough that they and the idle workers end up with
+// about the same share. Callers must hold o.mutex.
+func (o *Orchestrator) rebalancePass(scan *types.ScanStatus, state *scanState, trigger string, threshold float64, now time.Time) *types.RebalancePass {
+	pass := &types.RebalancePass{Trigger: trigger, IdleWorkers: []string{}, Requested: map[string]int{}}
+	for _, worker := range o.idleWorkers(scan, state, "", now) {
+		pass.IdleWorkers = append(pass.IdleWorkers, worker.ID)
+	}
+	if len(pass.IdleWorkers) == 0 {
+		return pass
+	}
+
+	movable := make(map[string]int)
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status != "running" || (threshold > 0 && worker.Progress >= threshold) {
+			continue
+		}
+		// Heartbeats may be ahead of the reported segments. The segment
+		// being scanned and the buffer after it stay put.
+		scanned := max(worker.DomainsScanned, state.doneCounts[worker.ID])
+		untouched := worker.DomainsAlive - scanned - len(state.pendingTargets[worker.ID]) -
+			state.releases[worker.ID].targets
+		if count := untouched - workerSegmentSize - rebalanceBuffer; count >= workerSegmentSize {
+			movable[worker.ID] = count
+		}
+	}
+	for workerID, count := range movable {
+		move := count * len(pass.IdleWorkers) / (len(pass.IdleWorkers) + len(movable))
+		// Less than a segment is not worth another nuclei start
+		if move < workerSegmentSize {
+			continue
+		}
+		request := state.releases[workerID]
+		request.targets += move
+		request.trigger = trigger
+		state.releases[workerID] = request
+		pass.Requested[workerID] = move
+	}
+	if len(pass.Requested) > 0 {
+		slog.Info("Asked slow workers to hand back targets", "scan_id", scan.ID, "trigger", trigger,
+			"requested", pass.Requested, "idle_workers", pass.IdleWorkers)
+	}
+	return pass
+}
+
+// idleWorkers returns the workers of a scan that finished their targets but
+// still ask for more, other than except. Callers must hold o.mutex.
+func (o *Orchestrator) idleWorkers(scan *types.ScanStatus, state *scanState, except string, now time.Time) []*types.WorkerStatus {
+	var idle []*types.WorkerStatus
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status != "completed" || worker.ID == except {
+			continue
+		}
+		if seen, known := state.workerSeen[worker.ID]; known && now.Sub(seen) < idleWorkerWindow {
+			idle = append(idle, worker)
+		}
+	}
+	return idle
+}
+
+// ReportTargetsDone records the targets a worker of a static scan finished
+// scanning, with the number it still has queued, and returns how many of
+// those it should hand back for idle workers
+func (o *Orchestrator) ReportTargetsDone(scanID, workerID string, targets []string, queued int) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		return 0, ErrScanNotFound
+	}
+	worker := o.findWorker(scanID, workerID)
+	if worker == nil {
+		return 0, nil
+	}
+
+	for _, target := range targets {
+		// Segments are reported again after a reboot
+		if _, done := state.doneTargets[target]; !done {
+			state.doneTargets[target] = struct{}{}
+			state.doneCounts[workerID]++
+		}
+	}
+	state.coverage.complete(targets)
+	o.setWorkerScanned(worker, max(worker.DomainsScanned, state.doneCounts[workerID]))
+	o.workerSeen(scanID, worker)
+	o.workerEvent(scanID, types.WorkerProgress, worker)
+	updateScanProgress(scan)
+	o.scanChanged(scan)
+
+	request, asked := state.releases[workerID]
+	if !asked {
+		return 0, nil
+	}
+	release := min(request.targets, queued-rebalanceBuffer)
+	if release < 1 || scanEnded(scan.Status) || len(o.idleWorkers(scan, state, workerID, time.Now())) == 0 {
+		delete(state.releases, workerID)
+		return 0, nil
+	}
+	request.targets = release
+	state.releases[workerID] = request
+	return release, nil
+}
+
+// ReleaseTargets moves targets a slow worker handed back, as a rebalance
+// asked it to, to the scan's idle workers, which pull them like added
+// targets. The move is recorded in the scan's rebalances.
+func (o *Orchestrator) ReleaseTargets(scanID, workerID string, targets []string) (*types.Rebalance, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		return nil, ErrScanNotFound
+	}
+	worker := o.findWorker(scanID, workerID)
+	request, asked := state.releases[workerID]
+	if worker == nil || !asked || len(targets) == 0 || len(targets) > request.targets {
+		return nil, ErrReleaseRefused
+	}
+	for _, target := range targets {
+		if !state.scope.contains(target) {
+			return nil, fmt.Errorf("%w: %s is not a target of the scan", ErrInvalidScan, target)
+		}
+	}
+	delete(state.releases, workerID)
+	now := time.Now()
+	idle := o.idleWorkers(scan, state, workerID, now)
+	if scanEnded(scan.Status) || len(idle) == 0 {
+		return nil, ErrReleaseRefused
+	}
+
+	worker.TotalDomains -= len(targets)
+	worker.DomainsAlive -= len(targets)
+	o.setWorkerScanned(worker, worker.DomainsScanned)
+	o.workerSeen(scanID, worker)
+	o.workerEvent(scanID, types.WorkerProgress, worker)
+
+	rebalance := types.Rebalance{
+		From:      workerID,
+		To:        make(map[string]int),
+		Targets:   len(targets),
+		Trigger:   request.trigger,
+		Timestamp: now.UTC(),
+	}
+	for i, target := range targets {
+		taker := idle[i%len(idle)]
+		state.pendingTargets[taker.ID] = append(state.pendingTargets[taker.ID], target)
+		state.coverage.assign([]string{target}, taker.ID, TargetAssigned)
+		state.addedTargets[taker.ID]++
+		taker.TotalDomains++
+		taker.DomainsAlive++
+		rebalance.To[taker.ID]++
+	}
+	for _, taker := range idle {
+		if rebalance.To[taker.ID] == 0 {
+			continue
+		}
+		// Back to running, so the scan does not finish before it is done
+		taker.Status = "running"
+		state.workerSeen[taker.ID] = now
+		workerScanning(state, taker.ID, now)
+		o.setWorkerScanned(taker, taker.DomainsScanned)
+		o.workerEvent(scanID, types.WorkerProgress, taker)
+	}
+	scan.Rebalances = append(scan.Rebalances, rebalance)
+	o.queueScanEvent(scanID, types.TargetsRebalanced, &rebalance)
+	updateScanProgress(scan)
+	o.scanChanged(scan)
+
+	slog.Info("Rebalanced targets", "scan_id", scanID, "from", workerID, "to", rebalance.To,
+		"targets", len(targets), "trigger", request.trigger)
+	return &rebalance, nil
+}
@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// scanRecord builds the durable record of an in-memory scan. Callers must
+// hold o.mutex.
+func scanRecord(scan *types.ScanStatus, state *scanState) types.ScanRecord {
+	record := types.ScanRecord{
+		ID:             scan.ID,
+		Status:         scan.Status,
+		CreatedAt:      scan.CreatedAt,
+		CompletedAt:    scan.CompletedAt,
+		TotalDomains:   scan.TotalDomains,
+		ScannedDomains: scan.ScannedDomains,
+		Workers:        len(scan.ActiveDroplets),
+		ResultCount:    scan.ResultCount,
+		SeverityCounts: make(map[string]int),
+		Artifacts:      append([]types.Artifact(nil), scan.Artifacts...),
+		Pinned:         scan.Pinned,
+		TriageCounts:   copyTriageCounts(scan.TriageCounts),
+	}
+	record.OutOfScopeResults = scan.OutOfScopeResults
+	record.BlockedResults = scan.BlockedResults
+	record.RerunOf = scan.RerunOf
+	record.Degraded = scan.Degraded
+	record.FailureSummary = scan.FailureSummary
+	record.ActualCost = accrue(scan.ActualCost, scanEnded(scan.Status), time.Now())
+	if scan.EmailReport != nil {
+		report := *scan.EmailReport
+		record.EmailReport = &report
+	}
+	for severity, count := range scan.SeverityCounts {
+		record.SeverityCounts[severity] = count
+	}
+	if scan.Coverage != nil {
+		coverage := *scan.Coverage
+		record.Coverage = &coverage
+	}
+	if state != nil {
+		record.Targets = state.targets
+		record.Config = state.request
+		record.Discovered = state.discovered
+		if scan.Coverage != nil && state.coverage != nil {
+			record.Uncovered = state.coverage.uncovered()
+		}
+		if state.log != nil {
+			record.Log = state.log.snapshot()
+		}
+	}
+	return record
+}
+
+// saveScanRecord writes a scan's record to storage. Failures are logged
+// rather than returned since the scan itself carries on in memory.
+func (o *Orchestrator) saveScanRecord(record types.ScanRecord) {
+	if err := o.store.SaveScan(record); err != nil {
+		slog.Error("Failed to save scan record", "scan_id", record.ID, "error", err)
+		o.alerts.storageFailed("save a scan record", err)
+	}
+}
+
+// GetScanRecord returns the record of a scan, live for scans in memory and
+// from storage for scans that are not, e.g. after a restart
+func (o *Orchestrator) GetScanRecord(scanID string) (*types.ScanRecord, error) {
+	o.mutex.RLock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		record := scanRecord(scan, o.scanStates[scanID])
+		o.mutex.RUnlock()
+		return &record, nil
+	}
+	o.mutex.RUnlock()
+
+	record, err := o.store.GetScan(scanID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrScanNotFound
+	}
+	if err == nil {
+		// Leaked droplets of scans no longer tracked still accrue
+		record.ActualCost = accrue(record.ActualCost, scanEnded(record.Status), time.Now())
+	}
+	return record, err
+}
+
+// ListScans returns every known scan newest first, with live values for the
+// scans still in memory, here or on other replicas
+func (o *Orchestrator) ListScans() ([]types.ScanRecord, error) {
+	stored, err := o.store.ListScans()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := o.replicaScans()
+	if err != nil {
+		slog.Warn("Failed to read the scans of other replicas", "error", err)
+	}
+
+	o.mutex.RLock()
+	live := make(map[string]types.ScanRecord, len(o.activeScans)+len(remote))
+	for _, scan := range remote {
+		live[scan.ID] = scanRecord(scan, nil)
+	}
+	for id, scan := range o.activeScans {
+		live[id] = scanRecord(scan, nil)
+	}
+	o.mutex.RUnlock()
+
+	now := time.Now()
+	records := make([]types.ScanRecord, 0, len(stored)+len(live))
+	for _, record := range stored {
+		if current, exists := live[record.ID]; exists {
+			record = current
+			delete(live, record.ID)
+		} else {
+			record.ActualCost = accrue(record.ActualCost, scanEnded(record.Status), now)
+		}
+		records = append(records, record)
+	}
+	for _, record := range live {
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records, nil
+}
+
+// RunningScans returns the status of every scan that has not completed,
+// here or on other replicas, oldest first
+func (o *Orchestrator) RunningScans() []*types.ScanStatus {
+	remote, err := o.replicaScans()
+	if err != nil {
+		slog.Warn("Failed to read the scans of other replicas", "error", err)
+	}
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	running := make([]*types.ScanStatus, 0, len(o.activeScans)+len(remote))
+	for _, scan := range o.activeScans {
+		if !scanEnded(scan.Status) {
+			running = append(running, statusSnapshot(scan))
+		}
+	}
+	for _, scan := range remote {
+		if _, local := o.activeScans[scan.ID]; !local && !scanEnded(scan.Status) {
+			running = append(running, scan)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].CreatedAt.Before(running[j].CreatedAt) })
+	return running
+}
+
+// QueryResults returns the results of a scan that match filter. A PageSize of
+// zero returns every match on a single page.
+func (o *Orchestrator) QueryResults(scanID string, filter types.ResultFilter) (*types.ResultPage, error) {
+	if _, err := o.GetScanRecord(scanID); err != nil {
+		return nil, err
+	}
+	return o.store.QueryResults(scanID, filter)
+}
+
+// Orders GetHosts can sort affected hosts in
+const (
+	HostsBySeverity = "severity" // most severe first, then most findings
+	HostsByCount    = "count"    // most findings first, then most severe
+	HostsByHost     = "host"     // host name, ascending
+)
+
+// GetHosts returns one summary per host with findings in a scan, read from
+// the host index kept at ingest
+func (o *Orchestrator) GetHosts(scanID, order string) ([]types.HostSummary, error) {
+	if _, err := o.GetScanRecord(scanID); err != nil {
+		return nil, err
+	}
+	hosts, err := o.store.Hosts(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	bySeverity := func(a, b types.HostSummary) int {
+		return storage.SeverityRank(a.HighestSeverity) - storage.SeverityRank(b.HighestSeverity)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := hosts[i], hosts[j]
+		switch order {
+		case HostsByHost:
+			return a.Host < b.Host
+		case HostsByCount:
+			if a.ResultCount != b.ResultCount {
+				return a.ResultCount > b.ResultCount
+			}
+			if diff := bySeverity(a, b); diff != 0 {
+				return diff > 0
+			}
+		default:
+			if diff := bySeverity(a, b); diff != 0 {
+				return diff > 0
+			}
+			if a.ResultCount != b.ResultCount {
+				return a.ResultCount > b.ResultCount
+			}
+		}
+		return a.Host < b.Host
+	})
+	return hosts, nil
+}
+
+// allResults reads every stored result of a scan
+func (o *Orchestrator) allResults(scanID string) ([]types.ScanResult, error) {
+	page, err := o.store.QueryResults(scanID, types.ResultFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Results, nil
+}
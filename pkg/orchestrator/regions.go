@@ -0,0 +1,216 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+// regionFullCooldown is how long a region that reported no capacity is
+// left out of plans
+const regionFullCooldown = 15 * time.Minute
+
+// ErrNoRegionCapacity is returned when every region a scan may use is at its
+// droplet limit or out of capacity
+var ErrNoRegionCapacity = errors.New("no region has room for another droplet")
+
+// regionLimits holds the configured droplet limits of regions, in the order
+// scans fall back to them, and the regions the provider recently reported
+// out of capacity
+type regionLimits struct {
+	limits []types.RegionLimit
+	mutex  sync.Mutex
+	full   map[string]time.Time // until when
+}
+
+// regionSlot is the number of droplets a region can still take, negative
+// when it has no limit
+type regionSlot struct {
+	region string
+	free   int
+}
+
+// ParseRegionLimits reads region droplet limits written as region=max,
+// comma-separated, e.g. "nyc3=10,sfo3=5". The order is kept: scans whose
+// region is full fall back to the others in it.
+func ParseRegionLimits(value string) ([]types.RegionLimit, error) {
+	var limits []types.RegionLimit
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		region, count, found := strings.Cut(entry, "=")
+		region = strings.TrimSpace(region)
+		if !found || region == "" {
+			return nil, fmt.Errorf("%q is not region=maxDroplets", entry)
+		}
+		maxDroplets, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || maxDroplets < 0 {
+			return nil, fmt.Errorf("%q: invalid droplet limit", entry)
+		}
+		if seen[region] {
+			return nil, fmt.Errorf("region %s is listed twice", region)
+		}
+		seen[region] = true
+		limits = append(limits, types.RegionLimit{Region: region, MaxDroplets: maxDroplets})
+	}
+	return limits, nil
+}
+
+// markFull leaves a region out of plans for the cooldown
+func (r *regionLimits) markFull(region string, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.full[region] = now.Add(regionFullCooldown)
+}
+
+// isFull reports whether a region reported no capacity within the cooldown
+func (r *regionLimits) isFull(region string, now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	until, full := r.full[region]
+	if full && !now.Before(until) {
+		delete(r.full, region)
+		return false
+	}
+	return full
+}
+
+// regionSlots returns the room left in the preferred region and then the
+// configured ones, from the worker droplets running now. A preferred
+// region without a limit has unlimited room unless it reported no
+// capacity. The warning is set when the droplets could not be listed and
+// the limits were applied as if none ran.
+func (o *Orchestrator) regionSlots(ctx context.Context, preferred string, exclude map[string]bool) ([]regionSlot, string) {
+	inUse := make(map[string]int)
+	warning := ""
+	if len(o.regions.limits) > 0 {
+		droplets, err := o.WorkerDroplets(ctx)
+		if err != nil {
+			slog.Warn("Failed to count worker droplets by region", "error", err)
+			warning = "worker droplets could not be counted, region limits were applied as if none were running"
+		}
+		for _, droplet := range droplets {
+			inUse[droplet.Region]++
+		}
+	}
+
+	now := time.Now()
+	var slots []regionSlot
+	add := func(region string, maxDroplets int) {
+		if exclude[region] {
+			return
+		}
+		for _, slot := range slots {
+			if slot.region == region {
+				return
+			}
+		}
+		free := -1
+		if maxDroplets >= 0 {
+			free = max(maxDroplets-inUse[region], 0)
+		}
+		if o.regions.isFull(region, now) {
+			free = 0
+		}
+		slots = append(slots, regionSlot{region: region, free: free})
+	}
+	if preferred != "" {
+		limit := -1
+		for _, configured := range o.regions.limits {
+			if configured.Region == preferred {
+				limit = configured.MaxDroplets
+			}
+		}
+		add(preferred, limit)
+	}
+	for _, configured := range o.regions.limits {
+		add(configured.Region, configured.MaxDroplets)
+	}
+	return slots, warning
+}
+
+// regionRoom is the number of droplets the slots take together, negative
+// when unlimited
+func regionRoom(slots []regionSlot) int {
+	room := 0
+	for _, slot := range slots {
+		if slot.free < 0 {
+			return -1
+		}
+		room += slot.free
+	}
+	return room
+}
+
+// assignRegions places droplets in the slots in order, filling each before
+// the next. Droplets beyond the room left go to the first slot.
+func assignRegions(slots []regionSlot, droplets int) []string {
+	regions := make([]string, droplets)
+	slot, used := 0, 0
+	for i := range regions {
+		for slot < len(slots) && slots[slot].free >= 0 && used >= slots[slot].free {
+			slot++
+			used = 0
+		}
+		if slot == len(slots) {
+			regions[i] = slots[0].region
+			continue
+		}
+		regions[i] = slots[slot].region
+		used++
+	}
+	return regions
+}
+
+// fallbackRegion picks the first region with room, preferred first, other
+// than those tried, or none
+func (o *Orchestrator) fallbackRegion(ctx context.Context, preferred string, tried map[string]bool) string {
+	slots, _ := o.regionSlots(ctx, preferred, tried)
+	for _, slot := range slots {
+		if slot.free != 0 {
+			return slot.region
+		}
+	}
+	return ""
+}
+
+// launchWorker claims a warm pool worker for a worker, or creates its
+// droplet. When the region has no capacity it is left out of plans for a
+// while and the droplet is created in the next region with room instead,
+// until none is left.
+func (o *Orchestrator) launchWorker(ctx context.Context, req *types.ScanRequest, config types.DropletConfig, index int, domains []string) {
+	if o.claimPoolWorker(ctx, req, config, index, domains) {
+		return
+	}
+	workerID := workerName(req.ID, index)
+	tried := make(map[string]bool)
+	for {
+		err := o.createAndStartWorker(ctx, req, config, index, domains)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, provider.ErrNoCapacity) {
+			o.regions.markFull(config.Region, time.Now())
+			tried[config.Region] = true
+			if next := o.fallbackRegion(ctx, "", tried); next != "" {
+				slog.Warn("Region is out of capacity, creating the worker in another", "scan_id", req.ID,
+					"worker_id", workerID, "region", config.Region, "fallback", next, "error", err)
+				config.Region = next
+				continue
+			}
+		}
+		slog.Error("Failed to create worker", "scan_id", req.ID, "worker_id", workerID, "error", err)
+		o.workerCreateFailed(req.ID, workerID, err)
+		return
+	}
+}
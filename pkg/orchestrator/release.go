@@ -0,0 +1,106 @@
+package orchestrator
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrScanNotHeld is returned when releasing a scan whose workers are not
+// waiting for it: it was started without holdUntilRelease, or released
+// already
+var ErrScanNotHeld = errors.New("scan is not held")
+
+// SourceIPs lists the public addresses of a scan's workers. Workers of a held
+// scan have theirs before sending any traffic, so the list can be handed to
+// the targets' owners first; it is complete once none are pending.
+func (o *Orchestrator) SourceIPs(scanID string) (*types.SourceIPs, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return nil, ErrScanNotFound
+	}
+
+	result := &types.SourceIPs{
+		ScanID:  scanID,
+		IPs:     []string{},
+		Workers: make([]types.WorkerIPs, 0, len(scan.ActiveDroplets)),
+		Held:    scan.Held,
+	}
+	seen := make(map[string]bool)
+	for _, worker := range scan.ActiveDroplets {
+		result.Workers = append(result.Workers, types.WorkerIPs{
+			ID:     worker.ID,
+			IP:     worker.IP,
+			IPv6:   worker.IPv6,
+			Status: worker.Status,
+		})
+		for _, ip := range []string{worker.IP, worker.IPv6} {
+			if ip != "" && !seen[ip] {
+				seen[ip] = true
+				result.IPs = append(result.IPs, ip)
+			}
+		}
+	}
+	sort.Strings(result.IPs)
+
+	// Workers whose droplet could not be created never get an address
+	if scan.Plan != nil && !scanEnded(scan.Status) {
+		failed := 0
+		if state := o.scanStates[scanID]; state != nil {
+			failed = state.failedWorkers
+		}
+		result.Pending = max(scan.Plan.Droplets-len(scan.ActiveDroplets)-failed, 0)
+	}
+	return result, nil
+}
+
+// ReleaseScan lets the workers of a scan started with holdUntilRelease start
+// scanning. Workers that come up later, such as replacements, start right
+// away.
+func (o *Orchestrator) ReleaseScan(scanID string) (*types.ScanStatus, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return nil, ErrScanNotFound
+	}
+	if scanEnded(scan.Status) {
+		return nil, ErrScanNotRunning
+	}
+	if !scan.Held {
+		return nil, ErrScanNotHeld
+	}
+
+	releasedAt := time.Now().UTC()
+	scan.Held = false
+	scan.ReleasedAt = &releasedAt
+	o.scanChanged(scan)
+	slog.Info("Scan released", "scan_id", scanID, "workers", len(scan.ActiveDroplets))
+	return statusSnapshot(scan), nil
+}
+
+// ScanReleased reports whether a worker may start scanning, and counts the
+// question as a sign of life so held workers do not stall
+func (o *Orchestrator) ScanReleased(scanID, workerID string) (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	if !exists {
+		return false, ErrScanNotFound
+	}
+	if worker := o.findWorker(scanID, workerID); worker != nil {
+		if o.workerSeen(scanID, worker) {
+			o.scanChanged(scan)
+			o.workerEvent(scanID, types.WorkerProgress, worker)
+		}
+	}
+	return !scan.Held, nil
+}
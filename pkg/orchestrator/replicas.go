@@ -0,0 +1,589 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// replicaTTL is how long a replica's registration and the leader's
+	// lease last without being renewed, and replicaRenew how often they are
+	replicaTTL   = 30 * time.Second
+	replicaRenew = 10 * time.Second
+	// snapshotInterval is how often changed scans are copied to Redis
+	snapshotInterval = 500 * time.Millisecond
+	// snapshotTTL is how long a scan's copy outlives its last change
+	snapshotTTL = 24 * time.Hour
+	// snapshotRetries is how often a copy is written again after another
+	// write raced it
+	snapshotRetries = 3
+	// broadcastQueue is the number of broadcasts waiting to be published
+	// before more are dropped
+	broadcastQueue = 1024
+)
+
+// Redis keys shared by the replicas
+const (
+	leaderKey        = "replicas:leader"
+	replicatedScans  = "replicas:scans"
+	broadcastChannel = "replicas:broadcasts"
+	checkChannel     = "replicas:checks"
+)
+
+// replicaKey holds the URL a replica is reached on while it runs
+func replicaKey(id string) string {
+	return "replica:" + id
+}
+
+// scanReplicaKey holds the replica a scan runs on and its status
+func scanReplicaKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:replica", scanID)
+}
+
+// ReplicaConfig lets several servers share one Redis behind a load
+// balancer. Each scan runs on the replica that started it until that replica
+// goes away and another takes it over from its copy in Redis; the others
+// pass its requests and worker callbacks on to that replica, serve its
+// status from Redis and relay its broadcasts to their own clients.
+type ReplicaConfig struct {
+	// ID names the replica; empty picks the host name and a random suffix
+	ID string
+	// URL is the base URL the other replicas reach this one on, e.g.
+	// http://10.0.0.5:8080. Empty runs the server alone.
+	URL string
+}
+
+// replicaState is this replica's part in the group
+type replicaState struct {
+	config ReplicaConfig
+	leader atomic.Bool // always set when the server runs alone
+	// dirty holds the scans whose copy in Redis is behind, guarded by
+	// Orchestrator.mutex
+	dirty map[string]bool
+	// outbox holds the broadcasts waiting to be published
+	outbox chan replicaBroadcast
+
+	mutex  sync.Mutex
+	handle func(scanID, kind string, data json.RawMessage) // nil until set
+}
+
+// replicaBroadcast is a message for the live clients of every replica,
+// those of one scan or, without a scan, the dashboard
+type replicaBroadcast struct {
+	Replica string          `json:"replica"`
+	ScanID  string          `json:"scanId,omitempty"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func newReplicaState(cfg ReplicaConfig) *replicaState {
+	if cfg.ID == "" {
+		host, _ := os.Hostname()
+		cfg.ID = host + "-" + uuid.New().String()[:8]
+	}
+	state := &replicaState{config: cfg, dirty: make(map[string]bool)}
+	if cfg.URL == "" {
+		state.leader.Store(true)
+	} else {
+		state.outbox = make(chan replicaBroadcast, broadcastQueue)
+	}
+	return state
+}
+
+// replicated reports whether other replicas may share the server's Redis
+func (o *Orchestrator) replicated() bool {
+	return o.replicas.config.URL != ""
+}
+
+// leading reports whether this replica runs the loops only one replica may
+// run, such as the retention janitor, the warm pool and the scan checks
+func (o *Orchestrator) leading() bool {
+	return o.replicas.leader.Load()
+}
+
+// startReplica registers the replica, campaigns for leadership and starts
+// copying scans and relaying broadcasts
+func (o *Orchestrator) startReplica() {
+	if !o.replicated() {
+		return
+	}
+	slog.Info("Running as a replica", "replica_id", o.replicas.config.ID, "url", o.replicas.config.URL)
+	go o.runReplicaLease()
+	go o.runSnapshots()
+	go o.publishBroadcasts()
+	go o.receiveBroadcasts()
+	go o.receiveChecks()
+}
+
+// runReplicaLease renews the replica's registration and the leader's lease
+// every replicaRenew, taking the lease when it is free. The leader then
+// takes over the running scans of replicas that are gone.
+func (o *Orchestrator) runReplicaLease() {
+	ticker := time.NewTicker(replicaRenew)
+	defer ticker.Stop()
+
+	for {
+		ctx := context.Background()
+		if err := o.redis.Set(ctx, replicaKey(o.replicas.config.ID), o.replicas.config.URL, replicaTTL).Err(); err != nil {
+			slog.Warn("Failed to register replica", "error", err)
+		}
+
+		leading, err := o.campaign(ctx)
+		if err != nil {
+			// A leader that cannot renew its lease steps down rather than
+			// risk running next to the one that takes over
+			slog.Warn("Failed to renew leadership", "error", err)
+			leading = false
+		}
+		if o.replicas.leader.Swap(leading) != leading {
+			if leading {
+				slog.Info("Replica became leader", "replica_id", o.replicas.config.ID)
+			} else {
+				slog.Info("Replica stopped leading", "replica_id", o.replicas.config.ID)
+			}
+		}
+		if leading {
+			o.takeOverOrphans(ctx)
+		}
+		<-ticker.C
+	}
+}
+
+// campaign extends the leader's lease when this replica holds it, or takes
+// it when nobody does, and reports whether this replica leads
+func (o *Orchestrator) campaign(ctx context.Context) (bool, error) {
+	id := o.replicas.config.ID
+	leading := false
+	err := o.redis.Watch(ctx, func(tx *redis.Tx) error {
+		holder, err := tx.Get(ctx, leaderKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if holder != "" && holder != id {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, leaderKey, id, replicaTTL)
+			return nil
+		})
+		leading = err == nil
+		return err
+	}, leaderKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Another replica took the lease meanwhile
+		return false, nil
+	}
+	return leading, err
+}
+
+// replicaURL returns the URL of a running replica other than this one, or
+// "" for this one and replicas that are gone
+func (o *Orchestrator) replicaURL(ctx context.Context, id string) string {
+	if id == "" || id == o.replicas.config.ID {
+		return ""
+	}
+	url, err := o.redis.Get(ctx, replicaKey(id)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Warn("Failed to look up replica", "replica_id", id, "error", err)
+		}
+		return ""
+	}
+	return url
+}
+
+// ScanReplica returns the base URL of the replica running a scan when that
+// is another one, or "" when this replica serves the scan's requests: it
+// holds the scan, the server runs alone, or the scan's replica is gone and
+// this one took the scan over
+func (o *Orchestrator) ScanReplica(scanID string) string {
+	if !o.replicated() {
+		return ""
+	}
+	o.mutex.RLock()
+	_, local := o.activeScans[scanID]
+	o.mutex.RUnlock()
+	if local {
+		return ""
+	}
+
+	ctx := context.Background()
+	owner, err := o.redis.HGet(ctx, scanReplicaKey(scanID), "replica").Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Warn("Failed to look up the replica of a scan", "scan_id", scanID, "error", err)
+		}
+		return ""
+	}
+	if url := o.replicaURL(ctx, owner); url != "" {
+		return url
+	}
+
+	// Another replica may win the race to take the scan over
+	adopted, err := o.adoptScan(ctx, scanID)
+	if err != nil {
+		slog.Warn("Failed to take over scan", "scan_id", scanID, "error", err)
+		return ""
+	}
+	if adopted {
+		return ""
+	}
+	owner, _ = o.redis.HGet(ctx, scanReplicaKey(scanID), "replica").Result()
+	return o.replicaURL(ctx, owner)
+}
+
+// LeaderReplica returns the base URL of the leader when that is another
+// replica, or "" when this one leads or the server runs alone
+func (o *Orchestrator) LeaderReplica() string {
+	if !o.replicated() || o.leading() {
+		return ""
+	}
+	ctx := context.Background()
+	leader, err := o.redis.Get(ctx, leaderKey).Result()
+	if err != nil {
+		return ""
+	}
+	return o.replicaURL(ctx, leader)
+}
+
+// ReplicaHealth returns the replica's name and role, or nil when the server
+// runs alone
+func (o *Orchestrator) ReplicaHealth() *types.ReplicaHealth {
+	if !o.replicated() {
+		return nil
+	}
+	return &types.ReplicaHealth{ID: o.replicas.config.ID, URL: o.replicas.config.URL, Leader: o.leading()}
+}
+
+// replicateScan marks a scan's copy in Redis as behind. Callers must hold
+// o.mutex.
+func (o *Orchestrator) replicateScan(scanID string) {
+	if o.replicated() {
+		o.replicas.dirty[scanID] = true
+	}
+}
+
+// scanSnapshot is a scan's status and state as copied to Redis
+type scanSnapshot struct {
+	status *types.ScanStatus
+	state  *savedScanState
+}
+
+// runSnapshots copies the scans that changed to Redis every
+// snapshotInterval, and removes those no longer held. Scans another replica
+// took over meanwhile are dropped.
+func (o *Orchestrator) runSnapshots() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.mutex.Lock()
+		dirty := o.replicas.dirty
+		o.replicas.dirty = make(map[string]bool)
+		snapshots := make(map[string]*scanSnapshot, len(dirty))
+		for scanID := range dirty {
+			scan, exists := o.activeScans[scanID]
+			state := o.scanStates[scanID]
+			if exists && state != nil {
+				snapshots[scanID] = &scanSnapshot{status: statusSnapshot(scan), state: saveScanState(state)}
+			} else {
+				snapshots[scanID] = nil
+			}
+		}
+		o.mutex.Unlock()
+
+		for scanID, snapshot := range snapshots {
+			var err error
+			if snapshot == nil {
+				err = o.dropSnapshot(scanID)
+			} else {
+				err = o.saveSnapshot(snapshot)
+			}
+			if errors.Is(err, errScanHeld) {
+				o.relinquishScan(scanID)
+				continue
+			}
+			if err != nil {
+				slog.Warn("Failed to copy scan to Redis", "scan_id", scanID, "error", err)
+				o.mutex.Lock()
+				o.replicas.dirty[scanID] = true
+				o.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// saveSnapshot writes a scan's status and state to Redis unless a later
+// revision is there already. The write only goes through when nobody changed
+// the copy since it was read, and is retried when somebody did. A copy
+// another replica claimed returns errScanHeld.
+func (o *Orchestrator) saveSnapshot(snapshot *scanSnapshot) error {
+	status := snapshot.status
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(snapshot.state)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := scanReplicaKey(status.ID)
+	id := o.replicas.config.ID
+
+	for attempt := 0; attempt < snapshotRetries; attempt++ {
+		err = o.redis.Watch(ctx, func(tx *redis.Tx) error {
+			stored, err := tx.HMGet(ctx, key, "replica", "revision").Result()
+			if err != nil {
+				return err
+			}
+			if owner, _ := stored[0].(string); owner != "" && owner != id {
+				return fmt.Errorf("%w %s", errScanHeld, owner)
+			}
+			if revision, _ := stored[1].(string); revision != "" {
+				if current, err := strconv.ParseInt(revision, 10, 64); err == nil && current >= status.Revision {
+					return nil
+				}
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, "replica", id, "revision", status.Revision, "status", data, "state", state)
+				pipe.Expire(ctx, key, snapshotTTL)
+				pipe.Expire(ctx, scanTokensKey(status.ID), snapshotTTL)
+				pipe.SAdd(ctx, replicatedScans, status.ID)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// dropSnapshot removes the copy of a scan this replica no longer holds,
+// unless another replica took the scan over
+func (o *Orchestrator) dropSnapshot(scanID string) error {
+	ctx := context.Background()
+	key := scanReplicaKey(scanID)
+	err := o.redis.Watch(ctx, func(tx *redis.Tx) error {
+		owner, err := tx.HGet(ctx, key, "replica").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if owner != "" && owner != o.replicas.config.ID {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, replicatedScans, scanID)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Claimed or written by another replica meanwhile
+		return nil
+	}
+	return err
+}
+
+// replicaStatus returns the status of a scan another replica runs, as last
+// copied to Redis
+func (o *Orchestrator) replicaStatus(scanID string) (*types.ScanStatus, error) {
+	data, err := o.redis.HGet(context.Background(), scanReplicaKey(scanID), "status").Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrScanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status types.ScanStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// replicaScans returns the status of every scan the other replicas run.
+// Copies that expired are forgotten on the way.
+func (o *Orchestrator) replicaScans() ([]*types.ScanStatus, error) {
+	if !o.replicated() {
+		return nil, nil
+	}
+	ctx := context.Background()
+	ids, err := o.redis.SMembers(ctx, replicatedScans).Result()
+	if err != nil {
+		return nil, err
+	}
+	o.mutex.RLock()
+	remote := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, local := o.activeScans[id]; !local {
+			remote = append(remote, id)
+		}
+	}
+	o.mutex.RUnlock()
+	if len(remote) == 0 {
+		return nil, nil
+	}
+
+	pipe := o.redis.Pipeline()
+	commands := make([]*redis.StringCmd, len(remote))
+	for i, id := range remote {
+		commands[i] = pipe.HGet(ctx, scanReplicaKey(id), "status")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	scans := make([]*types.ScanStatus, 0, len(remote))
+	for i, command := range commands {
+		data, err := command.Bytes()
+		if errors.Is(err, redis.Nil) {
+			o.redis.SRem(ctx, replicatedScans, remote[i])
+			continue
+		}
+		var status types.ScanStatus
+		if err != nil || json.Unmarshal(data, &status) != nil {
+			continue
+		}
+		scans = append(scans, &status)
+	}
+	return scans, nil
+}
+
+// PublishBroadcast hands a message for live clients to the other replicas,
+// for one scan's clients or, with an empty scanID, the dashboard. It does
+// nothing when the server runs alone.
+func (o *Orchestrator) PublishBroadcast(scanID, kind string, data interface{}) {
+	if !o.replicated() {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		slog.Warn("Failed to encode broadcast", "scan_id", scanID, "type", kind, "error", err)
+		return
+	}
+	message := replicaBroadcast{Replica: o.replicas.config.ID, ScanID: scanID, Type: kind, Data: encoded}
+	select {
+	case o.replicas.outbox <- message:
+	default:
+		slog.Warn("Replica broadcast queue full, dropping message", "scan_id", scanID, "type", kind)
+	}
+}
+
+// OnBroadcast sets the function the broadcasts of other replicas go to
+func (o *Orchestrator) OnBroadcast(handle func(scanID, kind string, data json.RawMessage)) {
+	o.replicas.mutex.Lock()
+	defer o.replicas.mutex.Unlock()
+	o.replicas.handle = handle
+}
+
+// publishBroadcasts publishes queued broadcasts in order
+func (o *Orchestrator) publishBroadcasts() {
+	for message := range o.replicas.outbox {
+		data, err := json.Marshal(message)
+		if err == nil {
+			err = o.redis.Publish(context.Background(), broadcastChannel, data).Err()
+		}
+		if err != nil {
+			slog.Warn("Failed to publish broadcast", "scan_id", message.ScanID, "type", message.Type, "error", err)
+		}
+	}
+}
+
+// receiveBroadcasts hands the broadcasts of other replicas to the handler.
+// The subscription reconnects by itself after Redis goes away.
+func (o *Orchestrator) receiveBroadcasts() {
+	subscription := o.redis.Subscribe(context.Background(), broadcastChannel)
+	for received := range subscription.Channel() {
+		var message replicaBroadcast
+		if err := json.Unmarshal([]byte(received.Payload), &message); err != nil {
+			slog.Warn("Ignoring malformed broadcast", "error", err)
+			continue
+		}
+		if message.Replica == o.replicas.config.ID {
+			continue
+		}
+		o.replicas.mutex.Lock()
+		handle := o.replicas.handle
+		o.replicas.mutex.Unlock()
+		if handle != nil {
+			handle(message.ScanID, message.Type, message.Data)
+		}
+	}
+}
+
+// Checks of the running scans that the leader runs on behalf of the group
+const (
+	checkStalls = "stalls"
+	checkLeases = "leases"
+	checkStuck  = "stuck"
+)
+
+// replicaCheck asks the other replicas to run a check over their scans, as
+// of the leader's time
+type replicaCheck struct {
+	Replica string    `json:"replica"`
+	Check   string    `json:"check"`
+	At      time.Time `json:"at"`
+}
+
+// leaderCheck runs a check over the scans this replica holds when it leads,
+// and asks the other replicas to run it over theirs. Only the leader's ticks
+// count, so each check runs once a tick across the group, and only the
+// replica holding a scan changes it.
+func (o *Orchestrator) leaderCheck(check string, now time.Time) {
+	if !o.leading() {
+		return
+	}
+	if o.replicated() {
+		data, err := json.Marshal(replicaCheck{Replica: o.replicas.config.ID, Check: check, At: now})
+		if err == nil {
+			err = o.redis.Publish(context.Background(), checkChannel, data).Err()
+		}
+		if err != nil {
+			slog.Warn("Failed to ask replicas for a check", "check", check, "error", err)
+		}
+	}
+	o.runCheck(check, now)
+}
+
+// runCheck runs a check over the scans this replica holds
+func (o *Orchestrator) runCheck(check string, now time.Time) {
+	switch check {
+	case checkStalls:
+		o.checkStalls(now)
+	case checkLeases:
+		o.checkLeases(now)
+	case checkStuck:
+		o.checkStuckScans(now)
+	}
+}
+
+// receiveChecks runs the checks the leader asks for
+func (o *Orchestrator) receiveChecks() {
+	subscription := o.redis.Subscribe(context.Background(), checkChannel)
+	for received := range subscription.Channel() {
+		var message replicaCheck
+		if err := json.Unmarshal([]byte(received.Payload), &message); err != nil {
+			slog.Warn("Ignoring malformed check request", "error", err)
+			continue
+		}
+		if message.Replica != o.replicas.config.ID {
+			o.runCheck(message.Check, message.At)
+		}
+	}
+}
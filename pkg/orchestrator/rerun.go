@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"errors"
+	"strings"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrScanConfigMissing is returned for scans recorded before their
+// configuration was stored
+var ErrScanConfigMissing = errors.New("scan was recorded before its configuration was stored")
+
+// scanConfig returns the request a scan ran with, including the targets it
+// ended up with
+func (o *Orchestrator) scanConfig(scanID string) (*types.ScanRequest, error) {
+	record, err := o.GetScanRecord(scanID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Config == nil {
+		return nil, ErrScanConfigMissing
+	}
+	config := *record.Config
+	config.ID = scanID
+	config.Domains = append([]string{}, record.Targets...)
+	return &config, nil
+}
+
+// ScanConfig returns the normalized request a scan ran with, with webhook
+// secrets, chat webhook URLs and credentials in its nuclei config redacted
+func (o *Orchestrator) ScanConfig(scanID string) (*types.ScanRequest, error) {
+	config, err := o.scanConfig(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Webhooks) > 0 {
+		webhooks := make([]types.WebhookConfig, len(config.Webhooks))
+		for i, webhook := range config.Webhooks {
+			if webhook.Secret != "" {
+				webhook.Secret = redactedValue
+			}
+			webhooks[i] = webhook
+		}
+		config.Webhooks = webhooks
+	}
+	if config.Slack != nil && config.Slack.WebhookURL != "" {
+		slack := *config.Slack
+		slack.WebhookURL = redactedValue
+		config.Slack = &slack
+	}
+	if config.Discord != nil && config.Discord.WebhookURL != "" {
+		discord := *config.Discord
+		discord.WebhookURL = redactedValue
+		config.Discord = &discord
+	}
+	config.ConfigYAML = redactConfigYAML(config.ConfigYAML)
+	return config, nil
+}
+
+// RerunRequest returns a new scan request with the same targets and
+// settings as a recorded scan, linked to it through RerunOf. Template
+// variables are not kept with the configuration and must be stored for the
+// new scan again.
+func (o *Orchestrator) RerunRequest(scanID string, overrides types.RerunRequest) (*types.ScanRequest, error) {
+	req, err := o.scanConfig(scanID)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = ""
+	req.Status = ""
+	req.RerunOf = scanID
+
+	if overrides.Droplets != nil {
+		req.Droplets = *overrides.Droplets
+	}
+	if overrides.Tags != nil {
+		req.ExtraFlags = replaceFlag(req.ExtraFlags, "tags", overrides.Tags)
+	}
+	if overrides.TemplateIDs != nil {
+		req.ExtraFlags = replaceFlag(req.ExtraFlags, "template-id", overrides.TemplateIDs)
+	}
+	return req, nil
+}
+
+// replaceFlag drops a list flag from normalized extra flags and, unless
+// values is empty, adds it back with values. The result is validated when
+// the scan starts.
+func replaceFlag(flags []string, name string, values []string) []string {
+	replaced := make([]string, 0, len(flags)+1)
+	for _, flag := range flags {
+		if flag != "-"+name && !strings.HasPrefix(flag, "-"+name+"=") {
+			replaced = append(replaced, flag)
+		}
+	}
+	if len(values) > 0 {
+		replaced = append(replaced, "-"+name+"="+strings.Join(values, ","))
+	}
+	return replaced
+}
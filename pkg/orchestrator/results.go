@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidResult is returned for worker results that cannot be stored
+var ErrInvalidResult = errors.New("invalid result")
+
+// maxResultField bounds the matched output fields of a result; longer values
+// are cut and marked
+const maxResultField = 64 << 10
+
+// normalizeResult validates a result posted by a worker and brings it into
+// the shape findings are stored in
+func normalizeResult(result *types.ScanResult) error {
+	result.Host = strings.TrimSpace(result.Host)
+	result.Template = strings.TrimSpace(result.Template)
+	if result.Host == "" || hostname(result.Host) == "" {
+		return fmt.Errorf("%w: host is required", ErrInvalidResult)
+	}
+	if result.Template == "" {
+		return fmt.Errorf("%w: template is required", ErrInvalidResult)
+	}
+
+	result.Severity = storage.IndexedSeverity(strings.TrimSpace(result.Severity))
+	result.Match = truncateField(result.Match)
+	result.CurlCommand = truncateField(result.CurlCommand)
+	for i, extracted := range result.ExtractedResults {
+		result.ExtractedResults[i] = truncateField(extracted)
+	}
+	return nil
+}
+
+// truncateField cuts a value to maxResultField bytes on a rune boundary and
+// notes how much was dropped
+func truncateField(value string) string {
+	if len(value) <= maxResultField {
+		return value
+	}
+	cut := maxResultField
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", value[:cut], len(value)-cut)
+}
+
+// ResultBatch is how a batch of findings from a worker was taken
+type ResultBatch struct {
+	// Stored are the findings stored, as stored
+	Stored     []types.ScanResult
+	Duplicates int
+	Blocked    int
+	// Invalid counts the findings normalizeResult refused
+	Invalid int
+}
+
+// AddResults takes a batch of findings like AddResult takes one, skipping
+// and counting the invalid, blocklisted and duplicate ones. The others are
+// stored at once: when storing fails none are, and the batch can be retried.
+func (o *Orchestrator) AddResults(scanID string, results []types.ScanResult) (*ResultBatch, error) {
+	batch := &ResultBatch{}
+	valid := make([]types.ScanResult, 0, len(results))
+	for _, result := range results {
+		if err := normalizeResult(&result); err != nil {
+			batch.Invalid++
+			continue
+		}
+		if rule := o.resultBlocked(&result); rule != nil {
+			slog.Warn("Blocklist: dropped result", "scan_id", scanID, "worker_id", result.WorkerID, "host", result.Host, "pattern", rule.Pattern)
+			batch.Blocked++
+			continue
+		}
+		valid = append(valid, result)
+	}
+
+	o.mutex.Lock()
+	scan, exists := o.activeScans[scanID]
+	state := o.scanStates[scanID]
+	if !exists || state == nil {
+		o.mutex.Unlock()
+		return nil, ErrScanNotFound
+	}
+	if batch.Blocked > 0 {
+		scan.BlockedResults += batch.Blocked
+		o.scanChanged(scan)
+	}
+
+	// Workers cannot triage, number or file their own findings
+	fresh := make([]types.ScanResult, 0, len(valid))
+	var duplicates []types.ScanResult
+	keys := make([]uint64, 0, len(valid))
+	for _, result := range valid {
+		result.ID = result.ResultID()
+		result.Triage = nil
+		result.Seq = 0
+		result.Ticket = nil
+		result.OutOfScope = state.scope != nil && !state.scope.contains(result.Host)
+
+		key := dedupHash(result.ID)
+		if _, duplicate := state.resultKeys[key]; duplicate {
+			duplicates = append(duplicates, result)
+			continue
+		}
+		state.resultKeys[key] = struct{}{}
+		keys = append(keys, key)
+		fresh = append(fresh, result)
+	}
+	if len(duplicates) > 0 {
+		scan.DuplicatesDropped += len(duplicates)
+		o.scanChanged(scan)
+	}
+	o.mutex.Unlock()
+
+	batch.Duplicates = len(duplicates)
+	for _, result := range duplicates {
+		if err := o.store.AppendDuplicate(scanID, result); err != nil {
+			slog.Error("Failed to store duplicate result", "scan_id", scanID, "error", err)
+		}
+	}
+	if len(duplicates) > 0 {
+		o.touchScan(scanID)
+	}
+	if len(fresh) == 0 {
+		return batch, nil
+	}
+
+	// Findings matching a false-positive rule are stored, already triaged
+	for i := range fresh {
+		fresh[i].Triage = o.matchRule(&fresh[i])
+	}
+	if err := o.store.AppendResults(scanID, fresh); err != nil {
+		o.alerts.storageFailed("store findings", err)
+		// Forget the keys so the worker's retry is not taken for duplicates
+		o.mutex.Lock()
+		for _, key := range keys {
+			delete(state.resultKeys, key)
+		}
+		o.mutex.Unlock()
+		return nil, err
+	}
+	for _, result := range fresh {
+		if result.Triage != nil {
+			o.recordRuleHit(result.Triage.Rule, result.Triage.UpdatedAt)
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, result := range fresh {
+		o.recordResult(scanID, scan, state, result)
+	}
+	batch.Stored = fresh
+	return batch, nil
+}
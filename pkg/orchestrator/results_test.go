@@ -0,0 +1,86 @@
+package orchestrator
+
+import (
+	"testing"
+
+	"nuclei-distributed/pkg/types"
+)
+
+func TestDedupKeyIgnoresWorker(t *testing.T) {
+	first := types.ScanResult{WorkerID: "a", Host: "example.com", Template: "tech-detect", MatchedAt: "https://example.com/"}
+	second := types.ScanResult{WorkerID: "b", Host: "example.com", Template: "tech-detect", Match: "https://example.com/"}
+	if first.DedupKey() != second.DedupKey() {
+		t.Errorf("DedupKey() = %q and %q, want equal", first.DedupKey(), second.DedupKey())
+	}
+	if first.ResultID() != second.ResultID() {
+		t.Errorf("ResultID() = %q and %q, want equal", first.ResultID(), second.ResultID())
+	}
+
+	other := second
+	other.Template = "exposed-panel"
+	if other.DedupKey() == first.DedupKey() {
+		t.Errorf("DedupKey() = %q for a different template", other.DedupKey())
+	}
+}
+
+func TestAddResultsDropsDuplicatesFromOtherWorkers(t *testing.T) {
+	o, _, _ := newTestOrchestrator(t)
+	scanID := startTestScan(t, o, "example.com", "example.org")
+
+	finding := types.ScanResult{Host: "example.com", Template: "tech-detect", MatchedAt: "https://example.com/", Severity: "info"}
+	fromA, fromB := finding, finding
+	fromA.WorkerID = workerName(scanID, 0)
+	fromB.WorkerID = workerName(scanID, 1)
+
+	batch, err := o.AddResults(scanID, []types.ScanResult{fromA})
+	if err != nil {
+		t.Fatalf("AddResults() error = %v", err)
+	}
+	if len(batch.Stored) != 1 || batch.Duplicates != 0 {
+		t.Fatalf("first batch stored %d and dropped %d, want 1 and 0", len(batch.Stored), batch.Duplicates)
+	}
+
+	// The same finding again, and twice within one batch
+	batch, err = o.AddResults(scanID, []types.ScanResult{fromB, fromB})
+	if err != nil {
+		t.Fatalf("AddResults() error = %v", err)
+	}
+	if len(batch.Stored) != 0 || batch.Duplicates != 2 {
+		t.Fatalf("second batch stored %d and dropped %d, want 0 and 2", len(batch.Stored), batch.Duplicates)
+	}
+
+	status, err := o.GetScanStatus(scanID)
+	if err != nil {
+		t.Fatalf("GetScanStatus() error = %v", err)
+	}
+	if status.DuplicatesDropped != 2 {
+		t.Errorf("DuplicatesDropped = %d, want 2", status.DuplicatesDropped)
+	}
+	if status.ResultCount != 1 {
+		t.Errorf("ResultCount = %d, want 1", status.ResultCount)
+	}
+
+	page, err := o.QueryResults(scanID, types.ResultFilter{})
+	if err != nil {
+		t.Fatalf("QueryResults() error = %v", err)
+	}
+	if len(page.Results) != 1 || page.Results[0].WorkerID != fromA.WorkerID || page.Results[0].Duplicate {
+		t.Errorf("results = %+v, want only the first worker's finding", page.Results)
+	}
+
+	page, err = o.QueryResults(scanID, types.ResultFilter{IncludeDuplicates: true})
+	if err != nil {
+		t.Fatalf("QueryResults() error = %v", err)
+	}
+	if len(page.Results) != 3 {
+		t.Fatalf("got %d results with duplicates, want 3", len(page.Results))
+	}
+	for i, result := range page.Results {
+		if result.Duplicate != (i > 0) {
+			t.Errorf("result %d from %s has Duplicate = %t", i, result.WorkerID, result.Duplicate)
+		}
+		if result.ID != fromA.ResultID() {
+			t.Errorf("result %d has ID %q, want %q", i, result.ID, fromA.ResultID())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// janitorInterval is how often the janitor looks for expired scans
+const janitorInterval = time.Hour
+
+// SetPinned pins a scan so the retention janitor keeps it, or unpins it
+func (o *Orchestrator) SetPinned(scanID string, pinned bool) (*types.ScanRecord, error) {
+	o.retentionMutex.Lock()
+	defer o.retentionMutex.Unlock()
+
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		scan.Pinned = pinned
+		o.scanChanged(scan)
+		record := scanRecord(scan, o.scanStates[scanID])
+		o.mutex.Unlock()
+
+		if err := o.store.SaveScan(record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	o.mutex.Unlock()
+
+	record, err := o.store.GetScan(scanID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrScanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record.Pinned = pinned
+	if err := o.store.SaveScan(*record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// RetentionHealth returns the janitor's progress, or nil when scans are kept
+// forever
+func (o *Orchestrator) RetentionHealth() *types.RetentionHealth {
+	retention := o.settings().retention
+	if retention <= 0 {
+		return nil
+	}
+
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	health := o.janitor
+	health.Retention = retention.String()
+	return &health
+}
+
+// runJanitor purges expired scans and audit entries now and then every
+// janitorInterval, each while its retention is set. Of several replicas only
+// the leader purges.
+func (o *Orchestrator) runJanitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		if o.leading() {
+			now := time.Now().UTC()
+			o.purgeExpired(now)
+			o.purgeAudit(now)
+		}
+		<-ticker.C
+	}
+}
+
+// expired reports whether a scan is past the retention cutoff. Only
+// completed, unpinned scans ever expire.
+func expired(record *types.ScanRecord, cutoff time.Time) bool {
+	if record.Pinned || !scanEnded(record.Status) {
+		return false
+	}
+	finished := record.CreatedAt
+	if record.CompletedAt != nil {
+		finished = *record.CompletedAt
+	}
+	return !finished.IsZero() && finished.Before(cutoff)
+}
+
+// purgeExpired deletes every scan that completed before the retention window
+func (o *Orchestrator) purgeExpired(now time.Time) {
+	retention := o.settings().retention
+	if retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-retention)
+
+	records, err := o.store.ListScans()
+	if err != nil {
+		slog.Error("Retention janitor failed to list scans", "error", err)
+		o.mutex.Lock()
+		o.janitor.LastRun = &now
+		o.janitor.LastError = err.Error()
+		o.mutex.Unlock()
+		return
+	}
+
+	var failure error
+	for i := range records {
+		if !expired(&records[i], cutoff) {
+			continue
+		}
+		if err := o.purgeScan(records[i].ID, cutoff); err != nil {
+			slog.Error("Retention janitor failed to purge scan", "scan_id", records[i].ID, "error", err)
+			failure = err
+		}
+	}
+
+	o.mutex.Lock()
+	o.janitor.LastRun = &now
+	o.janitor.LastError = ""
+	if failure != nil {
+		o.janitor.LastError = failure.Error()
+	}
+	o.mutex.Unlock()
+}
+
+// purgeScan deletes a scan's artifacts, worker logs, findings and record.
+// The record is read again first so a scan pinned since the listing is kept.
+func (o *Orchestrator) purgeScan(scanID string, cutoff time.Time) error {
+	o.retentionMutex.Lock()
+	defer o.retentionMutex.Unlock()
+
+	record, err := o.GetScanRecord(scanID)
+	if errors.Is(err, ErrScanNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !expired(record, cutoff) {
+		return nil
+	}
+
+	if o.artifacts != nil {
+		for _, artifact := range record.Artifacts {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := o.artifacts.Delete(ctx, artifact.Key)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := o.deleteWorkerLogs(scanID); err != nil {
+		return err
+	}
+	if err := o.store.DeleteScan(scanID); err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	if state := o.scanStates[scanID]; state != nil && state.changed != nil {
+		close(state.changed)
+	}
+	delete(o.activeScans, scanID)
+	o.replicateScan(scanID)
+	delete(o.scanStates, scanID)
+	o.scanLogs.forget(scanID)
+	o.janitor.ScansPurged++
+	o.mutex.Unlock()
+
+	slog.Info("Retention janitor purged scan", "scan_id", scanID, "status", record.Status,
+		"created_at", record.CreatedAt.Format(time.RFC3339), "results", record.ResultCount, "artifacts", len(record.Artifacts))
+	return nil
+}
+
+// globEscaper escapes the characters Redis treats as patterns in MATCH
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// deleteWorkerLogs removes the logs every worker of a scan spilled to Redis
+func (o *Orchestrator) deleteWorkerLogs(scanID string) error {
+	ctx := context.Background()
+
+	var keys []string
+	iter := o.redis.Scan(ctx, 0, workerLogsKey(globEscaper.Replace(scanID), "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return o.redis.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,213 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// maxScanLogEntries is the number of entries a scan's log keeps; older ones
+// are dropped
+const maxScanLogEntries = 2000
+
+// Sources of scan log entries
+const (
+	LogSourceOrchestrator = "orchestrator"
+	LogSourceWorker       = "worker"
+)
+
+// scanLog collects a scan's log entries. It has its own mutex since records
+// are logged with o.mutex held.
+type scanLog struct {
+	mutex sync.Mutex
+	log   types.ScanLog
+}
+
+// add appends an entry, dropping the oldest once the log is full
+func (l *scanLog) add(entry types.ScanLogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if overflow := len(l.log.Entries) + 1 - maxScanLogEntries; overflow > 0 {
+		l.log.Entries = append(l.log.Entries[:0], l.log.Entries[overflow:]...)
+		l.log.Dropped += overflow
+	}
+	l.log.Entries = append(l.log.Entries, entry)
+}
+
+// snapshot copies the log for the scan's record
+func (l *scanLog) snapshot() *types.ScanLog {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return &types.ScanLog{Entries: append([]types.ScanLogEntry{}, l.log.Entries...), Dropped: l.log.Dropped}
+}
+
+// scanLogs holds the logs of the scans in memory by scan ID, for the log
+// handler, which cannot take o.mutex
+type scanLogs struct {
+	mutex sync.RWMutex
+	logs  map[string]*scanLog
+}
+
+// start creates the log of a new scan
+func (s *scanLogs) start(scanID string) *scanLog {
+	log := &scanLog{}
+	s.mutex.Lock()
+	if s.logs == nil {
+		s.logs = make(map[string]*scanLog)
+	}
+	s.logs[scanID] = log
+	s.mutex.Unlock()
+	return log
+}
+
+// forget stops collecting the log of a scan no longer in memory
+func (s *scanLogs) forget(scanID string) {
+	s.mutex.Lock()
+	delete(s.logs, scanID)
+	s.mutex.Unlock()
+}
+
+func (s *scanLogs) get(scanID string) *scanLog {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.logs[scanID]
+}
+
+// ScanLogHandler wraps the server's log handler so that records about a
+// scan in memory, those with a scan_id, are also added to the scan's log.
+// Scan logs take info records and above whatever next's level.
+func (o *Orchestrator) ScanLogHandler(next slog.Handler) slog.Handler {
+	return &scanLogHandler{next: next, logs: &o.scanLogs}
+}
+
+type scanLogHandler struct {
+	next   slog.Handler
+	logs   *scanLogs
+	scanID string      // set by a scan_id attribute added to the logger
+	attrs  []slog.Attr // added to the logger, outside any group
+	// grouped is set once the logger opened a group, after which attributes
+	// are no longer top-level and are not looked at
+	grouped bool
+}
+
+func (h *scanLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+func (h *scanLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	if h.next.Enabled(ctx, record.Level) {
+		err = h.next.Handle(ctx, record)
+	}
+	if record.Level < slog.LevelInfo {
+		return err
+	}
+
+	scanID := h.scanID
+	// Capped so appending never writes to the logger's attributes
+	attrs := h.attrs[:len(h.attrs):len(h.attrs)]
+	if !h.grouped {
+		record.Attrs(func(attr slog.Attr) bool {
+			if attr.Key == "scan_id" {
+				scanID = attr.Value.String()
+			} else {
+				attrs = append(attrs, attr)
+			}
+			return true
+		})
+	}
+	if scanID == "" {
+		return err
+	}
+	log := h.logs.get(scanID)
+	if log == nil {
+		return err
+	}
+
+	entry := types.ScanLogEntry{
+		Time:    record.Time.UTC(),
+		Level:   record.Level.String(),
+		Source:  LogSourceOrchestrator,
+		Message: record.Message,
+	}
+	if len(attrs) > 0 {
+		entry.Attrs = make(map[string]string, len(attrs))
+		for _, attr := range attrs {
+			entry.Attrs[attr.Key] = attr.Value.Resolve().String()
+		}
+	}
+	log.add(entry)
+	return err
+}
+
+func (h *scanLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.next = h.next.WithAttrs(attrs)
+	if !h.grouped {
+		handler.attrs = append([]slog.Attr{}, h.attrs...)
+		for _, attr := range attrs {
+			if attr.Key == "scan_id" {
+				handler.scanID = attr.Value.String()
+			} else {
+				handler.attrs = append(handler.attrs, attr)
+			}
+		}
+	}
+	return &handler
+}
+
+func (h *scanLogHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.next = h.next.WithGroup(name)
+	handler.grouped = true
+	return &handler
+}
+
+// addWorkerErrors adds the errors among a worker's log lines to its scan's
+// log. Callers must hold o.mutex.
+func (o *Orchestrator) addWorkerErrors(scanID, workerID string, logs []types.Log) {
+	state := o.scanStates[scanID]
+	if state == nil || state.log == nil {
+		return
+	}
+	for _, entry := range logs {
+		if entry.Type != "error" {
+			continue
+		}
+		state.log.add(types.ScanLogEntry{
+			Time:     entry.Timestamp.UTC(),
+			Level:    slog.LevelError.String(),
+			Source:   LogSourceWorker,
+			WorkerID: workerID,
+			Message:  entry.Message,
+		})
+	}
+}
+
+// ScanLog returns what the server logged about a scan and the errors its
+// workers logged, live for scans in memory and from the scan's record
+// otherwise. Scans recorded before scan logs were kept have an empty log.
+func (o *Orchestrator) ScanLog(scanID string) (*types.ScanLog, error) {
+	o.mutex.RLock()
+	if state := o.scanStates[scanID]; state != nil && state.log != nil {
+		o.mutex.RUnlock()
+		return state.log.snapshot(), nil
+	}
+	o.mutex.RUnlock()
+
+	record, err := o.store.GetScan(scanID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrScanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.Log == nil {
+		return &types.ScanLog{Entries: []types.ScanLogEntry{}}, nil
+	}
+	return record.Log, nil
+}
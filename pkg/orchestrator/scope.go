@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// targetScope is the set of registered domains and IPs a scan was started
+// with. Findings on other hosts are still stored but flagged, e.g. when a
+// redirect took nuclei to a third-party site.
+type targetScope struct {
+	domains map[string]bool // registered domains, e.g. example.co.uk
+	ips     map[string]bool
+}
+
+func newTargetScope(targets []string) *targetScope {
+	scope := &targetScope{domains: make(map[string]bool), ips: make(map[string]bool)}
+	scope.add(targets)
+	return scope
+}
+
+// add widens the scope to targets added to a running scan
+func (s *targetScope) add(targets []string) {
+	for _, target := range targets {
+		host := hostname(target)
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			s.ips[ip.String()] = true
+			continue
+		}
+		s.domains[registeredDomain(host)] = true
+	}
+}
+
+// contains reports whether host, a hostname, URL or host:port, belongs to a
+// target: the same IP, or a name under the same registered domain
+func (s *targetScope) contains(host string) bool {
+	host = hostname(host)
+	if ip := net.ParseIP(host); ip != nil {
+		return s.ips[ip.String()]
+	}
+	return s.domains[registeredDomain(host)]
+}
+
+// hostname extracts the lowercased hostname from a URL, host:port or bare
+// host
+func hostname(target string) string {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "://") {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(parsed.Hostname())
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		target = host
+	}
+	if i := strings.IndexAny(target, "/?#"); i >= 0 {
+		target = target[:i]
+	}
+	return strings.ToLower(strings.Trim(target, "[]."))
+}
+
+// registeredDomain returns the domain a name was registered under, or the
+// name itself when it has none, e.g. for "localhost"
+func registeredDomain(host string) string {
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrSecretsConsumed is returned when a worker asks for secrets that were
+// invalidated after their first fetch
+var ErrSecretsConsumed = errors.New("secrets already fetched")
+
+const (
+	secretsTTL          = 48 * time.Hour
+	maxSecretValueBytes = 4096
+)
+
+var secretKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func scanSecretsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:secrets", scanID)
+}
+
+// secretPolicy tracks which workers fetched a scan's secrets
+type secretPolicy struct {
+	invalidateAfterFetch bool
+	fetched              map[string]bool
+}
+
+// newSecretsCipher builds the AES-GCM cipher used to encrypt secrets in Redis.
+// Without a configured key a random one is generated, which means stored
+// secrets do not survive a restart.
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		slog.Warn("SECRETS_KEY not set, using an ephemeral key for scan secrets")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate secrets key: %v", err)
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize secrets cipher: %v", err)
+	}
+
+	return aead, nil
+}
+
+// SetScanSecrets stores template variables for a scan encrypted in Redis.
+// Only the key names are recorded on the scan status.
+func (o *Orchestrator) SetScanSecrets(scanID string, secrets map[string]string, invalidateAfterFetch bool) error {
+	keys := make([]string, 0, len(secrets))
+	for key, value := range secrets {
+		if !secretKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: invalid secret name %q", ErrInvalidScan, key)
+		}
+		if len(value) > maxSecretValueBytes || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: secret %q must be a single line under %d bytes", ErrInvalidScan, key, maxSecretValueBytes)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	o.mutex.RLock()
+	_, exists := o.activeScans[scanID]
+	o.mutex.RUnlock()
+	if !exists {
+		return ErrScanNotFound
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, o.secretsCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := o.secretsCipher.Seal(nonce, nonce, plaintext, []byte(scanID))
+
+	if err := o.redis.Set(context.Background(), scanSecretsKey(scanID), sealed, secretsTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store secrets: %v", err)
+	}
+
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		scan.SecretKeys = keys
+		o.scanChanged(scan)
+	}
+	if state, exists := o.scanStates[scanID]; exists {
+		state.secrets = &secretPolicy{
+			invalidateAfterFetch: invalidateAfterFetch,
+			fetched:              make(map[string]bool),
+		}
+	}
+	o.mutex.Unlock()
+
+	slog.Info("Stored secrets", "scan_id", scanID, "count", len(keys))
+
+	return nil
+}
+
+// FetchWorkerSecrets decrypts a scan's secrets for one of its workers. When
+// the scan asked for invalidation each worker may fetch only once, and the
+// stored secrets are deleted after every worker has fetched them.
+func (o *Orchestrator) FetchWorkerSecrets(scanID, workerID string) (map[string]string, error) {
+	o.mutex.Lock()
+	var policy *secretPolicy
+	if state, exists := o.scanStates[scanID]; exists {
+		policy = state.secrets
+	}
+	if policy == nil {
+		o.mutex.Unlock()
+		return map[string]string{}, nil
+	}
+	if policy.invalidateAfterFetch && policy.fetched[workerID] {
+		o.mutex.Unlock()
+		return nil, ErrSecretsConsumed
+	}
+	o.mutex.Unlock()
+
+	ctx := context.Background()
+	sealed, err := o.redis.Get(ctx, scanSecretsKey(scanID)).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %v", err)
+	}
+
+	nonceSize := o.secretsCipher.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("stored secrets are corrupt")
+	}
+	plaintext, err := o.secretsCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(scanID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets: %v", err)
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to decode secrets: %v", err)
+	}
+
+	// Only a fetch that succeeded counts, so a worker retries a failed one.
+	// Another fetch by the same worker may have won the race meanwhile.
+	o.mutex.Lock()
+	if policy.invalidateAfterFetch && policy.fetched[workerID] {
+		o.mutex.Unlock()
+		return nil, ErrSecretsConsumed
+	}
+	policy.fetched[workerID] = true
+	allFetched := false
+	if scan, exists := o.activeScans[scanID]; exists && scan.Plan != nil {
+		allFetched = len(policy.fetched) >= scan.Plan.Droplets
+	}
+	o.mutex.Unlock()
+
+	if policy.invalidateAfterFetch && allFetched {
+		o.deleteScanSecrets(scanID)
+		slog.Info("All workers fetched secrets, deleted them", "scan_id", scanID)
+	}
+
+	return secrets, nil
+}
+
+func (o *Orchestrator) deleteScanSecrets(scanID string) {
+	if err := o.redis.Del(context.Background(), scanSecretsKey(scanID)).Err(); err != nil {
+		slog.Error("Failed to delete secrets", "scan_id", scanID, "error", err)
+	}
+}
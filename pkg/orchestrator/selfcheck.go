@@ -0,0 +1,185 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+// Self-check modes, what the server does while a self-check fails
+const (
+	SelfCheckStrict   = "strict"   // refuse to start
+	SelfCheckReadOnly = "readonly" // serve scans and results, refuse new scans
+	SelfCheckWarn     = "warn"     // log the failure and carry on
+)
+
+// selfCheckTimeout bounds a whole self-check
+const selfCheckTimeout = 30 * time.Second
+
+// ErrReadOnly is returned for new scans while the server is read-only
+// because its self-check failed
+var ErrReadOnly = errors.New("server is read-only until its self-check passes")
+
+// validSelfCheckMode reports whether mode is one of the self-check modes
+func validSelfCheckMode(mode string) bool {
+	switch mode {
+	case SelfCheckStrict, SelfCheckReadOnly, SelfCheckWarn:
+		return true
+	}
+	return false
+}
+
+// SelfCheck checks that Redis answers, that the compute provider's
+// credentials work and can create workers with the configured region, size
+// and image, and that workers can reach the callback URL. Unless the server
+// runs in warn mode, a failed check makes it read-only until a later
+// self-check passes. The outcome is kept for the health report.
+func (o *Orchestrator) SelfCheck(ctx context.Context) types.SelfCheck {
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+
+	checks := []types.CheckResult{checkResult("redis", o.redis.Ping(ctx).Err())}
+	access := o.checkProviderAccess(ctx)
+	checks = append(checks, access)
+	if checker, ok := o.provider.(provider.SelfChecker); ok && access.Status == types.CheckPassed {
+		checks = append(checks, o.checkSlugs(ctx, checker))
+	}
+	checks = append(checks, o.checkCallbackURL(ctx))
+
+	result := types.SelfCheck{Status: types.CheckPassed, CheckedAt: time.Now().UTC(), Checks: checks}
+	for _, check := range checks {
+		switch check.Status {
+		case types.CheckFailed:
+			result.Status = types.CheckFailed
+			slog.Error("Self-check failed", "check", check.Name, "error", check.Message)
+		case types.CheckWarning:
+			if result.Status == types.CheckPassed {
+				result.Status = types.CheckWarning
+			}
+			slog.Warn("Self-check warning", "check", check.Name, "warning", check.Message)
+		}
+	}
+	result.ReadOnly = result.Status == types.CheckFailed && o.selfCheckMode != SelfCheckWarn
+
+	o.health.mutex.Lock()
+	wasReadOnly := o.health.selfCheck != nil && o.health.selfCheck.ReadOnly
+	o.health.selfCheck = &result
+	o.health.mutex.Unlock()
+
+	switch {
+	case result.ReadOnly && !wasReadOnly:
+		slog.Error("Server is read-only, new scans are refused until a self-check passes")
+	case !result.ReadOnly && wasReadOnly:
+		slog.Info("Self-check passed, new scans are accepted again")
+	}
+	return result
+}
+
+// readOnly reports whether the last self-check failed and new scans are
+// refused
+func (o *Orchestrator) readOnly() bool {
+	o.health.mutex.RLock()
+	defer o.health.mutex.RUnlock()
+	return o.health.selfCheck != nil && o.health.selfCheck.ReadOnly
+}
+
+// checkProviderAccess checks the provider's credentials, with its own check
+// when it has one and by listing regions otherwise
+func (o *Orchestrator) checkProviderAccess(ctx context.Context) types.CheckResult {
+	if checker, ok := o.provider.(provider.SelfChecker); ok {
+		return checkResult("provider_credentials", checker.CheckAccess(ctx, workerTag))
+	}
+	_, err := o.provider.Regions(ctx)
+	return checkResult("provider_credentials", err)
+}
+
+// checkSlugs checks the droplet settings workers are created with: the
+// defaults, the warm pool's and the defaults in each region scans fall back
+// to
+func (o *Orchestrator) checkSlugs(ctx context.Context, checker provider.SelfChecker) types.CheckResult {
+	defaults := DefaultDropletConfig()
+	configs := []types.DropletConfig{defaults}
+	if o.warmPool.config.Size > 0 {
+		configs = append(configs, o.warmPool.config.Droplet)
+	}
+	for _, limit := range o.regions.limits {
+		configs = append(configs, types.DropletConfig{Region: limit.Region, Size: defaults.Size, Image: defaults.Image})
+	}
+
+	var problems []string
+	checked := make(map[types.DropletConfig]bool)
+	for _, config := range configs {
+		if checked[config] {
+			continue
+		}
+		checked[config] = true
+		if err := checker.CheckSlugs(ctx, config.Region, config.Size, config.Image); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return types.CheckResult{Name: "droplet_slugs", Status: types.CheckFailed, Message: strings.Join(problems, "; ")}
+	}
+	return types.CheckResult{Name: "droplet_slugs", Status: types.CheckPassed}
+}
+
+// checkCallbackURL fails for a callback URL that is not one, and warns when
+// its host does not resolve, or only to addresses workers on other machines
+// cannot reach such as localhost. Workers sharing the server's host or
+// network may still reach those, so they are not fatal.
+func (o *Orchestrator) checkCallbackURL(ctx context.Context) types.CheckResult {
+	result := types.CheckResult{Name: "callback_url", Status: types.CheckPassed}
+	parsed, err := url.Parse(o.callbackURL)
+	if err != nil || parsed.Hostname() == "" {
+		result.Status = types.CheckFailed
+		result.Message = fmt.Sprintf("%q is not a URL workers can call", o.callbackURL)
+		return result
+	}
+	host := parsed.Hostname()
+
+	var addresses []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = []net.IP{ip}
+	} else {
+		resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			result.Status = types.CheckWarning
+			result.Message = fmt.Sprintf("%s does not resolve: %v", host, err)
+			return result
+		}
+		for _, address := range resolved {
+			addresses = append(addresses, address.IP)
+		}
+	}
+
+	private := false
+	for _, ip := range addresses {
+		if ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return result
+		}
+		private = private || ip.IsPrivate()
+	}
+	result.Status = types.CheckWarning
+	if private {
+		result.Message = fmt.Sprintf("%s is a private address, only workers in the same network can reach it", host)
+	} else {
+		result.Message = fmt.Sprintf("%s is not routable, workers will never reach it", host)
+	}
+	return result
+}
+
+// checkResult is the outcome of a check that failed with err, or passed
+func checkResult(name string, err error) types.CheckResult {
+	if err != nil {
+		return types.CheckResult{Name: name, Status: types.CheckFailed, Message: err.Error()}
+	}
+	return types.CheckResult{Name: name, Status: types.CheckPassed}
+}
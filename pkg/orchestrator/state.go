@@ -0,0 +1,104 @@
+package orchestrator
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/types"
+)
+
+// scanState holds orchestrator bookkeeping for a scan that is not part of
+// its public status
+type scanState struct {
+	secrets *secretPolicy
+	targets []string     // domains the scan was started with or added to it
+	scope   *targetScope // registered domains and IPs of the targets
+	// request holds the scan's settings, without its targets, for workers
+	// added while it runs
+	request *types.ScanRequest
+	// pendingTargets holds the added targets each worker has yet to pull,
+	// addedTargets how many were added to each worker in total
+	pendingTargets map[string][]string
+	addedTargets   map[string]int
+	// resultKeys holds the hashed dedup key of every stored result, eight
+	// bytes per finding however long its URL
+	resultKeys map[uint64]struct{}
+	notifiers  []notify.Notifier
+	webhooks   []*notify.Webhook // also in notifiers, kept for delivery status
+	forward    bool              // findings are forwarded to OpenSearch
+	emailTo    []string          // recipients of the completion report
+	// emailMinSeverity is the lowest severity listed in the report's top
+	// findings, empty for all
+	emailMinSeverity string
+	// failedWorkers counts workers whose droplet could not be created; they
+	// never register but still count towards scan completion
+	failedWorkers int
+	// workerSeen is when each worker last sent a heartbeat or log;
+	// stalledWorkers holds the status of each stalled worker before it
+	// stalled
+	workerSeen     map[string]time.Time
+	stalledWorkers map[string]string
+	// confirmedTargets counts the targets each worker of a pull scan
+	// confirmed; poolDrained is set once a worker was told the pool is
+	// drained, so it is on its way out
+	confirmedTargets map[string]int
+	poolDrained      bool
+	// doneTargets holds the targets workers of a static scan reported
+	// scanned, a segment at a time, and doneCounts how many each worker
+	// reported; releases holds what rebalancing asked of slow workers
+	doneTargets map[string]struct{}
+	doneCounts  map[string]int
+	releases    map[string]releaseRequest
+	// scanStarted is when each worker running nuclei started its current
+	// stretch, scanTime how long its finished stretches took, for the
+	// scan's throughput
+	scanStarted map[string]time.Time
+	scanTime    map[string]time.Duration
+	// coverage tracks the state of each target, for the coverage report
+	// and to tell completed scans from partial ones
+	coverage *targetCoverage
+	// changed is closed when the scan's revision next goes up, waking the
+	// clients waiting for it; nil while nobody waits
+	changed chan struct{}
+	// span is the root span of the scan's trace, ended by its cleanup. Scans
+	// restored after a restart keep the no-op span they start with.
+	span trace.Span
+	// log collects what is logged about the scan
+	log *scanLog
+	// stuckAlerted is set once operators were alerted that no worker of the
+	// scan became ready
+	stuckAlerted bool
+	// discovered maps the targets subdomain enumeration added to their apex;
+	// plannedAt is when a scan started with expand or preflight was planned,
+	// once its enumeration or preflight finished
+	discovered map[string]string
+	plannedAt  time.Time
+}
+
+func newScanState() *scanState {
+	return &scanState{
+		resultKeys:       make(map[uint64]struct{}),
+		pendingTargets:   make(map[string][]string),
+		addedTargets:     make(map[string]int),
+		workerSeen:       make(map[string]time.Time),
+		stalledWorkers:   make(map[string]string),
+		confirmedTargets: make(map[string]int),
+		doneTargets:      make(map[string]struct{}),
+		doneCounts:       make(map[string]int),
+		releases:         make(map[string]releaseRequest),
+		scanStarted:      make(map[string]time.Time),
+		scanTime:         make(map[string]time.Duration),
+		coverage:         newTargetCoverage(),
+		span:             trace.SpanFromContext(context.Background()),
+	}
+}
+
+// dedupHash packs a result ID, the first 64 bits of its dedup key's SHA-256,
+// into the dedup index's key
+func dedupHash(resultID string) uint64 {
+	hash, _ := strconv.ParseUint(resultID, 16, 64)
+	return hash
+}
@@ -0,0 +1,273 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// streamGroup is the consumer group, and its only consumer, reading the
+	// scan streams. Only the replica running a scan reads its stream.
+	streamGroup = "orchestrator"
+	// streamBlock is how long a read waits for new entries
+	streamBlock = 2 * time.Second
+	// streamBatch is the number of entries read from a stream at once
+	streamBatch = 100
+	// streamRetry is how long an entry that failed waits to be read again
+	streamRetry = 5 * time.Second
+	// streamLinger is how long the stream of an ended scan is still read,
+	// for the results of workers that outlived it
+	streamLinger = 10 * time.Minute
+	// streamTTL is how long a stream outlives its last read entry
+	streamTTL = 24 * time.Hour
+)
+
+// Kinds of the entries on a scan stream, named after the callbacks they
+// stand for
+const (
+	StreamResults   = "results"
+	StreamHeartbeat = "heartbeat"
+	StreamComplete  = "complete"
+)
+
+// workerStreamKey is the stream a scan's workers push results, heartbeats
+// and completion onto
+func workerStreamKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:stream", scanID)
+}
+
+// streamName is what redis-cli takes as host and user name unquoted
+var streamName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// workerStream is the Redis workers push onto instead of calling back
+type workerStream struct {
+	cli  string // redis-cli options of the host, port, TLS, user and database
+	auth string // base64 of the password, empty without one
+	// handle takes the entries read; set once before reading starts
+	handle func(scanID, workerID, kind string, body []byte) error
+}
+
+// newWorkerStream parses the redis:// or rediss:// URL workers push onto
+func newWorkerStream(raw string) (*workerStream, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid worker Redis URL: %w", err)
+	}
+	if parsed.Scheme != "redis" && parsed.Scheme != "rediss" {
+		return nil, errors.New("worker Redis URL must be redis:// or rediss://")
+	}
+	host := parsed.Hostname()
+	if !streamName.MatchString(host) {
+		return nil, errors.New("worker Redis URL needs a host")
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "6379"
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, errors.New("worker Redis URL has an invalid port")
+	}
+
+	options := []string{"-h", host, "-p", port}
+	if parsed.Scheme == "rediss" {
+		options = append(options, "--tls")
+	}
+	stream := &workerStream{}
+	if user := parsed.User; user != nil {
+		if name := user.Username(); name != "" && name != "default" {
+			if !streamName.MatchString(name) {
+				return nil, errors.New("worker Redis URL has an invalid user name")
+			}
+			options = append(options, "--user", name)
+		}
+		if password, ok := user.Password(); ok && password != "" {
+			stream.auth = base64.StdEncoding.EncodeToString([]byte(password))
+		}
+	}
+	if db := strings.TrimPrefix(parsed.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, errors.New("worker Redis URL has an invalid database")
+		}
+		options = append(options, "-n", db)
+	}
+	stream.cli = strings.Join(options, " ")
+	return stream, nil
+}
+
+// OnWorkerMessage hands the results, heartbeats and completions workers push
+// onto the scan streams to handle, once stored, and starts reading them.
+// Entries are acknowledged unless handle returns an error, in which case
+// they are read again. Without a worker stream workers call back instead
+// and handle is never called.
+func (o *Orchestrator) OnWorkerMessage(handle func(scanID, workerID, kind string, body []byte) error) {
+	if o.workerStream == nil {
+		return
+	}
+	o.workerStream.handle = handle
+	go o.runWorkerStreams()
+}
+
+// runWorkerStreams reads the streams of the scans running here. A stream is
+// read from its unacknowledged entries first, after a restart or a failed
+// entry, then for new ones.
+func (o *Orchestrator) runWorkerStreams() {
+	ctx := context.Background()
+	groups := make(map[string]bool)   // streams known to have the group
+	caughtUp := make(map[string]bool) // streams without unacknowledged entries
+	for {
+		scans := o.streamedScans(time.Now())
+		for key := range groups {
+			if !scans[key] {
+				o.dropWorkerStream(ctx, key)
+				delete(groups, key)
+				delete(caughtUp, key)
+			}
+		}
+
+		keys := make([]string, 0, len(scans))
+		for key := range scans {
+			if !groups[key] {
+				err := o.redis.XGroupCreateMkStream(ctx, key, streamGroup, "0").Err()
+				if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+					slog.Warn("Failed to create stream group", "stream", key, "error", err)
+					continue
+				}
+				o.redis.Expire(ctx, key, streamTTL)
+				groups[key] = true
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			time.Sleep(streamBlock)
+			continue
+		}
+
+		// Reads of unacknowledged entries do not block
+		ids := make([]string, len(keys))
+		for i, key := range keys {
+			ids[i] = ">"
+			if !caughtUp[key] {
+				ids[i] = "0"
+			}
+		}
+		read, err := o.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    streamGroup,
+			Consumer: streamGroup,
+			Streams:  append(keys, ids...),
+			Count:    streamBatch,
+			Block:    streamBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			// A stream deleted or expired meanwhile lost its group
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				groups = make(map[string]bool)
+				caughtUp = make(map[string]bool)
+			} else {
+				slog.Warn("Failed to read worker streams", "error", err)
+			}
+			time.Sleep(streamRetry)
+			continue
+		}
+
+		failed := false
+		for _, stream := range read {
+			if len(stream.Messages) == 0 {
+				caughtUp[stream.Stream] = true
+				continue
+			}
+			if !o.takeWorkerStream(ctx, stream) {
+				caughtUp[stream.Stream] = false
+				failed = true
+			}
+		}
+		if failed {
+			time.Sleep(streamRetry)
+		}
+	}
+}
+
+// streamedScans returns the streams of the scans running here, and of those
+// that ended within streamLinger
+func (o *Orchestrator) streamedScans(now time.Time) map[string]bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	scans := make(map[string]bool, len(o.activeScans))
+	for scanID, scan := range o.activeScans {
+		if scanEnded(scan.Status) && (scan.CompletedAt == nil || now.Sub(*scan.CompletedAt) > streamLinger) {
+			continue
+		}
+		scans[workerStreamKey(scanID)] = true
+	}
+	return scans
+}
+
+// takeWorkerStream hands a stream's entries to the handler in order and
+// acknowledges them, stopping at the first one that failed. It reports
+// whether every entry was taken.
+func (o *Orchestrator) takeWorkerStream(ctx context.Context, stream redis.XStream) bool {
+	scanID := strings.TrimSuffix(strings.TrimPrefix(stream.Stream, "scan:"), ":stream")
+	for _, message := range stream.Messages {
+		if err := o.takeWorkerMessage(scanID, message); err != nil {
+			slog.Warn("Failed to take worker message, reading it again", "scan_id", scanID, "entry", message.ID, "error", err)
+			return false
+		}
+		_, err := o.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAck(ctx, stream.Stream, streamGroup, message.ID)
+			pipe.XDel(ctx, stream.Stream, message.ID)
+			pipe.Expire(ctx, stream.Stream, streamTTL)
+			return nil
+		})
+		if err != nil {
+			// Taken again once read again; results are deduplicated
+			slog.Warn("Failed to acknowledge worker message", "scan_id", scanID, "entry", message.ID, "error", err)
+			return false
+		}
+	}
+	return true
+}
+
+// takeWorkerMessage checks an entry's worker token and hands it to the
+// handler. Entries that are malformed or not the worker's are dropped.
+func (o *Orchestrator) takeWorkerMessage(scanID string, message redis.XMessage) error {
+	field := func(name string) string {
+		value, _ := message.Values[name].(string)
+		return value
+	}
+	workerID, kind := field("worker"), field("kind")
+	if !o.ValidateWorkerToken(scanID, workerID, field("token")) {
+		slog.Warn("Dropping worker message with an invalid worker token", "scan_id", scanID, "worker_id", workerID, "entry", message.ID)
+		return nil
+	}
+	return o.workerStream.handle(scanID, workerID, kind, []byte(field("body")))
+}
+
+// dropWorkerStream deletes the stream of a scan no longer read
+func (o *Orchestrator) dropWorkerStream(ctx context.Context, key string) {
+	if err := o.redis.Del(ctx, key).Err(); err != nil {
+		slog.Warn("Failed to delete worker stream", "stream", key, "error", err)
+	}
+}
+
+// streamParams points a worker's bootstrap script at the worker stream, if
+// workers push onto one
+func (o *Orchestrator) streamParams(params *userDataParams) {
+	if o.workerStream == nil {
+		return
+	}
+	params.StreamCLI = o.workerStream.cli
+	params.StreamAuthB64 = o.workerStream.auth
+}
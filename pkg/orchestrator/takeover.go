@@ -0,0 +1,308 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"nuclei-distributed/pkg/types"
+)
+
+// errScanHeld is returned when a scan's copy in Redis belongs to a replica
+// that is still running
+var errScanHeld = errors.New("scan is held by another replica")
+
+// savedScanState is the part of a scan's internal state copied to Redis
+// with its status, for the replica that takes the scan over. Rebalance
+// requests, open streams and the scan's trace stay with the replica that
+// held it.
+type savedScanState struct {
+	Request          *types.ScanRequest       `json:"request"`
+	Targets          []string                 `json:"targets"`
+	Coverage         map[string]savedTarget   `json:"coverage,omitempty"`
+	StartedWorkers   []string                 `json:"startedWorkers,omitempty"`
+	PendingTargets   map[string][]string      `json:"pendingTargets,omitempty"`
+	AddedTargets     map[string]int           `json:"addedTargets,omitempty"`
+	FailedWorkers    int                      `json:"failedWorkers,omitempty"`
+	WorkerSeen       map[string]time.Time     `json:"workerSeen,omitempty"`
+	StalledWorkers   map[string]string        `json:"stalledWorkers,omitempty"`
+	ConfirmedTargets map[string]int           `json:"confirmedTargets,omitempty"`
+	PoolDrained      bool                     `json:"poolDrained,omitempty"`
+	DoneTargets      []string                 `json:"doneTargets,omitempty"`
+	DoneCounts       map[string]int           `json:"doneCounts,omitempty"`
+	ScanTime         map[string]time.Duration `json:"scanTime,omitempty"`
+	StuckAlerted     bool                     `json:"stuckAlerted,omitempty"`
+	Discovered       map[string]string        `json:"discovered,omitempty"`
+	PlannedAt        time.Time                `json:"plannedAt,omitempty"`
+}
+
+// savedTarget is a target's coverage state and the worker it is assigned to
+type savedTarget struct {
+	State  string `json:"state"`
+	Worker string `json:"worker,omitempty"`
+}
+
+// saveScanState copies a scan's state for Redis. Callers must hold o.mutex.
+func saveScanState(state *scanState) *savedScanState {
+	saved := &savedScanState{
+		Request:          state.request,
+		Targets:          append([]string(nil), state.targets...),
+		Coverage:         make(map[string]savedTarget, len(state.coverage.targets)),
+		PendingTargets:   maps.Clone(state.pendingTargets),
+		AddedTargets:     maps.Clone(state.addedTargets),
+		FailedWorkers:    state.failedWorkers,
+		WorkerSeen:       maps.Clone(state.workerSeen),
+		StalledWorkers:   maps.Clone(state.stalledWorkers),
+		ConfirmedTargets: maps.Clone(state.confirmedTargets),
+		PoolDrained:      state.poolDrained,
+		DoneCounts:       maps.Clone(state.doneCounts),
+		ScanTime:         maps.Clone(state.scanTime),
+		StuckAlerted:     state.stuckAlerted,
+		Discovered:       maps.Clone(state.discovered),
+		PlannedAt:        state.plannedAt,
+	}
+	if state.request != nil {
+		request := *state.request
+		saved.Request = &request
+	}
+	for target, entry := range state.coverage.targets {
+		saved.Coverage[target] = savedTarget{State: entry.state, Worker: entry.worker}
+	}
+	for workerID := range state.coverage.started {
+		saved.StartedWorkers = append(saved.StartedWorkers, workerID)
+	}
+	for target := range state.doneTargets {
+		saved.DoneTargets = append(saved.DoneTargets, target)
+	}
+	return saved
+}
+
+// restore rebuilds the state of a scan taken over. What the replica that
+// held it set up for the scan, such as its notifiers, is set up again.
+func (saved *savedScanState) restore(o *Orchestrator, scanID string) *scanState {
+	state := newScanState()
+	state.request = saved.Request
+	state.targets = saved.Targets
+	state.scope = newTargetScope(saved.Targets)
+	for target, entry := range saved.Coverage {
+		state.coverage.targets[target] = &targetEntry{state: entry.State, worker: entry.Worker}
+	}
+	for _, workerID := range saved.StartedWorkers {
+		state.coverage.started[workerID] = true
+	}
+	maps.Copy(state.pendingTargets, saved.PendingTargets)
+	maps.Copy(state.addedTargets, saved.AddedTargets)
+	maps.Copy(state.workerSeen, saved.WorkerSeen)
+	maps.Copy(state.stalledWorkers, saved.StalledWorkers)
+	maps.Copy(state.confirmedTargets, saved.ConfirmedTargets)
+	maps.Copy(state.doneCounts, saved.DoneCounts)
+	maps.Copy(state.scanTime, saved.ScanTime)
+	for _, target := range saved.DoneTargets {
+		state.doneTargets[target] = struct{}{}
+	}
+	state.failedWorkers = saved.FailedWorkers
+	state.poolDrained = saved.PoolDrained
+	state.stuckAlerted = saved.StuckAlerted
+	state.discovered = saved.Discovered
+	state.plannedAt = saved.PlannedAt
+
+	state.notifiers, state.webhooks = o.startNotifiers(saved.Request)
+	state.emailTo = o.emailRecipients(saved.Request)
+	_, state.emailMinSeverity = notifierRoute(saved.Request, "email")
+	state.forward = o.forwarder != nil && (saved.Request.OpenSearch == nil || *saved.Request.OpenSearch)
+	if state.log = o.scanLogs.get(scanID); state.log == nil {
+		state.log = o.scanLogs.start(scanID)
+	}
+
+	// The dedup index is rebuilt from the findings stored so far
+	page, err := o.store.QueryResults(scanID, types.ResultFilter{})
+	if err != nil {
+		slog.Warn("Failed to load findings of scan taken over, duplicates may be stored again", "scan_id", scanID,
+			"error", err)
+	} else {
+		for _, result := range page.Results {
+			state.resultKeys[dedupHash(result.ID)] = struct{}{}
+		}
+	}
+	return state
+}
+
+// adoptScan takes over a scan whose replica is gone. It claims the scan in
+// Redis, watching the copy and the old replica's registration so that only
+// one replica wins and none takes a scan whose replica came back, then picks
+// up the scan's state and workers from the copy. It reports whether this
+// replica now holds the scan.
+func (o *Orchestrator) adoptScan(ctx context.Context, scanID string) (bool, error) {
+	key := scanReplicaKey(scanID)
+	id := o.replicas.config.ID
+
+	var owner string
+	var status types.ScanStatus
+	var saved savedScanState
+	err := o.redis.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := tx.HMGet(ctx, key, "replica", "revision", "status", "state").Result()
+		if err != nil {
+			return err
+		}
+		owner, _ = stored[0].(string)
+		if owner == "" {
+			return ErrScanNotFound
+		}
+		if owner != id {
+			if err := tx.Watch(ctx, replicaKey(owner)).Err(); err != nil {
+				return err
+			}
+			alive, err := tx.Exists(ctx, replicaKey(owner)).Result()
+			if err != nil {
+				return err
+			}
+			if alive > 0 {
+				return errScanHeld
+			}
+		}
+		data, _ := stored[2].(string)
+		if err := json.Unmarshal([]byte(data), &status); err != nil {
+			return fmt.Errorf("failed to decode status: %v", err)
+		}
+		data, _ = stored[3].(string)
+		if err := json.Unmarshal([]byte(data), &saved); err != nil || saved.Request == nil {
+			return fmt.Errorf("the copy of the scan has no state to take over")
+		}
+		revision, _ := stored[1].(string)
+		status.Revision, _ = strconv.ParseInt(revision, 10, 64)
+		status.Revision++
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "replica", id, "revision", status.Revision)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, errScanHeld) || errors.Is(err, ErrScanNotFound) || errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	state := saved.restore(o, scanID)
+	o.mutex.Lock()
+	if _, exists := o.activeScans[scanID]; exists {
+		o.mutex.Unlock()
+		return true, nil
+	}
+	o.activeScans[scanID] = &status
+	o.scanStates[scanID] = state
+	o.scanChanged(&status)
+	o.mutex.Unlock()
+	slog.Warn("Took over scan from a replica that is gone", "scan_id", scanID, "replica_id", owner,
+		"status", status.Status)
+
+	switch {
+	case status.Status == ScanEnumerating:
+		o.enumerationFailed(scanID, errors.New("the replica enumerating its subdomains went away"))
+	case status.Status == ScanPreflight:
+		o.preflightFailed(scanID, "the replica checking its targets went away")
+	case !scanEnded(status.Status):
+		go o.resumeWorkers(scanID)
+	}
+	return true, nil
+}
+
+// resumeWorkers watches the workers of a scan taken over, and waits for
+// those that were still being created to come up. Workers claimed from the
+// warm pool carry its tag instead of the scan's and are left to the pool.
+func (o *Orchestrator) resumeWorkers(scanID string) {
+	ctx := context.Background()
+	droplets, err := o.provider.ListWorkersByTag(ctx, scanID)
+	if err != nil {
+		slog.Error("Failed to list the workers of a scan taken over", "scan_id", scanID, "error", err)
+		return
+	}
+
+	o.mutex.RLock()
+	ready := make(map[string]bool)
+	if scan, exists := o.activeScans[scanID]; exists {
+		for _, worker := range scan.ActiveDroplets {
+			ready[worker.ID] = true
+		}
+	}
+	assigned := make(map[string]int)
+	if state := o.scanStates[scanID]; state != nil {
+		for _, entry := range state.coverage.targets {
+			assigned[entry.worker]++
+		}
+	}
+	o.mutex.RUnlock()
+
+	for _, droplet := range droplets {
+		if ready[droplet.Name] {
+			go o.watchWorker(ctx, scanID, droplet.Name, droplet.ID, scanID)
+		} else {
+			go o.waitForWorker(ctx, scanID, droplet.Name, droplet.ID, scanID, assigned[droplet.Name])
+		}
+	}
+}
+
+// relinquishScan drops a scan another replica took over while this one
+// could not reach Redis. Its workers, secrets and copy now belong to the
+// other replica and are left alone.
+func (o *Orchestrator) relinquishScan(scanID string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if state := o.scanStates[scanID]; state != nil {
+		for _, notifier := range state.notifiers {
+			notifier.Close()
+		}
+		// Clients waiting on the scan ask again and are passed on
+		if state.changed != nil {
+			close(state.changed)
+			state.changed = nil
+		}
+	}
+	delete(o.activeScans, scanID)
+	delete(o.scanStates, scanID)
+	delete(o.replicas.dirty, scanID)
+	o.forgetWorkerTokens(scanID)
+	slog.Warn("Scan was taken over by another replica, dropping it", "scan_id", scanID)
+}
+
+// takeOverOrphans takes over the running scans whose replica is gone, so
+// their workers are watched and their callbacks served before anyone asks
+// about them. The leader does this every replicaRenew.
+func (o *Orchestrator) takeOverOrphans(ctx context.Context) {
+	ids, err := o.redis.SMembers(ctx, replicatedScans).Result()
+	if err != nil {
+		slog.Warn("Failed to list replicated scans", "error", err)
+		return
+	}
+	for _, scanID := range ids {
+		o.mutex.RLock()
+		_, local := o.activeScans[scanID]
+		o.mutex.RUnlock()
+		if local {
+			continue
+		}
+		stored, err := o.redis.HMGet(ctx, scanReplicaKey(scanID), "replica", "status").Result()
+		if err != nil {
+			continue
+		}
+		owner, _ := stored[0].(string)
+		data, _ := stored[1].(string)
+		var status types.ScanStatus
+		if owner == "" || o.replicaURL(ctx, owner) != "" || json.Unmarshal([]byte(data), &status) != nil ||
+			scanEnded(status.Status) {
+			continue
+		}
+		if _, err := o.adoptScan(ctx, scanID); err != nil {
+			slog.Warn("Failed to take over scan", "scan_id", scanID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"nuclei-distributed/pkg/provider/fake"
+	"nuclei-distributed/pkg/types"
+)
+
+// newTestReplica returns a replica sharing the Redis of server and the
+// workers of the fake provider with the other replicas of a test
+func newTestReplica(t *testing.T, server *miniredis.Miniredis, workers *fake.Provider, id string) *Orchestrator {
+	t.Helper()
+	o, err := New(Config{
+		Provider:    workers,
+		RedisURL:    server.Addr(),
+		CallbackURL: "https://scanner.example.com",
+		Settings:    types.ServerConfig{Nuclei: types.NucleiDefaults{Timeout: 10, Retries: 1, MaxHostErrors: 30}},
+		Replica:     ReplicaConfig{ID: id, URL: "http://" + id + ":8080"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return o
+}
+
+// eventually fails the test unless done reports true within five seconds
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// copiedStatus returns the status of a scan as copied to Redis
+func copiedStatus(t *testing.T, server *miniredis.Miniredis, scanID string) *types.ScanStatus {
+	t.Helper()
+	if !server.Exists(scanReplicaKey(scanID)) || server.HGet(scanReplicaKey(scanID), "state") == "" {
+		return nil
+	}
+	var status types.ScanStatus
+	if err := json.Unmarshal([]byte(server.HGet(scanReplicaKey(scanID), "status")), &status); err != nil {
+		t.Fatalf("copied status does not decode: %v", err)
+	}
+	return &status
+}
+
+// holds reports whether a replica holds a scan in memory
+func holds(o *Orchestrator, scanID string) bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	_, exists := o.activeScans[scanID]
+	return exists
+}
+
+// startCopiedScan starts a scan on a replica and waits for its worker and
+// for its copy in Redis to show the worker
+func startCopiedScan(t *testing.T, o *Orchestrator, server *miniredis.Miniredis) string {
+	t.Helper()
+	scanID := startTestScan(t, o, "example.com", "example.org")
+	eventually(t, "the scan's worker is copied to Redis", func() bool {
+		status := copiedStatus(t, server, scanID)
+		return status != nil && len(status.ActiveDroplets) == 1
+	})
+	return scanID
+}
+
+func TestWorkerTokensSharedByReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	first := newTestReplica(t, server, workers, "first")
+	second := newTestReplica(t, server, workers, "second")
+
+	scanID := startTestScan(t, first, "example.com")
+	workerID := workerName(scanID, 0)
+	waitForWorkers(t, first, scanID, 1)
+	first.mutex.RLock()
+	token := first.workerTokens[workerKey(scanID, workerID)]
+	first.mutex.RUnlock()
+
+	if stored := server.HGet(scanTokensKey(scanID), workerID); stored == "" || stored == token {
+		t.Errorf("Redis holds %q for the token, want its hash", stored)
+	}
+	if !second.ValidateWorkerToken(scanID, workerID, token) {
+		t.Error("ValidateWorkerToken() = false on the other replica")
+	}
+	if second.ValidateWorkerToken(scanID, workerID, token+"0") {
+		t.Error("ValidateWorkerToken() = true for a wrong token on the other replica")
+	}
+
+	if err := first.CleanupScan(scanID); err != nil {
+		t.Fatalf("CleanupScan() error = %v", err)
+	}
+	if second.ValidateWorkerToken(scanID, workerID, token) {
+		t.Error("ValidateWorkerToken() = true on the other replica after cleanup")
+	}
+}
+
+func TestScanTakenOverFromGoneReplica(t *testing.T) {
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	first := newTestReplica(t, server, workers, "first")
+	second := newTestReplica(t, server, workers, "second")
+
+	scanID := startCopiedScan(t, first, server)
+	workerID := workerName(scanID, 0)
+	first.mutex.RLock()
+	token := first.workerTokens[workerKey(scanID, workerID)]
+	first.mutex.RUnlock()
+	finding := types.ScanResult{WorkerID: workerID, Host: "example.com", Template: "tech-detect", Severity: "info"}
+	if _, err := first.AddResults(scanID, []types.ScanResult{finding}); err != nil {
+		t.Fatalf("AddResults() error = %v", err)
+	}
+
+	// While the first replica runs, the scan's requests go to it
+	if got := second.ScanReplica(scanID); got != "http://first:8080" {
+		t.Fatalf("ScanReplica() = %q, want the first replica", got)
+	}
+
+	// Once its registration lapses, the second replica takes the scan over
+	server.Del(replicaKey("first"))
+	if got := second.ScanReplica(scanID); got != "" {
+		t.Fatalf("ScanReplica() = %q after the first replica went away, want the scan taken over", got)
+	}
+	if owner := server.HGet(scanReplicaKey(scanID), "replica"); owner != "second" {
+		t.Errorf("scan is held by %q in Redis, want second", owner)
+	}
+	status, err := second.GetScanStatus(scanID)
+	if err != nil {
+		t.Fatalf("GetScanStatus() error = %v", err)
+	}
+	if !holds(second, scanID) || len(status.ActiveDroplets) != 1 || status.ActiveDroplets[0].ID != workerID {
+		t.Fatalf("scan taken over has workers %+v, want %s", status.ActiveDroplets, workerID)
+	}
+	if !second.ValidateWorkerToken(scanID, workerID, token) {
+		t.Error("ValidateWorkerToken() = false for the worker of the scan taken over")
+	}
+
+	// Findings stored before the takeover still count as seen
+	batch, err := second.AddResults(scanID, []types.ScanResult{finding})
+	if err != nil {
+		t.Fatalf("AddResults() error = %v", err)
+	}
+	if batch.Duplicates != 1 {
+		t.Errorf("finding stored before the takeover was not a duplicate after it: %+v", batch)
+	}
+
+	// The first replica comes back and drops its copy on its next write
+	first.mutex.Lock()
+	first.scanChanged(first.activeScans[scanID])
+	first.mutex.Unlock()
+	eventually(t, "the first replica drops the scan", func() bool { return !holds(first, scanID) })
+	server.Set(replicaKey("first"), "http://first:8080")
+	if got := first.ScanReplica(scanID); got != "http://second:8080" {
+		t.Errorf("ScanReplica() = %q on the first replica, want the second", got)
+	}
+	if owner := server.HGet(scanReplicaKey(scanID), "replica"); owner != "second" {
+		t.Errorf("scan is held by %q in Redis after the first replica came back, want second", owner)
+	}
+}
+
+func TestAdoptScanRace(t *testing.T) {
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	first := newTestReplica(t, server, workers, "first")
+	scanID := startCopiedScan(t, first, server)
+
+	// A replica still running keeps its scan
+	second := newTestReplica(t, server, workers, "second")
+	if adopted, err := second.adoptScan(context.Background(), scanID); err != nil || adopted {
+		t.Fatalf("adoptScan() = %t, %v for the scan of a running replica, want false", adopted, err)
+	}
+
+	server.Del(replicaKey("first"))
+	replicas := []*Orchestrator{second}
+	for _, id := range []string{"third", "fourth", "fifth"} {
+		replicas = append(replicas, newTestReplica(t, server, workers, id))
+	}
+	results := make([]bool, len(replicas))
+	var wg sync.WaitGroup
+	for i, replica := range replicas {
+		wg.Add(1)
+		go func(i int, replica *Orchestrator) {
+			defer wg.Done()
+			adopted, err := replica.adoptScan(context.Background(), scanID)
+			if err != nil {
+				t.Errorf("adoptScan() error = %v", err)
+			}
+			results[i] = adopted
+		}(i, replica)
+	}
+	wg.Wait()
+
+	winners := 0
+	for i, adopted := range results {
+		if adopted {
+			winners++
+			if owner := server.HGet(scanReplicaKey(scanID), "replica"); owner != replicas[i].replicas.config.ID {
+				t.Errorf("scan is held by %q in Redis, want the winner %s", owner, replicas[i].replicas.config.ID)
+			}
+		}
+		if holds(replicas[i], scanID) != adopted {
+			t.Errorf("replica %s holds the scan = %t, adopted it = %t", replicas[i].replicas.config.ID,
+				holds(replicas[i], scanID), adopted)
+		}
+	}
+	if winners != 1 {
+		t.Errorf("%d replicas took the scan over, want 1", winners)
+	}
+}
+
+func TestLeaderTakesOverOrphans(t *testing.T) {
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	first := newTestReplica(t, server, workers, "first")
+	eventually(t, "the first replica leads", first.leading)
+	second := newTestReplica(t, server, workers, "second")
+	scanID := startCopiedScan(t, second, server)
+
+	server.Del(replicaKey("second"))
+	first.takeOverOrphans(context.Background())
+	if !holds(first, scanID) {
+		t.Error("the leader did not take over the scan of a replica that is gone")
+	}
+}
+
+func TestLeaderRunsChecks(t *testing.T) {
+	server := miniredis.RunT(t)
+	workers := fake.New()
+	leader := newTestReplica(t, server, workers, "leader")
+	eventually(t, "the first replica leads", leader.leading)
+	follower := newTestReplica(t, server, workers, "follower")
+	eventually(t, "the second replica registers", func() bool { return server.Exists(replicaKey("follower")) })
+	if follower.leading() {
+		t.Fatal("both replicas lead")
+	}
+
+	scanID := startTestScan(t, follower, "example.com")
+	workerID := workerName(scanID, 0)
+	waitForWorkers(t, follower, scanID, 1)
+	stalled := func() bool {
+		follower.mutex.RLock()
+		defer follower.mutex.RUnlock()
+		_, stalled := follower.scanStates[scanID].stalledWorkers[workerID]
+		return stalled
+	}
+
+	// A follower's own ticks check nothing
+	later := time.Now().Add(time.Hour)
+	follower.leaderCheck(checkStalls, later)
+	if stalled() {
+		t.Fatal("a follower ran the stall check on its own tick")
+	}
+
+	// The leader's tick has the follower check the scans it holds, once the
+	// follower listens
+	eventually(t, "the follower marks its worker stalled", func() bool {
+		leader.leaderCheck(checkStalls, later)
+		time.Sleep(20 * time.Millisecond)
+		return stalled()
+	})
+}
@@ -0,0 +1,397 @@
+package orchestrator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// DefaultMaxInvalidTargets is the fraction of a scan's targets that may
+	// be rejected before the whole scan is refused
+	DefaultMaxInvalidTargets = 0.1
+	// maxReportedRejections is the number of rejected targets listed in a
+	// scan plan; all of them are counted
+	maxReportedRejections = 100
+	// maxTargetExpansion is the number of addresses CIDR blocks and IP
+	// ranges may expand to unless a scan allows large expansions
+	maxTargetExpansion = 65536
+	// maxLargeTargetExpansion bounds expansion even when it is allowed
+	maxLargeTargetExpansion = 1 << 20
+)
+
+// unsafeTargetChars could break the worker script or nuclei's target
+// parsing. Whitespace and control characters are rejected as well.
+const unsafeTargetChars = "\"'`\\$;|<>{}^"
+
+// InvalidTargetsError is returned when too many of a scan's targets are
+// rejected. It wraps ErrInvalidScan.
+type InvalidTargetsError struct {
+	Submitted     int
+	RejectedCount int
+	Rejected      []types.RejectedTarget // the first 100
+}
+
+func (e *InvalidTargetsError) Error() string {
+	return fmt.Sprintf("%v: %d of %d targets are invalid", ErrInvalidScan, e.RejectedCount, e.Submitted)
+}
+
+func (e *InvalidTargetsError) Unwrap() error {
+	return ErrInvalidScan
+}
+
+// normalizeTargets validates a scan's targets and returns them normalized
+// and deduplicated, with a summary of what was dropped. Hostnames, IPs,
+// host:port and http(s) URLs are accepted, and CIDR blocks and IP ranges are
+// expanded into their addresses. Hosts are lowercased, and a URL without a
+// path collapses into its bare host when both were submitted, since nuclei
+// probes both schemes for a bare host anyway.
+func normalizeTargets(submitted []string, allowLarge bool) ([]string, *types.TargetSummary, error) {
+	summary := &types.TargetSummary{Submitted: len(submitted)}
+	reject := func(target string, err error) {
+		summary.RejectedCount++
+		if len(summary.Rejected) < maxReportedRejections {
+			summary.Rejected = append(summary.Rejected, types.RejectedTarget{Target: target, Reason: err.Error()})
+		}
+	}
+
+	targets, expanded, err := expandTargets(submitted, allowLarge, reject)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary.Expanded = expanded
+
+	type entry struct {
+		target string
+		forms  int // distinct spellings collapsed into this entry
+	}
+	entries := make([]*entry, 0, len(targets))
+	byKey := make(map[string]*entry)
+
+	for _, raw := range targets {
+		target, key, err := normalizeTarget(raw)
+		if err != nil {
+			reject(raw, err)
+			continue
+		}
+		if existing, exists := byKey[key]; exists {
+			summary.Duplicates++
+			if existing.target != target {
+				existing.forms++
+			}
+			continue
+		}
+		e := &entry{target: target, forms: 1}
+		byKey[key] = e
+		entries = append(entries, e)
+	}
+
+	normalized := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.forms > 1 {
+			normalized = append(normalized, hostname(e.target))
+			continue
+		}
+		normalized = append(normalized, e.target)
+	}
+	summary.Accepted = len(normalized)
+	return normalized, summary, nil
+}
+
+// ipRange is an inclusive range of addresses of one family
+type ipRange struct {
+	first, last net.IP
+	size        uint64 // number of addresses, capped at maxLargeTargetExpansion+1
+}
+
+// expandTargets replaces CIDR blocks such as 10.10.0.0/24 and ranges such as
+// 192.168.1.1-192.168.1.50 or 192.168.1.1-50 with their addresses, leaving
+// other targets as they are. Malformed ranges are passed to reject. It
+// returns the number of addresses ranges expanded to, and fails when that
+// exceeds the expansion limit.
+func expandTargets(targets []string, allowLarge bool, reject func(string, error)) ([]string, int, error) {
+	ranges := make(map[int]ipRange)
+	var total uint64
+	for i, target := range targets {
+		r, isRange, err := parseIPRange(strings.TrimSpace(target))
+		if err != nil {
+			reject(target, err)
+			ranges[i] = ipRange{}
+			continue
+		}
+		if isRange {
+			ranges[i] = r
+			total += r.size
+		}
+	}
+	if len(ranges) == 0 {
+		return targets, 0, nil
+	}
+
+	limit := uint64(maxTargetExpansion)
+	if allowLarge {
+		limit = maxLargeTargetExpansion
+	}
+	if total > limit {
+		if allowLarge {
+			return nil, 0, fmt.Errorf("%w: IP ranges expand to more than %d addresses", ErrInvalidScan, limit)
+		}
+		return nil, 0, fmt.Errorf("%w: IP ranges expand to more than %d addresses, set allowLargeExpansion to scan up to %d",
+			ErrInvalidScan, limit, maxLargeTargetExpansion)
+	}
+
+	expanded := make([]string, 0, len(targets)-len(ranges)+int(total))
+	for i, target := range targets {
+		r, isRange := ranges[i]
+		if !isRange {
+			expanded = append(expanded, target)
+			continue
+		}
+		for ip := r.first; r.size > 0; ip = nextIP(ip) {
+			expanded = append(expanded, ip.String())
+			if ip.Equal(r.last) {
+				break
+			}
+		}
+	}
+	return expanded, int(total), nil
+}
+
+// parseIPRange recognizes CIDR blocks and dash ranges. isRange is false for
+// any other target.
+func parseIPRange(target string) (r ipRange, isRange bool, err error) {
+	if strings.Contains(target, "/") && !strings.Contains(target, "://") {
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			if net.ParseIP(strings.SplitN(target, "/", 2)[0]) != nil {
+				return r, true, fmt.Errorf("invalid CIDR block")
+			}
+			return r, false, nil
+		}
+		if ip4 := network.IP.To4(); ip4 != nil {
+			network.IP = ip4
+		}
+		ones, bits := network.Mask.Size()
+		r.first = network.IP
+		r.last = make(net.IP, len(network.IP))
+		for i := range network.IP {
+			r.last[i] = network.IP[i] | ^network.Mask[i]
+		}
+		r.size = maxLargeTargetExpansion + 1
+		if bits-ones < 21 {
+			r.size = 1 << uint(bits-ones)
+		}
+		return r, true, nil
+	}
+
+	dash := strings.Index(target, "-")
+	if dash < 0 {
+		return r, false, nil
+	}
+	first := net.ParseIP(target[:dash])
+	if first == nil {
+		return r, false, nil
+	}
+	first4 := first.To4()
+	if first4 == nil {
+		return r, true, fmt.Errorf("IPv6 ranges must use CIDR notation")
+	}
+
+	end := target[dash+1:]
+	last := net.ParseIP(end).To4()
+	if last == nil {
+		// Shorthand for the last octet, e.g. 192.168.1.1-50
+		octet, err := strconv.Atoi(end)
+		if err != nil || octet < 0 || octet > 255 {
+			return r, true, fmt.Errorf("invalid IP range")
+		}
+		last = net.IPv4(first4[0], first4[1], first4[2], byte(octet)).To4()
+	}
+
+	from, to := ipv4Int(first4), ipv4Int(last)
+	if to < from {
+		return r, true, fmt.Errorf("IP range ends before it starts")
+	}
+	r.first, r.last = first4, last
+	r.size = uint64(to-from) + 1
+	return r, true, nil
+}
+
+func ipv4Int(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+// nextIP returns the address after ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// normalizeTarget checks one target and returns its normalized form and the
+// key equivalent targets share
+func normalizeTarget(target string) (string, string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", "", fmt.Errorf("empty target")
+	}
+	if i := strings.IndexFunc(target, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(unsafeTargetChars, r)
+	}); i >= 0 {
+		return "", "", fmt.Errorf("contains the character %q", target[i:i+1])
+	}
+
+	if !strings.Contains(target, "://") {
+		host, port := target, ""
+		if h, p, err := net.SplitHostPort(target); err == nil {
+			host, port = h, p
+		}
+		host, err := checkHost(host)
+		if err != nil {
+			return "", "", err
+		}
+		if port != "" {
+			if err := checkPort(port); err != nil {
+				return "", "", err
+			}
+			return net.JoinHostPort(host, port), net.JoinHostPort(host, port), nil
+		}
+		return host, host, nil
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("not a valid URL")
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", "", fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.User != nil {
+		return "", "", fmt.Errorf("URLs with credentials are not allowed")
+	}
+	host, err := checkHost(parsed.Hostname())
+	if err != nil {
+		return "", "", err
+	}
+	port := parsed.Port()
+	if port != "" {
+		if err := checkPort(port); err != nil {
+			return "", "", err
+		}
+	}
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	parsed.Scheme = scheme
+	parsed.Host = host
+	if strings.Contains(host, ":") {
+		parsed.Host = "[" + host + "]"
+	}
+	if port != "" {
+		parsed.Host = net.JoinHostPort(host, port)
+	}
+	if parsed.Path == "/" && parsed.RawQuery == "" && parsed.Fragment == "" {
+		parsed.Path = ""
+	}
+	parsed.Fragment = ""
+
+	normalized := parsed.String()
+	key := normalized
+	if port == "" && parsed.Path == "" && parsed.RawQuery == "" {
+		// Equivalent to the bare host for nuclei
+		key = host
+	}
+	return normalized, key, nil
+}
+
+// checkHost lowercases a hostname or IP and checks that it is valid
+func checkHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if host == "" {
+		return "", fmt.Errorf("missing host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	if len(host) > 253 {
+		return "", fmt.Errorf("hostname longer than 253 characters")
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return "", fmt.Errorf("invalid hostname")
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("hostname labels cannot start or end with a hyphen")
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '-' && r != '_' {
+				return "", fmt.Errorf("invalid character %q in hostname", r)
+			}
+		}
+	}
+	return host, nil
+}
+
+func checkPort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// checkTargets normalizes a scan's targets in place and removes blocklisted
+// ones. The scan is refused when none are left or more than the configured
+// fraction was rejected.
+func (o *Orchestrator) checkTargets(req *types.ScanRequest) (*types.TargetSummary, error) {
+	targets, summary, err := normalizeTargets(req.Domains, req.AllowLargeExpansion)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 || float64(summary.RejectedCount) > o.settings().MaxInvalidTargets*float64(summary.Submitted) {
+		return nil, &InvalidTargetsError{
+			Submitted:     summary.Submitted,
+			RejectedCount: summary.RejectedCount,
+			Rejected:      summary.Rejected,
+		}
+	}
+
+	targets, err = o.blockedTargets(req.ID, targets, summary)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, &BlockedTargetsError{BlockedCount: summary.BlockedCount, Blocked: summary.Blocked}
+	}
+	summary.Accepted = len(targets)
+	req.Domains = targets
+	return summary, nil
+}
+
+// shuffleTargets reorders targets so the hosts of one organization, which
+// sit together in sorted lists, are spread across workers rather than all
+// hitting the same origins from one of them. The order follows from the
+// scan ID, so a scan's plan can be reproduced.
+func shuffleTargets(targets []string, scanID string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(scanID))
+	shuffle := rand.New(rand.NewSource(int64(hash.Sum64())))
+	shuffle.Shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+}
@@ -0,0 +1,95 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log/slog"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// DefaultDegradedMemoryPercent is the memory use above which a worker
+	// is degraded
+	DefaultDegradedMemoryPercent = 90
+	// DefaultDegradedDiskFreeMB is the free disk below which a worker is
+	// degraded; full disks fail workers without a word
+	DefaultDegradedDiskFreeMB = 1024
+	// telemetryHistory is the number of samples kept per worker, five
+	// minutes of heartbeats
+	telemetryHistory = 10
+)
+
+// DegradedThresholds are the limits past which a worker is reported as
+// short of resources
+type DegradedThresholds struct {
+	MemoryPercent int // memory in use, in percent of the total
+	DiskFreeMB    int // free disk on the worker's work directory
+}
+
+// withDefaults fills in unset thresholds
+func (t DegradedThresholds) withDefaults() DegradedThresholds {
+	if t.MemoryPercent <= 0 {
+		t.MemoryPercent = DefaultDegradedMemoryPercent
+	}
+	if t.DiskFreeMB <= 0 {
+		t.DiskFreeMB = DefaultDegradedDiskFreeMB
+	}
+	return t
+}
+
+// RecordWorkerTelemetry stores a resource sample from a worker's heartbeat.
+// A worker crossing a threshold is marked degraded and announced with a
+// worker_degraded event; one back within them goes back to normal.
+func (o *Orchestrator) RecordWorkerTelemetry(scanID, workerID string, sample types.WorkerTelemetry) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	scan, exists := o.activeScans[scanID]
+	worker := o.findWorker(scanID, workerID)
+	if !exists || worker == nil {
+		return
+	}
+
+	// The history is replaced, not appended to, as status snapshots share it
+	start := max(len(worker.TelemetryHistory)+1-telemetryHistory, 0)
+	history := make([]types.WorkerTelemetry, 0, telemetryHistory)
+	history = append(history, worker.TelemetryHistory[start:]...)
+	worker.TelemetryHistory = append(history, sample)
+	worker.Telemetry = &sample
+	recovered := o.workerSeen(scanID, worker)
+
+	degraded := o.degradation(sample)
+	switch {
+	case degraded != "" && worker.Degraded == "":
+		slog.Warn("Worker degraded", "scan_id", scanID, "worker_id", workerID, "reason", degraded)
+		worker.Degraded = degraded
+		o.workerEvent(scanID, types.WorkerDegraded, worker)
+	case degraded == "" && worker.Degraded != "":
+		slog.Info("Worker no longer degraded", "scan_id", scanID, "worker_id", workerID)
+		worker.Degraded = ""
+		o.workerEvent(scanID, types.WorkerProgress, worker)
+	default:
+		worker.Degraded = degraded
+		if recovered {
+			o.workerEvent(scanID, types.WorkerProgress, worker)
+		}
+	}
+	o.scanChanged(scan)
+}
+
+// degradation says which thresholds a sample crossed, empty for none
+func (o *Orchestrator) degradation(sample types.WorkerTelemetry) string {
+	var reason string
+	if sample.MemoryTotalMB > 0 {
+		if used := sample.MemoryUsedMB * 100 / sample.MemoryTotalMB; used >= o.degraded.MemoryPercent {
+			reason = fmt.Sprintf("memory %d%% used", used)
+		}
+	}
+	if sample.DiskTotalMB > 0 && sample.DiskFreeMB < o.degraded.DiskFreeMB {
+		if reason != "" {
+			reason += ", "
+		}
+		reason += fmt.Sprintf("disk %dMB free", sample.DiskFreeMB)
+	}
+	return reason
+}
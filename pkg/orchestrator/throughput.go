@@ -0,0 +1,237 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// throughputSamples is the number of recent scans kept per profile
+	throughputSamples = 20
+	// minThroughputSamples is the number of past scans a profile needs
+	// before plans trust its median over the capacity table
+	minThroughputSamples = 3
+	// minSampleDropletHours keeps scans too short to measure out of the
+	// history
+	minSampleDropletHours = 1.0 / 60
+	// throughputProfilesKey is the Redis set of the profiles' sample lists
+	throughputProfilesKey = "throughput:profiles"
+)
+
+// Where a plan's throughput came from, see types.ScanPlan.ThroughputSource
+const (
+	ThroughputHistory  = "history"
+	ThroughputCapacity = "capacity"
+	ThroughputDefault  = "default"
+)
+
+// templateSelectionFlags are the extra flags that change which templates
+// run, and so how long a target takes
+var templateSelectionFlags = map[string]bool{
+	"tags":         true,
+	"include-tags": true,
+	"exclude-tags": true,
+	"template-id":  true,
+	"exclude-id":   true,
+	"author":       true,
+	"dast":         true,
+	"fuzz":         true,
+}
+
+// throughputKey is the Redis list of a profile's samples, newest first
+func throughputKey(dropletSize, templateSet string) string {
+	return fmt.Sprintf("throughput:%s:%s", dropletSize, templateSet)
+}
+
+// templateSet names the templates a scan runs from its severity, headless
+// setting and template selection flags, with list values sorted, e.g.
+// "severity=critical,high tags=cve". Scans selecting nothing run "default".
+func templateSet(req *types.ScanRequest) string {
+	var parts []string
+	for _, flag := range req.ExtraFlags {
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "-"), "=")
+		if !templateSelectionFlags[name] {
+			continue
+		}
+		if value == "" {
+			parts = append(parts, name)
+		} else {
+			parts = append(parts, name+"="+sortedList(value))
+		}
+	}
+	if req.Severity != "" {
+		parts = append(parts, "severity="+sortedList(strings.ReplaceAll(req.Severity, " ", "")))
+	}
+	if req.Headless {
+		parts = append(parts, "headless")
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// sortedList sorts the items of a comma-separated list
+func sortedList(list string) string {
+	items := strings.Split(list, ",")
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// throughputSample measures a completed scan: the targets its completed
+// workers scanned over the hours they ran nuclei. It returns nil when
+// there is too little to measure. Callers must hold o.mutex.
+func throughputSample(scan *types.ScanStatus, state *scanState) *types.ThroughputSample {
+	// Scans planned before throughput was recorded have no template set
+	if scan.Plan == nil || scan.Plan.TemplateSet == "" || scan.CompletedAt == nil {
+		return nil
+	}
+	domains := 0
+	var scanning time.Duration
+	for _, worker := range scan.ActiveDroplets {
+		if worker.Status == "completed" {
+			domains += worker.DomainsScanned
+			scanning += state.scanTime[worker.ID]
+		}
+	}
+	hours := scanning.Hours()
+	if domains == 0 || hours < minSampleDropletHours {
+		return nil
+	}
+	return &types.ThroughputSample{
+		ScanID:                scan.ID,
+		DropletSize:           scan.Plan.DropletSize,
+		TemplateSet:           scan.Plan.TemplateSet,
+		Domains:               domains,
+		DropletHours:          hours,
+		DomainsPerDropletHour: float64(domains) / hours,
+		CompletedAt:           *scan.CompletedAt,
+	}
+}
+
+// workerScanning marks the start of a worker's stretch of running nuclei.
+// Callers must hold o.mutex.
+func workerScanning(state *scanState, workerID string, now time.Time) {
+	if _, running := state.scanStarted[workerID]; !running {
+		state.scanStarted[workerID] = now
+	}
+}
+
+// workerStopped adds a worker's stretch of running nuclei to its scanning
+// time. Callers must hold o.mutex.
+func workerStopped(state *scanState, workerID string, now time.Time) {
+	if started, running := state.scanStarted[workerID]; running {
+		state.scanTime[workerID] += now.Sub(started)
+		delete(state.scanStarted, workerID)
+	}
+}
+
+// recordThroughput adds a completed scan's sample to its profile's history
+func (o *Orchestrator) recordThroughput(sample *types.ThroughputSample) {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := throughputKey(sample.DropletSize, sample.TemplateSet)
+	pipe := o.redis.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, throughputSamples-1)
+	pipe.SAdd(ctx, throughputProfilesKey, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("Failed to record scan throughput", "scan_id", sample.ScanID, "error", err)
+		return
+	}
+	slog.Info("Recorded scan throughput", "scan_id", sample.ScanID, "droplet_size", sample.DropletSize,
+		"template_set", sample.TemplateSet, "domains_per_droplet_hour", sample.DomainsPerDropletHour)
+}
+
+// throughputProfile reads a profile's samples, newest first, and their median
+func (o *Orchestrator) throughputProfile(ctx context.Context, key string) (*types.ThroughputProfile, error) {
+	values, err := o.redis.LRange(ctx, key, 0, throughputSamples-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	profile := &types.ThroughputProfile{Samples: []types.ThroughputSample{}}
+	rates := make([]float64, 0, len(values))
+	for _, value := range values {
+		var sample types.ThroughputSample
+		if json.Unmarshal([]byte(value), &sample) != nil {
+			continue
+		}
+		profile.DropletSize = sample.DropletSize
+		profile.TemplateSet = sample.TemplateSet
+		profile.Samples = append(profile.Samples, sample)
+		rates = append(rates, sample.DomainsPerDropletHour)
+	}
+	profile.MedianDomainsPerHour = median(rates)
+	profile.UsedForPlanning = len(rates) >= minThroughputSamples
+	return profile, nil
+}
+
+// plannedThroughput returns the median throughput of past scans with the
+// same droplet size and template set, and how many there were. It returns
+// zero when there are too few, or the history cannot be read.
+func (o *Orchestrator) plannedThroughput(ctx context.Context, dropletSize, templateSet string) (float64, int) {
+	profile, err := o.throughputProfile(ctx, throughputKey(dropletSize, templateSet))
+	if err != nil {
+		slog.Warn("Failed to read scan throughput, planning without it", "droplet_size", dropletSize,
+			"template_set", templateSet, "error", err)
+		return 0, 0
+	}
+	if !profile.UsedForPlanning {
+		return 0, 0
+	}
+	return profile.MedianDomainsPerHour, len(profile.Samples)
+}
+
+// ThroughputProfiles returns the throughput history of every droplet size
+// and template set scans ran with, ordered by size and template set
+func (o *Orchestrator) ThroughputProfiles(ctx context.Context) ([]types.ThroughputProfile, error) {
+	keys, err := o.redis.SMembers(ctx, throughputProfilesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	profiles := []types.ThroughputProfile{}
+	for _, key := range keys {
+		profile, err := o.throughputProfile(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(profile.Samples) > 0 {
+			profiles = append(profiles, *profile)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].DropletSize != profiles[j].DropletSize {
+			return profiles[i].DropletSize < profiles[j].DropletSize
+		}
+		return profiles[i].TemplateSet < profiles[j].TemplateSet
+	})
+	return profiles, nil
+}
+
+// median returns the middle of values, or the mean of the two middle ones;
+// zero for none
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return (sorted[middle-1] + sorted[middle]) / 2
+}
@@ -0,0 +1,105 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"nuclei-distributed/pkg/notify"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrTicketsDisabled is returned when no Jira project is configured
+var ErrTicketsDisabled = errors.New("Jira is not configured")
+
+// ErrTicketFailed is returned when Jira does not accept an issue
+var ErrTicketFailed = errors.New("failed to create Jira issue")
+
+// ticketQueueSize is the number of findings waiting to be filed automatically
+const ticketQueueSize = 1000
+
+// ticketJob is a finding waiting to be filed automatically
+type ticketJob struct {
+	scanID   string
+	resultID string
+}
+
+// CreateTicket files a Jira issue for a finding and links it to the finding.
+// A finding is only ever filed once: when it already has an issue, or Jira
+// has one labelled for the same finding from an earlier scan, that issue is
+// linked instead. created reports whether a new issue was filed.
+func (o *Orchestrator) CreateTicket(scanID, resultID string) (result *types.ScanResult, created bool, err error) {
+	if o.jira == nil {
+		return nil, false, ErrTicketsDisabled
+	}
+	if _, err := o.GetScanRecord(scanID); err != nil {
+		return nil, false, err
+	}
+
+	// Serialized so two requests for one finding cannot both file an issue
+	o.ticketMutex.Lock()
+	defer o.ticketMutex.Unlock()
+
+	result, err = o.store.GetResult(scanID, resultID)
+	if errors.Is(err, storage.ErrResultNotFound) {
+		return nil, false, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if result.Ticket != nil {
+		return result, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ticket, err := o.jira.Find(ctx, *result)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrTicketFailed, err)
+	}
+	if ticket == nil {
+		ticket, err = o.jira.Create(ctx, *result, o.scanURL(scanID, "report.html"))
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %v", ErrTicketFailed, err)
+		}
+		created = true
+		slog.Info("Created Jira issue", "scan_id", scanID, "result_id", resultID, "issue", ticket.Key)
+	}
+
+	if err := o.store.SaveTicket(scanID, resultID, *ticket); err != nil {
+		return nil, false, fmt.Errorf("issue %s was filed but could not be saved: %v", ticket.Key, err)
+	}
+	o.touchScan(scanID)
+	result.Ticket = ticket
+	return result, created, nil
+}
+
+// queueTicket files an issue in the background when a new finding meets the
+// automatic filing threshold. False positives are never filed.
+func (o *Orchestrator) queueTicket(scanID string, result *types.ScanResult) {
+	if o.jira == nil || o.jira.AutoSeverity() == "" {
+		return
+	}
+	if !notify.MeetsSeverity(result.Severity, o.jira.AutoSeverity()) || result.TriageStatus() == types.TriageFalsePositive {
+		return
+	}
+
+	select {
+	case o.ticketQueue <- ticketJob{scanID: scanID, resultID: result.ID}:
+	default:
+		slog.Warn("Jira queue full, not filing result", "scan_id", scanID, "result_id", result.ID)
+	}
+}
+
+// runTickets files the findings queued for automatic issue creation
+func (o *Orchestrator) runTickets() {
+	for job := range o.ticketQueue {
+		if _, _, err := o.CreateTicket(job.scanID, job.resultID); err != nil {
+			slog.Error("Failed to file Jira issue", "scan_id", job.scanID, "result_id", job.resultID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func workerKey(scanID, workerID string) string {
+	return scanID + "/" + workerID
+}
+
+// scanTokensKey holds the hashed callback tokens of a scan's workers, so any
+// replica that takes the scan over can check them
+func scanTokensKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:tokens", scanID)
+}
+
+// hashWorkerToken hashes a token, so Redis never holds one that works
+func hashWorkerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newWorkerToken creates the bearer token a worker uses for its callbacks,
+// and the tickets that open WebSockets
+func newWorkerToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueWorkerToken generates and remembers the callback token for a worker.
+// Replicas also keep its hash in Redis.
+func (o *Orchestrator) issueWorkerToken(scanID, workerID string) (string, error) {
+	token, err := newWorkerToken()
+	if err != nil {
+		return "", err
+	}
+	if o.replicated() {
+		ctx := context.Background()
+		_, err := o.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, scanTokensKey(scanID), workerID, hashWorkerToken(token))
+			pipe.Expire(ctx, scanTokensKey(scanID), snapshotTTL)
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to store worker token: %v", err)
+		}
+	}
+
+	o.mutex.Lock()
+	o.workerTokens[workerKey(scanID, workerID)] = token
+	o.mutex.Unlock()
+
+	return token, nil
+}
+
+// ValidateWorkerToken reports whether token is the callback token issued to
+// the given worker. Replicas check the tokens of workers they did not start
+// against Redis. Failures count towards the worker auth alert.
+func (o *Orchestrator) ValidateWorkerToken(scanID, workerID, token string) bool {
+	o.mutex.RLock()
+	expected, exists := o.workerTokens[workerKey(scanID, workerID)]
+	o.mutex.RUnlock()
+
+	valid := false
+	switch {
+	case token == "":
+	case exists:
+		valid = subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+	case o.replicated():
+		stored, err := o.redis.HGet(context.Background(), scanTokensKey(scanID), workerID).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			slog.Warn("Failed to look up worker token", "scan_id", scanID, "worker_id", workerID, "error", err)
+		}
+		valid = stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(hashWorkerToken(token))) == 1
+	}
+	if !valid {
+		o.alerts.workerAuthFailed(scanID, workerID)
+	}
+	return valid
+}
+
+// forgetWorkerTokens drops the tokens of a scan's workers held in memory.
+// Callers must hold o.mutex.
+func (o *Orchestrator) forgetWorkerTokens(scanID string) {
+	for key := range o.workerTokens {
+		if strings.HasPrefix(key, scanID+"/") {
+			delete(o.workerTokens, key)
+		}
+	}
+}
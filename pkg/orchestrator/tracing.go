@@ -0,0 +1,49 @@
+package orchestrator
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of scans. It is a no-op unless tracing was set up,
+// in which case each scan gets a trace of its own: planning, creating and
+// waiting for each droplet, the workers' calls and the cleanup.
+var tracer = otel.Tracer("nuclei-distributed/pkg/orchestrator")
+
+// scanTraceContext is a context carrying the scan's span, for the spans of
+// work done for the scan outside its request
+func (o *Orchestrator) scanTraceContext(scanID string) context.Context {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	if state := o.scanStates[scanID]; state != nil {
+		return trace.ContextWithSpan(context.Background(), state.span)
+	}
+	return context.Background()
+}
+
+// traceparent is the W3C traceparent header of ctx's span, which workers
+// send so their calls join the scan's trace; empty when tracing is off
+func traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// endSpan ends a span, marking it failed with err if set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// workerAttributes describe the worker a span is about
+func workerAttributes(scanID, workerID string) trace.SpanStartEventOption {
+	return trace.WithAttributes(attribute.String("scan.id", scanID), attribute.String("worker.id", workerID))
+}
@@ -0,0 +1,169 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrInvalidTriage is returned for triage updates with an unknown status
+var ErrInvalidTriage = errors.New("invalid triage")
+
+// ErrResultNotFound is returned when a scan has no finding with a given ID
+var ErrResultNotFound = errors.New("result not found")
+
+// maxTriageNote bounds the note stored with a verdict
+const maxTriageNote = 4096
+
+// SetTriage records an analyst's verdict on a finding and returns the
+// finding with it. Verdicts are kept in storage next to the finding and
+// tallied on the scan so stats can leave false positives out.
+func (o *Orchestrator) SetTriage(scanID, resultID, status, note string) (*types.ScanResult, error) {
+	if !types.TriageStatuses[status] {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidTriage, status)
+	}
+	if len(note) > maxTriageNote {
+		return nil, fmt.Errorf("%w: note longer than %d bytes", ErrInvalidTriage, maxTriageNote)
+	}
+	if _, err := o.GetScanRecord(scanID); err != nil {
+		return nil, err
+	}
+
+	triage := types.Triage{Status: status, Note: note, UpdatedAt: time.Now().UTC()}
+
+	// Serialized so concurrent verdicts on one finding keep the tallies right
+	o.triageMutex.Lock()
+	defer o.triageMutex.Unlock()
+
+	previous, err := o.store.SetTriage(scanID, resultID, triage)
+	if errors.Is(err, storage.ErrResultNotFound) {
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	from := previous.TriageStatus()
+	if from != status {
+		if err := o.countTriage(scanID, previous.Severity, from, status); err != nil {
+			return nil, err
+		}
+	}
+
+	o.touchScan(scanID)
+
+	result := *previous
+	result.Triage = &triage
+	return &result, nil
+}
+
+// countTriage moves a finding between the triage tallies of its scan, in
+// memory when the scan is loaded and in its stored record either way
+func (o *Orchestrator) countTriage(scanID, severity, from, to string) error {
+	o.mutex.Lock()
+	if scan, exists := o.activeScans[scanID]; exists {
+		moveTriage(&scan.TriageCounts, severity, from, to)
+		record := scanRecord(scan, o.scanStates[scanID])
+		o.mutex.Unlock()
+		return o.store.SaveScan(record)
+	}
+	o.mutex.Unlock()
+
+	record, err := o.store.GetScan(scanID)
+	if err != nil {
+		return err
+	}
+	moveTriage(&record.TriageCounts, severity, from, to)
+	return o.store.SaveScan(*record)
+}
+
+// moveTriage updates counts for a finding whose status changed from one
+// triage status to another. Open findings are not tallied.
+func moveTriage(counts *types.TriageCounts, severity, from, to string) {
+	if counts.Triaged == nil {
+		counts.Triaged = make(map[string]int)
+	}
+	if counts.FalsePositives == nil {
+		counts.FalsePositives = make(map[string]int)
+	}
+	severity = storage.IndexedSeverity(severity)
+
+	if from != types.TriageOpen {
+		decrement(counts.Triaged, from)
+	}
+	if to != types.TriageOpen {
+		counts.Triaged[to]++
+	}
+	if from == types.TriageFalsePositive {
+		decrement(counts.FalsePositives, severity)
+	}
+	if to == types.TriageFalsePositive {
+		counts.FalsePositives[severity]++
+	}
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+func copyTriageCounts(counts types.TriageCounts) types.TriageCounts {
+	copied := types.TriageCounts{}
+	if counts.Triaged != nil {
+		copied.Triaged = make(map[string]int, len(counts.Triaged))
+		for status, count := range counts.Triaged {
+			copied.Triaged[status] = count
+		}
+	}
+	if counts.FalsePositives != nil {
+		copied.FalsePositives = make(map[string]int, len(counts.FalsePositives))
+		for severity, count := range counts.FalsePositives {
+			copied.FalsePositives[severity] = count
+		}
+	}
+	return copied
+}
+
+// ScanStats returns a scan's finding counts by severity and triage status,
+// optionally without the findings triaged as false positives
+func (o *Orchestrator) ScanStats(scanID string, excludeFalsePositives bool) (*types.ScanStats, error) {
+	record, err := o.GetScanRecord(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &types.ScanStats{
+		ResultCount:           record.ResultCount,
+		SeverityCounts:        make(map[string]int),
+		TriageCounts:          make(map[string]int),
+		ExcludeFalsePositives: excludeFalsePositives,
+	}
+	for severity, count := range record.SeverityCounts {
+		if excludeFalsePositives {
+			count -= record.FalsePositives[severity]
+		}
+		if count > 0 {
+			stats.SeverityCounts[severity] = count
+		}
+	}
+
+	open := record.ResultCount
+	for status, count := range record.Triaged {
+		open -= count
+		if status == types.TriageFalsePositive && excludeFalsePositives {
+			stats.ResultCount -= count
+			continue
+		}
+		stats.TriageCounts[status] = count
+	}
+	if open > 0 {
+		stats.TriageCounts[types.TriageOpen] = open
+	}
+	return stats, nil
+}
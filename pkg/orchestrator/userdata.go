@@ -0,0 +1,951 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"strings"
+	"text/template"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+// userDataParams holds the values substituted into the worker bootstrap script
+type userDataParams struct {
+	ScanID      string
+	WorkerID    string
+	WorkerToken string
+	ServerURL   string
+	CurlTLS     string
+	DomainsB64  string
+	Headless    bool
+	Probe       bool
+	ExtraFlags  string
+	Severity    string
+	RateLimit   int
+	Timeout     int
+	Retries     *int // nil leaves nuclei's own default
+	MaxHostErr  int
+	HasConfig   bool
+	MaxRestarts int
+	Pull        bool // lease targets from the scan's pool instead of DomainsB64
+	BatchSize   int
+	SegmentSize int // targets a static worker scans between checkpoints
+	// InterruptionWatch exits once the provider is about to reclaim the
+	// worker, empty when it never does
+	InterruptionWatch string
+	// WorkDir holds the worker's files and is its HOME, /root on machines
+	// of its own
+	WorkDir string
+	// Foreground runs the worker in the bootstrap's process, which the
+	// provider tracks, instead of as a service
+	Foreground bool
+	// Pooled renders the job a warm pool worker runs for a scan: the tools
+	// and templates are installed already, and the pool agent waits for
+	// the worker to exit
+	Pooled bool
+	// Hold waits for the scan's release before any traffic to the targets
+	Hold bool
+	// Traceparent is sent with every call to the server so the calls join
+	// the scan's trace, empty when tracing is off
+	Traceparent string
+	// StreamCLI holds the redis-cli options of the Redis the worker pushes
+	// results, heartbeats and completion onto instead of calling back,
+	// empty when it calls back
+	StreamCLI     string
+	StreamAuthB64 string // the Redis password, empty without one
+}
+
+// Stream reports whether the worker pushes onto its scan's stream
+func (p userDataParams) Stream() bool {
+	return p.StreamCLI != ""
+}
+
+// maxNucleiRestarts is how often the worker restarts a crashed nuclei process
+// before giving up on its chunk
+const maxNucleiRestarts = 3
+
+// userDataTemplate is the bootstrap script of a worker. Its install block,
+// which sets up nuclei and the tools around it, is shared with the pool agent.
+var userDataTemplate = template.Must(template.New("userdata").Parse(`#!/bin/bash
+export DEBIAN_FRONTEND=noninteractive
+
+# Set up environment
+umask 077
+mkdir -p {{.WorkDir}}
+cat > {{.WorkDir}}/worker.env <<'ENV'
+HOME={{.WorkDir}}
+SCAN_ID={{.ScanID}}
+WORKER_ID={{.WorkerID}}
+WORKER_TOKEN={{.WorkerToken}}
+SERVER_URL={{.ServerURL}}
+CURL_TLS="{{.CurlTLS}}"
+CURL_TRACE="{{if .Traceparent}}-H traceparent:{{.Traceparent}}{{end}}"
+{{- if .Stream}}
+STREAM_CLI="{{.StreamCLI}}"
+STREAM_AUTH_B64={{.StreamAuthB64}}
+{{- end}}
+MAX_RESTARTS={{.MaxRestarts}}
+PROBE={{.Probe}}
+{{- if .Pull}}
+BATCH_SIZE={{.BatchSize}}
+{{- else}}
+SEGMENT_SIZE={{.SegmentSize}}
+{{- end}}
+NUCLEI_FLAGS="-jsonl -no-color -stats -stats-json -stats-interval 15
+{{- if .HasConfig}} -config {{.WorkDir}}/nuclei-config.yaml{{end}}
+{{- if .Headless}} -headless{{end}}
+{{- if .Severity}} -severity={{.Severity}}{{end}}
+{{- if .RateLimit}} -rate-limit={{.RateLimit}}{{end}}
+{{- if .Timeout}} -timeout={{.Timeout}}{{end}}
+{{- with .Retries}} -retries={{.}}{{end}}
+{{- if .MaxHostErr}} -max-host-error={{.MaxHostErr}}{{end}}
+{{- if .ExtraFlags}} {{.ExtraFlags}}{{end}}"
+ENV
+set -a
+. {{.WorkDir}}/worker.env
+set +a
+export DOMAINS_B64={{.DomainsB64}}
+
+# Ship a log line to the orchestrator: send_log <type> <message>
+send_log() {
+    jq -cn --arg type "$1" --arg msg "$2" --arg ts "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+        '[{timestamp: $ts, type: $type, message: $msg}]' | \
+    curl $CURL_TLS $CURL_TRACE -s -X POST \
+        -H "Content-Type: application/json" \
+        -H "Authorization: Bearer $WORKER_TOKEN" \
+        --data-binary @- \
+        "$SERVER_URL/api/v1/logs/$SCAN_ID/$WORKER_ID" > /dev/null || true
+}
+
+{{if not .Pooled}}{{template "install" .}}{{end}}# Decode domains, none for workers that lease them
+echo $DOMAINS_B64 | base64 -d > {{.WorkDir}}/domains.txt
+
+{{if not .Pooled}}# Download and run worker script
+curl -L https://raw.githubusercontent.com/projectdiscovery/nuclei/main/nuclei-templates.tar.gz | tar -xzf - -C {{.WorkDir}}/
+
+{{end}}{{if .HasConfig}}# Fetch the scan's nuclei config file
+if curl $CURL_TLS $CURL_TRACE -sf \
+    -H "Authorization: Bearer $WORKER_TOKEN" \
+    -o {{.WorkDir}}/nuclei-config.yaml \
+    "$SERVER_URL/api/v1/config/$SCAN_ID/$WORKER_ID"; then
+    send_log info "Fetched nuclei config"
+else
+    send_log error "Failed to fetch nuclei config"
+    touch {{.WorkDir}}/nuclei-config.yaml
+fi
+
+{{end}}# Fetch template variables once; they never appear in user data. The
+# response goes to a file first so a failed fetch is told from an empty one.
+touch {{.WorkDir}}/secrets.json {{.WorkDir}}/secrets.env
+chmod 600 {{.WorkDir}}/secrets.json {{.WorkDir}}/secrets.env
+if curl $CURL_TLS $CURL_TRACE -sf --retry 5 --retry-delay 2 \
+    -H "Authorization: Bearer $WORKER_TOKEN" \
+    -o {{.WorkDir}}/secrets.json \
+    "$SERVER_URL/api/v1/secrets/$SCAN_ID/$WORKER_ID" && \
+    jq -r '.secrets | to_entries[] | "\(.key)=\(.value)"' {{.WorkDir}}/secrets.json > {{.WorkDir}}/secrets.env; then
+    send_log info "Fetched $(wc -l < {{.WorkDir}}/secrets.env) template variables"
+else
+    send_log error "Failed to fetch template variables"
+fi
+rm -f {{.WorkDir}}/secrets.json
+
+# The scan itself runs as a service so it comes back after a reboot
+cat > {{.WorkDir}}/worker.sh <<'WORKER'
+#!/bin/bash
+RESUME_FILE={{.WorkDir}}/nuclei-resume.cfg
+RESTART_FILE={{.WorkDir}}/nuclei-restarts
+{{- if .Stream}}
+if [ -n "$STREAM_AUTH_B64" ]; then
+    export REDISCLI_AUTH=$(echo $STREAM_AUTH_B64 | base64 -d)
+fi
+{{- end}}
+
+# Ship a log line to the orchestrator: send_log <type> <message>
+send_log() {
+    jq -cn --arg type "$1" --arg msg "$2" --arg ts "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+        '[{timestamp: $ts, type: $type, message: $msg}]' | \
+    curl $CURL_TLS $CURL_TRACE -s -X POST \
+        -H "Content-Type: application/json" \
+        -H "Authorization: Bearer $WORKER_TOKEN" \
+        --data-binary @- \
+        "$SERVER_URL/api/v1/logs/$SCAN_ID/$WORKER_ID" > /dev/null || true
+}
+
+# Hand a heartbeat or completion to the server:
+# deliver <heartbeat|complete> <json>. Fails when it is worth trying again.
+deliver() {
+{{- if .Stream}}
+    # Pushed onto the scan's stream, which the server reads once it can
+    local entry=$(redis-cli $STREAM_CLI XADD "scan:$SCAN_ID:stream" '*' \
+        kind "$1" worker "$WORKER_ID" token "$WORKER_TOKEN" body "$2" 2>/dev/null)
+    [[ "$entry" =~ ^[0-9]+-[0-9]+$ ]]
+{{- else}}
+    local code=$(curl $CURL_TLS $CURL_TRACE -s -o /dev/null -w '%{http_code}' -X POST \
+        -H "Content-Type: application/json" \
+        -H "Authorization: Bearer $WORKER_TOKEN" \
+        --data-binary "$2" \
+        "$SERVER_URL/api/v1/$1/$SCAN_ID/$WORKER_ID")
+    # Rejected requests (4xx) would only be rejected again
+    case "$code" in 2*|4*) return 0 ;; esac
+    return 1
+{{- end}}
+}
+
+# Hand the batch in results.batch to the server, one result per line. Fails
+# when it is worth trying again.
+deliver_results() {
+{{- if .Stream}}
+    local entry=$(redis-cli $STREAM_CLI -x XADD "scan:$SCAN_ID:stream" '*' \
+        kind results worker "$WORKER_ID" token "$WORKER_TOKEN" body \
+        < {{.WorkDir}}/results.batch 2>/dev/null)
+    [[ "$entry" =~ ^[0-9]+-[0-9]+$ ]]
+{{- else}}
+    post_results {{.WorkDir}}/results.batch
+{{- end}}
+}
+{{- if not .Stream}}
+
+# Post the result lines in a file. A batch the server finds too large (413)
+# is split in halves, each posted the same way; a single result still too
+# large is logged as lost. Halves sent again after a failure are dropped as
+# duplicates by the server.
+post_results() {
+    local batch=$1
+    local code=$(gzip -c "$batch" | \
+        curl $CURL_TLS $CURL_TRACE -s -o /dev/null -w '%{http_code}' -X POST \
+        -H "Content-Type: application/x-ndjson" \
+        -H "Content-Encoding: gzip" \
+        -H "Authorization: Bearer $WORKER_TOKEN" \
+        --data-binary @- \
+        "$SERVER_URL/api/v1/results/$SCAN_ID/$WORKER_ID/batch")
+    case "$code" in
+        413)
+            local lines=$(wc -l < "$batch")
+            if [ "$lines" -le 1 ]; then
+                send_log error "Dropped a result of $(wc -c < "$batch") bytes, too large to deliver"
+                return 0
+            fi
+            head -n $((lines / 2)) "$batch" > "$batch.1"
+            tail -n +$((lines / 2 + 1)) "$batch" > "$batch.2"
+            post_results "$batch.1" && post_results "$batch.2"
+            local delivered=$?
+            rm -f "$batch.1" "$batch.2"
+            return $delivered
+            ;;
+        # Other rejected requests (4xx) would only be rejected again
+        2*|4*) return 0 ;;
+    esac
+    return 1
+}
+{{- end}}
+
+# Ship new nuclei stderr lines to the orchestrator in batches
+ship_stderr() {
+    local sent=$(wc -l < {{.WorkDir}}/nuclei.err 2>/dev/null || echo 0)
+    while true; do
+        local total=$(wc -l < {{.WorkDir}}/nuclei.err 2>/dev/null || echo 0)
+        if [ "$total" -gt "$sent" ]; then
+            # Stats lines are JSON and go out with heartbeats instead
+            sed -n "$((sent + 1)),${total}p" {{.WorkDir}}/nuclei.err | grep -v '^{' | \
+            jq -R --arg ts "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+                '{timestamp: $ts, message: ., type: (
+                    if test("Skipped .* from target list") then "skipped"
+                    elif test("\\[(ERR|FTL)\\]") then "error"
+                    else "info" end)}' | \
+            jq -cs . | \
+            curl $CURL_TLS $CURL_TRACE -s -X POST \
+                -H "Content-Type: application/json" \
+                -H "Authorization: Bearer $WORKER_TOKEN" \
+                --data-binary @- \
+                "$SERVER_URL/api/v1/logs/$SCAN_ID/$WORKER_ID" > /dev/null || true
+            sent=$total
+        fi
+        sleep 5
+    done
+}
+
+# Sample load, memory, the work directory's disk and nuclei's memory
+telemetry() {
+    local mem_total=$(awk '/^MemTotal:/ {print int($2 / 1024)}' /proc/meminfo)
+    local mem_available=$(awk '/^MemAvailable:/ {print int($2 / 1024)}' /proc/meminfo)
+    local disk=($(df -Pm {{.WorkDir}} | awk 'NR == 2 {print $2, $4}'))
+    local rss=$(ps -C nuclei -o rss= 2>/dev/null | awk '{sum += $1} END {print int(sum / 1024)}')
+    local findings=$(cat {{.WorkDir}}/results.json 2>/dev/null | wc -l)
+    jq -cn \
+        --argjson load1 "$(cut -d' ' -f1 /proc/loadavg)" \
+        --argjson cpus "$(nproc)" \
+        --argjson mem_total "${mem_total:-0}" \
+        --argjson mem_available "${mem_available:-0}" \
+        --argjson disk_total "${disk[0]:-0}" \
+        --argjson disk_free "${disk[1]:-0}" \
+        --argjson rss "${rss:-0}" \
+        --argjson findings "${findings:-0}" \
+        '{load1: $load1, cpus: $cpus, mem_used_mb: ($mem_total - $mem_available), mem_total_mb: $mem_total,
+          disk_free_mb: $disk_free, disk_total_mb: $disk_total, nuclei_rss_mb: $rss, findings: $findings}'
+}
+
+# Report completed/total hosts from the latest nuclei stats line of the
+# current targets, counting the hosts of earlier ones as completed and those
+# queued after them as not, with a resource sample. Before nuclei reports,
+# the sample goes alone.
+send_heartbeats() {
+    while true; do
+        local offset=$(cat {{.WorkDir}}/stats.offset 2>/dev/null || echo 0)
+        local earlier=$(cat {{.WorkDir}}/hosts.done 2>/dev/null || echo 0)
+        local queued=$(cat {{.WorkDir}}/hosts.queued 2>/dev/null || echo 0)
+        local stats=$(tail -n +$((offset + 1)) {{.WorkDir}}/nuclei.err 2>/dev/null | grep '^{' | tail -n 1)
+        local sample=$(telemetry || echo '{}')
+        local heartbeat
+        if [ -n "$stats" ]; then
+            heartbeat=$(echo "$stats" | \
+            jq -c --argjson earlier $earlier --argjson queued $queued --argjson sample "$sample" '{
+                hosts_total: ((.hosts | tonumber) + $earlier + $queued),
+                hosts_completed: ((((.hosts | tonumber) * (.percent | tonumber) / 100) | floor) + $earlier),
+                message: "requests \(.requests)/\(.total), errors \(.errors)",
+                telemetry: ($sample + {errors: ((.errors // "0") | tonumber), rps: ((.rps // "0") | tonumber)})
+            }')
+        else
+            heartbeat=$(jq -cn --argjson sample "$sample" '{telemetry: $sample}')
+        fi
+        deliver heartbeat "$heartbeat" || true
+        sleep 30
+    done
+}
+
+# Deliver every result line once, in batches of up to 100 complete lines,
+# remembering the position across restarts
+ship_results() {
+    touch {{.WorkDir}}/results.json
+    while true; do
+        local sent=$(cat {{.WorkDir}}/results.sent 2>/dev/null || echo 0)
+        local total=$(wc -l < {{.WorkDir}}/results.json)
+        if [ "$total" -gt "$sent" ]; then
+            [ $((total - sent)) -gt 100 ] && total=$((sent + 100))
+            sed -n "$((sent + 1)),${total}p" {{.WorkDir}}/results.json > {{.WorkDir}}/results.batch
+            # Retry while the batch cannot be handed over
+            local attempt
+            for attempt in 1 2 3 4 5; do
+                deliver_results && break
+                if [ $attempt -eq 5 ]; then
+                    send_log error "Dropped results $((sent + 1))-$total after 5 failed deliveries"
+                    break
+                fi
+                sleep $((attempt * 5))
+            done
+            echo $total > {{.WorkDir}}/results.sent
+            continue
+        fi
+        sleep 5
+    done
+}
+
+# Run nuclei until it exits cleanly, resuming after crashes up to MAX_RESTARTS times
+supervise_nuclei() {
+    local restarts=$(cat $RESTART_FILE 2>/dev/null || echo 0)
+    local var_flags=()
+    if [ -s {{.WorkDir}}/secrets.env ]; then
+        while IFS= read -r var; do
+            var_flags+=(-var "$var")
+        done < {{.WorkDir}}/secrets.env
+    fi
+    while true; do
+        local resume_flags=""
+        if [ -f $RESUME_FILE ]; then
+            resume_flags="-resume $RESUME_FILE"
+        fi
+
+        /usr/local/bin/nuclei -l $TARGETS $NUCLEI_FLAGS $resume_flags "${var_flags[@]}" >> {{.WorkDir}}/results.json 2>> {{.WorkDir}}/nuclei.err
+        local code=$?
+        if [ $code -eq 0 ]; then
+            send_log success "Nuclei finished"
+            return 0
+        fi
+
+        # nuclei saves its position under ~/.config/nuclei when interrupted
+        local latest=$(ls -t {{.WorkDir}}/.config/nuclei/resume-*.cfg 2>/dev/null | head -n 1)
+        if [ -n "$latest" ]; then
+            cp "$latest" $RESUME_FILE
+        fi
+
+        restarts=$((restarts + 1))
+        echo $restarts > $RESTART_FILE
+        if [ $restarts -gt $MAX_RESTARTS ]; then
+            send_log error "Nuclei exited with code $code, giving up after $MAX_RESTARTS restarts"
+            return 1
+        fi
+
+        send_log restart "Nuclei exited with code $code, restart $restarts/$MAX_RESTARTS"
+        sleep 10
+    done
+}
+
+# Fetch the targets added to the scan or rebalanced to this worker since it
+# last asked onto the end of {{.WorkDir}}/remaining.txt. Returns 1 when there are
+# none, and 2 once the scan is over.
+pull_targets() {
+    for attempt in 1 2 3 4 5; do
+        local code=$(curl $CURL_TLS $CURL_TRACE -s -o {{.WorkDir}}/pulled.json -w '%{http_code}' \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            "$SERVER_URL/api/v1/targets/$SCAN_ID/$WORKER_ID")
+        case "$code" in
+        200)
+            if [ "$(jq '.targets | length' {{.WorkDir}}/pulled.json)" -gt 0 ]; then
+                jq -r '.targets[]' {{.WorkDir}}/pulled.json >> {{.WorkDir}}/remaining.txt
+                return 0
+            fi
+            if [ "$(jq -r '.scan_running' {{.WorkDir}}/pulled.json)" = "false" ]; then
+                return 2
+            fi
+            return 1
+            ;;
+        4*)
+            return 2
+            ;;
+        esac
+        sleep $((attempt * 5))
+    done
+    return 1
+}
+
+# Wait until the result shipper has posted every result
+wait_for_results() {
+    while [ "$(cat {{.WorkDir}}/results.sent 2>/dev/null || echo 0)" -lt "$(wc -l < {{.WorkDir}}/results.json)" ]; do
+        sleep 2
+    done
+}
+
+# Give the result shipper time to catch up, then report the worker finished
+report_completion() {
+    wait_for_results
+    for attempt in 1 2 3 4 5; do
+        if deliver complete "$completion"; then
+            break
+        fi
+        sleep $((attempt * 5))
+    done
+}
+{{if .Pull}}
+# Lease the next batch of the scan's targets into {{.WorkDir}}/batch.txt. Returns 1
+# once the pool is drained. While other workers hold the last batches, waits
+# in case one of them comes back.
+lease_batch() {
+    while true; do
+        local code=$(curl $CURL_TLS $CURL_TRACE -s -o {{.WorkDir}}/batch.json -w '%{http_code}' \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            "$SERVER_URL/api/v1/targets/$SCAN_ID/$WORKER_ID/next?count=$BATCH_SIZE")
+        case "$code" in
+        200)
+            if [ "$(jq -r '.drained' {{.WorkDir}}/batch.json)" = "true" ]; then
+                return 1
+            fi
+            if [ -n "$(jq -r '.batchId // empty' {{.WorkDir}}/batch.json)" ]; then
+                jq -r '.targets[]' {{.WorkDir}}/batch.json > {{.WorkDir}}/batch.txt
+                jq -r '.batchId' {{.WorkDir}}/batch.json > {{.WorkDir}}/batch.id
+                return 0
+            fi
+            ;;
+        4*)
+            # The scan is over, there is nothing left to lease
+            send_log error "Leasing targets failed with HTTP $code"
+            return 1
+            ;;
+        esac
+        sleep 30
+    done
+}
+
+# Confirm the leased batch as scanned. If its lease expired meanwhile, the
+# server refuses and another worker scans it again.
+confirm_batch() {
+    for attempt in 1 2 3 4 5; do
+        code=$(curl $CURL_TLS $CURL_TRACE -s -o /dev/null -w '%{http_code}' -X POST \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            "$SERVER_URL/api/v1/targets/$SCAN_ID/$WORKER_ID/batches/$(cat {{.WorkDir}}/batch.id)")
+        case "$code" in 2*|4*) break ;; esac
+        sleep $((attempt * 5))
+    done
+}
+{{else}}
+# Scan {{.WorkDir}}/remaining.txt a segment at a time, reporting each segment as
+# scanned so slower workers' targets can be rebalanced. A reboot mid-segment
+# scans the segment again, resuming nuclei. Returns 1 when nuclei gave up.
+scan_remaining() {
+    while [ -s {{.WorkDir}}/segment.txt ] || [ -s {{.WorkDir}}/remaining.txt ]; do
+        if [ ! -s {{.WorkDir}}/segment.txt ]; then
+            head -n $SEGMENT_SIZE {{.WorkDir}}/remaining.txt > {{.WorkDir}}/segment.tmp
+            mv {{.WorkDir}}/segment.tmp {{.WorkDir}}/segment.txt
+            tail -n +$(( $(wc -l < {{.WorkDir}}/segment.txt) + 1 )) {{.WorkDir}}/remaining.txt > {{.WorkDir}}/remaining.tmp
+            mv {{.WorkDir}}/remaining.tmp {{.WorkDir}}/remaining.txt
+            wc -l < {{.WorkDir}}/nuclei.err > {{.WorkDir}}/stats.offset
+            rm -f $RESUME_FILE {{.WorkDir}}/.config/nuclei/resume-*.cfg
+        fi
+        wc -l < {{.WorkDir}}/remaining.txt > {{.WorkDir}}/hosts.queued
+
+        TARGETS={{.WorkDir}}/segment.txt
+        send_log info "Starting nuclei against $(wc -l < $TARGETS) targets, $(wc -l < {{.WorkDir}}/remaining.txt) more queued"
+        if ! supervise_nuclei; then
+            return 1
+        fi
+        echo $(( $(cat {{.WorkDir}}/hosts.done 2>/dev/null || echo 0) + $(wc -l < $TARGETS) )) > {{.WorkDir}}/hosts.done
+        report_segment
+        rm -f {{.WorkDir}}/segment.txt
+    done
+    echo 0 > {{.WorkDir}}/hosts.queued
+}
+
+# Report the segment as scanned, and hand back as many targets from the end
+# of the queue as a rebalance asks for. They are only dropped once the server
+# took them.
+report_segment() {
+    local release=$(jq -R . {{.WorkDir}}/segment.txt | \
+        jq -cs --argjson queued $(wc -l < {{.WorkDir}}/remaining.txt) '{targets: ., queued: $queued}' | \
+        curl $CURL_TLS $CURL_TRACE -sf -X POST \
+            -H "Content-Type: application/json" \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            --data-binary @- \
+            "$SERVER_URL/api/v1/targets/$SCAN_ID/$WORKER_ID/done" | jq -r '.release // 0')
+    if [ "${release:-0}" -le 0 ]; then
+        return 0
+    fi
+
+    local keep=$(( $(wc -l < {{.WorkDir}}/remaining.txt) - release ))
+    if [ $keep -lt 0 ]; then
+        return 0
+    fi
+    tail -n +$((keep + 1)) {{.WorkDir}}/remaining.txt > {{.WorkDir}}/release.txt
+    if jq -R . {{.WorkDir}}/release.txt | jq -cs '{targets: .}' | \
+        curl $CURL_TLS $CURL_TRACE -sf -X POST \
+            -H "Content-Type: application/json" \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            --data-binary @- \
+            "$SERVER_URL/api/v1/targets/$SCAN_ID/$WORKER_ID/release" > /dev/null; then
+        head -n $keep {{.WorkDir}}/remaining.txt > {{.WorkDir}}/remaining.tmp
+        mv {{.WorkDir}}/remaining.tmp {{.WorkDir}}/remaining.txt
+        send_log info "Handed $release queued targets to idle workers"
+    fi
+}
+{{end}}{{if .Hold}}
+# Send no traffic to the targets until the scan is released, so its source
+# IPs can be allowlisted first
+if [ ! -f {{.WorkDir}}/scan.released ]; then
+    send_log info "Waiting for the scan to be released"
+    while true; do
+        code=$(curl $CURL_TLS $CURL_TRACE -s -o {{.WorkDir}}/gate.json -w '%{http_code}' \
+            -H "Authorization: Bearer $WORKER_TOKEN" \
+            "$SERVER_URL/api/v1/gate/$SCAN_ID/$WORKER_ID")
+        if [ "$code" = "200" ] && [ "$(jq -r '.released' {{.WorkDir}}/gate.json)" = "true" ]; then
+            break
+        fi
+        sleep 10
+    done
+    touch {{.WorkDir}}/scan.released
+    send_log info "Scan released"
+fi
+{{end}}
+# The start marker only exists already if the droplet rebooted mid-scan
+if [ -f {{.WorkDir}}/nuclei.started ]; then
+    restarts=$(( $(cat $RESTART_FILE 2>/dev/null || echo 0) + 1 ))
+    echo $restarts > $RESTART_FILE
+    send_log restart "Worker restarted after reboot, restart $restarts/$MAX_RESTARTS"
+fi
+touch {{.WorkDir}}/nuclei.started
+{{if not .Pull}}
+# Only feed hosts that answer httpx to nuclei; the probe is skipped after a reboot
+TARGETS={{.WorkDir}}/domains.txt
+if [ "$PROBE" = "true" ] && [ -x /usr/local/bin/httpx ]; then
+    if [ ! -f {{.WorkDir}}/alive.txt ]; then
+        send_log info "Probing $(wc -l < {{.WorkDir}}/domains.txt) hosts with httpx"
+        /usr/local/bin/httpx -l {{.WorkDir}}/domains.txt -silent -no-color -o {{.WorkDir}}/alive.tmp > /dev/null 2>&1
+        touch {{.WorkDir}}/alive.tmp
+        mv {{.WorkDir}}/alive.tmp {{.WorkDir}}/alive.txt
+    fi
+    TARGETS={{.WorkDir}}/alive.txt
+
+    alive=$(wc -l < {{.WorkDir}}/alive.txt)
+    dead=$(( $(wc -l < {{.WorkDir}}/domains.txt) - alive ))
+    send_log info "httpx found $alive live hosts, $dead dead"
+    deliver heartbeat "$(jq -cn --argjson alive $alive --argjson dead $dead \
+        '{hosts_alive: $alive, hosts_dead: $dead, hosts_total: $alive, hosts_completed: 0, message: "probe complete"}')" || true
+fi
+
+# The targets not scanned yet, kept across reboots
+if [ ! -f {{.WorkDir}}/remaining.txt ]; then
+    cp $TARGETS {{.WorkDir}}/remaining.tmp
+    mv {{.WorkDir}}/remaining.tmp {{.WorkDir}}/remaining.txt
+fi
+{{end}}{{if .InterruptionWatch}}
+# Report the worker failed as soon as the provider gives notice it is
+# reclaiming the machine, so its targets go to the other workers
+watch_interruption() {
+    {{.InterruptionWatch}}
+    send_log error "The provider is reclaiming this worker"
+    deliver complete '{"status": "failed", "message": "worker reclaimed by the provider"}' || true
+}
+{{end}}
+touch {{.WorkDir}}/nuclei.err
+ship_stderr &
+send_heartbeats &
+ship_results &
+{{- if .InterruptionWatch}}
+watch_interruption &
+{{- end}}
+
+completion='{"status": "completed"}'
+{{- if .Pull}}
+
+# Scan batches leased from the pool until it is drained. A reboot mid-batch
+# scans the batch again, resuming nuclei.
+while true; do
+    if [ ! -s {{.WorkDir}}/batch.id ]; then
+        if ! lease_batch; then
+            break
+        fi
+        wc -l < {{.WorkDir}}/nuclei.err > {{.WorkDir}}/stats.offset
+        rm -f $RESUME_FILE {{.WorkDir}}/.config/nuclei/resume-*.cfg
+    fi
+
+    TARGETS={{.WorkDir}}/batch.txt
+    if [ "$PROBE" = "true" ] && [ -x /usr/local/bin/httpx ]; then
+        /usr/local/bin/httpx -l {{.WorkDir}}/batch.txt -silent -no-color -o {{.WorkDir}}/batch.alive > /dev/null 2>&1
+        touch {{.WorkDir}}/batch.alive
+        TARGETS={{.WorkDir}}/batch.alive
+    fi
+
+    if [ -s $TARGETS ]; then
+        send_log info "Starting nuclei against $(wc -l < $TARGETS) targets of batch $(cat {{.WorkDir}}/batch.id)"
+        if ! supervise_nuclei; then
+            completion='{"status": "failed", "message": "nuclei kept crashing, giving up after '"$MAX_RESTARTS"' restarts"}'
+            break
+        fi
+    fi
+    echo $(( $(cat {{.WorkDir}}/hosts.done 2>/dev/null || echo 0) + $(wc -l < $TARGETS) )) > {{.WorkDir}}/hosts.done
+
+    # Findings go out before the batch counts as scanned
+    wait_for_results
+    confirm_batch
+    rm -f {{.WorkDir}}/batch.id {{.WorkDir}}/batch.alive
+done
+{{else}}
+while true; do
+    if ! scan_remaining; then
+        completion='{"status": "failed", "message": "nuclei kept crashing, giving up after '"$MAX_RESTARTS"' restarts"}'
+        break
+    fi
+
+    # Then scan whatever was added to the scan in the meantime
+    if ! pull_targets; then
+        break
+    fi
+    send_log info "Pulled $(wc -l < {{.WorkDir}}/remaining.txt) added targets"
+done
+{{end}}
+report_completion
+{{- if not .Pull}}
+
+# Stay available for targets rebalanced from slower workers until the scan is
+# over
+if [ "$(echo "$completion" | jq -r .status)" = "completed" ]; then
+    while sleep 30; do
+        pull_targets
+        case $? in
+        0)
+            send_log info "Pulled $(wc -l < {{.WorkDir}}/remaining.txt) more targets"
+            if ! scan_remaining; then
+                completion='{"status": "failed", "message": "nuclei kept crashing, giving up after '"$MAX_RESTARTS"' restarts"}'
+            fi
+            report_completion
+            if [ "$(echo "$completion" | jq -r .status)" != "completed" ]; then
+                break
+            fi
+            ;;
+        2)
+            break
+            ;;
+        esac
+    done
+fi
+{{- end}}
+systemctl disable nuclei-worker
+WORKER
+chmod +x {{.WorkDir}}/worker.sh
+{{if .Foreground}}
+# On a shared host, or as a pool worker's job, the worker runs in the process
+# that waits for it and leaves no service behind
+exec {{.WorkDir}}/worker.sh
+{{else}}
+cat > /etc/systemd/system/nuclei-worker.service <<'UNIT'
+[Unit]
+Description=Nuclei distributed worker
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+EnvironmentFile={{.WorkDir}}/worker.env
+ExecStart={{.WorkDir}}/worker.sh
+Restart=no
+
+[Install]
+WantedBy=multi-user.target
+UNIT
+
+if [ -d /run/systemd/system ]; then
+    systemctl daemon-reload
+    systemctl enable --now nuclei-worker
+else
+    # No systemd, as in a container: the worker runs in the foreground and
+    # its exit ends the container
+    exec {{.WorkDir}}/worker.sh
+fi
+{{end}}{{define "install"}}# Update system
+apt-get update
+apt-get install -y curl wget unzip jq{{if .Stream}} redis-tools{{end}}
+
+send_log info "System packages installed"
+
+# Install Go
+wget https://go.dev/dl/go1.21.0.linux-amd64.tar.gz
+tar -C /usr/local -xzf go1.21.0.linux-amd64.tar.gz
+export PATH=$PATH:/usr/local/go/bin
+send_log info "Go installed"
+
+# Install nuclei
+if wget https://github.com/projectdiscovery/nuclei/releases/download/v3.0.4/nuclei_3.0.4_linux_amd64.zip && \
+    unzip nuclei_3.0.4_linux_amd64.zip && mv nuclei /usr/local/bin/; then
+    send_log info "Nuclei installed"
+else
+    send_log error "Nuclei installation failed"
+fi
+{{if .Headless}}
+# Install chromium for headless templates
+if apt-get install -y chromium-browser libnss3 libatk1.0-0 libatk-bridge2.0-0 libcups2 \
+    libxkbcommon0 libxcomposite1 libxdamage1 libxrandr2 libgbm1 libasound2 libpangocairo-1.0-0 fonts-liberation; then
+    send_log info "Chromium installed"
+else
+    send_log error "Chromium installation failed, headless templates will not run"
+fi
+{{end}}{{if .Probe}}
+# Install httpx to weed out dead hosts before nuclei runs
+if wget https://github.com/projectdiscovery/httpx/releases/download/v1.3.7/httpx_1.3.7_linux_amd64.zip && \
+    unzip -o httpx_1.3.7_linux_amd64.zip httpx && mv httpx /usr/local/bin/; then
+    send_log info "httpx installed"
+else
+    send_log error "httpx installation failed, scanning every host"
+fi
+{{end}}{{end}}`))
+
+// curlTLSOptions are the curl options workers call the server with. With a
+// pinned key the certificate is not checked against CAs, only the key.
+func (o *Orchestrator) curlTLSOptions() string {
+	if o.pinnedPubKey == "" {
+		return ""
+	}
+	return "--insecure --pinnedpubkey " + o.pinnedPubKey
+}
+
+func (o *Orchestrator) generateUserData(ctx context.Context, req *types.ScanRequest, workerID, workerToken string, domains []string) string {
+	params := o.workerParams(req, workerID, workerToken, domains)
+	params.Traceparent = traceparent(ctx)
+	return renderUserData(params)
+}
+
+// workerParams fills in the bootstrap script of one of a scan's workers
+func (o *Orchestrator) workerParams(req *types.ScanRequest, workerID, workerToken string, domains []string) userDataParams {
+	domainsStr := strings.Join(domains, "\n")
+
+	params := userDataParams{
+		ScanID:      req.ID,
+		WorkerID:    workerID,
+		WorkerToken: workerToken,
+		ServerURL:   o.callbackURL,
+		CurlTLS:     o.curlTLSOptions(),
+		DomainsB64:  base64.StdEncoding.EncodeToString([]byte(domainsStr)),
+		Headless:    req.Headless,
+		Probe:       req.Probe,
+		ExtraFlags:  strings.Join(req.ExtraFlags, " "),
+		Severity:    strings.ReplaceAll(req.Severity, " ", ""),
+		RateLimit:   req.RateLimit,
+		Timeout:     req.Timeout,
+		Retries:     req.Retries,
+		MaxHostErr:  req.MaxHostErrors,
+		HasConfig:   req.ConfigYAML != "",
+		MaxRestarts: maxNucleiRestarts,
+		Pull:        req.Distribution == DistributionPull,
+		BatchSize:   req.BatchSize,
+		SegmentSize: workerSegmentSize,
+		WorkDir:     "/root",
+		Hold:        req.HoldUntilRelease,
+	}
+	o.streamParams(&params)
+	if interruptible, ok := o.provider.(provider.Interruptible); ok {
+		params.InterruptionWatch = interruptible.InterruptionWatch()
+	}
+	if host, ok := o.provider.(provider.ProcessHost); ok {
+		params.WorkDir = host.WorkDir(workerID)
+		params.Foreground = true
+	}
+	return params
+}
+
+// renderUserData renders a worker's bootstrap script
+func renderUserData(params userDataParams) string {
+	var script bytes.Buffer
+	if err := userDataTemplate.Execute(&script, params); err != nil {
+		// The script holds the worker's token, so only the error is logged
+		slog.Error("Failed to render user data", "scan_id", params.ScanID, "worker_id", params.WorkerID, "error", err)
+	}
+
+	return script.String()
+}
+
+// poolAgentParams holds the values substituted into the bootstrap script of a
+// warm pool worker
+type poolAgentParams struct {
+	WorkerID   string
+	Token      string // authenticates the agent's polls for assignments
+	ServerURL  string
+	CurlTLS    string
+	WorkDir    string
+	Foreground bool
+	// Headless and Probe install chromium and httpx, which pool workers
+	// always have since any scan may claim them
+	Headless bool
+	Probe    bool
+	// Stream installs redis-cli, which workers push onto the scan streams
+	// with
+	Stream bool
+}
+
+// poolAgentTemplate is the bootstrap script of a warm pool worker. It
+// installs the same tools as a worker's, then runs an agent that asks the
+// server for a scan to work on. The job it gets is a worker's bootstrap
+// script without the installs; once it exits the agent resets the working
+// directory, refreshes the templates and asks again.
+var poolAgentTemplate = template.Must(userDataTemplate.New("poolagent").Parse(`#!/bin/bash
+export DEBIAN_FRONTEND=noninteractive
+
+# Set up environment
+umask 077
+mkdir -p {{.WorkDir}}
+cat > {{.WorkDir}}/pool-agent.env <<'ENV'
+HOME={{.WorkDir}}
+POOL_WORKER_ID={{.WorkerID}}
+POOL_TOKEN={{.Token}}
+SERVER_URL={{.ServerURL}}
+CURL_TLS="{{.CurlTLS}}"
+ENV
+set -a
+. {{.WorkDir}}/pool-agent.env
+set +a
+
+# Until a scan claims the worker there is no scan to log to
+send_log() {
+    echo "[$1] $2"
+}
+
+{{template "install" .}}
+cat > {{.WorkDir}}/pool-agent.sh <<'AGENT'
+#!/bin/bash
+
+# Download the latest templates, keeping the current ones when that fails
+refresh_templates() {
+    rm -rf {{.WorkDir}}/pool-agent.templates
+    mkdir -p {{.WorkDir}}/pool-agent.templates
+    if curl -sfL https://raw.githubusercontent.com/projectdiscovery/nuclei/main/nuclei-templates.tar.gz | \
+        tar -xzf - -C {{.WorkDir}}/pool-agent.templates && [ -d {{.WorkDir}}/pool-agent.templates/nuclei-templates ]; then
+        rm -rf {{.WorkDir}}/nuclei-templates
+        mv {{.WorkDir}}/pool-agent.templates/nuclei-templates {{.WorkDir}}/nuclei-templates
+        echo "Templates refreshed"
+    else
+        echo "Failed to refresh templates, keeping the current ones"
+    fi
+    rm -rf {{.WorkDir}}/pool-agent.templates
+}
+
+# Remove everything the last job left behind but the agent and the templates
+reset_worker() {
+    find {{.WorkDir}} -mindepth 1 -maxdepth 1 ! -name 'pool-agent*' ! -name nuclei-templates -exec rm -rf {} +
+}
+
+if [ ! -d {{.WorkDir}}/nuclei-templates ]; then
+    refresh_templates
+fi
+while true; do
+    # The marker outlives the job only until the next poll, or after a
+    # reboot cut the job short. The server hands that job out again while
+    # the scan still wants it, and it starts over.
+    if [ -f {{.WorkDir}}/pool-job.running ]; then
+        reset_worker
+        refresh_templates
+    fi
+
+    code=$(curl $CURL_TLS -s -o {{.WorkDir}}/pool-job.json -w '%{http_code}' \
+        -H "Authorization: Bearer $POOL_TOKEN" \
+        "$SERVER_URL/api/v1/pool/$POOL_WORKER_ID/assignment")
+    if [ "$code" = 200 ] && [ "$(jq -r .assigned {{.WorkDir}}/pool-job.json)" = "true" ]; then
+        jq -r .script {{.WorkDir}}/pool-job.json > {{.WorkDir}}/pool-job.sh
+        touch {{.WorkDir}}/pool-job.running
+        echo "Working on scan $(jq -r .scan_id {{.WorkDir}}/pool-job.json) as $(jq -r .worker_id {{.WorkDir}}/pool-job.json)"
+        bash {{.WorkDir}}/pool-job.sh
+        echo "Job exited with code $?"
+        continue
+    fi
+    rm -f {{.WorkDir}}/pool-job.json
+    sleep 10
+done
+AGENT
+chmod +x {{.WorkDir}}/pool-agent.sh
+{{if .Foreground}}
+exec {{.WorkDir}}/pool-agent.sh
+{{else}}
+cat > /etc/systemd/system/nuclei-pool-agent.service <<'UNIT'
+[Unit]
+Description=Nuclei warm pool agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+EnvironmentFile={{.WorkDir}}/pool-agent.env
+ExecStart={{.WorkDir}}/pool-agent.sh
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+UNIT
+
+if [ -d /run/systemd/system ]; then
+    systemctl daemon-reload
+    systemctl enable --now nuclei-pool-agent
+else
+    exec {{.WorkDir}}/pool-agent.sh
+fi
+{{end}}`))
+
+// generatePoolAgent renders the bootstrap script of a warm pool worker
+func (o *Orchestrator) generatePoolAgent(name, token string) string {
+	params := poolAgentParams{
+		WorkerID:  name,
+		Token:     token,
+		ServerURL: o.callbackURL,
+		CurlTLS:   o.curlTLSOptions(),
+		WorkDir:   "/root",
+		Headless:  true,
+		Probe:     true,
+		Stream:    o.workerStream != nil,
+	}
+	if host, ok := o.provider.(provider.ProcessHost); ok {
+		params.WorkDir = host.WorkDir(name)
+		params.Foreground = true
+	}
+
+	var script bytes.Buffer
+	if err := poolAgentTemplate.Execute(&script, params); err != nil {
+		slog.Error("Failed to render pool agent", "worker_id", name, "error", err)
+	}
+	return script.String()
+}
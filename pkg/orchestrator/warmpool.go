@@ -0,0 +1,477 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+// poolTag marks the droplets of warm pool workers. They carry it next to
+// the worker tag instead of a scan's ID, so cleaning up a scan leaves them
+// running.
+const poolTag = "nuclei-pool"
+
+// poolFirewall names the firewall in front of the warm pool
+const poolFirewall = "nuclei-warm-pool"
+
+const (
+	// DefaultPoolIdleTTL is how long workers returned to the pool beyond its
+	// size stay idle unless configured otherwise
+	DefaultPoolIdleTTL = 30 * time.Minute
+	// DefaultPoolMaxAge is how long pool workers live unless configured
+	// otherwise
+	DefaultPoolMaxAge = 24 * time.Hour
+	// warmPoolInterval is how often the pool is reconciled with the
+	// provider
+	warmPoolInterval = time.Minute
+	// poolPollTimeout is how recently an idle worker must have asked for
+	// work to be claimed; agents ask every ten seconds
+	poolPollTimeout = 2 * time.Minute
+	// poolSilentTimeout is how long a new worker may take to install its
+	// tools and first ask for work, and how long an idle one may go without
+	// asking, before it is destroyed
+	poolSilentTimeout = 20 * time.Minute
+)
+
+// WarmPoolConfig keeps workers running between scans, so scans claim them
+// instead of waiting for droplets to boot and install nuclei
+type WarmPoolConfig struct {
+	// Size is how many idle workers are kept ready; zero disables the pool
+	Size int
+	// IdleTTL is how long workers returned beyond Size stay idle before
+	// they are destroyed
+	IdleTTL time.Duration
+	// MaxAge is how long a pool worker lives, idle or not. A worker still
+	// scanning at that age is destroyed all the same and fails its chunk.
+	MaxAge time.Duration
+	// Droplet is the region, size and image pool workers are created with.
+	// Only workers of scans asking for the same are claimed from the pool.
+	Droplet types.DropletConfig
+}
+
+// warmPool tracks the pool's workers by name. Their tokens only live here,
+// so workers left by an earlier server process are destroyed.
+type warmPool struct {
+	config  WarmPoolConfig
+	mutex   sync.Mutex // held before Orchestrator.mutex
+	members map[string]*poolMember
+}
+
+// poolMember is a pool worker. It is booting until its agent first asks for
+// work, then idle or working on the assignment.
+type poolMember struct {
+	name       string
+	dropletID  string // empty while the droplet is created
+	token      string
+	createdAt  time.Time
+	lastPoll   time.Time
+	idleSince  time.Time
+	assignment *PoolAssignment // nil while idle
+}
+
+// PoolAssignment is the job a pool worker runs for a scan: the worker's
+// bootstrap script without the installs
+type PoolAssignment struct {
+	ScanID   string
+	WorkerID string
+	Script   string // empty while it is rendered
+}
+
+// newWarmPool fills in the defaults of a pool's settings
+func newWarmPool(cfg WarmPoolConfig) warmPool {
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = DefaultPoolIdleTTL
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultPoolMaxAge
+	}
+	defaults := DefaultDropletConfig()
+	if cfg.Droplet.Region == "" {
+		cfg.Droplet.Region = defaults.Region
+	}
+	if cfg.Droplet.Size == "" {
+		cfg.Droplet.Size = defaults.Size
+	}
+	if cfg.Droplet.Image == "" {
+		cfg.Droplet.Image = defaults.Image
+	}
+	return warmPool{config: cfg, members: make(map[string]*poolMember)}
+}
+
+// idle reports whether the member waits for work. Callers must hold the
+// pool's mutex.
+func (m *poolMember) idle() bool {
+	return m.assignment == nil && !m.lastPoll.IsZero()
+}
+
+// ValidatePoolToken reports whether token is the one issued to a pool
+// worker. Failures count towards the worker auth alert.
+func (o *Orchestrator) ValidatePoolToken(name, token string) bool {
+	o.warmPool.mutex.Lock()
+	member := o.warmPool.members[name]
+	o.warmPool.mutex.Unlock()
+
+	if member == nil || token == "" || subtle.ConstantTimeCompare([]byte(member.token), []byte(token)) != 1 {
+		o.alerts.workerAuthFailed("", name)
+		return false
+	}
+	return true
+}
+
+// PoolAssignment answers a pool worker asking for work with the job of the
+// scan that claimed it, or nil. Workers only ask again once their job
+// exited, or after a reboot cut it short, so a job the scan no longer wants
+// returns the worker to the pool.
+func (o *Orchestrator) PoolAssignment(name string) *PoolAssignment {
+	pool := &o.warmPool
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	member := pool.members[name]
+	if member == nil {
+		return nil
+	}
+	now := time.Now()
+	if member.lastPoll.IsZero() {
+		slog.Info("Pool worker ready", "worker_id", name, "droplet_id", member.dropletID,
+			"boot_seconds", int(now.Sub(member.createdAt).Seconds()))
+		member.idleSince = now
+	}
+	member.lastPoll = now
+
+	assignment := member.assignment
+	if assignment == nil || assignment.Script == "" {
+		return nil
+	}
+	o.mutex.RLock()
+	scan, exists := o.activeScans[assignment.ScanID]
+	worker := o.findWorker(assignment.ScanID, assignment.WorkerID)
+	wanted := exists && !scanEnded(scan.Status) &&
+		(worker == nil || (worker.Status != "completed" && worker.Status != "failed"))
+	o.mutex.RUnlock()
+	if wanted {
+		job := *assignment
+		return &job
+	}
+
+	slog.Info("Pool worker returned", "worker_id", name, "scan_id", assignment.ScanID,
+		"scan_worker_id", assignment.WorkerID)
+	member.assignment = nil
+	member.idleSince = now
+	return nil
+}
+
+// claimPoolWorker hands one of a scan's workers to an idle pool worker of
+// the droplet config it asks for, and reports whether there was one. The
+// worker's targets are queued for it like targets added to the scan, so it
+// pulls them through the targets endpoint instead of finding them in its
+// script.
+func (o *Orchestrator) claimPoolWorker(ctx context.Context, req *types.ScanRequest, config types.DropletConfig, index int, domains []string) bool {
+	// Of several replicas only the leader keeps a pool
+	pool := &o.warmPool
+	if pool.config.Size == 0 || config != pool.config.Droplet || !o.leading() {
+		return false
+	}
+	scanID := req.ID
+	workerID := workerName(scanID, index)
+
+	// The youngest idle worker has the longest to live
+	now := time.Now()
+	pool.mutex.Lock()
+	var member *poolMember
+	for _, candidate := range pool.members {
+		if !candidate.idle() || now.Sub(candidate.lastPoll) > poolPollTimeout ||
+			now.Sub(candidate.createdAt) > pool.config.MaxAge {
+			continue
+		}
+		if member == nil || candidate.createdAt.After(member.createdAt) {
+			member = candidate
+		}
+	}
+	if member == nil {
+		pool.mutex.Unlock()
+		return false
+	}
+	assignment := &PoolAssignment{ScanID: scanID, WorkerID: workerID}
+	member.assignment = assignment
+	name, dropletID := member.name, member.dropletID
+	pool.mutex.Unlock()
+
+	token, err := o.issueWorkerToken(scanID, workerID)
+	if err != nil {
+		slog.Error("Failed to issue worker token", "scan_id", scanID, "worker_id", workerID, "error", err)
+		pool.mutex.Lock()
+		member.assignment = nil
+		pool.mutex.Unlock()
+		return false
+	}
+
+	o.mutex.Lock()
+	if state := o.scanStates[scanID]; state != nil && len(domains) > 0 {
+		state.pendingTargets[workerID] = append(state.pendingTargets[workerID], domains...)
+		state.addedTargets[workerID] += len(domains)
+	}
+	o.mutex.Unlock()
+
+	params := o.workerParams(req, workerID, token, nil)
+	params.Traceparent = traceparent(ctx)
+	params.Pooled = true
+	params.Foreground = true
+	params.WorkDir = "/root"
+	if host, ok := o.provider.(provider.ProcessHost); ok {
+		params.WorkDir = host.WorkDir(name)
+	}
+	script := renderUserData(params)
+	pool.mutex.Lock()
+	assignment.Script = script
+	pool.mutex.Unlock()
+
+	slog.Info("Claimed pool worker", "scan_id", scanID, "worker_id", workerID, "pool_worker_id", name,
+		"droplet_id", dropletID, "domains", len(domains))
+	trace.SpanFromContext(ctx).AddEvent("claimed pool worker", workerAttributes(scanID, workerID),
+		trace.WithAttributes(attribute.String("droplet.id", dropletID)))
+	o.billDroplet(scanID, types.DropletCost{WorkerID: workerID, DropletID: dropletID, Size: config.Size, Pooled: true})
+	o.workerEvent(scanID, types.WorkerProvisioning, &types.WorkerStatus{
+		ID:           workerID,
+		TotalDomains: len(domains),
+		DomainsAlive: len(domains),
+		CreatedAt:    time.Now(),
+		Status:       "provisioning",
+	})
+	go o.waitForWorker(ctx, scanID, workerID, dropletID, poolTag, len(domains))
+	return true
+}
+
+// runWarmPool keeps the pool at its size and destroys the workers it no
+// longer wants. With the pool disabled it only destroys workers left by an
+// earlier server process, once. Of several replicas only the leader keeps
+// the pool, so a new leader destroys the workers of the one before.
+func (o *Orchestrator) runWarmPool() {
+	ctx := context.Background()
+	protected := false
+	for {
+		if !o.leading() {
+			time.Sleep(replicaRenew)
+			continue
+		}
+		if !protected && o.warmPool.config.Size > 0 {
+			o.protectPool(ctx)
+			protected = true
+		}
+		err := o.maintainPool(ctx)
+		if err != nil {
+			slog.Warn("Failed to maintain the warm pool", "error", err)
+		} else if o.warmPool.config.Size == 0 {
+			return
+		}
+		time.Sleep(warmPoolInterval)
+	}
+}
+
+// protectPool puts the pool's workers behind a firewall applied by the pool
+// tag, like a scan's
+func (o *Orchestrator) protectPool(ctx context.Context) {
+	firewaller, ok := o.provider.(provider.Firewaller)
+	if !o.firewall.Enabled || !ok {
+		return
+	}
+	if err := firewaller.EnsureFirewall(ctx, poolFirewall, poolTag, o.firewall.AdminCIDRs); err != nil {
+		slog.Warn("Failed to firewall the warm pool, its workers run without one", "error", err)
+		return
+	}
+	slog.Info("Warm pool firewalled", "firewall", poolFirewall)
+}
+
+// poolRetirement is a pool worker to destroy and why
+type poolRetirement struct {
+	member *poolMember
+	reason string
+}
+
+// maintainPool reconciles the pool with the provider. It is the reaper of
+// pool workers: they are expected to outlive scans, so only those the server
+// does not know, that went silent, that sat idle beyond the pool's size for
+// longer than the idle TTL, or that reached their maximum age are destroyed.
+// Then as many workers are created as the pool lacks.
+func (o *Orchestrator) maintainPool(ctx context.Context) error {
+	droplets, err := o.provider.ListWorkersByTag(ctx, poolTag)
+	if err != nil {
+		return err
+	}
+	pool := &o.warmPool
+	now := time.Now()
+
+	pool.mutex.Lock()
+	listed := make(map[string]bool, len(droplets))
+	var orphans []provider.Worker
+	for _, droplet := range droplets {
+		listed[droplet.Name] = true
+		if pool.members[droplet.Name] == nil {
+			orphans = append(orphans, droplet)
+		}
+	}
+	var unlisted []*poolMember
+	var retired []poolRetirement
+	var idle []*poolMember
+	booting := 0
+	for _, member := range pool.members {
+		switch {
+		case member.dropletID == "":
+			booting++ // still being created
+		case !listed[member.name]:
+			unlisted = append(unlisted, member)
+		case now.Sub(member.createdAt) > pool.config.MaxAge:
+			retired = append(retired, poolRetirement{member, "reached its maximum age"})
+		case member.lastPoll.IsZero() && now.Sub(member.createdAt) > poolSilentTimeout:
+			retired = append(retired, poolRetirement{member, "never asked for work"})
+		case member.idle() && now.Sub(member.lastPoll) > poolSilentTimeout:
+			retired = append(retired, poolRetirement{member, "stopped asking for work"})
+		case member.idle():
+			idle = append(idle, member)
+		case member.lastPoll.IsZero():
+			booting++
+		}
+	}
+	// Workers returned beyond the pool's size go once idle for long enough,
+	// those idle the longest first
+	sort.Slice(idle, func(i, j int) bool { return idle[i].idleSince.Before(idle[j].idleSince) })
+	for len(idle) > pool.config.Size && now.Sub(idle[0].idleSince) > pool.config.IdleTTL {
+		retired = append(retired, poolRetirement{idle[0], "idle beyond the pool's size"})
+		idle = idle[1:]
+	}
+	for _, retirement := range retired {
+		delete(pool.members, retirement.member.name)
+	}
+	missing := pool.config.Size - len(idle) - booting
+	pool.mutex.Unlock()
+
+	for _, droplet := range orphans {
+		if err := o.provider.DestroyWorker(ctx, droplet.ID); err != nil && !errors.Is(err, provider.ErrNotFound) {
+			slog.Warn("Failed to destroy unknown pool worker", "worker_id", droplet.Name, "droplet_id", droplet.ID, "error", err)
+			continue
+		}
+		slog.Info("Destroyed unknown pool worker", "worker_id", droplet.Name, "droplet_id", droplet.ID)
+		o.alerts.orphanDestroyed(droplet.Name, droplet.ID)
+	}
+	// Listings may lag behind new droplets, so only those the provider no
+	// longer has are forgotten
+	for _, member := range unlisted {
+		if _, err := o.provider.GetWorker(ctx, member.dropletID); errors.Is(err, provider.ErrNotFound) {
+			retired = append(retired, poolRetirement{member, "is gone from the provider"})
+			pool.mutex.Lock()
+			delete(pool.members, member.name)
+			pool.mutex.Unlock()
+		}
+	}
+	for _, retirement := range retired {
+		o.retirePoolWorker(ctx, retirement)
+	}
+
+	// Workers are not created while the server is read-only
+	if o.readOnly() {
+		missing = 0
+	}
+	for i := 0; i < missing; i++ {
+		if err := o.addPoolWorker(ctx); err != nil {
+			slog.Warn("Failed to create pool worker", "error", err)
+			break
+		}
+	}
+	return nil
+}
+
+// retirePoolWorker destroys a pool worker the pool dropped. A worker still
+// scanning fails its chunk, which goes to the scan's other workers.
+func (o *Orchestrator) retirePoolWorker(ctx context.Context, retirement poolRetirement) {
+	member := retirement.member
+	err := o.provider.DestroyWorker(ctx, member.dropletID)
+	if err != nil && !errors.Is(err, provider.ErrNotFound) {
+		// Left to the next pass, which finds it unknown
+		slog.Warn("Failed to destroy pool worker", "worker_id", member.name, "droplet_id", member.dropletID,
+			"reason", retirement.reason, "error", err)
+	} else {
+		slog.Info("Destroyed pool worker", "worker_id", member.name, "droplet_id", member.dropletID,
+			"reason", retirement.reason)
+	}
+
+	o.warmPool.mutex.Lock()
+	assignment := member.assignment
+	o.warmPool.mutex.Unlock()
+	if assignment == nil {
+		return
+	}
+	scanID, workerID := assignment.ScanID, assignment.WorkerID
+	o.mutex.RLock()
+	worker := o.findWorker(scanID, workerID)
+	running := worker != nil && worker.Status != "completed" && worker.Status != "failed"
+	o.mutex.RUnlock()
+	if running {
+		o.CompleteWorker(scanID, workerID, "pool worker "+retirement.reason)
+	}
+}
+
+// addPoolWorker creates a pool worker. It counts as booting from the start,
+// so the next pass does not create another for the same gap.
+func (o *Orchestrator) addPoolWorker(ctx context.Context) error {
+	token, err := newWorkerToken()
+	if err != nil {
+		return err
+	}
+	pool := &o.warmPool
+	member := &poolMember{
+		name:      "pool-worker-" + uuid.New().String()[:8],
+		token:     token,
+		createdAt: time.Now(),
+	}
+	pool.mutex.Lock()
+	pool.members[member.name] = member
+	pool.mutex.Unlock()
+
+	config := pool.config.Droplet
+	droplet, err := o.provider.CreateWorker(ctx, provider.CreateRequest{
+		Name:     member.name,
+		Region:   config.Region,
+		Size:     config.Size,
+		Image:    config.Image,
+		UserData: o.generatePoolAgent(member.name, token),
+		Tags:     []string{workerTag, poolTag},
+		Env:      map[string]string{"POOL_WORKER_ID": member.name, "SERVER_URL": o.callbackURL},
+	})
+	o.alerts.dropletCreated(config.Region, config.Size, err)
+	if err != nil {
+		pool.mutex.Lock()
+		delete(pool.members, member.name)
+		pool.mutex.Unlock()
+		return err
+	}
+
+	pool.mutex.Lock()
+	member.dropletID = droplet.ID
+	pool.mutex.Unlock()
+	slog.Info("Created pool worker", "worker_id", member.name, "droplet_id", droplet.ID, "region", config.Region,
+		"size", config.Size)
+	return nil
+}
+
+// poolWorkerScans returns the scan each busy pool worker works for, by name
+func (o *Orchestrator) poolWorkerScans() map[string]string {
+	o.warmPool.mutex.Lock()
+	defer o.warmPool.mutex.Unlock()
+	scans := make(map[string]string)
+	for name, member := range o.warmPool.members {
+		if member.assignment != nil {
+			scans[name] = member.assignment.ScanID
+		}
+	}
+	return scans
+}
@@ -0,0 +1,196 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/types"
+)
+
+const (
+	// workerStallTimeout is how long a worker may send neither heartbeats
+	// nor logs before it counts as stalled. Installing nuclei logs every
+	// step, so it does not count.
+	workerStallTimeout = 10 * time.Minute
+	// workerStallCheck is how often workers are checked for stalls
+	workerStallCheck = time.Minute
+	// workerWatchInterval is how often the provider is asked whether a
+	// running worker is still there
+	workerWatchInterval = time.Minute
+)
+
+// scanEvent is a worker lifecycle or rebalance event waiting to be handed
+// out
+type scanEvent struct {
+	scanID string
+	event  string
+	data   interface{}
+}
+
+// scanEvents hands worker lifecycle and rebalance events to the handler in
+// order. They are queued under o.mutex and delivered by one goroutine
+// outside it, so the handler may call back into the orchestrator.
+type scanEvents struct {
+	mutex  sync.Mutex
+	queue  []scanEvent
+	ready  chan struct{}
+	handle func(scanID, event string, data interface{}) // nil until set
+}
+
+// OnScanEvent sets the function worker lifecycle and rebalance events go
+// to: one of the types.Worker* constants with a copy of the worker's
+// *types.WorkerStatus, or types.TargetsRebalanced with a *types.Rebalance.
+// Events before it is set are dropped.
+func (o *Orchestrator) OnScanEvent(handle func(scanID, event string, data interface{})) {
+	o.scanEvents.mutex.Lock()
+	defer o.scanEvents.mutex.Unlock()
+
+	if o.scanEvents.ready == nil {
+		o.scanEvents.ready = make(chan struct{}, 1)
+		go o.deliverScanEvents()
+		go o.runStallChecks()
+		go o.runRebalanceChecks()
+	}
+	o.scanEvents.handle = handle
+}
+
+// workerEvent queues an event about a worker; the caller may hold o.mutex
+func (o *Orchestrator) workerEvent(scanID, event string, worker *types.WorkerStatus) {
+	copied := *worker
+	copied.Logs = nil // logs go out as worker_log messages
+	o.queueScanEvent(scanID, event, &copied)
+}
+
+// queueScanEvent queues an event; data must not be changed afterwards. The
+// caller may hold o.mutex.
+func (o *Orchestrator) queueScanEvent(scanID, event string, data interface{}) {
+	events := &o.scanEvents
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	if events.handle == nil {
+		return
+	}
+	events.queue = append(events.queue, scanEvent{scanID: scanID, event: event, data: data})
+	select {
+	case events.ready <- struct{}{}:
+	default:
+	}
+}
+
+// deliverScanEvents hands queued events to the handler
+func (o *Orchestrator) deliverScanEvents() {
+	events := &o.scanEvents
+	for range events.ready {
+		events.mutex.Lock()
+		queue := events.queue
+		events.queue = nil
+		handle := events.handle
+		events.mutex.Unlock()
+
+		for _, event := range queue {
+			handle(event.scanID, event.event, event.data)
+		}
+	}
+}
+
+// workerSeen records a sign of life from a worker. A stalled worker goes
+// back to the status it had; workerSeen reports whether it was stalled, for
+// callers to send its recovery. Callers must hold o.mutex.
+func (o *Orchestrator) workerSeen(scanID string, worker *types.WorkerStatus) (recovered bool) {
+	state := o.scanStates[scanID]
+	if state == nil {
+		return false
+	}
+	state.workerSeen[worker.ID] = time.Now()
+	o.replicateScan(scanID)
+	if previous, stalled := state.stalledWorkers[worker.ID]; stalled {
+		delete(state.stalledWorkers, worker.ID)
+		if worker.Status == "stalled" {
+			worker.Status = previous
+		}
+		slog.Info("Stalled worker recovered", "scan_id", scanID, "worker_id", worker.ID)
+		return true
+	}
+	return false
+}
+
+// runStallChecks marks workers that went quiet as stalled. Of several
+// replicas the leader runs the check.
+func (o *Orchestrator) runStallChecks() {
+	ticker := time.NewTicker(workerStallCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		o.leaderCheck(checkStalls, time.Now())
+	}
+}
+
+// checkStalls marks running workers not heard from for workerStallTimeout
+// as stalled. They keep counting towards the scan, which finishes only once
+// they report back.
+func (o *Orchestrator) checkStalls(now time.Time) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for scanID, scan := range o.activeScans {
+		state := o.scanStates[scanID]
+		if state == nil || scanEnded(scan.Status) {
+			continue
+		}
+		changed := false
+		for _, worker := range scan.ActiveDroplets {
+			switch worker.Status {
+			case "completed", "failed", "stalled":
+				continue
+			}
+			seen, known := state.workerSeen[worker.ID]
+			if !known || now.Sub(seen) < workerStallTimeout {
+				continue
+			}
+			state.stalledWorkers[worker.ID] = worker.Status
+			worker.Status = "stalled"
+			changed = true
+			slog.Warn("Worker stalled", "scan_id", scanID, "worker_id", worker.ID, "last_seen", seen)
+			o.workerEvent(scanID, types.WorkerStalled, worker)
+		}
+		if changed {
+			o.scanChanged(scan)
+		}
+	}
+}
+
+// watchWorker asks the provider after a worker's status, through the shared
+// listing of tag, until the worker reports back or the scan ends. A worker the provider reports failed or no
+// longer has, such as a Job whose container crashed, fails its chunk instead
+// of stalling until someone notices.
+func (o *Orchestrator) watchWorker(ctx context.Context, scanID, workerID, dropletID, tag string) {
+	for {
+		time.Sleep(workerWatchInterval)
+
+		o.mutex.RLock()
+		scan, exists := o.activeScans[scanID]
+		worker := o.findWorker(scanID, workerID)
+		running := exists && !scanEnded(scan.Status) && worker != nil &&
+			worker.Status != "completed" && worker.Status != "failed"
+		o.mutex.RUnlock()
+		if !running {
+			return
+		}
+
+		droplet, err := o.lookupWorker(ctx, tag, dropletID)
+		if err != nil && !errors.Is(err, provider.ErrNotFound) {
+			slog.Warn("Failed to get droplet status", "scan_id", scanID, "worker_id", workerID, "droplet_id", dropletID, "error", err)
+			continue
+		}
+		if err == nil && droplet.Status != provider.StatusFailed {
+			continue
+		}
+		slog.Warn("Worker failed on the provider", "scan_id", scanID, "worker_id", workerID, "droplet_id", dropletID)
+		o.CompleteWorker(scanID, workerID, "worker failed on the provider")
+		return
+	}
+}
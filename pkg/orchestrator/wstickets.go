@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// WebSocketTicketTTL is how long a WebSocket ticket can be redeemed
+const WebSocketTicketTTL = 30 * time.Second
+
+// webSocketTicketKey hashes a ticket, so Redis never holds one that works
+func webSocketTicketKey(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return "ws_ticket:" + hex.EncodeToString(sum[:])
+}
+
+// IssueWebSocketTicket creates a ticket that opens one WebSocket or event
+// stream in place of an API key, so the key stays out of URLs and proxy logs
+func (o *Orchestrator) IssueWebSocketTicket(ctx context.Context) (string, error) {
+	ticket, err := newWorkerToken()
+	if err != nil {
+		return "", err
+	}
+	if err := o.redis.Set(ctx, webSocketTicketKey(ticket), 1, WebSocketTicketTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store WebSocket ticket: %v", err)
+	}
+	return ticket, nil
+}
+
+// RedeemWebSocketTicket reports whether a ticket was issued and neither
+// redeemed nor expired since, and uses it up. Deleting is atomic, so two
+// connections racing with the same ticket cannot both succeed.
+func (o *Orchestrator) RedeemWebSocketTicket(ctx context.Context, ticket string) (bool, error) {
+	if ticket == "" {
+		return false, nil
+	}
+	deleted, err := o.redis.Del(ctx, webSocketTicketKey(ticket)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem WebSocket ticket: %v", err)
+	}
+	return deleted == 1, nil
+}
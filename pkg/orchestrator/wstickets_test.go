@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRedeemWebSocketTicket(t *testing.T) {
+	o, _, server := newTestOrchestrator(t)
+	ctx := context.Background()
+
+	redeem := func(ticket string) bool {
+		t.Helper()
+		valid, err := o.RedeemWebSocketTicket(ctx, ticket)
+		if err != nil {
+			t.Fatalf("RedeemWebSocketTicket() error = %v", err)
+		}
+		return valid
+	}
+
+	ticket, err := o.IssueWebSocketTicket(ctx)
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket() error = %v", err)
+	}
+	for _, key := range server.Keys() {
+		if strings.Contains(key, ticket) {
+			t.Errorf("Redis key %q holds the ticket itself", key)
+		}
+	}
+	if ttl := server.TTL(webSocketTicketKey(ticket)); ttl != WebSocketTicketTTL {
+		t.Errorf("ticket TTL = %v, want %v", ttl, WebSocketTicketTTL)
+	}
+
+	if !redeem(ticket) {
+		t.Fatal("fresh ticket was refused")
+	}
+	if redeem(ticket) {
+		t.Error("ticket was redeemed twice")
+	}
+	if redeem("") {
+		t.Error("empty ticket was accepted")
+	}
+	if redeem("never-issued") {
+		t.Error("ticket that was never issued was accepted")
+	}
+
+	// Just before and just past the TTL
+	fresh, err := o.IssueWebSocketTicket(ctx)
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket() error = %v", err)
+	}
+	expired, err := o.IssueWebSocketTicket(ctx)
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket() error = %v", err)
+	}
+	server.FastForward(WebSocketTicketTTL - time.Second)
+	if !redeem(fresh) {
+		t.Error("ticket was refused before its TTL")
+	}
+	server.FastForward(2 * time.Second)
+	if redeem(expired) {
+		t.Error("ticket was accepted past its TTL")
+	}
+}
+
+func TestRedeemWebSocketTicketOnce(t *testing.T) {
+	o, _, _ := newTestOrchestrator(t)
+	ctx := context.Background()
+	ticket, err := o.IssueWebSocketTicket(ctx)
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket() error = %v", err)
+	}
+
+	// Connections racing with one ticket
+	var redeemed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			valid, err := o.RedeemWebSocketTicket(ctx, ticket)
+			if err != nil {
+				t.Errorf("RedeemWebSocketTicket() error = %v", err)
+			}
+			if valid {
+				redeemed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := redeemed.Load(); got != 1 {
+		t.Errorf("ticket was redeemed %d times, want once", got)
+	}
+}
+
+func TestRedeemWebSocketTicketRedisDown(t *testing.T) {
+	o, _, server := newTestOrchestrator(t)
+	ticket, err := o.IssueWebSocketTicket(context.Background())
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket() error = %v", err)
+	}
+	server.Close()
+
+	valid, err := o.RedeemWebSocketTicket(context.Background(), ticket)
+	if err == nil || valid {
+		t.Errorf("RedeemWebSocketTicket() = %t, %v with Redis down, want an error", valid, err)
+	}
+}
@@ -0,0 +1,245 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// imdsURL is the EC2 instance metadata service
+	imdsURL = "http://169.254.169.254"
+	// containerCredentialsURL serves task role credentials on ECS
+	containerCredentialsURL = "http://169.254.170.2"
+	// credentialRefresh is how long before expiry role credentials are renewed
+	credentialRefresh = 5 * time.Minute
+)
+
+// ErrNoCredentials is returned when no source in the chain has credentials
+var ErrNoCredentials = errors.New("no AWS credentials in the environment, shared credentials file, " +
+	"container or instance metadata")
+
+// credentials sign requests. Expires is zero for keys that do not expire.
+type credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expires      time.Time
+}
+
+// credentialChain looks credentials up the way the AWS SDKs do: environment
+// variables, then the shared credentials file, then the ECS container
+// endpoint, then the instance profile. Expiring credentials are cached until
+// shortly before they expire.
+type credentialChain struct {
+	client *http.Client // short timeout, metadata endpoints are local
+	mutex  sync.Mutex
+	cached *credentials
+}
+
+// get returns the current credentials, looking them up again once they are
+// about to expire
+func (c *credentialChain) get(ctx context.Context) (*credentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cached != nil && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > credentialRefresh) {
+		return c.cached, nil
+	}
+
+	if creds := environmentCredentials(); creds != nil {
+		c.cached = creds
+		return creds, nil
+	}
+	creds, err := sharedCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		creds, err = c.containerCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if creds == nil {
+		creds, err = c.instanceCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+// environmentCredentials reads AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func environmentCredentials() *credentials {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil
+	}
+	return &credentials{AccessKey: accessKey, SecretKey: secretKey, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+}
+
+// sharedCredentials reads the AWS_PROFILE profile, or default, of the shared
+// credentials file. It is nil when the file or profile does not exist.
+func sharedCredentials() (*credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var creds credentials
+	inProfile := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inProfile = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !inProfile || !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKey = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// roleCredentials is how the container and instance endpoints return
+// temporary credentials
+type roleCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// containerCredentials fetches the task role's credentials on ECS. It is nil
+// outside a container with a role.
+func (c *credentialChain) containerCredentials(ctx context.Context) (*credentials, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		url = containerCredentialsURL + relative
+	}
+	if url == "" {
+		return nil, nil
+	}
+	headers := map[string]string{}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		headers["Authorization"] = token
+	}
+	var role roleCredentials
+	if err := c.fetchJSON(ctx, url, headers, &role); err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	return role.credentials(), nil
+}
+
+// instanceCredentials fetches the instance profile's credentials through
+// IMDSv2
+func (c *credentialChain) instanceCredentials(ctx context.Context) (*credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsURL+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, ErrNoCredentials
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, ErrNoCredentials
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+
+	roleURL := imdsURL + "/latest/meta-data/iam/security-credentials/"
+	role, err := c.fetch(ctx, roleURL, headers)
+	if err != nil {
+		return nil, ErrNoCredentials
+	}
+	var creds roleCredentials
+	if err := c.fetchJSON(ctx, roleURL+strings.TrimSpace(string(role)), headers, &creds); err != nil {
+		return nil, fmt.Errorf("instance profile credentials: %w", err)
+	}
+	return creds.credentials(), nil
+}
+
+func (r *roleCredentials) credentials() *credentials {
+	return &credentials{AccessKey: r.AccessKeyID, SecretKey: r.SecretAccessKey, SessionToken: r.Token, Expires: r.Expiration}
+}
+
+// fetch reads a small metadata document
+func (c *credentialChain) fetch(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+func (c *credentialChain) fetchJSON(ctx context.Context, url string, headers map[string]string, v any) error {
+	body, err := c.fetch(ctx, url, headers)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
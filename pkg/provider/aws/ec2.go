@@ -0,0 +1,488 @@
+// Package aws runs workers on EC2 instances. Requests go to the EC2 query API
+// signed with AWS Signature Version 4, with credentials looked up the way the
+// AWS SDKs do.
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	apiVersion       = "2016-11-15"
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	// tagPrefix namespaces the tags the orchestrator puts on instances, so
+	// tags added by account policies are left alone
+	tagPrefix = "nuclei:"
+	// maxUserData is the most user data EC2 takes, after decoding
+	maxUserData = 16 << 10
+)
+
+// instanceType describes the resources and on-demand price of an instance
+// type. Spot instances usually cost less.
+type instanceType struct {
+	MemoryMB    int
+	VCPUs       int
+	PriceHourly float64 // us-east-1, Linux
+}
+
+// instanceTypes lists the instance types droplet sizes map onto
+var instanceTypes = map[string]instanceType{
+	"t3.micro":   {MemoryMB: 1024, VCPUs: 2, PriceHourly: 0.0104},
+	"t3.small":   {MemoryMB: 2048, VCPUs: 2, PriceHourly: 0.0208},
+	"t3.medium":  {MemoryMB: 4096, VCPUs: 2, PriceHourly: 0.0416},
+	"c5.xlarge":  {MemoryMB: 8192, VCPUs: 4, PriceHourly: 0.17},
+	"c5.2xlarge": {MemoryMB: 16384, VCPUs: 8, PriceHourly: 0.34},
+}
+
+// sizeTypes maps droplet size slugs onto the instance type with at least
+// their memory
+var sizeTypes = map[string]string{
+	"s-1vcpu-1gb":  "t3.micro",
+	"s-1vcpu-2gb":  "t3.small",
+	"s-2vcpu-2gb":  "t3.small",
+	"s-2vcpu-4gb":  "t3.medium",
+	"s-4vcpu-8gb":  "c5.xlarge",
+	"s-8vcpu-16gb": "c5.2xlarge",
+}
+
+// Config selects where and how instances are launched
+type Config struct {
+	Region string // e.g. us-east-1
+	AMI    string // image workers boot, which must run cloud-init
+	// InstanceType, when set, is launched for every worker. Otherwise droplet
+	// sizes map onto instance types and sizes that are instance types, such
+	// as c6i.large, are launched as they are.
+	InstanceType     string
+	SubnetID         string   // empty for the default VPC
+	SecurityGroupIDs []string // must let workers reach the server
+	Spot             bool     // launch spot instances, which AWS may reclaim
+	// Endpoint overrides https://ec2.<region>.amazonaws.com
+	Endpoint string
+}
+
+// Provider launches workers as EC2 instances
+type Provider struct {
+	config      Config
+	endpoint    string
+	http        *http.Client
+	credentials *credentialChain
+}
+
+var (
+	_ provider.Provider      = (*Provider)(nil)
+	_ provider.Interruptible = (*Provider)(nil)
+	_ provider.Pricer        = (*Provider)(nil)
+)
+
+// New checks cfg and returns a provider for its region
+func New(cfg Config) (*Provider, error) {
+	if cfg.Region == "" {
+		return nil, errors.New("an AWS region is required")
+	}
+	if !strings.HasPrefix(cfg.AMI, "ami-") {
+		return nil, errors.New("an AMI ID such as ami-0abcdef1234567890 is required")
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://ec2." + cfg.Region + ".amazonaws.com"
+	}
+	return &Provider{
+		config:      cfg,
+		endpoint:    endpoint,
+		http:        &http.Client{Timeout: 30 * time.Second},
+		credentials: &credentialChain{client: &http.Client{Timeout: 2 * time.Second}},
+	}, nil
+}
+
+// instanceType picks the instance type a worker of a droplet size runs on
+func (p *Provider) instanceType(size string) string {
+	if p.config.InstanceType != "" {
+		return p.config.InstanceType
+	}
+	if mapped, known := sizeTypes[size]; known {
+		return mapped
+	}
+	if strings.Contains(size, ".") {
+		return size
+	}
+	return sizeTypes["s-1vcpu-1gb"]
+}
+
+// CreateWorker launches an instance. A region that is an availability zone
+// of the configured region places it there unless a subnet decides that.
+// Refusals for lack of capacity wrap provider.ErrNoCapacity.
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	userData, err := compressUserData(req.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"Action":                            {"RunInstances"},
+		"ImageId":                           {p.config.AMI},
+		"InstanceType":                      {p.instanceType(req.Size)},
+		"MinCount":                          {"1"},
+		"MaxCount":                          {"1"},
+		"UserData":                          {userData},
+		"InstanceInitiatedShutdownBehavior": {"terminate"},
+		"TagSpecification.1.ResourceType":   {"instance"},
+		"TagSpecification.1.Tag.1.Key":      {"Name"},
+		"TagSpecification.1.Tag.1.Value":    {req.Name},
+	}
+	for i, tag := range req.Tags {
+		params.Set(fmt.Sprintf("TagSpecification.1.Tag.%d.Key", i+2), tagPrefix+tag)
+		params.Set(fmt.Sprintf("TagSpecification.1.Tag.%d.Value", i+2), "")
+	}
+	if p.config.SubnetID != "" {
+		// A public address is only assigned through the network interface
+		params.Set("NetworkInterface.1.DeviceIndex", "0")
+		params.Set("NetworkInterface.1.SubnetId", p.config.SubnetID)
+		params.Set("NetworkInterface.1.AssociatePublicIpAddress", "true")
+		for i, group := range p.config.SecurityGroupIDs {
+			params.Set(fmt.Sprintf("NetworkInterface.1.SecurityGroupId.%d", i+1), group)
+		}
+	} else {
+		for i, group := range p.config.SecurityGroupIDs {
+			params.Set(fmt.Sprintf("SecurityGroupId.%d", i+1), group)
+		}
+		if strings.HasPrefix(req.Region, p.config.Region) && len(req.Region) > len(p.config.Region) {
+			params.Set("Placement.AvailabilityZone", req.Region)
+		}
+	}
+	if p.config.Spot {
+		params.Set("InstanceMarketOptions.MarketType", "spot")
+		params.Set("InstanceMarketOptions.SpotOptions.SpotInstanceType", "one-time")
+		params.Set("InstanceMarketOptions.SpotOptions.InstanceInterruptionBehavior", "terminate")
+	}
+
+	var response struct {
+		Instances []instance `xml:"instancesSet>item"`
+	}
+	if err := p.call(ctx, params, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Instances) == 0 {
+		return nil, errors.New("RunInstances returned no instance")
+	}
+	return response.Instances[0].worker(), nil
+}
+
+// GetWorker describes an instance. Terminated instances are not found.
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	if !strings.HasPrefix(id, "i-") {
+		return nil, provider.ErrNotFound
+	}
+	instances, err := p.describe(ctx, url.Values{"InstanceId.1": {id}})
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if instance.State != "terminated" {
+			return instance.worker(), nil
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+// DestroyWorker terminates an instance
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	if !strings.HasPrefix(id, "i-") {
+		return provider.ErrNotFound
+	}
+	return p.call(ctx, url.Values{"Action": {"TerminateInstances"}, "InstanceId.1": {id}}, nil)
+}
+
+// ListWorkersByTag returns the instances carrying tag that are not
+// terminated
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	instances, err := p.describe(ctx, url.Values{
+		"Filter.1.Name":    {"tag-key"},
+		"Filter.1.Value.1": {tagPrefix + tag},
+		"Filter.2.Name":    {"instance-state-name"},
+		"Filter.2.Value.1": {"pending"},
+		"Filter.2.Value.2": {"running"},
+		"Filter.2.Value.3": {"stopping"},
+		"Filter.2.Value.4": {"stopped"},
+		"Filter.2.Value.5": {"shutting-down"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]provider.Worker, 0, len(instances))
+	for _, instance := range instances {
+		workers = append(workers, *instance.worker())
+	}
+	return workers, nil
+}
+
+// Regions lists the availability zones of the configured region, which
+// droplet configs and region limits name on EC2
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	var response struct {
+		Zones []struct {
+			Name  string `xml:"zoneName"`
+			State string `xml:"zoneState"`
+		} `xml:"availabilityZoneInfo>item"`
+	}
+	if err := p.call(ctx, url.Values{"Action": {"DescribeAvailabilityZones"}}, &response); err != nil {
+		return nil, err
+	}
+	regions := make([]provider.Region, 0, len(response.Zones))
+	for _, zone := range response.Zones {
+		regions = append(regions, provider.Region{Slug: zone.Name, Name: zone.Name, Available: zone.State == "available"})
+	}
+	return regions, nil
+}
+
+// Sizes lists the instance types droplet sizes map onto
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	sizes := make([]provider.Size, 0, len(instanceTypes))
+	for name, spec := range instanceTypes {
+		sizes = append(sizes, provider.Size{
+			Slug:        name,
+			MemoryMB:    spec.MemoryMB,
+			VCPUs:       spec.VCPUs,
+			PriceHourly: spec.PriceHourly,
+			Available:   true,
+		})
+	}
+	return sizes, nil
+}
+
+// SizePrice is the on-demand price of the instance type a droplet size maps
+// onto, zero for types not in the table
+func (p *Provider) SizePrice(ctx context.Context, size string) (float64, error) {
+	return instanceTypes[p.instanceType(size)].PriceHourly, nil
+}
+
+// Currency is US dollars
+func (p *Provider) Currency() string {
+	return "USD"
+}
+
+// InterruptionWatch waits for the spot interruption notice EC2 posts to the
+// instance metadata two minutes before reclaiming a spot instance
+func (p *Provider) InterruptionWatch() string {
+	if !p.config.Spot {
+		return ""
+	}
+	return `until [ "$(curl -s -o /dev/null -w '%{http_code}' -H "X-aws-ec2-metadata-token: $(curl -s -X PUT ` +
+		`-H 'X-aws-ec2-metadata-token-ttl-seconds: 60' ` + imdsURL + `/latest/api/token)" ` +
+		imdsURL + `/latest/meta-data/spot/instance-action)" = 200 ]; do sleep 5; done`
+}
+
+// describe pages through DescribeInstances
+func (p *Provider) describe(ctx context.Context, params url.Values) ([]instance, error) {
+	params.Set("Action", "DescribeInstances")
+	var instances []instance
+	for {
+		var response struct {
+			Reservations []struct {
+				Instances []instance `xml:"instancesSet>item"`
+			} `xml:"reservationSet>item"`
+			NextToken string `xml:"nextToken"`
+		}
+		if err := p.call(ctx, params, &response); err != nil {
+			return nil, err
+		}
+		for _, reservation := range response.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		if response.NextToken == "" {
+			return instances, nil
+		}
+		params.Set("NextToken", response.NextToken)
+	}
+}
+
+// instance is an instance as the EC2 API describes it
+type instance struct {
+	ID         string    `xml:"instanceId"`
+	Type       string    `xml:"instanceType"`
+	State      string    `xml:"instanceState>name"`
+	Zone       string    `xml:"placement>availabilityZone"`
+	PublicIP   string    `xml:"ipAddress"`
+	LaunchTime time.Time `xml:"launchTime"`
+	Tags       []struct {
+		Key   string `xml:"key"`
+		Value string `xml:"value"`
+	} `xml:"tagSet>item"`
+}
+
+// worker converts an instance
+func (i *instance) worker() *provider.Worker {
+	w := &provider.Worker{
+		ID:        i.ID,
+		Region:    i.Zone,
+		Size:      i.Type,
+		IP:        i.PublicIP,
+		CreatedAt: i.LaunchTime,
+	}
+	switch i.State {
+	case "pending":
+		w.Status = provider.StatusNew
+	case "running":
+		w.Status = provider.StatusActive
+	default:
+		w.Status = provider.StatusOff
+	}
+	for _, tag := range i.Tags {
+		if tag.Key == "Name" {
+			w.Name = tag.Value
+		} else if name, ours := strings.CutPrefix(tag.Key, tagPrefix); ours {
+			w.Tags = append(w.Tags, name)
+		}
+	}
+	if spec, known := instanceTypes[i.Type]; known {
+		w.PriceHourly = spec.PriceHourly
+	}
+	return w
+}
+
+// apiError is the error document of the EC2 query API
+type apiError struct {
+	Errors []struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Errors>Error"`
+}
+
+// capacityErrors are the codes EC2 refuses launches with when an
+// availability zone has no room for the instance type
+var capacityErrors = map[string]bool{
+	"InsufficientInstanceCapacity": true,
+	"InsufficientHostCapacity":     true,
+	"InsufficientCapacity":         true,
+	"Unsupported":                  true, // the type is not offered in the zone
+}
+
+// call makes a signed query API request and decodes its response into out,
+// unless out is nil
+func (p *Provider) call(ctx context.Context, params url.Values, out any) error {
+	creds, err := p.credentials.get(ctx)
+	if err != nil {
+		return err
+	}
+	params.Set("Version", apiVersion)
+	body := params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	p.sign(req, creds, body, time.Now().UTC())
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr apiError
+		if xml.Unmarshal(data, &apiErr) != nil || len(apiErr.Errors) == 0 {
+			return fmt.Errorf("%s returned %d", params.Get("Action"), resp.StatusCode)
+		}
+		code, message := apiErr.Errors[0].Code, apiErr.Errors[0].Message
+		switch {
+		case strings.HasPrefix(code, "InvalidInstanceID."):
+			return provider.ErrNotFound
+		case capacityErrors[code]:
+			return fmt.Errorf("%w: %s: %s", provider.ErrNoCapacity, code, message)
+		}
+		return fmt.Errorf("%s failed: %s: %s", params.Get("Action"), code, message)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// sign adds SigV4 authorization headers to a query API request
+func (p *Provider) sign(req *http.Request, creds *credentials, body string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	scope := now.Format("20060102") + "/" + p.config.Region + "/ec2/aws4_request"
+	payloadHash := sha256.Sum256([]byte(body))
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	names := []string{"content-type", "host", "x-amz-date"}
+	values := []string{req.Header.Get("Content-Type"), req.URL.Host, amzDate}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		names = append(names, "x-amz-security-token")
+		values = append(values, creds.SessionToken)
+	}
+	var canonicalHeaders strings.Builder
+	for i, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(values[i]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, p.config.Region)
+	key = hmacSHA256(key, "ec2")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// compressUserData gzips the bootstrap script, which cloud-init unpacks, to
+// fit EC2's user data limit, and encodes it as RunInstances expects
+func compressUserData(script string) (string, error) {
+	var compressed bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	writer.Write([]byte(script))
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	if compressed.Len() > maxUserData {
+		return "", fmt.Errorf("worker user data is %d bytes compressed, over EC2's 16KB limit; "+
+			"give the scan more workers or use pull distribution", compressed.Len())
+	}
+	return base64.StdEncoding.EncodeToString(compressed.Bytes()), nil
+}
@@ -0,0 +1,366 @@
+// Package digitalocean runs workers on DigitalOcean droplets.
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"nuclei-distributed/pkg/provider"
+)
+
+// pageSize is the largest page the DigitalOcean API returns
+const pageSize = 200
+
+const (
+	// assignAttempts is how many times a droplet's project assignment is
+	// tried before giving up on it
+	assignAttempts = 6
+	// assignBackoff is the wait before the first retry, doubling after each
+	assignBackoff = 5 * time.Second
+)
+
+// tagPattern is what DigitalOcean accepts as a tag name
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9:_-]{1,255}$`)
+
+// Config selects the account and how droplets are labelled for billing
+type Config struct {
+	Token string
+	// ProjectID is the project every droplet is assigned to once created,
+	// none when empty
+	ProjectID string
+	// Tags are added to every droplet next to the worker and scan tags, e.g.
+	// cost-center:security
+	Tags []string
+	// Endpoint overrides https://api.digitalocean.com/
+	Endpoint string
+}
+
+// Provider creates workers as droplets through the DigitalOcean API
+type Provider struct {
+	config    Config
+	client    *godo.Client
+	transport *rateTransport
+}
+
+var (
+	_ provider.Provider        = (*Provider)(nil)
+	_ provider.ProjectAssigner = (*Provider)(nil)
+	_ provider.SelfChecker     = (*Provider)(nil)
+)
+
+// New checks cfg and returns a provider authenticated with its token
+func New(cfg Config) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("a DigitalOcean API token is required")
+	}
+	for _, tag := range cfg.Tags {
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q may only contain letters, digits, colons, dashes and underscores", tag)
+		}
+	}
+	// The transport authenticates, paces and retries requests itself
+	transport := newRateTransport(cfg.Token, http.DefaultTransport)
+	options := []godo.ClientOpt{}
+	if cfg.Endpoint != "" {
+		options = append(options, godo.SetBaseURL(cfg.Endpoint))
+	}
+	client, err := godo.New(&http.Client{Transport: transport}, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{config: cfg, client: client, transport: transport}, nil
+}
+
+// CreateWorker creates a droplet carrying the configured tags and assigns it
+// to the configured project. Refusals for lack of room in the region wrap
+// provider.ErrNoCapacity. A failed assignment does not fail the droplet, it
+// is retried in the background.
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	droplet, _, err := p.client.Droplets.Create(ctx, &godo.DropletCreateRequest{
+		Name:   req.Name,
+		Region: req.Region,
+		Size:   req.Size,
+		Image: godo.DropletCreateImage{
+			Slug: req.Image,
+		},
+		UserData: req.UserData,
+		IPv6:     true, // some targets are only reachable over IPv6
+		Tags:     append(append([]string(nil), req.Tags...), p.config.Tags...),
+	})
+	if err != nil {
+		if isCapacityError(err) {
+			return nil, fmt.Errorf("%w: %v", provider.ErrNoCapacity, err)
+		}
+		return nil, err
+	}
+	if p.config.ProjectID != "" {
+		if _, _, err := p.client.Projects.AssignResources(ctx, p.config.ProjectID, droplet.URN()); err != nil {
+			slog.Warn("Failed to assign droplet to project, retrying", "droplet_id", droplet.ID,
+				"project", p.config.ProjectID, "error", err)
+			go p.assignProject(droplet.URN())
+		}
+	}
+	return p.worker(droplet), nil
+}
+
+// assignProject retries assigning a droplet to the configured project with
+// growing waits, logging each failure
+func (p *Provider) assignProject(urn string) {
+	backoff := assignBackoff
+	for attempt := 2; attempt <= assignAttempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, _, err := p.client.Projects.AssignResources(ctx, p.config.ProjectID, urn)
+		cancel()
+		if err == nil {
+			slog.Info("Assigned droplet to project", "urn", urn, "project", p.config.ProjectID, "attempt", attempt)
+			return
+		}
+		if errors.Is(notFound(err), provider.ErrNotFound) {
+			return
+		}
+		slog.Warn("Failed to assign droplet to project", "urn", urn, "project", p.config.ProjectID,
+			"attempt", attempt, "error", err)
+	}
+	slog.Error("Gave up assigning droplet to project", "urn", urn, "project", p.config.ProjectID)
+}
+
+// Project is the configured project ID
+func (p *Provider) Project() string {
+	return p.config.ProjectID
+}
+
+// ProjectWorkers returns the IDs of the droplets assigned to the configured
+// project
+func (p *Provider) ProjectWorkers(ctx context.Context) (map[string]bool, error) {
+	if p.config.ProjectID == "" {
+		return nil, nil
+	}
+	resources, err := listAll(ctx, func(ctx context.Context, options *godo.ListOptions) ([]godo.ProjectResource, *godo.Response, error) {
+		return p.client.Projects.ListResources(ctx, p.config.ProjectID, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, resource := range resources {
+		if id, found := strings.CutPrefix(resource.URN, "do:droplet:"); found {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// CheckAccess checks the token can read the account, which must be active,
+// create tag and, when one is configured, read the project. Tokens with
+// custom scopes may lack any of these.
+func (p *Provider) CheckAccess(ctx context.Context, tag string) error {
+	account, _, err := p.client.Account.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("reading the account: %w", err)
+	}
+	if account.Status != "" && account.Status != "active" {
+		return fmt.Errorf("account is %s: %s", account.Status, account.StatusMessage)
+	}
+	// Creating a tag that exists returns it, so this changes nothing
+	if _, _, err := p.client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag}); err != nil {
+		return fmt.Errorf("creating tag %s: %w", tag, err)
+	}
+	if p.config.ProjectID != "" {
+		if _, _, err := p.client.Projects.Get(ctx, p.config.ProjectID); err != nil {
+			return fmt.Errorf("reading project %s: %w", p.config.ProjectID, err)
+		}
+	}
+	return nil
+}
+
+// CheckSlugs checks region exists and takes new droplets of size, and that
+// image is the slug of an image droplets can be created from
+func (p *Provider) CheckSlugs(ctx context.Context, region, size, image string) error {
+	regions, err := p.Regions(ctx)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(regions, func(candidate provider.Region) bool { return candidate.Slug == region })
+	switch {
+	case index < 0:
+		return fmt.Errorf("region %s does not exist", region)
+	case !regions[index].Available:
+		return fmt.Errorf("region %s does not take new droplets", region)
+	case !slices.Contains(regions[index].Sizes, size):
+		return fmt.Errorf("size %s is not offered in %s", size, region)
+	}
+	if _, _, err := p.client.Images.GetBySlug(ctx, image); err != nil {
+		if errors.Is(notFound(err), provider.ErrNotFound) {
+			return fmt.Errorf("image %s does not exist", image)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetWorker returns a droplet
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	dropletID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, provider.ErrNotFound
+	}
+	droplet, _, err := p.client.Droplets.Get(ctx, dropletID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return p.worker(droplet), nil
+}
+
+// DestroyWorker deletes a droplet
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	dropletID, err := strconv.Atoi(id)
+	if err != nil {
+		return provider.ErrNotFound
+	}
+	if _, err := p.client.Droplets.Delete(ctx, dropletID); err != nil {
+		return notFound(err)
+	}
+	return nil
+}
+
+// ListWorkersByTag returns every droplet carrying tag, across pages
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	droplets, err := listAll(ctx, func(ctx context.Context, options *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+		return p.client.Droplets.ListByTag(ctx, tag, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]provider.Worker, 0, len(droplets))
+	for i := range droplets {
+		workers = append(workers, *p.worker(&droplets[i]))
+	}
+	return workers, nil
+}
+
+// Regions lists DigitalOcean's regions
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	regions, err := listAll(ctx, p.client.Regions.List)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provider.Region, 0, len(regions))
+	for _, region := range regions {
+		result = append(result, provider.Region{
+			Slug:      region.Slug,
+			Name:      region.Name,
+			Available: region.Available,
+			Sizes:     region.Sizes,
+		})
+	}
+	return result, nil
+}
+
+// Sizes lists DigitalOcean's droplet sizes
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	sizes, err := listAll(ctx, p.client.Sizes.List)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provider.Size, 0, len(sizes))
+	for _, size := range sizes {
+		result = append(result, provider.Size{
+			Slug:        size.Slug,
+			MemoryMB:    size.Memory,
+			VCPUs:       size.Vcpus,
+			PriceHourly: size.PriceHourly,
+			Available:   size.Available,
+			Regions:     size.Regions,
+		})
+	}
+	return result, nil
+}
+
+// listAll follows a list call's pages to the last
+func listAll[T any](ctx context.Context, list func(context.Context, *godo.ListOptions) ([]T, *godo.Response, error)) ([]T, error) {
+	var all []T
+	options := &godo.ListOptions{PerPage: pageSize}
+	for {
+		page, response, err := list(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if response == nil || response.Links == nil || response.Links.IsLastPage() {
+			return all, nil
+		}
+		current, err := response.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		options.Page = current + 1
+	}
+}
+
+// worker converts a droplet, leaving out the configured tags so only the
+// orchestrator's own remain
+func (p *Provider) worker(droplet *godo.Droplet) *provider.Worker {
+	w := &provider.Worker{
+		ID:     strconv.Itoa(droplet.ID),
+		Name:   droplet.Name,
+		Size:   droplet.SizeSlug,
+		Status: droplet.Status,
+	}
+	for _, tag := range droplet.Tags {
+		if !slices.Contains(p.config.Tags, tag) {
+			w.Tags = append(w.Tags, tag)
+		}
+	}
+	if w.Status == "archive" {
+		w.Status = provider.StatusOff
+	}
+	if droplet.Region != nil {
+		w.Region = droplet.Region.Slug
+	}
+	if droplet.Size != nil {
+		w.PriceHourly = droplet.Size.PriceHourly
+	}
+	w.IP, _ = droplet.PublicIPv4()
+	w.IPv6, _ = droplet.PublicIPv6()
+	if created, err := time.Parse(time.RFC3339, droplet.Created); err == nil {
+		w.CreatedAt = created
+	}
+	return w
+}
+
+// notFound maps 404 responses onto provider.ErrNotFound
+func notFound(err error) error {
+	var response *godo.ErrorResponse
+	if errors.As(err, &response) && response.Response != nil && response.Response.StatusCode == 404 {
+		return provider.ErrNotFound
+	}
+	return err
+}
+
+// isCapacityError reports whether DigitalOcean refused a droplet because the
+// region has no room for it
+func isCapacityError(err error) bool {
+	var response *godo.ErrorResponse
+	if !errors.As(err, &response) || response.Response == nil {
+		return false
+	}
+	switch response.Response.StatusCode {
+	case 422, 503:
+	default:
+		return false
+	}
+	message := strings.ToLower(response.Message)
+	return strings.Contains(message, "capacity") || strings.Contains(message, "not available") ||
+		strings.Contains(message, "unavailable")
+}
@@ -0,0 +1,81 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+
+	"github.com/digitalocean/godo"
+	"nuclei-distributed/pkg/provider"
+)
+
+var _ provider.Firewaller = (*Provider)(nil)
+
+// anywhere is every IPv4 and IPv6 address
+var anywhere = []string{"0.0.0.0/0", "::/0"}
+
+// EnsureFirewall creates a cloud firewall applied to droplets carrying tag,
+// or attaches the tag to the existing firewall of that name. Droplets accept
+// SSH from sshSources and nothing else, and may connect anywhere.
+func (p *Provider) EnsureFirewall(ctx context.Context, name, tag string, sshSources []string) error {
+	// Firewalls only take tags that exist, droplets create theirs
+	if _, _, err := p.client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag}); err != nil {
+		return err
+	}
+
+	existing, err := p.firewall(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		for _, attached := range existing.Tags {
+			if attached == tag {
+				return nil
+			}
+		}
+		_, err := p.client.Firewalls.AddTags(ctx, existing.ID, tag)
+		return err
+	}
+
+	// A firewall without inbound rules denies all inbound traffic
+	inbound := []godo.InboundRule{}
+	if len(sshSources) > 0 {
+		inbound = []godo.InboundRule{{Protocol: "tcp", PortRange: "22", Sources: &godo.Sources{Addresses: sshSources}}}
+	}
+	_, _, err = p.client.Firewalls.Create(ctx, &godo.FirewallRequest{
+		Name:         name,
+		InboundRules: inbound,
+		OutboundRules: []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: "all", Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "udp", PortRange: "all", Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "icmp", Destinations: &godo.Destinations{Addresses: anywhere}},
+		},
+		Tags: []string{tag},
+	})
+	return err
+}
+
+// DeleteFirewall deletes the cloud firewall named name, if there is one
+func (p *Provider) DeleteFirewall(ctx context.Context, name string) error {
+	existing, err := p.firewall(ctx, name)
+	if err != nil || existing == nil {
+		return err
+	}
+	if _, err := p.client.Firewalls.Delete(ctx, existing.ID); err != nil && !errors.Is(notFound(err), provider.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// firewall finds the cloud firewall named name, or nil
+func (p *Provider) firewall(ctx context.Context, name string) (*godo.Firewall, error) {
+	firewalls, err := listAll(ctx, p.client.Firewalls.List)
+	if err != nil {
+		return nil, err
+	}
+	for i := range firewalls {
+		if firewalls[i].Name == name {
+			return &firewalls[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,213 @@
+package digitalocean
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	// lowBudgetShare is the share of the hourly budget below which requests
+	// are spread over the rest of the window instead of sent right away
+	lowBudgetShare = 20
+	// minLowBudget keeps pacing on for small limits
+	minLowBudget = 10
+	// maxRetries is how often a request refused with 429, or failed with a
+	// server error when it is safe to repeat, is sent again
+	maxRetries = 5
+	// maxRetryWait caps the wait before a retry; the hourly reset can be an
+	// hour away while the per-minute burst limit clears much sooner
+	maxRetryWait = time.Minute
+	// serverErrorWait is the first wait after a server error, doubling
+	serverErrorWait = time.Second
+)
+
+// rateTransport authenticates DigitalOcean API requests and keeps them
+// within the account's rate limit, which every client of the token shares.
+// It reads the RateLimit headers of each response, spreads requests over
+// the rest of the window once the remaining budget is low, and retries
+// requests refused with 429 once the limit resets.
+type rateTransport struct {
+	token string
+	base  http.RoundTripper
+
+	mutex     sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	next      time.Time // earliest send of the next paced request
+	updatedAt time.Time
+	throttled int64
+	retried   int64
+}
+
+var _ provider.RateLimited = (*Provider)(nil)
+
+func newRateTransport(token string, base http.RoundTripper) *rateTransport {
+	return &rateTransport{token: strings.Trim(strings.TrimSpace(token), "'"), base: base}
+}
+
+// RoundTrip sends a request once the budget allows, retrying it after 429s
+// and, for requests safe to repeat, server errors
+func (t *rateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverWait := serverErrorWait
+	for attempt := 0; ; attempt++ {
+		if err := t.pace(req.Context()); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req.Clone(req.Context())
+		if attempt > 0 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		attemptReq.Header.Set("Authorization", "Bearer "+t.token)
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		replayable := req.Body == nil || req.GetBody != nil
+		if err != nil {
+			if attempt >= maxRetries || !idempotent(req.Method) || !replayable {
+				return nil, err
+			}
+			if err := sleep(req.Context(), serverWait); err != nil {
+				return nil, err
+			}
+			serverWait *= 2
+			continue
+		}
+		t.observe(resp)
+
+		limited := resp.StatusCode == http.StatusTooManyRequests
+		failed := resp.StatusCode >= 500 && idempotent(req.Method)
+		if (!limited && !failed) || attempt >= maxRetries || !replayable {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		t.mutex.Lock()
+		t.retried++
+		if limited {
+			// Every request waits, not just this one, or the others would
+			// be refused too
+			if until := time.Now().Add(t.retryAfter(resp)); until.After(t.next) {
+				t.next = until
+			}
+		}
+		t.mutex.Unlock()
+		if failed {
+			if err := sleep(req.Context(), serverWait); err != nil {
+				return nil, err
+			}
+			serverWait *= 2
+		}
+	}
+}
+
+// pace holds a request back after a 429 until the API accepts requests
+// again, and while the budget is low so what is left of it lasts until the
+// window resets
+func (t *rateTransport) pace(ctx context.Context) error {
+	t.mutex.Lock()
+	now := time.Now()
+	start := now
+	if t.next.After(start) {
+		start = t.next
+	}
+	if t.limit > 0 && t.reset.After(now) && t.remaining <= max(t.limit/lowBudgetShare, minLowBudget) {
+		t.next = start.Add(t.reset.Sub(now) / time.Duration(t.remaining+1))
+	}
+	delay := start.Sub(now)
+	if delay > 0 {
+		t.throttled++
+	}
+	if t.remaining > 0 {
+		t.remaining--
+	}
+	t.mutex.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// observe records the budget a response reports
+func (t *rateTransport) observe(resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.limit = limit
+	t.remaining = remaining
+	if reset > 0 {
+		t.reset = time.Unix(reset, 0)
+	}
+	t.updatedAt = time.Now()
+}
+
+// retryAfter is how long to wait before retrying a request refused with
+// 429: the Retry-After the API sent, or until the limit resets. Callers
+// must hold t.mutex.
+func (t *rateTransport) retryAfter(resp *http.Response) time.Duration {
+	wait := time.Second
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if until := time.Until(t.reset); until > wait {
+		wait = until
+	}
+	return min(wait, maxRetryWait)
+}
+
+// state returns the budget as last reported
+func (t *rateTransport) state() provider.RateLimit {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return provider.RateLimit{
+		Limit:     t.limit,
+		Remaining: t.remaining,
+		Reset:     t.reset,
+		Throttled: t.throttled,
+		Retried:   t.retried,
+		UpdatedAt: t.updatedAt,
+	}
+}
+
+// RateLimit reports the account's API request budget
+func (p *Provider) RateLimit() provider.RateLimit {
+	return p.transport.state()
+}
+
+// idempotent reports whether a request may be repeated after a server
+// error without doing its work twice
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
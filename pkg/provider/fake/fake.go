@@ -0,0 +1,174 @@
+// Package fake is an in-memory provider for exercising the orchestrator
+// without cloud credentials. Workers are active with an IP as soon as they
+// are created unless told otherwise.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+// Provider keeps workers in memory. The zero value is not usable, see New.
+type Provider struct {
+	mutex   sync.Mutex
+	nextID  int
+	workers map[string]*provider.Worker
+	regions []provider.Region
+	sizes   []provider.Size
+
+	// BootPolls is the number of GetWorker calls a new worker stays new for
+	BootPolls int
+	// CreateErr, when set, is called before each worker is created and its
+	// error returned instead, e.g. to wrap provider.ErrNoCapacity
+	CreateErr func(req provider.CreateRequest) error
+
+	polls     map[string]int
+	destroyed []string
+}
+
+var _ provider.Provider = (*Provider)(nil)
+
+// New returns an empty provider offering a single region and size
+func New() *Provider {
+	return &Provider{
+		nextID:  1,
+		workers: make(map[string]*provider.Worker),
+		polls:   make(map[string]int),
+		regions: []provider.Region{{Slug: "nyc3", Name: "New York 3", Available: true, Sizes: []string{"s-1vcpu-1gb"}}},
+		sizes: []provider.Size{{Slug: "s-1vcpu-1gb", MemoryMB: 1024, VCPUs: 1, PriceHourly: 0.00893,
+			Available: true, Regions: []string{"nyc3"}}},
+	}
+}
+
+// SetCatalog replaces the regions and sizes the provider offers
+func (p *Provider) SetCatalog(regions []provider.Region, sizes []provider.Size) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.regions = regions
+	p.sizes = sizes
+}
+
+// CreateWorker records a worker
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.CreateErr != nil {
+		if err := p.CreateErr(req); err != nil {
+			return nil, err
+		}
+	}
+
+	id := strconv.Itoa(p.nextID)
+	p.nextID++
+	worker := &provider.Worker{
+		ID:        id,
+		Name:      req.Name,
+		Region:    req.Region,
+		Size:      req.Size,
+		Status:    provider.StatusActive,
+		IP:        workerIP(id),
+		Tags:      append([]string(nil), req.Tags...),
+		CreatedAt: time.Now(),
+	}
+	if p.BootPolls > 0 {
+		worker.Status = provider.StatusNew
+		worker.IP = ""
+	}
+	for _, size := range p.sizes {
+		if size.Slug == req.Size {
+			worker.PriceHourly = size.PriceHourly
+		}
+	}
+	p.workers[id] = worker
+	copied := *worker
+	return &copied, nil
+}
+
+// GetWorker returns a worker, booting it once it was polled BootPolls times
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	worker, exists := p.workers[id]
+	if !exists {
+		return nil, provider.ErrNotFound
+	}
+	if worker.Status == provider.StatusNew {
+		p.polls[id]++
+		if p.polls[id] >= p.BootPolls {
+			worker.Status = provider.StatusActive
+			worker.IP = workerIP(id)
+		}
+	}
+	copied := *worker
+	return &copied, nil
+}
+
+// DestroyWorker forgets a worker
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, exists := p.workers[id]; !exists {
+		return provider.ErrNotFound
+	}
+	delete(p.workers, id)
+	p.destroyed = append(p.destroyed, id)
+	return nil
+}
+
+// ListWorkersByTag returns the workers carrying tag, in creation order
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var workers []provider.Worker
+	for i := 1; i < p.nextID; i++ {
+		if worker, exists := p.workers[strconv.Itoa(i)]; exists && worker.HasTag(tag) {
+			workers = append(workers, *worker)
+		}
+	}
+	return workers, nil
+}
+
+// Regions returns the regions on offer
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]provider.Region(nil), p.regions...), nil
+}
+
+// Sizes returns the sizes on offer
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]provider.Size(nil), p.sizes...), nil
+}
+
+// Workers returns every worker not destroyed, in creation order
+func (p *Provider) Workers() []provider.Worker {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var workers []provider.Worker
+	for i := 1; i < p.nextID; i++ {
+		if worker, exists := p.workers[strconv.Itoa(i)]; exists {
+			workers = append(workers, *worker)
+		}
+	}
+	return workers
+}
+
+// Destroyed returns the IDs of destroyed workers, in the order they went
+func (p *Provider) Destroyed() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.destroyed...)
+}
+
+// workerIP makes up a worker's address from its ID
+func workerIP(id string) string {
+	n, _ := strconv.Atoi(id)
+	return fmt.Sprintf("10.0.%d.%d", n/256%256, n%256)
+}
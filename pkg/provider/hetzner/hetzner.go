@@ -0,0 +1,331 @@
+// Package hetzner runs workers on Hetzner Cloud servers through the Hetzner
+// Cloud API.
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"nuclei-distributed/pkg/provider"
+	"nuclei-distributed/pkg/version"
+)
+
+const (
+	// labelPrefix namespaces the labels the orchestrator puts on servers
+	labelPrefix = "nuclei/"
+	// priceRefresh is how long server type prices are cached
+	priceRefresh = time.Hour
+)
+
+// DefaultServerTypes maps droplet size slugs onto the server type with at
+// least their memory and cores
+var DefaultServerTypes = map[string]string{
+	"s-1vcpu-1gb":  "cx22",
+	"s-1vcpu-2gb":  "cx22",
+	"s-2vcpu-2gb":  "cx22",
+	"s-2vcpu-4gb":  "cx22",
+	"s-4vcpu-8gb":  "cx32",
+	"s-8vcpu-16gb": "cx42",
+}
+
+// Config selects where and how servers are created
+type Config struct {
+	Token    string
+	Location string // e.g. nbg1, used unless a scan names another
+	Image    string // defaults to ubuntu-22.04
+	// ServerTypes maps droplet size slugs onto server types, over
+	// DefaultServerTypes. Sizes without an entry are created as server types
+	// of that name.
+	ServerTypes map[string]string
+	// Endpoint overrides https://api.hetzner.cloud/v1
+	Endpoint string
+}
+
+// Provider creates workers as Hetzner Cloud servers
+type Provider struct {
+	config      Config
+	serverTypes map[string]string
+	client      *hcloud.Client
+
+	pricesMutex sync.Mutex
+	prices      map[string]float64 // by server type, in the configured location
+	pricedAt    time.Time
+}
+
+var (
+	_ provider.Provider = (*Provider)(nil)
+	_ provider.Pricer   = (*Provider)(nil)
+)
+
+// New checks cfg and returns a provider for its project
+func New(cfg Config) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("a Hetzner Cloud API token is required")
+	}
+	if cfg.Location == "" {
+		cfg.Location = "nbg1"
+	}
+	if cfg.Image == "" {
+		cfg.Image = "ubuntu-22.04"
+	}
+	serverTypes := make(map[string]string, len(DefaultServerTypes)+len(cfg.ServerTypes))
+	for size, serverType := range DefaultServerTypes {
+		serverTypes[size] = serverType
+	}
+	for size, serverType := range cfg.ServerTypes {
+		serverTypes[size] = serverType
+	}
+	options := []hcloud.ClientOption{
+		hcloud.WithToken(cfg.Token),
+		hcloud.WithApplication("nuclei-distributed", version.Version),
+	}
+	if cfg.Endpoint != "" {
+		options = append(options, hcloud.WithEndpoint(strings.TrimRight(cfg.Endpoint, "/")))
+	}
+	return &Provider{
+		config:      cfg,
+		serverTypes: serverTypes,
+		client:      hcloud.NewClient(options...),
+	}, nil
+}
+
+// ParseServerTypes reads size=serverType entries, comma-separated, e.g.
+// "s-1vcpu-1gb=cx22,s-4vcpu-8gb=cpx31"
+func ParseServerTypes(value string) (map[string]string, error) {
+	serverTypes := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		size, serverType, found := strings.Cut(entry, "=")
+		size, serverType = strings.TrimSpace(size), strings.TrimSpace(serverType)
+		if !found || size == "" || serverType == "" {
+			return nil, fmt.Errorf("%q is not size=serverType", entry)
+		}
+		serverTypes[size] = serverType
+	}
+	return serverTypes, nil
+}
+
+// serverType picks the server type a worker of a droplet size runs on
+func (p *Provider) serverType(size string) string {
+	if serverType, mapped := p.serverTypes[size]; mapped {
+		return serverType
+	}
+	return size
+}
+
+// CreateWorker creates a server. A region that is not a Hetzner location,
+// such as a DigitalOcean one, is replaced by the configured location.
+// Refusals for lack of room wrap provider.ErrNoCapacity.
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	location := req.Region
+	if !locations[location] {
+		location = p.config.Location
+	}
+	image := p.config.Image
+	if strings.Contains(req.Image, ".") {
+		image = req.Image
+	}
+	labels := make(map[string]string, len(req.Tags))
+	for _, tag := range req.Tags {
+		labels[labelPrefix+tag] = ""
+	}
+
+	created, _, err := p.client.Server.Create(ctx, hcloud.ServerCreateOpts{
+		Name:             req.Name,
+		ServerType:       &hcloud.ServerType{Name: p.serverType(req.Size)},
+		Image:            &hcloud.Image{Name: image},
+		Location:         &hcloud.Location{Name: location},
+		UserData:         req.UserData,
+		Labels:           labels,
+		StartAfterCreate: hcloud.Ptr(true),
+	})
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return worker(created.Server), nil
+}
+
+// GetWorker returns a server
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	serverID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, provider.ErrNotFound
+	}
+	server, _, err := p.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if server == nil {
+		return nil, provider.ErrNotFound
+	}
+	return worker(server), nil
+}
+
+// DestroyWorker deletes a server and waits for the deletion to finish, so
+// the server no longer shows up in ListWorkersByTag
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	serverID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return provider.ErrNotFound
+	}
+	deleted, _, err := p.client.Server.DeleteWithResult(ctx, &hcloud.Server{ID: serverID})
+	if err != nil {
+		return apiError(err)
+	}
+	return p.client.Action.WaitFor(ctx, deleted.Action)
+}
+
+// ListWorkersByTag returns the servers labelled with tag, across pages
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	servers, err := p.client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{PerPage: 50, LabelSelector: labelPrefix + tag},
+	})
+	if err != nil {
+		return nil, apiError(err)
+	}
+	workers := make([]provider.Worker, 0, len(servers))
+	for _, server := range servers {
+		workers = append(workers, *worker(server))
+	}
+	return workers, nil
+}
+
+// Regions lists Hetzner's locations
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	all, err := p.client.Location.All(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	regions := make([]provider.Region, 0, len(all))
+	for _, location := range all {
+		regions = append(regions, provider.Region{Slug: location.Name, Name: location.Description, Available: true})
+	}
+	return regions, nil
+}
+
+// Sizes lists Hetzner's server types with their prices in the configured
+// location
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	types, err := p.client.ServerType.All(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	sizes := make([]provider.Size, 0, len(types))
+	for _, serverType := range types {
+		size := provider.Size{
+			Slug:      serverType.Name,
+			MemoryMB:  int(serverType.Memory * 1024),
+			VCPUs:     serverType.Cores,
+			Available: !serverType.IsDeprecated(),
+		}
+		for _, pricing := range serverType.Pricings {
+			if pricing.Location == nil {
+				continue
+			}
+			size.Regions = append(size.Regions, pricing.Location.Name)
+			if pricing.Location.Name == p.config.Location {
+				size.PriceHourly, _ = strconv.ParseFloat(pricing.Hourly.Gross, 64)
+			}
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// SizePrice is the gross hourly price, in euros, of the server type a
+// droplet size maps onto in the configured location. Prices are fetched
+// once an hour.
+func (p *Provider) SizePrice(ctx context.Context, size string) (float64, error) {
+	p.pricesMutex.Lock()
+	defer p.pricesMutex.Unlock()
+	if p.prices == nil || time.Since(p.pricedAt) > priceRefresh {
+		sizes, err := p.Sizes(ctx)
+		if err != nil {
+			return 0, err
+		}
+		p.prices = make(map[string]float64, len(sizes))
+		for _, size := range sizes {
+			p.prices[size.Slug] = size.PriceHourly
+		}
+		p.pricedAt = time.Now()
+	}
+	return p.prices[p.serverType(size)], nil
+}
+
+// Currency is euros, which Hetzner bills in
+func (p *Provider) Currency() string {
+	return "EUR"
+}
+
+// worker converts a server
+func worker(s *hcloud.Server) *provider.Worker {
+	w := &provider.Worker{
+		ID:        strconv.FormatInt(s.ID, 10),
+		Name:      s.Name,
+		CreatedAt: s.Created,
+	}
+	if s.Datacenter != nil && s.Datacenter.Location != nil {
+		w.Region = s.Datacenter.Location.Name
+	}
+	switch s.Status {
+	case hcloud.ServerStatusRunning:
+		w.Status = provider.StatusActive
+	case hcloud.ServerStatusInitializing, hcloud.ServerStatusStarting:
+		w.Status = provider.StatusNew
+	default:
+		w.Status = provider.StatusOff
+	}
+	if ip := s.PublicNet.IPv4.IP; ip != nil {
+		w.IP = ip.String()
+	}
+	for label := range s.Labels {
+		if tag, ours := strings.CutPrefix(label, labelPrefix); ours {
+			w.Tags = append(w.Tags, tag)
+		}
+	}
+	if s.ServerType != nil {
+		w.Size = s.ServerType.Name
+		for _, pricing := range s.ServerType.Pricings {
+			if pricing.Location != nil && pricing.Location.Name == w.Region {
+				w.PriceHourly, _ = strconv.ParseFloat(pricing.Hourly.Gross, 64)
+			}
+		}
+	}
+	return w
+}
+
+// locations are Hetzner Cloud's locations
+var locations = map[string]bool{
+	"fsn1": true, "nbg1": true, "hel1": true, "ash": true, "hil": true, "sin": true,
+}
+
+// capacityErrors are the error codes Hetzner refuses servers with when the
+// location has no room for the server type
+var capacityErrors = map[hcloud.ErrorCode]bool{
+	hcloud.ErrorCodeResourceUnavailable: true,
+	hcloud.ErrorCodePlacementError:      true,
+}
+
+// apiError maps missing servers onto provider.ErrNotFound and refusals for
+// lack of room onto provider.ErrNoCapacity
+func apiError(err error) error {
+	var apiErr hcloud.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.Code == hcloud.ErrorCodeNotFound:
+		return provider.ErrNotFound
+	case capacityErrors[apiErr.Code]:
+		return fmt.Errorf("%w: %s", provider.ErrNoCapacity, apiErr.Message)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package hetzner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const testServer = `{
+	"id": 42,
+	"name": "abcd1234-worker-0",
+	"status": "initializing",
+	"created": "2024-05-01T10:00:00+00:00",
+	"public_net": {"ipv4": {"ip": "203.0.113.7"}},
+	"server_type": {"name": "cx22", "prices": [
+		{"location": "fsn1", "price_hourly": {"net": "0.0050", "gross": "0.0060"}},
+		{"location": "nbg1", "price_hourly": {"net": "0.0050", "gross": "0.0059"}}
+	]},
+	"datacenter": {"location": {"name": "nbg1"}},
+	"labels": {"nuclei/nuclei-worker": "", "nuclei/scan-1": "", "team": "security"}
+}`
+
+// fakeAPI serves the parts of the Hetzner Cloud API the provider uses and
+// counts polls of the delete action
+func fakeAPI(t *testing.T, polls *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "POST /servers":
+			var body struct {
+				ServerType string            `json:"server_type"`
+				Image      string            `json:"image"`
+				Location   string            `json:"location"`
+				UserData   string            `json:"user_data"`
+				Labels     map[string]string `json:"labels"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("create body: %v", err)
+			}
+			if body.ServerType == "cx52" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(`{"error": {"code": "resource_unavailable", "message": "no cx52 left in nbg1"}}`))
+				return
+			}
+			if body.ServerType != "cx32" || body.Image != "ubuntu-22.04" || body.Location != "nbg1" ||
+				body.UserData != "#!/bin/bash" || len(body.Labels) != 2 || body.Labels["nuclei/scan-1"] != "" {
+				t.Errorf("create body = %+v", body)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"server": ` + testServer + `, "action": {"id": 1, "status": "running"}}`))
+		case "GET /servers":
+			if selector := r.URL.Query().Get("label_selector"); selector != "nuclei/scan-1" {
+				t.Errorf("label_selector = %q", selector)
+			}
+			w.Write([]byte(`{"servers": [` + testServer + `], "meta": {"pagination": {"page": 1, "next_page": null}}}`))
+		case "GET /servers/42":
+			w.Write([]byte(`{"server": ` + testServer + `}`))
+		case "DELETE /servers/42":
+			w.Write([]byte(`{"action": {"id": 2, "command": "delete_server", "status": "running"}}`))
+		case "GET /actions":
+			polls.Add(1)
+			w.Write([]byte(`{"actions": [{"id": 2, "command": "delete_server", "status": "success"}],
+				"meta": {"pagination": {"page": 1, "next_page": null}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "not_found", "message": "not found"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProviderServers(t *testing.T) {
+	var polls atomic.Int32
+	api := fakeAPI(t, &polls)
+	hcloud, err := New(Config{Token: "token", Endpoint: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A DigitalOcean region and image fall back to the configured ones
+	created, err := hcloud.CreateWorker(ctx, provider.CreateRequest{
+		Name:     "abcd1234-worker-0",
+		Region:   "nyc3",
+		Size:     "s-4vcpu-8gb",
+		Image:    "ubuntu-22-04-x64",
+		UserData: "#!/bin/bash",
+		Tags:     []string{"nuclei-worker", "scan-1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateWorker: %v", err)
+	}
+	if created.ID != "42" || created.Status != provider.StatusNew || created.IP != "203.0.113.7" ||
+		created.Region != "nbg1" || created.Size != "cx22" || created.PriceHourly != 0.0059 ||
+		!created.HasTag("scan-1") || len(created.Tags) != 2 || created.CreatedAt.IsZero() {
+		t.Errorf("created worker = %+v", created)
+	}
+
+	_, err = hcloud.CreateWorker(ctx, provider.CreateRequest{Name: "abcd1234-worker-1", Size: "cx52"})
+	if !errors.Is(err, provider.ErrNoCapacity) {
+		t.Errorf("CreateWorker without room = %v, want ErrNoCapacity", err)
+	}
+
+	workers, err := hcloud.ListWorkersByTag(ctx, "scan-1")
+	if err != nil || len(workers) != 1 || workers[0].ID != "42" {
+		t.Fatalf("ListWorkersByTag = %+v, %v", workers, err)
+	}
+	if found, err := hcloud.GetWorker(ctx, "42"); err != nil || found.Name != "abcd1234-worker-0" {
+		t.Errorf("GetWorker = %+v, %v", found, err)
+	}
+	for _, id := range []string{"7", "droplet-7"} {
+		if _, err := hcloud.GetWorker(ctx, id); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("GetWorker(%q) = %v, want ErrNotFound", id, err)
+		}
+		if err := hcloud.DestroyWorker(ctx, id); !errors.Is(err, provider.ErrNotFound) {
+			t.Errorf("DestroyWorker(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+
+	// Deleting waits for the delete action to finish
+	if err := hcloud.DestroyWorker(ctx, "42"); err != nil {
+		t.Fatalf("DestroyWorker: %v", err)
+	}
+	if polls.Load() == 0 {
+		t.Error("DestroyWorker returned without waiting for the delete action")
+	}
+}
@@ -0,0 +1,201 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serviceAccountDir holds the credentials of pods running in a cluster
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// apiClient is how requests reach the API server: its URL, a client trusting
+// its CA and presenting any client certificate, and the bearer token
+type apiClient struct {
+	server    string
+	http      *http.Client
+	token     string
+	tokenFile string // read on each request, service account tokens rotate
+	namespace string // of the kubeconfig context or service account
+}
+
+// bearer returns the token requests authenticate with, if any
+func (c *apiClient) bearer() (string, error) {
+	if c.tokenFile == "" {
+		return c.token, nil
+	}
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// inClusterClient authenticates as the pod's service account
+func inClusterClient() (*apiClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster, set a kubeconfig")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA certificate is invalid")
+	}
+	namespace, _ := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	return &apiClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		http:      newHTTPClient(&tls.Config{RootCAs: pool}),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// kubeconfig is the part of a kubeconfig file the provider reads
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  any    `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeconfigClient authenticates as the current context of a kubeconfig
+// file. Tokens and client certificates are supported, exec plugins are not.
+func kubeconfigClient(path string) (*apiClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Relative paths in the file are relative to it
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	client := &apiClient{}
+	var clusterName, userName string
+	for _, context := range config.Contexts {
+		if context.Name == config.CurrentContext {
+			clusterName, userName = context.Context.Cluster, context.Context.User
+			client.namespace = context.Context.Namespace
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("%s: current context %q not found", path, config.CurrentContext)
+	}
+
+	tlsConfig := &tls.Config{}
+	for _, cluster := range config.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		client.server = strings.TrimRight(cluster.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(resolve(cluster.Cluster.CertificateAuthority), cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cluster CA: %w", path, err)
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("%s: cluster CA certificate is invalid", path)
+			}
+		}
+	}
+	if client.server == "" {
+		return nil, fmt.Errorf("%s: cluster %q has no server", path, clusterName)
+	}
+
+	for _, user := range config.Users {
+		if user.Name != userName {
+			continue
+		}
+		if user.User.Exec != nil && user.User.Token == "" && user.User.TokenFile == "" {
+			return nil, fmt.Errorf("%s: user %q authenticates with an exec plugin, which is not supported; "+
+				"use a service account token", path, userName)
+		}
+		client.token = user.User.Token
+		client.tokenFile = resolve(user.User.TokenFile)
+		cert, err := fileOrData(resolve(user.User.ClientCertificate), user.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: client certificate: %w", path, err)
+		}
+		key, err := fileOrData(resolve(user.User.ClientKey), user.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: client key: %w", path, err)
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: client certificate: %w", path, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	client.http = newHTTPClient(tlsConfig)
+	return client, nil
+}
+
+// fileOrData reads a PEM file, or decodes its base64 inline form
+func fileOrData(file, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
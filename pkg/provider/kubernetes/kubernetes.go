@@ -0,0 +1,467 @@
+// Package kubernetes runs workers as Kubernetes Jobs, one per chunk, through
+// the Kubernetes API. It authenticates in-cluster as the pod's service
+// account, or with a kubeconfig file.
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	// labelPrefix namespaces the labels the orchestrator puts on Jobs, their
+	// pods and Secrets
+	labelPrefix = "nuclei/"
+	// sizeAnnotation records the droplet size a Job was created for
+	sizeAnnotation = "nuclei/size"
+	// bootstrapKey is the Secret key holding the worker's bootstrap script
+	bootstrapKey = "userdata.sh"
+	// bootstrapDir is where the Secret is mounted in the worker container
+	bootstrapDir = "/bootstrap"
+)
+
+// Config selects where Jobs run and what they request
+type Config struct {
+	// Kubeconfig is the path of a kubeconfig file whose current context is
+	// used. When empty the provider authenticates in-cluster.
+	Kubeconfig string
+	// Namespace defaults to the kubeconfig context's or the service
+	// account's, then to "default"
+	Namespace string
+	// Image is the worker image, which needs bash and apt-get. Defaults to
+	// ubuntu:22.04.
+	Image string
+	// CPURequest and MemoryRequest are quantities such as "2" and "4Gi".
+	// When empty they follow the droplet size, e.g. s-2vcpu-4gb.
+	CPURequest    string
+	MemoryRequest string
+	// CPULimit and MemoryLimit are unset when empty
+	CPULimit    string
+	MemoryLimit string
+}
+
+// Provider creates workers as Kubernetes Jobs
+type Provider struct {
+	config Config
+	api    *apiClient
+}
+
+var (
+	_ provider.Provider = (*Provider)(nil)
+	_ provider.Pricer   = (*Provider)(nil)
+)
+
+// New loads the credentials cfg selects and returns a provider for its
+// namespace
+func New(cfg Config) (*Provider, error) {
+	var api *apiClient
+	var err error
+	if cfg.Kubeconfig != "" {
+		api, err = kubeconfigClient(cfg.Kubeconfig)
+	} else {
+		api, err = inClusterClient()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = api.namespace
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = "default"
+	}
+	if cfg.Image == "" {
+		cfg.Image = "ubuntu:22.04"
+	}
+	return &Provider{config: cfg, api: api}, nil
+}
+
+// objectMeta is the metadata of an API object
+type objectMeta struct {
+	Name              string            `json:"name"`
+	UID               string            `json:"uid,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+}
+
+// job is the part of a batch/v1 Job the provider reads
+type job struct {
+	Metadata objectMeta `json:"metadata"`
+	Status   struct {
+		Active     int `json:"active"`
+		Succeeded  int `json:"succeeded"`
+		Failed     int `json:"failed"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// pod is the part of a Pod the provider reads
+type pod struct {
+	Metadata objectMeta `json:"metadata"`
+	Status   struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// CreateWorker creates a Job running the bootstrap script from a Secret
+// owned by the Job, so deleting the Job also deletes the Secret. The script
+// holds the worker's token and targets, hence a Secret over a ConfigMap.
+// Running out of the namespace's resource quota wraps provider.ErrNoCapacity.
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	labels := make(map[string]string, len(req.Tags))
+	for _, tag := range req.Tags {
+		labels[labelPrefix+tag] = ""
+	}
+	image := p.config.Image
+	if strings.Contains(req.Image, ":") {
+		image = req.Image
+	}
+	env := make([]map[string]string, 0, len(req.Env))
+	for name, value := range req.Env {
+		env = append(env, map[string]string{"name": name, "value": value})
+	}
+
+	spec := map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": objectMeta{
+			Name:        req.Name,
+			Labels:      labels,
+			Annotations: map[string]string{sizeAnnotation: req.Size},
+		},
+		"spec": map[string]any{
+			// Failed chunks are retried by the orchestrator, not the Job
+			"backoffLimit": 0,
+			"template": map[string]any{
+				"metadata": map[string]any{"labels": labels},
+				"spec": map[string]any{
+					"restartPolicy":                "Never",
+					"automountServiceAccountToken": false,
+					"containers": []map[string]any{{
+						"name":         "worker",
+						"image":        image,
+						"command":      []string{"/bin/bash", bootstrapDir + "/" + bootstrapKey},
+						"env":          env,
+						"resources":    p.resources(req.Size),
+						"volumeMounts": []map[string]any{{"name": "bootstrap", "mountPath": bootstrapDir, "readOnly": true}},
+					}},
+					"volumes": []map[string]any{{
+						"name":   "bootstrap",
+						"secret": map[string]any{"secretName": req.Name, "defaultMode": 0o500},
+					}},
+				},
+			},
+		},
+	}
+	var created job
+	if err := p.do(ctx, http.MethodPost, p.path("apis/batch/v1", "jobs", ""), spec, &created); err != nil {
+		return nil, err
+	}
+
+	// The pod waits for the Secret to be mounted
+	secret := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":   req.Name,
+			"labels": labels,
+			"ownerReferences": []map[string]any{{
+				"apiVersion": "batch/v1",
+				"kind":       "Job",
+				"name":       created.Metadata.Name,
+				"uid":        created.Metadata.UID,
+			}},
+		},
+		"stringData": map[string]string{bootstrapKey: req.UserData},
+	}
+	if err := p.do(ctx, http.MethodPost, p.path("api/v1", "secrets", ""), secret, nil); err != nil {
+		p.deleteJob(context.Background(), req.Name)
+		return nil, err
+	}
+	return p.worker(&created, nil), nil
+}
+
+// resources are the container's requests and limits for a droplet size
+func (p *Provider) resources(size string) map[string]map[string]string {
+	requests := map[string]string{}
+	if cpu, memory, ok := sizeResources(size); ok {
+		requests["cpu"], requests["memory"] = cpu, memory
+	}
+	if p.config.CPURequest != "" {
+		requests["cpu"] = p.config.CPURequest
+	}
+	if p.config.MemoryRequest != "" {
+		requests["memory"] = p.config.MemoryRequest
+	}
+	limits := map[string]string{}
+	if p.config.CPULimit != "" {
+		limits["cpu"] = p.config.CPULimit
+	}
+	if p.config.MemoryLimit != "" {
+		limits["memory"] = p.config.MemoryLimit
+	}
+	return map[string]map[string]string{"requests": requests, "limits": limits}
+}
+
+// sizeSlug matches droplet sizes such as s-2vcpu-4gb
+var sizeSlug = regexp.MustCompile(`^[a-z]+-(\d+)vcpu-(\d+)gb`)
+
+// sizeResources reads the CPU and memory of a droplet size slug
+func sizeResources(size string) (cpu, memory string, ok bool) {
+	match := sizeSlug.FindStringSubmatch(size)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2] + "Gi", true
+}
+
+// GetWorker returns a Job, with the IP of its pod once it has one
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	var found job
+	if err := p.do(ctx, http.MethodGet, p.path("apis/batch/v1", "jobs", id), nil, &found); err != nil {
+		return nil, err
+	}
+	pods, err := p.listPods(ctx, "job-name="+id)
+	if err != nil {
+		return nil, err
+	}
+	return p.worker(&found, pods[id]), nil
+}
+
+// DestroyWorker deletes a Job with its pod and Secret
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	if err := p.deleteJob(ctx, id); err != nil {
+		return err
+	}
+	// Owned by the Job, but deleted now rather than by the garbage collector
+	err := p.do(ctx, http.MethodDelete, p.path("api/v1", "secrets", id), nil, nil)
+	if err != nil && !errors.Is(err, provider.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// deleteJob deletes a Job, and its pods in the background
+func (p *Provider) deleteJob(ctx context.Context, name string) error {
+	return p.do(ctx, http.MethodDelete, p.path("apis/batch/v1", "jobs", name)+"?propagationPolicy=Background", nil, nil)
+}
+
+// ListWorkersByTag returns the Jobs labelled with tag, across pages
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	selector := labelPrefix + tag
+	pods, err := p.listPods(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	var workers []provider.Worker
+	for next := ""; ; {
+		query := url.Values{"labelSelector": {selector}, "limit": {"100"}}
+		if next != "" {
+			query.Set("continue", next)
+		}
+		var list struct {
+			Items    []job `json:"items"`
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+		}
+		if err := p.do(ctx, http.MethodGet, p.path("apis/batch/v1", "jobs", "")+"?"+query.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			workers = append(workers, *p.worker(&list.Items[i], pods[list.Items[i].Metadata.Name]))
+		}
+		if next = list.Metadata.Continue; next == "" {
+			return workers, nil
+		}
+	}
+}
+
+// listPods returns the pods matching a label selector by the Job they belong
+// to, preferring running ones when a Job has several
+func (p *Provider) listPods(ctx context.Context, selector string) (map[string]*pod, error) {
+	pods := make(map[string]*pod)
+	for next := ""; ; {
+		query := url.Values{"labelSelector": {selector}, "limit": {"500"}}
+		if next != "" {
+			query.Set("continue", next)
+		}
+		var list struct {
+			Items    []pod `json:"items"`
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+		}
+		if err := p.do(ctx, http.MethodGet, p.path("api/v1", "pods", "")+"?"+query.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			jobName := item.Metadata.Labels["job-name"]
+			if current := pods[jobName]; current == nil || current.Status.Phase != "Running" {
+				pods[jobName] = item
+			}
+		}
+		if next = list.Metadata.Continue; next == "" {
+			return pods, nil
+		}
+	}
+}
+
+// Regions is the namespace, once listing its Jobs shows the API is reachable
+// and the credentials are allowed to
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	if err := p.do(ctx, http.MethodGet, p.path("apis/batch/v1", "jobs", "")+"?limit=1", nil, nil); err != nil {
+		return nil, err
+	}
+	return []provider.Region{{
+		Slug:      p.config.Namespace,
+		Name:      "Kubernetes namespace " + p.config.Namespace,
+		Available: true,
+	}}, nil
+}
+
+// Sizes lists nothing: Jobs take any size, as resource requests
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	return nil, nil
+}
+
+// SizePrice is zero: Jobs run on the cluster's existing nodes and are not
+// billed per worker
+func (p *Provider) SizePrice(ctx context.Context, size string) (float64, error) {
+	return 0, nil
+}
+
+// Currency is US dollars, though prices are always zero
+func (p *Provider) Currency() string {
+	return "USD"
+}
+
+// worker converts a Job and its pod, if it has one yet
+func (p *Provider) worker(j *job, running *pod) *provider.Worker {
+	w := &provider.Worker{
+		ID:     j.Metadata.Name,
+		Name:   j.Metadata.Name,
+		Region: p.config.Namespace,
+		Size:   j.Metadata.Annotations[sizeAnnotation],
+		Status: provider.StatusNew,
+	}
+	for _, condition := range j.Status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+		switch condition.Type {
+		case "Failed":
+			w.Status = provider.StatusFailed
+		case "Complete":
+			w.Status = provider.StatusOff
+		}
+	}
+	if running != nil && w.Status == provider.StatusNew {
+		switch running.Status.Phase {
+		case "Running":
+			w.Status = provider.StatusActive
+		case "Failed":
+			w.Status = provider.StatusFailed
+		case "Succeeded":
+			w.Status = provider.StatusOff
+		}
+		w.IP = running.Status.PodIP
+	}
+	for label := range j.Metadata.Labels {
+		if tag, ours := strings.CutPrefix(label, labelPrefix); ours {
+			w.Tags = append(w.Tags, tag)
+		}
+	}
+	if created, err := time.Parse(time.RFC3339, j.Metadata.CreationTimestamp); err == nil {
+		w.CreatedAt = created
+	}
+	return w
+}
+
+// path is the URL path of a namespaced resource collection, or of one object
+// in it when name is set
+func (p *Provider) path(group, resource, name string) string {
+	path := "/" + group + "/namespaces/" + url.PathEscape(p.config.Namespace) + "/" + resource
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+// do makes an API request with an optional JSON body and decodes the response
+// into out, unless out is nil
+func (p *Provider) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.api.server+path, reader)
+	if err != nil {
+		return err
+	}
+	token, err := p.api.bearer()
+	if err != nil {
+		return fmt.Errorf("reading the service account token: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.api.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Errors come back as a Status object
+		var status struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &status)
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return provider.ErrNotFound
+		case resp.StatusCode == http.StatusForbidden && strings.Contains(status.Message, "exceeded quota"):
+			return fmt.Errorf("%w: %s", provider.ErrNoCapacity, status.Message)
+		case status.Message != "":
+			return fmt.Errorf("%s %s failed: %s: %s", method, strings.SplitN(path, "?", 2)[0], status.Reason, status.Message)
+		}
+		return fmt.Errorf("%s %s returned %d", method, strings.SplitN(path, "?", 2)[0], resp.StatusCode)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,173 @@
+// Package provider describes the compute backends workers run on. The
+// orchestrator creates, watches and destroys workers only through the
+// Provider interface; implementations live in subpackages.
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Worker statuses. Backends map their own states onto these.
+const (
+	StatusNew    = "new"    // being created or booting
+	StatusActive = "active" // running
+	StatusOff    = "off"    // stopped or being destroyed
+	StatusFailed = "failed" // gave up, will not become active again
+)
+
+// ErrNotFound is returned for workers the provider does not have
+var ErrNotFound = errors.New("worker not found")
+
+// ErrNoCapacity is wrapped by errors from CreateWorker when the region has
+// no room for the worker, so it can be retried in another
+var ErrNoCapacity = errors.New("region has no capacity")
+
+// CreateRequest describes a worker to create
+type CreateRequest struct {
+	Name     string
+	Region   string
+	Size     string
+	Image    string
+	UserData string // cloud-init script run on first boot
+	Tags     []string
+	// Env is passed to the worker's environment by providers that start it
+	// directly, such as containers, rather than through cloud-init
+	Env map[string]string
+}
+
+// Worker is a machine running a scan worker
+type Worker struct {
+	ID          string // the provider's ID
+	Name        string
+	Region      string
+	Size        string
+	Status      string
+	IP          string // public IPv4, empty until assigned
+	IPv6        string // public IPv6, empty when the worker has none
+	Tags        []string
+	CreatedAt   time.Time
+	PriceHourly float64 // zero when the provider does not report it
+}
+
+// HasTag reports whether the worker carries tag
+func (w *Worker) HasTag(tag string) bool {
+	for _, candidate := range w.Tags {
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Region is a location workers can be created in
+type Region struct {
+	Slug      string
+	Name      string
+	Available bool
+	Sizes     []string // size slugs offered in the region
+}
+
+// Size is a machine size workers can be created with
+type Size struct {
+	Slug        string
+	MemoryMB    int
+	VCPUs       int
+	PriceHourly float64
+	Available   bool
+	Regions     []string // regions offering the size
+}
+
+// Provider creates and destroys workers on a compute backend
+type Provider interface {
+	// CreateWorker starts creating a worker and returns it, usually before
+	// it is active or has an IP
+	CreateWorker(ctx context.Context, req CreateRequest) (*Worker, error)
+	// GetWorker returns a worker, or ErrNotFound
+	GetWorker(ctx context.Context, id string) (*Worker, error)
+	// DestroyWorker destroys a worker, or returns ErrNotFound
+	DestroyWorker(ctx context.Context, id string) error
+	// ListWorkersByTag returns every worker carrying tag
+	ListWorkersByTag(ctx context.Context, tag string) ([]Worker, error)
+	// Regions lists the regions workers can be created in
+	Regions(ctx context.Context) ([]Region, error)
+	// Sizes lists the sizes workers can be created with
+	Sizes(ctx context.Context) ([]Size, error)
+}
+
+// Interruptible is implemented by providers that may reclaim running workers
+// at short notice, such as spot instances
+type Interruptible interface {
+	// InterruptionWatch is a shell command workers run in the background that
+	// exits once the worker is about to be reclaimed, or empty when workers
+	// are never reclaimed
+	InterruptionWatch() string
+}
+
+// Pricer is implemented by providers whose workers are not priced like
+// DigitalOcean droplets of the same size
+type Pricer interface {
+	// SizePrice is the hourly price of a worker of a droplet size, zero when
+	// unknown
+	SizePrice(ctx context.Context, size string) (float64, error)
+	// Currency is the ISO 4217 code of the prices, e.g. EUR
+	Currency() string
+}
+
+// ProcessHost is implemented by providers that run workers as processes on
+// machines they do not own, rather than on machines created for them
+type ProcessHost interface {
+	// WorkDir is the directory the worker named name keeps its files in. It
+	// is removed when the worker is destroyed.
+	WorkDir(name string) string
+}
+
+// Firewaller is implemented by providers that can firewall workers by tag
+type Firewaller interface {
+	// EnsureFirewall creates the firewall named name, or reuses it, so that
+	// workers carrying tag accept inbound connections only on SSH from
+	// sshSources, none when empty, while connecting out freely
+	EnsureFirewall(ctx context.Context, name, tag string, sshSources []string) error
+	// DeleteFirewall deletes the firewall named name; a missing one is not
+	// an error
+	DeleteFirewall(ctx context.Context, name string) error
+}
+
+// ProjectAssigner is implemented by providers that file workers under a
+// project for billing
+type ProjectAssigner interface {
+	// Project is the project workers are assigned to, empty when none is
+	// configured
+	Project() string
+	// ProjectWorkers returns the IDs of the workers assigned to the project
+	ProjectWorkers(ctx context.Context) (map[string]bool, error)
+}
+
+// RateLimit is how much of its API request budget a provider has left
+type RateLimit struct {
+	Limit     int       // requests allowed per window, zero until reported
+	Remaining int       // requests left in the window
+	Reset     time.Time // when the window's budget is restored
+	Throttled int64     // requests held back to stay within the budget
+	Retried   int64     // requests sent again after a 429 or server error
+	UpdatedAt time.Time // when the API last reported the budget
+}
+
+// RateLimited is implemented by providers that pace their API requests to
+// a budget shared by every client of the account
+type RateLimited interface {
+	// RateLimit reports the budget as the API last reported it
+	RateLimit() RateLimit
+}
+
+// SelfChecker is implemented by providers that can check, before any worker
+// is created, that their credentials and settings will work
+type SelfChecker interface {
+	// CheckAccess checks the credentials can read the account and create
+	// tag, and whatever else creating workers needs
+	CheckAccess(ctx context.Context, tag string) error
+	// CheckSlugs checks workers can be created in region with size and
+	// image
+	CheckSlugs(ctx context.Context, region, size, image string) error
+}
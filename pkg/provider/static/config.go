@@ -0,0 +1,135 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the pool of hosts workers run on, as read from a YAML file:
+//
+//	known_hosts: /etc/nuclei/known_hosts
+//	hosts:
+//	  - name: jump-1
+//	    address: 203.0.113.10
+//	    user: root
+//	    key_file: /etc/nuclei/id_ed25519
+//	  - address: 203.0.113.11:2222
+//	    user: scanner
+//	    password: secret
+//	    sudo: true
+type Config struct {
+	Hosts []Host `yaml:"hosts"`
+	// KnownHosts is an OpenSSH known_hosts file host keys are checked
+	// against. It is required unless InsecureIgnoreHostKey is set.
+	KnownHosts            string `yaml:"known_hosts"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+	// WorkDir is the directory on the hosts workers keep their files in,
+	// one subdirectory each. Defaults to /var/lib/nuclei-worker.
+	WorkDir string `yaml:"work_dir"`
+}
+
+// Host is a machine in the pool and how to log in to it
+type Host struct {
+	Name          string `yaml:"name"`    // defaults to the address
+	Address       string `yaml:"address"` // host or host:port, port 22 by default
+	User          string `yaml:"user"`
+	KeyFile       string `yaml:"key_file"` // private key, OpenSSH or PEM
+	KeyPassphrase string `yaml:"key_passphrase"`
+	Password      string `yaml:"password"`
+	// Sudo runs commands through passwordless sudo, for users other than
+	// root; the bootstrap installs packages
+	Sudo bool `yaml:"sudo"`
+}
+
+// LoadConfig reads a host pool file
+func LoadConfig(file string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", file, err)
+	}
+	return config, nil
+}
+
+// clientConfigs checks the hosts of cfg and builds the SSH client config of
+// each
+func clientConfigs(cfg *Config) ([]*ssh.ClientConfig, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, errors.New("the host pool is empty")
+	}
+	var hostKeys ssh.HostKeyCallback
+	switch {
+	case cfg.KnownHosts != "":
+		callback, err := knownhosts.New(cfg.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("known_hosts: %w", err)
+		}
+		hostKeys = callback
+	case cfg.InsecureIgnoreHostKey:
+		hostKeys = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("known_hosts is required unless insecure_ignore_host_key is set")
+	}
+
+	configs := make([]*ssh.ClientConfig, len(cfg.Hosts))
+	names := make(map[string]bool, len(cfg.Hosts))
+	for i := range cfg.Hosts {
+		host := &cfg.Hosts[i]
+		if host.Address == "" {
+			return nil, fmt.Errorf("host %d has no address", i+1)
+		}
+		if _, _, err := net.SplitHostPort(host.Address); err != nil {
+			host.Address = net.JoinHostPort(host.Address, "22")
+		}
+		if host.Name == "" {
+			host.Name = host.Address
+		}
+		if names[host.Name] {
+			return nil, fmt.Errorf("host %s is listed twice", host.Name)
+		}
+		names[host.Name] = true
+		if host.User == "" {
+			return nil, fmt.Errorf("host %s has no user", host.Name)
+		}
+
+		var auth []ssh.AuthMethod
+		if host.KeyFile != "" {
+			key, err := os.ReadFile(host.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %w", host.Name, err)
+			}
+			var signer ssh.Signer
+			if host.KeyPassphrase != "" {
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(host.KeyPassphrase))
+			} else {
+				signer, err = ssh.ParsePrivateKey(key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %s: %w", host.Name, host.KeyFile, err)
+			}
+			auth = append(auth, ssh.PublicKeys(signer))
+		}
+		if host.Password != "" {
+			auth = append(auth, ssh.Password(host.Password))
+		}
+		if len(auth) == 0 {
+			return nil, fmt.Errorf("host %s has neither key_file nor password", host.Name)
+		}
+		configs[i] = &ssh.ClientConfig{
+			User:            host.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         dialTimeout,
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,375 @@
+// Package static runs workers on a fixed pool of existing hosts, such as a
+// client's approved jump hosts, over SSH. Each host runs one worker at a
+// time: the bootstrap script is uploaded and run under nohup in a working
+// directory of its own, and the remote PID is tracked for status and
+// cancellation.
+package static
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"nuclei-distributed/pkg/provider"
+)
+
+const (
+	// Region is the only region of the pool
+	Region = "static"
+	// dialTimeout bounds connecting and logging in to a host
+	dialTimeout = 15 * time.Second
+	// defaultWorkDir holds the workers' directories on the hosts
+	defaultWorkDir = "/var/lib/nuclei-worker"
+)
+
+// errHostBusy is returned when a host free in the pool still runs a worker,
+// such as one started before the server restarted
+var errHostBusy = errors.New("host is running another worker")
+
+// Provider hands out the hosts of a pool to workers
+type Provider struct {
+	config  Config
+	clients []*ssh.ClientConfig // by host
+
+	mutex    sync.Mutex
+	assigned []*assignment // by host, nil while the host is free
+}
+
+// assignment is the worker a host runs, and the PID of its process once
+// started
+type assignment struct {
+	worker provider.Worker
+	pid    int
+}
+
+var (
+	_ provider.Provider    = (*Provider)(nil)
+	_ provider.ProcessHost = (*Provider)(nil)
+	_ provider.Pricer      = (*Provider)(nil)
+)
+
+// New checks the pool and returns a provider for it. Hosts are not contacted
+// until workers are created on them.
+func New(cfg Config) (*Provider, error) {
+	clients, err := clientConfigs(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.WorkDir = strings.TrimRight(cfg.WorkDir, "/")
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = defaultWorkDir
+	}
+	return &Provider{
+		config:   cfg,
+		clients:  clients,
+		assigned: make([]*assignment, len(cfg.Hosts)),
+	}, nil
+}
+
+// WorkDir is the directory a worker keeps its files in on its host
+func (p *Provider) WorkDir(name string) string {
+	return p.config.WorkDir + "/" + name
+}
+
+// CreateWorker books the first free host, uploads the bootstrap script to
+// the worker's directory and starts it. Hosts that cannot be reached or turn
+// out to be running a worker already are skipped. When every host is busy
+// the error wraps provider.ErrNoCapacity.
+func (p *Provider) CreateWorker(ctx context.Context, req provider.CreateRequest) (*provider.Worker, error) {
+	tried := make(map[int]bool)
+	var lastErr error
+	for {
+		index := p.book(req, tried)
+		if index < 0 {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, fmt.Errorf("%w: all %d hosts are busy", provider.ErrNoCapacity, len(p.config.Hosts))
+		}
+		tried[index] = true
+
+		pid, err := p.start(ctx, index, req)
+		if err != nil {
+			p.free(index, req.Name)
+			if !errors.Is(err, errHostBusy) {
+				lastErr = fmt.Errorf("host %s: %w", p.config.Hosts[index].Name, err)
+			}
+			continue
+		}
+		p.mutex.Lock()
+		booked := p.assigned[index]
+		booked.pid = pid
+		booked.worker.Status = provider.StatusActive
+		created := booked.worker
+		p.mutex.Unlock()
+		return &created, nil
+	}
+}
+
+// book marks the first free host not tried as running the worker and
+// returns it, or -1
+func (p *Provider) book(req provider.CreateRequest, tried map[int]bool) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, assigned := range p.assigned {
+		if assigned != nil || tried[i] {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(p.config.Hosts[i].Address)
+		p.assigned[i] = &assignment{worker: provider.Worker{
+			ID:        req.Name,
+			Name:      req.Name,
+			Region:    Region,
+			Size:      req.Size,
+			Status:    provider.StatusNew,
+			IP:        host,
+			Tags:      append([]string(nil), req.Tags...),
+			CreatedAt: time.Now(),
+		}}
+		return i
+	}
+	return -1
+}
+
+// free returns a host to the pool, unless it was booked again meanwhile
+func (p *Provider) free(index int, id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.assigned[index] != nil && p.assigned[index].worker.ID == id {
+		p.assigned[index] = nil
+	}
+}
+
+// find returns the host running a worker and the worker's PID, or -1
+func (p *Provider) find(id string) (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, assigned := range p.assigned {
+		if assigned != nil && assigned.worker.ID == id {
+			return i, assigned.pid
+		}
+	}
+	return -1, 0
+}
+
+// aliveFunc defines alive, which reports whether a process runs. Exited
+// processes the host has not reaped yet do not count.
+const aliveFunc = `alive() {
+    state=$(sed -n 's/^State:[[:space:]]*\(.\).*/\1/p' "/proc/$1/status" 2>/dev/null)
+    [ -n "$state" ] && [ "$state" != Z ]
+}
+`
+
+// startScript refuses hosts still running a worker, writes the bootstrap
+// script from stdin and starts it in a session of its own, so the whole
+// process group can be killed, then prints its PID. The wrapper records the
+// script's exit code.
+const startScript = aliveFunc + `set -e
+for pidfile in "$BASE"/*/pid; do
+    if [ -s "$pidfile" ] && alive "$(cat "$pidfile")"; then
+        exit 3
+    fi
+done
+mkdir -p "$DIR"
+chmod 700 "$DIR"
+cd "$DIR"
+cat > bootstrap.sh
+setsid nohup bash -c 'echo $$ > pid; bash bootstrap.sh; echo $? > exit' > bootstrap.log 2>&1 < /dev/null &
+for i in $(seq 50); do
+    [ -s pid ] && break
+    sleep 0.1
+done
+cat pid
+`
+
+// start uploads and starts a worker's bootstrap script, returning its PID
+func (p *Provider) start(ctx context.Context, index int, req provider.CreateRequest) (int, error) {
+	env := map[string]string{"BASE": p.config.WorkDir, "DIR": p.WorkDir(req.Name)}
+	output, err := p.run(ctx, index, env, startScript, strings.NewReader(req.UserData))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 3 {
+		return 0, errHostBusy
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil {
+		return 0, fmt.Errorf("the bootstrap script did not start: %q", output)
+	}
+	return pid, nil
+}
+
+// statusScript prints running while the worker's process lives, then its
+// exit code, or gone when its directory is
+const statusScript = aliveFunc + `if alive "$PID"; then
+    echo running
+elif [ -s "$DIR/exit" ]; then
+    echo "exited $(cat "$DIR/exit")"
+else
+    echo gone
+fi
+`
+
+// GetWorker returns a worker with its process's status on the host: active
+// while it runs, off once it exited cleanly and failed otherwise
+func (p *Provider) GetWorker(ctx context.Context, id string) (*provider.Worker, error) {
+	index, pid := p.find(id)
+	if index < 0 {
+		return nil, provider.ErrNotFound
+	}
+	env := map[string]string{"DIR": p.WorkDir(id), "PID": strconv.Itoa(pid)}
+	output, err := p.run(ctx, index, env, statusScript, nil)
+	if err != nil {
+		return nil, fmt.Errorf("host %s: %w", p.config.Hosts[index].Name, err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	booked := p.assigned[index]
+	if booked == nil || booked.worker.ID != id {
+		return nil, provider.ErrNotFound
+	}
+	worker := &booked.worker
+	switch strings.TrimSpace(output) {
+	case "running":
+		worker.Status = provider.StatusActive
+	case "exited 0":
+		worker.Status = provider.StatusOff
+	default:
+		worker.Status = provider.StatusFailed
+	}
+	found := *worker
+	return &found, nil
+}
+
+// stopScript kills the worker's process group and removes its directory
+const stopScript = aliveFunc + `if alive "$PID"; then
+    kill -TERM -- "-$PID" 2>/dev/null
+    sleep 2
+    kill -KILL -- "-$PID" 2>/dev/null
+fi
+rm -rf "$DIR"
+`
+
+// DestroyWorker stops a worker and frees its host. The host is freed even
+// when it cannot be reached; the next worker booked on it checks it is idle.
+func (p *Provider) DestroyWorker(ctx context.Context, id string) error {
+	index, pid := p.find(id)
+	if index < 0 {
+		return provider.ErrNotFound
+	}
+	defer p.free(index, id)
+	env := map[string]string{"DIR": p.WorkDir(id), "PID": strconv.Itoa(pid)}
+	if _, err := p.run(ctx, index, env, stopScript, nil); err != nil {
+		return fmt.Errorf("host %s: %w", p.config.Hosts[index].Name, err)
+	}
+	return nil
+}
+
+// ListWorkersByTag returns the workers carrying tag with the status they
+// were last seen in, without contacting the hosts
+func (p *Provider) ListWorkersByTag(ctx context.Context, tag string) ([]provider.Worker, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var workers []provider.Worker
+	for _, assigned := range p.assigned {
+		if assigned != nil && assigned.worker.HasTag(tag) {
+			workers = append(workers, assigned.worker)
+		}
+	}
+	return workers, nil
+}
+
+// Regions is the pool, available while a host is free
+func (p *Provider) Regions(ctx context.Context) ([]provider.Region, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	free := 0
+	for _, assigned := range p.assigned {
+		if assigned == nil {
+			free++
+		}
+	}
+	return []provider.Region{{
+		Slug:      Region,
+		Name:      fmt.Sprintf("Host pool, %d of %d free", free, len(p.assigned)),
+		Available: free > 0,
+	}}, nil
+}
+
+// Sizes lists nothing: hosts are the size they are
+func (p *Provider) Sizes(ctx context.Context) ([]provider.Size, error) {
+	return nil, nil
+}
+
+// SizePrice is zero: the hosts are paid for whether they scan or not
+func (p *Provider) SizePrice(ctx context.Context, size string) (float64, error) {
+	return 0, nil
+}
+
+// Currency is US dollars, though prices are always zero
+func (p *Provider) Currency() string {
+	return "USD"
+}
+
+// run runs a bash script on a host with the variables of env set, through
+// sudo when the host says so, and returns its output
+func (p *Provider) run(ctx context.Context, index int, env map[string]string, script string, stdin io.Reader) (string, error) {
+	host := p.config.Hosts[index]
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host.Address)
+	if err != nil {
+		return "", err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, host.Address, p.clients[index])
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+	// Closing the client ends a command the context gave up on
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var vars strings.Builder
+	for name, value := range env {
+		vars.WriteString(name + "=" + quote(value) + "\n")
+	}
+	command := "bash -c " + quote(vars.String()+script)
+	if host.Sudo {
+		command = "sudo -n " + command
+	}
+	var stdout, stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%w: %s", err, message)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// quote quotes a value for the shell
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,744 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// diskBackend keeps results in a JSONL file per scan and each scan record in
+// a JSON file, for deployments whose Redis does not persist. Byte offsets and
+// the severity index are held in memory and rebuilt from the results file on
+// first access after a restart.
+type diskBackend struct {
+	dir   string
+	mutex sync.Mutex
+	scans map[string]*diskScan
+}
+
+type diskScan struct {
+	offsets    []int64 // start of each result line
+	size       int64
+	severities map[string][]int64
+	ids        map[string]int64 // result ID to position
+	hosts      map[string]*diskHost
+}
+
+// diskHost is the host index entry of one host
+type diskHost struct {
+	summary   types.HostSummary
+	positions []int64
+}
+
+// index adds the result at position to the scan's in-memory indexes
+func (scan *diskScan) index(position int64, result *types.ScanResult) {
+	severity := IndexedSeverity(result.Severity)
+	scan.severities[severity] = append(scan.severities[severity], position)
+	if result.ID != "" {
+		scan.ids[result.ID] = position
+	}
+
+	host, exists := scan.hosts[result.Host]
+	if !exists {
+		host = &diskHost{}
+		scan.hosts[result.Host] = host
+	}
+	host.positions = append(host.positions, position)
+	addToSummary(&host.summary, result)
+}
+
+func newDiskBackend(dir string) (*diskBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &diskBackend{dir: dir, scans: make(map[string]*diskScan)}, nil
+}
+
+func (s *diskBackend) resultsPath(scanID string) string {
+	return filepath.Join(s.dir, filepath.Base(scanID)+".results.jsonl")
+}
+
+func (s *diskBackend) duplicatesPath(scanID string) string {
+	return filepath.Join(s.dir, filepath.Base(scanID)+".duplicates.jsonl")
+}
+
+func (s *diskBackend) recordPath(scanID string) string {
+	return filepath.Join(s.dir, filepath.Base(scanID)+".scan.json")
+}
+
+func (s *diskBackend) triagePath(scanID string) string {
+	return filepath.Join(s.dir, filepath.Base(scanID)+".triage.json")
+}
+
+func (s *diskBackend) ticketsPath(scanID string) string {
+	return filepath.Join(s.dir, filepath.Base(scanID)+".tickets.json")
+}
+
+// writeFileAtomic writes through a temporary file so a crash never leaves a
+// half-written file behind
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *diskBackend) SaveRecord(record types.ScanRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.recordPath(record.ID), data)
+}
+
+func (s *diskBackend) Record(scanID string) (*types.ScanRecord, error) {
+	data, err := os.ReadFile(s.recordPath(scanID))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record types.ScanRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *diskBackend) Records() ([]types.ScanRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.scan.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]types.ScanRecord, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var record types.ScanRecord
+		if json.Unmarshal(data, &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// load returns the scan's index, rebuilding it from disk when needed. Callers
+// must hold s.mutex.
+func (s *diskBackend) load(scanID string) (*diskScan, error) {
+	if scan, exists := s.scans[scanID]; exists {
+		return scan, nil
+	}
+
+	scan := &diskScan{
+		severities: make(map[string][]int64),
+		ids:        make(map[string]int64),
+		hosts:      make(map[string]*diskHost),
+	}
+	file, err := os.Open(s.resultsPath(scanID))
+	if os.IsNotExist(err) {
+		s.scans[scanID] = scan
+		return scan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var result types.ScanResult
+			if json.Unmarshal(line, &result) == nil {
+				scan.index(int64(len(scan.offsets)), &result)
+			}
+			scan.offsets = append(scan.offsets, scan.size)
+			scan.size += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.scans[scanID] = scan
+	return scan, nil
+}
+
+func (s *diskBackend) Append(scanID string, result types.ScanResult) (int64, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := appendLine(s.resultsPath(scanID), data); err != nil {
+		return 0, err
+	}
+
+	position := int64(len(scan.offsets))
+	scan.offsets = append(scan.offsets, scan.size)
+	scan.size += int64(len(data))
+	scan.index(position, &result)
+	return position, nil
+}
+
+func (s *diskBackend) AppendBatch(scanID string, results []types.ScanResult) (int64, error) {
+	// One write, so a failure leaves no partial batch behind
+	var data []byte
+	lengths := make([]int64, len(results))
+	for i := range results {
+		line, err := json.Marshal(results[i])
+		if err != nil {
+			return 0, err
+		}
+		lengths[i] = int64(len(line) + 1)
+		data = append(append(data, line...), '\n')
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := appendLine(s.resultsPath(scanID), data); err != nil {
+		return 0, err
+	}
+
+	first := int64(len(scan.offsets))
+	for i := range results {
+		scan.offsets = append(scan.offsets, scan.size)
+		scan.size += lengths[i]
+		scan.index(first+int64(i), &results[i])
+	}
+	return first, nil
+}
+
+func (s *diskBackend) AppendDuplicate(scanID string, result types.ScanResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return appendLine(s.duplicatesPath(scanID), append(data, '\n'))
+}
+
+func appendLine(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (s *diskBackend) Count(scanID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(scan.offsets)), nil
+}
+
+func (s *diskBackend) Range(scanID string, start, end int64) ([]types.ScanResult, error) {
+	s.mutex.Lock()
+	scan, err := s.load(scanID)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	if end > int64(len(scan.offsets)) {
+		end = int64(len(scan.offsets))
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end <= start {
+		s.mutex.Unlock()
+		return nil, nil
+	}
+	from := scan.offsets[start]
+	to := scan.size
+	if end < int64(len(scan.offsets)) {
+		to = scan.offsets[end]
+	}
+	s.mutex.Unlock()
+
+	file, err := os.Open(s.resultsPath(scanID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readResults(io.NewSectionReader(file, from, to-from), start+1)
+}
+
+func (s *diskBackend) Get(scanID string, positions []int64) ([]types.ScanResult, error) {
+	s.mutex.Lock()
+	scan, err := s.load(scanID)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	type span struct{ position, from, to int64 }
+	spans := make([]span, 0, len(positions))
+	for _, position := range positions {
+		if position < 0 || position >= int64(len(scan.offsets)) {
+			continue
+		}
+		to := scan.size
+		if position+1 < int64(len(scan.offsets)) {
+			to = scan.offsets[position+1]
+		}
+		spans = append(spans, span{position, scan.offsets[position], to})
+	}
+	s.mutex.Unlock()
+
+	file, err := os.Open(s.resultsPath(scanID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	results := make([]types.ScanResult, 0, len(spans))
+	for _, sp := range spans {
+		line := make([]byte, sp.to-sp.from)
+		if _, err := file.ReadAt(line, sp.from); err != nil {
+			return nil, err
+		}
+		var result types.ScanResult
+		if json.Unmarshal(line, &result) == nil {
+			result.Seq = sp.position + 1
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (s *diskBackend) SeverityPositions(scanID, severity string) ([]int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int64(nil), scan.severities[IndexedSeverity(severity)]...), nil
+}
+
+func (s *diskBackend) Hosts(scanID string) ([]types.HostSummary, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]types.HostSummary, 0, len(scan.hosts))
+	for _, host := range scan.hosts {
+		summary := host.summary
+		summary.SeverityCounts = make(map[string]int, len(host.summary.SeverityCounts))
+		for severity, count := range host.summary.SeverityCounts {
+			summary.SeverityCounts[severity] = count
+		}
+		summary.Templates = append([]string(nil), host.summary.Templates...)
+		hosts = append(hosts, summary)
+	}
+	return hosts, nil
+}
+
+func (s *diskBackend) HostPositions(scanID, host string) ([]int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return nil, err
+	}
+	if indexed, exists := scan.hosts[host]; exists {
+		return append([]int64(nil), indexed.positions...), nil
+	}
+	return nil, nil
+}
+
+func (s *diskBackend) Position(scanID, resultID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scan, err := s.load(scanID)
+	if err != nil {
+		return 0, err
+	}
+	position, exists := scan.ids[resultID]
+	if !exists {
+		return 0, ErrResultNotFound
+	}
+	return position, nil
+}
+
+func (s *diskBackend) Triage(scanID string) (map[string]types.Triage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.readTriage(scanID)
+}
+
+// readTriage loads a scan's verdicts. Callers must hold s.mutex.
+func (s *diskBackend) readTriage(scanID string) (map[string]types.Triage, error) {
+	verdicts := make(map[string]types.Triage)
+	data, err := os.ReadFile(s.triagePath(scanID))
+	if os.IsNotExist(err) {
+		return verdicts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &verdicts); err != nil {
+		return nil, err
+	}
+	return verdicts, nil
+}
+
+func (s *diskBackend) SaveTriage(scanID, resultID string, triage types.Triage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	verdicts, err := s.readTriage(scanID)
+	if err != nil {
+		return err
+	}
+	verdicts[resultID] = triage
+
+	data, err := json.Marshal(verdicts)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.triagePath(scanID), data)
+}
+
+func (s *diskBackend) Tickets(scanID string) (map[string]types.Ticket, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.readTickets(scanID)
+}
+
+// readTickets loads the issues linked to a scan's results. Callers must hold
+// s.mutex.
+func (s *diskBackend) readTickets(scanID string) (map[string]types.Ticket, error) {
+	tickets := make(map[string]types.Ticket)
+	data, err := os.ReadFile(s.ticketsPath(scanID))
+	if os.IsNotExist(err) {
+		return tickets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &tickets); err != nil {
+		return nil, err
+	}
+	return tickets, nil
+}
+
+func (s *diskBackend) SaveTicket(scanID, resultID string, ticket types.Ticket) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tickets, err := s.readTickets(scanID)
+	if err != nil {
+		return err
+	}
+	tickets[resultID] = ticket
+
+	data, err := json.Marshal(tickets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.ticketsPath(scanID), data)
+}
+
+func (s *diskBackend) Duplicates(scanID string) ([]types.ScanResult, error) {
+	file, err := os.Open(s.duplicatesPath(scanID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readResults(file, 0)
+}
+
+func (s *diskBackend) Delete(scanID string) error {
+	s.mutex.Lock()
+	delete(s.scans, scanID)
+	s.mutex.Unlock()
+
+	for _, path := range []string{s.resultsPath(scanID), s.duplicatesPath(scanID), s.recordPath(scanID), s.triagePath(scanID), s.ticketsPath(scanID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *diskBackend) rulesPath() string {
+	return filepath.Join(s.dir, "fp-rules.json")
+}
+
+// readRules loads the false-positive rules by ID. Callers must hold s.mutex.
+func (s *diskBackend) readRules() (map[string]types.FPRule, error) {
+	rules := make(map[string]types.FPRule)
+	data, err := os.ReadFile(s.rulesPath())
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// writeRules replaces the stored rules. Callers must hold s.mutex.
+func (s *diskBackend) writeRules(rules map[string]types.FPRule) error {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.rulesPath(), data)
+}
+
+func (s *diskBackend) SaveRule(rule types.FPRule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rules, err := s.readRules()
+	if err != nil {
+		return err
+	}
+	rules[rule.ID] = rule
+	return s.writeRules(rules)
+}
+
+func (s *diskBackend) Rules() ([]types.FPRule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rules, err := s.readRules()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]types.FPRule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+	return list, nil
+}
+
+func (s *diskBackend) DeleteRule(ruleID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rules, err := s.readRules()
+	if err != nil {
+		return err
+	}
+	if _, exists := rules[ruleID]; !exists {
+		return ErrRuleNotFound
+	}
+	delete(rules, ruleID)
+	return s.writeRules(rules)
+}
+
+func (s *diskBackend) blocklistPath() string {
+	return filepath.Join(s.dir, "blocklist.json")
+}
+
+func (s *diskBackend) SaveBlocklist(entries []types.BlocklistEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return writeFileAtomic(s.blocklistPath(), data)
+}
+
+func (s *diskBackend) Blocklist() ([]types.BlocklistEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make([]types.BlocklistEntry, 0)
+	data, err := os.ReadFile(s.blocklistPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *diskBackend) configPath() string {
+	return filepath.Join(s.dir, "config.json")
+}
+
+func (s *diskBackend) auditPath() string {
+	return filepath.Join(s.dir, "audit.jsonl")
+}
+
+func (s *diskBackend) SaveConfigOverrides(overrides map[string]json.RawMessage) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return writeFileAtomic(s.configPath(), data)
+}
+
+func (s *diskBackend) ConfigOverrides() (map[string]json.RawMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	overrides := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(s.configPath())
+	if os.IsNotExist(err) {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func (s *diskBackend) AppendAudit(entry types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return appendLine(s.auditPath(), append(data, '\n'))
+}
+
+// AuditLog skips lines that do not parse, such as one cut short by a crash
+func (s *diskBackend) AuditLog() ([]types.AuditEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make([]types.AuditEntry, 0)
+	data, err := os.ReadFile(s.auditPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry types.AuditEntry
+		if len(line) > 0 && json.Unmarshal(line, &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// PurgeAudit rewrites the audit log without the entries recorded before the
+// cutoff, dropping lines that do not parse with them
+func (s *diskBackend) PurgeAudit(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := os.ReadFile(s.auditPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var kept bytes.Buffer
+	purged := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry types.AuditEntry
+		if json.Unmarshal(line, &entry) != nil || entry.Time.Before(before) {
+			purged++
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, writeFileAtomic(s.auditPath(), kept.Bytes())
+}
+
+func (s *diskBackend) Close() error {
+	return nil
+}
+
+// readResults parses JSONL results, skipping lines that do not parse. With
+// seq set, results are numbered from it by line.
+func readResults(r io.Reader, seq int64) ([]types.ScanResult, error) {
+	results := make([]types.ScanResult, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := int64(0); scanner.Scan(); line++ {
+		var result types.ScanResult
+		if json.Unmarshal(scanner.Bytes(), &result) == nil {
+			if seq > 0 {
+				result.Seq = seq + line
+			}
+			results = append(results, result)
+		}
+	}
+	return results, scanner.Err()
+}
@@ -0,0 +1,18 @@
+package storage_test
+
+import (
+	"testing"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/storage/storagetest"
+)
+
+func TestDiskStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		store, err := storage.New(storage.Config{Backend: "disk", Dir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return store
+	})
+}
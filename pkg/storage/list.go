@@ -0,0 +1,436 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"nuclei-distributed/pkg/types"
+)
+
+// resultBatchSize is how many results are read at a time when a query has to
+// look at every result
+const resultBatchSize = 1000
+
+// listBackend keeps a scan's findings as an append-only list. Results are
+// addressed by their position in ingest order, starting at zero; a result's
+// sequence number is its position plus one.
+type listBackend interface {
+	// SaveRecord creates or replaces a scan's record
+	SaveRecord(record types.ScanRecord) error
+	// Record returns a scan's record, or ErrNotFound
+	Record(scanID string) (*types.ScanRecord, error)
+	// Records returns every scan record in no particular order
+	Records() ([]types.ScanRecord, error)
+	// Append stores a result and returns its position
+	Append(scanID string, result types.ScanResult) (int64, error)
+	// AppendBatch stores results in order at once and returns the position
+	// of the first
+	AppendBatch(scanID string, results []types.ScanResult) (int64, error)
+	// AppendDuplicate stores a finding the dedup index dropped
+	AppendDuplicate(scanID string, result types.ScanResult) error
+	// Count returns the number of stored results
+	Count(scanID string) (int64, error)
+	// Range returns the results at positions [start, end)
+	Range(scanID string, start, end int64) ([]types.ScanResult, error)
+	// Get returns the results at the given positions, in the given order
+	Get(scanID string, positions []int64) ([]types.ScanResult, error)
+	// SeverityPositions returns the positions of every result with severity
+	SeverityPositions(scanID, severity string) ([]int64, error)
+	// Hosts returns the host index's summary of every host
+	Hosts(scanID string) ([]types.HostSummary, error)
+	// HostPositions returns the positions of every result on host
+	HostPositions(scanID, host string) ([]int64, error)
+	// Position returns the position of the result with resultID, or
+	// ErrResultNotFound
+	Position(scanID, resultID string) (int64, error)
+	// Triage returns every verdict recorded for a scan, by result ID
+	Triage(scanID string) (map[string]types.Triage, error)
+	// SaveTriage records a verdict on a result
+	SaveTriage(scanID, resultID string, triage types.Triage) error
+	// Tickets returns every issue linked to a scan's results, by result ID
+	Tickets(scanID string) (map[string]types.Ticket, error)
+	// SaveTicket links a result to an issue
+	SaveTicket(scanID, resultID string, ticket types.Ticket) error
+	// Duplicates returns every dropped duplicate
+	Duplicates(scanID string) ([]types.ScanResult, error)
+	// Delete removes everything stored for a scan
+	Delete(scanID string) error
+	// SaveRule creates or replaces a false-positive rule
+	SaveRule(rule types.FPRule) error
+	// Rules returns every false-positive rule
+	Rules() ([]types.FPRule, error)
+	// DeleteRule removes a false-positive rule, or returns ErrRuleNotFound
+	DeleteRule(ruleID string) error
+	// SaveBlocklist replaces the blocklist entries added at runtime
+	SaveBlocklist(entries []types.BlocklistEntry) error
+	// Blocklist returns the blocklist entries added at runtime
+	Blocklist() ([]types.BlocklistEntry, error)
+	// SaveConfigOverrides replaces the server settings changed at runtime
+	SaveConfigOverrides(overrides map[string]json.RawMessage) error
+	// ConfigOverrides returns the server settings changed at runtime
+	ConfigOverrides() (map[string]json.RawMessage, error)
+	// AppendAudit records a change made through the API
+	AppendAudit(entry types.AuditEntry) error
+	// AuditLog returns every recorded change, oldest first
+	AuditLog() ([]types.AuditEntry, error)
+	// PurgeAudit removes the changes recorded before a time
+	PurgeAudit(before time.Time) (int, error)
+	// Close releases the backend's resources
+	Close() error
+}
+
+// listStore answers queries over a listBackend. Unfiltered and severity-only
+// queries read just the requested page; other filters scan the stored results
+// in batches.
+type listStore struct {
+	backend listBackend
+}
+
+func (s *listStore) SaveScan(record types.ScanRecord) error {
+	return s.backend.SaveRecord(record)
+}
+
+func (s *listStore) GetScan(scanID string) (*types.ScanRecord, error) {
+	return s.backend.Record(scanID)
+}
+
+func (s *listStore) ListScans() ([]types.ScanRecord, error) {
+	records, err := s.backend.Records()
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		records[i].Targets = nil
+		records[i].Config = nil
+		records[i].Uncovered = nil
+		records[i].Discovered = nil
+		records[i].Log = nil
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (s *listStore) AppendResult(scanID string, result types.ScanResult) (int64, error) {
+	position, err := s.backend.Append(scanID, result)
+	if err != nil {
+		return 0, err
+	}
+	if result.Triage != nil {
+		// Verdicts set on ingest live with the others so queries see them
+		if err := s.backend.SaveTriage(scanID, result.ID, *result.Triage); err != nil {
+			return 0, err
+		}
+	}
+	return position + 1, nil
+}
+
+func (s *listStore) AppendResults(scanID string, results []types.ScanResult) error {
+	if _, err := s.backend.AppendBatch(scanID, results); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Triage != nil {
+			if err := s.backend.SaveTriage(scanID, result.ID, *result.Triage); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *listStore) AppendDuplicate(scanID string, result types.ScanResult) error {
+	return s.backend.AppendDuplicate(scanID, result)
+}
+
+func (s *listStore) SetTriage(scanID, resultID string, triage types.Triage) (*types.ScanResult, error) {
+	previous, err := s.GetResult(scanID, resultID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.SaveTriage(scanID, resultID, triage); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func (s *listStore) GetResult(scanID, resultID string) (*types.ScanResult, error) {
+	position, err := s.backend.Position(scanID, resultID)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.backend.Get(scanID, []int64{position})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrResultNotFound
+	}
+
+	annotated, err := s.annotations(scanID)
+	if err != nil {
+		return nil, err
+	}
+	result := annotated.apply(results)[0]
+	return &result, nil
+}
+
+func (s *listStore) SaveTicket(scanID, resultID string, ticket types.Ticket) error {
+	if _, err := s.backend.Position(scanID, resultID); err != nil {
+		return err
+	}
+	return s.backend.SaveTicket(scanID, resultID, ticket)
+}
+
+// annotations are the verdicts and tickets recorded for a scan's results,
+// kept apart from the append-only list
+type annotations struct {
+	verdicts map[string]types.Triage
+	tickets  map[string]types.Ticket
+}
+
+func (s *listStore) annotations(scanID string) (*annotations, error) {
+	verdicts, err := s.backend.Triage(scanID)
+	if err != nil {
+		return nil, err
+	}
+	tickets, err := s.backend.Tickets(scanID)
+	if err != nil {
+		return nil, err
+	}
+	return &annotations{verdicts: verdicts, tickets: tickets}, nil
+}
+
+// apply sets the triage and ticket of each result
+func (a *annotations) apply(results []types.ScanResult) []types.ScanResult {
+	for i := range results {
+		if verdict, exists := a.verdicts[results[i].ID]; exists {
+			results[i].Triage = &verdict
+		}
+		if ticket, exists := a.tickets[results[i].ID]; exists {
+			results[i].Ticket = &ticket
+		}
+	}
+	return results
+}
+
+func (s *listStore) Hosts(scanID string) ([]types.HostSummary, error) {
+	return s.backend.Hosts(scanID)
+}
+
+func (s *listStore) DeleteScan(scanID string) error {
+	return s.backend.Delete(scanID)
+}
+
+func (s *listStore) SaveRule(rule types.FPRule) error {
+	return s.backend.SaveRule(rule)
+}
+
+func (s *listStore) Rules() ([]types.FPRule, error) {
+	return s.backend.Rules()
+}
+
+func (s *listStore) DeleteRule(ruleID string) error {
+	return s.backend.DeleteRule(ruleID)
+}
+
+func (s *listStore) SaveBlocklist(entries []types.BlocklistEntry) error {
+	return s.backend.SaveBlocklist(entries)
+}
+
+func (s *listStore) Blocklist() ([]types.BlocklistEntry, error) {
+	return s.backend.Blocklist()
+}
+
+func (s *listStore) SaveConfigOverrides(overrides map[string]json.RawMessage) error {
+	return s.backend.SaveConfigOverrides(overrides)
+}
+
+func (s *listStore) ConfigOverrides() (map[string]json.RawMessage, error) {
+	return s.backend.ConfigOverrides()
+}
+
+func (s *listStore) AppendAudit(entry types.AuditEntry) error {
+	return s.backend.AppendAudit(entry)
+}
+
+func (s *listStore) AuditLog() ([]types.AuditEntry, error) {
+	return s.backend.AuditLog()
+}
+
+func (s *listStore) PurgeAudit(before time.Time) (int, error) {
+	return s.backend.PurgeAudit(before)
+}
+
+func (s *listStore) Close() error {
+	return s.backend.Close()
+}
+
+func (s *listStore) QueryResults(scanID string, filter types.ResultFilter) (*types.ResultPage, error) {
+	total, err := s.backend.Count(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotated, err := s.annotations(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := newPage(filter, int(total))
+
+	start, end := int64(0), int64(-1)
+	if filter.PageSize > 0 {
+		if filter.Page < 1 {
+			filter.Page = 1
+			page.Page = 1
+		}
+		start = int64(filter.Page-1) * int64(filter.PageSize)
+		end = start + int64(filter.PageSize)
+	}
+
+	collect := func(result *types.ScanResult) {
+		if !matchesFilter(result, &filter) {
+			return
+		}
+		matched := int64(page.Matched)
+		if matched >= start && (end < 0 || matched < end) {
+			page.Results = append(page.Results, *result)
+		}
+		page.Matched++
+	}
+
+	// Positions after the cursor and before the results appended since the
+	// count, so the next cursor covers exactly what this query saw
+	first := filter.AfterSeq
+	if first < 0 {
+		first = 0
+	}
+	if first > total {
+		first = total
+	}
+	window := func(positions []int64) []int64 {
+		from := sort.Search(len(positions), func(i int) bool { return positions[i] >= first })
+		to := sort.Search(len(positions), func(i int) bool { return positions[i] >= total })
+		return positions[from:to]
+	}
+
+	indexed := filter.Template == "" && filter.Host == "" && filter.Since.IsZero() && !filter.IncludeDuplicates &&
+		!(filter.ExcludeFalsePositives && hasFalsePositives(annotated.verdicts))
+
+	switch {
+	case indexed && filter.AssetHost == "" && len(filter.Severities) == 0:
+		page.Matched = int(total - first)
+		if end < 0 || end > total-first {
+			end = total - first
+		}
+		if start < end {
+			results, err := s.backend.Range(scanID, first+start, first+end)
+			if err != nil {
+				return nil, err
+			}
+			page.Results = append(page.Results, annotated.apply(results)...)
+		}
+
+	case indexed && (filter.AssetHost == "" || len(filter.Severities) == 0):
+		var positions []int64
+		if filter.AssetHost != "" {
+			positions, err = s.backend.HostPositions(scanID, filter.AssetHost)
+		} else {
+			positions, err = s.severityPositions(scanID, filter.Severities)
+		}
+		if err != nil {
+			return nil, err
+		}
+		positions = window(positions)
+		page.Matched = len(positions)
+		if end < 0 || end > int64(len(positions)) {
+			end = int64(len(positions))
+		}
+		if start < end {
+			results, err := s.backend.Get(scanID, positions[start:end])
+			if err != nil {
+				return nil, err
+			}
+			page.Results = append(page.Results, annotated.apply(results)...)
+		}
+
+	case filter.AssetHost != "":
+		// A host's findings are read through the host index and filtered
+		positions, err := s.backend.HostPositions(scanID, filter.AssetHost)
+		if err != nil {
+			return nil, err
+		}
+		positions = window(positions)
+		for offset := 0; offset < len(positions); offset += resultBatchSize {
+			batch, err := s.backend.Get(scanID, positions[offset:min(offset+resultBatchSize, len(positions))])
+			if err != nil {
+				return nil, err
+			}
+			annotated.apply(batch)
+			for i := range batch {
+				collect(&batch[i])
+			}
+		}
+
+	default:
+		for offset := first; offset < total; offset += resultBatchSize {
+			batch, err := s.backend.Range(scanID, offset, min(offset+resultBatchSize, total))
+			if err != nil {
+				return nil, err
+			}
+			annotated.apply(batch)
+			for i := range batch {
+				collect(&batch[i])
+			}
+		}
+	}
+
+	if filter.IncludeDuplicates && filter.AfterSeq == 0 {
+		duplicates, err := s.backend.Duplicates(scanID)
+		if err != nil {
+			return nil, err
+		}
+		annotated.apply(duplicates)
+		for i := range duplicates {
+			duplicates[i].Duplicate = true
+			collect(&duplicates[i])
+		}
+	}
+
+	page.NextCursor = nextCursor(page, total)
+	return page, nil
+}
+
+// hasFalsePositives reports whether any verdict marks a false positive
+func hasFalsePositives(verdicts map[string]types.Triage) bool {
+	for _, verdict := range verdicts {
+		if verdict.Status == types.TriageFalsePositive {
+			return true
+		}
+	}
+	return false
+}
+
+// severityPositions merges the severity index for each requested severity
+// into one ascending list of positions
+func (s *listStore) severityPositions(scanID string, severities []string) ([]int64, error) {
+	seen := make(map[string]bool)
+	var positions []int64
+	for _, severity := range severities {
+		severity = IndexedSeverity(severity)
+		if seen[severity] {
+			continue
+		}
+		seen[severity] = true
+
+		indexed, err := s.backend.SeverityPositions(scanID, severity)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, indexed...)
+	}
+
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	return positions, nil
+}
@@ -0,0 +1,26 @@
+CREATE TABLE scans (
+    id         TEXT PRIMARY KEY,
+    status     TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    record     JSONB NOT NULL
+);
+
+CREATE INDEX scans_created_at_idx ON scans (created_at DESC);
+
+-- Findings in ingest order. Duplicates dropped by the dedup index are kept
+-- in the same table so they can still be exported.
+CREATE TABLE results (
+    id        BIGSERIAL PRIMARY KEY,
+    scan_id   TEXT NOT NULL,
+    duplicate BOOLEAN NOT NULL DEFAULT FALSE,
+    severity  TEXT NOT NULL,
+    host      TEXT NOT NULL,
+    template  TEXT NOT NULL,
+    found_at  TIMESTAMPTZ NOT NULL,
+    data      JSONB NOT NULL
+);
+
+CREATE INDEX results_scan_id_idx ON results (scan_id, duplicate, id);
+CREATE INDEX results_severity_idx ON results (scan_id, severity, id);
+CREATE INDEX results_host_idx ON results (scan_id, host);
+CREATE INDEX results_template_idx ON results (scan_id, template);
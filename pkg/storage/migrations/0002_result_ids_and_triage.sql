@@ -0,0 +1,4 @@
+ALTER TABLE results ADD COLUMN result_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE results ADD COLUMN triage JSONB;
+
+CREATE INDEX results_result_id_idx ON results (scan_id, result_id);
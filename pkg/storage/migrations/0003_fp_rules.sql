@@ -0,0 +1,4 @@
+CREATE TABLE fp_rules (
+    id   TEXT PRIMARY KEY,
+    rule JSONB NOT NULL
+);
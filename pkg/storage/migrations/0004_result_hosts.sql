@@ -0,0 +1,18 @@
+-- Per-host index of findings, kept up to date as results are inserted so the
+-- affected-assets view never aggregates the results table
+CREATE TABLE result_hosts (
+    scan_id    TEXT NOT NULL,
+    host       TEXT NOT NULL,
+    severity   TEXT NOT NULL,
+    template   TEXT NOT NULL,
+    results    INTEGER NOT NULL,
+    first_seen TIMESTAMPTZ NOT NULL,
+    last_seen  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scan_id, host, severity, template)
+);
+
+INSERT INTO result_hosts (scan_id, host, severity, template, results, first_seen, last_seen)
+SELECT scan_id, host, severity, template, count(*), min(found_at), max(found_at)
+FROM results
+WHERE NOT duplicate
+GROUP BY scan_id, host, severity, template;
@@ -0,0 +1,4 @@
+CREATE TABLE blocklist (
+    pattern TEXT PRIMARY KEY,
+    entry   JSONB NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE config_overrides (
+    field TEXT PRIMARY KEY,
+    value JSONB NOT NULL
+);
+
+CREATE TABLE audit_log (
+    id    BIGSERIAL PRIMARY KEY,
+    entry JSONB NOT NULL
+);
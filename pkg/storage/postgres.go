@@ -0,0 +1,657 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"nuclei-distributed/pkg/types"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// postgresStore keeps scan records and findings in PostgreSQL. Filters are
+// answered by the database, so no query reads more than the requested page.
+type postgresStore struct {
+	db *sql.DB
+	// inserts serializes result inserts so result IDs, which serve as
+	// sequence numbers, become visible in order and a cursor never skips a
+	// finding committed late
+	inserts sync.Mutex
+}
+
+func newPostgresStore(databaseURL string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate postgres schema: %v", err)
+	}
+	return &postgresStore{db: db}, nil
+}
+
+// migrate applies the embedded migrations that have not run yet. Files are
+// named NNNN_description.sql and applied in order, each in its own
+// transaction.
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("migration %s has no version prefix", name)
+		}
+
+		var applied bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + name)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %v", name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *postgresStore) SaveScan(record types.ScanRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO scans (id, status, created_at, record) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, created_at = EXCLUDED.created_at, record = EXCLUDED.record`,
+		record.ID, record.Status, record.CreatedAt, data)
+	return err
+}
+
+func (s *postgresStore) GetScan(scanID string) (*types.ScanRecord, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT record FROM scans WHERE id = $1`, scanID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record types.ScanRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *postgresStore) ListScans() ([]types.ScanRecord, error) {
+	rows, err := s.db.Query(`SELECT record FROM scans ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]types.ScanRecord, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record types.ScanRecord
+		if json.Unmarshal(data, &record) == nil {
+			record.Targets = nil
+			record.Config = nil
+			record.Uncovered = nil
+			record.Discovered = nil
+			record.Log = nil
+			records = append(records, record)
+		}
+	}
+	return records, rows.Err()
+}
+
+func (s *postgresStore) AppendResult(scanID string, result types.ScanResult) (int64, error) {
+	return s.insertResult(scanID, result, false)
+}
+
+func (s *postgresStore) AppendResults(scanID string, results []types.ScanResult) error {
+	s.inserts.Lock()
+	defer s.inserts.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if _, err := insertFinding(tx, scanID, result, false); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) AppendDuplicate(scanID string, result types.ScanResult) error {
+	_, err := s.insertResult(scanID, result, true)
+	return err
+}
+
+func (s *postgresStore) insertResult(scanID string, result types.ScanResult, duplicate bool) (int64, error) {
+	s.inserts.Lock()
+	defer s.inserts.Unlock()
+	return insertFinding(s.db, scanID, result, duplicate)
+}
+
+// rowQuerier runs a query on the database or in a transaction
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertFinding inserts a finding and counts it in the host index, unless it
+// is a duplicate. The caller holds inserts.
+func insertFinding(db rowQuerier, scanID string, result types.ScanResult, duplicate bool) (int64, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return 0, err
+	}
+
+	// A nil interface rather than a nil slice, so the driver sends NULL
+	var verdict interface{}
+	if result.Triage != nil {
+		encoded, err := json.Marshal(result.Triage)
+		if err != nil {
+			return 0, err
+		}
+		verdict = encoded
+	}
+
+	// Duplicates take the verdict and ticket of the finding they duplicate;
+	// other findings are counted in the host index
+	var id int64
+	err = db.QueryRow(`WITH inserted AS (
+			INSERT INTO results (scan_id, result_id, duplicate, severity, host, template, found_at, data, triage, ticket)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, (
+				SELECT triage FROM results WHERE scan_id = $1 AND result_id = $2 AND NOT duplicate AND $3 LIMIT 1
+			)), (
+				SELECT ticket FROM results WHERE scan_id = $1 AND result_id = $2 AND NOT duplicate AND $3 LIMIT 1
+			)) RETURNING id
+		), indexed AS (
+			INSERT INTO result_hosts (scan_id, host, severity, template, results, first_seen, last_seen)
+			SELECT $1::text, $5::text, $4::text, $6::text, 1, $7::timestamptz, $7::timestamptz WHERE NOT $3::boolean
+			ON CONFLICT (scan_id, host, severity, template) DO UPDATE SET
+				results = result_hosts.results + 1,
+				first_seen = LEAST(result_hosts.first_seen, EXCLUDED.first_seen),
+				last_seen = GREATEST(result_hosts.last_seen, EXCLUDED.last_seen)
+		)
+		SELECT id FROM inserted`,
+		scanID, result.ID, duplicate, IndexedSeverity(result.Severity), result.Host, result.Template, result.Timestamp, data, verdict).Scan(&id)
+	return id, err
+}
+
+func (s *postgresStore) QueryResults(scanID string, filter types.ResultFilter) (*types.ResultPage, error) {
+	var total int
+	var latest int64
+	if err := s.db.QueryRow(`SELECT count(*), COALESCE(max(id), 0) FROM results WHERE scan_id = $1 AND NOT duplicate`,
+		scanID).Scan(&total, &latest); err != nil {
+		return nil, err
+	}
+
+	page := newPage(filter, total)
+
+	// Results inserted after the count are left for the next cursor
+	conditions := []string{"scan_id = $1", "(duplicate OR id <= $2)"}
+	args := []interface{}{scanID, latest}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if !filter.IncludeDuplicates || filter.AfterSeq > 0 {
+		conditions = append(conditions, "NOT duplicate")
+	}
+	if filter.AfterSeq > 0 {
+		addCondition("id > $%d", filter.AfterSeq)
+	}
+	if len(filter.Severities) > 0 {
+		placeholders := make([]string, len(filter.Severities))
+		for i, severity := range filter.Severities {
+			args = append(args, IndexedSeverity(severity))
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, "severity IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.Template != "" {
+		addCondition("template = $%d", filter.Template)
+	}
+	if filter.AssetHost != "" {
+		addCondition("host = $%d", filter.AssetHost)
+	}
+	if filter.Host != "" {
+		addCondition("strpos(lower(host), lower($%d)) > 0", filter.Host)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("found_at >= $%d", filter.Since)
+	}
+	if filter.ExcludeFalsePositives {
+		addCondition("(triage IS NULL OR triage->>'status' <> $%d)", types.TriageFalsePositive)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	if err := s.db.QueryRow(`SELECT count(*) FROM results WHERE `+where, args...).Scan(&page.Matched); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, data, duplicate, triage, ticket FROM results WHERE ` + where + ` ORDER BY duplicate, id`
+	if filter.PageSize > 0 {
+		if filter.Page < 1 {
+			filter.Page = 1
+			page.Page = 1
+		}
+		args = append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var data, triage, ticket []byte
+		var duplicate bool
+		if err := rows.Scan(&id, &data, &duplicate, &triage, &ticket); err != nil {
+			return nil, err
+		}
+		result, err := decodeRow(data, triage, ticket)
+		if err != nil {
+			continue
+		}
+		result.Duplicate = duplicate
+		if !duplicate {
+			result.Seq = id
+		}
+		page.Results = append(page.Results, *result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page.NextCursor = nextCursor(page, latest)
+	return page, nil
+}
+
+// decodeRow rebuilds a finding from its stored JSON, triage and ticket
+// columns
+func decodeRow(data, triage, ticket []byte) (*types.ScanResult, error) {
+	var result types.ScanResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if triage != nil {
+		var verdict types.Triage
+		if err := json.Unmarshal(triage, &verdict); err != nil {
+			return nil, err
+		}
+		result.Triage = &verdict
+	}
+	if ticket != nil {
+		var linked types.Ticket
+		if err := json.Unmarshal(ticket, &linked); err != nil {
+			return nil, err
+		}
+		result.Ticket = &linked
+	}
+	return &result, nil
+}
+
+func (s *postgresStore) Hosts(scanID string) ([]types.HostSummary, error) {
+	rows, err := s.db.Query(`SELECT host, severity, template, results, first_seen, last_seen
+		FROM result_hosts WHERE scan_id = $1`, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make(map[string]*types.HostSummary)
+	for rows.Next() {
+		var host, severity, template string
+		var count int
+		var firstSeen, lastSeen time.Time
+		if err := rows.Scan(&host, &severity, &template, &count, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+
+		summary, exists := summaries[host]
+		if !exists {
+			summary = &types.HostSummary{
+				Host:           host,
+				SeverityCounts: make(map[string]int),
+				FirstSeen:      firstSeen,
+				LastSeen:       lastSeen,
+			}
+			summaries[host] = summary
+		}
+		summary.ResultCount += count
+		summary.SeverityCounts[severity] += count
+		summary.Templates = insertSorted(summary.Templates, template)
+		if firstSeen.Before(summary.FirstSeen) {
+			summary.FirstSeen = firstSeen
+		}
+		if lastSeen.After(summary.LastSeen) {
+			summary.LastSeen = lastSeen
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hosts := make([]types.HostSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		summary.HighestSeverity = highestSeverity(summary.SeverityCounts)
+		hosts = append(hosts, *summary)
+	}
+	return hosts, nil
+}
+
+func (s *postgresStore) SetTriage(scanID, resultID string, triage types.Triage) (*types.ScanResult, error) {
+	verdict, err := json.Marshal(triage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CTE locks the finding and hands back its triage from before the
+	// update; dropped duplicates of the finding share its verdict
+	var data, previous, ticket []byte
+	err = s.db.QueryRow(`WITH old AS (
+			SELECT data, triage, ticket FROM results
+			WHERE scan_id = $1 AND result_id = $2 AND NOT duplicate
+			ORDER BY id LIMIT 1 FOR UPDATE
+		)
+		UPDATE results SET triage = $3 FROM old
+		WHERE results.scan_id = $1 AND results.result_id = $2
+		RETURNING old.data, old.triage, old.ticket`, scanID, resultID, verdict).Scan(&data, &previous, &ticket)
+	if err == sql.ErrNoRows {
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRow(data, previous, ticket)
+}
+
+func (s *postgresStore) GetResult(scanID, resultID string) (*types.ScanResult, error) {
+	var id int64
+	var data, triage, ticket []byte
+	err := s.db.QueryRow(`SELECT id, data, triage, ticket FROM results
+		WHERE scan_id = $1 AND result_id = $2 AND NOT duplicate
+		ORDER BY id LIMIT 1`, scanID, resultID).Scan(&id, &data, &triage, &ticket)
+	if err == sql.ErrNoRows {
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	result, err := decodeRow(data, triage, ticket)
+	if err != nil {
+		return nil, err
+	}
+	result.Seq = id
+	return result, nil
+}
+
+func (s *postgresStore) SaveTicket(scanID, resultID string, ticket types.Ticket) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return err
+	}
+
+	// Dropped duplicates of the finding share its ticket
+	res, err := s.db.Exec(`UPDATE results SET ticket = $3 WHERE scan_id = $1 AND result_id = $2`,
+		scanID, resultID, data)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrResultNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteScan(scanID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM results WHERE scan_id = $1`, scanID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM result_hosts WHERE scan_id = $1`, scanID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM scans WHERE id = $1`, scanID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) SaveRule(rule types.FPRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO fp_rules (id, rule) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET rule = EXCLUDED.rule`, rule.ID, data)
+	return err
+}
+
+func (s *postgresStore) Rules() ([]types.FPRule, error) {
+	rows, err := s.db.Query(`SELECT rule FROM fp_rules`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]types.FPRule, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rule types.FPRule
+		if json.Unmarshal(data, &rule) == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, rows.Err()
+}
+
+func (s *postgresStore) DeleteRule(ruleID string) error {
+	deleted, err := s.db.Exec(`DELETE FROM fp_rules WHERE id = $1`, ruleID)
+	if err != nil {
+		return err
+	}
+	if count, err := deleted.RowsAffected(); err == nil && count == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) SaveBlocklist(entries []types.BlocklistEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM blocklist`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO blocklist (pattern, entry) VALUES ($1, $2)`, entry.Pattern, data); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) Blocklist() ([]types.BlocklistEntry, error) {
+	rows, err := s.db.Query(`SELECT entry FROM blocklist`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]types.BlocklistEntry, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var entry types.BlocklistEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *postgresStore) SaveConfigOverrides(overrides map[string]json.RawMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM config_overrides`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for field, value := range overrides {
+		if _, err := tx.Exec(`INSERT INTO config_overrides (field, value) VALUES ($1, $2)`, field, []byte(value)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) ConfigOverrides() (map[string]json.RawMessage, error) {
+	rows, err := s.db.Query(`SELECT field, value FROM config_overrides`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var field string
+		var value []byte
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+		overrides[field] = value
+	}
+	return overrides, rows.Err()
+}
+
+func (s *postgresStore) AppendAudit(entry types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO audit_log (entry) VALUES ($1)`, data)
+	return err
+}
+
+func (s *postgresStore) AuditLog() ([]types.AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT entry FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]types.AuditEntry, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var entry types.AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *postgresStore) PurgeAudit(before time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM audit_log WHERE (entry->>'time')::timestamptz < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/storage/storagetest"
+)
+
+// TestPostgresStore runs against the database at TEST_DATABASE_URL, which it
+// migrates and leaves its scans in
+func TestPostgresStore(t *testing.T) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		store, err := storage.New(storage.Config{Backend: "postgres", DatabaseURL: databaseURL})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,560 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"nuclei-distributed/pkg/types"
+)
+
+// redisBackend keeps results in a Redis list per scan with lists of positions
+// per severity and per host as secondary indexes. Scan records live in one
+// hash.
+type redisBackend struct {
+	client *redis.Client
+}
+
+// scansKey is the hash of scan records, keyed by scan ID
+const scansKey = "scans"
+
+// rulesKey is the hash of false-positive rules, keyed by rule ID
+const rulesKey = "fp-rules"
+
+// blocklistKey holds the blocklist entries added at runtime as a JSON array
+const blocklistKey = "blocklist"
+
+// configKey holds the server settings changed at runtime as a JSON object,
+// and auditKey the list of changes made through the API
+const (
+	configKey = "config-overrides"
+	auditKey  = "audit-log"
+)
+
+func resultsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:results", scanID)
+}
+
+func severityIndexKey(scanID, severity string) string {
+	return fmt.Sprintf("scan:%s:results:severity:%s", scanID, IndexedSeverity(severity))
+}
+
+func resultIDsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:results:ids", scanID)
+}
+
+func triageKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:triage", scanID)
+}
+
+func ticketsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:tickets", scanID)
+}
+
+// hostsKey is the set of hosts with findings in a scan
+func hostsKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:hosts", scanID)
+}
+
+// hostKey is the hash of a host's severity counts and first and last seen
+// times
+func hostKey(scanID, host string) string {
+	return fmt.Sprintf("scan:%s:host:%s", scanID, host)
+}
+
+func hostTemplatesKey(scanID, host string) string {
+	return fmt.Sprintf("scan:%s:host:%s:templates", scanID, host)
+}
+
+func hostPositionsKey(scanID, host string) string {
+	return fmt.Sprintf("scan:%s:host:%s:results", scanID, host)
+}
+
+// seenScript widens a host's first and last seen times to include a finding's
+// timestamp, in microseconds so Lua numbers hold them exactly
+var seenScript = redis.NewScript(`
+local seen = tonumber(ARGV[1])
+local first = tonumber(redis.call('HGET', KEYS[1], 'first'))
+if not first or seen < first then redis.call('HSET', KEYS[1], 'first', ARGV[1]) end
+local last = tonumber(redis.call('HGET', KEYS[1], 'last'))
+if not last or seen > last then redis.call('HSET', KEYS[1], 'last', ARGV[1]) end
+return 0
+`)
+
+func duplicatesKey(scanID string) string {
+	return fmt.Sprintf("scan:%s:duplicates", scanID)
+}
+
+func (s *redisBackend) SaveRecord(record types.ScanRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), scansKey, record.ID, data).Err()
+}
+
+func (s *redisBackend) Record(scanID string) (*types.ScanRecord, error) {
+	data, err := s.client.HGet(context.Background(), scansKey, scanID).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record types.ScanRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *redisBackend) Records() ([]types.ScanRecord, error) {
+	entries, err := s.client.HGetAll(context.Background(), scansKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]types.ScanRecord, 0, len(entries))
+	for _, data := range entries {
+		var record types.ScanRecord
+		if json.Unmarshal([]byte(data), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *redisBackend) Append(scanID string, result types.ScanResult) (int64, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	length, err := s.client.RPush(ctx, resultsKey(scanID), data).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	position := length - 1
+	if err := s.client.RPush(ctx, severityIndexKey(scanID, result.Severity), position).Err(); err != nil {
+		return position, err
+	}
+	if result.ID != "" {
+		if err := s.client.HSet(ctx, resultIDsKey(scanID), result.ID, position).Err(); err != nil {
+			return position, err
+		}
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, hostsKey(scanID), result.Host)
+		pipe.RPush(ctx, hostPositionsKey(scanID, result.Host), position)
+		pipe.HIncrBy(ctx, hostKey(scanID, result.Host), "sev:"+IndexedSeverity(result.Severity), 1)
+		pipe.SAdd(ctx, hostTemplatesKey(scanID, result.Host), result.Template)
+		seenScript.Eval(ctx, pipe, []string{hostKey(scanID, result.Host)}, result.Timestamp.UnixMicro())
+		return nil
+	})
+	return position, err
+}
+
+func (s *redisBackend) AppendBatch(scanID string, results []types.ScanResult) (int64, error) {
+	values := make([]interface{}, len(results))
+	for i, result := range results {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = data
+	}
+
+	// One push, so a failure leaves no partial batch behind; the indexes
+	// follow like a single result's
+	ctx := context.Background()
+	length, err := s.client.RPush(ctx, resultsKey(scanID), values...).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	first := length - int64(len(results))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, result := range results {
+			position := first + int64(i)
+			pipe.RPush(ctx, severityIndexKey(scanID, result.Severity), position)
+			if result.ID != "" {
+				pipe.HSet(ctx, resultIDsKey(scanID), result.ID, position)
+			}
+			pipe.SAdd(ctx, hostsKey(scanID), result.Host)
+			pipe.RPush(ctx, hostPositionsKey(scanID, result.Host), position)
+			pipe.HIncrBy(ctx, hostKey(scanID, result.Host), "sev:"+IndexedSeverity(result.Severity), 1)
+			pipe.SAdd(ctx, hostTemplatesKey(scanID, result.Host), result.Template)
+			seenScript.Eval(ctx, pipe, []string{hostKey(scanID, result.Host)}, result.Timestamp.UnixMicro())
+		}
+		return nil
+	})
+	return first, err
+}
+
+func (s *redisBackend) AppendDuplicate(scanID string, result types.ScanResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.client.RPush(context.Background(), duplicatesKey(scanID), data).Err()
+}
+
+func (s *redisBackend) Count(scanID string) (int64, error) {
+	return s.client.LLen(context.Background(), resultsKey(scanID)).Result()
+}
+
+func (s *redisBackend) Range(scanID string, start, end int64) ([]types.ScanResult, error) {
+	if end <= start {
+		return nil, nil
+	}
+	entries, err := s.client.LRange(context.Background(), resultsKey(scanID), start, end-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]int64, len(entries))
+	for i := range entries {
+		seqs[i] = start + int64(i) + 1
+	}
+	return decodeResults(entries, seqs), nil
+}
+
+func (s *redisBackend) Get(scanID string, positions []int64) ([]types.ScanResult, error) {
+	if len(positions) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(positions))
+	for i, position := range positions {
+		cmds[i] = pipe.LIndex(ctx, resultsKey(scanID), position)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	entries := make([]string, 0, len(cmds))
+	seqs := make([]int64, 0, len(cmds))
+	for i, cmd := range cmds {
+		if entry, err := cmd.Result(); err == nil {
+			entries = append(entries, entry)
+			seqs = append(seqs, positions[i]+1)
+		}
+	}
+	return decodeResults(entries, seqs), nil
+}
+
+func (s *redisBackend) SeverityPositions(scanID, severity string) ([]int64, error) {
+	return s.positions(severityIndexKey(scanID, severity))
+}
+
+// positions reads an index list of result positions
+func (s *redisBackend) positions(key string) ([]int64, error) {
+	entries, err := s.client.LRange(context.Background(), key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		if position, err := strconv.ParseInt(entry, 10, 64); err == nil {
+			positions = append(positions, position)
+		}
+	}
+	return positions, nil
+}
+
+func (s *redisBackend) Hosts(scanID string) ([]types.HostSummary, error) {
+	ctx := context.Background()
+	hosts, err := s.client.SMembers(ctx, hostsKey(scanID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	fields := make([]*redis.StringStringMapCmd, len(hosts))
+	templates := make([]*redis.StringSliceCmd, len(hosts))
+	for i, host := range hosts {
+		fields[i] = pipe.HGetAll(ctx, hostKey(scanID, host))
+		templates[i] = pipe.SMembers(ctx, hostTemplatesKey(scanID, host))
+	}
+	if len(hosts) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	summaries := make([]types.HostSummary, 0, len(hosts))
+	for i, host := range hosts {
+		summary := types.HostSummary{
+			Host:           host,
+			SeverityCounts: make(map[string]int),
+			Templates:      templates[i].Val(),
+		}
+		for field, value := range fields[i].Val() {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch {
+			case field == "first":
+				summary.FirstSeen = time.UnixMicro(number).UTC()
+			case field == "last":
+				summary.LastSeen = time.UnixMicro(number).UTC()
+			case strings.HasPrefix(field, "sev:"):
+				summary.SeverityCounts[strings.TrimPrefix(field, "sev:")] = int(number)
+				summary.ResultCount += int(number)
+			}
+		}
+		summary.HighestSeverity = highestSeverity(summary.SeverityCounts)
+		sort.Strings(summary.Templates)
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+func (s *redisBackend) HostPositions(scanID, host string) ([]int64, error) {
+	return s.positions(hostPositionsKey(scanID, host))
+}
+
+func (s *redisBackend) Position(scanID, resultID string) (int64, error) {
+	position, err := s.client.HGet(context.Background(), resultIDsKey(scanID), resultID).Int64()
+	if err == redis.Nil {
+		return 0, ErrResultNotFound
+	}
+	return position, err
+}
+
+func (s *redisBackend) Triage(scanID string) (map[string]types.Triage, error) {
+	entries, err := s.client.HGetAll(context.Background(), triageKey(scanID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := make(map[string]types.Triage, len(entries))
+	for resultID, data := range entries {
+		var verdict types.Triage
+		if json.Unmarshal([]byte(data), &verdict) == nil {
+			verdicts[resultID] = verdict
+		}
+	}
+	return verdicts, nil
+}
+
+func (s *redisBackend) SaveTriage(scanID, resultID string, triage types.Triage) error {
+	data, err := json.Marshal(triage)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), triageKey(scanID), resultID, data).Err()
+}
+
+func (s *redisBackend) Tickets(scanID string) (map[string]types.Ticket, error) {
+	entries, err := s.client.HGetAll(context.Background(), ticketsKey(scanID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := make(map[string]types.Ticket, len(entries))
+	for resultID, data := range entries {
+		var ticket types.Ticket
+		if json.Unmarshal([]byte(data), &ticket) == nil {
+			tickets[resultID] = ticket
+		}
+	}
+	return tickets, nil
+}
+
+func (s *redisBackend) SaveTicket(scanID, resultID string, ticket types.Ticket) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), ticketsKey(scanID), resultID, data).Err()
+}
+
+func (s *redisBackend) Duplicates(scanID string) ([]types.ScanResult, error) {
+	entries, err := s.client.LRange(context.Background(), duplicatesKey(scanID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeResults(entries, nil), nil
+}
+
+func (s *redisBackend) Delete(scanID string) error {
+	ctx := context.Background()
+	hosts, err := s.client.SMembers(ctx, hostsKey(scanID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{resultsKey(scanID), duplicatesKey(scanID), resultIDsKey(scanID), triageKey(scanID), ticketsKey(scanID), hostsKey(scanID)}
+	for _, severity := range severities {
+		keys = append(keys, severityIndexKey(scanID, severity))
+	}
+	for _, host := range hosts {
+		keys = append(keys, hostKey(scanID, host), hostTemplatesKey(scanID, host), hostPositionsKey(scanID, host))
+	}
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return s.client.HDel(ctx, scansKey, scanID).Err()
+}
+
+func (s *redisBackend) SaveRule(rule types.FPRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), rulesKey, rule.ID, data).Err()
+}
+
+func (s *redisBackend) Rules() ([]types.FPRule, error) {
+	entries, err := s.client.HGetAll(context.Background(), rulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]types.FPRule, 0, len(entries))
+	for _, data := range entries {
+		var rule types.FPRule
+		if json.Unmarshal([]byte(data), &rule) == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (s *redisBackend) DeleteRule(ruleID string) error {
+	deleted, err := s.client.HDel(context.Background(), rulesKey, ruleID).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (s *redisBackend) SaveBlocklist(entries []types.BlocklistEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), blocklistKey, data, 0).Err()
+}
+
+func (s *redisBackend) Blocklist() ([]types.BlocklistEntry, error) {
+	entries := make([]types.BlocklistEntry, 0)
+	data, err := s.client.Get(context.Background(), blocklistKey).Bytes()
+	if err == redis.Nil {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *redisBackend) Close() error {
+	// The client is shared with the orchestrator, which owns it
+	return nil
+}
+
+// decodeResults parses stored result JSON, skipping entries that do not
+// parse. Entries are numbered from seqs when it is given.
+func decodeResults(entries []string, seqs []int64) []types.ScanResult {
+	results := make([]types.ScanResult, 0, len(entries))
+	for i, entry := range entries {
+		var result types.ScanResult
+		if err := json.Unmarshal([]byte(entry), &result); err == nil {
+			if seqs != nil {
+				result.Seq = seqs[i]
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func (s *redisBackend) SaveConfigOverrides(overrides map[string]json.RawMessage) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), configKey, data, 0).Err()
+}
+
+func (s *redisBackend) ConfigOverrides() (map[string]json.RawMessage, error) {
+	overrides := make(map[string]json.RawMessage)
+	data, err := s.client.Get(context.Background(), configKey).Bytes()
+	if err == redis.Nil {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func (s *redisBackend) AppendAudit(entry types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.RPush(context.Background(), auditKey, data).Err()
+}
+
+func (s *redisBackend) AuditLog() ([]types.AuditEntry, error) {
+	values, err := s.client.LRange(context.Background(), auditKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]types.AuditEntry, 0, len(values))
+	for _, value := range values {
+		var entry types.AuditEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PurgeAudit trims the entries recorded before the cutoff from the head of
+// the list. Entries are appended in time order, so trimming stops at the
+// first one to keep, and entries appended meanwhile are past the trim.
+func (s *redisBackend) PurgeAudit(before time.Time) (int, error) {
+	ctx := context.Background()
+	values, err := s.client.LRange(ctx, auditKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, value := range values {
+		var entry types.AuditEntry
+		if err := json.Unmarshal([]byte(value), &entry); err == nil && !entry.Time.Before(before) {
+			break
+		}
+		purged++
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, s.client.LTrim(ctx, auditKey, int64(purged), -1).Err()
+}
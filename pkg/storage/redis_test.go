@@ -0,0 +1,21 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/storage/storagetest"
+)
+
+func TestRedisStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		store, err := storage.New(storage.Config{Backend: "redis", Redis: client})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return store
+	})
+}
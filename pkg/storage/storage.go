@@ -0,0 +1,266 @@
+// Package storage keeps scan records and findings outside process memory.
+// Redis, a directory of JSONL files and PostgreSQL are supported; every
+// backend answers the same queries so the API behaves the same on each.
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"nuclei-distributed/pkg/types"
+)
+
+// ErrNotFound is returned when a scan has no stored record
+var ErrNotFound = errors.New("scan not found in storage")
+
+// ErrResultNotFound is returned when a scan has no finding with a given ID
+var ErrResultNotFound = errors.New("result not found")
+
+// ErrRuleNotFound is returned when no false-positive rule has a given ID
+var ErrRuleNotFound = errors.New("rule not found")
+
+// Store persists scan records and their findings
+type Store interface {
+	// SaveScan creates or replaces a scan's record
+	SaveScan(record types.ScanRecord) error
+	// GetScan returns a scan's record, or ErrNotFound
+	GetScan(scanID string) (*types.ScanRecord, error)
+	// ListScans returns every scan record newest first, without targets,
+	// configuration or log
+	ListScans() ([]types.ScanRecord, error)
+	// AppendResult stores a finding and returns its sequence number. Sequence
+	// numbers start above zero and increase with every finding of a scan, and
+	// a finding is visible to queries once its number is returned.
+	AppendResult(scanID string, result types.ScanResult) (int64, error)
+	// AppendResults stores findings in order at once: a failure stores none
+	// of them, as far as the backend allows
+	AppendResults(scanID string, results []types.ScanResult) error
+	// AppendDuplicate stores a finding the dedup index dropped
+	AppendDuplicate(scanID string, result types.ScanResult) error
+	// QueryResults returns the findings of a scan that match filter, in
+	// ingest order with duplicates last. A PageSize of zero returns every
+	// match on a single page. Results carry their sequence number.
+	QueryResults(scanID string, filter types.ResultFilter) (*types.ResultPage, error)
+	// Hosts returns a summary of every host with findings, in no particular
+	// order. Summaries come from an index kept at ingest and leave out
+	// duplicates.
+	Hosts(scanID string) ([]types.HostSummary, error)
+	// SetTriage records a verdict on a stored finding and returns the finding
+	// as it was before, or ErrResultNotFound
+	SetTriage(scanID, resultID string, triage types.Triage) (*types.ScanResult, error)
+	// GetResult returns one stored finding with its triage and ticket, or
+	// ErrResultNotFound
+	GetResult(scanID, resultID string) (*types.ScanResult, error)
+	// SaveTicket links a stored finding to an issue, or returns
+	// ErrResultNotFound
+	SaveTicket(scanID, resultID string, ticket types.Ticket) error
+	// DeleteScan removes a scan's record and findings
+	DeleteScan(scanID string) error
+	// SaveRule creates or replaces a false-positive rule
+	SaveRule(rule types.FPRule) error
+	// Rules returns every false-positive rule in no particular order
+	Rules() ([]types.FPRule, error)
+	// DeleteRule removes a false-positive rule, or returns ErrRuleNotFound
+	DeleteRule(ruleID string) error
+	// SaveBlocklist replaces the blocklist entries added at runtime
+	SaveBlocklist(entries []types.BlocklistEntry) error
+	// Blocklist returns the blocklist entries added at runtime
+	Blocklist() ([]types.BlocklistEntry, error)
+	// SaveConfigOverrides replaces the server settings changed at runtime,
+	// each top-level ServerConfig field by its JSON name
+	SaveConfigOverrides(overrides map[string]json.RawMessage) error
+	// ConfigOverrides returns the server settings changed at runtime
+	ConfigOverrides() (map[string]json.RawMessage, error)
+	// AppendAudit records a change made through the API
+	AppendAudit(entry types.AuditEntry) error
+	// AuditLog returns every recorded change, oldest first
+	AuditLog() ([]types.AuditEntry, error)
+	// PurgeAudit removes the changes recorded before a time and returns how
+	// many were removed
+	PurgeAudit(before time.Time) (int, error)
+	// Close releases the backend's connections
+	Close() error
+}
+
+// Config selects and configures a storage backend
+type Config struct {
+	// Backend is "redis" (default), "disk" or "postgres"
+	Backend string
+	// Redis is the client the redis backend uses
+	Redis *redis.Client
+	// Dir is the directory the disk backend writes to
+	Dir string
+	// DatabaseURL is the postgres connection string
+	DatabaseURL string
+}
+
+// New opens the backend cfg selects
+func New(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", "redis":
+		return &listStore{backend: &redisBackend{client: cfg.Redis}}, nil
+	case "disk":
+		backend, err := newDiskBackend(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open results directory: %v", err)
+		}
+		return &listStore{backend: backend}, nil
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("postgres storage needs a database URL")
+		}
+		return newPostgresStore(cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// severities are the values findings are indexed and counted under
+var severities = []string{"info", "low", "medium", "high", "critical", "unknown"}
+
+// IndexedSeverity folds a finding's severity onto the indexed severities, so
+// findings with an empty or unexpected severity count as unknown
+func IndexedSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	for _, indexed := range severities {
+		if severity == indexed {
+			return severity
+		}
+	}
+	return "unknown"
+}
+
+// SeverityRank orders indexed severities from least to most severe
+func SeverityRank(severity string) int {
+	switch severity {
+	case "critical":
+		return 5
+	case "high":
+		return 4
+	case "medium":
+		return 3
+	case "low":
+		return 2
+	case "info":
+		return 1
+	}
+	return 0
+}
+
+// addToSummary folds a finding into its host's summary
+func addToSummary(summary *types.HostSummary, result *types.ScanResult) {
+	severity := IndexedSeverity(result.Severity)
+	if summary.SeverityCounts == nil {
+		summary.Host = result.Host
+		summary.SeverityCounts = make(map[string]int)
+		summary.FirstSeen = result.Timestamp
+		summary.LastSeen = result.Timestamp
+	}
+	summary.ResultCount++
+	summary.SeverityCounts[severity]++
+	if summary.HighestSeverity == "" || SeverityRank(severity) > SeverityRank(summary.HighestSeverity) {
+		summary.HighestSeverity = severity
+	}
+	summary.Templates = insertSorted(summary.Templates, result.Template)
+	if result.Timestamp.Before(summary.FirstSeen) {
+		summary.FirstSeen = result.Timestamp
+	}
+	if result.Timestamp.After(summary.LastSeen) {
+		summary.LastSeen = result.Timestamp
+	}
+}
+
+// insertSorted adds value to a sorted list unless it is already there
+func insertSorted(list []string, value string) []string {
+	i := sort.SearchStrings(list, value)
+	if i < len(list) && list[i] == value {
+		return list
+	}
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = value
+	return list
+}
+
+// highestSeverity returns the most severe severity with findings
+func highestSeverity(counts map[string]int) string {
+	highest := ""
+	for severity, count := range counts {
+		if count > 0 && (highest == "" || SeverityRank(severity) > SeverityRank(highest)) {
+			highest = severity
+		}
+	}
+	return highest
+}
+
+// matchesFilter reports whether a result passes every set field of filter
+func matchesFilter(result *types.ScanResult, filter *types.ResultFilter) bool {
+	if len(filter.Severities) > 0 {
+		matched := false
+		for _, severity := range filter.Severities {
+			if strings.EqualFold(result.Severity, severity) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if filter.Template != "" && result.Template != filter.Template {
+		return false
+	}
+
+	if filter.AssetHost != "" && result.Host != filter.AssetHost {
+		return false
+	}
+
+	if filter.Host != "" && !strings.Contains(strings.ToLower(result.Host), strings.ToLower(filter.Host)) {
+		return false
+	}
+
+	if !filter.Since.IsZero() && result.Timestamp.Before(filter.Since) {
+		return false
+	}
+
+	if filter.ExcludeFalsePositives && result.TriageStatus() == types.TriageFalsePositive {
+		return false
+	}
+
+	return true
+}
+
+// nextCursor is the cursor that follows a page: its last result when more
+// matches follow, otherwise latest, the highest sequence number the query saw
+func nextCursor(page *types.ResultPage, latest int64) string {
+	cursor := latest
+	if page.PageSize > 0 && page.Matched > page.Page*page.PageSize {
+		last := int64(0)
+		for _, result := range page.Results {
+			if result.Seq > last {
+				last = result.Seq
+			}
+		}
+		if last > 0 {
+			cursor = last
+		}
+	}
+	return strconv.FormatInt(cursor, 10)
+}
+
+// newPage returns an empty result page for filter
+func newPage(filter types.ResultFilter, total int) *types.ResultPage {
+	return &types.ResultPage{
+		Results:      make([]types.ScanResult, 0),
+		TotalResults: total,
+		Page:         filter.Page,
+		PageSize:     filter.PageSize,
+	}
+}
@@ -0,0 +1,493 @@
+// Package storagetest checks that a storage backend answers queries the way
+// the API relies on every backend to. Each backend's tests run Run against
+// stores of their own.
+package storagetest
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"nuclei-distributed/pkg/storage"
+	"nuclei-distributed/pkg/types"
+)
+
+// Run runs the conformance tests against the stores open returns. Each test
+// works on scans of its own, so open may hand out one shared database.
+func Run(t *testing.T, open func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{"Records", testRecords},
+		{"SequenceCursors", testSequenceCursors},
+		{"PagedCursors", testPagedCursors},
+		{"SeverityIndex", testSeverityIndex},
+		{"HostIndex", testHostIndex},
+		{"Duplicates", testDuplicates},
+		{"Triage", testTriage},
+		{"DeleteScan", testDeleteScan},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := open(t)
+			t.Cleanup(func() { store.Close() })
+			tt.test(t, store)
+		})
+	}
+}
+
+// baseTime is when the first test finding was found
+var baseTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// finding returns a normalized finding found minute minutes after baseTime
+func finding(host, template, severity string, minute int) types.ScanResult {
+	result := types.ScanResult{
+		Host:      host,
+		Template:  template,
+		Severity:  severity,
+		MatchedAt: "https://" + host + "/" + template,
+		Timestamp: baseTime.Add(time.Duration(minute) * time.Minute),
+		WorkerID:  "worker-0",
+	}
+	result.ID = result.ResultID()
+	return result
+}
+
+// newScan saves the record of a fresh scan and returns its ID
+func newScan(t *testing.T, store storage.Store) string {
+	t.Helper()
+	scanID := uuid.New().String()
+	if err := store.SaveScan(types.ScanRecord{ID: scanID, Status: "running", CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatalf("SaveScan() error = %v", err)
+	}
+	return scanID
+}
+
+// appendAll stores findings one at a time and returns their sequence numbers
+func appendAll(t *testing.T, store storage.Store, scanID string, results ...types.ScanResult) []int64 {
+	t.Helper()
+	seqs := make([]int64, len(results))
+	for i, result := range results {
+		seq, err := store.AppendResult(scanID, result)
+		if err != nil {
+			t.Fatalf("AppendResult() error = %v", err)
+		}
+		seqs[i] = seq
+	}
+	return seqs
+}
+
+// query runs QueryResults and fails the test on error
+func query(t *testing.T, store storage.Store, scanID string, filter types.ResultFilter) *types.ResultPage {
+	t.Helper()
+	page, err := store.QueryResults(scanID, filter)
+	if err != nil {
+		t.Fatalf("QueryResults(%+v) error = %v", filter, err)
+	}
+	return page
+}
+
+// templatesOf lists the templates of results in order
+func templatesOf(results []types.ScanResult) []string {
+	templates := make([]string, len(results))
+	for i, result := range results {
+		templates[i] = result.Template
+	}
+	return templates
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func cursorSeq(t *testing.T, page *types.ResultPage) int64 {
+	t.Helper()
+	seq, err := strconv.ParseInt(page.NextCursor, 10, 64)
+	if err != nil {
+		t.Fatalf("NextCursor = %q, want a sequence number", page.NextCursor)
+	}
+	return seq
+}
+
+func testRecords(t *testing.T, store storage.Store) {
+	if _, err := store.GetScan(uuid.New().String()); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetScan() of an unknown scan error = %v, want ErrNotFound", err)
+	}
+
+	older := types.ScanRecord{ID: uuid.New().String(), Status: "completed", CreatedAt: time.Now().UTC().Add(-time.Hour),
+		Targets: []string{"example.com"}}
+	newer := types.ScanRecord{ID: uuid.New().String(), Status: "running", CreatedAt: time.Now().UTC(),
+		Targets: []string{"example.org"}}
+	for _, record := range []types.ScanRecord{older, newer} {
+		if err := store.SaveScan(record); err != nil {
+			t.Fatalf("SaveScan() error = %v", err)
+		}
+	}
+
+	older.Status = "archived"
+	if err := store.SaveScan(older); err != nil {
+		t.Fatalf("SaveScan() error = %v", err)
+	}
+	got, err := store.GetScan(older.ID)
+	if err != nil {
+		t.Fatalf("GetScan() error = %v", err)
+	}
+	if got.Status != "archived" || !equalStrings(got.Targets, older.Targets) {
+		t.Errorf("GetScan() = %+v, want the replaced record with its targets", got)
+	}
+
+	records, err := store.ListScans()
+	if err != nil {
+		t.Fatalf("ListScans() error = %v", err)
+	}
+	positions := make(map[string]int)
+	for i, record := range records {
+		positions[record.ID] = i
+		if record.ID == older.ID && record.Targets != nil {
+			t.Errorf("ListScans() kept the targets of %s", record.ID)
+		}
+	}
+	newerAt, hasNewer := positions[newer.ID]
+	olderAt, hasOlder := positions[older.ID]
+	if !hasNewer || !hasOlder || newerAt > olderAt {
+		t.Errorf("ListScans() has the newer scan at %d and the older at %d, want newest first", newerAt, olderAt)
+	}
+}
+
+func testSequenceCursors(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+
+	seqs := appendAll(t, store, scanID,
+		finding("a.example.com", "t1", "info", 0),
+		finding("b.example.com", "t2", "high", 1),
+	)
+	if err := store.AppendResults(scanID, []types.ScanResult{
+		finding("c.example.com", "t3", "low", 2),
+		finding("d.example.com", "t4", "medium", 3),
+	}); err != nil {
+		t.Fatalf("AppendResults() error = %v", err)
+	}
+	if seqs[0] <= 0 || seqs[1] <= seqs[0] {
+		t.Fatalf("AppendResult() returned %v, want increasing sequence numbers above zero", seqs)
+	}
+
+	page := query(t, store, scanID, types.ResultFilter{})
+	if page.TotalResults != 4 || page.Matched != 4 {
+		t.Fatalf("TotalResults = %d and Matched = %d, want 4 and 4", page.TotalResults, page.Matched)
+	}
+	if want := []string{"t1", "t2", "t3", "t4"}; !equalStrings(templatesOf(page.Results), want) {
+		t.Fatalf("results = %v, want ingest order %v", templatesOf(page.Results), want)
+	}
+	for i, result := range page.Results {
+		if i < len(seqs) && result.Seq != seqs[i] {
+			t.Errorf("result %d has Seq %d, AppendResult returned %d", i, result.Seq, seqs[i])
+		}
+		if i > 0 && result.Seq <= page.Results[i-1].Seq {
+			t.Errorf("result %d has Seq %d after %d, want increasing", i, result.Seq, page.Results[i-1].Seq)
+		}
+	}
+	cursor := cursorSeq(t, page)
+	if last := page.Results[3].Seq; cursor != last {
+		t.Errorf("NextCursor = %d, want the last sequence number %d", cursor, last)
+	}
+
+	// Nothing new after the cursor, which stays where it is
+	page = query(t, store, scanID, types.ResultFilter{AfterSeq: cursor})
+	if len(page.Results) != 0 || page.Matched != 0 || cursorSeq(t, page) != cursor {
+		t.Errorf("query after the cursor = %d results, %d matched, cursor %s; want none at %d",
+			len(page.Results), page.Matched, page.NextCursor, cursor)
+	}
+
+	// Only what was appended since
+	appendAll(t, store, scanID, finding("e.example.com", "t5", "critical", 4))
+	if err := store.AppendResults(scanID, []types.ScanResult{finding("f.example.com", "t6", "info", 5)}); err != nil {
+		t.Fatalf("AppendResults() error = %v", err)
+	}
+	page = query(t, store, scanID, types.ResultFilter{AfterSeq: cursor})
+	if want := []string{"t5", "t6"}; !equalStrings(templatesOf(page.Results), want) {
+		t.Errorf("results after the cursor = %v, want %v", templatesOf(page.Results), want)
+	}
+	if page.TotalResults != 6 || page.Matched != 2 {
+		t.Errorf("TotalResults = %d and Matched = %d after the cursor, want 6 and 2", page.TotalResults, page.Matched)
+	}
+	if next := cursorSeq(t, page); next != page.Results[len(page.Results)-1].Seq {
+		t.Errorf("NextCursor = %d, want the last sequence number %d", next, page.Results[len(page.Results)-1].Seq)
+	}
+
+	// A filtered query still moves the cursor past what it looked at
+	page = query(t, store, scanID, types.ResultFilter{AfterSeq: cursor, Severities: []string{"high"}})
+	if len(page.Results) != 0 {
+		t.Errorf("high findings after the cursor = %v, want none", templatesOf(page.Results))
+	}
+	if next := cursorSeq(t, page); next <= cursor {
+		t.Errorf("NextCursor = %d after a filtered query, want past %d", next, cursor)
+	}
+}
+
+func testPagedCursors(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	for i := 0; i < 5; i++ {
+		appendAll(t, store, scanID, finding("example.com", "t"+strconv.Itoa(i), "info", i))
+	}
+
+	var seen []string
+	cursor := int64(0)
+	for pages := 0; pages < 5; pages++ {
+		page := query(t, store, scanID, types.ResultFilter{AfterSeq: cursor, Page: 1, PageSize: 2})
+		if len(page.Results) == 0 {
+			break
+		}
+		seen = append(seen, templatesOf(page.Results)...)
+		next := cursorSeq(t, page)
+		if next != page.Results[len(page.Results)-1].Seq {
+			t.Errorf("NextCursor = %d, want the page's last sequence number %d", next,
+				page.Results[len(page.Results)-1].Seq)
+		}
+		cursor = next
+	}
+	if want := []string{"t0", "t1", "t2", "t3", "t4"}; !equalStrings(seen, want) {
+		t.Errorf("paging by cursor saw %v, want %v", seen, want)
+	}
+
+	page := query(t, store, scanID, types.ResultFilter{Page: 3, PageSize: 2})
+	if page.Matched != 5 || !equalStrings(templatesOf(page.Results), []string{"t4"}) {
+		t.Errorf("page 3 = %v of %d matched, want [t4] of 5", templatesOf(page.Results), page.Matched)
+	}
+	page = query(t, store, scanID, types.ResultFilter{Page: 4, PageSize: 2})
+	if len(page.Results) != 0 || page.Matched != 5 {
+		t.Errorf("page 4 = %v of %d matched, want none of 5", templatesOf(page.Results), page.Matched)
+	}
+}
+
+func testSeverityIndex(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	appendAll(t, store, scanID,
+		finding("a.example.com", "t1", "info", 0),
+		finding("a.example.com", "t2", "critical", 1),
+		finding("b.example.com", "t3", "high", 2),
+		finding("b.example.com", "t4", "info", 3),
+		finding("c.example.com", "t5", "unknown", 4),
+	)
+	if err := store.AppendResults(scanID, []types.ScanResult{
+		finding("c.example.com", "t6", "high", 5),
+	}); err != nil {
+		t.Fatalf("AppendResults() error = %v", err)
+	}
+
+	tests := []struct {
+		severities []string
+		want       []string
+	}{
+		{[]string{"high"}, []string{"t3", "t6"}},
+		{[]string{"critical", "high"}, []string{"t2", "t3", "t6"}},
+		{[]string{"HIGH", "Critical"}, []string{"t2", "t3", "t6"}},
+		{[]string{"info", "info"}, []string{"t1", "t4"}},
+		{[]string{"unknown"}, []string{"t5"}},
+		{[]string{"low"}, []string{}},
+	}
+	for _, tt := range tests {
+		page := query(t, store, scanID, types.ResultFilter{Severities: tt.severities})
+		if !equalStrings(templatesOf(page.Results), tt.want) || page.Matched != len(tt.want) {
+			t.Errorf("severities %v = %v of %d matched, want %v", tt.severities, templatesOf(page.Results),
+				page.Matched, tt.want)
+		}
+		if page.TotalResults != 6 {
+			t.Errorf("severities %v: TotalResults = %d, want 6", tt.severities, page.TotalResults)
+		}
+	}
+
+	// Paged through the index
+	page := query(t, store, scanID, types.ResultFilter{Severities: []string{"high", "critical"}, Page: 2, PageSize: 2})
+	if page.Matched != 3 || !equalStrings(templatesOf(page.Results), []string{"t6"}) {
+		t.Errorf("page 2 of high and critical = %v of %d matched, want [t6] of 3", templatesOf(page.Results), page.Matched)
+	}
+
+	// Combined with the host index and other filters
+	page = query(t, store, scanID, types.ResultFilter{Severities: []string{"info"}, AssetHost: "b.example.com"})
+	if !equalStrings(templatesOf(page.Results), []string{"t4"}) {
+		t.Errorf("info findings on b.example.com = %v, want [t4]", templatesOf(page.Results))
+	}
+	page = query(t, store, scanID, types.ResultFilter{Severities: []string{"high"}, Template: "t6"})
+	if !equalStrings(templatesOf(page.Results), []string{"t6"}) {
+		t.Errorf("high findings of t6 = %v, want [t6]", templatesOf(page.Results))
+	}
+}
+
+func testHostIndex(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	appendAll(t, store, scanID,
+		finding("api.example.com", "t2", "low", 0),
+		finding("www.example.com", "t1", "medium", 1),
+		finding("api.example.com", "t1", "high", 2),
+	)
+	if err := store.AppendResults(scanID, []types.ScanResult{
+		finding("api.example.com", "t3", "low", 3),
+		finding("example.org", "t1", "info", 4),
+	}); err != nil {
+		t.Fatalf("AppendResults() error = %v", err)
+	}
+	// Duplicates stay out of the host index
+	if err := store.AppendDuplicate(scanID, finding("api.example.com", "t1", "high", 5)); err != nil {
+		t.Fatalf("AppendDuplicate() error = %v", err)
+	}
+
+	hosts, err := store.Hosts(scanID)
+	if err != nil {
+		t.Fatalf("Hosts() error = %v", err)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	if len(hosts) != 3 {
+		t.Fatalf("Hosts() returned %d hosts, want 3", len(hosts))
+	}
+	api := hosts[0]
+	if api.Host != "api.example.com" || api.ResultCount != 3 || api.HighestSeverity != "high" {
+		t.Errorf("summary = %+v, want api.example.com with 3 findings, highest high", api)
+	}
+	if api.SeverityCounts["low"] != 2 || api.SeverityCounts["high"] != 1 {
+		t.Errorf("severity counts of api.example.com = %v, want 2 low and 1 high", api.SeverityCounts)
+	}
+	if !equalStrings(api.Templates, []string{"t1", "t2", "t3"}) {
+		t.Errorf("templates of api.example.com = %v, want [t1 t2 t3]", api.Templates)
+	}
+	if !api.FirstSeen.Equal(baseTime) || !api.LastSeen.Equal(baseTime.Add(3*time.Minute)) {
+		t.Errorf("api.example.com seen from %v to %v, want %v to %v", api.FirstSeen, api.LastSeen, baseTime,
+			baseTime.Add(3*time.Minute))
+	}
+	if hosts[1].Host != "example.org" || hosts[1].ResultCount != 1 || hosts[1].HighestSeverity != "info" {
+		t.Errorf("summary = %+v, want example.org with 1 finding, highest info", hosts[1])
+	}
+
+	// AssetHost is exact, Host is a case-insensitive substring
+	page := query(t, store, scanID, types.ResultFilter{AssetHost: "api.example.com"})
+	if want := []string{"t2", "t1", "t3"}; !equalStrings(templatesOf(page.Results), want) || page.Matched != 3 {
+		t.Errorf("findings on api.example.com = %v of %d matched, want %v", templatesOf(page.Results), page.Matched, want)
+	}
+	page = query(t, store, scanID, types.ResultFilter{AssetHost: "example.com"})
+	if len(page.Results) != 0 {
+		t.Errorf("findings on example.com = %v, want none", templatesOf(page.Results))
+	}
+	page = query(t, store, scanID, types.ResultFilter{Host: "EXAMPLE.COM"})
+	if want := []string{"t2", "t1", "t1", "t3"}; !equalStrings(templatesOf(page.Results), want) {
+		t.Errorf("findings on hosts containing EXAMPLE.COM = %v, want %v", templatesOf(page.Results), want)
+	}
+
+	// The host index after a cursor and across pages
+	first := query(t, store, scanID, types.ResultFilter{AssetHost: "api.example.com", Page: 1, PageSize: 2})
+	if first.Matched != 3 || !equalStrings(templatesOf(first.Results), []string{"t2", "t1"}) {
+		t.Errorf("page 1 on api.example.com = %v of %d matched, want [t2 t1] of 3", templatesOf(first.Results), first.Matched)
+	}
+	page = query(t, store, scanID, types.ResultFilter{AssetHost: "api.example.com", AfterSeq: cursorSeq(t, first)})
+	if !equalStrings(templatesOf(page.Results), []string{"t3"}) {
+		t.Errorf("findings on api.example.com after the cursor = %v, want [t3]", templatesOf(page.Results))
+	}
+}
+
+func testDuplicates(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	original := finding("example.com", "t1", "high", 0)
+	appendAll(t, store, scanID, original, finding("example.com", "t2", "low", 1))
+
+	duplicate := original
+	duplicate.WorkerID = "worker-1"
+	duplicate.Timestamp = baseTime.Add(2 * time.Minute)
+	if err := store.AppendDuplicate(scanID, duplicate); err != nil {
+		t.Fatalf("AppendDuplicate() error = %v", err)
+	}
+
+	page := query(t, store, scanID, types.ResultFilter{})
+	if page.TotalResults != 2 || len(page.Results) != 2 {
+		t.Fatalf("TotalResults = %d with %d results, want duplicates left out", page.TotalResults, len(page.Results))
+	}
+
+	page = query(t, store, scanID, types.ResultFilter{IncludeDuplicates: true})
+	if page.TotalResults != 2 || page.Matched != 3 || len(page.Results) != 3 {
+		t.Fatalf("TotalResults = %d, Matched = %d with %d results, want 2, 3 and 3", page.TotalResults, page.Matched,
+			len(page.Results))
+	}
+	last := page.Results[2]
+	if !last.Duplicate || last.WorkerID != "worker-1" || last.ID != original.ID {
+		t.Errorf("last result = %+v, want the duplicate", last)
+	}
+	for _, result := range page.Results[:2] {
+		if result.Duplicate {
+			t.Errorf("result %s from %s is marked a duplicate", result.Template, result.WorkerID)
+		}
+	}
+
+	// Duplicates are not new findings for a cursor
+	first := query(t, store, scanID, types.ResultFilter{})
+	page = query(t, store, scanID, types.ResultFilter{AfterSeq: first.Results[0].Seq, IncludeDuplicates: true})
+	if !equalStrings(templatesOf(page.Results), []string{"t2"}) {
+		t.Errorf("results after the cursor = %v, want [t2] without duplicates", templatesOf(page.Results))
+	}
+}
+
+func testTriage(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	noise := finding("example.com", "t1", "medium", 0)
+	confirmed := finding("example.com", "t2", "high", 1)
+	appendAll(t, store, scanID, noise, confirmed)
+
+	if _, err := store.SetTriage(scanID, "0000000000000000", types.Triage{Status: types.TriageFalsePositive}); !errors.Is(err, storage.ErrResultNotFound) {
+		t.Errorf("SetTriage() of an unknown finding error = %v, want ErrResultNotFound", err)
+	}
+	previous, err := store.SetTriage(scanID, noise.ID, types.Triage{Status: types.TriageFalsePositive, UpdatedAt: baseTime})
+	if err != nil {
+		t.Fatalf("SetTriage() error = %v", err)
+	}
+	if previous.ID != noise.ID || previous.Triage != nil {
+		t.Errorf("SetTriage() returned %+v, want the untriaged finding", previous)
+	}
+
+	got, err := store.GetResult(scanID, noise.ID)
+	if err != nil {
+		t.Fatalf("GetResult() error = %v", err)
+	}
+	if got.TriageStatus() != types.TriageFalsePositive {
+		t.Errorf("GetResult() triage = %q, want %q", got.TriageStatus(), types.TriageFalsePositive)
+	}
+
+	page := query(t, store, scanID, types.ResultFilter{ExcludeFalsePositives: true})
+	if !equalStrings(templatesOf(page.Results), []string{"t2"}) || page.Matched != 1 {
+		t.Errorf("findings without false positives = %v of %d matched, want [t2] of 1", templatesOf(page.Results), page.Matched)
+	}
+	page = query(t, store, scanID, types.ResultFilter{Severities: []string{"medium"}, ExcludeFalsePositives: true})
+	if len(page.Results) != 0 {
+		t.Errorf("medium findings without false positives = %v, want none", templatesOf(page.Results))
+	}
+}
+
+func testDeleteScan(t *testing.T, store storage.Store) {
+	scanID := newScan(t, store)
+	kept := newScan(t, store)
+	appendAll(t, store, scanID, finding("example.com", "t1", "high", 0))
+	appendAll(t, store, kept, finding("example.com", "t1", "high", 0))
+
+	if err := store.DeleteScan(scanID); err != nil {
+		t.Fatalf("DeleteScan() error = %v", err)
+	}
+	if _, err := store.GetScan(scanID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetScan() after DeleteScan error = %v, want ErrNotFound", err)
+	}
+	if page := query(t, store, scanID, types.ResultFilter{}); page.TotalResults != 0 || len(page.Results) != 0 {
+		t.Errorf("deleted scan still has %d findings", page.TotalResults)
+	}
+	if hosts, err := store.Hosts(scanID); err != nil || len(hosts) != 0 {
+		t.Errorf("Hosts() of the deleted scan = %v, %v; want none", hosts, err)
+	}
+	if page := query(t, store, kept, types.ResultFilter{}); page.TotalResults != 1 {
+		t.Errorf("other scan has %d findings after the delete, want 1", page.TotalResults)
+	}
+}
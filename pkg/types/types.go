@@ -1,55 +1,1187 @@
 package types
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
 
 // ScanRequest represents a scan request from the frontend
 type ScanRequest struct {
-	ID       string   `json:"id"`
-	Domains  []string `json:"domains"`
-	Droplets int      `json:"droplets"`
-	Status   string   `json:"status"`
+	ID            string          `json:"id"`
+	Domains       []string        `json:"domains"`
+	Droplets      int             `json:"droplets"`
+	Status        string          `json:"status"`
+	DropletConfig *DropletConfig  `json:"dropletConfig,omitempty"`
+	Headless      bool            `json:"headless"`                // install chromium and run headless templates
+	Probe         bool            `json:"probe"`                   // run httpx first and only scan live hosts
+	ExtraFlags    []string        `json:"extraFlags,omitempty"`    // allowlisted nuclei flags, e.g. "-timeout=10"
+	Severity      string          `json:"severity,omitempty"`      // comma-separated severities passed to -severity
+	RateLimit     int             `json:"rateLimit,omitempty"`     // requests per second passed to -rate-limit
+	ConfigYAML    string          `json:"configYaml,omitempty"`    // nuclei config file handed to workers, allowlisted keys only
+	MaxHostErrors int             `json:"maxHostErrors,omitempty"` // errors before nuclei skips a host, 0 uses the server default
+	Timeout       int             `json:"timeout,omitempty"`       // per-request timeout in seconds, 0 uses the server default
+	Retries       *int            `json:"retries,omitempty"`       // request retries, 0 for none; unset uses the server default
+	Webhooks      []WebhookConfig `json:"webhooks,omitempty"`      // replaces the server's default webhooks when set
+	Slack         *SlackConfig    `json:"slack,omitempty"`         // overrides the server's Slack settings
+	Discord       *DiscordConfig  `json:"discord,omitempty"`       // overrides the server's Discord settings
+	Notifiers     []string        `json:"notifiers,omitempty"`     // webhook, slack, discord, email or none; empty uses every configured notifier
+	NotifyEmails  []string        `json:"notifyEmails,omitempty"`  // report recipients, replacing the server's default list when set
+	OpenSearch    *bool           `json:"opensearch,omitempty"`    // forward findings to OpenSearch, unset follows the server setting
+	// Notifications routes the scan's events to named channels, each with its
+	// own threshold. When set it replaces Notifiers.
+	Notifications []NotificationRoute `json:"notifications,omitempty"`
+	// AllowLargeExpansion lets CIDR blocks and IP ranges expand to more than
+	// 65536 addresses
+	AllowLargeExpansion bool `json:"allowLargeExpansion,omitempty"`
+	// RerunOf is the scan this one runs again, set by the rerun endpoint
+	RerunOf string `json:"rerunOf,omitempty"`
+	// Optimizer narrows how the targets are spread across droplets, within
+	// the server's limits
+	Optimizer *OptimizerLimits `json:"optimizer,omitempty"`
+	// KeepOrder hands workers the targets in the order submitted instead of
+	// shuffled
+	KeepOrder bool `json:"keepOrder,omitempty"`
+	// GroupByApex keeps the names under each registered domain on one
+	// worker, so per-host rate limits hold; it replaces the shuffle
+	GroupByApex bool `json:"groupByApex,omitempty"`
+	// Distribution is how workers get their targets: static, the default,
+	// hands each worker a fixed chunk up front; pull has workers lease
+	// batches from a shared pool until it is drained
+	Distribution string `json:"distribution,omitempty"`
+	// BatchSize is the number of targets a pull worker leases at a time, 0
+	// uses the server default
+	BatchSize int `json:"batchSize,omitempty"`
+	// Mode picks the droplet count: balanced, the default, follows
+	// Droplets; fast takes as many droplets as the limits allow; cheap the
+	// fewest that finish within DeadlineMinutes, or the fewest allowed
+	Mode            string `json:"mode,omitempty"`
+	DeadlineMinutes int    `json:"deadlineMinutes,omitempty"` // cheap mode only
+	// HoldUntilRelease has workers come up without sending any traffic
+	// until POST /scan/:id/release, so their source IPs can be allowlisted
+	// first
+	HoldUntilRelease bool `json:"holdUntilRelease,omitempty"`
+	// Preflight resolves the targets, and probes them as the server's
+	// preflight settings say, before any droplet is created; dead ones are
+	// left out of the scan
+	Preflight bool `json:"preflight,omitempty"`
+	// Expand enumerates the subdomains of each submitted apex domain and
+	// scans them along with the submitted targets. The scan is planned
+	// once enumeration finishes.
+	Expand bool `json:"expand,omitempty"`
+}
+
+// OptimizerLimits bound how a scan's targets are spread across droplets. In
+// a scan request, unset fields keep the server's values, and the maximums
+// may not go above the server's.
+type OptimizerLimits struct {
+	MinDroplets          int `json:"minDroplets,omitempty"`
+	MaxDroplets          int `json:"maxDroplets,omitempty"`
+	MinDomainsPerDroplet int `json:"minDomainsPerDroplet,omitempty"`
+	MaxDomainsPerDroplet int `json:"maxDomainsPerDroplet,omitempty"`
+}
+
+// DropletCapacity is how many targets a droplet size is planned to take and
+// how many it scans per hour
+type DropletCapacity struct {
+	MaxDomainsPerDroplet int     `json:"maxDomainsPerDroplet"`
+	DomainsPerHour       float64 `json:"domainsPerHour"`
+}
+
+// ThroughputSample is the throughput a completed scan measured: targets
+// its workers scanned per hour of running nuclei
+type ThroughputSample struct {
+	ScanID                string    `json:"scanId"`
+	DropletSize           string    `json:"dropletSize"`
+	TemplateSet           string    `json:"templateSet"`
+	Domains               int       `json:"domains"`
+	DropletHours          float64   `json:"dropletHours"`
+	DomainsPerDropletHour float64   `json:"domainsPerDropletHour"`
+	CompletedAt           time.Time `json:"completedAt"`
+}
+
+// ThroughputProfile holds the recent samples of scans on one droplet size
+// with one template set, newest first
+type ThroughputProfile struct {
+	DropletSize string             `json:"dropletSize"`
+	TemplateSet string             `json:"templateSet"`
+	Samples     []ThroughputSample `json:"samples"`
+	// MedianDomainsPerHour is the median of the samples, which plans use
+	// once there are enough of them
+	MedianDomainsPerHour float64 `json:"medianDomainsPerHour"`
+	UsedForPlanning      bool    `json:"usedForPlanning"`
+}
+
+// RerunRequest overrides settings of a scan that is run again. Template
+// selections replace the stored flags of the same kind.
+type RerunRequest struct {
+	Droplets    *int     `json:"droplets,omitempty"`
+	Tags        []string `json:"tags,omitempty"`        // passed to -tags
+	TemplateIDs []string `json:"templateIds,omitempty"` // passed to -template-id
+}
+
+// NotificationRoute sends a scan's events to one configured channel
+type NotificationRoute struct {
+	Channel     string `json:"channel"`               // slack, discord, webhook or email
+	MinSeverity string `json:"minSeverity,omitempty"` // lowest severity sent; empty keeps the channel's threshold
+}
+
+// SlackConfig selects the Slack incoming webhook findings are posted to
+type SlackConfig struct {
+	WebhookURL  string `json:"webhookUrl,omitempty"`
+	MinSeverity string `json:"minSeverity,omitempty"` // lowest severity posted, defaults to high
+}
+
+// WebhookConfig describes an endpoint notified about scan events
+type WebhookConfig struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret,omitempty"`      // HMAC-SHA256 key for the X-Nuclei-Signature header
+	Events      []string `json:"events,omitempty"`      // scan_started, worker_failed, new_result, scan_complete, alert; empty means all
+	MinSeverity string   `json:"minSeverity,omitempty"` // lowest severity that triggers new_result
+}
+
+// DiscordConfig selects the Discord webhook findings are posted to
+type DiscordConfig struct {
+	WebhookURL  string `json:"webhookUrl,omitempty"`
+	MinSeverity string `json:"minSeverity,omitempty"` // lowest severity posted, defaults to high
+}
+
+// ErrorResponse is the body of every failed API request
+type ErrorResponse struct {
+	Code    string      `json:"code"` // machine-readable, e.g. SCAN_NOT_FOUND
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// WebhookStatus reports delivery state for one of a scan's webhooks
+type WebhookStatus struct {
+	URL         string           `json:"url"`
+	Events      []string         `json:"events,omitempty"`
+	MinSeverity string           `json:"minSeverity,omitempty"`
+	Delivered   int              `json:"delivered"`
+	Failed      int              `json:"failed"`
+	Pending     int              `json:"pending"`
+	LastAttempt time.Time        `json:"lastAttempt,omitempty"`
+	Failures    []WebhookFailure `json:"failures,omitempty"` // most recent failures, oldest first
+}
+
+// WebhookFailure records an event that could not be delivered
+type WebhookFailure struct {
+	Event     string    `json:"event"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ForwarderHealth reports the state of the OpenSearch result exporter
+type ForwarderHealth struct {
+	Status        string     `json:"status"` // ok, or degraded while bulk requests fail
+	Indexed       int64      `json:"indexed"`
+	Queued        int        `json:"queued"`
+	Dropped       int64      `json:"dropped"` // documents that did not fit in the queue
+	DeadLetters   int        `json:"deadLetters"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+}
+
+// DeadLetter is a document the exporter gave up on
+type DeadLetter struct {
+	Index    string          `json:"index"`
+	ID       string          `json:"id"`
+	Document json.RawMessage `json:"document"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// ScanSummary is a compact view of a scan used in notifications
+type ScanSummary struct {
+	ID                string         `json:"id"`
+	Status            string         `json:"status"`
+	TotalDomains      int            `json:"totalDomains"`
+	ScannedDomains    int            `json:"scannedDomains"`
+	Workers           int            `json:"workers"`
+	FailedWorkers     int            `json:"failedWorkers"`
+	Results           int            `json:"results"`
+	Severities        map[string]int `json:"severities"`
+	DuplicatesDropped int            `json:"duplicatesDropped"`
+	// Degraded is set when the scan ended degraded or failed, so automation
+	// can decide to run it again; FailureSummary says why
+	Degraded       bool            `json:"degraded"`
+	FailureSummary *FailureSummary `json:"failureSummary,omitempty"`
+}
+
+// FailureSummary explains why a scan ended degraded or failed under the
+// failure policy
+type FailureSummary struct {
+	Workers          int      `json:"workers"`
+	FailedWorkers    int      `json:"failedWorkers"` // including those that never came up
+	Targets          int      `json:"targets"`
+	UncoveredTargets int      `json:"uncoveredTargets"`
+	Reasons          []string `json:"reasons"`
+}
+
+// WorkerFailure describes a worker that stopped without finishing its chunk
+type WorkerFailure struct {
+	WorkerID string `json:"workerId"`
+	Reason   string `json:"reason"`
+}
+
+// Alert reports a server-wide failure to operators, as opposed to a scan
+// event. Suppressed counts the alerts of the same type held back by its
+// cooldown since the last one was sent.
+type Alert struct {
+	Type       string            `json:"type"`
+	Message    string            `json:"message"`
+	Details    map[string]string `json:"details,omitempty"`
+	Suppressed int               `json:"suppressed,omitempty"`
 }
 
 // WorkerStatus represents the status of a worker droplet
 type WorkerStatus struct {
 	ID             string    `json:"id"`
 	IP             string    `json:"ip"`
+	IPv6           string    `json:"ipv6,omitempty"`
 	Progress       float64   `json:"progress"`
 	CurrentDomain  string    `json:"currentDomain"`
 	DomainsScanned int       `json:"domainsScanned"`
 	TotalDomains   int       `json:"totalDomains"`
+	DomainsAlive   int       `json:"domainsAlive"` // equals TotalDomains unless the httpx probe ran
 	Logs           []Log     `json:"logs"`
 	CreatedAt      time.Time `json:"createdAt"`
-	Status         string    `json:"status"` // running, completed, failed
+	Status         string    `json:"status"`       // provisioning, starting, running, stalled, completed, failed
+	RestartCount   int       `json:"restartCount"` // nuclei restarts after crashes or reboots
+	// Telemetry is the latest resource sample from the worker's heartbeats,
+	// TelemetryHistory the last few, oldest first
+	Telemetry        *WorkerTelemetry  `json:"telemetry,omitempty"`
+	TelemetryHistory []WorkerTelemetry `json:"telemetryHistory,omitempty"`
+	// Degraded says why the worker is short of memory or disk, empty while
+	// it is not
+	Degraded string `json:"degraded,omitempty"`
 }
 
+// WorkerTelemetry is a sample of a worker's resources and nuclei counters
+type WorkerTelemetry struct {
+	Timestamp         time.Time `json:"timestamp"`
+	Load1             float64   `json:"load1"` // one-minute load average
+	CPUs              int       `json:"cpus"`
+	MemoryUsedMB      int       `json:"memoryUsedMb"`
+	MemoryTotalMB     int       `json:"memoryTotalMb"`
+	DiskFreeMB        int       `json:"diskFreeMb"` // on the worker's work directory
+	DiskTotalMB       int       `json:"diskTotalMb"`
+	NucleiRSSMB       int       `json:"nucleiRssMb"`
+	Errors            int64     `json:"errors"` // failed requests of the current nuclei run
+	RequestsPerSecond float64   `json:"requestsPerSecond"`
+	Findings          int       `json:"findings"` // results written so far
+}
+
+// Worker lifecycle events, broadcast to a scan's live update clients as the
+// message type with the affected worker's WorkerStatus, without logs, as data
+const (
+	WorkerProvisioning = "worker_provisioning" // droplet requested
+	WorkerReady        = "worker_ready"        // droplet up with an IP, installing nuclei
+	WorkerProgress     = "worker_progress"     // heartbeat, or a stalled worker heard from again
+	WorkerStalled      = "worker_stalled"      // no heartbeat or log for 10 minutes
+	WorkerDegraded     = "worker_degraded"     // memory or disk crossed its threshold
+	WorkerFailed       = "worker_failed"       // droplet not created, or nuclei gave up
+	WorkerCompleted    = "worker_completed"    // whole chunk scanned
+	WorkerDestroyed    = "worker_destroyed"    // droplet deleted
+)
+
 // Log represents a log entry from a worker
 type Log struct {
 	Timestamp time.Time `json:"timestamp"`
 	Message   string    `json:"message"`
-	Type      string    `json:"type"` // info, scan, error, success
+	Type      string    `json:"type"` // info, scan, error, success, restart, skipped
 	WorkerID  string    `json:"workerId"`
 }
 
+// WorkerLogPage represents a paginated slice of a worker's logs
+type WorkerLogPage struct {
+	WorkerID string `json:"workerId"`
+	Logs     []Log  `json:"logs"`
+	Total    int    `json:"total"`
+	Offset   int    `json:"offset"`
+	Limit    int    `json:"limit"`
+}
+
 // ScanResult represents a nuclei scan result
 type ScanResult struct {
-	Host      string    `json:"host"`
-	Template  string    `json:"template"`
-	Severity  string    `json:"severity"`
-	Match     string    `json:"match"`
-	Timestamp time.Time `json:"timestamp"`
-	WorkerID  string    `json:"workerId"`
+	ID               string    `json:"id,omitempty"` // stable within a scan, see ResultID
+	Host             string    `json:"host"`
+	Template         string    `json:"template"`
+	Severity         string    `json:"severity"`
+	Match            string    `json:"match"`
+	Timestamp        time.Time `json:"timestamp"`
+	WorkerID         string    `json:"workerId"`
+	TemplateName     string    `json:"templateName,omitempty"`
+	Description      string    `json:"description,omitempty"`
+	Reference        []string  `json:"reference,omitempty"`
+	MatchedAt        string    `json:"matchedAt,omitempty"`
+	MatcherName      string    `json:"matcherName,omitempty"`
+	ExtractedResults []string  `json:"extractedResults,omitempty"`
+	CurlCommand      string    `json:"curlCommand,omitempty"`
+	Type             string    `json:"type,omitempty"` // protocol, e.g. http, dns, network
+	IP               string    `json:"ip,omitempty"`
+	Duplicate        bool      `json:"duplicate,omitempty"` // only set when duplicates are requested
+	Triage           *Triage   `json:"triage,omitempty"`    // unset while the finding is untriaged
+	// Seq orders a scan's stored findings; it is set on results read back
+	// from storage and never by workers
+	Seq int64 `json:"seq,omitempty"`
+	// OutOfScope marks findings on hosts outside the scan's targets
+	OutOfScope bool `json:"outOfScope,omitempty"`
+	// Ticket is the issue tracking the finding's remediation, if any
+	Ticket *Ticket `json:"ticket,omitempty"`
+}
+
+// Ticket links a finding to the Jira issue created for it
+type Ticket struct {
+	Key       string    `json:"key"` // e.g. SEC-123
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Triage statuses an analyst can give a finding
+const (
+	TriageOpen          = "open"
+	TriageFalsePositive = "false_positive"
+	TriageAccepted      = "accepted"
+	TriageConfirmed     = "confirmed"
+)
+
+// TriageStatuses lists every valid triage status
+var TriageStatuses = map[string]bool{
+	TriageOpen: true, TriageFalsePositive: true, TriageAccepted: true, TriageConfirmed: true,
+}
+
+// Triage is an analyst's verdict on a finding
+type Triage struct {
+	Status    string    `json:"status"`
+	Note      string    `json:"note,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// InheritedFrom is the scan a verdict was carried over from in a diff
+	InheritedFrom string `json:"inheritedFrom,omitempty"`
+	// Rule is the false-positive rule that set the verdict on ingest
+	Rule string `json:"rule,omitempty"`
+}
+
+// Host pattern syntaxes a false-positive rule can use
+const (
+	PatternGlob  = "glob"
+	PatternRegex = "regex"
+)
+
+// FPRule marks incoming findings of a template as false positives, optionally
+// only on hosts matching a pattern
+type FPRule struct {
+	ID          string     `json:"id"`
+	Template    string     `json:"template"`
+	HostPattern string     `json:"hostPattern,omitempty"` // empty matches every host
+	PatternType string     `json:"patternType,omitempty"` // glob (default) or regex
+	Note        string     `json:"note,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	Hits        int        `json:"hits"`
+	LastHitAt   *time.Time `json:"lastHitAt,omitempty"`
+}
+
+// TriageStatus returns the finding's triage status, open when untriaged
+func (r *ScanResult) TriageStatus() string {
+	if r.Triage == nil || r.Triage.Status == "" {
+		return TriageOpen
+	}
+	return r.Triage.Status
+}
+
+// nucleiResult is the subset of nuclei's JSONL output that maps onto ScanResult
+type nucleiResult struct {
+	TemplateID string `json:"template-id"`
+	Info       struct {
+		Name        string      `json:"name"`
+		Severity    string      `json:"severity"`
+		Description string      `json:"description"`
+		Reference   interface{} `json:"reference"`
+	} `json:"info"`
+	MatchedAt        string   `json:"matched-at"`
+	MatcherName      string   `json:"matcher-name"`
+	ExtractedResults []string `json:"extracted-results"`
+	CurlCommand      string   `json:"curl-command"`
+}
+
+// UnmarshalJSON accepts both the ScanResult format and raw nuclei JSONL
+// lines as posted by workers
+func (r *ScanResult) UnmarshalJSON(data []byte) error {
+	type plain ScanResult
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var raw nucleiResult
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+
+	// nuclei's own "template" field is the template path, prefer the ID
+	if raw.TemplateID != "" {
+		r.Template = raw.TemplateID
+	}
+	if r.Severity == "" {
+		r.Severity = raw.Info.Severity
+	}
+	if r.TemplateName == "" {
+		r.TemplateName = raw.Info.Name
+	}
+	if r.Description == "" {
+		r.Description = raw.Info.Description
+	}
+	if r.Reference == nil {
+		switch reference := raw.Info.Reference.(type) {
+		case string:
+			r.Reference = []string{reference}
+		case []interface{}:
+			for _, ref := range reference {
+				if s, ok := ref.(string); ok {
+					r.Reference = append(r.Reference, s)
+				}
+			}
+		}
+	}
+	if r.MatchedAt == "" {
+		r.MatchedAt = raw.MatchedAt
+	}
+	if r.MatcherName == "" {
+		r.MatcherName = raw.MatcherName
+	}
+	if r.ExtractedResults == nil {
+		r.ExtractedResults = raw.ExtractedResults
+	}
+	if r.CurlCommand == "" {
+		r.CurlCommand = raw.CurlCommand
+	}
+	if r.Match == "" {
+		r.Match = r.MatchedAt
+	}
+
+	return nil
+}
+
+// DedupKey identifies a finding independently of the worker that reported it
+func (r *ScanResult) DedupKey() string {
+	match := r.MatchedAt
+	if match == "" {
+		match = r.Match
+	}
+	return r.Host + "|" + r.Template + "|" + match
+}
+
+// ResultID derives a finding's ID from its dedup key, so the same finding
+// gets the same ID in every scan
+func (r *ScanResult) ResultID() string {
+	sum := sha256.Sum256([]byte(r.DedupKey()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ResultFilter selects a subset of a scan's results
+type ResultFilter struct {
+	Severities []string  // any of these severities, case-insensitive
+	Template   string    // exact template ID
+	Host       string    // case-insensitive host substring
+	AssetHost  string    // exact host, answered from the host index
+	Since      time.Time // results at or after this time
+	AfterSeq   int64     // results with a higher sequence number, duplicates excluded
+	Page       int       // 1-based page number
+	PageSize   int       // results per page, 0 for all
+
+	IncludeDuplicates     bool // also return findings dropped as duplicates
+	ExcludeFalsePositives bool // leave out findings triaged as false positives
+}
+
+// HostSummary is one affected asset of a scan with the findings on it
+type HostSummary struct {
+	Host            string         `json:"host"`
+	HighestSeverity string         `json:"highestSeverity"`
+	ResultCount     int            `json:"resultCount"`
+	SeverityCounts  map[string]int `json:"severityCounts"`
+	Templates       []string       `json:"templates"` // matched template IDs, sorted
+	FirstSeen       time.Time      `json:"firstSeen"`
+	LastSeen        time.Time      `json:"lastSeen"`
+}
+
+// ResultPage is one page of filtered scan results
+type ResultPage struct {
+	Results      []ScanResult `json:"results"`
+	Matched      int          `json:"matched"`      // results matching the filter
+	TotalResults int          `json:"totalResults"` // results in the scan
+	Page         int          `json:"page"`
+	PageSize     int          `json:"pageSize"`
+	// NextCursor is passed back as since to fetch only newer results
+	NextCursor string `json:"nextCursor"`
 }
 
 // ScanStatus represents the overall status of a scan
 type ScanStatus struct {
 	ID             string          `json:"id"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	CompletedAt    *time.Time      `json:"completedAt,omitempty"`
 	Progress       float64         `json:"progress"`
 	ActiveDroplets []*WorkerStatus `json:"activeDroplets"`
-	Results        []ScanResult    `json:"results"`
+	Results        []ScanResult    `json:"results"` // most recent findings only, page through /results for all
+	ResultCount    int             `json:"resultCount"`
+	SeverityCounts map[string]int  `json:"severityCounts"`
 	TotalDomains   int             `json:"totalDomains"`
+	DomainsAlive   int             `json:"domainsAlive"` // workload after dead hosts were probed away
 	ScannedDomains int             `json:"scannedDomains"`
 	Status         string          `json:"status"`
+	Plan           *ScanPlan       `json:"plan,omitempty"`
+	ExtraFlags     []string        `json:"extraFlags,omitempty"`
+	SecretKeys     []string        `json:"secretKeys,omitempty"` // names only, values never leave the secret store
+	ConfigYAML     string          `json:"configYaml,omitempty"` // sanitized nuclei config served to workers
+	ProblemHosts   []ProblemHost   `json:"problemHosts,omitempty"`
+	// DuplicatesDropped counts findings reported more than once, e.g. by two
+	// workers or after a worker re-sent its results file
+	DuplicatesDropped int        `json:"duplicatesDropped"`
+	Artifacts         []Artifact `json:"artifacts,omitempty"`
+	Pinned            bool       `json:"pinned,omitempty"` // kept past the retention window
+	// OutOfScopeResults counts stored findings on hosts outside the targets
+	OutOfScopeResults int `json:"outOfScopeResults,omitempty"`
+	// BlockedResults counts findings on blocklisted hosts, which are dropped
+	BlockedResults int `json:"blockedResults,omitempty"`
+	// EmailReport tracks the report emailed when the scan finishes
+	EmailReport *EmailReport `json:"emailReport,omitempty"`
+	// Revision increases with every change to the scan or its findings
+	// while the server tracks it
+	Revision int64  `json:"revision"`
+	RerunOf  string `json:"rerunOf,omitempty"` // scan this one runs again
+	// Pool tracks the shared targets of a scan with pull distribution
+	Pool *TargetPool `json:"pool,omitempty"`
+	// Rebalances lists the targets moved from slow workers to idle ones
+	Rebalances []Rebalance `json:"rebalances,omitempty"`
+	// Coverage counts the targets in each coverage state once the scan
+	// ended; see GET /scan/:id/coverage while it runs
+	Coverage *CoverageSummary `json:"coverage,omitempty"`
+	// Warnings are problems the scan runs on despite, such as workers left
+	// without a firewall
+	Warnings []string `json:"warnings,omitempty"`
+	// Held is set while the workers of a scan started with holdUntilRelease
+	// wait for its release; ReleasedAt is when it was released
+	Held       bool       `json:"held,omitempty"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+	// ActualCost is what the scan's droplets cost by their lifetimes, up to
+	// now while any is still billed
+	ActualCost *ActualCost `json:"actualCost,omitempty"`
+	// Degraded is set when the scan ended degraded or failed because too
+	// many workers failed or targets went unscanned; FailureSummary says why
+	Degraded       bool            `json:"degraded,omitempty"`
+	FailureSummary *FailureSummary `json:"failureSummary,omitempty"`
+	// Enumeration reports the subdomain enumeration of a scan started with
+	// expand, live while the scan is enumerating
+	Enumeration *EnumerationSummary `json:"enumeration,omitempty"`
+	// Preflight reports the check of the targets of a scan started with
+	// preflight, once it is done; a scan whose targets are all dead ends
+	// failed with them listed here
+	Preflight *PreflightSummary `json:"preflight,omitempty"`
+	TriageCounts
+}
+
+// ActualCost is what a scan's droplets cost from their creation, or claim
+// from the warm pool, until their destruction or return
+type ActualCost struct {
+	Total    float64 `json:"total"`
+	Currency string  `json:"currency"` // ISO 4217, e.g. USD
+	Running  int     `json:"running"`  // droplets still billed to the scan
+	// Final is set once the scan ended and none of its droplets is billed
+	// any longer
+	Final bool `json:"final"`
+	// LeakedCost is the part of Total from droplets that outlived the
+	// scan's cleanup, until they were reaped
+	LeakedCost  float64       `json:"leakedCost,omitempty"`
+	CleanedUpAt *time.Time    `json:"cleanedUpAt,omitempty"`
+	Droplets    []DropletCost `json:"droplets"`
+}
+
+// DropletCost is the time a droplet was billed to a scan and what it cost
+type DropletCost struct {
+	WorkerID    string  `json:"workerId"`
+	DropletID   string  `json:"dropletId"`
+	Size        string  `json:"size"`
+	PriceHourly float64 `json:"priceHourly"`
+	// Pooled droplets came from the warm pool and are billed from their
+	// claim until their worker finished, then go back to the pool
+	Pooled    bool       `json:"pooled,omitempty"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"` // unset while billed
+	// LeakedFrom is when the droplet should have been gone, for droplets
+	// that survived the scan's cleanup
+	LeakedFrom *time.Time `json:"leakedFrom,omitempty"`
+	Cost       float64    `json:"cost"`
+}
+
+// CostStats are what scans' droplets cost over a number of days, by day and
+// by scan
+type CostStats struct {
+	Currency string     `json:"currency"`
+	From     time.Time  `json:"from"` // start of the first day, UTC
+	To       time.Time  `json:"to"`
+	Total    float64    `json:"total"`
+	Days     []DayCost  `json:"days"`  // every day of the window, oldest first
+	Scans    []ScanCost `json:"scans"` // scans billed in the window, costliest first
+}
+
+// DayCost is what droplets cost on one UTC day
+type DayCost struct {
+	Date  string  `json:"date"` // YYYY-MM-DD
+	Cost  float64 `json:"cost"`
+	Scans int     `json:"scans"` // scans billed that day
+}
+
+// ScanCost is what one scan's droplets cost
+type ScanCost struct {
+	ScanID    string    `json:"scanId"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	Cost      float64   `json:"cost"`  // within the window
+	Total     float64   `json:"total"` // over the scan's lifetime
+	Running   int       `json:"running"`
+	Final     bool      `json:"final"`
+}
+
+// SourceIPs lists the addresses a scan's workers send traffic from, for
+// targets to allowlist
+type SourceIPs struct {
+	ScanID  string      `json:"scanId"`
+	IPs     []string    `json:"ips"` // every worker's IPv4 and IPv6 address, sorted
+	Workers []WorkerIPs `json:"workers"`
+	// Pending counts planned workers without addresses yet; the list is
+	// complete once it is zero
+	Pending int  `json:"pending"`
+	Held    bool `json:"held"` // workers send no traffic until the scan is released
+}
+
+// WorkerIPs are the public addresses of one worker
+type WorkerIPs struct {
+	ID     string `json:"id"`
+	IP     string `json:"ip"`
+	IPv6   string `json:"ipv6,omitempty"`
+	Status string `json:"status"`
+}
+
+// CoverageSummary counts a scan's targets by coverage state: pending,
+// assigned, completed, errored, skipped or dead. Gap counts those neither
+// scanned, skipped nor found dead before the scan.
+type CoverageSummary struct {
+	Counts map[string]int `json:"counts"`
+	Gap    int            `json:"gap"`
+}
+
+// UncoveredTarget is a target of a scan that was not scanned
+type UncoveredTarget struct {
+	Target   string `json:"target"`
+	State    string `json:"state"`
+	WorkerID string `json:"workerId,omitempty"` // empty while waiting in a pull scan's pool
+}
+
+// CoverageReport tells which of a scan's targets were scanned
+type CoverageReport struct {
+	ScanID string `json:"scanId"`
+	Status string `json:"status"`
+	Total  int    `json:"total"`
+	CoverageSummary
+	Uncovered []UncoveredTarget `json:"uncovered"`
+}
+
+// TargetPool counts the targets of a scan whose workers pull them in batches
+type TargetPool struct {
+	BatchSize int `json:"batchSize"`
+	Queued    int `json:"queued"`    // waiting to be leased
+	Leased    int `json:"leased"`    // in batches leased but not confirmed
+	Confirmed int `json:"confirmed"` // in batches workers confirmed as scanned
+	// Returned counts targets put back in the pool after their lease
+	// expired or their worker gave up
+	Returned int `json:"returned"`
+}
+
+// TargetBatch is a batch of targets leased to a pull worker. Without a
+// batch ID there is nothing to lease: the pool is drained, or waits for
+// batches leased by other workers to be confirmed or returned.
+type TargetBatch struct {
+	BatchID string   `json:"batchId,omitempty"`
+	Targets []string `json:"targets"`
+	Drained bool     `json:"drained"`
+}
+
+// Rebalance is a move of untouched targets from a slow worker to idle ones,
+// broadcast to the scan's live update clients as a TargetsRebalanced message
+type Rebalance struct {
+	From      string         `json:"from"`
+	To        map[string]int `json:"to"` // targets each idle worker took over
+	Targets   int            `json:"targets"`
+	Trigger   string         `json:"trigger"` // manual or automatic
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// TargetsRebalanced is the message type of a Rebalance
+const TargetsRebalanced = "targets_rebalanced"
+
+// RebalancePass is what a rebalance pass asked of a scan's workers. Slow
+// workers hand the targets back at their next checkpoint, when they finish
+// the segment they are scanning.
+type RebalancePass struct {
+	Trigger     string         `json:"trigger"`
+	IdleWorkers []string       `json:"idleWorkers"`
+	Requested   map[string]int `json:"requested"` // targets asked of each slow worker
+}
+
+// ScanRecord is the durable summary of a scan kept by the storage backend. It
+// outlives the in-memory scan status, e.g. across server restarts.
+type ScanRecord struct {
+	ID             string         `json:"id"`
+	Status         string         `json:"status"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	CompletedAt    *time.Time     `json:"completedAt,omitempty"`
+	TotalDomains   int            `json:"totalDomains"`
+	ScannedDomains int            `json:"scannedDomains"`
+	Workers        int            `json:"workers"`
+	ResultCount    int            `json:"resultCount"`
+	SeverityCounts map[string]int `json:"severityCounts"`
+	Targets        []string       `json:"targets,omitempty"` // omitted from scan listings
+	Artifacts      []Artifact     `json:"artifacts,omitempty"`
+	Pinned         bool           `json:"pinned,omitempty"`
+	// OutOfScopeResults counts stored findings on hosts outside the targets
+	OutOfScopeResults int          `json:"outOfScopeResults,omitempty"`
+	BlockedResults    int          `json:"blockedResults,omitempty"` // dropped findings on blocklisted hosts
+	EmailReport       *EmailReport `json:"emailReport,omitempty"`
+	RerunOf           string       `json:"rerunOf,omitempty"`
+	// Coverage counts the targets by coverage state once the scan ended,
+	// and Uncovered lists those that were not scanned; it is left out of
+	// scan listings. Both are nil for scans recorded before coverage was
+	// tracked.
+	Coverage  *CoverageSummary  `json:"coverage,omitempty"`
+	Uncovered []UncoveredTarget `json:"uncovered,omitempty"`
+	// Discovered maps the targets subdomain enumeration added to the apex
+	// they were found under; it is left out of scan listings
+	Discovered map[string]string `json:"discovered,omitempty"`
+	// Config is the request the scan ran with, without its targets. It is
+	// left out of scan listings and only served redacted, and is nil for
+	// scans recorded before configurations were stored.
+	Config *ScanRequest `json:"config,omitempty"`
+	// ActualCost is nil for scans recorded before costs were tracked
+	ActualCost *ActualCost `json:"actualCost,omitempty"`
+	// Log is what the server logged about the scan, left out of scan
+	// listings. It is nil for scans recorded before scan logs were kept.
+	Log            *ScanLog        `json:"log,omitempty"`
+	Degraded       bool            `json:"degraded,omitempty"`
+	FailureSummary *FailureSummary `json:"failureSummary,omitempty"`
+	TriageCounts
+}
+
+// ScanLog holds the server's log records about a scan and the errors its
+// workers logged, oldest first
+type ScanLog struct {
+	Entries []ScanLogEntry `json:"entries"`
+	// Dropped counts the oldest entries dropped to keep the log bounded
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// ScanLogEntry is one record of a scan's log
+type ScanLogEntry struct {
+	Time     time.Time         `json:"time"`
+	Level    string            `json:"level"`
+	Source   string            `json:"source"` // orchestrator or worker
+	WorkerID string            `json:"workerId,omitempty"`
+	Message  string            `json:"msg"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+// EmailReport is the delivery state of a scan's completion email
+type EmailReport struct {
+	Recipients []string   `json:"recipients"`
+	Status     string     `json:"status"` // pending, sent or failed
+	Attempts   int        `json:"attempts"`
+	Attached   bool       `json:"attached"` // CSV attached rather than linked
+	SentAt     *time.Time `json:"sentAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// TriageCounts tallies triaged findings. Untriaged findings are not counted.
+type TriageCounts struct {
+	Triaged map[string]int `json:"triageCounts,omitempty"` // by triage status
+	// FalsePositives counts false positives by severity, so severity counts
+	// can be reported without them
+	FalsePositives map[string]int `json:"falsePositiveCounts,omitempty"`
+}
+
+// Dependency health statuses
+const (
+	DependencyOK      = "ok"
+	DependencyFailing = "failing"
+	DependencyUnknown = "unknown" // not checked yet
+)
+
+// DependencyHealth is the outcome of the last check of a service the server
+// depends on
+type DependencyHealth struct {
+	Status    string     `json:"status"`
+	Critical  bool       `json:"critical"` // the server cannot work while it fails
+	LatencyMs int64      `json:"latencyMs"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// RateLimit is the API request budget, for dependencies that report one
+	RateLimit *RateLimitState `json:"rateLimit,omitempty"`
+}
+
+// RateLimitState is how much of an API's request budget is left, and how
+// often requests were held back or retried to stay within it
+type RateLimitState struct {
+	Limit     int        `json:"limit"`
+	Remaining int        `json:"remaining"`
+	Reset     *time.Time `json:"reset,omitempty"`
+	Throttled int64      `json:"throttled"` // requests held back while the budget was low or after a 429
+	Retried   int64      `json:"retried"`   // requests sent again after a 429 or server error
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// HealthReport is the server's health: healthy unless a critical dependency
+// is failing
+type HealthReport struct {
+	Status       string                      `json:"status"`
+	Ready        bool                        `json:"ready"` // state was restored from storage
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+	OpenSearch   *ForwarderHealth            `json:"opensearch,omitempty"`
+	Retention    *RetentionHealth            `json:"retention,omitempty"`
+	SelfCheck    *SelfCheck                  `json:"selfCheck,omitempty"` // the last self-check
+	Replica      *ReplicaHealth              `json:"replica,omitempty"`   // when replicas share the server's Redis
+}
+
+// ReplicaHealth names the replica that answered and whether it leads, i.e.
+// runs the retention janitor and the warm pool
+type ReplicaHealth struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Leader bool   `json:"leader"`
+}
+
+// Self-check statuses
+const (
+	CheckPassed  = "passed"
+	CheckWarning = "warning" // something is off, but scans can still work
+	CheckFailed  = "failed"  // scans cannot work until it is fixed
+)
+
+// SelfCheck is the outcome of the server's check of its credentials and
+// settings, made at startup and on request
+type SelfCheck struct {
+	Status    string        `json:"status"`   // the worst status of its checks
+	ReadOnly  bool          `json:"readOnly"` // new scans are refused until a check passes
+	CheckedAt time.Time     `json:"checkedAt"`
+	Checks    []CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of one of a self-check's checks
+type CheckResult struct {
+	Name    string `json:"name"` // redis, provider_credentials, droplet_slugs or callback_url
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// RetentionHealth reports what the retention janitor has purged
+type RetentionHealth struct {
+	Retention   string     `json:"retention"`
+	LastRun     *time.Time `json:"lastRun,omitempty"`
+	ScansPurged int        `json:"scansPurged"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// ScanStats are a scan's finding counts, optionally without false positives
+type ScanStats struct {
+	ResultCount           int            `json:"resultCount"`
+	SeverityCounts        map[string]int `json:"severityCounts"`
+	TriageCounts          map[string]int `json:"triageCounts"`
+	ExcludeFalsePositives bool           `json:"excludeFalsePositives"`
+}
+
+// Artifact is a file archived to object storage when a scan completes
+type Artifact struct {
+	Name       string     `json:"name"` // results.jsonl, results.csv or report.html
+	Key        string     `json:"key"`  // object key, prefixed with the scan ID
+	URL        string     `json:"url,omitempty"`
+	Status     string     `json:"status"` // pending, uploaded or failed
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	UploadedAt *time.Time `json:"uploadedAt,omitempty"`
+}
+
+// ArtifactLink is a presigned download link for an uploaded artifact
+type ArtifactLink struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ProblemHost is a target nuclei stopped scanning after hitting its host error limit
+type ProblemHost struct {
+	Host      string    `json:"host"`
+	WorkerID  string    `json:"workerId"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ScanPlan describes the droplets a scan runs on and its projected duration and cost
+type ScanPlan struct {
+	Droplets         int     `json:"droplets"`
+	DropletSize      string  `json:"dropletSize"`
+	Region           string  `json:"region"`
+	EstimatedMinutes float64 `json:"estimatedMinutes"`
+	// EstimatedCost is in Currency, zero when the size's price is unknown
+	EstimatedCost float64 `json:"estimatedCost"`
+	// PriceHourly is the hourly price of a droplet of the size on the
+	// compute provider
+	PriceHourly float64  `json:"priceHourly,omitempty"`
+	Currency    string   `json:"currency,omitempty"` // ISO 4217, e.g. USD
+	Warnings    []string `json:"warnings,omitempty"`
+	// ChunkSizes is the number of targets each droplet starts with
+	ChunkSizes []int `json:"chunkSizes,omitempty"`
+	// Distribution is static or pull, see ScanRequest.Distribution
+	Distribution string `json:"distribution"`
+	// Limits are the optimizer limits the plan was made with
+	Limits *OptimizerLimits `json:"limits,omitempty"`
+	// Capacity is the capacity table entry of the droplet size, nil when
+	// the size has none and the global defaults applied
+	Capacity *DropletCapacity `json:"capacity,omitempty"`
+	// DomainsPerHour is the per-droplet throughput the estimate assumed,
+	// taken from ThroughputSource: history, capacity or default
+	DomainsPerHour   float64 `json:"domainsPerHour"`
+	ThroughputSource string  `json:"throughputSource"`
+	// TemplateSet names the templates the scan runs, which past scans'
+	// throughput is matched on
+	TemplateSet string `json:"templateSet"`
+	// HistorySamples is the number of past scans the throughput is the
+	// median of, when taken from history
+	HistorySamples int `json:"historySamples,omitempty"`
+	// Regions counts the droplets planned in each region when region
+	// limits are configured; Region is the one asked for
+	Regions map[string]int `json:"regions,omitempty"`
+	// Mode is the planning mode the droplet count was chosen with, and
+	// Alternatives what the other modes would have planned
+	Mode         string            `json:"mode"`
+	Alternatives []PlanAlternative `json:"alternatives,omitempty"`
+	// Targets reports how the submitted targets were normalized
+	Targets *TargetSummary `json:"targets,omitempty"`
+}
+
+// RegionLimit caps the worker droplets running in a region
+type RegionLimit struct {
+	Region      string `json:"region"`
+	MaxDroplets int    `json:"maxDroplets"`
+}
+
+// PlanAlternative is what a scan would run on in another planning mode
+type PlanAlternative struct {
+	Mode             string  `json:"mode"`
+	Droplets         int     `json:"droplets"`
+	EstimatedMinutes float64 `json:"estimatedMinutes"`
+	EstimatedCost    float64 `json:"estimatedCost"`
+}
+
+// TargetSummary reports how a scan's submitted targets were normalized
+type TargetSummary struct {
+	Submitted     int              `json:"submitted"`
+	Accepted      int              `json:"accepted"`
+	Duplicates    int              `json:"duplicates"` // equivalent after normalization
+	Shuffled      bool             `json:"shuffled"`   // spread across workers in an order seeded by the scan ID
+	Expanded      int              `json:"expanded"`   // addresses CIDR blocks and IP ranges expanded to
+	RejectedCount int              `json:"rejectedCount"`
+	Rejected      []RejectedTarget `json:"rejected,omitempty"` // the first 100
+	BlockedCount  int              `json:"blockedCount"`
+	Blocked       []BlockedTarget  `json:"blocked,omitempty"` // the first 100
+	// Preflight reports the targets found dead before the scan, for scans
+	// started with preflight
+	Preflight *PreflightSummary `json:"preflight,omitempty"`
+	// Enumeration reports the subdomains added to the targets, for scans
+	// started with expand. The counts above include them.
+	Enumeration *EnumerationSummary `json:"enumeration,omitempty"`
+}
+
+// EnumerationSummary reports the subdomain enumeration of a scan's apex
+// domains
+type EnumerationSummary struct {
+	Apexes    []string `json:"apexes"`
+	Completed int      `json:"completed"` // apexes enumerated so far
+	Found     int      `json:"found"`     // distinct new subdomains found
+	// Added counts the subdomains added to the targets, short of those
+	// found when they were blocked or over the cap
+	Added      int      `json:"added"`
+	Capped     bool     `json:"capped,omitempty"` // subdomains were left out over the cap
+	TimedOut   []string `json:"timedOut,omitempty"`
+	Failed     []string `json:"failed,omitempty"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+// PreflightSummary reports how a scan's targets were checked before any
+// droplet was created. Dead targets are left out of the scan and recorded
+// in its coverage as dead.
+type PreflightSummary struct {
+	Checked    int          `json:"checked"`
+	Probe      string       `json:"probe"`             // none, tcp or http
+	Skipped    string       `json:"skipped,omitempty"` // why the targets were not checked
+	DurationMs int64        `json:"durationMs"`
+	DeadCount  int          `json:"deadCount"`
+	Dead       []DeadTarget `json:"dead,omitempty"` // the first 100
+}
+
+// DeadTarget is a target preflight found dead
+type DeadTarget struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// RejectedTarget is a submitted target that cannot be scanned
+type RejectedTarget struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// BlockedTarget is a submitted target the blocklist removed from a scan
+type BlockedTarget struct {
+	Target  string `json:"target"`
+	Pattern string `json:"pattern"` // the blocklist entry it matched
+}
+
+// Blocklist entry sources
+const (
+	BlocklistSourceConfig = "config" // set with BLOCKLIST, read-only at runtime
+	BlocklistSourceAPI    = "api"
+)
+
+// BlocklistEntry is a target that is never scanned: a CIDR block or IP, an
+// exact domain, or a suffix pattern such as *.gov, which also covers gov
+// itself
+type BlocklistEntry struct {
+	Pattern   string    `json:"pattern" binding:"required"`
+	Note      string    `json:"note,omitempty"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BlocklistRequest adds entries to the blocklist
+type BlocklistRequest struct {
+	Entries []BlocklistEntry `json:"entries" binding:"required,min=1,dive"`
+}
+
+// ServerConfig holds the server settings that can change while it runs. It
+// is read from the environment, then CONFIG_FILE, then the changes made
+// through PATCH /admin/config. Durations are strings such as 15m; an empty
+// retention keeps scans forever.
+type ServerConfig struct {
+	Nuclei             NucleiDefaults       `json:"nuclei"`
+	Optimizer          OptimizerLimits      `json:"optimizer"`
+	RebalanceThreshold float64              `json:"rebalanceThreshold"` // percent progress, 0 rebalances on request only
+	MaxInvalidTargets  float64              `json:"maxInvalidTargets"`  // fraction of a scan's targets
+	Retention          string               `json:"retention"`
+	AuditRetention     string               `json:"auditRetention"`
+	Blocklist          []string             `json:"blocklist"`
+	Notifications      NotificationSettings `json:"notifications"`
+	Alerts             AlertSettings        `json:"alerts"`
+	FailurePolicy      FailurePolicy        `json:"failurePolicy"`
+	Preflight          PreflightSettings    `json:"preflight"`
+	Enumeration        EnumerationSettings  `json:"enumeration"`
+}
+
+// NucleiDefaults fill in the nuclei options a scan request leaves unset
+type NucleiDefaults struct {
+	Timeout       int `json:"timeout"`
+	Retries       int `json:"retries"`
+	MaxHostErrors int `json:"maxHostErrors"`
+}
+
+// NotificationSettings are the notifiers scans without their own use, and
+// alerts go to
+type NotificationSettings struct {
+	Webhooks  []WebhookConfig `json:"webhooks"`
+	Slack     SlackConfig     `json:"slack"`
+	Discord   DiscordConfig   `json:"discord"`
+	Emails    []string        `json:"emails"`    // completion report recipients
+	PublicURL string          `json:"publicUrl"` // base URL notifications link to
+}
+
+// AlertSettings decide when operators are alerted about failures of the
+// server
+type AlertSettings struct {
+	Sinks               []string `json:"sinks"` // webhook, slack, discord; empty for all
+	Cooldown            string   `json:"cooldown"`
+	CreateFailures      int      `json:"createFailures"`
+	AuthFailures        int      `json:"authFailures"`
+	AuthWindow          string   `json:"authWindow"`
+	ProvisioningTimeout string   `json:"provisioningTimeout"`
+}
+
+// FailurePolicy decides when a scan that lost workers or targets ends
+// degraded or failed rather than completed. Each limit is the percentage of
+// the scan's workers that failed, or of its targets left unscanned, that
+// must be exceeded; 0 disables the limit.
+type FailurePolicy struct {
+	DegradedWorkers float64 `json:"degradedWorkers"`
+	FailedWorkers   float64 `json:"failedWorkers"`
+	DegradedTargets float64 `json:"degradedTargets"`
+	FailedTargets   float64 `json:"failedTargets"`
+}
+
+// PreflightSettings tune the check of the targets of scans started with
+// preflight
+type PreflightSettings struct {
+	Concurrency    int    `json:"concurrency"`    // targets checked at once
+	ResolveTimeout string `json:"resolveTimeout"` // per DNS lookup
+	Probe          string `json:"probe"`          // none, tcp or http
+	ProbeTimeout   string `json:"probeTimeout"`   // per connection or request
+	SkipIPOnly     bool   `json:"skipIpOnly"`     // scans of addresses alone are not checked
+}
+
+// EnumerationSettings bound the subdomain enumeration of scans started with
+// expand
+type EnumerationSettings struct {
+	MaxTargets  int    `json:"maxTargets"`  // subdomains added to a scan at most
+	ApexTimeout string `json:"apexTimeout"` // per apex domain
+	Concurrency int    `json:"concurrency"` // apexes enumerated at once
+}
+
+// AuditEntry records a change made through the API, the API key that made
+// it and where it came from
+type AuditEntry struct {
+	Time     time.Time      `json:"time"`
+	KeyID    string         `json:"keyId"`
+	SourceIP string         `json:"sourceIp"`
+	Action   string         `json:"action"`  // operation, e.g. startScan
+	Request  string         `json:"request"` // method and path
+	Status   int            `json:"status"`
+	ScanID   string         `json:"scanId,omitempty"`
+	Summary  string         `json:"summary,omitempty"`
+	Changes  []ConfigChange `json:"changes,omitempty"`
+}
+
+// AuditFilter selects audit log entries; zero fields match every entry
+type AuditFilter struct {
+	Action string
+	KeyID  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ConfigChange is one setting of ServerConfig before and after a change,
+// with secrets redacted
+type ConfigChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
 }
 
 // DropletConfig represents configuration for creating droplets
@@ -63,4 +1195,142 @@ type DropletConfig struct {
 type WebSocketMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	// Seq numbers a scan's broadcasts from 1. A status_update sent on connect
+	// carries the last broadcast it covers; replies such as pong carry 0.
+	Seq uint64 `json:"seq"`
+}
+
+// ResultsBatch is the data of a results_batch message: the findings stored
+// since the previous batch, and the scan's totals after them
+type ResultsBatch struct {
+	Results        []ScanResult   `json:"results"`
+	ResultCount    int            `json:"resultCount"`
+	SeverityCounts map[string]int `json:"severityCounts"`
+}
+
+// ScanEvent is a scan's state in the dashboard feed of every scan, counts
+// only and no findings
+type ScanEvent struct {
+	ScanID         string         `json:"scanId"`
+	Status         string         `json:"status"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	CompletedAt    *time.Time     `json:"completedAt,omitempty"`
+	Progress       float64        `json:"progress"`
+	TotalDomains   int            `json:"totalDomains"`
+	ScannedDomains int            `json:"scannedDomains"`
+	Workers        int            `json:"workers"`
+	ResultCount    int            `json:"resultCount"`
+	SeverityCounts map[string]int `json:"severityCounts"`
+	Degraded       bool           `json:"degraded,omitempty"` // the scan ended degraded or failed
+	Failure        *WorkerFailure `json:"failure,omitempty"`  // worker_failed only
+}
+
+// WebSocketSubscription is the data of a subscribe message, choosing what a
+// WebSocket client is sent
+type WebSocketSubscription struct {
+	Events      []string `json:"events,omitempty"`      // message types sent; empty sends all
+	MinSeverity string   `json:"minSeverity,omitempty"` // lowest severity of findings in results_batch messages
+}
+
+// ScanDiff compares the findings of two scans, matched on host, template and
+// matched-at
+type ScanDiff struct {
+	Base       string       `json:"base"`
+	Head       string       `json:"head"`
+	New        []ScanResult `json:"new"`        // only in head
+	Persisting []ScanResult `json:"persisting"` // in both, as reported by head
+	Fixed      []ScanResult `json:"fixed"`      // only in base, for hosts head scanned again
+	// NotRescanned holds base findings for hosts head did not target, so
+	// their absence says nothing about whether they were fixed
+	NotRescanned    []ScanResult `json:"notRescanned"`
+	HostsOnlyInBase []string     `json:"hostsOnlyInBase"`
+	HostsOnlyInHead []string     `json:"hostsOnlyInHead"`
+}
+
+// WorkerDroplet is a droplet tagged as a worker, as its provider reports it,
+// whether or not the server still tracks its scan
+type WorkerDroplet struct {
+	ID          string    `json:"id"`   // the provider's ID
+	Name        string    `json:"name"` // the worker ID
+	ScanID      string    `json:"scanId,omitempty"`
+	ScanKnown   bool      `json:"scanKnown"` // the scan is tracked by this server
+	Region      string    `json:"region"`
+	Size        string    `json:"size"`
+	Status      string    `json:"status"`
+	IP          string    `json:"ip,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	AgeSeconds  int64     `json:"ageSeconds"`
+	PriceHourly float64   `json:"priceHourly"`
+	// Pooled is set for warm pool workers, which outlive scans; ScanID is
+	// the scan one works for, if any
+	Pooled bool `json:"pooled,omitempty"`
+	// Project is the provider project the droplet is assigned to. Unassigned
+	// is set when workers should be in a project and this one is not.
+	Project    string `json:"project,omitempty"`
+	Unassigned bool   `json:"unassigned,omitempty"`
+}
+
+// AddTargetsRequest adds targets to a running scan
+type AddTargetsRequest struct {
+	Domains []string `json:"domains" binding:"required,min=1"`
+}
+
+// TargetsAdded reports what became of targets added to a running scan
+type TargetsAdded struct {
+	Added   int            `json:"added"`   // new targets, after validation and deduplication
+	Targets *TargetSummary `json:"targets"` // validation of the submitted targets
+	// AlreadyTargeted counts valid targets the scan already had
+	AlreadyTargeted int `json:"alreadyTargeted"`
+	// Assigned counts the added targets queued for each running worker,
+	// which pulls them once its current targets are done
+	Assigned  map[string]int `json:"assigned,omitempty"`
+	NewWorker string         `json:"newWorker,omitempty"` // worker provisioned for the added targets
+}
+
+// DebugState counts what the server holds in memory, for triage without a
+// full profile
+type DebugState struct {
+	Goroutines       int    `json:"goroutines"`
+	HeapAllocBytes   uint64 `json:"heapAllocBytes"`
+	HeapObjects      uint64 `json:"heapObjects"`
+	ActiveScans      int    `json:"activeScans"`
+	RunningScans     int    `json:"runningScans"`
+	WorkerTokens     int    `json:"workerTokens"`
+	TicketQueue      int    `json:"ticketQueue"`  // findings waiting to be filed in Jira
+	ForwardQueue     int    `json:"forwardQueue"` // findings waiting to be sent to OpenSearch
+	WebSocketClients int    `json:"webSocketClients"`
+	// Live updates lost since startup: broadcasts dropped because a scan's
+	// queue was full, messages dropped for WebSocket and event stream
+	// clients that fell behind, clients disconnected for staying behind and
+	// clients refused because their scan had as many as it may
+	BroadcastsDropped     int64 `json:"broadcastsDropped"`
+	ClientMessagesDropped int64 `json:"clientMessagesDropped"`
+	SlowClients           int64 `json:"slowClients"`
+	ClientsRefused        int64 `json:"clientsRefused"`
+	// ProviderRateLimit is the compute provider's API request budget, for
+	// providers that pace their requests
+	ProviderRateLimit *RateLimitState `json:"providerRateLimit,omitempty"`
+	// Scans lists every scan held in memory, the largest dedup index first
+	Scans []ScanDebugState `json:"scans"`
+}
+
+// ScanDebugState counts what the server holds in memory for one scan
+type ScanDebugState struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Workers int    `json:"workers"`
+	// DegradedWorkers counts workers short of memory or disk
+	DegradedWorkers int `json:"degradedWorkers"`
+	// Goroutines counts those started for the scan: one per notifier, one
+	// per worker droplet still coming up and, while clients are connected,
+	// the WebSocket broadcaster
+	Goroutines       int `json:"goroutines"`
+	RecentResults    int `json:"recentResults"` // findings held in the status
+	ResultKeys       int `json:"resultKeys"`    // entries in the dedup index
+	WorkerLogs       int `json:"workerLogs"`    // log lines not yet spilled to Redis
+	ProblemHosts     int `json:"problemHosts"`
+	PendingTargets   int `json:"pendingTargets"` // added targets not yet pulled
+	WebSocketClients int `json:"webSocketClients"`
+	BroadcastQueue   int `json:"broadcastQueue"` // messages waiting for WebSocket clients
+	BatchedResults   int `json:"batchedResults"` // findings waiting for the next results batch
 }
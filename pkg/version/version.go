@@ -0,0 +1,15 @@
+// Package version identifies the running build. Version and Commit are set
+// at build time:
+//
+//	go build -ldflags "-X nuclei-distributed/pkg/version.Version=1.2.0 -X nuclei-distributed/pkg/version.Commit=$(git rev-parse --short HEAD)"
+package version
+
+// APIVersion is the current version of the HTTP API, served under /api/v1
+const APIVersion = "v1"
+
+var (
+	// Version is the server's release, "dev" for local builds
+	Version = "dev"
+	// Commit is the git commit the server was built from
+	Commit = "unknown"
+)
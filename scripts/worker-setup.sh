@@ -9,11 +9,14 @@ set -e
 # - SCAN_ID: Unique identifier for this scan
 # - WORKER_ID: Unique identifier for this worker
 # - MAIN_SERVER: IP/hostname of main server
+# - SERVER_URL: base URL of main server, http://$MAIN_SERVER:8080 when unset
+# - CURL_TLS: extra curl options, e.g. to pin a self-signed certificate
 # - DOMAINS_B64: Base64 encoded list of domains to scan
 
 LOG_FILE="/var/log/nuclei-worker.log"
 RESULTS_FILE="/root/results.json"
 DOMAINS_FILE="/root/domains.txt"
+SERVER_URL="${SERVER_URL:-http://$MAIN_SERVER:8080}"
 
 # Function to log messages
 log() {
@@ -26,26 +29,26 @@ send_heartbeat() {
     local current_domain=$2
     local message=$3
     
-    curl -s -X POST \
+    curl $CURL_TLS -s -X POST \
         -H "Content-Type: application/json" \
         -d "{\"progress\": $progress, \"current_domain\": \"$current_domain\", \"message\": \"$message\"}" \
-        "http://$MAIN_SERVER:8080/api/heartbeat/$SCAN_ID/$WORKER_ID" || true
+        "$SERVER_URL/api/v1/heartbeat/$SCAN_ID/$WORKER_ID" || true
 }
 
 # Function to send results to main server
 send_result() {
     local result_line="$1"
     
-    curl -s -X POST \
+    curl $CURL_TLS -s -X POST \
         -H "Content-Type: application/json" \
         -d "$result_line" \
-        "http://$MAIN_SERVER:8080/api/results/$SCAN_ID/$WORKER_ID" || true
+        "$SERVER_URL/api/v1/results/$SCAN_ID/$WORKER_ID" || true
 }
 
 # Function to notify completion
 notify_completion() {
-    curl -s -X POST \
-        "http://$MAIN_SERVER:8080/api/complete/$SCAN_ID/$WORKER_ID" || true
+    curl $CURL_TLS -s -X POST \
+        "$SERVER_URL/api/v1/complete/$SCAN_ID/$WORKER_ID" || true
 }
 
 # Main execution
@@ -25,6 +25,7 @@ interface ScanStatus {
   progress: number;
   activeDroplets: WorkerStatus[];
   results: ScanResult[];
+  resultCount: number;
   totalDomains: number;
   scannedDomains: number;
   status: string;
@@ -37,47 +38,94 @@ const App: React.FC = () => {
   const [scanId, setScanId] = useState<string>('');
   const [scanStatus, setScanStatus] = useState<ScanStatus | null>(null);
   const [ws, setWs] = useState<WebSocket | null>(null);
+  const [apiKey, setApiKey] = useState<string>(localStorage.getItem('apiKey') || '');
+
+  const updateApiKey = (key: string) => {
+    setApiKey(key);
+    localStorage.setItem('apiKey', key);
+  };
 
   // WebSocket connection
   useEffect(() => {
     if (scanId && scanning) {
-      const websocket = new WebSocket(`ws://${window.location.host}/ws/${scanId}`);
-      
-      websocket.onopen = () => {
-        console.log('WebSocket connected');
-      };
+      // Browsers cannot set headers on the upgrade, so a single-use ticket
+      // goes in the query instead of the key
+      let websocket: WebSocket | null = null;
+      let cancelled = false;
 
-      websocket.onmessage = (event) => {
-        const message = JSON.parse(event.data);
+      fetch('/api/v1/ws-ticket', {
+        method: 'POST',
+        headers: { 'Authorization': `Bearer ${apiKey}` },
+      })
+        .then(response => response.json())
+        .then(({ ticket }) => {
+          if (cancelled) {
+            return;
+          }
+          const socket = new WebSocket(`ws://${window.location.host}/ws/${scanId}?ticket=${encodeURIComponent(ticket)}`);
+          websocket = socket;
+
+          socket.onopen = () => {
+            console.log('WebSocket connected');
+          };
+
+          socket.onmessage = (event) => {
+            const message = JSON.parse(event.data);
         
-        switch (message.type) {
-          case 'status_update':
-            setScanStatus(message.data);
-            break;
-          case 'new_result':
-            setScanStatus(prev => prev ? {
-              ...prev,
-              results: [...prev.results, message.data]
-            } : null);
-            break;
-          case 'scan_complete':
-            setScanStatus(message.data);
-            setScanning(false);
-            break;
-        }
-      };
+            switch (message.type) {
+              case 'status_update':
+                setScanStatus(message.data);
+                break;
+              case 'results_batch':
+                setScanStatus(prev => prev ? {
+                  ...prev,
+                  results: [...prev.results, ...message.data.results].slice(-100),
+                  resultCount: message.data.resultCount
+                } : null);
+                break;
+              case 'scan_complete':
+                setScanStatus(message.data);
+                setScanning(false);
+                break;
+              case 'worker_provisioning':
+              case 'worker_ready':
+              case 'worker_progress':
+              case 'worker_stalled':
+              case 'worker_failed':
+              case 'worker_completed':
+              case 'worker_destroyed': {
+                // Each event carries just the worker that changed
+                const worker: WorkerStatus = message.data;
+                setScanStatus(prev => {
+                  if (!prev) {
+                    return null;
+                  }
+                  const known = prev.activeDroplets.some(w => w.id === worker.id);
+                  return {
+                    ...prev,
+                    activeDroplets: known
+                      ? prev.activeDroplets.map(w => (w.id === worker.id ? worker : w))
+                      : [...prev.activeDroplets, worker],
+                  };
+                });
+                break;
+              }
+            }
+          };
 
-      websocket.onclose = () => {
-        console.log('WebSocket disconnected');
-      };
+          socket.onclose = () => {
+            console.log('WebSocket disconnected');
+          };
 
-      setWs(websocket);
+          setWs(socket);
+        });
 
       return () => {
-        websocket.close();
+        cancelled = true;
+        websocket?.close();
       };
     }
-  }, [scanId, scanning]);
+  }, [scanId, scanning, apiKey]);
 
   const startScan = async () => {
     const domainList = domains.split('\n').filter(d => d.trim());
@@ -88,10 +136,11 @@ const App: React.FC = () => {
     }
 
     try {
-      const response = await fetch('/api/scan', {
+      const response = await fetch('/api/v1/scan', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
+          'Authorization': `Bearer ${apiKey}`,
         },
         body: JSON.stringify({
           domains: domainList,
@@ -106,7 +155,7 @@ const App: React.FC = () => {
         setScanning(true);
         setScanStatus(null);
       } else {
-        alert(`Error: ${data.error}`);
+        alert(`Error: ${data.message}`);
       }
     } catch (error) {
       alert(`Network error: ${error}`);
@@ -122,26 +171,26 @@ const App: React.FC = () => {
     setScanStatus(null);
   };
 
-  const exportResults = () => {
-    if (!scanStatus || scanStatus.results.length === 0) {
+  const exportResults = async () => {
+    if (!scanStatus || scanStatus.resultCount === 0) {
       alert('No results to export');
       return;
     }
 
-    const csv = [
-      'Host,Template,Severity,Match,Timestamp,Worker ID',
-      ...scanStatus.results.map(r => 
-        `"${r.host}","${r.template}","${r.severity}","${r.match}","${r.timestamp}","${r.workerId}"`
-      )
-    ].join('\n');
-
-    const blob = new Blob([csv], { type: 'text/csv' });
-    const url = window.URL.createObjectURL(blob);
+    // The status only carries the latest results, the server exports them all.
+    // Fetched rather than linked so the API key can be sent.
+    const response = await fetch(`/api/v1/scan/${scanId}/results?format=csv`, {
+      headers: { 'Authorization': `Bearer ${apiKey}` },
+    });
+    if (!response.ok) {
+      alert(`Export failed: ${response.status}`);
+      return;
+    }
     const a = document.createElement('a');
-    a.href = url;
+    a.href = URL.createObjectURL(await response.blob());
     a.download = `nuclei-scan-${scanId}.csv`;
     a.click();
-    window.URL.revokeObjectURL(url);
+    URL.revokeObjectURL(a.href);
   };
 
   const getSeverityClass = (severity: string) => {
@@ -166,6 +215,17 @@ const App: React.FC = () => {
           <div className="card">
             <h3>Scan Configuration</h3>
             
+            <div className="input-group">
+              <label>API Key</label>
+              <input
+                type="password"
+                value={apiKey}
+                onChange={(e) => updateApiKey(e.target.value)}
+                placeholder="Key from API_KEYS"
+                disabled={scanning}
+              />
+            </div>
+
             <div className="input-group">
               <label>Target Domains</label>
               <textarea
@@ -204,7 +264,7 @@ const App: React.FC = () => {
                   </button>
                 )}
                 
-                {scanStatus && scanStatus.results.length > 0 && (
+                {scanStatus && scanStatus.resultCount > 0 && (
                   <button className="scan-button secondary" onClick={exportResults}>
                     📥 Export Results
                   </button>
@@ -259,7 +319,7 @@ const App: React.FC = () => {
               </div>
 
               <div className="results-section">
-                <h4>Live Results ({scanStatus.results.length})</h4>
+                <h4>Live Results ({scanStatus.resultCount})</h4>
                 <div className="results-container">
                   {scanStatus.results.length === 0 ? (
                     <div className="no-results">No vulnerabilities found yet...</div>
@@ -293,7 +353,7 @@ const App: React.FC = () => {
                           ))}
                         </tbody>
                       </table>
-                      {scanStatus.results.length > 20 && (
+                      {scanStatus.resultCount > 20 && (
                         <div className="table-footer">
                           Showing latest 20 results. Export for full results.
                         </div>